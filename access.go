@@ -0,0 +1,278 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// Operations reported in AccessEvent
+const (
+	AccessOperationDownload       = "download"        // Direct download through StreamFile or DownloadHandler
+	AccessOperationSignedDownload = "signed_download" // Download with a signed token
+)
+
+// DefaultAccessFlushInterval is how often FileAccessRecorder writes its state file
+const DefaultAccessFlushInterval = 5 * time.Second
+
+// AccessEvent describes a finished download
+type AccessEvent struct {
+	Path      string    `json:"path"`
+	Operation string    `json:"operation"`
+	Bytes     int64     `json:"bytes"`     // Body bytes actually written, lower than the size for aborted downloads
+	Status    int       `json:"status"`    // HTTP status of the response
+	Completed bool      `json:"completed"` // False when the body was cut short, e.g. by the client disconnecting
+	RemoteIP  string    `json:"remote_ip,omitempty"`
+	Subject   string    `json:"subject,omitempty"` // "sub" claim of the signed token, if any
+	Time      time.Time `json:"time"`
+}
+
+// AccessRecorder receives an event after each download response completes
+type AccessRecorder interface {
+	RecordAccess(ctx context.Context, event *AccessEvent) error
+}
+
+// AccessStatsProvider is implemented by recorders that keep per-path counters
+type AccessStatsProvider interface {
+	AccessStats(ctx context.Context, path string) (*AccessStats, error)
+}
+
+// AccessStats summarizes the downloads of a file
+type AccessStats struct {
+	Path       string     `json:"path"`
+	Count      int64      `json:"count"`   // Successful (2xx) downloads, including partial ones
+	Aborted    int64      `json:"aborted"` // Downloads in Count whose body was cut short
+	Bytes      int64      `json:"bytes"`   // Body bytes sent across all downloads
+	LastAccess *time.Time `json:"last_access,omitempty"`
+}
+
+// GetAccessStats returns the download counters of a file. The configured AccessRecorder
+// must implement AccessStatsProvider, as FileAccessRecorder does.
+func (s *Storage) GetAccessStats(ctx context.Context, path string) (*AccessStats, error) {
+	if s.config.AccessRecorder == nil {
+		return nil, NotSupportedError("access recording is not configured")
+	}
+	provider, ok := s.config.AccessRecorder.(AccessStatsProvider)
+	if !ok {
+		return nil, NotSupportedError("access recorder does not keep stats")
+	}
+	return provider.AccessStats(ctx, cleanPath(path))
+}
+
+// serveRecorded serves a download and reports it to the AccessRecorder once the response
+// is complete, so the byte count reflects what the client actually received
+func (s *Storage) serveRecorded(c *rest.EndpointContext, path string, event *AccessEvent, headers func(header http.Header)) error {
+	response := c.EchoCtx.Response()
+	written := response.Size
+
+	err := s.writeContent(c.Context(), response, c.EchoCtx.Request(), path, headers)
+	if errors.Is(err, ErrIsDirectory) {
+		// A directory is answered with its index, which is not a download
+		return s.serveDirectory(c, path, err)
+	}
+
+	event.Bytes = response.Size - written
+	event.Status = responseStatus(response, err)
+	event.Completed = err == nil
+	s.recordAccess(c, path, event)
+
+	result := "completed"
+	switch {
+	case errors.Is(err, errTransferAborted):
+		result = "aborted"
+	case err != nil:
+		result = "failed"
+	}
+	labels := map[string]string{"storage": s.config.Name, "operation": event.Operation, "result": result}
+	s.config.incCounter("storage_downloads_total", 1, labels)
+	s.config.incCounter("storage_download_bytes_total", event.Bytes, labels)
+
+	// An aborted response was already committed; there is nothing left to tell the client
+	abortTruncated(err)
+	if err != nil && !errors.Is(err, errTransferAborted) {
+		return downloadError(err)
+	}
+	return nil
+}
+
+// recordAccess fills the request fields of an event and passes it to the recorder
+func (s *Storage) recordAccess(c *rest.EndpointContext, path string, event *AccessEvent) {
+	if s.config.AccessRecorder == nil {
+		return
+	}
+
+	event.Path = cleanPath(path)
+	event.RemoteIP = c.EchoCtx.RealIP()
+	event.Time = s.config.now()
+
+	// The request context is canceled when the client aborts, which must not lose the event
+	ctx := context.WithoutCancel(c.Context())
+	if err := s.config.AccessRecorder.RecordAccess(ctx, event); err != nil {
+		s.config.log(ctx, LogLevelWarn, "failed to record access", map[string]interface{}{
+			"path":  event.Path,
+			"error": err.Error(),
+		})
+	}
+}
+
+// responseStatus returns the status sent, or the one the error from writeContent maps to
+func responseStatus(response *echo.Response, err error) int {
+	if response.Committed {
+		return response.Status
+	}
+	var storageErr *StorageError
+	if errors.As(err, &storageErr) {
+		return storageErr.HTTPStatus()
+	}
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
+
+// tokenClaims returns the claims of a token that was already validated
+func tokenClaims(token string) jwt.MapClaims {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return jwt.MapClaims{}
+	}
+	return claims
+}
+
+// tokenSubject returns the "sub" claim of a token that was already validated
+func tokenSubject(token string) string {
+	subject, _ := tokenClaims(token).GetSubject()
+	return subject
+}
+
+// FileAccessRecorder keeps per-path download counters in memory and persists them to a
+// JSON file at most every FlushInterval. Call Close on shutdown to write pending counters.
+type FileAccessRecorder struct {
+	stateFile     string
+	FlushInterval time.Duration
+
+	mu        sync.Mutex
+	stats     map[string]*AccessStats
+	dirty     bool
+	lastFlush time.Time
+}
+
+// NewFileAccessRecorder creates a recorder, loading the counters persisted in stateFile.
+// An empty stateFile keeps the counters in memory only.
+func NewFileAccessRecorder(stateFile string) (*FileAccessRecorder, error) {
+	r := &FileAccessRecorder{
+		stateFile:     stateFile,
+		FlushInterval: DefaultAccessFlushInterval,
+		stats:         make(map[string]*AccessStats),
+		lastFlush:     time.Now(),
+	}
+
+	if stateFile == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, NewStorageErrorWithCause(ErrorCodeInvalidConfig, "failed to read access state", err)
+	}
+	if err := json.Unmarshal(data, &r.stats); err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeInvalidConfig, "failed to parse access state", err)
+	}
+
+	return r, nil
+}
+
+// RecordAccess updates the counters of the event's path. Failed responses are not counted.
+func (r *FileAccessRecorder) RecordAccess(ctx context.Context, event *AccessEvent) error {
+	if event.Status < 200 || event.Status >= 300 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats, ok := r.stats[event.Path]
+	if !ok {
+		stats = &AccessStats{Path: event.Path}
+		r.stats[event.Path] = stats
+	}
+	stats.Count++
+	if !event.Completed {
+		stats.Aborted++
+	}
+	stats.Bytes += event.Bytes
+	accessed := event.Time
+	stats.LastAccess = &accessed
+	r.dirty = true
+
+	if time.Since(r.lastFlush) < r.FlushInterval {
+		return nil
+	}
+	return r.flushLocked()
+}
+
+// AccessStats returns the counters of a path, zero when it was never downloaded
+func (r *FileAccessRecorder) AccessStats(ctx context.Context, path string) (*AccessStats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats, ok := r.stats[path]
+	if !ok {
+		return &AccessStats{Path: path}, nil
+	}
+	copied := *stats
+	return &copied, nil
+}
+
+// Flush writes pending counters to the state file
+func (r *FileAccessRecorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.flushLocked()
+}
+
+// Close writes pending counters to the state file
+func (r *FileAccessRecorder) Close() error {
+	return r.Flush()
+}
+
+// flushLocked persists the counters if they changed. Must be called with the lock held.
+func (r *FileAccessRecorder) flushLocked() error {
+	r.lastFlush = time.Now()
+	if !r.dirty || r.stateFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(r.stats)
+	if err != nil {
+		return NewStorageErrorWithCause(ErrorCodeInternalError, "failed to encode access state", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated state
+	tmp := r.stateFile + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(tmp), 0755); err != nil {
+		return NewStorageErrorWithCause(ErrorCodeInternalError, "failed to create access state directory", err)
+	}
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return NewStorageErrorWithCause(ErrorCodeInternalError, "failed to write access state", err)
+	}
+	if err := os.Rename(tmp, r.stateFile); err != nil {
+		return NewStorageErrorWithCause(ErrorCodeInternalError, "failed to write access state", err)
+	}
+
+	r.dirty = false
+	return nil
+}
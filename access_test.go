@@ -0,0 +1,194 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// accessLog collects access events for tests
+type accessLog struct {
+	mu     sync.Mutex
+	events []*AccessEvent
+}
+
+func (l *accessLog) RecordAccess(ctx context.Context, event *AccessEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+	return nil
+}
+
+// abortingWriter fails after accepting limit body bytes, like a client that disconnects
+type abortingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (w *abortingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n, _ := w.ResponseRecorder.Write(p[:w.limit])
+		w.limit = 0
+		return n, errors.New("connection reset")
+	}
+	w.limit -= len(p)
+	return w.ResponseRecorder.Write(p)
+}
+
+// readFromRecorder is a response recorder with ReadFrom, like the connections that send
+// files with sendfile
+type readFromRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (w readFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{w.ResponseRecorder}, src)
+}
+
+func TestAccessRecorder(t *testing.T) {
+	ctx := context.Background()
+	log := &accessLog{}
+	metrics := &countingMetrics{}
+	storage, err := New(&StorageConfig{
+		Name:       "test",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+		SignedURL:  &SignedURLConfig{Enabled: true, SecretKey: "secret"},
+		Metrics:    metrics,
+
+		AccessRecorder: log,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.Upload(ctx, "shared/clip.mp4", strings.NewReader("0123456789"), nil)
+
+	e := echo.New()
+	stream := func(target string, w http.ResponseWriter) *AccessEvent {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.Header.Set("X-Real-IP", "203.0.113.7")
+		c := &rest.EndpointContext{EchoCtx: e.NewContext(request, w)}
+		storage.StreamFile(c, "shared/clip.mp4")
+
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		if len(log.events) == 0 {
+			t.Fatal("expected an access event")
+		}
+		return log.events[len(log.events)-1]
+	}
+
+	event := stream("/shared/clip.mp4", httptest.NewRecorder())
+	if event.Path != "shared/clip.mp4" || event.Operation != AccessOperationDownload || event.Bytes != 10 ||
+		event.Status != http.StatusOK || event.RemoteIP != "203.0.113.7" || !event.Completed {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	event = stream("/shared/clip.mp4", &abortingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 4})
+	if event.Bytes != 4 || event.Completed || event.Status != http.StatusOK {
+		t.Errorf("expected an aborted download after 4 bytes, got %+v", event)
+	}
+	if downloads, bytes := metrics.counters["storage_downloads_total"], metrics.counters["storage_download_bytes_total"]; downloads != 2 || bytes != 14 {
+		t.Errorf("expected 2 downloads of 14 bytes in the metrics, got %d of %d", downloads, bytes)
+	}
+
+	t.Run("Progress", func(t *testing.T) {
+		var reports []string
+		storage.config.DownloadProgress = func(path string, bytesDone, bytesTotal int64) {
+			reports = append(reports, fmt.Sprintf("%s %d/%d", path, bytesDone, bytesTotal))
+		}
+		storage.config.DownloadProgressInterval = 4
+		defer func() { storage.config.DownloadProgress = nil }()
+
+		for _, w := range []http.ResponseWriter{httptest.NewRecorder(), readFromRecorder{httptest.NewRecorder()}} {
+			reports = nil
+			storage.config.CopyBufferSize = 4
+			stream("/shared/clip.mp4", w)
+			if fmt.Sprint(reports) != "[shared/clip.mp4 4/10 shared/clip.mp4 8/10 shared/clip.mp4 10/10]" {
+				t.Errorf("%T: unexpected progress %v", w, reports)
+			}
+		}
+		storage.config.CopyBufferSize = 0
+	})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"path": "shared/clip.mp4",
+		"op":   string(SignedURLOperationGet),
+		"sub":  "user-42",
+		"exp":  time.Now().Add(time.Minute).Unix(),
+	})
+	signed, _ := token.SignedString([]byte("secret"))
+	event = stream("/shared/clip.mp4?token="+signed, httptest.NewRecorder())
+	if event.Operation != AccessOperationSignedDownload || event.Subject != "user-42" || event.Bytes != 10 {
+		t.Errorf("unexpected signed event %+v", event)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/shared/missing.mp4", nil)
+	storage.StreamFile(&rest.EndpointContext{EchoCtx: e.NewContext(request, httptest.NewRecorder())}, "shared/missing.mp4")
+	if event := log.events[len(log.events)-1]; event.Status != http.StatusNotFound || event.Path != "shared/missing.mp4" {
+		t.Errorf("expected a 404 event, got %+v", event)
+	}
+
+	event = stream("/shared/clip.mp4?token=invalid", httptest.NewRecorder())
+	if event.Status != http.StatusUnauthorized || event.Bytes != 0 {
+		t.Errorf("expected a 401 event, got %+v", event)
+	}
+
+	if _, err := storage.GetAccessStats(ctx, "shared/clip.mp4"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported without a stats recorder, got %v", err)
+	}
+}
+
+func TestFileAccessRecorder(t *testing.T) {
+	ctx := context.Background()
+	stateFile := filepath.Join(t.TempDir(), "access.json")
+	recorder, err := NewFileAccessRecorder(stateFile)
+	if err != nil {
+		t.Fatalf("NewFileAccessRecorder failed: %v", err)
+	}
+
+	storage, _ := New(&StorageConfig{Name: "test", Provider: "memory", AccessRecorder: recorder})
+
+	first := time.Now().Add(-time.Hour)
+	last := time.Now()
+	recorder.RecordAccess(ctx, &AccessEvent{Path: "a.mp4", Bytes: 10, Status: http.StatusOK, Completed: true, Time: first})
+	recorder.RecordAccess(ctx, &AccessEvent{Path: "a.mp4", Bytes: 4, Status: http.StatusPartialContent, Time: last})
+	recorder.RecordAccess(ctx, &AccessEvent{Path: "a.mp4", Status: http.StatusNotFound, Time: time.Now()})
+
+	stats, err := storage.GetAccessStats(ctx, "/a.mp4")
+	if err != nil {
+		t.Fatalf("GetAccessStats failed: %v", err)
+	}
+	if stats.Count != 2 || stats.Aborted != 1 || stats.Bytes != 14 || stats.LastAccess == nil || !stats.LastAccess.Equal(last) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if stats, _ := storage.GetAccessStats(ctx, "never.mp4"); stats.Count != 0 || stats.LastAccess != nil {
+		t.Errorf("expected empty stats, got %+v", stats)
+	}
+
+	// Counters survive a restart once flushed
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	reloaded, err := NewFileAccessRecorder(stateFile)
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if stats, _ := reloaded.AccessStats(ctx, "a.mp4"); stats.Count != 2 {
+		t.Errorf("expected persisted count 2, got %d", stats.Count)
+	}
+}
@@ -0,0 +1,148 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failingReader returns its data and then an error
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestAppend(t *testing.T) {
+	ctx := context.Background()
+	fs, mem := newTransferStorages(t)
+	dedup, _ := New(&StorageConfig{Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir(), Deduplicate: true}})
+
+	for name, storage := range map[string]*Storage{"filesystem": fs, "memory": mem, "deduplicated": dedup} {
+		t.Run(name, func(t *testing.T) {
+			info, err := storage.Append(ctx, "events/2024-06-12.log", strings.NewReader("first\n"))
+			if err != nil || info.Size != 6 {
+				t.Fatalf("Append failed: %v, %+v", err, info)
+			}
+			if info, err = storage.Append(ctx, "events/2024-06-12.log", strings.NewReader("second\n")); err != nil || info.Size != 13 {
+				t.Fatalf("Append failed: %v, %+v", err, info)
+			}
+
+			// Concurrent appends of whole records never interleave
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					record := fmt.Sprintf("record-%02d %s\n", i, strings.Repeat("x", 4096))
+					if _, err := storage.Append(ctx, "events/2024-06-12.log", strings.NewReader(record)); err != nil {
+						t.Errorf("Append failed: %v", err)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			reader, _, err := storage.Download(ctx, "events/2024-06-12.log")
+			if err != nil {
+				t.Fatalf("Download failed: %v", err)
+			}
+			data, _ := io.ReadAll(reader)
+			reader.Close()
+
+			lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			if len(lines) != 52 || lines[0] != "first" || lines[1] != "second" {
+				t.Fatalf("Expected 52 lines, got %d", len(lines))
+			}
+			for _, line := range lines[2:] {
+				if len(line) != len("record-00 ")+4096 || !strings.HasPrefix(line, "record-") {
+					t.Fatalf("Interleaved record %q", line[:20])
+				}
+			}
+
+			// A failed append leaves the previous content
+			if _, err := storage.Append(ctx, "events/2024-06-12.log", &failingReader{data: []byte("partial")}); err == nil {
+				t.Error("Expected the failed read to be reported")
+			}
+			if info, _ := storage.GetInfo(ctx, "events/2024-06-12.log"); info.Size != int64(len(data)) {
+				t.Errorf("Expected the partial record to be dropped, got %d bytes", info.Size)
+			}
+
+			if _, err := storage.Append(ctx, "events", strings.NewReader("x")); !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("Expected ErrInvalidPath for a directory, got %v", err)
+			}
+		})
+	}
+
+	t.Run("Deduplicated content is not modified", func(t *testing.T) {
+		dedup.Upload(ctx, "a.log", strings.NewReader("shared\n"), nil)
+		dedup.Upload(ctx, "b.log", strings.NewReader("shared\n"), nil)
+		dedup.Append(ctx, "a.log", strings.NewReader("more\n"))
+
+		reader, _, _ := dedup.Download(ctx, "b.log")
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(data) != "shared\n" {
+			t.Errorf("Expected the linked copy to be unchanged, got %q", data)
+		}
+	})
+
+	t.Run("Retention", func(t *testing.T) {
+		mem.Upload(ctx, "locked.log", strings.NewReader("a\n"), nil)
+		mem.SetRetention(ctx, "locked.log", time.Now().Add(time.Hour))
+		if _, err := mem.Append(ctx, "locked.log", strings.NewReader("b\n")); !errors.Is(err, ErrRetentionLocked) {
+			t.Errorf("Expected ErrRetentionLocked, got %v", err)
+		}
+	})
+
+	t.Run("Quota", func(t *testing.T) {
+		storage, _ := New(&StorageConfig{Name: "test", Provider: "memory", Quota: &QuotaConfig{DefaultLimit: 10}})
+		storage.Append(ctx, "tenant/log", bytes.NewReader([]byte("123456")))
+		if _, err := storage.Append(ctx, "tenant/log", bytes.NewReader([]byte("789"))); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		if usage, _ := storage.QuotaUsage("tenant"); usage.Used != 9 {
+			t.Errorf("Expected 9 bytes used, got %d", usage.Used)
+		}
+		if _, err := storage.Append(ctx, "tenant/log", bytes.NewReader([]byte("0123"))); !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+		}
+	})
+
+	t.Run("Not supported", func(t *testing.T) {
+		storage, err := New(&StorageConfig{Name: "test", Provider: "s3", S3: &S3Config{Bucket: "b", Region: "r"}})
+		if err != nil {
+			t.Skipf("S3 storage unavailable: %v", err)
+		}
+		if _, err := storage.Append(ctx, "log", strings.NewReader("x")); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported, got %v", err)
+		}
+		if storage.Capabilities().Append {
+			t.Error("Expected S3 not to report appends")
+		}
+	})
+}
+
+func TestCapabilities(t *testing.T) {
+	fs, mem := newTransferStorages(t)
+
+	caps := fs.Capabilities()
+	if !caps.Append || !caps.RangeReads || !caps.LocalFiles || !caps.CreateDirectory || !caps.Retention || caps.Provider != "filesystem" {
+		t.Errorf("Unexpected filesystem capabilities %+v", caps)
+	}
+	if caps := mem.ReadOnly().Capabilities(); !caps.Append || caps.LocalFiles || !caps.ReadOnly {
+		t.Errorf("Unexpected memory capabilities %+v", caps)
+	}
+}
@@ -0,0 +1,227 @@
+package vsaasstorage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Formats accepted by ArchiveFiles
+const (
+	ArchiveTar = "tar"
+	ArchiveZip = "zip"
+)
+
+// ArchiveManifestName is the entry that describes the archived files
+const ArchiveManifestName = "manifest.json"
+
+// maxArchiveFiles bounds the number of paths accepted by ArchiveHandler and the number of
+// files of a directory archived by ArchiveDirectory
+const maxArchiveFiles = 1000
+
+// ArchiveOptions controls the behavior of ArchiveFiles
+type ArchiveOptions struct {
+	Format      string            `json:"format,omitempty"`       // "tar" (default) or "zip"
+	Names       map[string]string `json:"names,omitempty"`        // Name inside the archive by path; the path itself by default
+	SkipMissing bool              `json:"skip_missing,omitempty"` // Record missing files in the manifest instead of failing
+}
+
+// ArchiveManifest is written as the last entry of an archive
+type ArchiveManifest struct {
+	CreatedAt time.Time              `json:"created_at"`
+	Files     []ArchiveManifestEntry `json:"files"`
+}
+
+// ArchiveManifestEntry describes one requested file
+type ArchiveManifestEntry struct {
+	Path         string     `json:"path"`
+	Name         string     `json:"name"` // Entry name inside the archive
+	Size         int64      `json:"size"`
+	SHA256       string     `json:"sha256,omitempty"`
+	ContentType  string     `json:"content_type,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	Missing      bool       `json:"missing,omitempty"`
+}
+
+// archiveWriter adds entries to a tar or zip stream
+type archiveWriter interface {
+	create(name string, size int64, modTime time.Time) (io.Writer, error)
+	Close() error
+}
+
+type tarArchive struct{ *tar.Writer }
+
+func (a tarArchive) create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	err := a.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	})
+	return a.Writer, err
+}
+
+type zipArchive struct{ *zip.Writer }
+
+func (a zipArchive) create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	// Video and images do not compress, so entries are stored as is
+	return a.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modTime,
+	})
+}
+
+// ArchiveFiles streams the given files as a single tar or zip archive to w, followed by a
+// manifest.json entry with the path, size, SHA-256 and timestamps of each file. Every path
+// is checked before anything is written, so a missing file fails without partial output
+// unless SkipMissing is set, in which case it is listed in the manifest as missing.
+func (s *Storage) ArchiveFiles(ctx context.Context, paths []string, w io.Writer, opts ArchiveOptions) (*ArchiveManifest, error) {
+	var archive archiveWriter
+	switch opts.Format {
+	case "", ArchiveTar:
+		archive = tarArchive{tar.NewWriter(w)}
+	case ArchiveZip:
+		archive = zipArchive{zip.NewWriter(w)}
+	default:
+		return nil, NewStorageError(ErrorCodeInvalidPath, "unknown archive format: "+opts.Format)
+	}
+
+	manifest := &ArchiveManifest{CreatedAt: s.config.now().UTC(), Files: make([]ArchiveManifestEntry, 0, len(paths))}
+	names := map[string]bool{ArchiveManifestName: true}
+	for _, filePath := range paths {
+		entry := ArchiveManifestEntry{Path: cleanPath(filePath), Name: archiveEntryName(filePath, opts.Names)}
+		if entry.Name == "" || names[entry.Name] {
+			return nil, NewStorageError(ErrorCodeInvalidPath, "duplicate or empty archive name for "+filePath)
+		}
+		names[entry.Name] = true
+
+		info, err := s.GetInfo(ctx, filePath)
+		switch {
+		case err == nil && info.IsDirectory:
+			return nil, NewStorageError(ErrorCodeInvalidPath, "cannot archive a directory: "+filePath)
+		case err == nil:
+			entry.Size = info.Size
+			entry.ContentType = info.ContentType
+			entry.LastModified = info.LastModified
+		case opts.SkipMissing && errors.Is(err, ErrFileNotFound):
+			entry.Missing = true
+		default:
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, entry)
+	}
+
+	for i := range manifest.Files {
+		entry := &manifest.Files[i]
+		if entry.Missing {
+			continue
+		}
+		if err := s.archiveFile(ctx, archive, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeInternalError, "failed to encode archive manifest", err)
+	}
+	writer, err := archive.create(ArchiveManifestName, int64(len(data)), manifest.CreatedAt)
+	if err == nil {
+		_, err = writer.Write(data)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to write archive", err)
+	}
+
+	return manifest, nil
+}
+
+// ArchiveDirectory streams every file under dir like ArchiveFiles, named by their path
+// relative to dir unless opts.Names says otherwise. Hidden entries are skipped, and a
+// directory with more than 1000 files fails with ErrInvalidPath before anything is written.
+func (s *Storage) ArchiveDirectory(ctx context.Context, dir string, w io.Writer, opts ArchiveOptions) (*ArchiveManifest, error) {
+	prefix := cleanPath(dir)
+	var paths []string
+	names := make(map[string]string)
+	err := s.Walk(ctx, dir, func(info *FileInfo) error {
+		if info.IsDirectory {
+			return nil
+		}
+		if len(paths) == maxArchiveFiles {
+			return NewStorageErrorWithPath(ErrorCodeInvalidPath, fmt.Sprintf("directory holds more than %d files", maxArchiveFiles), dir)
+		}
+		filePath := cleanPath(info.Path)
+		paths = append(paths, filePath)
+		names[filePath] = strings.TrimPrefix(strings.TrimPrefix(filePath, prefix), "/")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for filePath, name := range opts.Names {
+		names[cleanPath(filePath)] = name
+	}
+	opts.Names = names
+	return s.ArchiveFiles(ctx, paths, w, opts)
+}
+
+// archiveFile copies one file into the archive, hashing it on the way
+func (s *Storage) archiveFile(ctx context.Context, archive archiveWriter, entry *ArchiveManifestEntry) error {
+	if err := checkContext(ctx, entry.Path); err != nil {
+		return err
+	}
+
+	reader, _, err := s.Download(ctx, entry.Path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var modTime time.Time
+	if entry.LastModified != nil {
+		modTime = *entry.LastModified
+	}
+	writer, err := archive.create(entry.Name, entry.Size, modTime)
+	if err != nil {
+		return NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to write archive", err)
+	}
+
+	hash := sha256.New()
+	written, err := copyBuffer(io.MultiWriter(writer, hash), reader, s.config.GetCopyBufferSize())
+	if err != nil {
+		return NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to archive "+entry.Path, err)
+	}
+	if written != entry.Size {
+		// The file changed after it was checked; the tar header already holds the old size
+		return NewStorageError(ErrorCodeDownloadFailed, "file changed while archiving: "+entry.Path)
+	}
+
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// archiveEntryName returns the name of a file inside the archive, always a relative path
+func archiveEntryName(filePath string, names map[string]string) string {
+	if name, ok := names[filePath]; ok {
+		return cleanPath(name)
+	}
+	if name, ok := names[cleanPath(filePath)]; ok {
+		return cleanPath(name)
+	}
+	return cleanPath(filePath)
+}
@@ -0,0 +1,167 @@
+package vsaasstorage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// readTar returns the entries of a tar archive by name
+func readTar(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	entries := make(map[string]string)
+	reader := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("invalid tar: %v", err)
+		}
+		content, _ := io.ReadAll(reader)
+		entries[header.Name] = string(content)
+	}
+}
+
+func TestArchiveFiles(t *testing.T) {
+	ctx := context.Background()
+	storage, _ := New(&StorageConfig{Name: "test", Provider: "memory"})
+	storage.Upload(ctx, "cameras/1/clip.mp4", strings.NewReader("clip one"), nil)
+	storage.Upload(ctx, "cameras/2/clip.mp4", strings.NewReader("clip two"), nil)
+	storage.Upload(ctx, "snapshots/a.jpg", strings.NewReader("jpeg"), nil)
+
+	paths := []string{"cameras/1/clip.mp4", "cameras/2/clip.mp4", "snapshots/a.jpg"}
+
+	t.Run("Tar", func(t *testing.T) {
+		var buf bytes.Buffer
+		manifest, err := storage.ArchiveFiles(ctx, paths, &buf, ArchiveOptions{
+			Names: map[string]string{"cameras/2/clip.mp4": "evidence/second.mp4"},
+		})
+		if err != nil {
+			t.Fatalf("ArchiveFiles failed: %v", err)
+		}
+
+		entries := readTar(t, buf.Bytes())
+		if entries["cameras/1/clip.mp4"] != "clip one" || entries["evidence/second.mp4"] != "clip two" || entries["snapshots/a.jpg"] != "jpeg" {
+			t.Errorf("unexpected entries %v", entries)
+		}
+
+		var written ArchiveManifest
+		if err := json.Unmarshal([]byte(entries[ArchiveManifestName]), &written); err != nil {
+			t.Fatalf("invalid manifest: %v", err)
+		}
+		sum := sha256.Sum256([]byte("clip two"))
+		second := written.Files[1]
+		if len(written.Files) != 3 || second.Path != "cameras/2/clip.mp4" || second.Name != "evidence/second.mp4" ||
+			second.Size != 8 || second.SHA256 != hex.EncodeToString(sum[:]) || second.LastModified == nil {
+			t.Errorf("unexpected manifest entry %+v", second)
+		}
+		if manifest.Files[1].SHA256 != second.SHA256 {
+			t.Error("expected the returned manifest to match the archived one")
+		}
+	})
+
+	t.Run("Zip", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := storage.ArchiveFiles(ctx, paths, &buf, ArchiveOptions{Format: ArchiveZip}); err != nil {
+			t.Fatalf("ArchiveFiles failed: %v", err)
+		}
+		reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("invalid zip: %v", err)
+		}
+		if len(reader.File) != 4 || reader.File[3].Name != ArchiveManifestName {
+			t.Errorf("expected 3 files and the manifest, got %d entries", len(reader.File))
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		withMissing := append([]string{"missing.mp4"}, paths...)
+
+		var buf bytes.Buffer
+		if _, err := storage.ArchiveFiles(ctx, withMissing, &buf, ArchiveOptions{}); !errors.Is(err, ErrFileNotFound) {
+			t.Errorf("expected ErrFileNotFound, got %v", err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("expected nothing written before failing, got %d bytes", buf.Len())
+		}
+
+		manifest, err := storage.ArchiveFiles(ctx, withMissing, &buf, ArchiveOptions{SkipMissing: true})
+		if err != nil {
+			t.Fatalf("ArchiveFiles failed: %v", err)
+		}
+		if !manifest.Files[0].Missing || manifest.Files[0].SHA256 != "" || len(readTar(t, buf.Bytes())) != 4 {
+			t.Errorf("expected the missing file only in the manifest, got %+v", manifest.Files[0])
+		}
+	})
+
+	t.Run("InvalidNames", func(t *testing.T) {
+		names := map[string]string{"cameras/2/clip.mp4": "cameras/1/clip.mp4"}
+		if _, err := storage.ArchiveFiles(ctx, paths, io.Discard, ArchiveOptions{Names: names}); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("expected ErrInvalidPath for duplicate names, got %v", err)
+		}
+		if _, err := storage.ArchiveFiles(ctx, []string{"cameras"}, io.Discard, ArchiveOptions{}); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("expected ErrInvalidPath for a directory, got %v", err)
+		}
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		e := echo.New()
+		body := `{"paths": ["cameras/1/clip.mp4", "snapshots/a.jpg"], "format": "tar"}`
+		request := httptest.NewRequest(http.MethodPost, "/archive", strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		if err := storage.ArchiveHandler()(&rest.EndpointContext{EchoCtx: e.NewContext(request, recorder)}); err != nil {
+			t.Fatalf("handler failed: %v", err)
+		}
+		if recorder.Header().Get("Content-Type") != "application/x-tar" || len(readTar(t, recorder.Body.Bytes())) != 3 {
+			t.Errorf("unexpected response %v", recorder.Header())
+		}
+
+		request = httptest.NewRequest(http.MethodPost, "/archive", strings.NewReader(`{"paths": ["missing.mp4"]}`))
+		recorder = httptest.NewRecorder()
+		err := storage.ArchiveHandler()(&rest.EndpointContext{EchoCtx: e.NewContext(request, recorder)})
+		if err == nil || recorder.Body.Len() != 0 {
+			t.Errorf("expected an error before writing the archive, got %v", err)
+		}
+	})
+}
+
+func TestArchiveHandlerAbort(t *testing.T) {
+	ctx := context.Background()
+	provider := &truncatingProvider{limit: -1}
+	RegisterProvider("truncating-archive", func(config *StorageConfig) (StorageProvider, error) {
+		inner, err := NewMemoryProvider(config)
+		provider.StorageProvider = inner
+		return provider, err
+	})
+	storage, err := New(&StorageConfig{Name: "test", Provider: "truncating-archive"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.Upload(ctx, "clips/a.mp4", strings.NewReader("0123456789"), nil)
+	provider.limit = 4
+
+	// The archive fails after its first bytes were sent
+	request := httptest.NewRequest(http.MethodPost, "/archive", strings.NewReader(`{"paths": ["clips/a.mp4"]}`))
+	c := &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, httptest.NewRecorder())}
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("Expected the handler to abort the connection, got %v", r)
+		}
+	}()
+	storage.ArchiveHandler()(c)
+}
@@ -0,0 +1,195 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Operations recorded in AuditEntry
+const (
+	AuditOperationDelete          = "delete" // Also each file of DeleteMany
+	AuditOperationDeleteDirectory = "delete_directory"
+	AuditOperationMove            = "move" // Path is the original path and Destination where it went
+	AuditOperationPurgeTrash      = "purge_trash"
+	AuditOperationRestoreVersion  = "restore_version"
+)
+
+// Results of an AuditEntry
+const (
+	AuditResultStarted = "started" // Recorded before the operation when the audit is mandatory
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+)
+
+// AuditEntry records who performed a destructive operation and how it ended. Paths are
+// canonical.
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	Storage     string    `json:"storage,omitempty"`
+	Operation   string    `json:"op"`
+	Path        string    `json:"path"`
+	Destination string    `json:"destination,omitempty"` // Where a move put the file
+	VersionID   string    `json:"version_id,omitempty"`  // Version restored by RestoreVersion
+	Count       int       `json:"count,omitempty"`       // Trash batches removed by PurgeTrash
+	Subject     string    `json:"subject,omitempty"`     // See WithSubject
+	RequestID   string    `json:"request_id,omitempty"`
+	Result      string    `json:"result"`
+	Error       string    `json:"error,omitempty"` // Why the operation failed
+}
+
+// AuditSink records the deletes, moves, trash purges and version restores of a storage.
+// Unlike the Journal, which only sees what happened, it also records failed attempts.
+// With StorageConfig.AuditMandatory each operation is first recorded as started and is
+// not performed, failing with ErrAuditFailed, when that record fails, so an operation
+// that could not be recorded did not happen. Failures to record the result of an
+// operation are logged and counted in storage_audit_errors_total.
+type AuditSink interface {
+	Record(entry AuditEntry) error
+}
+
+type subjectKey struct{}
+
+// WithSubject returns a context carrying the subject performing operations, e.g. the user
+// authenticated by an Authorizer, recorded in audit entries. Without it the uploader set
+// with WithUploader is recorded.
+func WithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// SubjectFrom returns the subject carried by ctx, or "" if there is none
+func SubjectFrom(ctx context.Context) string {
+	if subject, _ := ctx.Value(subjectKey{}).(string); subject != "" {
+		return subject
+	}
+	return UploaderFrom(ctx)
+}
+
+// audited runs op, recording it in the configured audit sink. op may complete the entry,
+// e.g. with the count of PurgeTrash. Moves out of the quarantine are the storage's own
+// and are not recorded.
+func (s *Storage) audited(ctx context.Context, entry AuditEntry, op func(entry *AuditEntry) error) error {
+	if s.config.Audit == nil || isQuarantinePath(entry.Path) {
+		return op(&entry)
+	}
+
+	entry.Storage = s.config.Name
+	entry.Path = cleanPath(s.config.normalizePath(entry.Path))
+	if entry.Destination != "" {
+		entry.Destination = cleanPath(s.config.normalizePath(entry.Destination))
+	}
+	entry.Subject = SubjectFrom(ctx)
+	entry.RequestID = RequestIDFrom(ctx)
+
+	if s.config.AuditMandatory {
+		started := entry
+		started.Time, started.Result = s.config.now().UTC(), AuditResultStarted
+		if err := s.config.Audit.Record(started); err != nil {
+			s.auditFailed(ctx, &entry, err)
+			return AuditFailedError(entry.Path, err)
+		}
+	}
+
+	err := op(&entry)
+	entry.Time, entry.Result = s.config.now().UTC(), AuditResultSuccess
+	if err != nil {
+		entry.Result, entry.Error = AuditResultFailure, err.Error()
+	}
+	if recordErr := s.config.Audit.Record(entry); recordErr != nil {
+		s.auditFailed(ctx, &entry, recordErr)
+	}
+	return err
+}
+
+// auditFailed logs and counts an entry the audit sink could not record
+func (s *Storage) auditFailed(ctx context.Context, entry *AuditEntry, err error) {
+	s.config.log(ctx, LogLevelError, "failed to record audit entry", map[string]interface{}{
+		"path":      entry.Path,
+		"operation": entry.Operation,
+		"error":     err.Error(),
+	})
+	s.config.incCounter("storage_audit_errors_total", 1, map[string]string{
+		"storage": s.config.Name,
+	})
+}
+
+// FileAuditSink appends entries as JSON lines to a file, synced to disk before Record
+// returns so a recorded entry survives a crash. The file is only ever appended to.
+type FileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileAuditSink opens or creates the audit file at path
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeInvalidConfig, "failed to create audit directory", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeInvalidConfig, "failed to open audit file", err)
+	}
+	return &FileAuditSink{file: file}, nil
+}
+
+// Record appends the entry as one line and syncs the file
+func (a *FileAuditSink) Record(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return os.ErrClosed
+	}
+	if _, err := a.file.Write(line); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// Close closes the audit file
+func (a *FileAuditSink) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// MemoryAuditSink keeps entries in memory, for tests
+type MemoryAuditSink struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+}
+
+// NewMemoryAuditSink creates an empty in-memory audit sink
+func NewMemoryAuditSink() *MemoryAuditSink {
+	return &MemoryAuditSink{}
+}
+
+// Record appends the entry
+func (a *MemoryAuditSink) Record(entry AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, entry)
+	return nil
+}
+
+// Entries returns a copy of the recorded entries, oldest first
+func (a *MemoryAuditSink) Entries() []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AuditEntry(nil), a.entries...)
+}
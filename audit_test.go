@@ -0,0 +1,138 @@
+package vsaasstorage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingAuditSink refuses every entry
+type failingAuditSink struct{}
+
+func (failingAuditSink) Record(AuditEntry) error { return errors.New("audit backend down") }
+
+func TestAudit(t *testing.T) {
+	ctx := WithRequestID(WithSubject(context.Background(), "user-7"), "req-1")
+	sink := NewMemoryAuditSink()
+	storage, err := New(&StorageConfig{
+		Name:       "test",
+		Provider:   "memory",
+		Trash:      &TrashConfig{Enabled: true},
+		Versioning: &VersioningConfig{Enabled: true},
+		Audit:      sink,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	for _, p := range []string{"clips/a.mp4", "clips/b.mp4", "cams/1/c.mp4", "cams/1/d.mp4"} {
+		storage.Upload(ctx, p, strings.NewReader("x"), nil)
+	}
+	storage.Upload(ctx, "clips/a.mp4", strings.NewReader("y"), nil)
+	versions, _ := storage.ListVersions(ctx, "clips/a.mp4")
+
+	storage.Move(ctx, "/clips/b.mp4", "clips/moved.mp4")
+	storage.Delete(ctx, "clips/missing.mp4")
+	storage.DeleteMany(ctx, []string{"clips/moved.mp4"})
+	storage.DeleteDirectory(ctx, "cams/1", DeleteOptions{Permanent: true})
+	storage.RestoreVersion(ctx, "clips/a.mp4", versions[0].Metadata[VersionIDMetadataKey])
+	storage.PurgeTrash(ctx, -time.Hour)
+
+	var got []string
+	for _, entry := range sink.Entries() {
+		if entry.Subject != "user-7" || entry.RequestID != "req-1" || entry.Storage != "test" || entry.Time.IsZero() {
+			t.Errorf("Expected the subject, request ID, storage and time in %+v", entry)
+		}
+		got = append(got, entry.Operation+" "+entry.Path+" "+entry.Result)
+	}
+	expected := []string{
+		"move clips/b.mp4 success",
+		"delete clips/missing.mp4 failure",
+		"delete clips/moved.mp4 success",
+		"delete_directory cams/1 success",
+		"restore_version clips/a.mp4 success",
+		"purge_trash .trash success",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected entries\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+	entries := sink.Entries()
+	if entries[0].Destination != "clips/moved.mp4" || entries[1].Error == "" || entries[4].VersionID != versions[0].Metadata[VersionIDMetadataKey] || entries[5].Count == 0 {
+		t.Errorf("Expected the details of each operation, got %+v", entries)
+	}
+
+	t.Run("Mandatory", func(t *testing.T) {
+		if _, err := New(&StorageConfig{Name: "test", Provider: "memory", AuditMandatory: true}); err == nil {
+			t.Error("Expected a mandatory audit without a sink to be rejected")
+		}
+
+		storage, err := New(&StorageConfig{Name: "test", Provider: "memory", Audit: failingAuditSink{}, AuditMandatory: true})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		storage.Upload(ctx, "clips/a.mp4", strings.NewReader("x"), nil)
+		if err := storage.Delete(ctx, "clips/a.mp4"); !errors.Is(err, ErrAuditFailed) {
+			t.Fatalf("Expected the delete to be vetoed, got %v", err)
+		}
+		if exists, _ := storage.Exists(ctx, "clips/a.mp4"); !exists {
+			t.Error("Expected the file to survive a vetoed delete")
+		}
+
+		// Without AuditMandatory the failure is only logged
+		optional, _ := New(&StorageConfig{Name: "test", Provider: "memory", Audit: failingAuditSink{}})
+		optional.Upload(ctx, "clips/a.mp4", strings.NewReader("x"), nil)
+		if err := optional.Delete(ctx, "clips/a.mp4"); err != nil {
+			t.Errorf("Expected the delete to go ahead, got %v", err)
+		}
+	})
+
+	t.Run("MandatoryStarted", func(t *testing.T) {
+		sink := NewMemoryAuditSink()
+		storage, _ := New(&StorageConfig{Name: "test", Provider: "memory", Audit: sink, AuditMandatory: true})
+		storage.Upload(ctx, "clips/a.mp4", strings.NewReader("x"), nil)
+		storage.Delete(ctx, "clips/a.mp4")
+		entries := sink.Entries()
+		if len(entries) != 2 || entries[0].Result != AuditResultStarted || entries[1].Result != AuditResultSuccess {
+			t.Errorf("Expected a started and a success entry, got %+v", entries)
+		}
+	})
+}
+
+func TestFileAuditSink(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	sink, err := NewFileAuditSink(file)
+	if err != nil {
+		t.Fatalf("NewFileAuditSink failed: %v", err)
+	}
+	sink.Record(AuditEntry{Operation: AuditOperationDelete, Path: "a.txt", Result: AuditResultSuccess})
+	sink.Record(AuditEntry{Operation: AuditOperationMove, Path: "b.txt", Destination: "c.txt", Result: AuditResultFailure})
+	sink.Close()
+	if err := sink.Record(AuditEntry{}); err == nil {
+		t.Error("Expected a closed sink to fail")
+	}
+
+	// Reopening appends to the same file
+	sink, _ = NewFileAuditSink(file)
+	sink.Record(AuditEntry{Operation: AuditOperationPurgeTrash, Path: ".trash", Result: AuditResultSuccess})
+	sink.Close()
+
+	f, _ := os.Open(file)
+	defer f.Close()
+	var operations []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Expected JSON lines, got %q", scanner.Text())
+		}
+		operations = append(operations, entry.Operation)
+	}
+	if strings.Join(operations, ",") != "delete,move,purge_trash" {
+		t.Errorf("Expected three entries, got %v", operations)
+	}
+}
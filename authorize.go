@@ -0,0 +1,68 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// Authorizer decides whether the request of a handler may perform op on path. Handlers
+// call it before touching the provider and answer 403 when it returns an error. Listing,
+// info and downloads are SignedURLOperationGet; uploads and new directories are
+// SignedURLOperationPut on the destination.
+type Authorizer func(c *rest.EndpointContext, path string, op SignedURLOperation) error
+
+// tenantPlaceholder is replaced by the tenant in PrefixAuthorizer layouts
+const tenantPlaceholder = "{tenant}"
+
+// PrefixAuthorizer returns an Authorizer that only allows paths under a per-tenant
+// prefix, such as layout "tenants/{tenant}" for "tenants/<id>/...". extractTenant reads
+// the tenant of the request, e.g. from the authenticated user; requests without one, or
+// with one that is not a single path segment such as "." or "..", are refused.
+func PrefixAuthorizer(extractTenant func(ctx context.Context) string, layout string) Authorizer {
+	return func(c *rest.EndpointContext, path string, op SignedURLOperation) error {
+		tenant := extractTenant(c.Context())
+		if tenant == "" || strings.Contains(tenant, "/") {
+			return errors.New("request has no tenant")
+		}
+		if tenant == "." || tenant == ".." || canonicalize(tenant) != tenant {
+			return errors.New("request has an invalid tenant")
+		}
+
+		clean := canonicalize(path)
+		for _, segment := range strings.Split(clean, "/") {
+			if segment == ".." {
+				return errors.New("path escapes the tenant prefix")
+			}
+		}
+
+		prefix := canonicalize(strings.ReplaceAll(layout, tenantPlaceholder, tenant))
+		if clean != prefix && !strings.HasPrefix(clean, prefix+"/") {
+			return errors.New("path is outside the tenant prefix")
+		}
+		return nil
+	}
+}
+
+// authorize runs the configured Authorizer, returning a 403 error when it refuses
+func (s *Storage) authorize(c *rest.EndpointContext, path string, op SignedURLOperation) error {
+	if s.config.Authorizer == nil {
+		return nil
+	}
+	if err := s.config.Authorizer(c, path, op); err != nil {
+		return httpError(NewStorageErrorWithCause(ErrorCodePermissionDenied, "access denied: "+err.Error(), err), "Access denied")
+	}
+	return nil
+}
+
+// tokenAuthorizes reports whether a download token replaces the Authorizer, which needs
+// SignedTokensSkipAuthorizer and a provider that validates tokens
+func (s *Storage) tokenAuthorizes(token string) bool {
+	if token == "" || !s.config.SignedTokensSkipAuthorizer || s.config.Provider != "filesystem" {
+		return false
+	}
+	_, ok := providerAs[*FileSystemProvider](s.provider)
+	return ok
+}
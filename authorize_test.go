@@ -0,0 +1,126 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+type tenantKey struct{}
+
+// tenantContext builds a handler context for a request made by tenant
+func tenantContext(method, target, tenant string) (*rest.EndpointContext, *httptest.ResponseRecorder) {
+	request := httptest.NewRequest(method, target, nil)
+	request = request.WithContext(context.WithValue(request.Context(), tenantKey{}, tenant))
+	recorder := httptest.NewRecorder()
+	return &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder)}, recorder
+}
+
+// isForbidden reports whether a handler error is a 403
+func isForbidden(err error) bool {
+	var httpErr *echo.HTTPError
+	return errors.As(err, &httpErr) && httpErr.Code == http.StatusForbidden
+}
+
+func TestPrefixAuthorizer(t *testing.T) {
+	authorizer := PrefixAuthorizer(func(ctx context.Context) string {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		return tenant
+	}, "tenants/{tenant}")
+
+	tests := []struct {
+		tenant, path string
+		allowed      bool
+	}{
+		{"acme", "tenants/acme/cameras/1.mp4", true},
+		{"acme", "/tenants/acme/", true},
+		{"acme", "tenants/acme", true},
+		{"acme", "tenants/other/cameras/1.mp4", false},
+		{"acme", "tenants/acme-evil/a.txt", false},
+		{"acme", "tenants/acme/../other/a.txt", false},
+		{"acme", "/", false},
+		{"", "tenants//a.txt", false},
+		{".", "tenants/a.txt", false},
+		{".", "tenants", false},
+		{"..", "other/a.txt", false},
+	}
+	for _, tt := range tests {
+		c, _ := tenantContext(http.MethodGet, "/", tt.tenant)
+		if err := authorizer(c, tt.path, SignedURLOperationGet); (err == nil) != tt.allowed {
+			t.Errorf("Tenant %q, path %q: expected allowed=%v, got %v", tt.tenant, tt.path, tt.allowed, err)
+		}
+	}
+}
+
+func TestHandlerAuthorization(t *testing.T) {
+	ctx := context.Background()
+	config := &StorageConfig{
+		Name:       "test",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+		SignedURL:  &SignedURLConfig{Enabled: true, SecretKey: "secret"},
+		Authorizer: PrefixAuthorizer(func(ctx context.Context) string {
+			tenant, _ := ctx.Value(tenantKey{}).(string)
+			return tenant
+		}, "tenants/{tenant}"),
+	}
+	storage, err := New(config)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.Upload(ctx, "tenants/acme/a.txt", strings.NewReader("a"), nil)
+
+	handlers := []struct {
+		name    string
+		handler func(c *rest.EndpointContext) error
+		method  string
+	}{
+		{"Download", storage.DownloadHandler(), http.MethodGet},
+		{"Info", storage.InfoHandler(), http.MethodGet},
+		{"Poster", storage.PosterHandler(), http.MethodGet},
+		{"List", storage.ListHandler(), http.MethodGet},
+		{"Mkdir", storage.MkdirHandler(), http.MethodPost},
+		{"Delete", storage.DeleteHandler(), http.MethodDelete},
+	}
+	for _, h := range handlers {
+		c, _ := tenantContext(h.method, "/files?path=tenants/acme/a.txt", "other")
+		if err := h.handler(c); !isForbidden(err) {
+			t.Errorf("%s: expected 403 for another tenant, got %v", h.name, err)
+		}
+	}
+
+	c, recorder := tenantContext(http.MethodGet, "/files?path=tenants/acme/a.txt", "acme")
+	if err := storage.DownloadHandler()(c); err != nil || recorder.Body.String() != "a" {
+		t.Errorf("Expected the tenant's own file, got %v", err)
+	}
+	if exists, _ := storage.Exists(ctx, "tenants/acme/a.txt"); !exists {
+		t.Fatal("Expected the refused delete to leave the file in place")
+	}
+
+	t.Run("SignedTokens", func(t *testing.T) {
+		token, _ := storage.GenerateSignedURL(ctx, "tenants/acme/a.txt", SignedURLOperationGet, time.Hour)
+
+		c, _ := tenantContext(http.MethodGet, "/files?path=tenants/acme/a.txt&token="+token, "")
+		if err := storage.DownloadHandler()(c); !isForbidden(err) {
+			t.Errorf("Expected the authorizer to apply to tokens by default, got %v", err)
+		}
+
+		config.SignedTokensSkipAuthorizer = true
+		defer func() { config.SignedTokensSkipAuthorizer = false }()
+		c, recorder := tenantContext(http.MethodGet, "/files?path=tenants/acme/a.txt&token="+token, "")
+		if err := storage.DownloadHandler()(c); err != nil || recorder.Body.String() != "a" {
+			t.Errorf("Expected the token to authorize the download, got %v", err)
+		}
+		c, _ = tenantContext(http.MethodGet, "/files?path=tenants/acme/a.txt&token=forged", "")
+		if err := storage.DownloadHandler()(c); err == nil {
+			t.Error("Expected an invalid token to be refused")
+		}
+	})
+}
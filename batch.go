@@ -0,0 +1,226 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CopyDirectory copies every file under srcDir to dstDir, running up to
+// StorageConfig.Concurrency copies at a time. Empty directories are not copied, since
+// not every provider can represent them. Files that could not be copied are reported
+// in a *MultiError keyed by source path.
+func (s *Storage) CopyDirectory(ctx context.Context, srcDir, dstDir string) error {
+	if err := s.checkWritable(dstDir); err != nil {
+		return err
+	}
+
+	files, err := s.directoryFiles(ctx, srcDir, dstDir)
+	if err != nil {
+		return err
+	}
+
+	return s.executor().Run(ctx, files, func(ctx context.Context, filePath string) error {
+		return s.Copy(ctx, filePath, path.Join(dstDir, relativePath(srcDir, filePath)))
+	})
+}
+
+// moveDirectory moves every file under srcDir to dstDir and removes srcDir once all of
+// them were moved. Files that could not be moved stay in place and are reported in a
+// *MultiError keyed by source path.
+func (s *Storage) moveDirectory(ctx context.Context, srcDir, dstDir string) error {
+	files, err := s.directoryFiles(ctx, srcDir, dstDir)
+	if err != nil {
+		return err
+	}
+
+	err = s.executor().Run(ctx, files, func(ctx context.Context, filePath string) error {
+		return s.Move(ctx, filePath, path.Join(dstDir, relativePath(srcDir, filePath)))
+	})
+	if err != nil {
+		return err
+	}
+
+	// Only empty directories are left
+	if err := s.provider.DeleteDirectory(ctx, srcDir); err != nil && !errors.Is(err, ErrDirectoryNotFound) {
+		return err
+	}
+	return nil
+}
+
+// directoryFiles lists the files under srcDir for a recursive copy or move to dstDir
+func (s *Storage) directoryFiles(ctx context.Context, srcDir, dstDir string) ([]string, error) {
+	src, dst := cleanPath(srcDir), cleanPath(dstDir)
+	if isRootPath(src) || dst == src || strings.HasPrefix(dst, src+"/") {
+		return nil, NewStorageErrorWithPath(ErrorCodeInvalidPath, "destination is inside the source directory", dstDir)
+	}
+
+	var files []string
+	err := s.walk(ctx, srcDir, allEntries, func(info *FileInfo) error {
+		if !info.IsDirectory {
+			files = append(files, info.Path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// rejectedDirectory reports whether a provider failed a file operation because path is
+// a directory. Providers refuse directories as invalid or, when they only store objects,
+// as not found; the path is only inspected after such a failure.
+func (s *Storage) rejectedDirectory(ctx context.Context, path string, err error) bool {
+	if !errors.Is(err, ErrInvalidPath) && !errors.Is(err, ErrFileNotFound) {
+		return false
+	}
+	info, err := s.provider.GetInfo(ctx, path)
+	return err == nil && info.IsDirectory
+}
+
+// DeleteMany deletes the given files, running up to StorageConfig.Concurrency deletes
+// at a time. Files that could not be deleted are reported in a *MultiError keyed by path.
+func (s *Storage) DeleteMany(ctx context.Context, paths []string, opts ...DeleteOptions) error {
+	if err := s.checkWritable(""); err != nil {
+		return err
+	}
+
+	return s.executor().Run(ctx, paths, func(ctx context.Context, filePath string) error {
+		return s.Delete(ctx, filePath, opts...)
+	})
+}
+
+// EmptyOptions selects the entries removed by EmptyDirectory
+type EmptyOptions struct {
+	DeleteOptions           // Applied to every deletion
+	Pattern       string    // Only remove entries whose name matches this glob, as in path.Match
+	Before        time.Time // Only remove entries last modified before this time
+}
+
+// matches reports whether an entry is selected by the options. With Before set, entries
+// without a modification time, such as directories on object stores, are kept.
+func (o EmptyOptions) matches(info *FileInfo) (bool, error) {
+	if o.Pattern != "" {
+		matched, err := path.Match(o.Pattern, info.Name)
+		if err != nil {
+			return false, NewStorageErrorWithCause(ErrorCodeInvalidPath, "invalid pattern", err)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	if !o.Before.IsZero() && (info.LastModified == nil || !info.LastModified.Before(o.Before)) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// EmptyDirectory deletes the entries of a directory, or only those selected by the
+// options, and keeps the directory itself. Subdirectories are deleted with their contents.
+// It returns how many entries were removed. Deletions run up to StorageConfig.Concurrency
+// at a time; entries under retention are kept and reported in a *DirectoryRetentionError,
+// other failures in a *MultiError keyed by path.
+func (s *Storage) EmptyDirectory(ctx context.Context, dirPath string, opts ...EmptyOptions) (int, error) {
+	if err := s.checkWritable(dirPath); err != nil {
+		return 0, err
+	}
+
+	var options EmptyOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+
+	entries, err := s.ListWithOptions(ctx, dirPath, allEntries)
+	if err != nil {
+		return 0, err
+	}
+
+	var paths []string
+	directories := make(map[string]bool)
+	for _, entry := range entries {
+		matched, err := options.matches(entry)
+		if err != nil {
+			return 0, err
+		}
+		if matched {
+			paths = append(paths, entry.Path)
+			directories[entry.Path] = entry.IsDirectory
+		}
+	}
+
+	// Make the directory explicit first, so providers that only infer directories from
+	// their contents keep it once the last entry is gone
+	s.keepDirectory(ctx, dirPath)
+	removed, err := s.deleteEntries(ctx, dirPath, paths, directories, options.DeleteOptions)
+
+	// Deletions may have pruned the directory once it was left empty
+	s.keepDirectory(ctx, dirPath)
+	return removed, err
+}
+
+// deleteEntries deletes files and, with their contents, the directories among paths, up to
+// StorageConfig.Concurrency at a time, and returns how many were removed. Entries under
+// retention are kept and reported in a *DirectoryRetentionError for dirPath, other
+// failures in a *MultiError keyed by path.
+func (s *Storage) deleteEntries(ctx context.Context, dirPath string, paths []string, directories map[string]bool, opts DeleteOptions) (int, error) {
+	var (
+		removed atomic.Int64
+		mu      sync.Mutex
+		skipped []string
+	)
+	err := s.executor().Run(ctx, paths, func(ctx context.Context, entryPath string) error {
+		var err error
+		if directories[entryPath] {
+			err = s.DeleteDirectory(ctx, entryPath, opts)
+		} else {
+			err = s.Delete(ctx, entryPath, opts)
+		}
+
+		var retentionErr *DirectoryRetentionError
+		switch {
+		case errors.As(err, &retentionErr):
+			mu.Lock()
+			skipped = append(skipped, retentionErr.Skipped...)
+			mu.Unlock()
+			return nil
+		case errors.Is(err, ErrRetentionLocked):
+			mu.Lock()
+			skipped = append(skipped, entryPath)
+			mu.Unlock()
+			return nil
+		case err != nil:
+			return err
+		}
+		removed.Add(1)
+		return nil
+	})
+
+	if err != nil {
+		return int(removed.Load()), err
+	}
+	if len(skipped) > 0 {
+		sort.Strings(skipped)
+		return int(removed.Load()), &DirectoryRetentionError{Path: dirPath, Skipped: skipped}
+	}
+	return int(removed.Load()), nil
+}
+
+// keepDirectory creates a directory on providers that support it, logging failures
+func (s *Storage) keepDirectory(ctx context.Context, dirPath string) {
+	if isRootPath(dirPath) {
+		return
+	}
+	provider, ok := providerAs[DirectoryProvider](s.provider)
+	if !ok {
+		return
+	}
+	if err := provider.CreateDirectory(ctx, s.extensionPath(dirPath)); err != nil {
+		s.config.log(ctx, LogLevelWarn, "failed to keep emptied directory", map[string]interface{}{
+			"path":  dirPath,
+			"error": err.Error(),
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyDirectory(t *testing.T) {
+	ctx := context.Background()
+	fs, mem := newTransferStorages(t)
+
+	for name, storage := range map[string]*Storage{"filesystem": fs, "memory": mem} {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				path := fmt.Sprintf("camera/thumbs/%02d.jpg", i)
+				storage.Upload(ctx, path, strings.NewReader(path), nil)
+			}
+			storage.Upload(ctx, "camera/thumbs/day/1.jpg", strings.NewReader("nested"), nil)
+
+			if err := storage.CopyDirectory(ctx, "camera/thumbs", "backup/thumbs"); err != nil {
+				t.Fatalf("CopyDirectory failed: %v", err)
+			}
+
+			for _, path := range []string{"backup/thumbs/00.jpg", "backup/thumbs/19.jpg", "backup/thumbs/day/1.jpg"} {
+				if exists, _ := storage.Exists(ctx, path); !exists {
+					t.Errorf("Expected %s to be copied", path)
+				}
+			}
+		})
+	}
+
+	t.Run("Missing directory", func(t *testing.T) {
+		if err := mem.CopyDirectory(ctx, "missing", "backup"); !errors.Is(err, ErrDirectoryNotFound) {
+			t.Errorf("Expected ErrDirectoryNotFound, got %v", err)
+		}
+	})
+}
+
+func TestDeleteMany(t *testing.T) {
+	ctx := context.Background()
+	_, storage := newTransferStorages(t)
+
+	paths := []string{"a.jpg", "b.jpg", "c.jpg", "locked.jpg"}
+	for _, path := range paths {
+		storage.Upload(ctx, path, strings.NewReader(path), nil)
+	}
+	storage.SetRetention(ctx, "locked.jpg", time.Now().Add(time.Hour))
+
+	err := storage.DeleteMany(ctx, append(paths, "missing.jpg"))
+
+	var multiErr *MultiError
+	if !errors.As(err, &multiErr) {
+		t.Fatalf("Expected a MultiError, got %v", err)
+	}
+	if multiErr.Len() != 2 || !errors.Is(multiErr.Errors["locked.jpg"], ErrRetentionLocked) || !errors.Is(multiErr.Errors["missing.jpg"], ErrFileNotFound) {
+		t.Errorf("Unexpected failures %v", multiErr.Errors)
+	}
+
+	for _, path := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if exists, _ := storage.Exists(ctx, path); exists {
+			t.Errorf("Expected %s to be deleted", path)
+		}
+	}
+}
+
+func TestCopyAndMoveDirectories(t *testing.T) {
+	ctx := context.Background()
+	fs, mem := newTransferStorages(t)
+
+	// The filesystem provider can also hold empty directories
+	fsProvider, _ := providerAs[*FileSystemProvider](fs.provider)
+	emptyDir, _ := fsProvider.getFullPath("cameras/old/empty")
+
+	for name, storage := range map[string]*Storage{"filesystem": fs, "memory": mem} {
+		t.Run(name, func(t *testing.T) {
+			files := []string{"a.mp4", "2024/b.mp4", "2024/06/c.mp4"}
+			for _, file := range files {
+				storage.Upload(ctx, "cameras/old/"+file, strings.NewReader(file), nil)
+			}
+			os.MkdirAll(emptyDir, 0755)
+
+			if err := storage.Copy(ctx, "cameras/old", "cameras/copy"); err != nil {
+				t.Fatalf("Copy failed: %v", err)
+			}
+			if err := storage.Move(ctx, "cameras/old", "cameras/new"); err != nil {
+				t.Fatalf("Move failed: %v", err)
+			}
+
+			for _, file := range files {
+				for _, dir := range []string{"cameras/copy/", "cameras/new/"} {
+					if exists, _ := storage.Exists(ctx, dir+file); !exists {
+						t.Errorf("Expected %s%s to exist", dir, file)
+					}
+				}
+			}
+			if exists, _ := storage.DirectoryExists(ctx, "cameras/old"); exists {
+				t.Error("Expected the moved directory to be removed, empty subdirectories included")
+			}
+
+			if err := storage.Move(ctx, "cameras/new", "cameras/new/nested"); !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("Expected moving a directory into itself to fail with ErrInvalidPath, got %v", err)
+			}
+			if err := storage.Copy(ctx, "missing", "elsewhere"); !errors.Is(err, ErrFileNotFound) {
+				t.Errorf("Expected ErrFileNotFound for a missing source, got %v", err)
+			}
+		})
+	}
+
+	t.Run("Locked files stay behind", func(t *testing.T) {
+		mem.Upload(ctx, "locked/a.mp4", strings.NewReader("a"), nil)
+		mem.Upload(ctx, "locked/b.mp4", strings.NewReader("b"), nil)
+		mem.SetRetention(ctx, "locked/b.mp4", time.Now().Add(time.Hour))
+
+		err := mem.Move(ctx, "locked", "unlocked")
+		var multiErr *MultiError
+		if !errors.As(err, &multiErr) || multiErr.Len() != 1 || !errors.Is(err, ErrRetentionLocked) {
+			t.Fatalf("Expected a MultiError with the locked file, got %v", err)
+		}
+		if exists, _ := mem.Exists(ctx, "locked/b.mp4"); !exists {
+			t.Error("Expected the locked file to stay in place")
+		}
+		if exists, _ := mem.Exists(ctx, "unlocked/a.mp4"); !exists {
+			t.Error("Expected the unlocked file to be moved")
+		}
+	})
+
+	t.Run("Providers reject directories", func(t *testing.T) {
+		for _, provider := range []StorageProvider{fs.provider, mem.provider} {
+			if err := provider.Copy(ctx, "cameras/new", "x"); !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("Expected ErrInvalidPath from Copy, got %v", err)
+			}
+			if err := provider.Move(ctx, "cameras/new", "x"); !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("Expected ErrInvalidPath from Move, got %v", err)
+			}
+		}
+	})
+}
+
+func TestEmptyDirectory(t *testing.T) {
+	ctx := context.Background()
+	fs, err := New(&StorageConfig{
+		Name:       "test",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir(), PruneEmptyDirs: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem storage: %v", err)
+	}
+	mem, _ := New(&StorageConfig{Name: "test", Provider: "memory"})
+
+	for name, storage := range map[string]*Storage{"filesystem": fs, "memory": mem} {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				storage.Upload(ctx, fmt.Sprintf("cam1/day/%02d.mp4", i), strings.NewReader("clip"), nil)
+			}
+			storage.Upload(ctx, "cam1/day/index.json", strings.NewReader("{}"), nil)
+			storage.Upload(ctx, "cam1/day/thumbs/01.jpg", strings.NewReader("jpeg"), nil)
+
+			removed, err := storage.EmptyDirectory(ctx, "cam1/day", EmptyOptions{Pattern: "*.mp4"})
+			if err != nil || removed != 10 {
+				t.Fatalf("Expected 10 entries removed, got %d, %v", removed, err)
+			}
+			files, _ := storage.List(ctx, "cam1/day")
+			if len(files) != 2 {
+				t.Errorf("Expected the unmatched entries to stay, got %d", len(files))
+			}
+
+			if removed, err := storage.EmptyDirectory(ctx, "cam1/day", EmptyOptions{Before: time.Now().Add(-time.Hour)}); err != nil || removed != 0 {
+				t.Errorf("Expected nothing older than the cutoff, got %d, %v", removed, err)
+			}
+
+			removed, err = storage.EmptyDirectory(ctx, "cam1/day")
+			if err != nil || removed != 2 {
+				t.Fatalf("Expected 2 entries removed, got %d, %v", removed, err)
+			}
+			files, err = storage.List(ctx, "cam1/day")
+			if err != nil || len(files) != 0 {
+				t.Errorf("Expected the directory to be kept empty, got %v, %v", files, err)
+			}
+		})
+	}
+
+	t.Run("Retention", func(t *testing.T) {
+		mem.Upload(ctx, "cam2/day/a.mp4", strings.NewReader("a"), nil)
+		mem.Upload(ctx, "cam2/day/b.mp4", strings.NewReader("b"), nil)
+		mem.Upload(ctx, "cam2/day/sub/c.mp4", strings.NewReader("c"), nil)
+		mem.SetRetention(ctx, "cam2/day/b.mp4", time.Now().Add(time.Hour))
+		mem.SetRetention(ctx, "cam2/day/sub/c.mp4", time.Now().Add(time.Hour))
+
+		removed, err := mem.EmptyDirectory(ctx, "cam2/day")
+		var retentionErr *DirectoryRetentionError
+		if !errors.As(err, &retentionErr) || len(retentionErr.Skipped) != 2 || retentionErr.Skipped[0] != "cam2/day/b.mp4" {
+			t.Fatalf("Expected the locked files to be reported, got %v", err)
+		}
+		if removed != 1 {
+			t.Errorf("Expected 1 entry removed, got %d", removed)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := mem.EmptyDirectory(ctx, "missing"); !errors.Is(err, ErrDirectoryNotFound) {
+			t.Errorf("Expected ErrDirectoryNotFound, got %v", err)
+		}
+		mem.Upload(ctx, "file.txt", strings.NewReader("x"), nil)
+		if _, err := mem.EmptyDirectory(ctx, "file.txt"); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("Expected ErrInvalidPath, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package vsaasstorage
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+)
+
+// DefaultCopyBufferSize is the buffer size used for streaming copies when
+// StorageConfig.CopyBufferSize is not set
+const DefaultCopyBufferSize = 256 << 10
+
+// copyBufferPools holds one *sync.Pool of buffers per configured size
+var copyBufferPools sync.Map
+
+// getCopyBuffer returns a pooled buffer of the given size
+func getCopyBuffer(size int) *[]byte {
+	pool, ok := copyBufferPools.Load(size)
+	if !ok {
+		pool, _ = copyBufferPools.LoadOrStore(size, &sync.Pool{
+			New: func() any {
+				buf := make([]byte, size)
+				return &buf
+			},
+		})
+	}
+	return pool.(*sync.Pool).Get().(*[]byte)
+}
+
+// putCopyBuffer returns a buffer to its pool
+func putCopyBuffer(buf *[]byte) {
+	if pool, ok := copyBufferPools.Load(len(*buf)); ok {
+		pool.(*sync.Pool).Put(buf)
+	}
+}
+
+// copyBuffer copies src to dst through a pooled buffer of the given size. Destinations
+// implementing io.ReaderFrom, such as *os.File, are left to copy on their own so the
+// kernel fast paths (copy_file_range, sendfile) still apply.
+func copyBuffer(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if _, ok := dst.(io.ReaderFrom); ok {
+		return io.Copy(dst, src)
+	}
+
+	buf := getCopyBuffer(size)
+	defer putCopyBuffer(buf)
+
+	// os.File.WriteTo only helps when writing to a socket; otherwise it allocates its own buffer
+	if _, ok := src.(*os.File); ok {
+		src = struct{ io.Reader }{src}
+	}
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// contextCopyChunk is how much copyContext copies between context checks
+const contextCopyChunk = 8 << 20
+
+// copyContext is copyBuffer checking ctx between chunks of contextCopyChunk bytes. Unlike
+// a contextReader it keeps the kernel fast paths, which see through the io.LimitedReader
+// of each chunk.
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader, size int) (int64, error) {
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		n, err := copyBuffer(dst, io.LimitReader(src, contextCopyChunk), size)
+		written += n
+		if err != nil || n < contextCopyChunk {
+			return written, err
+		}
+	}
+}
+
+// contextReader fails reads once its context is done, so a cancelled request stops an
+// upload between chunks
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read reads from the underlying reader unless the context is done
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Unwrap returns the underlying reader, so its size can still be known
+func (r *contextReader) Unwrap() io.Reader {
+	return r.r
+}
@@ -0,0 +1,81 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// onlyReader hides optional interfaces such as io.WriterTo, like a multipart upload stream
+type onlyReader struct{ io.Reader }
+
+func TestCopyBuffer(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100_000)
+
+	t.Run("Pooled copy", func(t *testing.T) {
+		var dst bytes.Buffer
+		n, err := copyBuffer(struct{ io.Writer }{&dst}, onlyReader{bytes.NewReader(data)}, 4096)
+		if err != nil || n != int64(len(data)) || !bytes.Equal(dst.Bytes(), data) {
+			t.Errorf("Unexpected copy of %d bytes: %v", n, err)
+		}
+
+		buf := getCopyBuffer(4096)
+		if len(*buf) != 4096 {
+			t.Errorf("Expected a 4096 byte buffer, got %d", len(*buf))
+		}
+		putCopyBuffer(buf)
+	})
+
+	t.Run("File to file", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "src"), data, 0644)
+
+		src, _ := os.Open(filepath.Join(dir, "src"))
+		defer src.Close()
+		dst, _ := os.Create(filepath.Join(dir, "dst"))
+		defer dst.Close()
+
+		if n, err := copyBuffer(dst, src, DefaultCopyBufferSize); err != nil || n != int64(len(data)) {
+			t.Fatalf("Unexpected copy of %d bytes: %v", n, err)
+		}
+		if content, _ := os.ReadFile(filepath.Join(dir, "dst")); !bytes.Equal(content, data) {
+			t.Error("Copied file differs from the source")
+		}
+	})
+
+	t.Run("Configured size", func(t *testing.T) {
+		if size := (&StorageConfig{}).GetCopyBufferSize(); size != DefaultCopyBufferSize {
+			t.Errorf("Expected default size, got %d", size)
+		}
+		if size := (&StorageConfig{CopyBufferSize: 1 << 20}).GetCopyBufferSize(); size != 1<<20 {
+			t.Errorf("Expected 1MB, got %d", size)
+		}
+	})
+}
+
+// Compare allocations of io.Copy against the pooled copy on an upload-like path
+// (hashing writer, plain reader):
+//
+//	go test -bench CopyBuffer -benchmem
+func BenchmarkCopyBuffer(b *testing.B) {
+	data := bytes.Repeat([]byte("x"), 4<<20)
+
+	b.Run("io.Copy", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			io.Copy(io.MultiWriter(io.Discard, md5.New()), onlyReader{bytes.NewReader(data)})
+		}
+	})
+
+	b.Run("copyBuffer", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			copyBuffer(io.MultiWriter(io.Discard, md5.New()), onlyReader{bytes.NewReader(data)}, DefaultCopyBufferSize)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package vsaasstorage
+
+// Capabilities reports the optional operations the provider of a storage supports, so
+// callers can choose a fallback up front instead of handling ErrNotSupported
+type Capabilities struct {
+	Provider        string `json:"provider"`
+	ReadOnly        bool   `json:"read_only"`
+	Append          bool   `json:"append"`           // Append adds to a file without rewriting it
+	RangeReads      bool   `json:"range_reads"`      // ReadRange reads only the requested bytes, without downloading the start
+	LocalFiles      bool   `json:"local_files"`      // Direct downloads are sent with sendfile
+	NativeSeeking   bool   `json:"native_seeking"`   // OpenSeekable seeks without downloading the file whole first
+	CreateDirectory bool   `json:"create_directory"` // Empty directories can be created
+	Retention       bool   `json:"retention"`        // SetRetention locks files
+	CleanupOrphans  bool   `json:"cleanup_orphans"`  // CleanupOrphans removes abandoned uploads
+	ArchiveRestore  bool   `json:"archive_restore"`  // RestoreFromArchive restores files from archive storage classes
+	StorageClasses  bool   `json:"storage_classes"`  // TransitionStorageClass moves files between storage classes
+	Prefetch        bool   `json:"prefetch"`         // Prefetch loads files into a local cache, not only checks them
+
+	ResponseOverrides bool `json:"response_overrides"` // GET signed URLs can override the response headers
+	Capacity          bool `json:"capacity"`           // CapacityInfo reports the space of the volume
+}
+
+// Capabilities returns the optional operations supported by the storage
+func (s *Storage) Capabilities() Capabilities {
+	_, appendable := providerAs[AppendProvider](s.provider)
+	_, ranges := providerAs[RangeProvider](s.provider)
+	_, files := providerAs[FileProvider](s.provider)
+	_, directories := providerAs[DirectoryProvider](s.provider)
+	_, retention := providerAs[RetentionProvider](s.provider)
+	_, cleanup := providerAs[OrphanCleanupProvider](s.provider)
+	_, restore := providerAs[ArchiveRestoreProvider](s.provider)
+	_, classes := providerAs[StorageClassProvider](s.provider)
+	_, prefetch := providerAs[PrefetchProvider](s.provider)
+	_, overrides := providerAs[ResponseOverrideProvider](s.provider)
+	_, capacity := providerAs[CapacityProvider](s.provider)
+
+	return Capabilities{
+		Provider:        s.config.Provider,
+		ReadOnly:        s.config.ReadOnly,
+		Append:          appendable,
+		RangeReads:      ranges || files, // Local files are read from the offset
+		LocalFiles:      files,
+		NativeSeeking:   ranges || files,
+		CreateDirectory: directories,
+		Retention:       retention,
+		CleanupOrphans:  cleanup,
+		ArchiveRestore:  restore,
+		StorageClasses:  classes,
+		Prefetch:        prefetch,
+
+		ResponseOverrides: overrides && s.config.CDNSigning == nil,
+		Capacity:          capacity,
+	}
+}
@@ -0,0 +1,80 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// CapacityInfo describes the space of the volume a storage writes to
+type CapacityInfo struct {
+	Total     int64 `json:"total_bytes"`
+	Free      int64 `json:"free_bytes"`
+	Available int64 `json:"available_bytes"` // Free space usable without privileges, what uploads can fill
+}
+
+// CapacityProvider is implemented by providers that write to a volume of limited size
+type CapacityProvider interface {
+	// Capacity reports the space of the volume
+	Capacity(ctx context.Context) (*CapacityInfo, error)
+}
+
+// CapacityInfo reports the space of the volume the storage writes to and sets it on the
+// storage_capacity_{total,free,available}_bytes gauges. Only the filesystem provider has
+// a limited volume; others fail with ErrNotSupported.
+func (s *Storage) CapacityInfo(ctx context.Context) (*CapacityInfo, error) {
+	provider, ok := providerAs[CapacityProvider](s.provider)
+	if !ok {
+		return nil, NotSupportedError("provider does not report its capacity")
+	}
+	info, err := provider.Capacity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{"storage": s.config.Name}
+	s.config.setGauge("storage_capacity_total_bytes", float64(info.Total), labels)
+	s.config.setGauge("storage_capacity_free_bytes", float64(info.Free), labels)
+	s.config.setGauge("storage_capacity_available_bytes", float64(info.Available), labels)
+	return info, nil
+}
+
+// HealthHandler creates a handler function for liveness probes. It answers 200 with the
+// capacity of the volume when the provider reports one, and 503 when the available space
+// is below FileSystemConfig.MinFreeBytes or the capacity cannot be read, since uploads
+// would fail. Calling it also refreshes the capacity gauges.
+func (s *Storage) HealthHandler() func(c *rest.EndpointContext) error {
+	return s.traced(func(c *rest.EndpointContext) error {
+		body := map[string]interface{}{
+			"status":  "ok",
+			"storage": s.config.Name,
+		}
+
+		info, err := s.CapacityInfo(c.Context())
+		switch {
+		case errors.Is(err, ErrNotSupported):
+			return c.JSON(body)
+		case err != nil:
+			body["status"] = "error"
+			body["message"] = err.Error()
+			return c.JSON(body, http.StatusServiceUnavailable)
+		}
+
+		body["capacity"] = info
+		if minFree := s.config.minFreeBytes(); minFree > 0 && info.Available < minFree {
+			body["status"] = "low_space"
+			return c.JSON(body, http.StatusServiceUnavailable)
+		}
+		return c.JSON(body)
+	})
+}
+
+// minFreeBytes returns the space the filesystem provider keeps free, 0 for none
+func (c *StorageConfig) minFreeBytes() int64 {
+	if c.FileSystem == nil {
+		return 0
+	}
+	return c.FileSystem.MinFreeBytes
+}
@@ -0,0 +1,81 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestCapacityInfo(t *testing.T) {
+	ctx := context.Background()
+	metrics := &countingMetrics{}
+	config := &StorageConfig{Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir()}, Metrics: metrics}
+	storage, err := New(config)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	info, err := storage.CapacityInfo(ctx)
+	if errors.Is(err, ErrNotSupported) {
+		t.Skip("Volume capacity is not available on this platform")
+	}
+	if err != nil || info.Total <= 0 || info.Available > info.Total || info.Free > info.Total {
+		t.Fatalf("Expected the capacity of the volume, got %+v, %v", info, err)
+	}
+	if metrics.gauges["storage_capacity_total_bytes"] != float64(info.Total) {
+		t.Errorf("Expected the capacity in the gauges, got %v", metrics.gauges)
+	}
+	if !storage.Capabilities().Capacity {
+		t.Error("Expected the capacity capability")
+	}
+
+	health := func(storage *Storage) (int, map[string]interface{}) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		c := &rest.EndpointContext{EchoCtx: echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/health", nil), recorder)}
+		if err := storage.HealthHandler()(c); err != nil {
+			t.Fatalf("HealthHandler failed: %v", err)
+		}
+		var body map[string]interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &body)
+		return recorder.Code, body
+	}
+
+	if code, body := health(storage); code != http.StatusOK || body["status"] != "ok" || body["capacity"] == nil {
+		t.Errorf("Expected a healthy storage with its capacity, got %d %v", code, body)
+	}
+
+	t.Run("MinFreeBytes", func(t *testing.T) {
+		config.FileSystem.MinFreeBytes = info.Total + 1
+		defer func() { config.FileSystem.MinFreeBytes = 0 }()
+
+		_, err := storage.Upload(ctx, "clips/a.mp4", strings.NewReader("clip"), nil)
+		var storageErr *StorageError
+		if !errors.Is(err, ErrInsufficientStorage) || !errors.As(err, &storageErr) || storageErr.HTTPStatus() != http.StatusInsufficientStorage {
+			t.Errorf("Expected the upload to be refused, got %v", err)
+		}
+		if exists, _ := storage.Exists(ctx, "clips/a.mp4"); exists {
+			t.Error("Expected nothing to be written")
+		}
+		if code, body := health(storage); code != http.StatusServiceUnavailable || body["status"] != "low_space" {
+			t.Errorf("Expected the storage to report low space, got %d %v", code, body)
+		}
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		memory, _ := New(&StorageConfig{Name: "test", Provider: "memory"})
+		if _, err := memory.CapacityInfo(ctx); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported, got %v", err)
+		}
+		if code, body := health(memory); code != http.StatusOK || body["capacity"] != nil {
+			t.Errorf("Expected a healthy storage without capacity, got %d %v", code, body)
+		}
+	})
+}
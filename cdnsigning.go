@@ -0,0 +1,271 @@
+package vsaasstorage
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultCDNSignedURLExpiry is the lifetime of CloudFront signed URLs when neither the
+// call nor CDNSigningConfig sets one
+const DefaultCDNSignedURLExpiry = time.Hour
+
+// CDNSigningConfig signs GET URLs with a CloudFront key pair instead of asking the
+// provider, so downloads go through the CDN cache
+type CDNSigningConfig struct {
+	KeyPairID     string        `json:"keyPairId"`           // Public key ID registered in CloudFront
+	PrivateKeyPEM string        `json:"privateKeyPem"`       // RSA private key, PKCS#1 or PKCS#8
+	BaseURL       string        `json:"baseUrl"`             // Distribution URL, e.g. https://d111111abcdef8.cloudfront.net
+	ExpiresIn     time.Duration `json:"expiresIn,omitempty"` // Default lifetime, defaults to 1 hour
+}
+
+// String implements fmt.Stringer without exposing the private key
+func (c CDNSigningConfig) String() string {
+	type plain CDNSigningConfig
+	redacted := plain(c)
+	redacted.PrivateKeyPEM = redactSecret(c.PrivateKeyPEM)
+	return fmt.Sprintf("%+v", redacted)
+}
+
+// MarshalJSON implements json.Marshaler masking the private key
+func (c CDNSigningConfig) MarshalJSON() ([]byte, error) {
+	type plain CDNSigningConfig
+	redacted := plain(c)
+	redacted.PrivateKeyPEM = redactSecret(c.PrivateKeyPEM)
+	return json.Marshal(redacted)
+}
+
+// Validate validates the CDN signing configuration, including the private key
+func (c *CDNSigningConfig) Validate() error {
+	if c.KeyPairID == "" {
+		return errors.New("cdnSigning.keyPairId is required")
+	}
+	if err := validatePublicBaseURL(c.BaseURL); err != nil {
+		return fmt.Errorf("cdnSigning.baseUrl: %w", err)
+	}
+	if _, err := c.privateKey(); err != nil {
+		return fmt.Errorf("cdnSigning.privateKeyPem: %w", err)
+	}
+	return nil
+}
+
+// privateKey parses the configured RSA private key
+func (c *CDNSigningConfig) privateKey() (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(c.PrivateKeyPEM))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("not an RSA private key")
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA private key")
+	}
+	return key, nil
+}
+
+// SignedURLOptions controls GenerateSignedURLWithOptions
+type SignedURLOptions struct {
+	ExpiresIn time.Duration // Lifetime of the URL; CDN signing falls back to CDNSigningConfig.ExpiresIn
+
+	// IPAddress restricts a CDN signed URL to a client address or CIDR range, e.g.
+	// "203.0.113.7" or "203.0.113.0/24". It requires CDN signing and a custom policy.
+	IPAddress string
+	// NotBefore makes a CDN signed URL valid only from the given time. It requires CDN
+	// signing and a custom policy.
+	NotBefore *time.Time
+
+	// Response overrides the headers a GET URL is served with. It requires a provider
+	// implementing ResponseOverrideProvider and is not available with CDN signing.
+	Response ResponseOverrides
+
+	// KeyName signs with a key of SignedURLConfig.Keys instead of SecretKey, failing with
+	// ErrPermissionDenied for operations or paths outside its scope. It requires the
+	// filesystem provider and is not available for CDN signed GET URLs.
+	KeyName string
+}
+
+// customPolicy reports whether the options need a CloudFront custom policy
+func (o SignedURLOptions) customPolicy() bool {
+	return o.IPAddress != "" || o.NotBefore != nil
+}
+
+// GenerateSignedURLWithOptions is GenerateSignedURL with options. With CDNSigning GET URLs
+// are CloudFront URLs signed with a canned policy, or a custom policy when the options
+// restrict the client IP or start time; PUT and DELETE are still signed by the provider.
+func (s *Storage) GenerateSignedURLWithOptions(ctx context.Context, path string, operation SignedURLOperation, opts SignedURLOptions) (string, error) {
+	if operation != SignedURLOperationGet {
+		if err := s.checkWritable(path); err != nil {
+			return "", err
+		}
+	}
+
+	if opts.KeyName != "" {
+		return s.signWithKey(ctx, path, operation, opts)
+	}
+	if !opts.Response.empty() {
+		return s.signWithOverrides(ctx, path, operation, opts)
+	}
+	if operation == SignedURLOperationGet && s.config.CDNSigning != nil {
+		return s.config.CDNSigning.sign(s.config.normalizePath(path), opts, s.config.now())
+	}
+	if opts.customPolicy() {
+		return "", NotSupportedError("IP and start time restrictions require CDN signing")
+	}
+	return s.provider.GenerateSignedURL(ctx, path, operation, opts.ExpiresIn)
+}
+
+// signWithOverrides signs a GET URL whose response headers are overridden
+func (s *Storage) signWithOverrides(ctx context.Context, path string, operation SignedURLOperation, opts SignedURLOptions) (string, error) {
+	if operation != SignedURLOperationGet {
+		return "", NewStorageErrorWithPath(ErrorCodeSignedURLFailed, "response overrides only apply to GET URLs", path)
+	}
+	if err := opts.Response.validate(); err != nil {
+		return "", err
+	}
+	if s.config.CDNSigning != nil || opts.customPolicy() {
+		return "", NotSupportedError("response overrides are not supported with CDN signing")
+	}
+	provider, ok := providerAs[ResponseOverrideProvider](s.provider)
+	if !ok {
+		return "", NotSupportedError("provider cannot override response headers")
+	}
+	return provider.GenerateSignedURLWithOverrides(ctx, s.extensionPath(path), opts.ExpiresIn, opts.Response)
+}
+
+// signWithKey signs a URL with a named key of SignedURLConfig.Keys
+func (s *Storage) signWithKey(ctx context.Context, path string, operation SignedURLOperation, opts SignedURLOptions) (string, error) {
+	if (operation == SignedURLOperationGet && s.config.CDNSigning != nil) || opts.customPolicy() {
+		return "", NotSupportedError("signing keys do not apply to CDN signed URLs")
+	}
+	if !opts.Response.empty() {
+		if operation != SignedURLOperationGet {
+			return "", NewStorageErrorWithPath(ErrorCodeSignedURLFailed, "response overrides only apply to GET URLs", path)
+		}
+		if err := opts.Response.validate(); err != nil {
+			return "", err
+		}
+	}
+	provider, ok := providerAs[*FileSystemProvider](s.provider)
+	if !ok {
+		return "", NotSupportedError("provider does not sign with named keys")
+	}
+	return provider.signURL(ctx, s.extensionPath(path), operation, opts.ExpiresIn, opts.Response, opts.KeyName)
+}
+
+// cdnPolicy is a CloudFront policy with a single statement
+type cdnPolicy struct {
+	Statement []cdnStatement `json:"Statement"`
+}
+
+type cdnStatement struct {
+	Resource  string       `json:"Resource"`
+	Condition cdnCondition `json:"Condition"`
+}
+
+type cdnCondition struct {
+	DateLessThan    cdnEpoch     `json:"DateLessThan"`
+	DateGreaterThan *cdnEpoch    `json:"DateGreaterThan,omitempty"`
+	IPAddress       *cdnSourceIP `json:"IpAddress,omitempty"`
+}
+
+type cdnEpoch struct {
+	EpochTime int64 `json:"AWS:EpochTime"`
+}
+
+type cdnSourceIP struct {
+	SourceIP string `json:"AWS:SourceIp"`
+}
+
+// sign returns the CloudFront signed URL of a path
+func (c *CDNSigningConfig) sign(filePath string, opts SignedURLOptions, now time.Time) (string, error) {
+	key, err := c.privateKey()
+	if err != nil {
+		return "", NewStorageErrorWithCause(ErrorCodeSignedURLFailed, "invalid CDN signing key", err)
+	}
+
+	expiresIn := opts.ExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = c.ExpiresIn
+	}
+	if expiresIn <= 0 {
+		expiresIn = DefaultCDNSignedURLExpiry
+	}
+	expires := now.Add(expiresIn).Unix()
+	resource := joinURL(c.BaseURL, filePath)
+
+	query := url.Values{}
+	var policy []byte
+	if opts.customPolicy() {
+		condition := cdnCondition{DateLessThan: cdnEpoch{expires}}
+		if opts.NotBefore != nil {
+			condition.DateGreaterThan = &cdnEpoch{opts.NotBefore.Unix()}
+		}
+		if opts.IPAddress != "" {
+			cidr, err := sourceIPRange(opts.IPAddress)
+			if err != nil {
+				return "", NewStorageErrorWithCause(ErrorCodeSignedURLFailed, "invalid IP address restriction", err)
+			}
+			condition.IPAddress = &cdnSourceIP{cidr}
+		}
+		if policy, err = json.Marshal(cdnPolicy{Statement: []cdnStatement{{Resource: resource, Condition: condition}}}); err != nil {
+			return "", NewStorageErrorWithCause(ErrorCodeSignedURLFailed, "failed to encode policy", err)
+		}
+		query.Set("Policy", cdnBase64(policy))
+	} else {
+		// CloudFront rebuilds the canned policy from the URL, so it must match byte for byte
+		policy = []byte(fmt.Sprintf(`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":%d}}}]}`, resource, expires))
+		query.Set("Expires", fmt.Sprint(expires))
+	}
+
+	digest := sha1.Sum(policy)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, digest[:])
+	if err != nil {
+		return "", NewStorageErrorWithCause(ErrorCodeSignedURLFailed, "failed to sign policy", err)
+	}
+	query.Set("Signature", cdnBase64(signature))
+	query.Set("Key-Pair-Id", c.KeyPairID)
+
+	return resource + "?" + query.Encode(), nil
+}
+
+// sourceIPRange returns an address or CIDR range in the form CloudFront expects
+func sourceIPRange(address string) (string, error) {
+	if strings.Contains(address, "/") {
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return "", err
+		}
+		return network.String(), nil
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address %q", address)
+	}
+	if ip.To4() != nil {
+		return ip.String() + "/32", nil
+	}
+	return ip.String() + "/128", nil
+}
+
+// cdnBase64 encodes data with the URL-safe alphabet of CloudFront
+func cdnBase64(data []byte) string {
+	return strings.NewReplacer("+", "-", "=", "_", "/", "~").Replace(base64.StdEncoding.EncodeToString(data))
+}
@@ -0,0 +1,162 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+)
+
+// Headers with the checksum of the content of an upload, read by UploadHandler
+const (
+	ContentMD5Header     = "Content-MD5"       // Base64 MD5 digest, as in RFC 1864
+	ChecksumSHA256Header = "X-Checksum-Sha256" // Base64 or hex SHA-256 digest
+)
+
+// ExpectedChecksum is the checksum a client computed for the content of an upload. Set in
+// FileMetadata, the content is hashed as the provider reads it and the upload fails with
+// ErrChecksumMismatch, leaving nothing stored, when it differs.
+type ExpectedChecksum struct {
+	MD5    []byte // MD5 digest, nil when not checked
+	SHA256 []byte // SHA-256 digest, nil when not checked
+}
+
+// ParseChecksumHeaders reads the checksum sent in the Content-MD5 and x-checksum-sha256
+// headers, returning nil when there is none
+func ParseChecksumHeaders(header http.Header) (*ExpectedChecksum, error) {
+	checksum := &ExpectedChecksum{}
+	if value := header.Get(ContentMD5Header); value != "" {
+		digest, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(digest) != md5.Size {
+			return nil, NewStorageError(ErrorCodeUploadFailed, "invalid Content-MD5 header")
+		}
+		checksum.MD5 = digest
+	}
+	if value := header.Get(ChecksumSHA256Header); value != "" {
+		digest, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(digest) != sha256.Size {
+			digest, err = hex.DecodeString(value)
+		}
+		if err != nil || len(digest) != sha256.Size {
+			return nil, NewStorageError(ErrorCodeUploadFailed, "invalid x-checksum-sha256 header")
+		}
+		checksum.SHA256 = digest
+	}
+	if checksum.MD5 == nil && checksum.SHA256 == nil {
+		return nil, nil
+	}
+	return checksum, nil
+}
+
+// contentMD5 returns the MD5 digest in the format of Content-MD5, empty when not checked
+func (c *ExpectedChecksum) contentMD5() string {
+	if c == nil || c.MD5 == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(c.MD5)
+}
+
+// checksumSHA256 returns the SHA-256 digest in base64, empty when not checked
+func (c *ExpectedChecksum) checksumSHA256() string {
+	if c == nil || c.SHA256 == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(c.SHA256)
+}
+
+// expectedChecksum returns the checksum the content of an upload must match, or nil
+func (m *FileMetadata) expectedChecksum() *ExpectedChecksum {
+	if m == nil {
+		return nil
+	}
+	return m.ExpectedChecksum
+}
+
+// checksumReader hashes the content of an upload as it is read and fails the read that
+// reaches its end when the content does not match the expected checksum, so providers
+// discard it like any other failed upload
+type checksumReader struct {
+	r        io.Reader
+	path     string
+	expected *ExpectedChecksum
+	md5      hash.Hash
+	sha256   hash.Hash
+	err      error
+}
+
+// newChecksumReader wraps the content of an upload to path when metadata expects a
+// checksum, returning reader unchanged and a nil checksumReader otherwise
+func newChecksumReader(reader io.Reader, path string, metadata *FileMetadata) (io.Reader, *checksumReader) {
+	expected := metadata.expectedChecksum()
+	if expected == nil {
+		return reader, nil
+	}
+	r := &checksumReader{r: reader, path: path, expected: expected}
+	if expected.MD5 != nil {
+		r.md5 = md5.New()
+	}
+	if expected.SHA256 != nil {
+		r.sha256 = sha256.New()
+	}
+	return r, r
+}
+
+// Read reads from the underlying reader, checking the checksum at the end of the content
+func (r *checksumReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.r.Read(p)
+	for _, h := range []hash.Hash{r.md5, r.sha256} {
+		if h != nil {
+			h.Write(p[:n])
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		r.err = r.verify()
+		if r.err != nil {
+			return n, r.err
+		}
+	}
+	return n, err
+}
+
+// Unwrap returns the underlying reader, so its size can still be known
+func (r *checksumReader) Unwrap() io.Reader {
+	return r.r
+}
+
+// verify compares the digests of the content read with the expected ones
+func (r *checksumReader) verify() error {
+	if r.md5 != nil && !bytes.Equal(r.md5.Sum(nil), r.expected.MD5) {
+		return UploadChecksumMismatchError(r.path, "MD5 differs", nil)
+	}
+	if r.sha256 != nil && !bytes.Equal(r.sha256.Sum(nil), r.expected.SHA256) {
+		return UploadChecksumMismatchError(r.path, "SHA-256 differs", nil)
+	}
+	return nil
+}
+
+// failure returns the checksum mismatch in place of the error a provider reported for
+// the upload it caused
+func (r *checksumReader) failure(err error) error {
+	if r != nil && r.err != nil {
+		return r.err
+	}
+	return err
+}
+
+// verifyContent checks content read whole against an expected checksum, for uploads
+// whose stored content is not the one the client hashed
+func verifyContent(reader io.Reader, path string, expected *ExpectedChecksum) error {
+	r, verifier := newChecksumReader(reader, path, &FileMetadata{ExpectedChecksum: expected})
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return verifier.failure(NewStorageErrorWithCause(ErrorCodeUploadFailed, "failed to read uploaded file", err))
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package vsaasstorage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestParseChecksumHeaders(t *testing.T) {
+	md5Sum := md5.Sum([]byte("clip"))
+	sha256Sum := sha256.Sum256([]byte("clip"))
+
+	header := http.Header{}
+	if checksum, err := ParseChecksumHeaders(header); checksum != nil || err != nil {
+		t.Errorf("Expected no checksum without headers, got %v %v", checksum, err)
+	}
+
+	header.Set(ContentMD5Header, base64.StdEncoding.EncodeToString(md5Sum[:]))
+	header.Set(ChecksumSHA256Header, hex.EncodeToString(sha256Sum[:]))
+	checksum, err := ParseChecksumHeaders(header)
+	if err != nil || string(checksum.MD5) != string(md5Sum[:]) || string(checksum.SHA256) != string(sha256Sum[:]) {
+		t.Fatalf("Expected both digests, got %+v %v", checksum, err)
+	}
+	header.Set(ChecksumSHA256Header, base64.StdEncoding.EncodeToString(sha256Sum[:]))
+	if checksum, err := ParseChecksumHeaders(header); err != nil || string(checksum.SHA256) != string(sha256Sum[:]) {
+		t.Errorf("Expected a base64 SHA-256, got %+v %v", checksum, err)
+	}
+
+	for name, value := range map[string]string{ContentMD5Header: hex.EncodeToString(md5Sum[:]), ChecksumSHA256Header: "abc"} {
+		header := http.Header{}
+		header.Set(name, value)
+		var storageErr *StorageError
+		if _, err := ParseChecksumHeaders(header); !errors.As(err, &storageErr) || storageErr.Code != ErrorCodeUploadFailed {
+			t.Errorf("Expected %s %q to be rejected, got %v", name, value, err)
+		}
+	}
+}
+
+func TestUploadExpectedChecksum(t *testing.T) {
+	ctx := context.Background()
+	sum := md5.Sum([]byte("clip"))
+	expected := &ExpectedChecksum{MD5: sum[:]}
+
+	for _, provider := range []string{"filesystem", "memory"} {
+		t.Run(provider, func(t *testing.T) {
+			config := &StorageConfig{Name: "test", Provider: provider}
+			if provider == "filesystem" {
+				config.FileSystem = &FileSystemConfig{BasePath: t.TempDir()}
+			}
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			storage.Upload(ctx, "clips/a.mp4", strings.NewReader("old"), nil)
+
+			_, err = storage.Upload(ctx, "clips/a.mp4", strings.NewReader("clop"), &FileMetadata{ExpectedChecksum: expected})
+			var storageErr *StorageError
+			if !errors.As(err, &storageErr) || storageErr.Code != ErrorCodeChecksumMismatch || storageErr.HTTPStatus() != http.StatusUnprocessableEntity {
+				t.Fatalf("Expected a 422 checksum mismatch, got %v", err)
+			}
+			if data, _, _ := storage.DownloadBytes(ctx, "clips/a.mp4"); string(data) != "old" {
+				t.Errorf("Expected the previous content kept, got %q", data)
+			}
+			if _, err := storage.UploadIfMatch(ctx, "clips/b.mp4", strings.NewReader("clop"), &FileMetadata{ExpectedChecksum: expected}, ""); !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("Expected a conditional upload to be checked too, got %v", err)
+			}
+			if exists, _ := storage.Exists(ctx, "clips/b.mp4"); exists {
+				t.Error("Expected nothing stored for a corrupt conditional upload")
+			}
+
+			if _, err := storage.Upload(ctx, "clips/a.mp4", strings.NewReader("clip"), &FileMetadata{ExpectedChecksum: expected}); err != nil {
+				t.Errorf("Expected matching content to be stored, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadHandlerChecksum(t *testing.T) {
+	storage, err := New(&StorageConfig{Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir()}})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	source := filepath.Join(t.TempDir(), "upload.tmp")
+	os.WriteFile(source, []byte("clip"), 0644)
+	sum := md5.Sum([]byte("clip"))
+	contentMD5 := base64.StdEncoding.EncodeToString(sum[:])
+
+	upload := func(contentMD5 string, files ...string) (*httptest.ResponseRecorder, error) {
+		request := httptest.NewRequest(http.MethodPost, "/upload", nil)
+		request.Header.Set(ContentMD5Header, contentMD5)
+		recorder := httptest.NewRecorder()
+		uploaded := map[string][]*rest.UploadedFile{}
+		for _, name := range files {
+			uploaded[name] = []*rest.UploadedFile{{Path: source, Filename: name + ".mp4", OriginalName: name + ".mp4", Size: 4}}
+		}
+		err := storage.UploadHandler("uploads")(&rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder), UploadedFiles: uploaded})
+		return recorder, err
+	}
+
+	t.Run("Match", func(t *testing.T) {
+		recorder, err := upload(contentMD5, "file")
+		if err != nil || recorder.Code != http.StatusOK {
+			t.Fatalf("Expected the upload to succeed, got %d %v", recorder.Code, err)
+		}
+		var body struct {
+			Files []UploadedFileResult `json:"files"`
+		}
+		json.Unmarshal(recorder.Body.Bytes(), &body)
+		if len(body.Files) != 1 || body.Files[0].ContentMD5 != contentMD5 {
+			t.Fatalf("Expected the checksum echoed, got %s", recorder.Body.String())
+		}
+		if etag := recorder.Header().Get("ETag"); etag != `"`+hex.EncodeToString(sum[:])+`"` {
+			t.Errorf("Expected the ETag of the file, got %q", etag)
+		}
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		other := md5.Sum([]byte("clop"))
+		_, err := upload(base64.StdEncoding.EncodeToString(other[:]), "file")
+		var httpErr *echo.HTTPError
+		if !errors.As(err, &httpErr) || httpErr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("Expected a 422, got %v", err)
+		}
+		if body, _ := httpErr.Message.(map[string]interface{}); body["code"] != ErrorCodeChecksumMismatch {
+			t.Errorf("Expected the CHECKSUM_MISMATCH code, got %v", httpErr.Message)
+		}
+		files, _ := storage.List(context.Background(), "uploads")
+		if len(files) != 1 {
+			t.Errorf("Expected the corrupt upload not to be stored, got %d files", len(files))
+		}
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		other := md5.Sum([]byte("clop"))
+		request := httptest.NewRequest(http.MethodPost, "/upload", nil)
+		request.Header.Set(ContentMD5Header, base64.StdEncoding.EncodeToString(other[:]))
+		request.Header.Set("Accept", "text/event-stream")
+		recorder := httptest.NewRecorder()
+		c := &rest.EndpointContext{
+			EchoCtx:       echo.New().NewContext(request, recorder),
+			UploadedFiles: map[string][]*rest.UploadedFile{"file": {{Path: source, Filename: "a.mp4", OriginalName: "a.mp4", Size: 4}}},
+		}
+		if err := storage.UploadHandler("uploads")(c); err != nil {
+			t.Fatalf("UploadHandler failed: %v", err)
+		}
+		body, _ := io.ReadAll(recorder.Body)
+		if !strings.Contains(string(body), "event: error") || !strings.Contains(string(body), `"status":422`) {
+			t.Errorf("Expected an error event with status 422, got %s", body)
+		}
+	})
+
+	t.Run("MultipleFiles", func(t *testing.T) {
+		_, err := upload(contentMD5, "a", "b")
+		var httpErr *echo.HTTPError
+		if !errors.As(err, &httpErr) || httpErr.Code != http.StatusBadRequest {
+			t.Errorf("Expected a checksum for several files to be refused, got %v", err)
+		}
+	})
+}
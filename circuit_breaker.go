@@ -0,0 +1,312 @@
+package vsaasstorage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// CircuitState represents the state of a circuit breaker
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+// CircuitBreakerConfig contains configuration for the circuit breaker decorator
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `json:"failureThreshold"` // Consecutive failures that open the circuit
+	OpenDuration     time.Duration `json:"openDuration"`     // Time the circuit stays open before probing
+	HalfOpenProbes   int           `json:"halfOpenProbes"`   // Successful probes required to close the circuit
+
+	// IsFailure decides whether an error counts against the backend (defaults to transient errors)
+	IsFailure func(err error) bool `json:"-"`
+	// OnStateChange is called after every state transition
+	OnStateChange func(from, to CircuitState) `json:"-"`
+}
+
+// withDefaults returns a copy of the configuration with zero values replaced by defaults
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 5
+	}
+	if c.OpenDuration <= 0 {
+		c.OpenDuration = 30 * time.Second
+	}
+	if c.HalfOpenProbes <= 0 {
+		c.HalfOpenProbes = 1
+	}
+	if c.IsFailure == nil {
+		c.IsFailure = RetryPolicy{}.withDefaults().retryable
+	}
+	return c
+}
+
+// CircuitOpenError is returned while the circuit is open
+func CircuitOpenError() *StorageError {
+	return NewStorageError(ErrorCodeProviderError, "circuit open")
+}
+
+// CircuitBreakerProvider wraps a StorageProvider short-circuiting calls while the backend is failing
+type CircuitBreakerProvider struct {
+	inner  StorageProvider
+	config CircuitBreakerConfig
+
+	mu             sync.Mutex
+	state          CircuitState
+	failures       int
+	openedAt       time.Time
+	probesInFlight int
+	probeSuccesses int
+	transitions    [][2]CircuitState
+	now            func() time.Time
+}
+
+// NewCircuitBreakerProvider creates a new circuit breaker around the inner provider
+func NewCircuitBreakerProvider(inner StorageProvider, config CircuitBreakerConfig) *CircuitBreakerProvider {
+	return &CircuitBreakerProvider{
+		inner:  inner,
+		config: config.withDefaults(),
+		state:  CircuitClosed,
+		now:    time.Now,
+	}
+}
+
+// Unwrap returns the wrapped provider
+func (p *CircuitBreakerProvider) Unwrap() StorageProvider {
+	return p.inner
+}
+
+// State returns the current state of the circuit
+func (p *CircuitBreakerProvider) State() CircuitState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == CircuitOpen && p.now().Sub(p.openedAt) >= p.config.OpenDuration {
+		return CircuitHalfOpen
+	}
+	return p.state
+}
+
+// setState transitions the circuit and queues a notification. Must be called with the lock held.
+func (p *CircuitBreakerProvider) setState(state CircuitState) {
+	if p.state == state {
+		return
+	}
+
+	from := p.state
+	p.state = state
+	p.failures = 0
+	p.probesInFlight = 0
+	p.probeSuccesses = 0
+	if state == CircuitOpen {
+		p.openedAt = p.now()
+	}
+
+	p.transitions = append(p.transitions, [2]CircuitState{from, state})
+}
+
+// unlock releases the lock and delivers queued state change notifications
+func (p *CircuitBreakerProvider) unlock() {
+	transitions := p.transitions
+	p.transitions = nil
+	p.mu.Unlock()
+
+	if p.config.OnStateChange == nil {
+		return
+	}
+	for _, transition := range transitions {
+		p.config.OnStateChange(transition[0], transition[1])
+	}
+}
+
+// allow reports whether a call may proceed and whether it is a half-open probe
+func (p *CircuitBreakerProvider) allow() (bool, bool) {
+	p.mu.Lock()
+	defer p.unlock()
+
+	if p.state == CircuitOpen {
+		if p.now().Sub(p.openedAt) < p.config.OpenDuration {
+			return false, false
+		}
+		p.setState(CircuitHalfOpen)
+	}
+
+	if p.state == CircuitHalfOpen {
+		if p.probesInFlight+p.probeSuccesses >= p.config.HalfOpenProbes {
+			return false, false
+		}
+		p.probesInFlight++
+		return true, true
+	}
+
+	return true, false
+}
+
+// record updates the circuit with the outcome of a call
+func (p *CircuitBreakerProvider) record(probe bool, err error) {
+	p.mu.Lock()
+	defer p.unlock()
+
+	failed := err != nil && p.config.IsFailure(err)
+
+	if probe {
+		if p.state != CircuitHalfOpen {
+			return
+		}
+		p.probesInFlight--
+		if failed {
+			p.setState(CircuitOpen)
+			return
+		}
+		p.probeSuccesses++
+		if p.probeSuccesses >= p.config.HalfOpenProbes {
+			p.setState(CircuitClosed)
+		}
+		return
+	}
+
+	if p.state != CircuitClosed {
+		return
+	}
+
+	if !failed {
+		p.failures = 0
+		return
+	}
+
+	p.failures++
+	if p.failures >= p.config.FailureThreshold {
+		p.setState(CircuitOpen)
+	}
+}
+
+// call runs fn through the circuit
+func (p *CircuitBreakerProvider) call(fn func() error) error {
+	allowed, probe := p.allow()
+	if !allowed {
+		return CircuitOpenError()
+	}
+
+	err := fn()
+	p.record(probe, err)
+	return err
+}
+
+// Upload uploads a file through the circuit
+func (p *CircuitBreakerProvider) Upload(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata) (*FileInfo, error) {
+	var info *FileInfo
+	err := p.call(func() error {
+		var err error
+		info, err = p.inner.Upload(ctx, path, reader, metadata)
+		return err
+	})
+	return info, err
+}
+
+// Download downloads a file through the circuit
+func (p *CircuitBreakerProvider) Download(ctx context.Context, path string) (io.ReadCloser, *FileInfo, error) {
+	var reader io.ReadCloser
+	var info *FileInfo
+	err := p.call(func() error {
+		var err error
+		reader, info, err = p.inner.Download(ctx, path)
+		return err
+	})
+	return reader, info, err
+}
+
+// Delete deletes a file through the circuit
+func (p *CircuitBreakerProvider) Delete(ctx context.Context, path string) error {
+	return p.call(func() error {
+		return p.inner.Delete(ctx, path)
+	})
+}
+
+// Exists checks if a file exists through the circuit
+func (p *CircuitBreakerProvider) Exists(ctx context.Context, path string) (bool, error) {
+	var exists bool
+	err := p.call(func() error {
+		var err error
+		exists, err = p.inner.Exists(ctx, path)
+		return err
+	})
+	return exists, err
+}
+
+// GetInfo gets information about a file through the circuit
+func (p *CircuitBreakerProvider) GetInfo(ctx context.Context, path string) (*FileInfo, error) {
+	var info *FileInfo
+	err := p.call(func() error {
+		var err error
+		info, err = p.inner.GetInfo(ctx, path)
+		return err
+	})
+	return info, err
+}
+
+// List lists files in a directory through the circuit
+func (p *CircuitBreakerProvider) List(ctx context.Context, path string) ([]*FileInfo, error) {
+	var files []*FileInfo
+	err := p.call(func() error {
+		var err error
+		files, err = p.inner.List(ctx, path)
+		return err
+	})
+	return files, err
+}
+
+// DeleteDirectory deletes a directory through the circuit
+func (p *CircuitBreakerProvider) DeleteDirectory(ctx context.Context, path string) error {
+	return p.call(func() error {
+		return p.inner.DeleteDirectory(ctx, path)
+	})
+}
+
+// Copy copies a file through the circuit
+func (p *CircuitBreakerProvider) Copy(ctx context.Context, srcPath, dstPath string) error {
+	return p.call(func() error {
+		return p.inner.Copy(ctx, srcPath, dstPath)
+	})
+}
+
+// Move moves a file through the circuit
+func (p *CircuitBreakerProvider) Move(ctx context.Context, srcPath, dstPath string) error {
+	return p.call(func() error {
+		return p.inner.Move(ctx, srcPath, dstPath)
+	})
+}
+
+// GenerateSignedURL generates a signed URL using the inner provider.
+// Signing does not contact the backend, so it bypasses the circuit.
+func (p *CircuitBreakerProvider) GenerateSignedURL(ctx context.Context, path string, operation SignedURLOperation, expiresIn time.Duration) (string, error) {
+	return p.inner.GenerateSignedURL(ctx, path, operation, expiresIn)
+}
+
+// circuitBreakerConfig returns the configured circuit breaker reporting state changes to the config hooks
+func (c *StorageConfig) circuitBreakerConfig() CircuitBreakerConfig {
+	breaker := *c.CircuitBreaker
+	onStateChange := breaker.OnStateChange
+	breaker.OnStateChange = func(from, to CircuitState) {
+		level := LogLevelInfo
+		if to == CircuitOpen {
+			level = LogLevelError
+		}
+		c.log(context.Background(), level, "storage circuit breaker state changed", map[string]interface{}{
+			"storage": c.Name,
+			"from":    string(from),
+			"to":      string(to),
+		})
+		c.incCounter("storage_circuit_transitions_total", 1, map[string]string{
+			"storage": c.Name,
+			"state":   string(to),
+		})
+		if onStateChange != nil {
+			onStateChange(from, to)
+		}
+	}
+	return breaker
+}
@@ -0,0 +1,167 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerProvider(t *testing.T) {
+	ctx := context.Background()
+
+	newBreaker := func(fake *fakeProvider) (*CircuitBreakerProvider, *time.Time, *[]CircuitState) {
+		now := time.Now()
+		var transitions []CircuitState
+		breaker := NewCircuitBreakerProvider(fake, CircuitBreakerConfig{
+			FailureThreshold: 2,
+			OpenDuration:     time.Minute,
+			HalfOpenProbes:   2,
+			OnStateChange: func(from, to CircuitState) {
+				transitions = append(transitions, to)
+			},
+		})
+		breaker.now = func() time.Time { return now }
+		return breaker, &now, &transitions
+	}
+
+	t.Run("Opens after consecutive failures", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("get_info", errTransient, errTransient)
+		breaker, _, transitions := newBreaker(fake)
+
+		breaker.GetInfo(ctx, "a.txt")
+		if breaker.State() != CircuitClosed {
+			t.Fatalf("Expected closed circuit after one failure, got %s", breaker.State())
+		}
+
+		breaker.GetInfo(ctx, "a.txt")
+		if breaker.State() != CircuitOpen {
+			t.Fatalf("Expected open circuit, got %s", breaker.State())
+		}
+
+		// Calls fail fast without reaching the provider
+		_, err := breaker.GetInfo(ctx, "a.txt")
+		if !errors.Is(err, CircuitOpenError()) {
+			t.Errorf("Expected circuit open error, got %v", err)
+		}
+
+		if fake.callCount("get_info") != 2 {
+			t.Errorf("Expected 2 provider calls, got %d", fake.callCount("get_info"))
+		}
+
+		if len(*transitions) != 1 || (*transitions)[0] != CircuitOpen {
+			t.Errorf("Expected a single transition to open, got %v", *transitions)
+		}
+	})
+
+	t.Run("Success resets the failure count", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("get_info", errTransient, nil, errTransient)
+		breaker, _, _ := newBreaker(fake)
+
+		for i := 0; i < 3; i++ {
+			breaker.GetInfo(ctx, "a.txt")
+		}
+
+		if breaker.State() != CircuitClosed {
+			t.Errorf("Expected closed circuit, got %s", breaker.State())
+		}
+	})
+
+	t.Run("Non-transient errors do not count", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("get_info", FileNotFoundError("a.txt"), FileNotFoundError("a.txt"))
+		breaker, _, _ := newBreaker(fake)
+
+		breaker.GetInfo(ctx, "a.txt")
+		breaker.GetInfo(ctx, "a.txt")
+
+		if breaker.State() != CircuitClosed {
+			t.Errorf("Expected closed circuit, got %s", breaker.State())
+		}
+	})
+
+	t.Run("Closes after successful probes", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("exists", errTransient, errTransient)
+		breaker, now, transitions := newBreaker(fake)
+
+		breaker.Exists(ctx, "a.txt")
+		breaker.Exists(ctx, "a.txt")
+		if breaker.State() != CircuitOpen {
+			t.Fatalf("Expected open circuit, got %s", breaker.State())
+		}
+
+		*now = now.Add(time.Minute)
+		if breaker.State() != CircuitHalfOpen {
+			t.Fatalf("Expected half-open circuit, got %s", breaker.State())
+		}
+
+		if _, err := breaker.Exists(ctx, "a.txt"); err != nil {
+			t.Fatalf("First probe failed: %v", err)
+		}
+
+		if breaker.State() != CircuitHalfOpen {
+			t.Fatalf("Expected circuit to stay half-open after one probe, got %s", breaker.State())
+		}
+
+		if _, err := breaker.Exists(ctx, "a.txt"); err != nil {
+			t.Fatalf("Second probe failed: %v", err)
+		}
+
+		if breaker.State() != CircuitClosed {
+			t.Fatalf("Expected closed circuit, got %s", breaker.State())
+		}
+
+		expected := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitClosed}
+		if len(*transitions) != len(expected) {
+			t.Fatalf("Expected transitions %v, got %v", expected, *transitions)
+		}
+		for i, state := range expected {
+			if (*transitions)[i] != state {
+				t.Errorf("Expected transition %d to be %s, got %s", i, state, (*transitions)[i])
+			}
+		}
+	})
+
+	t.Run("Failed probe reopens the circuit", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("exists", errTransient, errTransient, errTransient)
+		breaker, now, _ := newBreaker(fake)
+
+		breaker.Exists(ctx, "a.txt")
+		breaker.Exists(ctx, "a.txt")
+
+		*now = now.Add(time.Minute)
+		breaker.Exists(ctx, "a.txt")
+
+		if breaker.State() != CircuitOpen {
+			t.Errorf("Expected open circuit after failed probe, got %s", breaker.State())
+		}
+	})
+}
+
+func TestCircuitBreakerConfigWiring(t *testing.T) {
+	storage, err := New(&StorageConfig{
+		Name:     "TestStorage",
+		Provider: "filesystem",
+		FileSystem: &FileSystemConfig{
+			BasePath: t.TempDir(),
+		},
+		Retry:          &RetryPolicy{MaxAttempts: 2},
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 3},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	breaker, ok := storage.provider.(*CircuitBreakerProvider)
+	if !ok {
+		t.Fatalf("Expected circuit breaker provider, got %T", storage.provider)
+	}
+
+	if _, ok := breaker.Unwrap().(*RetryingProvider); !ok {
+		t.Errorf("Expected circuit breaker to wrap the retrying provider, got %T", breaker.Unwrap())
+	}
+}
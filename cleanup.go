@@ -0,0 +1,69 @@
+package vsaasstorage
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultOrphanAge is how old temporary data must be before StartCleanupWorker removes
+// it, long enough for slow uploads of large videos to finish
+const DefaultOrphanAge = 24 * time.Hour
+
+// CleanupReport counts what CleanupOrphans removed, per category
+type CleanupReport struct {
+	TempFiles        int `json:"temp_files"`        // Temporary files left by interrupted uploads
+	MultipartUploads int `json:"multipart_uploads"` // Incomplete multipart uploads aborted
+	Claims           int `json:"claims"`            // Reservations of generated upload names never released
+}
+
+// OrphanCleanupProvider is implemented by providers that can leave temporary data behind
+// when a process crashes in the middle of an upload
+type OrphanCleanupProvider interface {
+	CleanupOrphans(ctx context.Context, olderThan time.Duration) (*CleanupReport, error)
+}
+
+// CleanupOrphans removes temporary data older than olderThan that interrupted uploads
+// left behind: temporary files on the filesystem, incomplete multipart uploads on S3,
+// which are billed until aborted, and the claims reserving generated upload names on
+// every provider.
+func (s *Storage) CleanupOrphans(ctx context.Context, olderThan time.Duration) (*CleanupReport, error) {
+	if err := s.checkWritable(""); err != nil {
+		return nil, err
+	}
+
+	report := &CleanupReport{}
+	if provider, ok := providerAs[OrphanCleanupProvider](s.provider); ok {
+		var err error
+		if report, err = provider.CleanupOrphans(ctx, olderThan); err != nil {
+			return report, err
+		}
+	}
+
+	claims, err := s.cleanupClaims(ctx, olderThan)
+	report.Claims = claims
+	return report, err
+}
+
+// StartCleanupWorker runs CleanupOrphans with DefaultOrphanAge in the background every
+// interval, with the same jitter as StartExpirationWorker. It stops when ctx is cancelled.
+func (s *Storage) StartCleanupWorker(ctx context.Context, interval time.Duration) {
+	go runEvery(ctx, s.config.clock(), interval, func() {
+		report, err := s.CleanupOrphans(ctx, DefaultOrphanAge)
+		if err != nil && ctx.Err() == nil {
+			s.config.log(ctx, LogLevelError, "orphan cleanup failed", map[string]interface{}{
+				"storage": s.config.Name,
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		if report != nil && report.TempFiles+report.MultipartUploads+report.Claims > 0 {
+			s.config.log(ctx, LogLevelInfo, "orphaned uploads removed", map[string]interface{}{
+				"storage":           s.config.Name,
+				"temp_files":        report.TempFiles,
+				"multipart_uploads": report.MultipartUploads,
+				"claims":            report.Claims,
+			})
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleanupOrphans(t *testing.T) {
+	ctx := context.Background()
+	fs, mem := newTransferStorages(t)
+	base := fs.config.FileSystem.BasePath
+
+	old := time.Now().Add(-2 * time.Hour)
+	write := func(rel string, modTime time.Time) string {
+		full := filepath.Join(base, rel)
+		os.MkdirAll(filepath.Dir(full), 0755)
+		os.WriteFile(full, []byte("partial"), 0644)
+		os.Chtimes(full, modTime, modTime)
+		return full
+	}
+
+	staleUpload := write(".blobs/tmp/upload-1", old)
+	freshUpload := write(".blobs/tmp/upload-2", time.Now().Add(time.Hour))
+	staleLink := write("cameras/1/.tmp-clip.mp4.123.link", old)
+	fs.Upload(ctx, "cameras/1/clip.mp4", strings.NewReader("video"), nil)
+	fs.Upload(ctx, "docs/.shortcut.link", strings.NewReader("user"), nil)
+	fs.Upload(ctx, "docs/.x.upload", strings.NewReader("user"), nil)
+	if _, err := fs.Upload(ctx, "docs/.tmp-x.upload", strings.NewReader("user"), nil); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Expected uploads to the names of temporary files to be rejected, got %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	report, err := fs.CleanupOrphans(ctx, time.Millisecond)
+	if err != nil {
+		t.Fatalf("CleanupOrphans failed: %v", err)
+	}
+	if report.TempFiles != 2 || report.MultipartUploads != 0 {
+		t.Errorf("Expected 2 temp files removed, got %+v", report)
+	}
+
+	for _, path := range []string{staleUpload, staleLink} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", path)
+		}
+	}
+	if _, err := os.Stat(freshUpload); err != nil {
+		t.Error("Expected an upload younger than the threshold to be kept")
+	}
+	for _, path := range []string{"cameras/1/clip.mp4", "docs/.shortcut.link", "docs/.x.upload"} {
+		if exists, _ := fs.Exists(ctx, path); !exists {
+			t.Errorf("Expected %s to be kept", path)
+		}
+	}
+
+	report, err = mem.CleanupOrphans(ctx, time.Hour)
+	if err != nil || report.TempFiles != 0 {
+		t.Errorf("Expected an empty report from the memory provider, got %+v, %v", report, err)
+	}
+}
+
+func TestCleanupWorker(t *testing.T) {
+	fs, _ := newTransferStorages(t)
+	stale := filepath.Join(fs.config.FileSystem.BasePath, ".blobs", "tmp", "upload-1")
+	os.MkdirAll(filepath.Dir(stale), 0755)
+	os.WriteFile(stale, []byte("partial"), 0644)
+	old := time.Now().Add(-2 * DefaultOrphanAge)
+	os.Chtimes(stale, old, old)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fs.StartCleanupWorker(ctx, 5*time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(stale); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("Expected the worker to remove the stale upload")
+}
@@ -0,0 +1,39 @@
+package vsaasstorage
+
+import "time"
+
+// Clock tells the storage the time. It decides when signed URLs, leases, idempotency keys
+// and expiring files run out, how old trashed files and stale uploads are, and when the
+// background workers run. StorageConfig.Clock replaces the real clock, e.g. in tests.
+type Clock interface {
+	Now() time.Time
+	// After delivers the time on the channel once d has passed, like time.After
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock of the system
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// clockOrReal returns c, or the real clock when it is nil
+func clockOrReal(c Clock) Clock {
+	if c == nil {
+		return realClock{}
+	}
+	return c
+}
+
+// clock returns the configured clock, or the real clock without a configuration
+func (c *StorageConfig) clock() Clock {
+	if c == nil {
+		return realClock{}
+	}
+	return clockOrReal(c.Clock)
+}
+
+// now returns the current time of the configured clock
+func (c *StorageConfig) now() time.Time {
+	return c.clock().Now()
+}
@@ -0,0 +1,111 @@
+package vsaasstorage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ArchiveRestoreProvider is implemented by providers whose objects can be moved to archive
+// storage classes, such as S3 Glacier, where they must be restored before they can be read
+type ArchiveRestoreProvider interface {
+	// RestoreFromArchive requests a temporary readable copy of an archived object for days
+	RestoreFromArchive(ctx context.Context, path string, days int, tier string) error
+	// GetRestoreStatus reports whether an object is archived and how its restore is going
+	GetRestoreStatus(ctx context.Context, path string) (*RestoreStatus, error)
+}
+
+// Retrieval tiers of RestoreFromArchive, from fastest and most expensive to slowest and
+// cheapest. Deep archive classes do not support RestoreTierExpedited.
+const (
+	RestoreTierExpedited = "Expedited"
+	RestoreTierStandard  = "Standard"
+	RestoreTierBulk      = "Bulk"
+)
+
+// RestoreStatus is the archive state of an object
+type RestoreStatus struct {
+	Archived   bool       `json:"archived"`             // The object is in an archive storage class
+	InProgress bool       `json:"in_progress"`          // A restore was requested and is not ready yet
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // When the restored copy is removed again
+}
+
+// Readable reports whether the object can be downloaded now: it is not archived, or a
+// restored copy is available
+func (r *RestoreStatus) Readable() bool {
+	return !r.Archived || (!r.InProgress && r.ExpiresAt != nil)
+}
+
+// RestoreFromArchive requests a temporary copy of an archived file that can be downloaded
+// for days, retrieved with the given tier (RestoreTierStandard if empty). Restores take
+// from minutes to hours depending on the tier; GetRestoreStatus reports when the copy is
+// ready. Providers without archive storage classes return ErrNotSupported.
+func (s *Storage) RestoreFromArchive(ctx context.Context, path string, days int, tier string) error {
+	if days < 1 {
+		return NewStorageError(ErrorCodeInvalidConfig, "restore days must be positive")
+	}
+	if tier == "" {
+		tier = RestoreTierStandard
+	}
+	switch tier {
+	case RestoreTierExpedited, RestoreTierStandard, RestoreTierBulk:
+	default:
+		return NewStorageError(ErrorCodeInvalidConfig, fmt.Sprintf("unknown restore tier %q", tier))
+	}
+
+	if err := checkFilePath(path); err != nil {
+		return err
+	}
+	path = s.extensionPath(path)
+
+	provider, ok := providerAs[ArchiveRestoreProvider](s.provider)
+	if !ok {
+		return NotSupportedError("archive restores are not supported by the provider")
+	}
+	return provider.RestoreFromArchive(ctx, path, days, tier)
+}
+
+// GetRestoreStatus returns the archive state of a file and of its restore, if any
+func (s *Storage) GetRestoreStatus(ctx context.Context, path string) (*RestoreStatus, error) {
+	if err := checkFilePath(path); err != nil {
+		return nil, err
+	}
+	path = s.extensionPath(path)
+
+	provider, ok := providerAs[ArchiveRestoreProvider](s.provider)
+	if !ok {
+		return nil, NotSupportedError("archive restores are not supported by the provider")
+	}
+	return provider.GetRestoreStatus(ctx, path)
+}
+
+// parseRestoreHeader parses the x-amz-restore header of an archived object, e.g.
+// ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT". An empty header
+// means no restore was requested.
+func parseRestoreHeader(header string) (*RestoreStatus, error) {
+	status := &RestoreStatus{Archived: true}
+	for rest := strings.TrimSpace(header); rest != ""; {
+		key, value, ok := strings.Cut(rest, `="`)
+		if !ok {
+			return nil, fmt.Errorf("malformed restore header %q", header)
+		}
+		value, rest, ok = strings.Cut(value, `"`)
+		if !ok {
+			return nil, fmt.Errorf("malformed restore header %q", header)
+		}
+		rest = strings.TrimLeft(rest, ", ")
+
+		switch strings.TrimSpace(key) {
+		case "ongoing-request":
+			status.InProgress = value == "true"
+		case "expiry-date":
+			expiresAt, err := time.Parse(time.RFC1123, value)
+			if err != nil {
+				return nil, fmt.Errorf("malformed restore expiry %q: %w", value, err)
+			}
+			status.ExpiresAt = &expiresAt
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,151 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// archivingProvider keeps some files in an archive storage class, refusing to download
+// them until they are restored
+type archivingProvider struct {
+	StorageProvider
+	archived map[string]*RestoreStatus
+}
+
+func (p *archivingProvider) Download(ctx context.Context, path string) (io.ReadCloser, *FileInfo, error) {
+	if status, ok := p.archived[path]; ok && !status.Readable() {
+		return nil, nil, ArchivedError(path, nil)
+	}
+	return p.StorageProvider.Download(ctx, path)
+}
+
+func (p *archivingProvider) RestoreFromArchive(ctx context.Context, path string, days int, tier string) error {
+	if status, ok := p.archived[path]; ok {
+		expiresAt := time.Now().AddDate(0, 0, days)
+		status.ExpiresAt = &expiresAt
+	}
+	return nil
+}
+
+func (p *archivingProvider) GetRestoreStatus(ctx context.Context, path string) (*RestoreStatus, error) {
+	if status, ok := p.archived[path]; ok {
+		return status, nil
+	}
+	return &RestoreStatus{}, nil
+}
+
+func TestRestoreFromArchive(t *testing.T) {
+	ctx := context.Background()
+	provider := &archivingProvider{archived: map[string]*RestoreStatus{"clips/old.mp4": {Archived: true}}}
+	RegisterProvider("archiving", func(config *StorageConfig) (StorageProvider, error) {
+		inner, err := NewMemoryProvider(config)
+		provider.StorageProvider = inner
+		return provider, err
+	})
+	storage, err := New(&StorageConfig{Name: "test", Provider: "archiving"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.Upload(ctx, "clips/old.mp4", strings.NewReader("old footage"), nil)
+
+	if !storage.Capabilities().ArchiveRestore {
+		t.Error("Expected the archive restore capability")
+	}
+	if _, _, err := storage.Download(ctx, "clips/old.mp4"); !errors.Is(err, ErrArchived) {
+		t.Fatalf("Expected ErrArchived, got %v", err)
+	}
+
+	// Handlers answer 409 and tell the client to request a restore
+	request := httptest.NewRequest(http.MethodGet, "/files?path=clips/old.mp4", nil)
+	c := &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, httptest.NewRecorder())}
+	var httpErr *echo.HTTPError
+	if err := storage.DownloadHandler()(c); !errors.As(err, &httpErr) || httpErr.Code != http.StatusConflict {
+		t.Fatalf("Expected a 409, got %v", err)
+	}
+	body, _ := json.Marshal(httpErr.Message)
+	if !strings.Contains(string(body), `"code":"ARCHIVED"`) || !strings.Contains(string(body), `"restore_required":true`) {
+		t.Errorf("Expected a machine-readable body, got %s", body)
+	}
+
+	for _, tt := range []struct {
+		days int
+		tier string
+	}{{0, RestoreTierStandard}, {1, "Instant"}} {
+		if err := storage.RestoreFromArchive(ctx, "clips/old.mp4", tt.days, tt.tier); err == nil {
+			t.Errorf("Expected %d days with tier %q to be refused", tt.days, tt.tier)
+		}
+	}
+
+	status, err := storage.GetRestoreStatus(ctx, "clips/old.mp4")
+	if err != nil || !status.Archived || status.Readable() {
+		t.Fatalf("Expected an archived, unreadable file, got %+v, %v", status, err)
+	}
+	if err := storage.RestoreFromArchive(ctx, "/clips//old.mp4", 7, ""); err != nil {
+		t.Fatalf("RestoreFromArchive failed: %v", err)
+	}
+	if status, _ := storage.GetRestoreStatus(ctx, "clips/old.mp4"); !status.Readable() {
+		t.Errorf("Expected the restored copy to be readable, got %+v", status)
+	}
+	if data, _, err := storage.DownloadBytes(ctx, "clips/old.mp4"); err != nil || string(data) != "old footage" {
+		t.Errorf("Expected the restored content, got %q, %v", data, err)
+	}
+
+	t.Run("Unsupported", func(t *testing.T) {
+		storage, err := New(&StorageConfig{Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir()}})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		if storage.Capabilities().ArchiveRestore {
+			t.Error("Expected filesystem storage not to report archive restores")
+		}
+		if err := storage.RestoreFromArchive(ctx, "clips/old.mp4", 1, ""); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported, got %v", err)
+		}
+		if _, err := storage.GetRestoreStatus(ctx, "clips/old.mp4"); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported, got %v", err)
+		}
+	})
+}
+
+func TestParseRestoreHeader(t *testing.T) {
+	expiry := time.Date(2012, 12, 21, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header     string
+		inProgress bool
+		expiresAt  *time.Time
+		readable   bool
+	}{
+		{"", false, nil, false},
+		{`ongoing-request="true"`, true, nil, false},
+		{`ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`, false, &expiry, true},
+	}
+	for _, tt := range tests {
+		status, err := parseRestoreHeader(tt.header)
+		if err != nil {
+			t.Errorf("Header %q: %v", tt.header, err)
+			continue
+		}
+		if !status.Archived || status.InProgress != tt.inProgress || status.Readable() != tt.readable {
+			t.Errorf("Header %q: unexpected status %+v", tt.header, status)
+		}
+		if (status.ExpiresAt == nil) != (tt.expiresAt == nil) || (tt.expiresAt != nil && !status.ExpiresAt.Equal(*tt.expiresAt)) {
+			t.Errorf("Header %q: expected expiry %v, got %v", tt.header, tt.expiresAt, status.ExpiresAt)
+		}
+	}
+
+	for _, header := range []string{`ongoing-request`, `ongoing-request="false`, `expiry-date="yesterday"`} {
+		if _, err := parseRestoreHeader(header); err == nil {
+			t.Errorf("Expected header %q to be malformed", header)
+		}
+	}
+}
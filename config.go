@@ -1,17 +1,116 @@
 package vsaasstorage
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
+// redactedValue replaces secret values in redacted output
+const redactedValue = "***"
+
 // StorageConfig represents the unified configuration for all storage providers
 type StorageConfig struct {
 	Name       string            `json:"name"`
-	Provider   string            `json:"provider"` // "filesystem", "s3"
+	Provider   string            `json:"provider"` // "filesystem", "s3", "memory" or a registered provider
 	FileSystem *FileSystemConfig `json:"filesystem,omitempty"`
 	S3         *S3Config         `json:"s3,omitempty"`
 	SignedURL  *SignedURLConfig  `json:"signedUrl,omitempty"`
+	CDNSigning *CDNSigningConfig `json:"cdnSigning,omitempty"` // Sign GET URLs for CloudFront instead of the provider
+	Retry      *RetryPolicy      `json:"retry,omitempty"`      // Retry transient provider errors when set
+
+	CircuitBreaker  *CircuitBreakerConfig `json:"circuitBreaker,omitempty"`  // Short-circuit calls while the backend is down
+	Trash           *TrashConfig          `json:"trash,omitempty"`           // Soft delete into a trash area
+	Quota           *QuotaConfig          `json:"quota,omitempty"`           // Per-prefix storage limits
+	Versioning      *VersioningConfig     `json:"versioning,omitempty"`      // Keep previous versions of overwritten files
+	Idempotency     *IdempotencyConfig    `json:"idempotency,omitempty"`     // Replay uploads retried with the same idempotency key
+	UploadMemory    *UploadMemoryConfig   `json:"uploadMemory,omitempty"`    // Refuse uploads with a 503 beyond a budget of bytes in progress
+	Poster          *PosterConfig         `json:"poster,omitempty"`          // Create a JPEG poster for videos uploaded through the handlers
+	CopyBufferSize  int                   `json:"copyBufferSize,omitempty"`  // Buffer size for streaming copies, defaults to 256KB
+	ComputeChecksum *bool                 `json:"computeChecksum,omitempty"` // Hash uploads with MD5 for the ETag, defaults to true
+	Concurrency     int                   `json:"concurrency,omitempty"`     // Parallel operations in directory and batch operations, defaults to 8
+	ReadOnly        bool                  `json:"readOnly,omitempty"`        // Refuse every operation that modifies the storage
+	StripEXIF       bool                  `json:"stripExif,omitempty"`       // Remove EXIF and XMP from JPEG and PNG uploads received through the handlers
+	ExtractEXIF     bool                  `json:"extractExif,omitempty"`     // Return the removed time, GPS and camera fields in UploadedFileResult.EXIF
+	FaststartRemux  bool                  `json:"faststartRemux,omitempty"`  // Send the moov box first when streaming MP4s that are not faststart
+
+	// SeekableMemoryThreshold is the size up to which OpenSeekable keeps a download in
+	// memory on providers without range reads before spilling it to a temporary file,
+	// defaults to 8MB
+	SeekableMemoryThreshold int64 `json:"seekableMemoryThreshold,omitempty"`
+
+	PathNormalization PathNormalization `json:"pathNormalization,omitempty"` // "nfc" (default) or "none"
+	MaxNameLength     int               `json:"maxNameLength,omitempty"`     // Bytes per path component, defaults to 249 on filesystem; -1 disables
+	MaxPathLength     int               `json:"maxPathLength,omitempty"`     // Bytes per path, defaults to 4096 on filesystem and 1024 on S3; -1 disables
+
+	ListDetailsConcurrency int    `json:"listDetailsConcurrency,omitempty"` // Parallel GetInfo calls or checksums when listing with ETags or metadata, defaults to 16
+	UploadConcurrency      int    `json:"uploadConcurrency,omitempty"`      // Files of a multi-file upload stored in parallel, defaults to 4
+	BatchListThreshold     int    `json:"batchListThreshold,omitempty"`     // Paths of one directory from which ExistsMany and GetInfoMany list it instead, defaults to 32; -1 disables
+	PublicBaseURL          string `json:"publicBaseURL,omitempty"`          // Base of the public (e.g. CDN) URLs built by PublicURL
+	UploadLayout           string `json:"uploadLayout,omitempty"`           // Template placing handler uploads under their directory, e.g. LayoutDate; flat when empty
+
+	// MinDeleteDepth refuses DeleteDirectory on paths with fewer segments, e.g. 2 protects
+	// "cameras" but not "cameras/cam42", unless DeleteOptions.Force is set. The root is
+	// always protected.
+	MinDeleteDepth int `json:"minDeleteDepth,omitempty"`
+
+	// LegacyDeleteResponse makes DeleteHandler answer 200 with a JSON body instead of 204
+	// No Content, for clients written against earlier versions
+	LegacyDeleteResponse bool `json:"legacyDeleteResponse,omitempty"`
+
+	// DirectoryDownloads selects what the download handler answers for a directory:
+	// "index" (default) lists it like ListHandler, or a zip with ?format=zip, and
+	// "reject" answers 409 with the IS_DIRECTORY code
+	DirectoryDownloads DirectoryDownloads `json:"directoryDownloads,omitempty"`
+
+	// LegacyPaths returns paths in FileInfo and upload results as the caller passed them
+	// instead of in canonical form. Deprecated: it will be removed in the next release.
+	LegacyPaths bool `json:"legacyPaths,omitempty"`
+
+	// VerboseTokenErrors names the failed check, e.g. expired or path_mismatch, in the 401
+	// responses to signed downloads. Off by default so clients cannot probe tokens.
+	VerboseTokenErrors bool `json:"verboseTokenErrors,omitempty"`
+
+	Logger  Logger  `json:"-"` // Optional sink for log entries
+	Metrics Metrics `json:"-"` // Optional sink for counters and gauges
+	Scanner Scanner `json:"-"` // Optional content scanner for uploads received through the handlers
+	Clock   Clock   `json:"-"` // Optional source of the time for expirations and workers, the system clock by default
+
+	AccessRecorder AccessRecorder `json:"-"` // Optional sink for download events from StreamFile and DownloadHandler
+	Journal        JournalWriter  `json:"-"` // Optional append-only record of successful mutations, replayed with ReplayJournal
+
+	// PreUpload approves, moves or renames the files received by UploadHandler and
+	// UploadFromCtx before they are stored, see PreUploadInterceptor
+	PreUpload PreUploadInterceptor `json:"-"`
+
+	// Audit records who deleted, moved or restored what. With AuditMandatory operations
+	// that cannot be recorded are not performed, see AuditSink.
+	Audit          AuditSink `json:"-"`
+	AuditMandatory bool      `json:"auditMandatory,omitempty"`
+
+	// DownloadProgress is called every DownloadProgressInterval bytes (1 MiB by default)
+	// of the downloads served by the handlers, and once more when they end, for tracking
+	// very large transfers. bytesTotal is the length of the response body.
+	DownloadProgress         ProgressFunc `json:"-"`
+	DownloadProgressInterval int64        `json:"downloadProgressInterval,omitempty"`
+
+	// DownloadChecksumTrailer sends full (200) downloads chunked with the MD5 of the body
+	// in an X-Checksum trailer, so clients can detect a body cut short by a mid-stream
+	// provider error. Truncated downloads abort the connection either way.
+	DownloadChecksumTrailer bool `json:"downloadChecksumTrailer,omitempty"`
+
+	// LeaseClockSkew is how long past its embedded expiry a lease is still honored before
+	// another owner may take it over, to tolerate clocks that disagree between hosts.
+	// Defaults to DefaultLeaseClockSkew.
+	LeaseClockSkew time.Duration `json:"leaseClockSkew,omitempty"`
+
+	// Authorizer is called by every handler before it touches the provider; an error
+	// answers 403. With SignedTokensSkipAuthorizer, downloads with a valid signed token
+	// are not checked, since the token is the authorization.
+	Authorizer                 Authorizer `json:"-"`
+	SignedTokensSkipAuthorizer bool       `json:"signedTokensSkipAuthorizer,omitempty"`
 }
 
 // FileSystemConfig contains configuration for filesystem provider
@@ -19,6 +118,25 @@ type FileSystemConfig struct {
 	BasePath    string `json:"basePath"`    // Base directory path
 	CreateDirs  bool   `json:"createDirs"`  // Automatically create directories
 	Permissions string `json:"permissions"` // File permissions (e.g., "0755")
+	Deduplicate bool   `json:"deduplicate"` // Store identical content once under .blobs, hardlinked from each path
+
+	SyncWrites     SyncWritesMode `json:"syncWrites,omitempty"`     // "none" (default), "file" or "file+dir"
+	PruneEmptyDirs bool           `json:"pruneEmptyDirs,omitempty"` // Remove parent directories left empty by Delete and Move
+
+	// CaseInsensitiveCheck rejects uploads whose name differs only by case from an existing
+	// entry, such as "Report.pdf" next to "report.pdf", which shadow each other over SMB or macOS
+	CaseInsensitiveCheck bool `json:"caseInsensitiveCheck,omitempty"`
+
+	// MinFreeBytes fails uploads with ErrInsufficientStorage (HTTP 507) before they would
+	// leave less than this space available on the volume, so the disk never fills up
+	MinFreeBytes int64 `json:"minFreeBytes,omitempty"`
+
+	// OffloadHeader hands downloads over to the web server in front: "X-Accel-Redirect"
+	// (nginx) sends the path of the file under OffloadPrefix, the internal location that
+	// serves BasePath, and "X-Sendfile" (Apache, lighttpd) its absolute path. Empty serves
+	// the content from the process.
+	OffloadHeader string `json:"offloadHeader,omitempty"`
+	OffloadPrefix string `json:"offloadPrefix,omitempty"` // Internal location for X-Accel-Redirect, e.g. "/protected"
 }
 
 // S3Config contains configuration for S3 provider
@@ -34,6 +152,7 @@ type S3Config struct {
 	DefaultUploadParams map[string]interface{} `json:"defaultUploadParams,omitempty"` // Default parameters for uploads
 	MaxRetries          int                    `json:"maxRetries"`
 	HTTPOptions         *HTTPOptions           `json:"httpOptions,omitempty"`
+	Versioning          bool                   `json:"versioning,omitempty"` // The bucket has versioning enabled; versions are read from S3 instead of the .versions prefix
 }
 
 // HTTPOptions contains HTTP-specific options
@@ -48,6 +167,21 @@ type SignedURLConfig struct {
 	Enabled   bool          `json:"enabled"`
 	ExpiresIn time.Duration `json:"expiresIn"` // Default expiration time
 	SecretKey string        `json:"secretKey"` // Secret key for JWT signing (filesystem)
+
+	// Keys are named secrets restricted to some operations and paths, chosen with
+	// SignedURLOptions.KeyName. Tokens are validated with the key named in their kid
+	// header, or with SecretKey when they have none.
+	Keys []SigningKey `json:"keys,omitempty"`
+}
+
+// SigningKey is a secret for signed URLs scoped to what the service holding it needs,
+// e.g. GET for a viewer portal and PUT for an ingest service, so a leaked key cannot
+// sign anything else
+type SigningKey struct {
+	Name       string               `json:"name"`                 // Sent as the kid header of the tokens it signs
+	Secret     string               `json:"secret"`               // Secret key for JWT signing
+	Operations []SignedURLOperation `json:"operations"`           // Operations it may sign
+	PathPrefix string               `json:"pathPrefix,omitempty"` // Directory its tokens are limited to, every path when empty
 }
 
 // Validate validates the storage configuration
@@ -60,6 +194,53 @@ func (c *StorageConfig) Validate() error {
 		return errors.New("provider is required")
 	}
 
+	switch c.PathNormalization {
+	case "", PathNormalizationNFC, PathNormalizationNone:
+	default:
+		return errors.New("pathNormalization must be nfc or none")
+	}
+
+	switch c.DirectoryDownloads {
+	case "", DirectoryDownloadIndex, DirectoryDownloadReject:
+	default:
+		return errors.New("directoryDownloads must be index or reject")
+	}
+
+	// A mandatory audit without a sink would silently record nothing
+	if c.AuditMandatory && c.Audit == nil {
+		return errors.New("auditMandatory requires an audit sink")
+	}
+
+	if c.PublicBaseURL != "" {
+		if err := validatePublicBaseURL(c.PublicBaseURL); err != nil {
+			return err
+		}
+	}
+
+	if c.UploadLayout != "" {
+		if _, err := ParsePathLayout(c.UploadLayout); err != nil {
+			return err
+		}
+	}
+
+	if c.SignedURL != nil {
+		if err := c.SignedURL.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.CDNSigning != nil {
+		if err := c.CDNSigning.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.UploadMemory != nil {
+		if err := c.UploadMemory.Validate(); err != nil {
+			return err
+		}
+	}
+
 	switch c.Provider {
 	case "filesystem":
 		if c.FileSystem == nil {
@@ -71,7 +252,12 @@ func (c *StorageConfig) Validate() error {
 			return errors.New("s3 configuration is required when provider is s3")
 		}
 		return c.S3.Validate()
+	case "memory":
+		return nil
 	default:
+		if _, ok := lookupProvider(c.Provider); ok {
+			return nil
+		}
 		return errors.New("unsupported provider: " + c.Provider)
 	}
 }
@@ -81,6 +267,20 @@ func (c *FileSystemConfig) Validate() error {
 	if c.BasePath == "" {
 		return errors.New("basePath is required for filesystem provider")
 	}
+	switch c.SyncWrites {
+	case "", SyncWritesNone, SyncWritesFile, SyncWritesFileAndDir:
+	default:
+		return errors.New("syncWrites must be none, file or file+dir")
+	}
+	switch c.OffloadHeader {
+	case "", OffloadHeaderSendfile:
+	case OffloadHeaderAccelRedirect:
+		if !strings.HasPrefix(c.OffloadPrefix, "/") {
+			return errors.New("offloadPrefix must be an absolute location for X-Accel-Redirect")
+		}
+	default:
+		return errors.New("offloadHeader must be X-Accel-Redirect or X-Sendfile")
+	}
 	return nil
 }
 
@@ -117,3 +317,219 @@ func (c *StorageConfig) GetSignedURLConfig() *SignedURLConfig {
 
 	return &config
 }
+
+// checksumEnabled reports whether an upload should be hashed for its ETag. The
+// upload's metadata overrides the storage setting.
+func (c *StorageConfig) checksumEnabled(metadata *FileMetadata) bool {
+	if metadata != nil && metadata.ComputeChecksum != nil {
+		return *metadata.ComputeChecksum
+	}
+	if c == nil || c.ComputeChecksum == nil {
+		return true
+	}
+	return *c.ComputeChecksum
+}
+
+// GetCopyBufferSize returns the buffer size used for streaming copies
+func (c *StorageConfig) GetCopyBufferSize() int {
+	if c == nil || c.CopyBufferSize <= 0 {
+		return DefaultCopyBufferSize
+	}
+	return c.CopyBufferSize
+}
+
+// GetSeekableMemoryThreshold returns the bytes OpenSeekable keeps in memory, or the default
+func (c *StorageConfig) GetSeekableMemoryThreshold() int64 {
+	if c == nil || c.SeekableMemoryThreshold <= 0 {
+		return DefaultSeekableMemoryThreshold
+	}
+	return c.SeekableMemoryThreshold
+}
+
+// Clone returns a deep copy of the storage configuration
+func (c *StorageConfig) Clone() *StorageConfig {
+	if c == nil {
+		return nil
+	}
+
+	clone := *c
+	if c.FileSystem != nil {
+		fs := *c.FileSystem
+		clone.FileSystem = &fs
+	}
+	if c.S3 != nil {
+		s3 := *c.S3
+		if c.S3.DefaultUploadParams != nil {
+			s3.DefaultUploadParams = make(map[string]interface{}, len(c.S3.DefaultUploadParams))
+			for k, v := range c.S3.DefaultUploadParams {
+				s3.DefaultUploadParams[k] = v
+			}
+		}
+		if c.S3.HTTPOptions != nil {
+			httpOptions := *c.S3.HTTPOptions
+			s3.HTTPOptions = &httpOptions
+		}
+		clone.S3 = &s3
+	}
+	if c.SignedURL != nil {
+		signedURL := *c.SignedURL
+		if c.SignedURL.Keys != nil {
+			signedURL.Keys = make([]SigningKey, len(c.SignedURL.Keys))
+			for i, key := range c.SignedURL.Keys {
+				key.Operations = append([]SignedURLOperation(nil), key.Operations...)
+				signedURL.Keys[i] = key
+			}
+		}
+		clone.SignedURL = &signedURL
+	}
+	if c.CDNSigning != nil {
+		cdnSigning := *c.CDNSigning
+		clone.CDNSigning = &cdnSigning
+	}
+	if c.Retry != nil {
+		retry := *c.Retry
+		retry.RetryableCodes = append([]ErrorCode(nil), c.Retry.RetryableCodes...)
+		clone.Retry = &retry
+	}
+	if c.CircuitBreaker != nil {
+		breaker := *c.CircuitBreaker
+		clone.CircuitBreaker = &breaker
+	}
+	if c.Trash != nil {
+		trash := *c.Trash
+		clone.Trash = &trash
+	}
+	if c.Versioning != nil {
+		versioning := *c.Versioning
+		clone.Versioning = &versioning
+	}
+	if c.ComputeChecksum != nil {
+		computeChecksum := *c.ComputeChecksum
+		clone.ComputeChecksum = &computeChecksum
+	}
+	if c.Quota != nil {
+		quota := *c.Quota
+		if c.Quota.Limits != nil {
+			quota.Limits = make(map[string]int64, len(c.Quota.Limits))
+			for prefix, limit := range c.Quota.Limits {
+				quota.Limits[prefix] = limit
+			}
+		}
+		clone.Quota = &quota
+	}
+	if c.Idempotency != nil {
+		idempotency := *c.Idempotency
+		clone.Idempotency = &idempotency
+	}
+	if c.UploadMemory != nil {
+		uploadMemory := *c.UploadMemory
+		clone.UploadMemory = &uploadMemory
+	}
+	if c.Poster != nil {
+		poster := *c.Poster
+		clone.Poster = &poster
+	}
+
+	return &clone
+}
+
+// Redacted returns a deep copy of the configuration with secret fields masked
+func (c *StorageConfig) Redacted() *StorageConfig {
+	clone := c.Clone()
+	if clone == nil {
+		return nil
+	}
+
+	if clone.S3 != nil {
+		clone.S3.SecretAccessKey = redactSecret(clone.S3.SecretAccessKey)
+		clone.S3.SessionToken = redactSecret(clone.S3.SessionToken)
+	}
+	if clone.SignedURL != nil {
+		clone.SignedURL.SecretKey = redactSecret(clone.SignedURL.SecretKey)
+		for i := range clone.SignedURL.Keys {
+			clone.SignedURL.Keys[i].Secret = redactSecret(clone.SignedURL.Keys[i].Secret)
+		}
+	}
+	if clone.CDNSigning != nil {
+		clone.CDNSigning.PrivateKeyPEM = redactSecret(clone.CDNSigning.PrivateKeyPEM)
+	}
+
+	return clone
+}
+
+// String implements fmt.Stringer without exposing secrets
+func (c S3Config) String() string {
+	type plain S3Config
+	redacted := plain(c)
+	redacted.SecretAccessKey = redactSecret(c.SecretAccessKey)
+	redacted.SessionToken = redactSecret(c.SessionToken)
+	return fmt.Sprintf("%+v", redacted)
+}
+
+// MarshalJSON implements json.Marshaler masking secret fields
+func (c S3Config) MarshalJSON() ([]byte, error) {
+	type plain S3Config
+	redacted := plain(c)
+	redacted.SecretAccessKey = redactSecret(c.SecretAccessKey)
+	redacted.SessionToken = redactSecret(c.SessionToken)
+	return json.Marshal(redacted)
+}
+
+// String implements fmt.Stringer without exposing secrets
+func (c SignedURLConfig) String() string {
+	type plain SignedURLConfig
+	redacted := plain(c)
+	redacted.SecretKey = redactSecret(c.SecretKey)
+	return fmt.Sprintf("%+v", redacted)
+}
+
+// MarshalJSON implements json.Marshaler masking secret fields
+func (c SignedURLConfig) MarshalJSON() ([]byte, error) {
+	type plain SignedURLConfig
+	redacted := plain(c)
+	redacted.SecretKey = redactSecret(c.SecretKey)
+	return json.Marshal(redacted)
+}
+
+// String implements fmt.Stringer without exposing secrets
+func (k SigningKey) String() string {
+	type plain SigningKey
+	redacted := plain(k)
+	redacted.Secret = redactSecret(k.Secret)
+	return fmt.Sprintf("%+v", redacted)
+}
+
+// MarshalJSON implements json.Marshaler masking secret fields
+func (k SigningKey) MarshalJSON() ([]byte, error) {
+	type plain SigningKey
+	redacted := plain(k)
+	redacted.Secret = redactSecret(k.Secret)
+	return json.Marshal(redacted)
+}
+
+// Validate validates the signed URL configuration
+func (c *SignedURLConfig) Validate() error {
+	names := make(map[string]bool, len(c.Keys))
+	for _, key := range c.Keys {
+		switch {
+		case key.Name == "":
+			return errors.New("signing keys require a name")
+		case names[key.Name]:
+			return fmt.Errorf("duplicate signing key %q", key.Name)
+		case key.Secret == "":
+			return fmt.Errorf("signing key %q requires a secret", key.Name)
+		case len(key.Operations) == 0:
+			return fmt.Errorf("signing key %q requires at least one operation", key.Name)
+		}
+		names[key.Name] = true
+	}
+	return nil
+}
+
+// redactSecret masks a non-empty secret value
+func redactSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedValue
+}
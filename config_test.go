@@ -0,0 +1,164 @@
+package vsaasstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSecretConfig() *StorageConfig {
+	return &StorageConfig{
+		Name:     "SecretStorage",
+		Provider: "s3",
+		S3: &S3Config{
+			Region:          "us-east-1",
+			Bucket:          "bucket",
+			AccessKeyID:     "AKIAEXAMPLE",
+			SecretAccessKey: "super-secret-access-key",
+			SessionToken:    "super-secret-session-token",
+			DefaultUploadParams: map[string]interface{}{
+				"CacheControl": "max-age=300",
+			},
+			HTTPOptions: &HTTPOptions{Timeout: 1000},
+		},
+		SignedURL: &SignedURLConfig{
+			Enabled:   true,
+			ExpiresIn: 5 * time.Minute,
+			SecretKey: "super-secret-signing-key",
+		},
+		CDNSigning: &CDNSigningConfig{
+			KeyPairID:     "K2JCJMDEHXQW5F",
+			PrivateKeyPEM: "super-secret-cdn-private-key",
+			BaseURL:       "https://d111111abcdef8.cloudfront.net",
+		},
+	}
+}
+
+var configSecrets = []string{
+	"super-secret-access-key",
+	"super-secret-session-token",
+	"super-secret-signing-key",
+	"super-secret-cdn-private-key",
+}
+
+func TestConfigRedaction(t *testing.T) {
+	t.Run("MarshalJSON masks secrets", func(t *testing.T) {
+		config := newSecretConfig()
+
+		data, err := json.Marshal(config)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+
+		for _, secret := range configSecrets {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("Serialized config contains secret '%s': %s", secret, data)
+			}
+		}
+
+		if !strings.Contains(string(data), redactedValue) {
+			t.Errorf("Expected serialized config to contain '%s', got %s", redactedValue, data)
+		}
+
+		// Marshaling must not mutate the live config
+		if config.S3.SecretAccessKey != "super-secret-access-key" {
+			t.Error("MarshalJSON mutated the original config")
+		}
+	})
+
+	t.Run("Redacted masks secrets", func(t *testing.T) {
+		config := newSecretConfig()
+		redacted := config.Redacted()
+
+		if redacted.S3.SecretAccessKey != redactedValue {
+			t.Errorf("Expected redacted secret access key, got '%s'", redacted.S3.SecretAccessKey)
+		}
+
+		if redacted.S3.SessionToken != redactedValue {
+			t.Errorf("Expected redacted session token, got '%s'", redacted.S3.SessionToken)
+		}
+
+		if redacted.SignedURL.SecretKey != redactedValue {
+			t.Errorf("Expected redacted signing key, got '%s'", redacted.SignedURL.SecretKey)
+		}
+
+		if redacted.CDNSigning.PrivateKeyPEM != redactedValue {
+			t.Errorf("Expected redacted CDN private key, got '%s'", redacted.CDNSigning.PrivateKeyPEM)
+		}
+
+		if redacted.S3.AccessKeyID != "AKIAEXAMPLE" {
+			t.Errorf("Access key ID should not be redacted, got '%s'", redacted.S3.AccessKeyID)
+		}
+
+		if config.S3.SecretAccessKey != "super-secret-access-key" {
+			t.Error("Redacted mutated the original config")
+		}
+	})
+
+	t.Run("String masks secrets", func(t *testing.T) {
+		config := newSecretConfig()
+
+		output := fmt.Sprint(*config.S3) + fmt.Sprint(*config.SignedURL) + fmt.Sprint(config.S3) + fmt.Sprint(config.SignedURL) +
+			fmt.Sprint(*config.CDNSigning) + fmt.Sprint(config.CDNSigning)
+		for _, secret := range configSecrets {
+			if strings.Contains(output, secret) {
+				t.Errorf("String output contains secret '%s': %s", secret, output)
+			}
+		}
+	})
+
+	t.Run("Empty secrets stay empty", func(t *testing.T) {
+		config := &StorageConfig{
+			Name:      "NoSecrets",
+			Provider:  "filesystem",
+			SignedURL: &SignedURLConfig{Enabled: false},
+		}
+
+		if redacted := config.Redacted(); redacted.SignedURL.SecretKey != "" {
+			t.Errorf("Expected empty secret to remain empty, got '%s'", redacted.SignedURL.SecretKey)
+		}
+	})
+}
+
+func TestGetConfigReturnsCopy(t *testing.T) {
+	testDir := filepath.Join(os.TempDir(), "vsaas-storage-config-test")
+	defer os.RemoveAll(testDir)
+
+	storage, err := New(&StorageConfig{
+		Name:     "TestStorage",
+		Provider: "filesystem",
+		FileSystem: &FileSystemConfig{
+			BasePath:   testDir,
+			CreateDirs: true,
+		},
+		SignedURL: &SignedURLConfig{
+			Enabled:   true,
+			SecretKey: "test-secret-key",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	config := storage.GetConfig()
+	config.Name = "Mutated"
+	config.FileSystem.BasePath = "/mutated"
+	config.SignedURL.SecretKey = "mutated"
+
+	current := storage.GetConfig()
+	if current.Name != "TestStorage" {
+		t.Errorf("Expected name 'TestStorage', got '%s'", current.Name)
+	}
+
+	if current.FileSystem.BasePath != testDir {
+		t.Errorf("Expected base path '%s', got '%s'", testDir, current.FileSystem.BasePath)
+	}
+
+	if current.SignedURL.SecretKey != "test-secret-key" {
+		t.Error("Mutating the returned config changed the running signing key")
+	}
+}
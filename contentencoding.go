@@ -0,0 +1,90 @@
+package vsaasstorage
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// identityETagSuffix marks the ETag of gzip content served decoded, which is another
+// representation than the stored bytes
+const identityETagSuffix = ".identity"
+
+// acceptsEncoding reports whether an Accept-Encoding header allows a content coding,
+// named or through "*". A q of 0 refuses it.
+func acceptsEncoding(header, encoding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		accepted := true
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				accepted = err == nil && q > 0
+			}
+		}
+		switch {
+		case name == encoding, encoding == "gzip" && name == "x-gzip":
+			return accepted
+		case name == "*":
+			wildcard = accepted
+		}
+	}
+	return wildcard
+}
+
+// withoutRange returns the request without its Range and If-Range headers, so encoded
+// content is always sent whole: a range of the encoded bytes cannot be decoded
+func withoutRange(request *http.Request) *http.Request {
+	if request.Header.Get("Range") == "" {
+		return request
+	}
+	request = request.Clone(request.Context())
+	request.Header.Del("Range")
+	request.Header.Del("If-Range")
+	return request
+}
+
+// noneMatch reports whether an If-None-Match header lists etag, or "*"
+func noneMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag != "" && NormalizeETag(tag) == NormalizeETag(etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDecoded streams gzip content decompressed, for clients that do not accept gzip.
+// The decoded length is unknown, so the body is sent without Content-Length and ranges
+// are answered with the whole content.
+func writeDecoded(writer *contentWriter, request *http.Request, content io.Reader, etag string, modTime time.Time) error {
+	decoded, err := gzip.NewReader(content)
+	if err != nil {
+		return NewStorageErrorWithCause(ErrorCodeDownloadFailed, "invalid gzip content", err)
+	}
+	defer decoded.Close()
+
+	header := writer.Header()
+	header.Del("Content-Length")
+	if !modTime.IsZero() {
+		header.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	if etag != "" && noneMatch(request.Header.Get("If-None-Match"), etag) {
+		header.Del("Content-Type")
+		writer.WriteHeader(http.StatusNotModified)
+		return writer.finish()
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	if request.Method != http.MethodHead {
+		writer.ReadFrom(decoded)
+	}
+	return writer.finish()
+}
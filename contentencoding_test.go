@@ -0,0 +1,98 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestContentEncodingNegotiation(t *testing.T) {
+	ctx := context.Background()
+	document := []byte(`{"cameras": [1, 2, 3], "exported": true}`)
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(document)
+	gz.Close()
+
+	configs := map[string]*StorageConfig{
+		"filesystem": {Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir()}},
+		"memory":     {Name: "test", Provider: "memory"},
+	}
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			metadata := &FileMetadata{ContentType: "application/json", ContentEncoding: "gzip"}
+			if _, err := storage.Upload(ctx, "exports/cameras.json", bytes.NewReader(compressed.Bytes()), metadata); err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+			if info, _ := storage.GetInfo(ctx, "exports/cameras.json"); info.ContentEncoding != "gzip" {
+				t.Fatalf("Expected the encoding to be persisted, got %q", info.ContentEncoding)
+			}
+
+			download := func(headers map[string]string) *httptest.ResponseRecorder {
+				request := httptest.NewRequest(http.MethodGet, "/download/exports/cameras.json", nil)
+				for name, value := range headers {
+					request.Header.Set(name, value)
+				}
+				recorder := httptest.NewRecorder()
+				c := &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder)}
+				if err := storage.StreamFile(c, "exports/cameras.json"); err != nil {
+					t.Fatalf("StreamFile failed: %v", err)
+				}
+				return recorder
+			}
+
+			t.Run("Accepted", func(t *testing.T) {
+				recorder := download(map[string]string{"Accept-Encoding": "br, gzip;q=0.8"})
+				if recorder.Header().Get("Content-Encoding") != "gzip" || !bytes.Equal(recorder.Body.Bytes(), compressed.Bytes()) {
+					t.Errorf("Expected the stored bytes with Content-Encoding, got %v", recorder.Header())
+				}
+				if recorder.Header().Get("Content-Length") != strconv.Itoa(compressed.Len()) {
+					t.Errorf("Expected the compressed length, got %q", recorder.Header().Get("Content-Length"))
+				}
+				if recorder.Header().Get("Vary") != "Accept-Encoding" {
+					t.Errorf("Expected Vary: Accept-Encoding, got %q", recorder.Header().Get("Vary"))
+				}
+			})
+
+			t.Run("Decoded", func(t *testing.T) {
+				for _, accept := range []string{"", "identity", "gzip;q=0, br"} {
+					recorder := download(map[string]string{"Accept-Encoding": accept})
+					if recorder.Code != http.StatusOK || !bytes.Equal(recorder.Body.Bytes(), document) {
+						t.Fatalf("Accept-Encoding %q: expected the decoded document, got %d %q", accept, recorder.Code, recorder.Body.String())
+					}
+					if recorder.Header().Get("Content-Encoding") != "" || recorder.Header().Get("Content-Length") != "" {
+						t.Errorf("Expected neither Content-Encoding nor Content-Length, got %v", recorder.Header())
+					}
+				}
+			})
+
+			t.Run("Range", func(t *testing.T) {
+				recorder := download(map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-3"})
+				if recorder.Code != http.StatusOK || !bytes.Equal(recorder.Body.Bytes(), compressed.Bytes()) {
+					t.Errorf("Expected a full 200 for a range of encoded content, got %d", recorder.Code)
+				}
+				recorder = download(map[string]string{"Range": "bytes=0-3"})
+				if recorder.Code != http.StatusOK || !bytes.Equal(recorder.Body.Bytes(), document) {
+					t.Errorf("Expected a full decoded 200, got %d", recorder.Code)
+				}
+			})
+		})
+	}
+
+	for header, want := range map[string]bool{"gzip": true, "GZIP;q=1": true, "x-gzip": true, "*": true, "*, gzip;q=0": false, "br": false, "": false} {
+		if got := acceptsEncoding(header, "gzip"); got != want {
+			t.Errorf("acceptsEncoding(%q) = %v, expected %v", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+)
+
+// checkContext fails with a CanceledError once ctx is done, so operations stop before
+// touching the backend for a request that is already gone
+func checkContext(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return CanceledError(path, err)
+	}
+	return nil
+}
+
+// isContextError reports whether err comes from a canceled or timed out context
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
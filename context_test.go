@@ -0,0 +1,54 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+// cancelingReader cancels its context on the first read, like a client that disconnects
+// while its upload is being stored
+type cancelingReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	r.cancel()
+	return r.r.Read(p)
+}
+
+func TestCanceledUpload(t *testing.T) {
+	fsStorage, memStorage := newTransferStorages(t)
+	for name, storage := range map[string]*Storage{"filesystem": fsStorage, "memory": memStorage} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			reader := &cancelingReader{r: io.LimitReader(zeroReader{}, 3*contextCopyChunk), cancel: cancel}
+
+			_, err := storage.Upload(ctx, "large.bin", reader, nil)
+			if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.Canceled) {
+				t.Fatalf("Expected a canceled error, got %v", err)
+			}
+			if exists, _ := storage.Exists(context.Background(), "large.bin"); exists {
+				t.Error("Expected the partial upload to be removed")
+			}
+		})
+	}
+}
+
+func TestCopyContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := &cancelingReader{r: io.LimitReader(zeroReader{}, 3*contextCopyChunk), cancel: cancel}
+
+	written, err := copyContext(ctx, io.Discard, reader, DefaultCopyBufferSize)
+	if !errors.Is(err, context.Canceled) || written != contextCopyChunk {
+		t.Errorf("Expected the copy to stop after one chunk, got %d, %v", written, err)
+	}
+
+	written, err = copyContext(context.Background(), io.Discard, io.LimitReader(zeroReader{}, contextCopyChunk+1), DefaultCopyBufferSize)
+	if err != nil || written != contextCopyChunk+1 {
+		t.Errorf("Expected the whole input, got %d, %v", written, err)
+	}
+}
@@ -0,0 +1,66 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+)
+
+// DeleteMatching deletes the files and directories whose path matches pattern, a glob as
+// in path.Match applied to the whole path, e.g. "cameras/*/2024-01-*/*.mp4". Wildcards do
+// not cross slashes, so only the part of the tree the pattern can reach is walked. Matched
+// directories are deleted with their contents, under the same MinDeleteDepth guard as
+// DeleteDirectory. It returns how many entries were removed; entries under retention are
+// kept and reported in a *DirectoryRetentionError, other failures in a *MultiError.
+func (s *Storage) DeleteMatching(ctx context.Context, pattern string, opts ...DeleteOptions) (int, error) {
+	pattern = cleanPath(pattern)
+	if err := s.checkWritable(pattern); err != nil {
+		return 0, err
+	}
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+		return 0, NewStorageErrorWithCause(ErrorCodeInvalidPath, "invalid pattern", err)
+	}
+
+	segments := strings.Split(pattern, "/")
+	root := globRoot(segments)
+
+	var paths []string
+	directories := make(map[string]bool)
+	err := s.walk(ctx, root, ListOptions{IncludeHidden: true, AllowMissing: true}, func(info *FileInfo) error {
+		depth := strings.Count(info.Path, "/") + 1
+		if depth < len(segments) {
+			// Only descend into directories the rest of the pattern can match below
+			if matched, _ := path.Match(strings.Join(segments[:depth], "/"), info.Path); !matched {
+				return SkipDir
+			}
+			return nil
+		}
+		if matched, _ := path.Match(pattern, info.Path); matched {
+			paths = append(paths, info.Path)
+			directories[info.Path] = info.IsDirectory
+		}
+		return SkipDir
+	})
+	if err != nil && !errors.Is(err, ErrDirectoryNotFound) {
+		return 0, err
+	}
+	if len(paths) == 0 {
+		return 0, nil
+	}
+
+	return s.deleteEntries(ctx, root, paths, directories, mergeDeleteOptions(opts))
+}
+
+// globRoot returns the directory made of the leading segments of a pattern without
+// wildcards, where the walk for its matches starts
+func globRoot(segments []string) string {
+	var literal []string
+	for _, segment := range segments[:len(segments)-1] {
+		if strings.ContainsAny(segment, `*?[\`) {
+			break
+		}
+		literal = append(literal, segment)
+	}
+	return strings.Join(literal, "/")
+}
@@ -0,0 +1,119 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestDeleteDirectoryGuard(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{Name: "test", Provider: "memory", MinDeleteDepth: 2})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	for _, p := range []string{"cameras/cam1/a.mp4", "cameras/cam2/b.mp4", "root.txt"} {
+		storage.Upload(ctx, p, strings.NewReader("x"), nil)
+	}
+
+	for _, dir := range []string{"", "/", "cameras", "/cameras/"} {
+		if err := storage.DeleteDirectory(ctx, dir); !errors.Is(err, ErrForceRequired) {
+			t.Errorf("Expected deleting %q to need force, got %v", dir, err)
+		}
+	}
+	if exists, _ := storage.Exists(ctx, "cameras/cam1/a.mp4"); !exists {
+		t.Fatal("Expected nothing to be deleted")
+	}
+	if err := storage.DeleteDirectory(ctx, "cameras/cam1"); err != nil {
+		t.Errorf("Expected a deep directory to be deleted, got %v", err)
+	}
+
+	t.Run("Handler", func(t *testing.T) {
+		remove := func(query string) (int, error) {
+			request := httptest.NewRequest(http.MethodDelete, "/files?path=cameras&recursive=true"+query, nil)
+			recorder := httptest.NewRecorder()
+			err := storage.DeleteHandler()(&rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder)})
+			return recorder.Code, err
+		}
+
+		_, err := remove("")
+		var httpErr *echo.HTTPError
+		if !errors.As(err, &httpErr) || httpErr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected a 400 without force, got %v", err)
+		}
+		if body, _ := httpErr.Message.(map[string]interface{}); body["code"] != ErrorCodeForceRequired {
+			t.Errorf("Expected the FORCE_REQUIRED code, got %v", httpErr.Message)
+		}
+
+		if code, err := remove("&force=true"); err != nil || code != http.StatusNoContent {
+			t.Fatalf("Expected the directory deleted with force, got %d %v", code, err)
+		}
+		if exists, _ := storage.Exists(ctx, "cameras/cam2/b.mp4"); exists {
+			t.Error("Expected the directory to be gone")
+		}
+	})
+
+	if err := storage.DeleteDirectory(ctx, "", DeleteOptions{Force: true}); err != nil {
+		t.Errorf("Expected the root deleted with force, got %v", err)
+	}
+	if exists, _ := storage.Exists(ctx, "root.txt"); exists {
+		t.Error("Expected the root to be emptied")
+	}
+}
+
+func TestDeleteMatching(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir()}})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	for _, p := range []string{
+		"cameras/cam1/2024-01-01/a.mp4",
+		"cameras/cam1/2024-01-01/a.jpg",
+		"cameras/cam1/2024-02-01/b.mp4",
+		"cameras/cam2/2024-01-15/c.mp4",
+		"cameras/cam2/2024-01-15/nested/d.mp4",
+		"archive/2024-01-01/e.mp4",
+	} {
+		storage.Upload(ctx, p, strings.NewReader("x"), nil)
+	}
+
+	removed, err := storage.DeleteMatching(ctx, "cameras/*/2024-01-*/*.mp4")
+	if err != nil || removed != 2 {
+		t.Fatalf("Expected 2 files removed, got %d, %v", removed, err)
+	}
+	var left []string
+	storage.walk(ctx, "", allEntries, func(info *FileInfo) error {
+		if !info.IsDirectory {
+			left = append(left, info.Path)
+		}
+		return nil
+	})
+	sort.Strings(left)
+	want := "archive/2024-01-01/e.mp4,cameras/cam1/2024-01-01/a.jpg,cameras/cam1/2024-02-01/b.mp4,cameras/cam2/2024-01-15/nested/d.mp4"
+	if strings.Join(left, ",") != want {
+		t.Errorf("Expected only the matches removed, left %v", left)
+	}
+
+	// Matched directories go with their contents
+	if removed, err := storage.DeleteMatching(ctx, "cameras/cam2/*"); err != nil || removed != 1 {
+		t.Errorf("Expected the directory removed, got %d, %v", removed, err)
+	}
+	if exists, _ := storage.Exists(ctx, "cameras/cam2/2024-01-15/nested/d.mp4"); exists {
+		t.Error("Expected the contents of the matched directory to be deleted")
+	}
+
+	if removed, err := storage.DeleteMatching(ctx, "missing/*"); err != nil || removed != 0 {
+		t.Errorf("Expected nothing to match under a missing directory, got %d, %v", removed, err)
+	}
+	if _, err := storage.DeleteMatching(ctx, "cameras/[a-"); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Expected an invalid pattern to be refused, got %v", err)
+	}
+}
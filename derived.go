@@ -0,0 +1,294 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+)
+
+// DerivedSourceMetadataKey records in a derived file the version of the source it was
+// generated from: its ETag, or its size and modification time when it has none
+const DerivedSourceMetadataKey = "vsaas-derived-source"
+
+// DeriveFunc writes to w the file derived from src, e.g. a thumbnail or a transcode,
+// reading the content of src from r. r can seek, as returned by OpenSeekable.
+type DeriveFunc func(ctx context.Context, src *FileInfo, r io.Reader, w io.Writer) error
+
+// DerivationOptions configures a derivation
+type DerivationOptions struct {
+	// StaleWhileRevalidate keeps the derived files of a source that changed: GetDerived
+	// returns the stale file while a fresh one is generated in the background. By default
+	// they are deleted as soon as the source changes and generated on the next GetDerived.
+	StaleWhileRevalidate bool
+	ContentType          string // Content type of the derived files, by their extension when empty
+}
+
+// DerivedFileManager keeps the derivations of a storage: the files generated from others,
+// like posters of videos, which are regenerated when their source changes. Sources
+// uploaded, appended to, copied over or moved away invalidate their derived files, and
+// deleted sources take them along. Derived files of sources changed by other means, e.g.
+// directly on the provider, are detected as stale by GetDerived from the source version
+// they record.
+type DerivedFileManager struct {
+	mu          sync.Mutex
+	derivations map[string]*derivation
+	inflight    map[string]*derivedCall
+}
+
+// derivation is a registered kind of derived file
+type derivation struct {
+	name     string
+	derive   DeriveFunc
+	template string
+	opts     DerivationOptions
+}
+
+// derivedCall is a derived file being generated, shared by concurrent requests for it
+type derivedCall struct {
+	done chan struct{}
+	info *FileInfo
+	err  error
+}
+
+// newDerivedFileManager creates a manager without derivations
+func newDerivedFileManager() *DerivedFileManager {
+	return &DerivedFileManager{
+		derivations: make(map[string]*derivation),
+		inflight:    make(map[string]*derivedCall),
+	}
+}
+
+// RegisterDerivation registers a kind of derived file. pathTemplate places the derived
+// file of each source: {path} is the path of the source, {dir} its directory, {filename}
+// its name, {name} its name without extension and {ext} the extension, e.g.
+// "thumbs/{path}.jpg" or "{dir}/.thumbs/{name}.webp". It must identify the source, with
+// {path} or with {dir} and {filename} or {name}. A name registered again is replaced.
+func (m *DerivedFileManager) RegisterDerivation(name string, derive DeriveFunc, pathTemplate string, opts ...DerivationOptions) error {
+	if name == "" || derive == nil {
+		return errors.New("a derivation requires a name and a derive function")
+	}
+	for _, placeholder := range layoutPlaceholder.FindAllString(pathTemplate, -1) {
+		switch placeholder {
+		case "{path}", "{dir}", "{filename}", "{name}", "{ext}":
+		default:
+			return fmt.Errorf("unknown placeholder %s in derived path %q", placeholder, pathTemplate)
+		}
+	}
+	identified := strings.Contains(pathTemplate, "{path}") ||
+		strings.Contains(pathTemplate, "{dir}") && (strings.Contains(pathTemplate, "{filename}") || strings.Contains(pathTemplate, "{name}"))
+	if !identified {
+		return fmt.Errorf("derived path %q must include {path}, or {dir} with {filename} or {name}", pathTemplate)
+	}
+	if derivedPath(pathTemplate, "a/b.c") == "a/b.c" {
+		return fmt.Errorf("derived path %q is the path of the source", pathTemplate)
+	}
+
+	var options DerivationOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.derivations[name] = &derivation{name: name, derive: derive, template: pathTemplate, opts: options}
+	return nil
+}
+
+// lookup returns a registered derivation
+func (m *DerivedFileManager) lookup(name string) (*derivation, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.derivations[name]
+	return d, ok
+}
+
+// all returns the registered derivations
+func (m *DerivedFileManager) all() []*derivation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	derivations := make([]*derivation, 0, len(m.derivations))
+	for _, d := range m.derivations {
+		derivations = append(derivations, d)
+	}
+	return derivations
+}
+
+// do runs generate once for concurrent calls with the same key, which all get its result.
+// Callers stop waiting when their context is done; the generation goes on for the others.
+func (m *DerivedFileManager) do(ctx context.Context, key string, generate func() (*FileInfo, error)) (*FileInfo, error) {
+	m.mu.Lock()
+	call, ok := m.inflight[key]
+	if !ok {
+		call = &derivedCall{done: make(chan struct{})}
+		m.inflight[key] = call
+		go func() {
+			call.info, call.err = generate()
+			m.mu.Lock()
+			delete(m.inflight, key)
+			m.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	m.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.info, call.err
+	case <-ctx.Done():
+		return nil, CanceledError(key, ctx.Err())
+	}
+}
+
+// derivedPath returns the path of the file derived from srcPath with a template
+func derivedPath(template, srcPath string) string {
+	srcPath = cleanPath(srcPath)
+	dir, filename := path.Split(srcPath)
+	ext := path.Ext(filename)
+	return cleanPath(path.Clean("/" + strings.NewReplacer(
+		"{path}", srcPath,
+		"{dir}", strings.TrimSuffix(dir, "/"),
+		"{filename}", filename,
+		"{name}", strings.TrimSuffix(filename, ext),
+		"{ext}", ext,
+	).Replace(template)))
+}
+
+// sourceVersion identifies the content of a source
+func sourceVersion(info *FileInfo) string {
+	if etag := NormalizeETag(info.ETag); etag != "" {
+		return etag
+	}
+	var modified int64
+	if info.LastModified != nil {
+		modified = info.LastModified.UnixNano()
+	}
+	return fmt.Sprintf("%d-%d", info.Size, modified)
+}
+
+// Derivations returns the manager of the derived files of the storage, shared with its views
+func (s *Storage) Derivations() *DerivedFileManager {
+	return s.derived
+}
+
+// GetDerived returns the file of the named derivation of srcPath, generating it when it
+// does not exist or was generated from another version of the source. Concurrent calls
+// for the same file generate it once. With StaleWhileRevalidate a stale file is returned
+// at once while a fresh one is generated in the background.
+func (s *Storage) GetDerived(ctx context.Context, srcPath, name string) (*FileInfo, error) {
+	d, ok := s.derived.lookup(name)
+	if !ok {
+		return nil, NotSupportedError("unknown derivation: " + name)
+	}
+	src, err := s.GetInfo(ctx, srcPath)
+	if err != nil {
+		return nil, err
+	}
+	if src.IsDirectory {
+		return nil, NewStorageErrorWithPath(ErrorCodeIsDirectory, "path is a directory", srcPath)
+	}
+
+	target := derivedPath(d.template, src.Path)
+	version := sourceVersion(src)
+	info, err := s.GetInfo(ctx, target)
+	switch {
+	case err == nil && info.Metadata[DerivedSourceMetadataKey] == version:
+		return info, nil
+	case err != nil && !errors.Is(err, ErrFileNotFound):
+		return nil, err
+	}
+
+	// Generations outlive the request that started them, as others may be waiting
+	generateCtx := context.WithoutCancel(ctx)
+	generate := func() (*FileInfo, error) {
+		return s.generateDerived(generateCtx, d, src.Path, nil, nil)
+	}
+	key := target + "\x00" + version
+	if err == nil && d.opts.StaleWhileRevalidate {
+		go func() {
+			if _, err := s.derived.do(generateCtx, key, generate); err != nil {
+				s.derivationFailed(generateCtx, d, src.Path, err)
+			}
+		}()
+		return info, nil
+	}
+	return s.derived.do(ctx, key, generate)
+}
+
+// generateDerived stores the file of a derivation of srcPath, read from content when
+// given, e.g. the file just uploaded, and opened with OpenSeekable otherwise
+func (s *Storage) generateDerived(ctx context.Context, d *derivation, srcPath string, src *FileInfo, content io.Reader) (*FileInfo, error) {
+	if content == nil {
+		file, info, err := s.OpenSeekable(ctx, srcPath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		content, src = file, info
+	}
+	target := derivedPath(d.template, src.Path)
+
+	reader, writer := io.Pipe()
+	derived := make(chan struct{})
+	go func() {
+		defer close(derived)
+		writer.CloseWithError(d.derive(ctx, src, content, writer))
+	}()
+	info, err := s.Upload(withDeriving(ctx), target, reader, &FileMetadata{
+		ContentType:    d.opts.ContentType,
+		CustomMetadata: map[string]string{DerivedSourceMetadataKey: sourceVersion(src)},
+	})
+	reader.Close() // Stops the derivation if the upload failed first
+	<-derived
+	if err != nil {
+		return nil, err
+	}
+	s.config.incCounter("storage_derived_generated_total", 1, map[string]string{"storage": s.config.Name, "derivation": d.name})
+	return info, nil
+}
+
+// derivationFailed logs and counts a derived file that could not be generated in the
+// background
+func (s *Storage) derivationFailed(ctx context.Context, d *derivation, srcPath string, err error) {
+	s.config.log(ctx, LogLevelWarn, "failed to generate derived file", map[string]interface{}{
+		"path":       srcPath,
+		"derivation": d.name,
+		"error":      err.Error(),
+	})
+	s.config.incCounter("storage_derived_failures_total", 1, map[string]string{"storage": s.config.Name, "derivation": d.name})
+}
+
+type derivingKey struct{}
+
+// withDeriving marks the writes of derived files, which are not sources themselves
+func withDeriving(ctx context.Context) context.Context {
+	return context.WithValue(ctx, derivingKey{}, true)
+}
+
+// invalidateDerived deletes the derived files of a source that changed, except those
+// served stale while revalidating, or of a source that was removed
+func (s *Storage) invalidateDerived(ctx context.Context, srcPath string, removed bool) {
+	if ctx.Value(derivingKey{}) != nil {
+		return
+	}
+	srcPath = cleanPath(s.config.normalizePath(srcPath))
+	if isInternalPath(srcPath) {
+		return
+	}
+	for _, d := range s.derived.all() {
+		if d.opts.StaleWhileRevalidate && !removed {
+			continue
+		}
+		target := derivedPath(d.template, srcPath)
+		err := s.deleteFile(withDeriving(ctx), target, DeleteOptions{Permanent: true})
+		if err != nil && !errors.Is(err, ErrFileNotFound) {
+			s.config.log(ctx, LogLevelWarn, "failed to delete derived file", map[string]interface{}{
+				"path":       target,
+				"derivation": d.name,
+				"error":      err.Error(),
+			})
+		}
+	}
+}
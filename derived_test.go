@@ -0,0 +1,154 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDerivedFiles(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{Name: "test", Provider: "memory"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	close(release)
+	var gate atomic.Pointer[chan struct{}]
+	gate.Store(&release)
+	upper := func(ctx context.Context, src *FileInfo, r io.Reader, w io.Writer) error {
+		calls.Add(1)
+		<-*gate.Load()
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(bytes.ToUpper(content))
+		return err
+	}
+	if err := storage.Derivations().RegisterDerivation("upper", upper, "derived/{path}.txt", DerivationOptions{ContentType: "text/plain"}); err != nil {
+		t.Fatalf("Failed to register derivation: %v", err)
+	}
+	read := func(path string) string {
+		reader, _, err := storage.Download(ctx, path)
+		if err != nil {
+			return ""
+		}
+		defer reader.Close()
+		content, _ := io.ReadAll(reader)
+		return string(content)
+	}
+
+	storage.Upload(ctx, "cameras/1/note.txt", strings.NewReader("hello"), nil)
+	info, err := storage.GetDerived(ctx, "cameras/1/note.txt", "upper")
+	if err != nil || info.Path != "derived/cameras/1/note.txt.txt" || read(info.Path) != "HELLO" {
+		t.Fatalf("unexpected derived file %+v, %v", info, err)
+	}
+	if _, err := storage.GetDerived(ctx, "cameras/1/note.txt", "upper"); err != nil || calls.Load() != 1 {
+		t.Errorf("expected the fresh derived file to be reused, got %d calls and %v", calls.Load(), err)
+	}
+
+	// A new version of the source deletes the derived file, which is generated again
+	storage.Upload(ctx, "cameras/1/note.txt", strings.NewReader("hello again"), nil)
+	if exists, _ := storage.Exists(ctx, info.Path); exists {
+		t.Error("expected the derived file of the changed source to be deleted")
+	}
+	if info, err := storage.GetDerived(ctx, "cameras/1/note.txt", "upper"); err != nil || read(info.Path) != "HELLO AGAIN" {
+		t.Errorf("expected a regenerated derived file, got %v", err)
+	}
+
+	// Concurrent requests generate the file once
+	storage.Upload(ctx, "cameras/1/note.txt", strings.NewReader("third"), nil)
+	blocked := make(chan struct{})
+	gate.Store(&blocked)
+	calls.Store(0)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := storage.GetDerived(ctx, "cameras/1/note.txt", "upper"); err != nil {
+				t.Errorf("GetDerived failed: %v", err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(blocked)
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Errorf("expected a single generation, got %d", calls.Load())
+	}
+
+	// Deleting the source takes its derived files along
+	storage.Delete(ctx, "cameras/1/note.txt")
+	if exists, _ := storage.Exists(ctx, info.Path); exists {
+		t.Error("expected the derived file of the deleted source to be deleted")
+	}
+	if _, err := storage.GetDerived(ctx, "cameras/1/note.txt", "missing"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected an unknown derivation to fail, got %v", err)
+	}
+}
+
+func TestDerivedStaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	storage, _ := New(&StorageConfig{Name: "test", Provider: "memory"})
+	generated := make(chan struct{}, 4)
+	length := func(ctx context.Context, src *FileInfo, r io.Reader, w io.Writer) error {
+		defer func() { generated <- struct{}{} }()
+		content, _ := io.ReadAll(r)
+		_, err := io.WriteString(w, strings.Repeat("*", len(content)))
+		return err
+	}
+	storage.Derivations().RegisterDerivation("length", length, "{dir}/.length/{name}", DerivationOptions{StaleWhileRevalidate: true})
+
+	storage.Upload(ctx, "a/clip.mp4", strings.NewReader("12"), nil)
+	if info, err := storage.GetDerived(ctx, "a/clip.mp4", "length"); err != nil || info.Path != "a/.length/clip" || info.Size != 2 {
+		t.Fatalf("unexpected derived file %+v, %v", info, err)
+	}
+	<-generated
+
+	// The stale file is kept and served while a fresh one is generated
+	storage.Upload(ctx, "a/clip.mp4", strings.NewReader("1234"), nil)
+	info, err := storage.GetDerived(ctx, "a/clip.mp4", "length")
+	if err != nil || info.Size != 2 {
+		t.Fatalf("expected the stale derived file, got %+v, %v", info, err)
+	}
+	<-generated
+	deadline := time.Now().Add(time.Second)
+	for info.Size != 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		info, _ = storage.GetInfo(ctx, "a/.length/clip")
+	}
+	if info.Size != 4 {
+		t.Errorf("expected the derived file to be revalidated, got %+v", info)
+	}
+
+	storage.Move(ctx, "a/clip.mp4", "b/clip.mp4")
+	if exists, _ := storage.Exists(ctx, "a/.length/clip"); exists {
+		t.Error("expected the derived file of the moved source to be deleted")
+	}
+}
+
+func TestRegisterDerivation(t *testing.T) {
+	manager := newDerivedFileManager()
+	derive := func(ctx context.Context, src *FileInfo, r io.Reader, w io.Writer) error { return nil }
+	for _, template := range []string{"thumbs/{name}.jpg", "{dir}/{ext}", "{path}/{size}", "{path}", "{dir}/{filename}"} {
+		if err := manager.RegisterDerivation("thumb", derive, template); err == nil {
+			t.Errorf("expected %q to be rejected", template)
+		}
+	}
+	if err := manager.RegisterDerivation("thumb", nil, "{path}.jpg"); err == nil {
+		t.Error("expected a derivation without derive function to be rejected")
+	}
+	if got := derivedPath("{dir}/.thumbs/{name}{ext}.jpg", "/clip.mp4"); got != ".thumbs/clip.mp4.jpg" {
+		t.Errorf("unexpected derived path %q", got)
+	}
+}
@@ -0,0 +1,179 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path"
+)
+
+// DiffReport lists the differences between two directories. Paths are relative to the
+// compared prefixes.
+type DiffReport struct {
+	OnlyInA    []string `json:"only_in_a"`
+	OnlyInB    []string `json:"only_in_b"`
+	Mismatched []string `json:"mismatched"` // Different content, or a file on one side and a directory on the other
+}
+
+// Equal reports whether no differences were found
+func (r *DiffReport) Equal() bool {
+	return len(r.OnlyInA) == 0 && len(r.OnlyInB) == 0 && len(r.Mismatched) == 0
+}
+
+// CompareFiles reports whether pathA in this storage and pathB in other have the same
+// content. Files are compared by size and ETag, streaming both sides when the ETags are
+// missing or not comparable, e.g. an S3 multipart ETag against an MD5.
+func (s *Storage) CompareFiles(ctx context.Context, other *Storage, pathA, pathB string) (bool, error) {
+	infoA, err := s.GetInfo(ctx, pathA)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := other.GetInfo(ctx, pathB)
+	if err != nil {
+		return false, err
+	}
+	if infoA.IsDirectory || infoB.IsDirectory {
+		return false, NewStorageError(ErrorCodeInvalidPath, "cannot compare directories, use DiffDirectories")
+	}
+
+	return s.compareContent(ctx, other, infoA, infoB)
+}
+
+// DiffDirectories compares the files under prefixA in this storage with those under
+// prefixB in other. Like SyncTo it merges the sorted listings of one directory at a
+// time, so memory use is bounded by the largest directory rather than the whole tree.
+func (s *Storage) DiffDirectories(ctx context.Context, other *Storage, prefixA, prefixB string) (*DiffReport, error) {
+	report := &DiffReport{OnlyInA: []string{}, OnlyInB: []string{}, Mismatched: []string{}}
+
+	// Prefix A must exist; a missing prefix B is simply empty
+	if _, err := s.List(ctx, prefixA); err != nil {
+		return nil, err
+	}
+
+	if err := s.diffDirectory(ctx, other, prefixA, prefixB, "", report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// diffDirectory compares one directory level and recurses into common subdirectories
+func (s *Storage) diffDirectory(ctx context.Context, other *Storage, prefixA, prefixB, rel string, report *DiffReport) error {
+	if err := checkContext(ctx, rel); err != nil {
+		return err
+	}
+
+	entriesA, err := s.List(ctx, path.Join(prefixA, rel))
+	if err != nil {
+		return err
+	}
+	entriesB, err := other.List(ctx, path.Join(prefixB, rel))
+	if err != nil && !errors.Is(err, ErrDirectoryNotFound) {
+		return err
+	}
+
+	sortByName(entriesA)
+	sortByName(entriesB)
+
+	i, j := 0, 0
+	for i < len(entriesA) || j < len(entriesB) {
+		switch {
+		case j >= len(entriesB) || (i < len(entriesA) && entriesA[i].Name < entriesB[j].Name):
+			if err := s.diffOnlyIn(ctx, entriesA[i], prefixA, &report.OnlyInA); err != nil {
+				return err
+			}
+			i++
+		case i >= len(entriesA) || entriesB[j].Name < entriesA[i].Name:
+			if err := other.diffOnlyIn(ctx, entriesB[j], prefixB, &report.OnlyInB); err != nil {
+				return err
+			}
+			j++
+		default:
+			a, b := entriesA[i], entriesB[j]
+			entryRel := path.Join(rel, a.Name)
+			switch {
+			case a.IsDirectory && b.IsDirectory:
+				if err := s.diffDirectory(ctx, other, prefixA, prefixB, entryRel, report); err != nil {
+					return err
+				}
+			case a.IsDirectory != b.IsDirectory:
+				report.Mismatched = append(report.Mismatched, entryRel)
+			default:
+				same, err := s.compareContent(ctx, other, a, b)
+				if err != nil {
+					return err
+				}
+				if !same {
+					report.Mismatched = append(report.Mismatched, entryRel)
+				}
+			}
+			i++
+			j++
+		}
+	}
+
+	return nil
+}
+
+// diffOnlyIn records an entry missing on the other side, listing every file of a directory
+func (s *Storage) diffOnlyIn(ctx context.Context, entry *FileInfo, prefix string, paths *[]string) error {
+	if !entry.IsDirectory {
+		*paths = append(*paths, relativePath(prefix, entry.Path))
+		return nil
+	}
+	return s.Walk(ctx, entry.Path, func(info *FileInfo) error {
+		if !info.IsDirectory {
+			*paths = append(*paths, relativePath(prefix, info.Path))
+		}
+		return nil
+	})
+}
+
+// compareContent compares two files by size and ETag, streaming both when the ETags cannot be compared
+func (s *Storage) compareContent(ctx context.Context, other *Storage, a, b *FileInfo) (bool, error) {
+	if a.Size != b.Size {
+		return false, nil
+	}
+	if comparableETags(a.ETag, b.ETag) {
+		return NormalizeETag(a.ETag) == NormalizeETag(b.ETag), nil
+	}
+
+	readerA, _, err := s.Download(ctx, a.Path)
+	if err != nil {
+		return false, err
+	}
+	defer readerA.Close()
+
+	readerB, _, err := other.Download(ctx, b.Path)
+	if err != nil {
+		return false, err
+	}
+	defer readerB.Close()
+
+	return sameReaders(readerA, readerB, s.config.GetCopyBufferSize())
+}
+
+// sameReaders reports whether two readers produce the same bytes, reading both in chunks
+func sameReaders(a, b io.Reader, size int) (bool, error) {
+	bufA, bufB := getCopyBuffer(size), getCopyBuffer(size)
+	defer putCopyBuffer(bufA)
+	defer putCopyBuffer(bufB)
+
+	for {
+		n, errA := io.ReadFull(a, *bufA)
+		m, errB := io.ReadFull(b, *bufB)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return false, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to read file", errA)
+		}
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to read file", errB)
+		}
+		if !bytes.Equal((*bufA)[:n], (*bufB)[:m]) {
+			return false, nil
+		}
+		if errA != nil || errB != nil {
+			// Both ended at the same point only if both reported the end
+			return (errA != nil) == (errB != nil), nil
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareFiles(t *testing.T) {
+	ctx := context.Background()
+	fs, mem := newTransferStorages(t)
+
+	fs.Upload(ctx, "a.mp4", strings.NewReader("same content"), nil)
+	mem.Upload(ctx, "b.mp4", strings.NewReader("same content"), nil)
+	mem.Upload(ctx, "c.mp4", strings.NewReader("diff content"), nil)
+	mem.Upload(ctx, "d.mp4", strings.NewReader("longer content"), nil)
+
+	// The filesystem reports no ETag, so these stream both sides
+	cases := map[string]bool{"b.mp4": true, "c.mp4": false, "d.mp4": false}
+	for path, expected := range cases {
+		same, err := fs.CompareFiles(ctx, mem, "a.mp4", path)
+		if err != nil {
+			t.Fatalf("CompareFiles failed: %v", err)
+		}
+		if same != expected {
+			t.Errorf("Expected a.mp4 and %s to compare %v", path, expected)
+		}
+	}
+
+	// Both memory ETags are MD5s
+	if same, _ := mem.CompareFiles(ctx, mem, "b.mp4", "c.mp4"); same {
+		t.Error("Expected different ETags to compare unequal")
+	}
+
+	if _, err := fs.CompareFiles(ctx, mem, "a.mp4", "missing.mp4"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+}
+
+func TestDiffDirectories(t *testing.T) {
+	ctx := context.Background()
+	fs, mem := newTransferStorages(t)
+
+	for path, content := range map[string]string{
+		"src/same.mp4":         "same",
+		"src/changed.mp4":      "v1",
+		"src/only-a.mp4":       "a",
+		"src/2024/06/deep.mp4": "deep",
+		"src/2024/extra/x.mp4": "x",
+		"src/conflict":         "file",
+	} {
+		fs.Upload(ctx, path, strings.NewReader(content), nil)
+	}
+	for path, content := range map[string]string{
+		"dst/same.mp4":          "same",
+		"dst/changed.mp4":       "v2",
+		"dst/only-b.mp4":        "b",
+		"dst/2024/06/deep.mp4":  "deep",
+		"dst/conflict/file.mp4": "dir",
+	} {
+		mem.Upload(ctx, path, strings.NewReader(content), nil)
+	}
+
+	report, err := fs.DiffDirectories(ctx, mem, "src", "dst")
+	if err != nil {
+		t.Fatalf("DiffDirectories failed: %v", err)
+	}
+
+	expected := &DiffReport{
+		OnlyInA:    []string{"2024/extra/x.mp4", "only-a.mp4"},
+		OnlyInB:    []string{"only-b.mp4"},
+		Mismatched: []string{"changed.mp4", "conflict"},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if report.Equal() {
+		t.Error("Expected the report to have differences")
+	}
+
+	report, err = mem.DiffDirectories(ctx, mem, "dst", "dst")
+	if err != nil || !report.Equal() {
+		t.Errorf("Expected a directory to equal itself, got %+v, %v", report, err)
+	}
+
+	report, err = fs.DiffDirectories(ctx, mem, "src/2024", "missing")
+	if err != nil || len(report.OnlyInA) != 2 {
+		t.Errorf("Expected a missing prefix B to be empty, got %+v, %v", report, err)
+	}
+}
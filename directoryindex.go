@@ -0,0 +1,71 @@
+package vsaasstorage
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+
+	rest "github.com/xompass/vsaas-rest"
+	"github.com/xompass/vsaas-rest/http_errors"
+)
+
+// DirectoryDownloads selects how the download handler answers a path that is a directory
+type DirectoryDownloads string
+
+const (
+	// DirectoryDownloadIndex answers with the listing of the directory in the format of
+	// ListHandler, or with an archive of its files for ?format=zip. It is the default.
+	DirectoryDownloadIndex DirectoryDownloads = "index"
+	// DirectoryDownloadReject answers 409 with the IS_DIRECTORY code
+	DirectoryDownloadReject DirectoryDownloads = "reject"
+)
+
+// serveDirectory answers a download of a directory, err being the IS_DIRECTORY error
+// writeContent returned for it
+func (s *Storage) serveDirectory(c *rest.EndpointContext, dirPath string, err error) error {
+	if s.config.DirectoryDownloads == DirectoryDownloadReject {
+		return mutationError(err, "Failed to download file", dirPath)
+	}
+
+	switch format := c.EchoCtx.QueryParam("format"); format {
+	case "":
+		return s.writeListing(c, dirPath)
+	case ArchiveZip, ArchiveTar:
+		return s.writeDirectoryArchive(c, dirPath, format)
+	default:
+		return http_errors.BadRequestError("format must be zip or tar")
+	}
+}
+
+// writeDirectoryArchive streams the files under a directory as a zip or tar download
+// named after the directory
+func (s *Storage) writeDirectoryArchive(c *rest.EndpointContext, dirPath, format string) error {
+	contentType := "application/x-tar"
+	if format == ArchiveZip {
+		contentType = "application/zip"
+	}
+	name := "export"
+	if !isRootPath(dirPath) {
+		name = path.Base(cleanPath(dirPath))
+	}
+
+	response := c.EchoCtx.Response()
+	response.Header().Set("Content-Type", contentType)
+	response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", name, format))
+
+	_, err := s.ArchiveDirectory(c.Context(), dirPath, response, ArchiveOptions{Format: format})
+	if err != nil && !response.Committed {
+		response.Header().Del("Content-Disposition")
+		return httpError(err, "Failed to build archive")
+	}
+	if err != nil {
+		// The archive is already being sent; aborting the connection tells the client it
+		// is incomplete
+		s.config.log(c.Context(), LogLevelError, "failed to stream archive", map[string]interface{}{
+			"path":  dirPath,
+			"error": err.Error(),
+		})
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
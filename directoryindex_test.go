@@ -0,0 +1,95 @@
+package vsaasstorage
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestDirectoryDownloads(t *testing.T) {
+	ctx := context.Background()
+	configs := map[string]*StorageConfig{
+		"filesystem": {Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir()}},
+		"memory":     {Name: "test", Provider: "memory"},
+	}
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			storage.Upload(ctx, "cases/4411/clip.mp4", strings.NewReader("clip"), nil)
+			storage.Upload(ctx, "cases/4411/snapshots/a.jpg", strings.NewReader("a"), nil)
+
+			download := func(target string) (*httptest.ResponseRecorder, error) {
+				request := httptest.NewRequest(http.MethodGet, target, nil)
+				recorder := httptest.NewRecorder()
+				c := &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder)}
+				return recorder, storage.StreamFile(c, "cases/4411")
+			}
+
+			recorder, err := download("/download/cases/4411")
+			if err != nil {
+				t.Fatalf("StreamFile failed: %v", err)
+			}
+			var index struct {
+				Path  string      `json:"path"`
+				Files []*FileInfo `json:"files"`
+				Count int         `json:"count"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &index); err != nil || index.Count != 2 || len(index.Files) != 2 {
+				t.Fatalf("Expected the index of the directory, got %s", recorder.Body.String())
+			}
+
+			recorder, err = download("/download/cases/4411?format=zip")
+			if err != nil {
+				t.Fatalf("StreamFile failed: %v", err)
+			}
+			if got := recorder.Header().Get("Content-Disposition"); got != `attachment; filename="4411.zip"` {
+				t.Errorf("Expected the archive named after the directory, got %q", got)
+			}
+			archive, err := zip.NewReader(bytes.NewReader(recorder.Body.Bytes()), int64(recorder.Body.Len()))
+			if err != nil {
+				t.Fatalf("Expected a zip, got %v", err)
+			}
+			var names []string
+			for _, file := range archive.File {
+				names = append(names, file.Name)
+			}
+			sort.Strings(names)
+			if strings.Join(names, ",") != "clip.mp4,manifest.json,snapshots/a.jpg" {
+				t.Errorf("Expected the files relative to the directory, got %v", names)
+			}
+
+			if _, err := download("/download/cases/4411?format=rar"); err == nil {
+				t.Error("Expected an unknown format to be refused")
+			}
+
+			config.DirectoryDownloads = DirectoryDownloadReject
+			defer func() { config.DirectoryDownloads = "" }()
+			_, err = download("/download/cases/4411")
+			var httpErr *echo.HTTPError
+			if !errors.As(err, &httpErr) || httpErr.Code != http.StatusConflict {
+				t.Fatalf("Expected 409, got %v", err)
+			}
+			if body, _ := httpErr.Message.(map[string]interface{}); body["code"] != ErrorCodeIsDirectory {
+				t.Errorf("Expected the IS_DIRECTORY code, got %v", httpErr.Message)
+			}
+		})
+	}
+
+	invalid := &StorageConfig{Name: "test", Provider: "memory", DirectoryDownloads: "zip"}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected an unknown directoryDownloads to be refused")
+	}
+}
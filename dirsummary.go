@@ -0,0 +1,68 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// DirectorySummary counts the direct children of a directory, without descending into
+// its subdirectories
+type DirectorySummary struct {
+	Files       int   `json:"files"`
+	Directories int   `json:"directories"`
+	TotalSize   int64 `json:"total_size"`          // Of the files only
+	Truncated   bool  `json:"truncated,omitempty"` // Only the first listing page of a large directory was counted
+}
+
+// GetInfoOptions controls the behavior of GetInfoWithOptions
+type GetInfoOptions struct {
+	// IncludeChildrenSummary fills FileInfo.Children when the path is a directory. Hidden
+	// entries are not counted, like in List.
+	IncludeChildrenSummary bool
+}
+
+// DirectorySummaryProvider is implemented by providers that can summarize a directory
+// more cheaply than listing it, e.g. from a single listing page
+type DirectorySummaryProvider interface {
+	DirectorySummary(ctx context.Context, path string) (*DirectorySummary, error)
+}
+
+// GetInfoWithOptions is GetInfo with options
+func (s *Storage) GetInfoWithOptions(ctx context.Context, path string, opts GetInfoOptions) (*FileInfo, error) {
+	info, err := s.GetInfo(ctx, path)
+	if err != nil || !opts.IncludeChildrenSummary || !info.IsDirectory {
+		return info, err
+	}
+	if info.Children, err = s.summarizeDirectory(ctx, path); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// summarizeDirectory counts the entries of a directory, iterating over it when the provider
+// has no cheaper way
+func (s *Storage) summarizeDirectory(ctx context.Context, path string) (*DirectorySummary, error) {
+	if provider, ok := providerAs[DirectorySummaryProvider](s.provider); ok {
+		return provider.DirectorySummary(ctx, s.extensionPath(path))
+	}
+
+	entries := s.Iterate(ctx, path, ListOptions{})
+	defer entries.Close()
+	summary := &DirectorySummary{}
+	for {
+		entry, err := entries.Next()
+		if errors.Is(err, io.EOF) {
+			return summary, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.IsDirectory {
+			summary.Directories++
+			continue
+		}
+		summary.Files++
+		summary.TotalSize += entry.Size
+	}
+}
@@ -0,0 +1,63 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestGetInfoChildrenSummary(t *testing.T) {
+	ctx := context.Background()
+	configs := map[string]*StorageConfig{
+		"filesystem": {Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir()}},
+		"memory":     {Name: "test", Provider: "memory"},
+	}
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			storage.Upload(ctx, "cameras/1/a.mp4", strings.NewReader("12345"), nil)
+			storage.Upload(ctx, "cameras/1/b.mp4", strings.NewReader("123"), nil)
+			storage.Upload(ctx, "cameras/1/.DS_Store", strings.NewReader("hidden"), nil)
+			storage.Upload(ctx, "cameras/1/night/c.mp4", strings.NewReader("not counted"), nil)
+			storage.CreateDirectory(ctx, "cameras/1/empty")
+
+			info, err := storage.GetInfoWithOptions(ctx, "cameras/1", GetInfoOptions{IncludeChildrenSummary: true})
+			if err != nil {
+				t.Fatalf("GetInfo failed: %v", err)
+			}
+			want := DirectorySummary{Files: 2, Directories: 2, TotalSize: 8}
+			if info.Children == nil || *info.Children != want {
+				t.Errorf("Expected %+v, got %+v", want, info.Children)
+			}
+
+			if info, _ := storage.GetInfo(ctx, "cameras/1"); info.Children != nil {
+				t.Error("Expected no summary without the option")
+			}
+			if info, _ := storage.GetInfoWithOptions(ctx, "cameras/1/a.mp4", GetInfoOptions{IncludeChildrenSummary: true}); info.Children != nil {
+				t.Error("Expected no summary for a file")
+			}
+
+			request := httptest.NewRequest(http.MethodGet, "/info?path=cameras/1&children=true", nil)
+			recorder := httptest.NewRecorder()
+			if err := storage.InfoHandler()(&rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder)}); err != nil {
+				t.Fatalf("InfoHandler failed: %v", err)
+			}
+			var body struct {
+				Children *DirectorySummary `json:"children"`
+			}
+			json.Unmarshal(recorder.Body.Bytes(), &body)
+			if body.Children == nil || *body.Children != want {
+				t.Errorf("Expected the summary in the response, got %s", recorder.Body.String())
+			}
+		})
+	}
+}
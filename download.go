@@ -0,0 +1,104 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DownloadBytes reads a whole file into memory. The content is checked against the size
+// and MD5 ETag of the file, failing with ErrChecksumMismatch when the provider returned
+// a truncated or altered body.
+func (s *Storage) DownloadBytes(ctx context.Context, path string) ([]byte, *FileInfo, error) {
+	reader, info, err := s.Download(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	var buf bytes.Buffer
+	verifier := newContentVerifier(info)
+	if _, err := copyBuffer(io.MultiWriter(&buf, verifier), reader, s.config.GetCopyBufferSize()); err != nil {
+		return nil, nil, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to read file", err)
+	}
+	if err := verifier.verify(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), info, nil
+}
+
+// DownloadToFile writes a file to localPath, replacing it only once the content was
+// read in full and checked like DownloadBytes. Nothing is left behind on failure.
+func (s *Storage) DownloadToFile(ctx context.Context, path, localPath string) (*FileInfo, error) {
+	reader, info, err := s.Download(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), ".download-*")
+	if err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to create local file", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	verifier := newContentVerifier(info)
+	_, err = copyBuffer(io.MultiWriter(tmp, verifier), reader, s.config.GetCopyBufferSize())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to write local file", err)
+	}
+	if err := verifier.verify(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), localPath); err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to write local file", err)
+	}
+	return info, nil
+}
+
+// contentVerifier counts and hashes downloaded content to check it against its FileInfo
+type contentVerifier struct {
+	info *FileInfo
+	hash hash.Hash // Nil when the ETag is not an MD5 of the content
+	size int64
+}
+
+// newContentVerifier creates a verifier for the content of info
+func newContentVerifier(info *FileInfo) *contentVerifier {
+	v := &contentVerifier{info: info}
+	if etag := NormalizeETag(info.ETag); len(etag) == md5.Size*2 && !isMultipartETag(etag) {
+		if _, err := hex.DecodeString(etag); err == nil {
+			v.hash = md5.New()
+		}
+	}
+	return v
+}
+
+// Write counts and hashes p
+func (v *contentVerifier) Write(p []byte) (int, error) {
+	v.size += int64(len(p))
+	if v.hash != nil {
+		v.hash.Write(p)
+	}
+	return len(p), nil
+}
+
+// verify returns ErrChecksumMismatch when the content does not match the size or ETag
+func (v *contentVerifier) verify() error {
+	if v.size != v.info.Size {
+		return ChecksumMismatchError(v.info.Path, fmt.Sprintf("read %d of %d bytes", v.size, v.info.Size))
+	}
+	if v.hash != nil && hex.EncodeToString(v.hash.Sum(nil)) != NormalizeETag(v.info.ETag) {
+		return ChecksumMismatchError(v.info.Path, "content does not match its ETag")
+	}
+	return nil
+}
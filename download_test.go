@@ -0,0 +1,151 @@
+package vsaasstorage
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// truncatingProvider serves downloads that fail after limit bytes, like an object store
+// connection dropped mid-stream
+type truncatingProvider struct {
+	StorageProvider
+	limit int64
+}
+
+func (p *truncatingProvider) Download(ctx context.Context, path string) (io.ReadCloser, *FileInfo, error) {
+	reader, info, err := p.StorageProvider.Download(ctx, path)
+	if err != nil || p.limit < 0 {
+		return reader, info, err
+	}
+	data := make([]byte, p.limit)
+	n, _ := io.ReadFull(reader, data)
+	return struct {
+		io.Reader
+		io.Closer
+	}{&failingReader{data: data[:n]}, reader}, info, nil
+}
+
+func TestDownloadVerification(t *testing.T) {
+	ctx := context.Background()
+	provider := &truncatingProvider{limit: -1}
+	RegisterProvider("truncating", func(config *StorageConfig) (StorageProvider, error) {
+		inner, err := NewMemoryProvider(config)
+		provider.StorageProvider = inner
+		return provider, err
+	})
+	storage, err := New(&StorageConfig{Name: "test", Provider: "truncating"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.Upload(ctx, "clips/a.mp4", strings.NewReader("0123456789"), nil)
+	localPath := filepath.Join(t.TempDir(), "a.mp4")
+
+	data, info, err := storage.DownloadBytes(ctx, "clips/a.mp4")
+	if err != nil || string(data) != "0123456789" || info.Size != 10 {
+		t.Fatalf("Expected the whole file, got %q, %v", data, err)
+	}
+	if _, err := storage.DownloadToFile(ctx, "clips/a.mp4", localPath); err != nil {
+		t.Fatalf("DownloadToFile failed: %v", err)
+	}
+	if local, _ := os.ReadFile(localPath); string(local) != "0123456789" {
+		t.Errorf("Expected the file on disk, got %q", local)
+	}
+
+	t.Run("Truncated", func(t *testing.T) {
+		provider.limit = 4
+		defer func() { provider.limit = -1 }()
+
+		if _, _, err := storage.DownloadBytes(ctx, "clips/a.mp4"); err == nil {
+			t.Error("Expected DownloadBytes to fail")
+		}
+		if _, err := storage.DownloadToFile(ctx, "clips/a.mp4", localPath); err == nil {
+			t.Error("Expected DownloadToFile to fail")
+		}
+		if local, _ := os.ReadFile(localPath); string(local) != "0123456789" {
+			t.Errorf("Expected the previous local file to be kept, got %q", local)
+		}
+		if entries, _ := os.ReadDir(filepath.Dir(localPath)); len(entries) != 1 {
+			t.Errorf("Expected no temporary file left behind, got %d entries", len(entries))
+		}
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		info, _ := storage.GetInfo(ctx, "clips/a.mp4")
+		verifier := newContentVerifier(info)
+		verifier.Write([]byte("9876543210"))
+		if err := verifier.verify(); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("Expected ErrChecksumMismatch for other content of the same size, got %v", err)
+		}
+		verifier = newContentVerifier(info)
+		verifier.Write([]byte("01234"))
+		if err := verifier.verify(); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("Expected ErrChecksumMismatch for short content, got %v", err)
+		}
+	})
+
+	t.Run("AbortStream", func(t *testing.T) {
+		provider.limit = 4
+		defer func() { provider.limit = -1 }()
+
+		request := httptest.NewRequest(http.MethodGet, "/clips/a.mp4", nil)
+		c := &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, httptest.NewRecorder())}
+		defer func() {
+			if r := recover(); r != http.ErrAbortHandler {
+				t.Errorf("Expected the handler to abort the connection, got %v", r)
+			}
+		}()
+		storage.StreamFile(c, "clips/a.mp4")
+	})
+}
+
+func TestDownloadChecksumTrailer(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{
+		Name:                    "test",
+		Provider:                "filesystem",
+		FileSystem:              &FileSystemConfig{BasePath: t.TempDir()},
+		DownloadChecksumTrailer: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.Upload(ctx, "clips/a.mp4", strings.NewReader("0123456789"), nil)
+
+	serve := func(rangeHeader string) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, "/clips/a.mp4", nil)
+		if rangeHeader != "" {
+			request.Header.Set("Range", rangeHeader)
+		}
+		recorder := httptest.NewRecorder()
+		if err := storage.StreamFile(&rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder)}, "clips/a.mp4"); err != nil {
+			t.Fatalf("StreamFile failed: %v", err)
+		}
+		return recorder.Result()
+	}
+
+	response := serve("")
+	body, _ := io.ReadAll(response.Body)
+	if string(body) != "0123456789" || response.Header.Get("Content-Length") != "" {
+		t.Errorf("Expected a chunked body, got %q with length %q", body, response.Header.Get("Content-Length"))
+	}
+	if checksum := response.Trailer.Get(ChecksumTrailer); checksum != fmt.Sprintf("md5=%x", md5.Sum(body)) {
+		t.Errorf("Unexpected checksum trailer %q", checksum)
+	}
+
+	response = serve("bytes=0-3")
+	if response.StatusCode != http.StatusPartialContent || response.Trailer.Get(ChecksumTrailer) != "" || response.Header.Get("Content-Length") != "4" {
+		t.Errorf("Expected ranges to keep their length and no trailer, got %d %v", response.StatusCode, response.Trailer)
+	}
+}
@@ -1,42 +1,109 @@
 package vsaasstorage
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
 
 // ErrorCode represents the type of storage error
 type ErrorCode string
 
 const (
-	ErrorCodeInvalidProvider   ErrorCode = "INVALID_PROVIDER"
-	ErrorCodeInvalidConfig     ErrorCode = "INVALID_CONFIG"
-	ErrorCodeFileNotFound      ErrorCode = "FILE_NOT_FOUND"
-	ErrorCodeDirectoryNotFound ErrorCode = "DIRECTORY_NOT_FOUND"
-	ErrorCodeFileAlreadyExists ErrorCode = "FILE_ALREADY_EXISTS"
-	ErrorCodePermissionDenied  ErrorCode = "PERMISSION_DENIED"
-	ErrorCodeInvalidPath       ErrorCode = "INVALID_PATH"
-	ErrorCodeUploadFailed      ErrorCode = "UPLOAD_FAILED"
-	ErrorCodeDownloadFailed    ErrorCode = "DOWNLOAD_FAILED"
-	ErrorCodeDeleteFailed      ErrorCode = "DELETE_FAILED"
-	ErrorCodeCopyFailed        ErrorCode = "COPY_FAILED"
-	ErrorCodeMoveFailed        ErrorCode = "MOVE_FAILED"
-	ErrorCodeListFailed        ErrorCode = "LIST_FAILED"
-	ErrorCodeSignedURLFailed   ErrorCode = "SIGNED_URL_FAILED"
-	ErrorCodeInvalidToken      ErrorCode = "INVALID_TOKEN"
-	ErrorCodeTokenExpired      ErrorCode = "TOKEN_EXPIRED"
-	ErrorCodeProviderError     ErrorCode = "PROVIDER_ERROR"
-	ErrorCodeInternalError     ErrorCode = "INTERNAL_ERROR"
+	ErrorCodeInvalidProvider     ErrorCode = "INVALID_PROVIDER"
+	ErrorCodeInvalidConfig       ErrorCode = "INVALID_CONFIG"
+	ErrorCodeFileNotFound        ErrorCode = "FILE_NOT_FOUND"
+	ErrorCodeDirectoryNotFound   ErrorCode = "DIRECTORY_NOT_FOUND"
+	ErrorCodeFileAlreadyExists   ErrorCode = "FILE_ALREADY_EXISTS"
+	ErrorCodePermissionDenied    ErrorCode = "PERMISSION_DENIED"
+	ErrorCodeInvalidPath         ErrorCode = "INVALID_PATH"
+	ErrorCodeUploadFailed        ErrorCode = "UPLOAD_FAILED"
+	ErrorCodeDownloadFailed      ErrorCode = "DOWNLOAD_FAILED"
+	ErrorCodeDeleteFailed        ErrorCode = "DELETE_FAILED"
+	ErrorCodeCopyFailed          ErrorCode = "COPY_FAILED"
+	ErrorCodeMoveFailed          ErrorCode = "MOVE_FAILED"
+	ErrorCodeListFailed          ErrorCode = "LIST_FAILED"
+	ErrorCodeSignedURLFailed     ErrorCode = "SIGNED_URL_FAILED"
+	ErrorCodeInvalidToken        ErrorCode = "INVALID_TOKEN"
+	ErrorCodeTokenExpired        ErrorCode = "TOKEN_EXPIRED"
+	ErrorCodeProviderError       ErrorCode = "PROVIDER_ERROR"
+	ErrorCodeInternalError       ErrorCode = "INTERNAL_ERROR"
+	ErrorCodeNotSupported        ErrorCode = "NOT_SUPPORTED"
+	ErrorCodeRetentionLocked     ErrorCode = "RETENTION_LOCKED"
+	ErrorCodeQuotaExceeded       ErrorCode = "QUOTA_EXCEEDED"
+	ErrorCodeReadOnly            ErrorCode = "READ_ONLY"
+	ErrorCodeContentRejected     ErrorCode = "CONTENT_REJECTED"
+	ErrorCodeIsDirectory         ErrorCode = "IS_DIRECTORY"
+	ErrorCodeChecksumMismatch    ErrorCode = "CHECKSUM_MISMATCH"
+	ErrorCodeInvalidJSON         ErrorCode = "INVALID_JSON"
+	ErrorCodeFileTooLarge        ErrorCode = "FILE_TOO_LARGE"
+	ErrorCodePreconditionFailed  ErrorCode = "PRECONDITION_FAILED"
+	ErrorCodeLeaseHeld           ErrorCode = "LEASE_HELD"
+	ErrorCodeLeaseLost           ErrorCode = "LEASE_LOST"
+	ErrorCodeCanceled            ErrorCode = "CANCELED"
+	ErrorCodeArchived            ErrorCode = "ARCHIVED"
+	ErrorCodeThrottled           ErrorCode = "THROTTLED"
+	ErrorCodeTimeout             ErrorCode = "TIMEOUT"
+	ErrorCodeInsufficientStorage ErrorCode = "INSUFFICIENT_STORAGE"
+	ErrorCodeForceRequired       ErrorCode = "FORCE_REQUIRED"
+	ErrorCodeAuditFailed         ErrorCode = "AUDIT_FAILED"
+)
+
+// Sentinel errors for use with errors.Is. Each one only carries a code, and
+// StorageError.Is matches any error with the same code regardless of message,
+// path or provider:
+//
+//	if errors.Is(err, vsaasstorage.ErrFileNotFound) { ... }
+var (
+	ErrFileNotFound        = &StorageError{Code: ErrorCodeFileNotFound}
+	ErrDirectoryNotFound   = &StorageError{Code: ErrorCodeDirectoryNotFound}
+	ErrIsDirectory         = &StorageError{Code: ErrorCodeIsDirectory}
+	ErrFileAlreadyExists   = &StorageError{Code: ErrorCodeFileAlreadyExists}
+	ErrPermissionDenied    = &StorageError{Code: ErrorCodePermissionDenied}
+	ErrInvalidPath         = &StorageError{Code: ErrorCodeInvalidPath}
+	ErrInvalidToken        = &StorageError{Code: ErrorCodeInvalidToken}
+	ErrTokenExpired        = &StorageError{Code: ErrorCodeTokenExpired}
+	ErrProviderError       = &StorageError{Code: ErrorCodeProviderError}
+	ErrNotSupported        = &StorageError{Code: ErrorCodeNotSupported}
+	ErrRetentionLocked     = &StorageError{Code: ErrorCodeRetentionLocked}
+	ErrQuotaExceeded       = &StorageError{Code: ErrorCodeQuotaExceeded}
+	ErrReadOnly            = &StorageError{Code: ErrorCodeReadOnly}
+	ErrContentRejected     = &StorageError{Code: ErrorCodeContentRejected}
+	ErrChecksumMismatch    = &StorageError{Code: ErrorCodeChecksumMismatch}
+	ErrInvalidJSON         = &StorageError{Code: ErrorCodeInvalidJSON}
+	ErrFileTooLarge        = &StorageError{Code: ErrorCodeFileTooLarge}
+	ErrPreconditionFailed  = &StorageError{Code: ErrorCodePreconditionFailed}
+	ErrLeaseHeld           = &StorageError{Code: ErrorCodeLeaseHeld}
+	ErrLeaseLost           = &StorageError{Code: ErrorCodeLeaseLost}
+	ErrCanceled            = &StorageError{Code: ErrorCodeCanceled}
+	ErrArchived            = &StorageError{Code: ErrorCodeArchived}
+	ErrThrottled           = &StorageError{Code: ErrorCodeThrottled}
+	ErrTimeout             = &StorageError{Code: ErrorCodeTimeout}
+	ErrInsufficientStorage = &StorageError{Code: ErrorCodeInsufficientStorage}
+	ErrForceRequired       = &StorageError{Code: ErrorCodeForceRequired}
+	ErrAuditFailed         = &StorageError{Code: ErrorCodeAuditFailed}
 )
 
 // StorageError represents a storage operation error
 type StorageError struct {
-	Code     ErrorCode `json:"code"`
-	Message  string    `json:"message"`
-	Provider string    `json:"provider,omitempty"`
-	Path     string    `json:"path,omitempty"`
-	Cause    error     `json:"-"`
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	Provider  string    `json:"provider,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	RequestID string    `json:"request_id,omitempty"` // Set on the errors behind handler responses, see WithRequestID
+	Cause     error     `json:"-"`
+
+	sentContent bool // The content that failed its checksum was sent by the client, see HTTPStatus
 }
 
 // Error implements the error interface
 func (e *StorageError) Error() string {
+	if e.Message == "" && e.Provider == "" && e.Path == "" {
+		return fmt.Sprintf("[%s]", e.Code)
+	}
 	if e.Provider != "" && e.Path != "" {
 		return fmt.Sprintf("[%s:%s] %s: %s", e.Provider, e.Code, e.Path, e.Message)
 	} else if e.Provider != "" {
@@ -60,6 +127,49 @@ func (e *StorageError) Is(target error) bool {
 	return false
 }
 
+// HTTPStatus returns the HTTP status code that corresponds to the error code
+func (e *StorageError) HTTPStatus() int {
+	switch e.Code {
+	case ErrorCodeFileNotFound, ErrorCodeDirectoryNotFound:
+		return http.StatusNotFound
+	case ErrorCodeFileAlreadyExists, ErrorCodeIsDirectory, ErrorCodeLeaseHeld, ErrorCodeLeaseLost, ErrorCodeArchived:
+		return http.StatusConflict
+	case ErrorCodePermissionDenied, ErrorCodeReadOnly:
+		return http.StatusForbidden
+	case ErrorCodeInvalidPath, ErrorCodeUploadFailed, ErrorCodeForceRequired:
+		return http.StatusBadRequest
+	case ErrorCodeInvalidToken, ErrorCodeTokenExpired:
+		return http.StatusUnauthorized
+	case ErrorCodeRetentionLocked:
+		return http.StatusLocked
+	case ErrorCodeContentRejected, ErrorCodeInvalidJSON:
+		return http.StatusUnprocessableEntity
+	case ErrorCodeFileTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrorCodePreconditionFailed:
+		return http.StatusPreconditionFailed
+	case ErrorCodeQuotaExceeded, ErrorCodeInsufficientStorage:
+		return http.StatusInsufficientStorage
+	case ErrorCodeChecksumMismatch:
+		// Content sent with a checksum it does not match is refused; content read back
+		// corrupt is a fault of the backend
+		if e.sentContent {
+			return http.StatusUnprocessableEntity
+		}
+		return http.StatusBadGateway
+	case ErrorCodeProviderError:
+		return http.StatusBadGateway
+	case ErrorCodeNotSupported:
+		return http.StatusNotImplemented
+	case ErrorCodeThrottled, ErrorCodeAuditFailed:
+		return http.StatusServiceUnavailable
+	case ErrorCodeCanceled, ErrorCodeTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // NewStorageError creates a new storage error
 func NewStorageError(code ErrorCode, message string) *StorageError {
 	return &StorageError{
@@ -125,3 +235,235 @@ func InvalidTokenError(message string) *StorageError {
 func TokenExpiredError() *StorageError {
 	return NewStorageError(ErrorCodeTokenExpired, "token has expired")
 }
+
+func NotSupportedError(message string) *StorageError {
+	return NewStorageError(ErrorCodeNotSupported, message)
+}
+
+func RetentionLockedError(path string, until time.Time) *StorageError {
+	return NewStorageErrorWithPath(ErrorCodeRetentionLocked, "retained until "+until.UTC().Format(time.RFC3339), path)
+}
+
+func QuotaExceededError(prefix string, limit int64) *StorageError {
+	return NewStorageErrorWithPath(ErrorCodeQuotaExceeded, fmt.Sprintf("quota of %d bytes exceeded", limit), prefix)
+}
+
+func ForceRequiredError(path string) *StorageError {
+	return NewStorageErrorWithPath(ErrorCodeForceRequired, "refusing to delete a shallow directory without force", path)
+}
+
+// AuditFailedError is returned when an operation was not performed because the mandatory
+// audit sink could not record it
+func AuditFailedError(path string, cause error) *StorageError {
+	return &StorageError{Code: ErrorCodeAuditFailed, Message: "operation not performed, audit failed: " + cause.Error(), Path: path, Cause: cause}
+}
+
+func ReadOnlyError(path string) *StorageError {
+	return NewStorageErrorWithPath(ErrorCodeReadOnly, "storage is read-only", path)
+}
+
+func ContentRejectedError(path, reason string) *StorageError {
+	return NewStorageErrorWithPath(ErrorCodeContentRejected, "content rejected: "+reason, path)
+}
+
+func PreconditionFailedError(path string) *StorageError {
+	return NewStorageErrorWithPath(ErrorCodePreconditionFailed, "file was modified", path)
+}
+
+func LeaseHeldError(path, owner string, expiresAt time.Time) *StorageError {
+	return NewStorageErrorWithPath(ErrorCodeLeaseHeld, fmt.Sprintf("lease held by %q until %s", owner, expiresAt.UTC().Format(time.RFC3339)), path)
+}
+
+func LeaseLostError(path string) *StorageError {
+	return NewStorageErrorWithPath(ErrorCodeLeaseLost, "lease was released or taken over", path)
+}
+
+// ArchivedError is returned when reading a file in an archive storage class that has not
+// been restored with RestoreFromArchive
+func ArchivedError(path string, cause error) *StorageError {
+	return &StorageError{Code: ErrorCodeArchived, Message: "file is archived and must be restored before it can be read", Path: path, Cause: cause}
+}
+
+func ChecksumMismatchError(path, detail string) *StorageError {
+	return NewStorageErrorWithPath(ErrorCodeChecksumMismatch, "content is corrupt or truncated: "+detail, path)
+}
+
+// UploadChecksumMismatchError is returned when the content of an upload does not match the
+// checksum sent with it. Unlike ChecksumMismatchError it answers 422.
+func UploadChecksumMismatchError(path, detail string, cause error) *StorageError {
+	return &StorageError{Code: ErrorCodeChecksumMismatch, Message: "content does not match its checksum: " + detail, Path: path, Cause: cause, sentContent: true}
+}
+
+// CanceledError wraps the error of a canceled or timed out context, so callers can match
+// both ErrCanceled and context.Canceled or context.DeadlineExceeded
+func CanceledError(path string, cause error) *StorageError {
+	return &StorageError{Code: ErrorCodeCanceled, Message: cause.Error(), Path: path, Cause: cause}
+}
+
+// DirectoryRetentionError is returned by DeleteDirectory when some files were kept
+// because of retention locks. Everything else under the directory was deleted.
+type DirectoryRetentionError struct {
+	Path    string
+	Skipped []string
+}
+
+// Error implements the error interface
+func (e *DirectoryRetentionError) Error() string {
+	return e.storageError().Error()
+}
+
+// Unwrap exposes the error as a RETENTION_LOCKED StorageError
+func (e *DirectoryRetentionError) Unwrap() error {
+	return e.storageError()
+}
+
+// storageError builds the StorageError that summarizes the skipped files
+func (e *DirectoryRetentionError) storageError() *StorageError {
+	return NewStorageErrorWithPath(ErrorCodeRetentionLocked, fmt.Sprintf("%d files kept under retention", len(e.Skipped)), e.Path)
+}
+
+// UploadFailure is a file of a multi-file upload that could not be stored
+type UploadFailure struct {
+	FieldName    string
+	OriginalName string
+	Err          error
+}
+
+// UploadError is returned by UploadFromCtx when some files of a request could not be
+// stored. The results returned with it are the files that were.
+type UploadError struct {
+	Failures []*UploadFailure
+}
+
+// Error implements the error interface
+func (e *UploadError) Error() string {
+	first := e.Failures[0]
+	if len(e.Failures) == 1 {
+		return fmt.Sprintf("failed to upload %s: %v", first.OriginalName, first.Err)
+	}
+	return fmt.Sprintf("%d files failed to upload, first %s: %v", len(e.Failures), first.OriginalName, first.Err)
+}
+
+// Unwrap exposes the error of every failed file to errors.Is and errors.As
+func (e *UploadError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, failure := range e.Failures {
+		errs[i] = failure.Err
+	}
+	return errs
+}
+
+// MultiErrorJSONLimit bounds the failures listed when a MultiError is serialized to JSON.
+// The rest are only counted, so a directory operation that failed on every file of a large
+// tree still produces a small response.
+const MultiErrorJSONLimit = 100
+
+// MultiError collects the failures of a batch operation, keyed by path. Batch and directory
+// operations return it so that callers keep the error code of every failed path.
+type MultiError struct {
+	Errors map[string]error
+}
+
+// Add records the failure of a path
+func (e *MultiError) Add(path string, err error) {
+	if e.Errors == nil {
+		e.Errors = make(map[string]error)
+	}
+	e.Errors[path] = err
+}
+
+// Len returns the number of failed paths
+func (e *MultiError) Len() int {
+	return len(e.Errors)
+}
+
+// Paths returns the failed paths in lexical order
+func (e *MultiError) Paths() []string {
+	paths := make([]string, 0, len(e.Errors))
+	for path := range e.Errors {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Error implements the error interface, describing the first failure in path order
+func (e *MultiError) Error() string {
+	paths := e.Paths()
+	if len(paths) == 0 {
+		return "no errors"
+	}
+	if len(paths) == 1 {
+		return fmt.Sprintf("%s: %v", paths[0], e.Errors[paths[0]])
+	}
+	return fmt.Sprintf("%d operations failed, first %s: %v", len(paths), paths[0], e.Errors[paths[0]])
+}
+
+// Unwrap exposes every failure to errors.Is and errors.As
+func (e *MultiError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, path := range e.Paths() {
+		errs = append(errs, e.Errors[path])
+	}
+	return errs
+}
+
+// ErrorOrNil returns the MultiError if it holds any failure, or nil
+func (e *MultiError) ErrorOrNil() error {
+	if e == nil || len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Failed returns the failure of a path as a StorageError, or nil if the path did not fail.
+// Errors that are not StorageErrors are reported as INTERNAL_ERROR.
+func (e *MultiError) Failed(path string) *StorageError {
+	err, ok := e.Errors[path]
+	if !ok {
+		return nil
+	}
+	var storageErr *StorageError
+	if errors.As(err, &storageErr) {
+		return storageErr
+	}
+	return &StorageError{Code: ErrorCodeInternalError, Message: err.Error(), Path: path, Cause: err}
+}
+
+// Summary counts the failures by error code
+func (e *MultiError) Summary() map[ErrorCode]int {
+	summary := make(map[ErrorCode]int)
+	for path := range e.Errors {
+		summary[e.Failed(path).Code]++
+	}
+	return summary
+}
+
+// multiErrorEntry is the JSON form of one failure of a MultiError
+type multiErrorEntry struct {
+	Path    string    `json:"path"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// MarshalJSON lists the first MultiErrorJSONLimit failures in path order along with their
+// total and how many were left out
+func (e *MultiError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.body())
+}
+
+// body is the JSON form of the MultiError, which handlers extend with their message
+func (e *MultiError) body() map[string]interface{} {
+	paths := e.Paths()
+	entries := make([]multiErrorEntry, 0, min(len(paths), MultiErrorJSONLimit))
+	for _, path := range paths[:min(len(paths), MultiErrorJSONLimit)] {
+		failed := e.Failed(path)
+		entries = append(entries, multiErrorEntry{Path: path, Code: failed.Code, Message: failed.Message})
+	}
+	return map[string]interface{}{
+		"failed":    len(paths),
+		"errors":    entries,
+		"summary":   e.Summary(),
+		"truncated": len(paths) - len(entries),
+	}
+}
@@ -0,0 +1,245 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		sentinel error
+	}{
+		{"File not found", FileNotFoundError("a.txt"), ErrFileNotFound},
+		{"Directory not found", DirectoryNotFoundError("dir"), ErrDirectoryNotFound},
+		{"File already exists", FileAlreadyExistsError("a.txt"), ErrFileAlreadyExists},
+		{"Permission denied", PermissionDeniedError("a.txt"), ErrPermissionDenied},
+		{"Invalid path", InvalidPathError("../a.txt"), ErrInvalidPath},
+		{"Invalid token", InvalidTokenError("bad"), ErrInvalidToken},
+		{"Token expired", TokenExpiredError(), ErrTokenExpired},
+		{"Wrapped", fmt.Errorf("context: %w", FileNotFoundError("a.txt")), ErrFileNotFound},
+		{"Canceled", CanceledError("a.txt", context.Canceled), ErrCanceled},
+		{"Canceled cause", CanceledError("a.txt", context.DeadlineExceeded), context.DeadlineExceeded},
+		{"Archived", ArchivedError("a.txt", nil), ErrArchived},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if !errors.Is(tc.err, tc.sentinel) {
+				t.Errorf("Expected errors.Is(%v, %v) to be true", tc.err, tc.sentinel)
+			}
+		})
+	}
+
+	if errors.Is(FileNotFoundError("a.txt"), ErrDirectoryNotFound) {
+		t.Error("Different codes should not match")
+	}
+
+	if ErrFileNotFound.Error() != "[FILE_NOT_FOUND]" {
+		t.Errorf("Unexpected sentinel message '%s'", ErrFileNotFound.Error())
+	}
+}
+
+func TestStorageErrorHTTPStatus(t *testing.T) {
+	testCases := []struct {
+		code     ErrorCode
+		expected int
+	}{
+		{ErrorCodeFileNotFound, http.StatusNotFound},
+		{ErrorCodeDirectoryNotFound, http.StatusNotFound},
+		{ErrorCodeFileAlreadyExists, http.StatusConflict},
+		{ErrorCodeIsDirectory, http.StatusConflict},
+		{ErrorCodeChecksumMismatch, http.StatusBadGateway},
+		{ErrorCodeInvalidJSON, http.StatusUnprocessableEntity},
+		{ErrorCodeFileTooLarge, http.StatusRequestEntityTooLarge},
+		{ErrorCodePreconditionFailed, http.StatusPreconditionFailed},
+		{ErrorCodeLeaseHeld, http.StatusConflict},
+		{ErrorCodeArchived, http.StatusConflict},
+		{ErrorCodePermissionDenied, http.StatusForbidden},
+		{ErrorCodeReadOnly, http.StatusForbidden},
+		{ErrorCodeInvalidPath, http.StatusBadRequest},
+		{ErrorCodeUploadFailed, http.StatusBadRequest},
+		{ErrorCodeForceRequired, http.StatusBadRequest},
+		{ErrorCodeInvalidToken, http.StatusUnauthorized},
+		{ErrorCodeTokenExpired, http.StatusUnauthorized},
+		{ErrorCodeRetentionLocked, http.StatusLocked},
+		{ErrorCodeContentRejected, http.StatusUnprocessableEntity},
+		{ErrorCodeQuotaExceeded, http.StatusInsufficientStorage},
+		{ErrorCodeInsufficientStorage, http.StatusInsufficientStorage},
+		{ErrorCodeThrottled, http.StatusServiceUnavailable},
+		{ErrorCodeAuditFailed, http.StatusServiceUnavailable},
+		{ErrorCodeTimeout, http.StatusGatewayTimeout},
+		{ErrorCodeProviderError, http.StatusBadGateway},
+		{ErrorCodeNotSupported, http.StatusNotImplemented},
+		{ErrorCodeCanceled, http.StatusGatewayTimeout},
+		{ErrorCodeDownloadFailed, http.StatusInternalServerError},
+		{ErrorCodeInternalError, http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.code), func(t *testing.T) {
+			if status := NewStorageError(tc.code, "test").HTTPStatus(); status != tc.expected {
+				t.Errorf("Expected status %d, got %d", tc.expected, status)
+			}
+		})
+	}
+}
+
+func TestFileSystemProviderErrorsMatchSentinels(t *testing.T) {
+	storage, err := New(&StorageConfig{
+		Name:     "TestStorage",
+		Provider: "filesystem",
+		FileSystem: &FileSystemConfig{
+			BasePath: t.TempDir(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	ctx := context.Background()
+
+	if _, _, err := storage.Download(ctx, "missing.txt"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound from Download, got %v", err)
+	}
+
+	if err := storage.Delete(ctx, "missing.txt"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound from Delete, got %v", err)
+	}
+
+	if _, err := storage.List(ctx, "missing"); !errors.Is(err, ErrDirectoryNotFound) {
+		t.Errorf("Expected ErrDirectoryNotFound from List, got %v", err)
+	}
+
+	if _, err := storage.GetInfo(ctx, "../escape.txt"); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Expected ErrInvalidPath from GetInfo, got %v", err)
+	}
+
+	if err := storage.Copy(ctx, "missing.txt", "copy.txt"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound from Copy, got %v", err)
+	}
+}
+
+func TestMapOSError(t *testing.T) {
+	pathErr := func(errno syscall.Errno) error {
+		return &os.PathError{Op: "open", Path: "/data/clips/a.mp4", Err: errno}
+	}
+	testCases := []struct {
+		name     string
+		err      error
+		sentinel error
+		status   int
+	}{
+		{"EACCES", pathErr(syscall.EACCES), ErrPermissionDenied, http.StatusForbidden},
+		{"ENOSPC", pathErr(syscall.ENOSPC), ErrInsufficientStorage, http.StatusInsufficientStorage},
+		{"EDQUOT", pathErr(syscall.EDQUOT), ErrInsufficientStorage, http.StatusInsufficientStorage},
+		{"ENAMETOOLONG", pathErr(syscall.ENAMETOOLONG), ErrInvalidPath, http.StatusBadRequest},
+		{"ETIMEDOUT", pathErr(syscall.ETIMEDOUT), ErrTimeout, http.StatusGatewayTimeout},
+		{"Canceled", fmt.Errorf("write: %w", context.Canceled), ErrCanceled, http.StatusGatewayTimeout},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mapped := mapOSError(tc.err, "clips/a.mp4")
+			if mapped == nil || !errors.Is(mapped, tc.sentinel) || mapped.HTTPStatus() != tc.status {
+				t.Fatalf("Expected %v with status %d, got %v", tc.sentinel, tc.status, mapped)
+			}
+			if !errors.Is(mapped, tc.err) || mapped.Path != "clips/a.mp4" {
+				t.Errorf("Expected the original error kept as cause, got %v", mapped.Cause)
+			}
+		})
+	}
+
+	if mapped := mapOSError(pathErr(syscall.EIO), "clips/a.mp4"); mapped != nil {
+		t.Errorf("Expected EIO to be left to the caller, got %v", mapped)
+	}
+	if err := fileSystemError(pathErr(syscall.EIO), "clips/a.mp4", ErrorCodeUploadFailed, "failed to write"); err.Code != ErrorCodeUploadFailed || !errors.Is(err, syscall.EIO) {
+		t.Errorf("Expected other errors to keep the operation code, got %v", err)
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	var multi MultiError
+	for i := 0; i < MultiErrorJSONLimit+5; i++ {
+		path := fmt.Sprintf("clips/%03d.mp4", i)
+		multi.Add(path, FileNotFoundError(path))
+	}
+	multi.Add("clips/broken.mp4", errors.New("disk failure"))
+
+	if failed := multi.Failed("clips/000.mp4"); failed == nil || failed.Code != ErrorCodeFileNotFound {
+		t.Errorf("Expected the FILE_NOT_FOUND failure, got %v", failed)
+	}
+	if failed := multi.Failed("clips/broken.mp4"); failed == nil || failed.Code != ErrorCodeInternalError || failed.Message != "disk failure" {
+		t.Errorf("Expected a plain error reported as INTERNAL_ERROR, got %v", failed)
+	}
+	if failed := multi.Failed("clips/ok.mp4"); failed != nil {
+		t.Errorf("Expected no failure for a path that succeeded, got %v", failed)
+	}
+	if summary := multi.Summary(); summary[ErrorCodeFileNotFound] != MultiErrorJSONLimit+5 || summary[ErrorCodeInternalError] != 1 {
+		t.Errorf("Unexpected summary %v", summary)
+	}
+	if !errors.Is(&multi, ErrFileNotFound) {
+		t.Error("Expected the failures to be visible to errors.Is")
+	}
+
+	data, err := json.Marshal(&multi)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var body struct {
+		Failed    int               `json:"failed"`
+		Errors    []multiErrorEntry `json:"errors"`
+		Truncated int               `json:"truncated"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if body.Failed != MultiErrorJSONLimit+6 || len(body.Errors) != MultiErrorJSONLimit || body.Truncated != 6 {
+		t.Errorf("Expected %d listed failures and 6 truncated, got %d and %d", MultiErrorJSONLimit, len(body.Errors), body.Truncated)
+	}
+	if body.Errors[0].Path != "clips/000.mp4" || body.Errors[0].Code != ErrorCodeFileNotFound {
+		t.Errorf("Expected the failures in path order, got %+v", body.Errors[0])
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	var multi MultiError
+	multi.Add("a.txt", FileNotFoundError("a.txt"))
+	multi.Add("b.txt", PermissionDeniedError("b.txt"))
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"Partial", batchError(&multi, "Failed to copy", 5), http.StatusMultiStatus},
+		{"All failed", batchError(&multi, "Failed to copy", 2), http.StatusBadRequest},
+		{"Mutation", mutationError(fmt.Errorf("copy: %w", &multi), "Failed to copy", "dir"), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		var httpErr *echo.HTTPError
+		if !errors.As(tt.err, &httpErr) || httpErr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %v", tt.name, tt.status, tt.err)
+			continue
+		}
+		body, ok := httpErr.Message.(map[string]interface{})
+		if !ok || body["failed"] != 2 || body["message"] != "Failed to copy" {
+			t.Errorf("%s: expected a structured body, got %v", tt.name, httpErr.Message)
+		}
+	}
+
+	var servers MultiError
+	servers.Add("a.txt", errors.New("disk failure"))
+	var httpErr *echo.HTTPError
+	if err := batchError(&servers, "Failed to copy", 0); !errors.As(err, &httpErr) || httpErr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected server failures to answer 500, got %v", err)
+	}
+}
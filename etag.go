@@ -0,0 +1,65 @@
+package vsaasstorage
+
+import (
+	"net/http"
+	"strings"
+)
+
+// NormalizeETag returns the bare value of an ETag, without the weak prefix or the
+// surrounding quotes. FileInfo.ETag always holds normalized values, so ETags from
+// different providers can be compared directly.
+func NormalizeETag(etag string) string {
+	etag = strings.TrimSpace(etag)
+	etag = strings.TrimPrefix(etag, "W/")
+	if len(etag) >= 2 && strings.HasPrefix(etag, "\"") && strings.HasSuffix(etag, "\"") {
+		etag = etag[1 : len(etag)-1]
+	}
+	return etag
+}
+
+// isMultipartETag reports whether an ETag was produced by a multipart upload, such as
+// S3's "<md5 of part md5s>-<parts>". It is not a hash of the content and cannot be
+// compared with the ETag of the same content uploaded in a single part.
+func isMultipartETag(etag string) bool {
+	return strings.Contains(NormalizeETag(etag), "-")
+}
+
+// comparableETags reports whether two ETags are content hashes that can be compared
+func comparableETags(a, b string) bool {
+	return a != "" && b != "" && !isMultipartETag(a) && !isMultipartETag(b)
+}
+
+// quoteETag returns the ETag in the quoted strong form used by HTTP headers
+func quoteETag(etag string) string {
+	return "\"" + NormalizeETag(etag) + "\""
+}
+
+// quoteConditionalETags rewrites If-Match and If-None-Match so unquoted ETags sent by
+// clients still match the quoted ETag header. The request is cloned before any change.
+func quoteConditionalETags(request *http.Request) *http.Request {
+	cloned := false
+	for _, name := range []string{"If-Match", "If-None-Match"} {
+		value := request.Header.Get(name)
+		if value == "" {
+			continue
+		}
+
+		tags := strings.Split(value, ",")
+		for i, tag := range tags {
+			tag = strings.TrimSpace(tag)
+			if tag != "*" && !strings.HasPrefix(tag, "W/") {
+				tag = quoteETag(tag)
+			}
+			tags[i] = tag
+		}
+
+		if quoted := strings.Join(tags, ", "); quoted != value {
+			if !cloned {
+				request = request.Clone(request.Context())
+				cloned = true
+			}
+			request.Header.Set(name, quoted)
+		}
+	}
+	return request
+}
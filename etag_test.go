@@ -0,0 +1,66 @@
+package vsaasstorage
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNormalizeETag(t *testing.T) {
+	cases := map[string]string{
+		"abc123":       "abc123",
+		`"abc123"`:     "abc123",
+		`W/"abc123"`:   "abc123",
+		` "abc123-4" `: "abc123-4",
+		`"`:            `"`,
+		"":             "",
+	}
+	for input, expected := range cases {
+		if got := NormalizeETag(input); got != expected {
+			t.Errorf("NormalizeETag(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+
+	if got := quoteETag(`W/"abc123"`); got != `"abc123"` {
+		t.Errorf("Expected the strong quoted form, got %s", got)
+	}
+}
+
+func TestFileChangedETags(t *testing.T) {
+	older := time.Now().Add(-time.Hour)
+	newer := time.Now()
+
+	cases := []struct {
+		name    string
+		src     *FileInfo
+		dst     *FileInfo
+		changed bool
+	}{
+		{"quoted and unquoted", &FileInfo{ETag: `"abc"`}, &FileInfo{ETag: "abc"}, false},
+		{"different content", &FileInfo{ETag: "abc"}, &FileInfo{ETag: "def"}, true},
+		{"multipart falls back to time", &FileInfo{ETag: "abc", LastModified: &older}, &FileInfo{ETag: `"def-3"`, LastModified: &newer}, false},
+		{"multipart on a newer source", &FileInfo{ETag: "abc-2", LastModified: &newer}, &FileInfo{ETag: "abc", LastModified: &older}, true},
+	}
+	for _, c := range cases {
+		if got := fileChanged(c.src, c.dst); got != c.changed {
+			t.Errorf("%s: expected changed=%v, got %v", c.name, c.changed, got)
+		}
+	}
+}
+
+func TestQuoteConditionalETags(t *testing.T) {
+	request, _ := http.NewRequest(http.MethodGet, "/file", nil)
+	request.Header.Set("If-None-Match", `abc, "def", W/"ghi"`)
+	request.Header.Set("If-Match", "*")
+
+	quoted := quoteConditionalETags(request)
+	if got := quoted.Header.Get("If-None-Match"); got != `"abc", "def", W/"ghi"` {
+		t.Errorf("Unexpected If-None-Match %s", got)
+	}
+	if got := quoted.Header.Get("If-Match"); got != "*" {
+		t.Errorf("Unexpected If-Match %s", got)
+	}
+	if request.Header.Get("If-None-Match") != `abc, "def", W/"ghi"` {
+		t.Error("Expected the original request to be left unchanged")
+	}
+}
@@ -0,0 +1,388 @@
+package vsaasstorage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ImageMetadata holds the EXIF fields removed from an uploaded image, so the application
+// can store them separately when it has consent to
+type ImageMetadata struct {
+	Time      *time.Time `json:"time,omitempty"` // DateTimeOriginal, or DateTime when missing
+	Latitude  *float64   `json:"latitude,omitempty"`
+	Longitude *float64   `json:"longitude,omitempty"`
+	Make      string     `json:"make,omitempty"`
+	Model     string     `json:"model,omitempty"`
+}
+
+var (
+	jpegEXIFHeader = []byte("Exif\x00\x00")
+	jpegXMPHeaders = [][]byte{
+		[]byte("http://ns.adobe.com/xap/1.0/\x00"),
+		[]byte("http://ns.adobe.com/xmp/extension/\x00"),
+	}
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+	// PNG text chunks with these keywords carry EXIF or XMP
+	pngMetadataKeywords = []string{"XML:com.adobe.xmp", "Raw profile type exif", "Raw profile type APP1", "Raw profile type xmp"}
+)
+
+// errNotImage is returned by stripImageMetadata for content that is not a JPEG or PNG
+var errNotImage = errors.New("not a JPEG or PNG image")
+
+// maxPNGMetadataChunk bounds the PNG chunks read into memory to inspect them
+const maxPNGMetadataChunk = 16 << 20
+
+// stripUploadedImage writes a copy of a JPEG or PNG upload without its EXIF and XMP
+// blocks to a temporary file and returns it with the EXIF that was removed. It returns
+// a nil file when there is nothing to strip: other content types, or images whose
+// structure cannot be parsed, which are uploaded unchanged with a logged warning.
+func (s *Storage) stripUploadedImage(ctx context.Context, file *os.File, filePath string) (*os.File, []byte) {
+	tmp, err := os.CreateTemp("", "vsaas-strip-*")
+	if err != nil {
+		s.config.log(ctx, LogLevelWarn, "failed to strip image metadata", map[string]interface{}{
+			"path":  filePath,
+			"error": err.Error(),
+		})
+		return nil, nil
+	}
+
+	writer := bufio.NewWriter(tmp)
+	exif, err := stripImageMetadata(writer, file)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		if !errors.Is(err, errNotImage) {
+			s.config.log(ctx, LogLevelWarn, "failed to strip image metadata, uploading unchanged", map[string]interface{}{
+				"path":  filePath,
+				"error": err.Error(),
+			})
+		}
+		tmp.Close()
+		os.Remove(tmp.Name())
+		file.Seek(0, io.SeekStart)
+		return nil, nil
+	}
+
+	return tmp, exif
+}
+
+// stripImageMetadata copies a JPEG or PNG image from src to dst without EXIF and XMP
+// blocks, leaving the pixel data untouched, and returns the raw EXIF (TIFF) data it
+// removed
+func stripImageMetadata(dst io.Writer, src io.Reader) ([]byte, error) {
+	reader := bufio.NewReader(src)
+	magic, _ := reader.Peek(len(pngSignature))
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0xFF, 0xD8}):
+		return stripJPEG(dst, reader)
+	case bytes.Equal(magic, pngSignature):
+		return stripPNG(dst, reader)
+	default:
+		return nil, errNotImage
+	}
+}
+
+// stripJPEG drops the APP1 segments holding EXIF or XMP. Everything from the start of
+// scan onwards is image data and is copied as is.
+func stripJPEG(dst io.Writer, reader *bufio.Reader) ([]byte, error) {
+	var exif []byte
+
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(reader, soi); err != nil {
+		return nil, err
+	}
+	if _, err := dst.Write(soi); err != nil {
+		return nil, err
+	}
+
+	for {
+		marker, err := readJPEGMarker(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case marker == 0xDA || marker == 0xD9: // Start of scan, end of image
+			if _, err := dst.Write([]byte{0xFF, marker}); err != nil {
+				return nil, err
+			}
+			_, err := io.Copy(dst, reader)
+			return exif, err
+		case marker >= 0xD0 && marker <= 0xD7 || marker == 0x01: // No payload
+			if _, err := dst.Write([]byte{0xFF, marker}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(header))
+		if length < 2 {
+			return nil, fmt.Errorf("invalid JPEG segment length %d", length)
+		}
+		payload := make([]byte, length-2)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return nil, err
+		}
+
+		if marker == 0xE1 {
+			if bytes.HasPrefix(payload, jpegEXIFHeader) {
+				exif = payload[len(jpegEXIFHeader):]
+				continue
+			}
+			if hasAnyPrefix(payload, jpegXMPHeaders) {
+				continue
+			}
+		}
+
+		if _, err := dst.Write([]byte{0xFF, marker, header[0], header[1]}); err != nil {
+			return nil, err
+		}
+		if _, err := dst.Write(payload); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readJPEGMarker reads the next marker, skipping fill bytes
+func readJPEGMarker(reader *bufio.Reader) (byte, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("invalid JPEG marker 0x%02x", b)
+	}
+	for b == 0xFF {
+		if b, err = reader.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// stripPNG drops eXIf chunks and text chunks holding EXIF or XMP
+func stripPNG(dst io.Writer, reader *bufio.Reader) ([]byte, error) {
+	var exif []byte
+
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(reader, signature); err != nil {
+		return nil, err
+	}
+	if _, err := dst.Write(signature); err != nil {
+		return nil, err
+	}
+
+	for {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:])
+
+		switch chunkType {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+			if length > maxPNGMetadataChunk {
+				return nil, fmt.Errorf("PNG %s chunk too large", chunkType)
+			}
+			data := make([]byte, length+4) // Data and CRC
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return nil, err
+			}
+			if chunkType == "eXIf" {
+				exif = data[:length]
+				continue
+			}
+			if keyword, _, _ := bytes.Cut(data[:length], []byte{0}); slices.Contains(pngMetadataKeywords, string(keyword)) {
+				continue
+			}
+			if _, err := dst.Write(header); err != nil {
+				return nil, err
+			}
+			if _, err := dst.Write(data); err != nil {
+				return nil, err
+			}
+		default:
+			if _, err := dst.Write(header); err != nil {
+				return nil, err
+			}
+			if _, err := io.CopyN(dst, reader, length+4); err != nil {
+				return nil, err
+			}
+		}
+
+		if chunkType == "IEND" {
+			return exif, nil
+		}
+	}
+}
+
+// parseEXIF extracts the capture time, GPS position and camera from raw EXIF (TIFF) data
+func parseEXIF(data []byte) (*ImageMetadata, error) {
+	if len(data) < 8 {
+		return nil, errors.New("EXIF data too short")
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid EXIF byte order")
+	}
+
+	tiff := &tiffReader{data: data, order: order}
+	ifd0, err := tiff.readIFD(order.Uint32(data[4:8]))
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &ImageMetadata{
+		Make:  tiff.ascii(ifd0[0x010F]),
+		Model: tiff.ascii(ifd0[0x0110]),
+	}
+
+	dateTime := tiff.ascii(ifd0[0x0132])
+	if entry, ok := ifd0[0x8769]; ok {
+		if exifIFD, err := tiff.readIFD(entry.offset()); err == nil {
+			if original := tiff.ascii(exifIFD[0x9003]); original != "" {
+				dateTime = original
+			}
+		}
+	}
+	if t, err := time.Parse("2006:01:02 15:04:05", dateTime); err == nil {
+		metadata.Time = &t
+	}
+
+	if entry, ok := ifd0[0x8825]; ok {
+		if gps, err := tiff.readIFD(entry.offset()); err == nil {
+			metadata.Latitude = tiff.coordinate(gps[0x0002], tiff.ascii(gps[0x0001]), "S")
+			metadata.Longitude = tiff.coordinate(gps[0x0004], tiff.ascii(gps[0x0003]), "W")
+		}
+	}
+
+	return metadata, nil
+}
+
+// tiffEntry is a raw IFD entry
+type tiffEntry struct {
+	kind  uint16
+	count uint32
+	value []byte // The 4-byte value field, holding the value or its offset
+	order binary.ByteOrder
+}
+
+// offset returns the value field as an offset into the TIFF data
+func (e tiffEntry) offset() uint32 {
+	return e.order.Uint32(e.value)
+}
+
+// tiffReader reads IFDs from EXIF data
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// readIFD reads the entries of the IFD at offset, keyed by tag
+func (t *tiffReader) readIFD(offset uint32) (map[uint16]tiffEntry, error) {
+	if int64(offset)+2 > int64(len(t.data)) {
+		return nil, errors.New("EXIF IFD out of range")
+	}
+	count := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	if start+count*12 > len(t.data) {
+		return nil, errors.New("EXIF IFD out of range")
+	}
+
+	entries := make(map[uint16]tiffEntry, count)
+	for i := 0; i < count; i++ {
+		raw := t.data[start+i*12 : start+(i+1)*12]
+		entries[t.order.Uint16(raw)] = tiffEntry{
+			kind:  t.order.Uint16(raw[2:]),
+			count: t.order.Uint32(raw[4:]),
+			value: raw[8:12],
+			order: t.order,
+		}
+	}
+	return entries, nil
+}
+
+// bytes returns the data of an entry whose values take size bytes each
+func (t *tiffReader) bytes(entry tiffEntry, size int) []byte {
+	length := int64(entry.count) * int64(size)
+	if length <= 4 {
+		return entry.value[:length]
+	}
+	offset := int64(entry.offset())
+	if offset+length > int64(len(t.data)) {
+		return nil
+	}
+	return t.data[offset : offset+length]
+}
+
+// ascii returns an ASCII entry without its trailing NULs
+func (t *tiffReader) ascii(entry tiffEntry) string {
+	if entry.kind != 2 {
+		return ""
+	}
+	return strings.TrimRight(string(t.bytes(entry, 1)), "\x00 ")
+}
+
+// coordinate converts a GPS degrees/minutes/seconds entry to decimal degrees, negative
+// when ref matches negativeRef
+func (t *tiffReader) coordinate(entry tiffEntry, ref, negativeRef string) *float64 {
+	if entry.kind != 5 || entry.count != 3 { // Three rationals
+		return nil
+	}
+	raw := t.bytes(entry, 8)
+	if len(raw) != 24 {
+		return nil
+	}
+
+	var parts [3]float64
+	for i := range parts {
+		numerator, denominator := t.order.Uint32(raw[i*8:]), t.order.Uint32(raw[i*8+4:])
+		if denominator == 0 {
+			return nil
+		}
+		parts[i] = float64(numerator) / float64(denominator)
+	}
+
+	value := parts[0] + parts[1]/60 + parts[2]/3600
+	if ref == negativeRef {
+		value = -value
+	}
+	return &value
+}
+
+// hasAnyPrefix reports whether data starts with any of the prefixes
+func hasAnyPrefix(data []byte, prefixes [][]byte) bool {
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(data, prefix) {
+			return true
+		}
+	}
+	return false
+}
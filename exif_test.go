@@ -0,0 +1,246 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// testEXIF builds big-endian EXIF data with a camera, capture time and GPS position
+// (33°26'24" S, 70°39'0" W)
+func testEXIF() []byte {
+	data := make([]byte, 216)
+	order := binary.BigEndian
+	copy(data, "MM\x00\x2a")
+	order.PutUint32(data[4:], 8)
+
+	entry := func(at int, tag, kind uint16, count uint32, value []byte) {
+		order.PutUint16(data[at:], tag)
+		order.PutUint16(data[at+2:], kind)
+		order.PutUint32(data[at+4:], count)
+		copy(data[at+8:at+12], value)
+	}
+	offset := func(v uint32) []byte {
+		b := make([]byte, 4)
+		order.PutUint32(b, v)
+		return b
+	}
+	rationals := func(at int, values ...uint32) {
+		for i, v := range values {
+			order.PutUint32(data[at+i*4:], v)
+		}
+	}
+
+	order.PutUint16(data[8:], 4) // IFD0
+	entry(10, 0x010F, 2, 6, offset(62))
+	entry(22, 0x0110, 2, 7, offset(68))
+	entry(34, 0x8769, 4, 1, offset(76))
+	entry(46, 0x8825, 4, 1, offset(114))
+	copy(data[62:], "Canon\x00")
+	copy(data[68:], "EOS R5\x00")
+
+	order.PutUint16(data[76:], 1) // Exif IFD
+	entry(78, 0x9003, 2, 20, offset(94))
+	copy(data[94:], "2024:06:01 12:30:00\x00")
+
+	order.PutUint16(data[114:], 4) // GPS IFD
+	entry(116, 0x0001, 2, 2, []byte("S\x00"))
+	entry(128, 0x0002, 5, 3, offset(168))
+	entry(140, 0x0003, 2, 2, []byte("W\x00"))
+	entry(152, 0x0004, 5, 3, offset(192))
+	rationals(168, 33, 1, 26, 1, 24, 1)
+	rationals(192, 70, 1, 39, 1, 0, 1)
+
+	return data
+}
+
+// testImage returns a small image with some detail to compare pixels
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+		}
+	}
+	return img
+}
+
+// jpegWithMetadata encodes a JPEG and inserts EXIF and XMP APP1 segments after SOI
+func jpegWithMetadata(t *testing.T, exif []byte) []byte {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(), nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+
+	segment := func(payload []byte) []byte {
+		header := []byte{0xFF, 0xE1, 0, 0}
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)+2))
+		return append(header, payload...)
+	}
+
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:2])
+	out.Write(segment(append([]byte("Exif\x00\x00"), exif...)))
+	out.Write(segment([]byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>GPS</x:xmpmeta>")))
+	out.Write(encoded.Bytes()[2:])
+	return out.Bytes()
+}
+
+// pngWithMetadata encodes a PNG and inserts eXIf, XMP and comment chunks before IEND
+func pngWithMetadata(t *testing.T, exif []byte) []byte {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, testImage()); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+
+	chunk := func(kind string, data []byte) []byte {
+		out := make([]byte, 8, 12+len(data))
+		binary.BigEndian.PutUint32(out, uint32(len(data)))
+		copy(out[4:], kind)
+		out = append(out, data...)
+		crc := crc32.NewIEEE()
+		crc.Write(out[4:])
+		return binary.BigEndian.AppendUint32(out, crc.Sum32())
+	}
+
+	iend := len(encoded.Bytes()) - 12
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:iend])
+	out.Write(chunk("eXIf", exif))
+	out.Write(chunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>")))
+	out.Write(chunk("tEXt", []byte("Comment\x00kept")))
+	out.Write(encoded.Bytes()[iend:])
+	return out.Bytes()
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	exif := testEXIF()
+
+	for name, tc := range map[string]struct {
+		content []byte
+		decode  func(io.Reader) (image.Image, error)
+	}{
+		"jpeg": {jpegWithMetadata(t, exif), jpeg.Decode},
+		"png":  {pngWithMetadata(t, exif), png.Decode},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			removed, err := stripImageMetadata(&out, bytes.NewReader(tc.content))
+			if err != nil {
+				t.Fatalf("stripImageMetadata failed: %v", err)
+			}
+			if !bytes.Equal(removed, exif) {
+				t.Error("Expected the removed EXIF to be returned")
+			}
+			if bytes.Contains(out.Bytes(), []byte("Canon")) || bytes.Contains(out.Bytes(), []byte("xmpmeta")) {
+				t.Error("Expected EXIF and XMP to be removed")
+			}
+
+			original, _ := tc.decode(bytes.NewReader(tc.content))
+			stripped, err := tc.decode(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatalf("Stripped image does not decode: %v", err)
+			}
+			bounds := original.Bounds()
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+					if original.At(x, y) != stripped.At(x, y) {
+						t.Fatalf("Pixel (%d, %d) changed", x, y)
+					}
+				}
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	stripImageMetadata(&out, bytes.NewReader(pngWithMetadata(t, exif)))
+	if !bytes.Contains(out.Bytes(), []byte("Comment\x00kept")) {
+		t.Error("Expected unrelated text chunks to be kept")
+	}
+
+	if _, err := stripImageMetadata(io.Discard, bytes.NewReader([]byte("plain text"))); err != errNotImage {
+		t.Errorf("Expected errNotImage, got %v", err)
+	}
+}
+
+func TestParseEXIF(t *testing.T) {
+	metadata, err := parseEXIF(testEXIF())
+	if err != nil {
+		t.Fatalf("parseEXIF failed: %v", err)
+	}
+
+	if metadata.Make != "Canon" || metadata.Model != "EOS R5" {
+		t.Errorf("Unexpected camera %q %q", metadata.Make, metadata.Model)
+	}
+	if metadata.Time == nil || metadata.Time.Format("2006-01-02 15:04:05") != "2024-06-01 12:30:00" {
+		t.Errorf("Unexpected time %v", metadata.Time)
+	}
+	if metadata.Latitude == nil || math.Abs(*metadata.Latitude+33.44) > 1e-9 {
+		t.Errorf("Unexpected latitude %v", metadata.Latitude)
+	}
+	if metadata.Longitude == nil || math.Abs(*metadata.Longitude+70.65) > 1e-9 {
+		t.Errorf("Unexpected longitude %v", metadata.Longitude)
+	}
+
+	truncated := testEXIF()[:40]
+	if _, err := parseEXIF(truncated); err == nil {
+		t.Error("Expected an error for truncated EXIF")
+	}
+}
+
+func TestUploadStripEXIF(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{Name: "Snapshots", Provider: "memory", StripEXIF: true, ExtractEXIF: true})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	upload := func(content []byte, name string) (*UploadedFileResult, []byte) {
+		file := filepath.Join(t.TempDir(), name)
+		os.WriteFile(file, content, 0644)
+		result, err := storage.UploadFromUploadedFile(ctx, &rest.UploadedFile{Path: file, Filename: name, OriginalName: name, MimeType: "image/jpeg"}, "file", "snapshots")
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		reader, _, _ := storage.Download(ctx, result.Path)
+		defer reader.Close()
+		stored, _ := io.ReadAll(reader)
+		return result, stored
+	}
+
+	result, stored := upload(jpegWithMetadata(t, testEXIF()), "snap.jpg")
+	if bytes.Contains(stored, []byte("Canon")) {
+		t.Error("Expected the stored image to have no EXIF")
+	}
+	if result.EXIF == nil || result.EXIF.Model != "EOS R5" || result.EXIF.Latitude == nil {
+		t.Errorf("Expected the extracted EXIF in the result, got %+v", result.EXIF)
+	}
+
+	// Unreadable EXIF is still removed, without failing the upload
+	_, stored = upload(jpegWithMetadata(t, []byte("garbage")), "garbage.jpg")
+	if bytes.Contains(stored, []byte("garbage")) {
+		t.Error("Expected unreadable EXIF to be removed")
+	}
+
+	// A truncated image cannot be parsed and is stored unchanged
+	truncated := jpegWithMetadata(t, testEXIF())[:30]
+	if _, stored = upload(truncated, "broken.jpg"); !bytes.Equal(stored, truncated) {
+		t.Error("Expected an unparseable image to be stored unchanged")
+	}
+
+	if _, stored = upload([]byte("not an image"), "notes.txt"); string(stored) != "not an image" {
+		t.Error("Expected other content to pass through unchanged")
+	}
+}
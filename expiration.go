@@ -0,0 +1,101 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// CleanupExpired permanently deletes every file whose expiration time has passed and
+// returns how many were removed. It is intended to run periodically from a scheduler.
+func (s *Storage) CleanupExpired(ctx context.Context) (int, error) {
+	if err := s.checkWritable(""); err != nil {
+		return 0, err
+	}
+
+	now := s.config.now()
+
+	var expired []*FileInfo
+	err := s.walk(ctx, "", allEntries, func(info *FileInfo) error {
+		if !info.IsDirectory && info.isExpired(now) {
+			expired = append(expired, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, info := range expired {
+		filePath := info.Path
+		if err := checkContext(ctx, filePath); err != nil {
+			return deleted, err
+		}
+
+		// Expired files skip the trash
+		if err := s.provider.Delete(ctx, filePath); err != nil {
+			if errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrRetentionLocked) {
+				continue // Removed concurrently or held by retention
+			}
+			return deleted, err
+		}
+		if s.quota != nil {
+			if err := s.releaseQuota([]*FileInfo{info}); err != nil {
+				return deleted, err
+			}
+		}
+		s.journal(ctx, JournalOperationDelete, filePath, "", nil)
+		s.invalidateDerived(ctx, filePath, true)
+		deleted++
+	}
+
+	s.config.incCounter("storage_expired_deleted_total", int64(deleted), map[string]string{
+		"storage": s.config.Name,
+	})
+
+	return deleted, nil
+}
+
+// StartExpirationWorker runs CleanupExpired in the background every interval, with up to
+// 10% of random jitter so that several instances do not sweep in lockstep. It stops when
+// ctx is cancelled.
+func (s *Storage) StartExpirationWorker(ctx context.Context, interval time.Duration) {
+	go runEvery(ctx, s.config.clock(), interval, func() {
+		deleted, err := s.CleanupExpired(ctx)
+		if err != nil && ctx.Err() == nil {
+			s.config.log(ctx, LogLevelError, "expiration cleanup failed", map[string]interface{}{
+				"storage": s.config.Name,
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		if deleted > 0 {
+			s.config.log(ctx, LogLevelInfo, "expired files deleted", map[string]interface{}{
+				"storage": s.config.Name,
+				"deleted": deleted,
+			})
+		}
+	})
+}
+
+// runEvery calls fn every interval of clock plus up to 10% of random jitter until ctx is
+// cancelled
+func runEvery(ctx context.Context, clock Clock, interval time.Duration, fn func()) {
+	for {
+		wait := interval
+		if jitter := int64(interval / 10); jitter > 0 {
+			wait += time.Duration(rand.Int63n(jitter))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(wait):
+		}
+
+		fn()
+	}
+}
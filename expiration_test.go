@@ -0,0 +1,119 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpiration(t *testing.T) {
+	ctx := context.Background()
+
+	for _, provider := range []string{"filesystem", "memory"} {
+		t.Run(provider, func(t *testing.T) {
+			config := &StorageConfig{
+				Name:     "ExpiringStorage",
+				Provider: provider,
+			}
+			if provider == "filesystem" {
+				config.FileSystem = &FileSystemConfig{BasePath: t.TempDir()}
+			}
+
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			past := time.Now().Add(-time.Minute)
+			uploads := map[string]*FileMetadata{
+				"exports/old.mp4":   {ExpiresAt: &past},
+				"exports/fresh.mp4": {TTL: time.Hour},
+				"exports/keep.mp4":  nil,
+			}
+			for path, metadata := range uploads {
+				if _, err := storage.Upload(ctx, path, strings.NewReader(path), metadata); err != nil {
+					t.Fatalf("Upload of %s failed: %v", path, err)
+				}
+			}
+
+			if _, _, err := storage.Download(ctx, "exports/old.mp4"); !errors.Is(err, ErrFileNotFound) {
+				t.Errorf("Expected expired download to be not found, got %v", err)
+			}
+			if exists, err := storage.Exists(ctx, "exports/old.mp4"); exists || err != nil {
+				t.Errorf("Expected an expired file not to exist before the sweep, got %v, %v", exists, err)
+			}
+
+			info, err := storage.GetInfo(ctx, "exports/fresh.mp4")
+			if err != nil {
+				t.Fatalf("GetInfo failed: %v", err)
+			}
+			if info.ExpiresAt == nil || time.Until(*info.ExpiresAt) < 59*time.Minute {
+				t.Errorf("Expected expiration about an hour ahead, got %v", info.ExpiresAt)
+			}
+
+			deleted, err := storage.CleanupExpired(ctx)
+			if err != nil || deleted != 1 {
+				t.Fatalf("Expected 1 expired file deleted, got %d, %v", deleted, err)
+			}
+
+			files, err := storage.List(ctx, "exports")
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			if len(files) != 2 {
+				t.Errorf("Expected 2 remaining files, got %d", len(files))
+			}
+
+			// Moving a file onto itself keeps it as it is
+			if err := storage.Move(ctx, "exports/fresh.mp4", "exports/fresh.mp4"); err != nil {
+				t.Fatalf("Move onto itself failed: %v", err)
+			}
+			if info, err := storage.GetInfo(ctx, "exports/fresh.mp4"); err != nil || info.ExpiresAt == nil {
+				t.Errorf("Expected the expiration to survive a move onto itself, got %+v, %v", info, err)
+			}
+
+			// Moving keeps the expiration with the file
+			if err := storage.Move(ctx, "exports/fresh.mp4", "shared/fresh.mp4"); err != nil {
+				t.Fatalf("Move failed: %v", err)
+			}
+			info, err = storage.GetInfo(ctx, "shared/fresh.mp4")
+			if err != nil || info.ExpiresAt == nil {
+				t.Errorf("Expected expiration to follow the move, got %+v, %v", info, err)
+			}
+
+			// Re-uploading without expiration clears it
+			storage.Upload(ctx, "shared/fresh.mp4", strings.NewReader("x"), nil)
+			info, _ = storage.GetInfo(ctx, "shared/fresh.mp4")
+			if info.ExpiresAt != nil {
+				t.Errorf("Expected expiration to be cleared, got %v", info.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestExpirationWorker(t *testing.T) {
+	storage, err := New(&StorageConfig{
+		Name:     "ExpiringStorage",
+		Provider: "memory",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage.Upload(ctx, "clip.mp4", strings.NewReader("x"), &FileMetadata{TTL: time.Millisecond})
+	storage.StartExpirationWorker(ctx, 5*time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if exists, _ := storage.Exists(ctx, "clip.mp4"); !exists {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("Expected the worker to delete the expired file")
+}
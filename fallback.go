@@ -0,0 +1,168 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+// FallbackOptions controls how a storage created with WithFallback reads from its secondary
+type FallbackOptions struct {
+	// MigrateOnRead copies a file found in the secondary into the primary in the background,
+	// the first time its content is read, so later reads hit the primary. A file written to
+	// the primary in the meantime is never overwritten on providers with ConditionalProvider.
+	MigrateOnRead bool
+}
+
+// fallbackSource is the secondary storage a fallback view reads missing files from
+type fallbackSource struct {
+	storage   *Storage
+	opts      FallbackOptions
+	migrating sync.Map // Paths being copied into the primary
+}
+
+// WithFallback returns a view of the storage that reads the files missing from it from
+// secondary, e.g. the old storage during a migration: Download, ReadRange, GetInfo, Exists
+// and the handlers serving files try the primary first and, only when it reports the file
+// as not found, the secondary. Writes always go to the primary. Listings include the files
+// of the secondary with ListOptions.IncludeFallback.
+func (s *Storage) WithFallback(secondary *Storage, opts ...FallbackOptions) *Storage {
+	var options FallbackOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	return &Storage{
+		provider:     s.provider,
+		config:       s.config,
+		quota:        s.quota,
+		idempotency:  s.idempotency,
+		uploadMemory: s.uploadMemory,
+		fallback:     &fallbackSource{storage: secondary, opts: options},
+		derived:      s.derived,
+	}
+}
+
+// fallsBack reports whether a read that failed with err is retried on the secondary
+func (s *Storage) fallsBack(err error) bool {
+	return s.fallback != nil && errors.Is(err, ErrFileNotFound)
+}
+
+// migrate copies a file read from the secondary into the primary s in the background when
+// MigrateOnRead is set. Concurrent reads of the same file start a single copy.
+func (f *fallbackSource) migrate(ctx context.Context, s *Storage, path string) {
+	if !f.opts.MigrateOnRead || s.config.ReadOnly {
+		return
+	}
+	if _, busy := f.migrating.LoadOrStore(path, true); busy {
+		return
+	}
+
+	// The copy outlives the read that started it
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer f.migrating.Delete(path)
+		if err := f.copyForward(ctx, s, path); err != nil {
+			s.config.log(ctx, LogLevelWarn, "failed to migrate file from the fallback storage", map[string]interface{}{
+				"storage": s.config.Name,
+				"path":    path,
+				"error":   err.Error(),
+			})
+			return
+		}
+		s.config.incCounter("storage_fallback_migrated_total", 1, map[string]string{"storage": s.config.Name})
+	}()
+}
+
+// copyForward copies a file from the secondary into the primary s, without replacing a
+// version written to the primary since it was found missing when the provider allows it
+func (f *fallbackSource) copyForward(ctx context.Context, s *Storage, path string) error {
+	reader, info, err := f.storage.Download(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	metadata := &FileMetadata{
+		ContentType:    info.ContentType,
+		CustomMetadata: info.Metadata,
+		ExpiresAt:      info.ExpiresAt,
+	}
+	if _, ok := providerAs[ConditionalProvider](s.provider); !ok {
+		_, err = s.Upload(ctx, path, reader, metadata)
+		return err
+	}
+	if _, err = s.UploadIfMatch(ctx, path, reader, metadata, ""); errors.Is(err, ErrPreconditionFailed) {
+		return nil // Written to the primary in the meantime
+	}
+	return err
+}
+
+// download reads a file missing from the primary s from the secondary
+func (f *fallbackSource) download(ctx context.Context, s *Storage, path string) (io.ReadCloser, *FileInfo, error) {
+	reader, info, err := f.storage.Download(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	f.migrate(ctx, s, path)
+	return reader, info, nil
+}
+
+// readRange reads part of a file missing from the primary s from the secondary
+func (f *fallbackSource) readRange(ctx context.Context, s *Storage, path string, offset, length int64) (io.ReadCloser, *FileInfo, error) {
+	reader, info, err := f.storage.ReadRange(ctx, path, offset, length)
+	if err != nil {
+		return nil, nil, err
+	}
+	f.migrate(ctx, s, path)
+	return reader, info, nil
+}
+
+// openContent opens a file missing from the primary s from the secondary, to be served
+func (f *fallbackSource) openContent(ctx context.Context, s *Storage, path string, info *FileInfo) (io.ReadSeekCloser, error) {
+	content, err := f.storage.openContent(ctx, path, info)
+	if err != nil {
+		return nil, err
+	}
+	f.migrate(ctx, s, path)
+	return content, nil
+}
+
+// listMerged lists a directory in the primary s and the secondary. Entries present in both
+// are taken from the primary. The directory is missing only when it is in neither.
+func (s *Storage) listMerged(ctx context.Context, path string, opts ListOptions) ([]*FileInfo, error) {
+	single := opts
+	single.IncludeFallback = false
+	single.AllowMissing = false
+
+	files, err := s.ListWithOptions(ctx, path, single)
+	primaryMissing := errors.Is(err, ErrDirectoryNotFound)
+	if err != nil && !primaryMissing {
+		return nil, err
+	}
+	secondary, err := s.fallback.storage.ListWithOptions(ctx, path, single)
+	secondaryMissing := errors.Is(err, ErrDirectoryNotFound)
+	if err != nil && !secondaryMissing {
+		return nil, err
+	}
+	if primaryMissing && secondaryMissing {
+		if opts.AllowMissing {
+			return []*FileInfo{}, nil
+		}
+		return nil, DirectoryNotFoundError(path)
+	}
+
+	listed := make(map[string]bool, len(files))
+	for _, file := range files {
+		listed[file.Name] = true
+	}
+	merged := files
+	for _, file := range secondary {
+		if !listed[file.Name] {
+			merged = append(merged, file)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name < merged[j].Name })
+	return merged, nil
+}
@@ -0,0 +1,168 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestWithFallback(t *testing.T) {
+	ctx := context.Background()
+	primary, err := New(&StorageConfig{Name: "primary", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir()}})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	secondary, err := New(&StorageConfig{Name: "secondary", Provider: "memory"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	primary.Upload(ctx, "clips/new.mp4", strings.NewReader("new"), nil)
+	secondary.Upload(ctx, "clips/new.mp4", strings.NewReader("stale"), nil)
+	secondary.Upload(ctx, "clips/old.mp4", strings.NewReader("old"), &FileMetadata{ContentType: "video/mp4"})
+	secondary.Upload(ctx, "archive/2023.mp4", strings.NewReader("older"), nil)
+
+	storage := primary.WithFallback(secondary)
+
+	t.Run("Reads", func(t *testing.T) {
+		for path, want := range map[string]string{"clips/new.mp4": "new", "clips/old.mp4": "old"} {
+			reader, _, err := storage.Download(ctx, path)
+			if err != nil {
+				t.Fatalf("Download %s failed: %v", path, err)
+			}
+			content, _ := io.ReadAll(reader)
+			reader.Close()
+			if string(content) != want {
+				t.Errorf("Download %s: expected %q, got %q", path, want, content)
+			}
+		}
+
+		if info, err := storage.GetInfo(ctx, "clips/old.mp4"); err != nil || info.ContentType != "video/mp4" {
+			t.Errorf("Expected the info of the secondary, got %v, %v", info, err)
+		}
+		if exists, err := storage.Exists(ctx, "clips/old.mp4"); err != nil || !exists {
+			t.Errorf("Expected the file of the secondary to exist, got %v, %v", exists, err)
+		}
+		if reader, _, err := storage.ReadRange(ctx, "clips/old.mp4", 1, 1); err != nil {
+			t.Errorf("ReadRange failed: %v", err)
+		} else if content, _ := io.ReadAll(reader); string(content) != "l" {
+			t.Errorf("Expected a range of the secondary, got %q", content)
+		}
+		if _, _, err := storage.Download(ctx, "clips/missing.mp4"); !errors.Is(err, ErrFileNotFound) {
+			t.Errorf("Expected a file in neither to be missing, got %v", err)
+		}
+		if _, err := primary.GetInfo(ctx, "clips/old.mp4"); !errors.Is(err, ErrFileNotFound) {
+			t.Errorf("Expected the primary itself not to fall back, got %v", err)
+		}
+	})
+
+	t.Run("StreamFile", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/download/clips/old.mp4", nil)
+		recorder := httptest.NewRecorder()
+		c := &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder)}
+		if err := storage.StreamFile(c, "clips/old.mp4"); err != nil {
+			t.Fatalf("StreamFile failed: %v", err)
+		}
+		if recorder.Code != http.StatusOK || recorder.Body.String() != "old" {
+			t.Errorf("Expected the file of the secondary to be served, got %d %q", recorder.Code, recorder.Body.String())
+		}
+	})
+
+	t.Run("Writes", func(t *testing.T) {
+		if _, err := storage.Upload(ctx, "clips/written.mp4", strings.NewReader("written"), nil); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		if exists, _ := primary.Exists(ctx, "clips/written.mp4"); !exists {
+			t.Error("Expected writes to go to the primary")
+		}
+		if exists, _ := secondary.Exists(ctx, "clips/written.mp4"); exists {
+			t.Error("Expected the secondary to be left alone")
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		files, err := storage.List(ctx, "clips")
+		if err != nil || len(files) != 2 {
+			t.Fatalf("Expected only the primary listed by default, got %d files, %v", len(files), err)
+		}
+
+		files, err = storage.ListWithOptions(ctx, "clips", ListOptions{IncludeFallback: true})
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name)
+		}
+		if strings.Join(names, ",") != "new.mp4,old.mp4,written.mp4" || files[0].Size != 3 {
+			t.Errorf("Expected both sources merged with the primary first, got %v", names)
+		}
+
+		if files, err := storage.ListWithOptions(ctx, "archive", ListOptions{IncludeFallback: true}); err != nil || len(files) != 1 {
+			t.Errorf("Expected a directory only in the secondary listed, got %v, %v", files, err)
+		}
+		if _, err := storage.ListWithOptions(ctx, "missing", ListOptions{IncludeFallback: true}); !errors.Is(err, ErrDirectoryNotFound) {
+			t.Errorf("Expected a directory in neither to be missing, got %v", err)
+		}
+	})
+}
+
+func TestFallbackMigrateOnRead(t *testing.T) {
+	ctx := context.Background()
+	primary, _ := New(&StorageConfig{Name: "primary", Provider: "memory"})
+	secondary, _ := New(&StorageConfig{Name: "secondary", Provider: "memory"})
+	secondary.Upload(ctx, "clips/old.mp4", strings.NewReader("old"), &FileMetadata{ContentType: "video/mp4", CustomMetadata: map[string]string{"camera": "7"}})
+	secondary.Upload(ctx, "clips/raced.mp4", strings.NewReader("stale"), nil)
+
+	storage := primary.WithFallback(secondary, FallbackOptions{MigrateOnRead: true})
+
+	waitFor := func(path string) *FileInfo {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if info, err := primary.GetInfo(ctx, path); err == nil {
+				return info
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("Expected %s to be migrated to the primary", path)
+		return nil
+	}
+
+	if _, err := storage.GetInfo(ctx, "clips/old.mp4"); err != nil {
+		t.Fatalf("GetInfo failed: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if exists, _ := primary.Exists(ctx, "clips/old.mp4"); exists {
+		t.Error("Expected GetInfo not to migrate the file")
+	}
+
+	reader, _, err := storage.Download(ctx, "clips/old.mp4")
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	reader.Close()
+	info := waitFor("clips/old.mp4")
+	if info.ContentType != "video/mp4" || info.Metadata["camera"] != "7" {
+		t.Errorf("Expected the content type and metadata to be migrated, got %+v", info)
+	}
+
+	// A file written to the primary while it is read from the secondary is kept
+	reader, _, err = storage.Download(ctx, "clips/raced.mp4")
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	reader.Close()
+	storage.Upload(ctx, "clips/raced.mp4", strings.NewReader("fresh"), nil)
+	time.Sleep(20 * time.Millisecond)
+	reader, _, _ = primary.Download(ctx, "clips/raced.mp4")
+	if content, _ := io.ReadAll(reader); string(content) != "fresh" {
+		t.Errorf("Expected the migration not to overwrite a newer file, got %q", content)
+	}
+}
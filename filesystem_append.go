@@ -0,0 +1,261 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// pathLocks serializes operations on the same file within the process
+type pathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+// pathLock is a mutex shared by the callers holding or waiting for a path
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+// appendLocks serializes appends to the same file, so each call is written as a whole
+// even when several storages share a base path
+var appendLocks = &pathLocks{locks: make(map[string]*pathLock)}
+
+// lock acquires the lock of a path and returns the function releasing it
+func (l *pathLocks) lock(key string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &pathLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// conditionalLocks serializes conditional uploads to the same file, also across
+// storages sharing a base path. Downloads and GetInfo take it too, so they never see an
+// upload halfway, such as the file created by uploadExclusive before its content or new
+// content beside the sidecar of the previous upload; plain uploads take it to publish both.
+var conditionalLocks = &pathLocks{locks: make(map[string]*pathLock)}
+
+// UploadIfMatch uploads a file if the current one has etag, or if there is none when
+// etag is empty. New files are created with O_EXCL, so creation is atomic across
+// processes; replacements are serialized within the process only. Files without a
+// stored checksum never match an ETag.
+func (p *FileSystemProvider) UploadIfMatch(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata, etag string) (*FileInfo, error) {
+	if err := checkContext(ctx, path); err != nil {
+		return nil, err
+	}
+	if err := checkTempName(path); err != nil {
+		return nil, err
+	}
+	fullPath, err := p.getFullPath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer conditionalLocks.lock(fullPath)()
+
+	if etag == "" {
+		return p.uploadExclusive(ctx, path, fullPath, reader, metadata)
+	}
+
+	info, err := p.fileInfo(path, fullPath)
+	switch {
+	case errors.Is(err, ErrFileNotFound):
+		return nil, PreconditionFailedError(path)
+	case err != nil:
+		return nil, err
+	case info.IsDirectory || NormalizeETag(info.ETag) != etag:
+		return nil, PreconditionFailedError(path)
+	}
+	return p.upload(ctx, path, reader, metadata, true)
+}
+
+// uploadExclusive claims fullPath with an empty O_EXCL file before uploading over it, so
+// only one of several processes creating the same file succeeds
+func (p *FileSystemProvider) uploadExclusive(ctx context.Context, path, fullPath string, reader io.Reader, metadata *FileMetadata) (*FileInfo, error) {
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to create directory")
+	}
+	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, PreconditionFailedError(path)
+	}
+	if err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to create file")
+	}
+	file.Close()
+
+	info, err := p.upload(ctx, path, reader, metadata, true)
+	if err != nil {
+		os.Remove(fullPath)
+		return nil, err
+	}
+	return info, nil
+}
+
+// Append adds the content of reader to the end of a file, creating it if needed. The file
+// is opened with O_APPEND and appends to the same path are serialized, so records written
+// whole by each call never interleave. If the write fails the file is truncated back to its
+// previous size. The returned FileInfo has no ETag, as only the appended bytes are read.
+func (p *FileSystemProvider) Append(ctx context.Context, path string, reader io.Reader) (*FileInfo, error) {
+	if err := checkContext(ctx, path); err != nil {
+		return nil, err
+	}
+	if err := checkTempName(path); err != nil {
+		return nil, err
+	}
+	fullPath, err := p.getFullPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := appendLocks.lock(fullPath)
+	defer unlock()
+
+	// Files under retention cannot be modified
+	if err := checkRetention(fullPath, path, p.config.now()); err != nil {
+		return nil, err
+	}
+	if err := p.checkFreeSpace(path, reader); err != nil {
+		return nil, err
+	}
+	if err := p.checkCaseCollision(path, fullPath); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to create directory")
+	}
+
+	// Deduplicated content is shared through hard links and must not grow in place
+	if p.deduplicating() {
+		if err := p.detachBlob(path, fullPath); err != nil {
+			return nil, err
+		}
+	}
+
+	existing, statErr := os.Stat(fullPath)
+	if statErr == nil && existing.IsDir() {
+		return nil, NewStorageErrorWithPath(ErrorCodeInvalidPath, "path is a directory", path)
+	}
+	created := os.IsNotExist(statErr)
+
+	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to open file")
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeInternalError, "failed to get file stats")
+	}
+	previousSize := stat.Size()
+
+	_, err = copyContext(ctx, file, reader, p.config.GetCopyBufferSize())
+	if err == nil {
+		err = p.syncFile(file)
+	}
+	if err != nil {
+		if created {
+			os.Remove(fullPath)
+		} else {
+			file.Truncate(previousSize) // Drop the partial record
+		}
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to append to file")
+	}
+
+	if created {
+		if p.config.FileSystem.Permissions != "" {
+			if perm, err := strconv.ParseUint(p.config.FileSystem.Permissions, 8, 32); err == nil {
+				os.Chmod(fullPath, os.FileMode(perm))
+			}
+		}
+		if err := p.syncDir(fullPath); err != nil {
+			return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to sync directory")
+		}
+	}
+
+	if stat, err = file.Stat(); err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeInternalError, "failed to get file stats")
+	}
+
+	modTime := stat.ModTime()
+	info := &FileInfo{
+		Path:         path,
+		Name:         filepath.Base(path),
+		Size:         stat.Size(),
+		ContentType:  fileSystemContentType(path, nil),
+		LastModified: &modTime,
+	}
+	applySidecar(info, fullPath, p.config.now())
+	return info, nil
+}
+
+// detachBlob replaces a file linked to deduplicated content with a private copy
+func (p *FileSystemProvider) detachBlob(path, fullPath string) error {
+	sidecar := readSidecar(fullPath)
+	if sidecar == nil || sidecar.Blob == "" {
+		return nil
+	}
+
+	src, err := os.Open(fullPath)
+	if err != nil {
+		return fileSystemError(err, path, ErrorCodeUploadFailed, "failed to open file")
+	}
+	defer src.Close()
+
+	tmpDir := filepath.Join(p.config.FileSystem.BasePath, blobsDir, blobsTmpDir)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return fileSystemError(err, path, ErrorCodeUploadFailed, "failed to create blob directory")
+	}
+	tmp, err := os.CreateTemp(tmpDir, "append-*")
+	if err != nil {
+		return fileSystemError(err, path, ErrorCodeUploadFailed, "failed to create file")
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	_, err = copyBuffer(tmp, src, p.config.GetCopyBufferSize())
+	if err == nil {
+		err = p.syncFile(tmp)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if stat, statErr := src.Stat(); statErr == nil {
+			os.Chmod(tmp.Name(), stat.Mode().Perm())
+			os.Chtimes(tmp.Name(), time.Now(), stat.ModTime())
+		}
+		err = os.Rename(tmp.Name(), fullPath)
+	}
+	if err != nil {
+		return fileSystemError(err, path, ErrorCodeUploadFailed, "failed to copy deduplicated content")
+	}
+
+	blob := sidecar.Blob
+	sidecar.Blob = ""
+	if err := writeSidecar(fullPath, sidecar); err != nil {
+		return fileSystemError(err, path, ErrorCodeUploadFailed, "failed to write metadata")
+	}
+	p.releaseBlob(blob)
+	return nil
+}
@@ -0,0 +1,37 @@
+package vsaasstorage
+
+import (
+	"context"
+	"io"
+)
+
+// Capacity reports the space of the volume holding BasePath
+func (p *FileSystemProvider) Capacity(ctx context.Context) (*CapacityInfo, error) {
+	if err := checkContext(ctx, ""); err != nil {
+		return nil, err
+	}
+	info, err := volumeCapacity(p.config.FileSystem.BasePath)
+	if err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeInternalError, "failed to read the capacity of the volume", err)
+	}
+	return info, nil
+}
+
+// checkFreeSpace fails with ErrInsufficientStorage when writing the content of reader,
+// when its size is known, would leave less than MinFreeBytes available on the volume.
+// Volumes whose capacity cannot be read are not checked.
+func (p *FileSystemProvider) checkFreeSpace(path string, reader io.Reader) error {
+	minFree := p.config.FileSystem.MinFreeBytes
+	if minFree <= 0 {
+		return nil
+	}
+	info, err := volumeCapacity(p.config.FileSystem.BasePath)
+	if err != nil {
+		return nil
+	}
+	size, _ := readerSize(reader)
+	if info.Available-size < minFree {
+		return NewStorageErrorWithPath(ErrorCodeInsufficientStorage, "not enough free space on the volume", path)
+	}
+	return nil
+}
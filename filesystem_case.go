@@ -0,0 +1,109 @@
+package vsaasstorage
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxCaseRenames bounds the names tried by uniqueFilename before giving up
+const maxCaseRenames = 10
+
+// checkCaseCollision fails with ErrorCodeFileAlreadyExists when a component of path
+// differs only by case from an existing entry, which would shadow it on case-insensitive
+// clients. Components are compared with the listing of their parent, from the base path
+// down to the first one that does not exist yet, as deeper ones cannot collide.
+func (p *FileSystemProvider) checkCaseCollision(path, fullPath string) error {
+	if !p.config.FileSystem.CaseInsensitiveCheck {
+		return nil
+	}
+
+	base := filepath.Clean(p.config.FileSystem.BasePath)
+	rel, err := filepath.Rel(base, fullPath)
+	if err != nil || rel == "." {
+		return nil
+	}
+
+	dir := base
+	for _, component := range strings.Split(rel, string(filepath.Separator)) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil // Missing or unreadable parents are reported by the operation itself
+		}
+
+		exact := false
+		for _, entry := range entries {
+			name := entry.Name()
+			if name == component {
+				exact = true
+				break
+			}
+			if strings.EqualFold(name, component) && !isSidecarName(name) {
+				existing := filepath.ToSlash(filepath.Join(dir, name))
+				return NewStorageErrorWithPath(ErrorCodeFileAlreadyExists,
+					"an entry differing only by case exists: "+strings.TrimPrefix(existing, filepath.ToSlash(base)+"/"), path)
+			}
+		}
+		if !exact {
+			return nil
+		}
+		dir = filepath.Join(dir, component)
+	}
+	return nil
+}
+
+// caseInsensitiveCheck reports whether uploads are checked for names differing only by
+// case. Only the filesystem provider needs it: object stores are case-sensitive end to end.
+func (c *StorageConfig) caseInsensitiveCheck() bool {
+	return c.Provider == "filesystem" && c.FileSystem != nil && c.FileSystem.CaseInsensitiveCheck
+}
+
+// uniqueFilename generates a unique name for an upload, short enough for the path limits
+// of the directory place puts it in, and returns it with its path. With
+// CaseInsensitiveCheck it lists that directory and generates a new name while one
+// differs only by case from an entry.
+func (s *Storage) uniqueFilename(ctx context.Context, originalFilename string, place func(fileName string) string) (string, string, error) {
+	originalFilename = s.config.normalizePath(originalFilename)
+	maxLength := s.config.nameLimit(parentDir(place(originalFilename)))
+	fileName := generateUniqueFilename(originalFilename, maxLength)
+	if !s.config.caseInsensitiveCheck() {
+		return fileName, place(fileName), nil
+	}
+
+	// Layouts such as LayoutHash put each name in its own directory
+	listings := make(map[string]map[string]bool)
+	for i := 0; ; i++ {
+		filePath := place(fileName)
+		dir := parentDir(filePath)
+		taken, ok := listings[dir]
+		if !ok {
+			entries, err := s.ListWithOptions(ctx, dir, ListOptions{AllowMissing: true, IncludeHidden: true})
+			if err != nil {
+				return "", "", err
+			}
+			taken = make(map[string]bool, len(entries))
+			for _, entry := range entries {
+				taken[strings.ToLower(entry.Name)] = true
+			}
+			listings[dir] = taken
+		}
+
+		if !taken[strings.ToLower(fileName)] {
+			return fileName, filePath, nil
+		}
+		if i == maxCaseRenames {
+			return "", "", FileAlreadyExistsError(filePath)
+		}
+		fileName = generateUniqueFilename(originalFilename, maxLength)
+	}
+}
+
+// parentDir returns the directory of a path, "" for paths at the root
+func parentDir(filePath string) string {
+	if dir := path.Dir(filePath); dir != "." && dir != "/" {
+		return dir
+	}
+	return ""
+}
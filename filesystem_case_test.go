@@ -0,0 +1,82 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestCaseInsensitiveCheck(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{
+		Name:       "test",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir(), CaseInsensitiveCheck: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	if _, err := storage.Upload(ctx, "Reports/report.pdf", strings.NewReader("v1"), nil); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	t.Run("Upload", func(t *testing.T) {
+		for _, path := range []string{"Reports/Report.pdf", "reports/other.pdf", "REPORTS/new/file.pdf"} {
+			if _, err := storage.Upload(ctx, path, strings.NewReader("v2"), nil); !errors.Is(err, ErrFileAlreadyExists) {
+				t.Errorf("Expected ErrFileAlreadyExists for %s, got %v", path, err)
+			}
+		}
+		if _, err := storage.Append(ctx, "Reports/REPORT.pdf", strings.NewReader("v2")); !errors.Is(err, ErrFileAlreadyExists) {
+			t.Errorf("Expected ErrFileAlreadyExists from Append, got %v", err)
+		}
+
+		// Overwriting the same name and new names are allowed
+		for _, path := range []string{"Reports/report.pdf", "Reports/summary.pdf", "Reports/2024/report.pdf"} {
+			if _, err := storage.Upload(ctx, path, strings.NewReader("v2"), nil); err != nil {
+				t.Errorf("Upload of %s failed: %v", path, err)
+			}
+		}
+	})
+
+	t.Run("GeneratedNames", func(t *testing.T) {
+		// The first generated name differs only by case from an existing file
+		if _, err := storage.Upload(ctx, "uploads/photo_ABCD1234.jpg", strings.NewReader("a"), nil); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+
+		suffixes := []string{"abcd1234", "ef567890"}
+		restore := uniqueSuffix
+		uniqueSuffix = func() string {
+			suffix := suffixes[0]
+			suffixes = suffixes[1:]
+			return suffix
+		}
+		defer func() { uniqueSuffix = restore }()
+
+		source := filepath.Join(t.TempDir(), "photo.jpg")
+		os.WriteFile(source, []byte("b"), 0644)
+		file := &rest.UploadedFile{Path: source, Filename: "photo.jpg", OriginalName: "photo.jpg", MimeType: "image/jpeg"}
+
+		result, err := storage.UploadFromUploadedFile(ctx, file, "file", "uploads")
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		if result.Filename != "photo_ef567890.jpg" {
+			t.Errorf("Expected the colliding name to be replaced, got %s", result.Filename)
+		}
+	})
+
+	t.Run("CaseSensitiveProviders", func(t *testing.T) {
+		mem, _ := New(&StorageConfig{Name: "test", Provider: "memory", FileSystem: &FileSystemConfig{CaseInsensitiveCheck: true}})
+		mem.Upload(ctx, "Reports/report.pdf", strings.NewReader("v1"), nil)
+		if _, err := mem.Upload(ctx, "Reports/Report.pdf", strings.NewReader("v2"), nil); err != nil {
+			t.Errorf("Expected memory storage to keep both names, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package vsaasstorage
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CleanupOrphans removes the temporary files of interrupted uploads older than olderThan:
+// deduplicated uploads that were never turned into a blob, and uploads and links that were
+// never renamed over their target
+func (p *FileSystemProvider) CleanupOrphans(ctx context.Context, olderThan time.Duration) (*CleanupReport, error) {
+	report := &CleanupReport{}
+	cutoff := p.config.now().Add(-olderThan)
+	base := p.config.FileSystem.BasePath
+	blobs := filepath.Join(base, blobsDir)
+
+	report.TempFiles += removeStaleUploads(filepath.Join(blobs, blobsTmpDir), cutoff)
+
+	err := filepath.WalkDir(base, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Removed while walking
+			}
+			return err
+		}
+		if err := checkContext(ctx, ""); err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if path == blobs {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !isTempName(entry.Name()) {
+			return nil
+		}
+		if stat, err := entry.Info(); err == nil && stat.ModTime().Before(cutoff) {
+			if os.Remove(path) == nil {
+				report.TempFiles++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return report, err
+		}
+		return report, fileSystemError(err, "", ErrorCodeDeleteFailed, "failed to walk directory")
+	}
+
+	return report, nil
+}
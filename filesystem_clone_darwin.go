@@ -0,0 +1,19 @@
+//go:build darwin
+
+package vsaasstorage
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst a copy-on-write clone of src with clonefile (APFS).
+// It fails when the filesystem does not support clones or the files are on different ones.
+func cloneFile(src *os.File, dst string) error {
+	// clonefile refuses to replace an existing file
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return unix.Clonefile(src.Name(), dst, unix.CLONE_NOFOLLOW)
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package vsaasstorage
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst share the blocks of src with the FICLONE ioctl (btrfs, XFS).
+// It fails when the filesystem does not support reflinks or the files are on different ones.
+func cloneFile(src *os.File, dst string) error {
+	file, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := unix.IoctlFileClone(int(file.Fd()), int(src.Fd())); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
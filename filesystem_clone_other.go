@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package vsaasstorage
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is not available on this platform, so copies always stream the data
+func cloneFile(src *os.File, dst string) error {
+	return errors.New("reflinks are not supported on this platform")
+}
@@ -0,0 +1,83 @@
+package vsaasstorage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSystemCopyClone(t *testing.T) {
+	ctx := context.Background()
+	basePath := t.TempDir()
+	storage, err := New(&StorageConfig{
+		Name:       "CloneStorage",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: basePath},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	provider, _ := providerAs[*FileSystemProvider](storage.provider)
+
+	content := strings.Repeat("frame", 10_000)
+	storage.Upload(ctx, "src/clip.mp4", strings.NewReader(content), nil)
+
+	srcFullPath := filepath.Join(basePath, "src", "clip.mp4")
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chmod(srcFullPath, 0600)
+	os.Chtimes(srcFullPath, modTime, modTime)
+
+	t.Run("Copy keeps mode and mtime", func(t *testing.T) {
+		if err := storage.Copy(ctx, "src/clip.mp4", "dst/clip.mp4"); err != nil {
+			t.Fatalf("Copy failed: %v", err)
+		}
+
+		dstFullPath := filepath.Join(basePath, "dst", "clip.mp4")
+		data, _ := os.ReadFile(dstFullPath)
+		if string(data) != content {
+			t.Error("Copied content differs from the source")
+		}
+
+		stat, err := os.Stat(dstFullPath)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if stat.Mode().Perm() != 0600 {
+			t.Errorf("Expected mode 0600, got %v", stat.Mode().Perm())
+		}
+		if !stat.ModTime().Equal(modTime) {
+			t.Errorf("Expected mtime %v, got %v", modTime, stat.ModTime())
+		}
+	})
+
+	t.Run("Streaming fallback", func(t *testing.T) {
+		src, _ := os.Open(srcFullPath)
+		defer src.Close()
+
+		dst := filepath.Join(basePath, "streamed.mp4")
+		os.WriteFile(dst, []byte(strings.Repeat("old", 100_000)), 0644)
+
+		if err := provider.streamCopy(context.Background(), src, dst); err != nil {
+			t.Fatalf("streamCopy failed: %v", err)
+		}
+		if data, _ := os.ReadFile(dst); string(data) != content {
+			t.Error("Streamed content differs from the source")
+		}
+	})
+
+	t.Run("Reflink", func(t *testing.T) {
+		src, _ := os.Open(srcFullPath)
+		defer src.Close()
+
+		dst := filepath.Join(basePath, "cloned.mp4")
+		if err := cloneFile(src, dst); err != nil {
+			t.Skipf("Reflinks not supported here: %v", err)
+		}
+		if data, _ := os.ReadFile(dst); string(data) != content {
+			t.Error("Cloned content differs from the source")
+		}
+	})
+}
@@ -0,0 +1,340 @@
+package vsaasstorage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// blobsDir is the directory that holds deduplicated content, named by its SHA-256
+const blobsDir = ".blobs"
+
+// blobsTmpDir holds uploads while they are being hashed
+const blobsTmpDir = "tmp"
+
+// DedupBlobMetadataKey is added to the metadata of deduplicated files on the filesystem
+// provider, holding the SHA-256 of the blob they link to. Files stored as plain copies,
+// because their content could not be linked, do not have it. It is never stored as
+// custom metadata, so it can be passed back on uploads.
+const DedupBlobMetadataKey = "vsaas-dedup-blob"
+
+// staleUploadAge is how old a temporary upload must be before garbage collection removes it
+const staleUploadAge = time.Hour
+
+// deduplicating reports whether uploads are stored as content-addressed blobs
+func (p *FileSystemProvider) deduplicating() bool {
+	return p.config.FileSystem.Deduplicate
+}
+
+// blobPath returns the full path of the blob with the given hash
+func (p *FileSystemProvider) blobPath(hash string) string {
+	return filepath.Join(p.config.FileSystem.BasePath, blobsDir, hash)
+}
+
+// uploadDeduplicated hashes the upload into a temporary file and links the path to the
+// blob holding that content, creating the blob if it is the first copy. The blob's link
+// count is its reference count, so it stays consistent even if the process crashes.
+func (p *FileSystemProvider) uploadDeduplicated(ctx context.Context, path, fullPath string, reader io.Reader, metadata *FileMetadata, locked bool) (*FileInfo, error) {
+	tmpDir := filepath.Join(p.config.FileSystem.BasePath, blobsDir, blobsTmpDir)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to create blob directory")
+	}
+
+	tmp, err := os.CreateTemp(tmpDir, "upload-*")
+	if err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to create file")
+	}
+	defer os.Remove(tmp.Name()) // No-op once the file became a blob
+
+	// The SHA-256 addresses the blob and is always needed; the MD5 only feeds the ETag
+	contentHash := sha256.New()
+	etagHash := md5.New()
+	writers := []io.Writer{tmp, contentHash}
+	checksum := p.config.checksumEnabled(metadata)
+	if checksum {
+		writers = append(writers, etagHash)
+	}
+	size, err := copyContext(ctx, io.MultiWriter(writers...), reader, p.config.GetCopyBufferSize())
+	if err == nil {
+		err = p.syncFile(tmp)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to write file")
+	}
+
+	// Set file permissions if specified
+	if p.config.FileSystem.Permissions != "" {
+		if perm, err := strconv.ParseUint(p.config.FileSystem.Permissions, 8, 32); err == nil {
+			os.Chmod(tmp.Name(), os.FileMode(perm))
+		}
+	}
+
+	hash := hex.EncodeToString(contentHash.Sum(nil))
+	blob := p.blobPath(hash)
+	if !locked {
+		defer conditionalLocks.lock(fullPath)() // GetInfo and Download wait for the link and its sidecar
+	}
+	previous := readSidecar(fullPath)
+
+	// Link to the existing blob; if there is none (or it was just released), publish ours.
+	// The temporary file is linked rather than renamed so it is still there to fall back on.
+	published := false
+	err = p.replaceWithLink(blob, fullPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err = p.link(tmp.Name(), blob); err == nil || errors.Is(err, fs.ErrExist) {
+			published = err == nil
+			err = p.replaceWithLink(blob, fullPath)
+		}
+	}
+
+	// Where the content cannot be linked, such as past the link limit of a popular blob or
+	// on filesystems without hard links, the upload is stored as a plain file
+	if err != nil && linkUnsupported(err) {
+		err = p.storeUnlinked(ctx, tmp.Name(), fullPath, published)
+		if published {
+			os.Remove(tmp.Name())
+			p.releaseBlob(hash)
+		}
+		hash = ""
+	} else if err == nil {
+		err = p.syncDir(blob)
+	}
+	if err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to link blob")
+	}
+	if err := p.syncDir(fullPath); err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to sync directory")
+	}
+
+	stat, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeInternalError, "failed to get file stats")
+	}
+
+	etag := ""
+	if checksum {
+		etag = fmt.Sprintf("%x", etagHash.Sum(nil))
+	}
+
+	sidecar := &fileSidecar{ExpiresAt: metadata.expiration(p.config.now()), Blob: hash, Metadata: metadata.customMetadata(), ContentEncoding: metadata.contentEncoding()}
+	if etag != "" {
+		sidecar.setETag(etag, stat)
+	}
+	if err := writeSidecar(fullPath, sidecar); err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to write metadata")
+	}
+
+	// The overwritten content may have been the last reference to its blob
+	if previous != nil && previous.Blob != "" && previous.Blob != hash {
+		p.releaseBlob(previous.Blob)
+	}
+
+	modTime := stat.ModTime()
+	return &FileInfo{
+		Path:         path,
+		Name:         filepath.Base(path),
+		Size:         size,
+		ContentType:  fileSystemContentType(path, metadata),
+		ETag:         etag,
+		LastModified: &modTime,
+		IsDirectory:  false,
+		Metadata:     sidecar.infoMetadata(),
+		ExpiresAt:    sidecar.ExpiresAt,
+
+		ContentEncoding: sidecar.ContentEncoding,
+	}, nil
+}
+
+// infoMetadata returns the custom metadata reported for a file, adding
+// DedupBlobMetadataKey when it links to a blob
+func (s *fileSidecar) infoMetadata() map[string]string {
+	if s.Blob == "" {
+		return s.Metadata
+	}
+	metadata := make(map[string]string, len(s.Metadata)+1)
+	for k, v := range s.Metadata {
+		metadata[k] = v
+	}
+	metadata[DedupBlobMetadataKey] = s.Blob
+	return metadata
+}
+
+// storeUnlinked moves an upload whose content could not be linked to fullPath. A temporary
+// file already published as a blob is copied instead, so the plain file never shares its
+// data with the paths linked to the blob.
+func (p *FileSystemProvider) storeUnlinked(ctx context.Context, tmpPath, fullPath string, published bool) error {
+	if !published {
+		return os.Rename(tmpPath, fullPath)
+	}
+
+	src, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	copyPath := tmpPath + ".copy"
+	err = p.streamCopy(ctx, src, copyPath)
+	if err == nil {
+		if stat, statErr := src.Stat(); statErr == nil {
+			os.Chmod(copyPath, stat.Mode().Perm())
+		}
+		err = os.Rename(copyPath, fullPath)
+	}
+	if err != nil {
+		os.Remove(copyPath)
+	}
+	return err
+}
+
+// releaseBlob removes a blob once no path links to it anymore
+func (p *FileSystemProvider) releaseBlob(hash string) {
+	blob := p.blobPath(hash)
+	stat, err := os.Stat(blob)
+	if err != nil {
+		return
+	}
+
+	if links, ok := linkCount(stat); ok && links <= 1 {
+		os.Remove(blob)
+	}
+}
+
+// CollectGarbageBlobs removes blobs no longer referenced by any path and stale temporary
+// uploads, returning how many blobs were removed. It repairs the state left by a crash
+// between writing a blob and linking it, and is safe to run while the storage is in use.
+func (p *FileSystemProvider) CollectGarbageBlobs(ctx context.Context) (int, error) {
+	dir := filepath.Join(p.config.FileSystem.BasePath, blobsDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fileSystemError(err, blobsDir, ErrorCodeListFailed, "failed to read blob directory")
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if err := checkContext(ctx, ""); err != nil {
+			return removed, err
+		}
+
+		if entry.IsDir() {
+			if entry.Name() == blobsTmpDir {
+				removeStaleUploads(filepath.Join(dir, blobsTmpDir), p.config.now().Add(-staleUploadAge))
+			}
+			continue
+		}
+
+		stat, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if links, ok := linkCount(stat); ok && links <= 1 {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err == nil {
+				removed++
+			}
+		}
+	}
+
+	return removed, nil
+}
+
+// removeStaleUploads deletes temporary uploads abandoned by a crashed process before
+// cutoff and returns how many were removed
+func removeStaleUploads(dir string, cutoff time.Time) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if stat, err := entry.Info(); err == nil && stat.ModTime().Before(cutoff) {
+			if os.Remove(filepath.Join(dir, entry.Name())) == nil {
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// Temporary files created beside their target by replaceWithLink and Upload are named with
+// tempPrefix, which uploads refuse, and one of these suffixes
+const (
+	tempPrefix      = ".tmp-"
+	linkTmpSuffix   = ".link"
+	uploadTmpSuffix = ".upload"
+)
+
+// tempNameMax caps the part of a file name kept in its temporary names, so they stay within
+// the name limit of the filesystem even for names at DefaultFileSystemMaxNameLength
+const tempNameMax = 200
+
+// siblingTempPath returns a hidden temporary path in the directory of fullPath, e.g.
+// dir/.tmp-name.1700000000000000000.link
+func siblingTempPath(fullPath, suffix string) string {
+	name := filepath.Base(fullPath)
+	if len(name) > tempNameMax {
+		cut := tempNameMax
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut]
+	}
+	return filepath.Join(filepath.Dir(fullPath), fmt.Sprintf("%s%s.%d%s", tempPrefix, name, time.Now().UnixNano(), suffix))
+}
+
+// isTempName reports whether name is of a temporary file created by siblingTempPath
+func isTempName(name string) bool {
+	return strings.HasPrefix(name, tempPrefix) && (strings.HasSuffix(name, linkTmpSuffix) || strings.HasSuffix(name, uploadTmpSuffix))
+}
+
+// checkTempName rejects writes to the names reserved for temporary files, which
+// CleanupOrphans would take for leftovers of interrupted uploads
+func checkTempName(path string) error {
+	if strings.HasPrefix(filepath.Base(path), tempPrefix) {
+		return InvalidPathError(path)
+	}
+	return nil
+}
+
+// replaceWithLink atomically points dst at the same content as src using a hard link
+func (p *FileSystemProvider) replaceWithLink(src, dst string) error {
+	tmp := siblingTempPath(dst, linkTmpSuffix)
+	if err := p.link(src, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// CollectGarbageBlobs removes unreferenced deduplicated content when the provider supports it
+func (s *Storage) CollectGarbageBlobs(ctx context.Context) (int, error) {
+	if err := s.checkWritable(""); err != nil {
+		return 0, err
+	}
+
+	provider, ok := providerAs[*FileSystemProvider](s.provider)
+	if !ok {
+		return 0, NotSupportedError("deduplication is not supported by the provider")
+	}
+	return provider.CollectGarbageBlobs(ctx)
+}
@@ -0,0 +1,201 @@
+package vsaasstorage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestFileSystemDeduplication(t *testing.T) {
+	basePath := t.TempDir()
+	storage, err := New(&StorageConfig{
+		Name:     "DedupStorage",
+		Provider: "filesystem",
+		FileSystem: &FileSystemConfig{
+			BasePath:    basePath,
+			Deduplicate: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	ctx := context.Background()
+	blobs := func() int {
+		entries, _ := os.ReadDir(filepath.Join(basePath, blobsDir))
+		count := 0
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				count++
+			}
+		}
+		return count
+	}
+
+	for _, path := range []string{"cam1/preroll.mp4", "cam2/preroll.mp4"} {
+		info, err := storage.Upload(ctx, path, strings.NewReader("same pre-roll"), nil)
+		if err != nil {
+			t.Fatalf("Upload of %s failed: %v", path, err)
+		}
+		if info.Size != int64(len("same pre-roll")) || info.ETag == "" {
+			t.Errorf("Unexpected upload info %+v", info)
+		}
+	}
+	storage.Upload(ctx, "cam3/other.mp4", strings.NewReader("different"), nil)
+
+	if blobs() != 2 {
+		t.Fatalf("Expected 2 blobs, got %d", blobs())
+	}
+
+	reader, _, err := storage.Download(ctx, "cam2/preroll.mp4")
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	content, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(content) != "same pre-roll" {
+		t.Errorf("Unexpected content '%s'", content)
+	}
+
+	root, _ := storage.List(ctx, "")
+	for _, entry := range root {
+		if entry.Name == blobsDir {
+			t.Error("Expected blob directory to be hidden")
+		}
+	}
+
+	if err := storage.Copy(ctx, "cam3/other.mp4", "cam4/other.mp4"); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if blobs() != 2 {
+		t.Errorf("Expected copy to reuse the blob, got %d blobs", blobs())
+	}
+
+	// Overwriting with new content must not change other paths sharing the blob
+	storage.Upload(ctx, "cam4/other.mp4", strings.NewReader("replaced"), nil)
+	reader, _, _ = storage.Download(ctx, "cam3/other.mp4")
+	content, _ = io.ReadAll(reader)
+	reader.Close()
+	if string(content) != "different" {
+		t.Errorf("Expected shared content to be untouched, got '%s'", content)
+	}
+
+	if err := storage.Delete(ctx, "cam1/preroll.mp4"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if blobs() != 3 {
+		t.Errorf("Expected blob to survive while referenced, got %d blobs", blobs())
+	}
+
+	if err := storage.DeleteDirectory(ctx, "cam2"); err != nil {
+		t.Fatalf("DeleteDirectory failed: %v", err)
+	}
+	if blobs() != 2 {
+		t.Errorf("Expected unreferenced blob to be removed, got %d blobs", blobs())
+	}
+
+	// A blob orphaned by a crash is collected
+	os.WriteFile(filepath.Join(basePath, blobsDir, "orphan"), []byte("x"), 0644)
+	removed, err := storage.CollectGarbageBlobs(ctx)
+	if err != nil || removed != 1 {
+		t.Errorf("Expected 1 orphan removed, got %d, %v", removed, err)
+	}
+}
+
+func TestFileSystemDeduplicationLinkFallback(t *testing.T) {
+	basePath := t.TempDir()
+	storage, err := New(&StorageConfig{
+		Name:     "DedupStorage",
+		Provider: "filesystem",
+		FileSystem: &FileSystemConfig{
+			BasePath:    basePath,
+			Deduplicate: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	provider, _ := providerAs[*FileSystemProvider](storage.provider)
+
+	ctx := context.Background()
+	read := func(path string) string {
+		reader, _, err := storage.Download(ctx, path)
+		if err != nil {
+			t.Fatalf("Download of %s failed: %v", path, err)
+		}
+		defer reader.Close()
+		content, _ := io.ReadAll(reader)
+		return string(content)
+	}
+	blob := func(path string) string {
+		info, err := storage.GetInfo(ctx, path)
+		if err != nil {
+			t.Fatalf("GetInfo of %s failed: %v", path, err)
+		}
+		return info.Metadata[DedupBlobMetadataKey]
+	}
+
+	storage.Upload(ctx, "cam1/clip.mp4", strings.NewReader("clip"), &FileMetadata{
+		CustomMetadata: map[string]string{DedupBlobMetadataKey: "forged", "camera": "1"},
+	})
+	hash := blob("cam1/clip.mp4")
+	if hash == "" || hash == "forged" {
+		t.Fatalf("Expected the blob of the upload in its metadata, got '%s'", hash)
+	}
+
+	// Publishing blobs works, but linking paths to them hits the link limit
+	provider.link = func(oldname, newname string) error {
+		if filepath.Base(filepath.Dir(newname)) == blobsDir {
+			return os.Link(oldname, newname)
+		}
+		if _, err := os.Stat(oldname); err != nil {
+			return os.Link(oldname, newname)
+		}
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EMLINK}
+	}
+	t.Cleanup(func() { provider.link = os.Link })
+
+	for _, path := range []string{"cam2/clip.mp4", "cam3/new.mp4"} {
+		content := "clip"
+		if path == "cam3/new.mp4" {
+			content = "new"
+		}
+		if _, err := storage.Upload(ctx, path, strings.NewReader(content), nil); err != nil {
+			t.Fatalf("Upload of %s failed: %v", path, err)
+		}
+		if read(path) != content {
+			t.Errorf("Unexpected content of %s", path)
+		}
+		if blob(path) != "" {
+			t.Errorf("Expected %s to be stored as a plain file", path)
+		}
+	}
+	if err := storage.Copy(ctx, "cam1/clip.mp4", "cam4/clip.mp4"); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if read("cam4/clip.mp4") != "clip" || blob("cam4/clip.mp4") != "" {
+		t.Error("Expected the copy to be stored as a plain file")
+	}
+
+	// Only the first upload references a blob, and it survives the plain files
+	entries, _ := os.ReadDir(filepath.Join(basePath, blobsDir))
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) != 1 || names[0] != hash {
+		t.Errorf("Expected only the blob of the first upload, got %v", names)
+	}
+	if err := storage.Delete(ctx, "cam1/clip.mp4"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if read("cam2/clip.mp4") != "clip" || read("cam4/clip.mp4") != "clip" {
+		t.Error("Expected plain files to keep their content")
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package vsaasstorage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// linkCount is not available on this platform, so unreferenced blobs are never removed
+func linkCount(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// linkUnsupported reports whether a hard link failed for a reason other than a missing
+// path, such as a filesystem without hard links or an account without the privilege
+func linkUnsupported(err error) bool {
+	var linkErr *os.LinkError
+	return errors.As(err, &linkErr) && linkErr.Op == "link" && !errors.Is(err, fs.ErrNotExist)
+}
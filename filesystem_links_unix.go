@@ -0,0 +1,25 @@
+//go:build unix
+
+package vsaasstorage
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// linkCount returns the number of hard links to a file
+func linkCount(info os.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Nlink), true
+}
+
+// linkUnsupported reports whether a hard link failed because the content cannot be linked
+// there, rather than because of the paths involved
+func linkUnsupported(err error) bool {
+	return errors.Is(err, syscall.EXDEV) || errors.Is(err, syscall.EMLINK) || errors.Is(err, syscall.EPERM) ||
+		errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS)
+}
@@ -0,0 +1,86 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFileSystemMoveRenameFailures(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{
+		Name:       "MoveStorage",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	provider, _ := providerAs[*FileSystemProvider](storage.provider)
+
+	failRename := func(errno syscall.Errno) {
+		provider.rename = func(oldpath, newpath string) error {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errno}
+		}
+	}
+	t.Cleanup(func() { provider.rename = os.Rename })
+
+	t.Run("Missing source", func(t *testing.T) {
+		provider.rename = os.Rename
+		if err := storage.Move(ctx, "missing.mp4", "dst.mp4"); !errors.Is(err, ErrFileNotFound) {
+			t.Errorf("Expected ErrFileNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Cross-device falls back to copy", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour)
+		storage.Upload(ctx, "local/clip.mp4", strings.NewReader("clip"), &FileMetadata{ExpiresAt: &expiresAt})
+
+		failRename(syscall.EXDEV)
+		if err := storage.Move(ctx, "local/clip.mp4", "remote/clip.mp4"); err != nil {
+			t.Fatalf("Move failed: %v", err)
+		}
+
+		if exists, _ := storage.Exists(ctx, "local/clip.mp4"); exists {
+			t.Error("Expected the source to be removed")
+		}
+		reader, info, err := storage.Download(ctx, "remote/clip.mp4")
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		defer reader.Close()
+		if content, _ := io.ReadAll(reader); string(content) != "clip" || info.ExpiresAt == nil {
+			t.Errorf("Expected content and expiration to be moved, got '%s' and %v", content, info.ExpiresAt)
+		}
+	})
+
+	t.Run("Other errors are not retried as a copy", func(t *testing.T) {
+		storage.Upload(ctx, "io/clip.mp4", strings.NewReader("clip"), nil)
+
+		failRename(syscall.EIO)
+		err := storage.Move(ctx, "io/clip.mp4", "elsewhere/clip.mp4")
+
+		var storageErr *StorageError
+		if !errors.As(err, &storageErr) || storageErr.Code != ErrorCodeMoveFailed || !errors.Is(err, syscall.EIO) {
+			t.Errorf("Expected MOVE_FAILED wrapping EIO, got %v", err)
+		}
+		if exists, _ := storage.Exists(ctx, "elsewhere/clip.mp4"); exists {
+			t.Error("Expected no copy at the destination")
+		}
+		if exists, _ := storage.Exists(ctx, "io/clip.mp4"); !exists {
+			t.Error("Expected the source to be kept")
+		}
+	})
+
+	t.Run("Permission errors", func(t *testing.T) {
+		failRename(syscall.EACCES)
+		if err := storage.Move(ctx, "io/clip.mp4", "elsewhere/clip.mp4"); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Expected ErrPermissionDenied, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NormalizePaths renames the entries under root whose names are not in NFC, deepest
+// first so the paths of pending entries stay valid. Sidecars follow their files. Entries
+// whose normalized name already exists are kept and reported in a *MultiError.
+func (p *FileSystemProvider) NormalizePaths(ctx context.Context, root string) (int, error) {
+	rootPath, err := p.getFullPath(root)
+	if err != nil {
+		return 0, err
+	}
+	base := filepath.Clean(p.config.FileSystem.BasePath)
+	blobs := filepath.Join(base, blobsDir)
+
+	var pending []string
+	err = filepath.WalkDir(rootPath, func(entryPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && entryPath == rootPath {
+				return DirectoryNotFoundError(root)
+			}
+			return err
+		}
+		if err := checkContext(ctx, root); err != nil {
+			return err
+		}
+		if entryPath == blobs {
+			return filepath.SkipDir
+		}
+		if entryPath != rootPath && !isSidecarName(entry.Name()) && normalizeNFC(entry.Name()) != entry.Name() {
+			pending = append(pending, entryPath)
+		}
+		return nil
+	})
+	if err != nil {
+		var storageErr *StorageError
+		if errors.As(err, &storageErr) {
+			return 0, storageErr
+		}
+		return 0, fileSystemError(err, root, ErrorCodeListFailed, "failed to walk directory")
+	}
+
+	// Deepest first: renaming a directory changes the paths below it
+	sort.SliceStable(pending, func(i, j int) bool {
+		return strings.Count(pending[i], string(filepath.Separator)) > strings.Count(pending[j], string(filepath.Separator))
+	})
+
+	renamed := 0
+	var errs MultiError
+	for _, oldPath := range pending {
+		newPath := filepath.Join(filepath.Dir(oldPath), normalizeNFC(filepath.Base(oldPath)))
+		relPath := filepath.ToSlash(strings.TrimPrefix(oldPath, base+string(filepath.Separator)))
+
+		// Normalization-insensitive filesystems such as APFS report the entry itself
+		if existing, err := os.Lstat(newPath); err == nil {
+			if current, err := os.Lstat(oldPath); err != nil || !os.SameFile(existing, current) {
+				errs.Add(relPath, FileAlreadyExistsError(normalizeNFC(relPath)))
+				continue
+			}
+		}
+		if err := p.rename(oldPath, newPath); err != nil {
+			errs.Add(relPath, fileSystemError(err, relPath, ErrorCodeMoveFailed, "failed to rename entry"))
+			continue
+		}
+		if _, err := os.Lstat(sidecarPath(oldPath)); err == nil {
+			if err := p.rename(sidecarPath(oldPath), sidecarPath(newPath)); err != nil {
+				errs.Add(relPath, fileSystemError(err, relPath, ErrorCodeMoveFailed, "failed to rename metadata"))
+			}
+		}
+		renamed++
+	}
+
+	return renamed, errs.ErrorOrNil()
+}
@@ -3,13 +3,16 @@ package vsaasstorage
 import (
 	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -18,6 +21,8 @@ import (
 // FileSystemProvider implements the StorageProvider interface for local filesystem
 type FileSystemProvider struct {
 	config *StorageConfig
+	rename func(oldpath, newpath string) error // os.Rename, replaceable in tests
+	link   func(oldname, newname string) error // os.Link, replaceable in tests
 }
 
 // NewFileSystemProvider creates a new filesystem provider
@@ -35,59 +40,122 @@ func NewFileSystemProvider(config *StorageConfig) (*FileSystemProvider, error) {
 
 	return &FileSystemProvider{
 		config: config,
+		rename: os.Rename,
+		link:   os.Link,
 	}, nil
 }
 
 // Upload uploads a file to the filesystem
 func (p *FileSystemProvider) Upload(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata) (*FileInfo, error) {
+	return p.upload(ctx, path, reader, metadata, false)
+}
+
+// upload uploads a file, publishing its content and sidecar under the conditionalLocks
+// lock of the path unless the caller already holds it
+func (p *FileSystemProvider) upload(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata, locked bool) (*FileInfo, error) {
+	if err := checkContext(ctx, path); err != nil {
+		return nil, err
+	}
+	if err := checkTempName(path); err != nil {
+		return nil, err
+	}
 	fullPath, err := p.getFullPath(path)
 	if err != nil {
 		return nil, err
 	}
 
+	// Files under retention cannot be overwritten
+	if err := checkRetention(fullPath, path, p.config.now()); err != nil {
+		return nil, err
+	}
+	if err := p.checkCaseCollision(path, fullPath); err != nil {
+		return nil, err
+	}
+	if err := p.checkFreeSpace(path, reader); err != nil {
+		return nil, err
+	}
+
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(fullPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, NewProviderError("filesystem", ErrorCodeUploadFailed, "failed to create directory", err)
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to create directory")
+	}
+
+	if p.deduplicating() {
+		return p.uploadDeduplicated(ctx, path, fullPath, reader, metadata, locked)
 	}
 
-	// Create the file
-	file, err := os.Create(fullPath)
+	// Write beside the file and rename over it, so readers never see a partial upload, content
+	// shared through hard links is never modified in place and a failed upload keeps the old file
+	tmpPath := siblingTempPath(fullPath, uploadTmpSuffix)
+	file, err := createFile(tmpPath)
 	if err != nil {
-		return nil, NewProviderError("filesystem", ErrorCodeUploadFailed, "failed to create file", err)
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to create file")
 	}
 	defer file.Close()
+	defer os.Remove(tmpPath) // No-op once renamed
 
-	// Copy data and calculate size and hash
+	// Copy data and calculate size and hash. Without a checksum the ETag is left empty.
+	var writer io.Writer = file
 	hash := md5.New()
-	size, err := io.Copy(io.MultiWriter(file, hash), reader)
+	checksum := p.config.checksumEnabled(metadata)
+	if checksum {
+		writer = io.MultiWriter(file, hash)
+	}
+	size, err := copyContext(ctx, writer, reader, p.config.GetCopyBufferSize())
+	if err == nil {
+		err = p.syncFile(file)
+	}
 	if err != nil {
-		os.Remove(fullPath) // Clean up on error
-		return nil, NewProviderError("filesystem", ErrorCodeUploadFailed, "failed to write file", err)
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to write file")
 	}
 
 	// Set file permissions if specified
 	if p.config.FileSystem.Permissions != "" {
 		if perm, err := strconv.ParseUint(p.config.FileSystem.Permissions, 8, 32); err == nil {
-			os.Chmod(fullPath, os.FileMode(perm))
+			os.Chmod(tmpPath, os.FileMode(perm))
 		}
 	}
 
 	// Get file info
 	stat, err := file.Stat()
 	if err != nil {
-		return nil, NewProviderError("filesystem", ErrorCodeInternalError, "failed to get file stats", err)
+		return nil, fileSystemError(err, path, ErrorCodeInternalError, "failed to get file stats")
 	}
+	file.Close()
 
-	// Determine content type
-	contentType := "application/octet-stream"
-	if metadata != nil && metadata.ContentType != "" {
-		contentType = metadata.ContentType
-	} else {
-		contentType = mime.TypeByExtension(filepath.Ext(path))
-		if contentType == "" {
-			contentType = "application/octet-stream"
-		}
+	etag := ""
+	if checksum {
+		etag = fmt.Sprintf("%x", hash.Sum(nil))
+	}
+
+	// Persist expiration, custom metadata, encoding and the checksum in the sidecar,
+	// dropping any left by a previous upload. It is written before the content is renamed
+	// into place, so a crash in between never leaves the new content with the expiration
+	// of the previous upload; the cached ETag only matches the new content.
+	sidecar := &fileSidecar{ExpiresAt: metadata.expiration(p.config.now()), Metadata: metadata.customMetadata(), ContentEncoding: metadata.contentEncoding()}
+	if etag != "" {
+		sidecar.setETag(etag, stat)
+	}
+	if !locked {
+		defer conditionalLocks.lock(fullPath)() // GetInfo and Download wait for both
+	}
+	previous := readSidecar(fullPath)
+	if err := writeSidecar(fullPath, sidecar); err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to write metadata")
+	}
+	if err := os.Rename(tmpPath, fullPath); err != nil {
+		writeSidecar(fullPath, previous)
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to replace file")
+	}
+
+	// The replaced file may have held the last reference to its blob
+	if previous != nil && previous.Blob != "" {
+		p.releaseBlob(previous.Blob)
+	}
+
+	if err := p.syncDir(fullPath); err != nil {
+		return nil, fileSystemError(err, path, ErrorCodeUploadFailed, "failed to sync directory")
 	}
 
 	modTime := stat.ModTime()
@@ -95,19 +163,36 @@ func (p *FileSystemProvider) Upload(ctx context.Context, path string, reader io.
 		Path:         path,
 		Name:         filepath.Base(path),
 		Size:         size,
-		ContentType:  contentType,
-		ETag:         fmt.Sprintf("%x", hash.Sum(nil)),
+		ContentType:  fileSystemContentType(path, metadata),
+		ETag:         etag,
 		LastModified: &modTime,
 		IsDirectory:  false,
+		Metadata:     sidecar.Metadata,
+		ExpiresAt:    sidecar.ExpiresAt,
+
+		ContentEncoding: sidecar.ContentEncoding,
 	}, nil
 }
 
 // Download downloads a file from the filesystem
 func (p *FileSystemProvider) Download(ctx context.Context, path string) (io.ReadCloser, *FileInfo, error) {
+	file, info, err := p.DownloadFile(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// DownloadFile opens a file for reading, returning the *os.File itself
+func (p *FileSystemProvider) DownloadFile(ctx context.Context, path string) (*os.File, *FileInfo, error) {
+	if err := checkContext(ctx, path); err != nil {
+		return nil, nil, err
+	}
 	fullPath, err := p.getFullPath(path)
 	if err != nil {
 		return nil, nil, err
 	}
+	defer conditionalLocks.lock(fullPath)() // Never open an upload halfway
 
 	// Check if file exists
 	stat, err := os.Stat(fullPath)
@@ -115,7 +200,7 @@ func (p *FileSystemProvider) Download(ctx context.Context, path string) (io.Read
 		if os.IsNotExist(err) {
 			return nil, nil, FileNotFoundError(path)
 		}
-		return nil, nil, NewProviderError("filesystem", ErrorCodeDownloadFailed, "failed to stat file", err)
+		return nil, nil, fileSystemError(err, path, ErrorCodeDownloadFailed, "failed to stat file")
 	}
 
 	if stat.IsDir() {
@@ -125,7 +210,7 @@ func (p *FileSystemProvider) Download(ctx context.Context, path string) (io.Read
 	// Open file
 	file, err := os.Open(fullPath)
 	if err != nil {
-		return nil, nil, NewProviderError("filesystem", ErrorCodeDownloadFailed, "failed to open file", err)
+		return nil, nil, fileSystemError(err, path, ErrorCodeDownloadFailed, "failed to open file")
 	}
 
 	// Get content type
@@ -143,64 +228,95 @@ func (p *FileSystemProvider) Download(ctx context.Context, path string) (io.Read
 		LastModified: &modTime,
 		IsDirectory:  false,
 	}
+	applySidecar(fileInfo, fullPath, p.config.now())
 
 	return file, fileInfo, nil
 }
 
 // Delete deletes a file from the filesystem
 func (p *FileSystemProvider) Delete(ctx context.Context, path string) error {
+	if err := checkContext(ctx, path); err != nil {
+		return err
+	}
 	fullPath, err := p.getFullPath(path)
 	if err != nil {
 		return err
 	}
 
-	// Check if file exists
-	if _, err := os.Stat(fullPath); err != nil {
+	// Check if file exists; os.Remove would also delete an empty directory
+	stat, err := os.Stat(fullPath)
+	if err != nil {
 		if os.IsNotExist(err) {
 			return FileNotFoundError(path)
 		}
-		return NewProviderError("filesystem", ErrorCodeDeleteFailed, "failed to stat file", err)
+		return fileSystemError(err, path, ErrorCodeDeleteFailed, "failed to stat file")
+	}
+	if stat.IsDir() {
+		return NewStorageErrorWithPath(ErrorCodeInvalidPath, "path is a directory", path)
+	}
+
+	if err := checkRetention(fullPath, path, p.config.now()); err != nil {
+		return err
 	}
 
+	sidecar := readSidecar(fullPath)
+
 	// Delete file
 	if err := os.Remove(fullPath); err != nil {
-		return NewProviderError("filesystem", ErrorCodeDeleteFailed, "failed to delete file", err)
+		return fileSystemError(err, path, ErrorCodeDeleteFailed, "failed to delete file")
+	}
+	removeSidecar(fullPath)
+
+	if sidecar != nil && sidecar.Blob != "" {
+		p.releaseBlob(sidecar.Blob)
 	}
+	p.pruneParents(fullPath)
 
 	return nil
 }
 
-// Exists checks if a file exists in the filesystem
+// Exists checks if a file exists in the filesystem. Directories report false.
 func (p *FileSystemProvider) Exists(ctx context.Context, path string) (bool, error) {
+	if err := checkContext(ctx, path); err != nil {
+		return false, err
+	}
 	fullPath, err := p.getFullPath(path)
 	if err != nil {
 		return false, err
 	}
 
-	_, err = os.Stat(fullPath)
+	stat, err := os.Stat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
 		}
-		return false, NewProviderError("filesystem", ErrorCodeInternalError, "failed to check file existence", err)
+		return false, fileSystemError(err, path, ErrorCodeInternalError, "failed to check file existence")
 	}
 
-	return true, nil
+	return !stat.IsDir(), nil
 }
 
 // GetInfo gets information about a file
 func (p *FileSystemProvider) GetInfo(ctx context.Context, path string) (*FileInfo, error) {
+	if err := checkContext(ctx, path); err != nil {
+		return nil, err
+	}
 	fullPath, err := p.getFullPath(path)
 	if err != nil {
 		return nil, err
 	}
+	defer conditionalLocks.lock(fullPath)() // Never report an upload halfway
+	return p.fileInfo(path, fullPath)
+}
 
+// fileInfo builds the FileInfo of a path from the file and its sidecar
+func (p *FileSystemProvider) fileInfo(path, fullPath string) (*FileInfo, error) {
 	stat, err := os.Stat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, FileNotFoundError(path)
 		}
-		return nil, NewProviderError("filesystem", ErrorCodeInternalError, "failed to get file info", err)
+		return nil, fileSystemError(err, path, ErrorCodeInternalError, "failed to get file info")
 	}
 
 	contentType := "application/octet-stream"
@@ -212,18 +328,45 @@ func (p *FileSystemProvider) GetInfo(ctx context.Context, path string) (*FileInf
 	}
 
 	modTime := stat.ModTime()
-	return &FileInfo{
+	info := &FileInfo{
 		Path:         path,
 		Name:         filepath.Base(path),
 		Size:         stat.Size(),
 		ContentType:  contentType,
 		LastModified: &modTime,
 		IsDirectory:  stat.IsDir(),
-	}, nil
+	}
+	if !stat.IsDir() {
+		applySidecar(info, fullPath, p.config.now())
+	}
+
+	return info, nil
 }
 
-// List lists files in a directory
+// List lists files in a directory, sorted by name. Entries removed while the directory is
+// read are skipped.
 func (p *FileSystemProvider) List(ctx context.Context, path string) ([]*FileInfo, error) {
+	it, err := p.Iterate(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return collectIterator(it)
+}
+
+// fsIterateBatch is the number of names read from a directory at a time
+const fsIterateBatch = 1024
+
+// Iterate lists a directory one entry at a time. Entries of a ListIterator come sorted
+// by name, which SyncTo relies on to merge listings, and directories are read in no
+// order, so every name is read before the first entry, fsIterateBatch at a time, and
+// sorted: opening the iterator takes time and memory linear in the size of the directory.
+// Only the stat and FileInfo of each entry wait until Next reaches it. For 100k files
+// BenchmarkIterate peaks at about 3.4 MB, mostly the names, against about 23.6 MB for
+// List. Entries removed while the directory is read are skipped.
+func (p *FileSystemProvider) Iterate(ctx context.Context, path string) (ListIterator, error) {
+	if err := checkContext(ctx, path); err != nil {
+		return nil, err
+	}
 	fullPath, err := p.getFullPath(path)
 	if err != nil {
 		return nil, err
@@ -235,51 +378,148 @@ func (p *FileSystemProvider) List(ctx context.Context, path string) ([]*FileInfo
 		if os.IsNotExist(err) {
 			return nil, DirectoryNotFoundError(path)
 		}
-		return nil, NewProviderError("filesystem", ErrorCodeListFailed, "failed to stat directory", err)
+		return nil, fileSystemError(err, path, ErrorCodeListFailed, "failed to stat directory")
 	}
 
 	if !stat.IsDir() {
 		return nil, NewStorageErrorWithPath(ErrorCodeInvalidPath, "path is not a directory", path)
 	}
 
-	// Read directory
-	entries, err := os.ReadDir(fullPath)
+	dir, err := os.Open(fullPath)
 	if err != nil {
-		return nil, NewProviderError("filesystem", ErrorCodeListFailed, "failed to read directory", err)
+		return nil, fileSystemError(err, path, ErrorCodeListFailed, "failed to read directory")
 	}
+	defer dir.Close()
+
+	var names []string
+	for {
+		batch, err := dir.Readdirnames(fsIterateBatch)
+		names = append(names, batch...)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fileSystemError(err, path, ErrorCodeListFailed, "failed to read directory")
+		}
+		if err := checkContext(ctx, path); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(names)
+
+	return &fsListIterator{
+		ctx:      ctx,
+		path:     path,
+		fullPath: fullPath,
+		names:    names,
+		root:     fullPath == filepath.Clean(p.config.FileSystem.BasePath),
+		config:   p.config,
+	}, nil
+}
 
-	var files []*FileInfo
-	for _, entry := range entries {
-		entryPath := filepath.Join(path, entry.Name())
-		info, err := entry.Info()
+// fsListIterator stats the entries of a directory as they are read
+type fsListIterator struct {
+	ctx      context.Context
+	path     string
+	fullPath string
+	names    []string
+	root     bool
+	config   *StorageConfig // Clock for the retention locks of the entries
+}
+
+func (it *fsListIterator) Next() (*FileInfo, error) {
+	for len(it.names) > 0 {
+		if err := checkContext(it.ctx, it.path); err != nil {
+			return nil, err
+		}
+		name := it.names[0]
+		it.names = it.names[1:]
+
+		entryFullPath := filepath.Join(it.fullPath, name)
+		info, err := os.Lstat(entryFullPath)
 		if err != nil {
 			continue // Skip entries we can't stat
 		}
+		if !info.IsDir() && isSidecarName(name) {
+			continue // Metadata sidecars are not objects
+		}
+		if !info.IsDir() && isTempName(name) {
+			continue // Uploads in progress, or left by a crash for CleanupOrphans
+		}
+		if info.IsDir() && name == blobsDir && it.root {
+			continue // Deduplicated content is only reachable through its paths
+		}
 
 		contentType := "application/octet-stream"
 		if !info.IsDir() {
-			contentType = mime.TypeByExtension(filepath.Ext(entry.Name()))
+			contentType = mime.TypeByExtension(filepath.Ext(name))
 			if contentType == "" {
 				contentType = "application/octet-stream"
 			}
 		}
 
 		modTime := info.ModTime()
-		files = append(files, &FileInfo{
-			Path:         entryPath,
-			Name:         entry.Name(),
+		fileInfo := &FileInfo{
+			Path:         filepath.Join(it.path, name),
+			Name:         name,
 			Size:         info.Size(),
 			ContentType:  contentType,
 			LastModified: &modTime,
 			IsDirectory:  info.IsDir(),
-		})
+		}
+		if !info.IsDir() {
+			applySidecar(fileInfo, entryFullPath, it.config.now())
+		}
+		return fileInfo, nil
 	}
+	return nil, io.EOF
+}
 
-	return files, nil
+func (it *fsListIterator) Close() error {
+	it.names = nil
+	return nil
+}
+
+// CreateDirectory creates a directory and any missing parents. A path where a file
+// exists, or below one, is rejected with ErrorCodeInvalidPath.
+func (p *FileSystemProvider) CreateDirectory(ctx context.Context, path string) error {
+	if err := checkContext(ctx, path); err != nil {
+		return err
+	}
+	fullPath, err := p.getFullPath(path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(fullPath, p.dirMode()); err != nil {
+		if errors.Is(err, syscall.ENOTDIR) {
+			return NewStorageErrorWithPath(ErrorCodeInvalidPath, "path is a file", path)
+		}
+		return fileSystemError(err, path, ErrorCodeInternalError, "failed to create directory")
+	}
+	if err := p.syncDir(fullPath); err != nil {
+		return fileSystemError(err, path, ErrorCodeInternalError, "failed to sync directory")
+	}
+	return nil
+}
+
+// dirMode returns the mode for created directories: the configured permissions with
+// search access wherever read access is granted, or 0755
+func (p *FileSystemProvider) dirMode() os.FileMode {
+	if p.config.FileSystem.Permissions != "" {
+		if perm, err := strconv.ParseUint(p.config.FileSystem.Permissions, 8, 32); err == nil {
+			mode := os.FileMode(perm) & os.ModePerm
+			return mode | (mode&0444)>>2
+		}
+	}
+	return 0755
 }
 
 // DeleteDirectory deletes a directory and all its contents recursively
 func (p *FileSystemProvider) DeleteDirectory(ctx context.Context, path string) error {
+	if err := checkContext(ctx, path); err != nil {
+		return err
+	}
 	fullPath, err := p.getFullPath(path)
 	if err != nil {
 		return err
@@ -291,16 +531,82 @@ func (p *FileSystemProvider) DeleteDirectory(ctx context.Context, path string) e
 		if os.IsNotExist(err) {
 			return DirectoryNotFoundError(path)
 		}
-		return NewProviderError("filesystem", ErrorCodeDeleteFailed, "failed to stat directory", err)
+		return fileSystemError(err, path, ErrorCodeDeleteFailed, "failed to stat directory")
 	}
 
 	if !stat.IsDir() {
 		return NewStorageErrorWithPath(ErrorCodeInvalidPath, "path is not a directory", path)
 	}
 
-	// Remove directory and all its contents
-	if err := os.RemoveAll(fullPath); err != nil {
-		return NewProviderError("filesystem", ErrorCodeDeleteFailed, "failed to delete directory", err)
+	// Find files under retention, which must survive the deletion, and the blobs the
+	// files link to, which may become unreferenced
+	var skipped, blobs []string
+	err = filepath.WalkDir(fullPath, func(entryPath string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || isSidecarName(entry.Name()) {
+			return err
+		}
+		if sidecar := readSidecar(entryPath); sidecar != nil && sidecar.Blob != "" {
+			blobs = append(blobs, sidecar.Blob)
+		}
+		if checkRetention(entryPath, "", p.config.now()) != nil {
+			rel, _ := filepath.Rel(fullPath, entryPath)
+			skipped = append(skipped, filepath.ToSlash(filepath.Join(path, rel)))
+		}
+		return nil
+	})
+	if err != nil {
+		return fileSystemError(err, path, ErrorCodeDeleteFailed, "failed to scan directory")
+	}
+
+	defer func() {
+		for _, blob := range blobs {
+			p.releaseBlob(blob)
+		}
+	}()
+
+	if len(skipped) == 0 {
+		// Remove directory and all its contents
+		if err := os.RemoveAll(fullPath); err != nil {
+			return fileSystemError(err, path, ErrorCodeDeleteFailed, "failed to delete directory")
+		}
+		p.pruneParents(fullPath)
+		return nil
+	}
+
+	if err := p.deleteUnretained(fullPath, path); err != nil {
+		return err
+	}
+
+	return &DirectoryRetentionError{Path: path, Skipped: skipped}
+}
+
+// deleteUnretained removes every file under a directory that is not under retention,
+// along with the directories left empty
+func (p *FileSystemProvider) deleteUnretained(fullPath, path string) error {
+	var dirs []string
+	err := filepath.WalkDir(fullPath, func(entryPath string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			dirs = append(dirs, entryPath)
+			return nil
+		}
+		if isSidecarName(entry.Name()) || checkRetention(entryPath, "", p.config.now()) != nil {
+			return nil
+		}
+		if err := os.Remove(entryPath); err != nil {
+			return err
+		}
+		return removeSidecar(entryPath)
+	})
+	if err != nil {
+		return fileSystemError(err, path, ErrorCodeDeleteFailed, "failed to delete directory contents")
+	}
+
+	// Remove directories deepest first; non-empty ones still hold retained files
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
 	}
 
 	return nil
@@ -308,6 +614,12 @@ func (p *FileSystemProvider) DeleteDirectory(ctx context.Context, path string) e
 
 // Copy copies a file from source to destination
 func (p *FileSystemProvider) Copy(ctx context.Context, srcPath, dstPath string) error {
+	if err := checkContext(ctx, srcPath); err != nil {
+		return err
+	}
+	if err := checkTempName(dstPath); err != nil {
+		return err
+	}
 	srcFullPath, err := p.getFullPath(srcPath)
 	if err != nil {
 		return err
@@ -324,33 +636,125 @@ func (p *FileSystemProvider) Copy(ctx context.Context, srcPath, dstPath string)
 		if os.IsNotExist(err) {
 			return FileNotFoundError(srcPath)
 		}
-		return NewProviderError("filesystem", ErrorCodeCopyFailed, "failed to open source file", err)
+		return fileSystemError(err, srcPath, ErrorCodeCopyFailed, "failed to open source file")
 	}
 	defer src.Close()
 
+	// Directories are copied file by file by Storage.Copy
+	if stat, err := src.Stat(); err == nil && stat.IsDir() {
+		return NewStorageErrorWithPath(ErrorCodeInvalidPath, "path is a directory", srcPath)
+	}
+
+	// Files under retention cannot be overwritten
+	if err := checkRetention(dstFullPath, dstPath, p.config.now()); err != nil {
+		return err
+	}
+
 	// Create destination directory if needed
 	if err := os.MkdirAll(filepath.Dir(dstFullPath), 0755); err != nil {
-		return NewProviderError("filesystem", ErrorCodeCopyFailed, "failed to create destination directory", err)
+		return fileSystemError(err, dstPath, ErrorCodeCopyFailed, "failed to create destination directory")
 	}
 
-	// Create destination file
-	dst, err := os.Create(dstFullPath)
-	if err != nil {
-		return NewProviderError("filesystem", ErrorCodeCopyFailed, "failed to create destination file", err)
+	// Copies keep the metadata of the source but start without retention
+	sidecar := readSidecar(srcFullPath)
+	if sidecar != nil {
+		sidecar.RetainUntil = nil
+	}
+
+	// Replace rather than truncate, so content shared through hard links is never modified in place
+	if previous := readSidecar(dstFullPath); previous != nil && previous.Blob != "" {
+		os.Remove(dstFullPath)
+		defer p.releaseBlob(previous.Blob)
+	}
+
+	// Deduplicated content is copied by adding another link to its blob, or copied as a
+	// plain file where it cannot be linked
+	if p.deduplicating() && sidecar != nil && sidecar.Blob != "" {
+		err := p.replaceWithLink(srcFullPath, dstFullPath)
+		if err == nil {
+			if err := writeSidecar(dstFullPath, sidecar); err != nil {
+				return fileSystemError(err, dstPath, ErrorCodeCopyFailed, "failed to copy metadata")
+			}
+			if err := p.syncDir(dstFullPath); err != nil {
+				return fileSystemError(err, dstPath, ErrorCodeCopyFailed, "failed to sync directory")
+			}
+			return nil
+		}
+		if !linkUnsupported(err) {
+			return fileSystemError(err, dstPath, ErrorCodeCopyFailed, "failed to link blob")
+		}
+	}
+
+	// Share the blocks with a reflink where the filesystem supports it, otherwise copy them
+	if err := cloneFile(src, dstFullPath); err != nil {
+		if err := p.streamCopy(ctx, src, dstFullPath); err != nil {
+			os.Remove(dstFullPath) // Clean up on error
+			return fileSystemError(err, srcPath, ErrorCodeCopyFailed, "failed to copy file data")
+		}
+	}
+
+	// Keep the mode and modification time of the source
+	if stat, err := src.Stat(); err == nil {
+		os.Chmod(dstFullPath, stat.Mode().Perm())
+		os.Chtimes(dstFullPath, time.Time{}, stat.ModTime())
 	}
-	defer dst.Close()
 
-	// Copy data
-	if _, err := io.Copy(dst, src); err != nil {
+	if err := p.syncPath(dstFullPath); err != nil {
 		os.Remove(dstFullPath) // Clean up on error
-		return NewProviderError("filesystem", ErrorCodeCopyFailed, "failed to copy file data", err)
+		return fileSystemError(err, dstPath, ErrorCodeCopyFailed, "failed to sync file")
 	}
 
+	// Copy metadata along with the data, which no longer shares a blob
+	if sidecar != nil {
+		sidecar.Blob = ""
+	}
+	if err := writeSidecar(dstFullPath, sidecar); err != nil {
+		os.Remove(dstFullPath) // Clean up on error
+		return fileSystemError(err, dstPath, ErrorCodeCopyFailed, "failed to copy metadata")
+	}
+
+	return nil
+}
+
+// moveByCopy moves a file across filesystems. Copy removes a partially written
+// destination itself; a copy whose source cannot be deleted is removed again.
+func (p *FileSystemProvider) moveByCopy(ctx context.Context, srcPath, dstPath string) error {
+	if err := p.Copy(ctx, srcPath, dstPath); err != nil {
+		return err
+	}
+	if err := p.Delete(ctx, srcPath); err != nil {
+		p.Delete(ctx, dstPath)
+		return err
+	}
 	return nil
 }
 
+// streamCopy copies the content of src into dst, replacing it
+func (p *FileSystemProvider) streamCopy(ctx context.Context, src *os.File, dst string) error {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	file, err := createFile(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := copyContext(ctx, file, src, p.config.GetCopyBufferSize()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 // Move moves a file from source to destination
 func (p *FileSystemProvider) Move(ctx context.Context, srcPath, dstPath string) error {
+	if err := checkContext(ctx, srcPath); err != nil {
+		return err
+	}
+	if err := checkTempName(dstPath); err != nil {
+		return err
+	}
 	srcFullPath, err := p.getFullPath(srcPath)
 	if err != nil {
 		return err
@@ -361,114 +765,260 @@ func (p *FileSystemProvider) Move(ctx context.Context, srcPath, dstPath string)
 		return err
 	}
 
+	// Directories are moved file by file by Storage.Move, so the locks inside them are honored
+	stat, err := os.Lstat(srcFullPath)
+	if err == nil && stat.IsDir() {
+		return NewStorageErrorWithPath(ErrorCodeInvalidPath, "path is a directory", srcPath)
+	}
+
+	// A file moved onto itself stays as it is, sidecar included
+	if srcFullPath == dstFullPath {
+		switch {
+		case os.IsNotExist(err):
+			return FileNotFoundError(srcPath)
+		case err != nil:
+			return fileSystemError(err, srcPath, ErrorCodeMoveFailed, "failed to stat file")
+		}
+		return nil
+	}
+
+	// Files under retention can neither leave their path nor be overwritten
+	if err := checkRetention(srcFullPath, srcPath, p.config.now()); err != nil {
+		return err
+	}
+	if err := checkRetention(dstFullPath, dstPath, p.config.now()); err != nil {
+		return err
+	}
+
 	// Create destination directory if needed
 	if err := os.MkdirAll(filepath.Dir(dstFullPath), 0755); err != nil {
-		return NewProviderError("filesystem", ErrorCodeMoveFailed, "failed to create destination directory", err)
+		return fileSystemError(err, dstPath, ErrorCodeMoveFailed, "failed to create destination directory")
 	}
 
-	// Try to rename first (most efficient if on same filesystem)
-	if err := os.Rename(srcFullPath, dstFullPath); err != nil {
-		// If rename fails, try copy + delete
-		if err := p.Copy(ctx, srcPath, dstPath); err != nil {
-			return err
-		}
-		if err := p.Delete(ctx, srcPath); err != nil {
-			// If delete fails, try to clean up the copy
-			p.Delete(ctx, dstPath)
-			return err
+	// The overwritten file may hold the last reference to its blob
+	if previous := readSidecar(dstFullPath); previous != nil && previous.Blob != "" {
+		defer p.releaseBlob(previous.Blob)
+	}
+
+	// Rename, falling back to copy + delete only when crossing filesystems
+	if err := p.rename(srcFullPath, dstFullPath); err != nil {
+		switch {
+		case os.IsNotExist(err):
+			return FileNotFoundError(srcPath)
+		case errors.Is(err, syscall.EXDEV):
+			return p.moveByCopy(ctx, srcPath, dstPath)
+		default:
+			return fileSystemError(err, srcPath, ErrorCodeMoveFailed, "failed to move file")
 		}
 	}
 
+	// Move metadata along with the data
+	if err := writeSidecar(dstFullPath, readSidecar(srcFullPath)); err != nil {
+		return fileSystemError(err, dstPath, ErrorCodeMoveFailed, "failed to move metadata")
+	}
+	removeSidecar(srcFullPath)
+
+	// A rename changes both directories
+	if err := p.syncDir(dstFullPath); err != nil {
+		return fileSystemError(err, dstPath, ErrorCodeMoveFailed, "failed to sync directory")
+	}
+	if err := p.syncDir(srcFullPath); err != nil {
+		return fileSystemError(err, srcPath, ErrorCodeMoveFailed, "failed to sync directory")
+	}
+	p.pruneParents(srcFullPath)
+
 	return nil
 }
 
-// GenerateSignedURL generates a signed URL for filesystem operations
-func (p *FileSystemProvider) GenerateSignedURL(ctx context.Context, path string, operation SignedURLOperation, expiresIn time.Duration) (string, error) {
-	signedConfig := p.config.GetSignedURLConfig()
-	if !signedConfig.Enabled {
-		return "", NewStorageError(ErrorCodeSignedURLFailed, "signed URLs are not enabled")
+// SetRetention locks a file until the given time, storing the lock in its sidecar
+func (p *FileSystemProvider) SetRetention(ctx context.Context, path string, until time.Time) error {
+	if err := checkContext(ctx, path); err != nil {
+		return err
+	}
+	fullPath, err := p.getFullPath(path)
+	if err != nil {
+		return err
 	}
 
-	if signedConfig.SecretKey == "" {
-		return "", NewStorageError(ErrorCodeSignedURLFailed, "secret key is required for signed URLs")
+	stat, err := os.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return FileNotFoundError(path)
+		}
+		return fileSystemError(err, path, ErrorCodeInternalError, "failed to stat file")
+	}
+	if stat.IsDir() {
+		return NewStorageErrorWithPath(ErrorCodeInvalidPath, "path is a directory", path)
 	}
 
-	// Create JWT token
-	claims := jwt.MapClaims{
-		"path": path,
-		"op":   string(operation),
-		"exp":  time.Now().Add(expiresIn).Unix(),
-		"iat":  time.Now().Unix(),
+	sidecar := readSidecar(fullPath)
+	if sidecar == nil {
+		sidecar = &fileSidecar{}
+	}
+	if err := checkRetentionExtension(path, sidecar.RetainUntil, until, p.config.now()); err != nil {
+		return err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(signedConfig.SecretKey))
+	until = until.UTC()
+	sidecar.RetainUntil = &until
+	if err := writeSidecar(fullPath, sidecar); err != nil {
+		return fileSystemError(err, path, ErrorCodeInternalError, "failed to write metadata")
+	}
+
+	return nil
+}
+
+// GetRetention returns the time a file is locked until, or nil if it is not locked
+func (p *FileSystemProvider) GetRetention(ctx context.Context, path string) (*time.Time, error) {
+	if err := checkContext(ctx, path); err != nil {
+		return nil, err
+	}
+	fullPath, err := p.getFullPath(path)
 	if err != nil {
-		return "", NewProviderError("filesystem", ErrorCodeSignedURLFailed, "failed to sign token", err)
+		return nil, err
+	}
+
+	if _, err := os.Stat(fullPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, FileNotFoundError(path)
+		}
+		return nil, fileSystemError(err, path, ErrorCodeInternalError, "failed to stat file")
 	}
 
+	if sidecar := readSidecar(fullPath); sidecar != nil {
+		return activeRetention(sidecar.RetainUntil, p.config.now()), nil
+	}
+	return nil, nil
+}
+
+// GenerateSignedURL generates a signed URL for filesystem operations
+func (p *FileSystemProvider) GenerateSignedURL(ctx context.Context, path string, operation SignedURLOperation, expiresIn time.Duration) (string, error) {
+	return p.signURL(ctx, path, operation, expiresIn, ResponseOverrides{}, "")
+}
+
+// GenerateSignedURLWithOverrides generates a GET token carrying response header
+// overrides, which the download handler applies once the token is validated
+func (p *FileSystemProvider) GenerateSignedURLWithOverrides(ctx context.Context, path string, expiresIn time.Duration, overrides ResponseOverrides) (string, error) {
+	return p.signURL(ctx, path, SignedURLOperationGet, expiresIn, overrides, "")
+}
+
+// signURL signs a token for path with the named key of SignedURLConfig.Keys, or with
+// SecretKey when keyName is empty
+func (p *FileSystemProvider) signURL(ctx context.Context, path string, operation SignedURLOperation, expiresIn time.Duration, overrides ResponseOverrides, keyName string) (string, error) {
+	if err := checkContext(ctx, path); err != nil {
+		return "", err
+	}
 	// Return the token (the actual URL construction is handled by the application)
-	return tokenString, nil
+	claims := jwt.MapClaims{
+		"path": p.config.tokenPath(path),
+		"op":   string(operation),
+		"exp":  p.config.now().Add(expiresIn).Unix(),
+		"iat":  p.config.now().Unix(),
+	}
+	overrides.claims(claims)
+	return p.signToken(claims, keyName)
 }
 
-// ValidateSignedToken validates a signed token for filesystem operations
-func (p *FileSystemProvider) ValidateSignedToken(tokenString, path string, operation SignedURLOperation) error {
+// GeneratePrefixToken generates a token valid for every path under prefix, such as the
+// segments and variant playlists of an HLS stream. The root cannot be a prefix.
+func (p *FileSystemProvider) GeneratePrefixToken(prefix string, operation SignedURLOperation, expiresIn time.Duration) (string, error) {
+	if isRootPath(prefix) {
+		return "", NewStorageErrorWithPath(ErrorCodeInvalidPath, "a prefix token cannot cover the root", prefix)
+	}
+	return p.signToken(jwt.MapClaims{
+		"prefix": cleanPath(prefix),
+		"op":     string(operation),
+		"exp":    p.config.now().Add(expiresIn).Unix(),
+		"iat":    p.config.now().Unix(),
+	}, "")
+}
+
+// signToken signs the claims of a token with the named signing key, or with SecretKey
+// when keyName is empty, refusing claims outside the scope of the key
+func (p *FileSystemProvider) signToken(claims jwt.MapClaims, keyName string) (string, error) {
 	signedConfig := p.config.GetSignedURLConfig()
 	if !signedConfig.Enabled {
-		return NewStorageError(ErrorCodeSignedURLFailed, "signed URLs are not enabled")
+		return "", NewStorageError(ErrorCodeSignedURLFailed, "signed URLs are not enabled")
 	}
 
-	if signedConfig.SecretKey == "" {
-		return NewStorageError(ErrorCodeSignedURLFailed, "secret key is required for signed URLs")
+	secret, key, err := signedConfig.signingKey(keyName)
+	if err != nil {
+		return "", err
+	}
+	if !p.tokenInScope(key, claims) {
+		return "", NewStorageError(ErrorCodePermissionDenied, fmt.Sprintf("signing key %q cannot sign this operation or path", keyName))
 	}
 
-	// Parse and validate token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(signedConfig.SecretKey), nil
-	})
-
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if key != nil {
+		token.Header["kid"] = key.Name
+	}
+	tokenString, err := token.SignedString([]byte(secret))
 	if err != nil {
-		return InvalidTokenError("invalid token: " + err.Error())
+		return "", NewProviderError("filesystem", ErrorCodeSignedURLFailed, "failed to sign token", err)
 	}
+	return tokenString, nil
+}
 
-	if !token.Valid {
-		return InvalidTokenError("token is not valid")
+// ValidateSignedToken validates a signed token for filesystem operations. The check
+// that failed is the TokenFailure cause of the error.
+func (p *FileSystemProvider) ValidateSignedToken(tokenString, path string, operation SignedURLOperation) error {
+	info, err := p.inspectToken(tokenString, &tokenTarget{path: path, operation: operation})
+	if err != nil {
+		return err
 	}
+	return info.err()
+}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return InvalidTokenError("invalid token claims")
+// fileSystemContentType returns the content type for an upload, preferring the one in metadata
+func fileSystemContentType(path string, metadata *FileMetadata) string {
+	if metadata != nil && metadata.ContentType != "" {
+		return metadata.ContentType
 	}
 
-	// Validate path
-	tokenPath, ok := claims["path"].(string)
-	if !ok || tokenPath != path {
-		return InvalidTokenError("token path does not match requested path")
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
 	}
+	return contentType
+}
 
-	// Validate operation
-	tokenOp, ok := claims["op"].(string)
-	if !ok || tokenOp != string(operation) {
-		return InvalidTokenError("token operation does not match requested operation")
+// fileSystemError wraps an os error, reporting the errors known to mapOSError with their
+// own code and the rest with the given code
+func fileSystemError(err error, path string, code ErrorCode, message string) *StorageError {
+	if mapped := mapOSError(err, path); mapped != nil {
+		return mapped
 	}
+	return NewProviderError("filesystem", code, message, err)
+}
 
-	// Check expiration
-	if exp, ok := claims["exp"].(float64); ok {
-		if time.Now().Unix() > int64(exp) {
-			return TokenExpiredError()
-		}
+// mapOSError converts the os errors that have a StorageError code of their own, keeping
+// the original as Cause, and returns nil for the rest. Missing files are left to the
+// callers, which know whether a file or a directory was expected.
+func mapOSError(err error, path string) *StorageError {
+	var code ErrorCode
+	message := err.Error()
+	switch {
+	case isContextError(err):
+		code = ErrorCodeCanceled
+	case os.IsPermission(err):
+		code, message = ErrorCodePermissionDenied, "permission denied"
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		code, message = ErrorCodeInsufficientStorage, "no space left on device"
+	case errors.Is(err, syscall.ENAMETOOLONG):
+		code, message = ErrorCodeInvalidPath, "file name too long"
+	case errors.Is(err, syscall.ETIMEDOUT):
+		code = ErrorCodeTimeout
+	default:
+		return nil
 	}
-
-	return nil
+	return &StorageError{Code: code, Message: message, Provider: "filesystem", Path: path, Cause: err}
 }
 
 // getFullPath constructs the full filesystem path
 func (p *FileSystemProvider) getFullPath(path string) (string, error) {
 	// Clean and validate path
-	cleanPath := filepath.Clean(path)
+	cleanPath := filepath.Clean(p.config.normalizePath(path))
 
 	// Prevent path traversal attacks
 	if strings.Contains(cleanPath, "..") {
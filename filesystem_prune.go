@@ -0,0 +1,98 @@
+package vsaasstorage
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pruneParents removes the directories above fullPath that became empty, stopping at
+// the first one that still has entries and never removing BasePath itself
+func (p *FileSystemProvider) pruneParents(fullPath string) {
+	if !p.config.FileSystem.PruneEmptyDirs {
+		return
+	}
+
+	base := filepath.Clean(p.config.FileSystem.BasePath)
+	for dir := filepath.Dir(fullPath); strings.HasPrefix(dir, base+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		// Remove only succeeds on empty directories. A directory that another upload
+		// just recreated or filled is left alone, and one already removed is skipped.
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			return
+		}
+	}
+}
+
+// createFile creates a file, recreating its directory if a concurrent prune removed it
+func createFile(fullPath string) (*os.File, error) {
+	file, err := os.Create(fullPath)
+	if err != nil && os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return nil, err
+		}
+		file, err = os.Create(fullPath)
+	}
+	return file, err
+}
+
+// PruneEmptyDirectories removes every empty directory under root, deepest first, and
+// returns how many were removed. root itself is kept.
+func (p *FileSystemProvider) PruneEmptyDirectories(ctx context.Context, root string) (int, error) {
+	rootPath, err := p.getFullPath(root)
+	if err != nil {
+		return 0, err
+	}
+	blobs := filepath.Join(p.config.FileSystem.BasePath, blobsDir)
+
+	var dirs []string
+	err = filepath.WalkDir(rootPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path == blobs {
+			return filepath.SkipDir
+		}
+		if path != rootPath {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, DirectoryNotFoundError(root)
+		}
+		return 0, fileSystemError(err, root, ErrorCodeDeleteFailed, "failed to walk directory")
+	}
+
+	// WalkDir visits parents before children, so walking backwards empties children first
+	removed := 0
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := checkContext(ctx, root); err != nil {
+			return removed, err
+		}
+		if err := os.Remove(dirs[i]); err == nil {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// PruneEmptyDirectories removes the empty directories under root, for cleaning up trees
+// left behind before FileSystemConfig.PruneEmptyDirs was enabled
+func (s *Storage) PruneEmptyDirectories(ctx context.Context, root string) (int, error) {
+	if err := s.checkWritable(root); err != nil {
+		return 0, err
+	}
+
+	provider, ok := providerAs[*FileSystemProvider](s.provider)
+	if !ok {
+		return 0, NotSupportedError("pruning directories is not supported by the provider")
+	}
+	return provider.PruneEmptyDirectories(ctx, s.extensionPath(root))
+}
@@ -0,0 +1,98 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSystemPruneEmptyDirs(t *testing.T) {
+	ctx := context.Background()
+	basePath := t.TempDir()
+	storage, err := New(&StorageConfig{
+		Name:       "PruneStorage",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: basePath, PruneEmptyDirs: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	exists := func(path string) bool {
+		_, err := os.Stat(filepath.Join(basePath, path))
+		return err == nil
+	}
+
+	t.Run("Delete prunes up to the first non-empty parent", func(t *testing.T) {
+		storage.Upload(ctx, "cameras/cam42/2024/06/12/clip.mp4", strings.NewReader("clip"), nil)
+		storage.Upload(ctx, "cameras/cam42/keep.txt", strings.NewReader("keep"), nil)
+
+		if err := storage.Delete(ctx, "cameras/cam42/2024/06/12/clip.mp4"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if exists("cameras/cam42/2024") {
+			t.Error("Expected empty parents to be removed")
+		}
+		if !exists("cameras/cam42") {
+			t.Error("Expected the non-empty parent to be kept")
+		}
+	})
+
+	t.Run("Move prunes the source parents", func(t *testing.T) {
+		storage.Upload(ctx, "incoming/cam1/a.mp4", strings.NewReader("a"), nil)
+		if err := storage.Move(ctx, "incoming/cam1/a.mp4", "archive/cam1/a.mp4"); err != nil {
+			t.Fatalf("Move failed: %v", err)
+		}
+		if exists("incoming") {
+			t.Error("Expected the emptied source tree to be removed")
+		}
+	})
+
+	t.Run("BasePath is never removed", func(t *testing.T) {
+		storage.Upload(ctx, "root.txt", strings.NewReader("root"), nil)
+		storage.Delete(ctx, "root.txt")
+		storage.DeleteDirectory(ctx, "cameras")
+		storage.DeleteDirectory(ctx, "archive")
+
+		if _, err := os.Stat(basePath); err != nil {
+			t.Errorf("Expected BasePath to survive: %v", err)
+		}
+	})
+}
+
+func TestPruneEmptyDirectories(t *testing.T) {
+	ctx := context.Background()
+	basePath := t.TempDir()
+	storage, err := New(&StorageConfig{
+		Name:       "PruneStorage",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: basePath},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	for _, dir := range []string{"cameras/a/2024/01", "cameras/b/2024/02", "cameras/c"} {
+		os.MkdirAll(filepath.Join(basePath, dir), 0755)
+	}
+	storage.Upload(ctx, "cameras/c/clip.mp4", strings.NewReader("clip"), nil)
+
+	removed, err := storage.PruneEmptyDirectories(ctx, "cameras")
+	if err != nil {
+		t.Fatalf("PruneEmptyDirectories failed: %v", err)
+	}
+	if removed != 6 {
+		t.Errorf("Expected 6 directories removed, got %d", removed)
+	}
+	if _, err := os.Stat(filepath.Join(basePath, "cameras/c/clip.mp4")); err != nil {
+		t.Error("Expected files to be kept")
+	}
+
+	_, mem := newTransferStorages(t)
+	if _, err := mem.PruneEmptyDirectories(ctx, ""); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected a not supported error, got %v", err)
+	}
+}
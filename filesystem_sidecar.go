@@ -0,0 +1,117 @@
+package vsaasstorage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sidecarSuffix is appended to the hidden metadata file stored next to each object
+const sidecarSuffix = ".meta"
+
+// fileSidecar is the per-object metadata persisted by the filesystem provider
+type fileSidecar struct {
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	Blob        string     `json:"blob,omitempty"` // Hash of the deduplicated content the file links to
+
+	// ETag caches the MD5 of the content, valid while the file keeps the size and
+	// modification time it had when it was computed
+	ETag        string            `json:"etag,omitempty"`
+	ETagSize    int64             `json:"etag_size,omitempty"`
+	ETagModTime int64             `json:"etag_mtime,omitempty"` // Unix nanoseconds
+	Metadata    map[string]string `json:"metadata,omitempty"`
+
+	ContentEncoding string `json:"content_encoding,omitempty"`
+}
+
+// setETag caches the checksum of a file as described by stat
+func (s *fileSidecar) setETag(etag string, stat os.FileInfo) {
+	s.ETag = etag
+	s.ETagSize = stat.Size()
+	s.ETagModTime = stat.ModTime().UnixNano()
+}
+
+// cachedETag returns the cached checksum if the file has not changed since it was computed
+func (s *fileSidecar) cachedETag(size int64, modTime time.Time) string {
+	if s.ETag == "" || s.ETagSize != size || s.ETagModTime != modTime.UnixNano() {
+		return ""
+	}
+	return s.ETag
+}
+
+// empty reports whether the sidecar holds nothing worth storing
+func (s *fileSidecar) empty() bool {
+	return s.ExpiresAt == nil && s.RetainUntil == nil && s.Blob == "" && s.ETag == "" && len(s.Metadata) == 0 && s.ContentEncoding == ""
+}
+
+// sidecarPath returns the metadata file path for an object, e.g. dir/.name.meta
+func sidecarPath(fullPath string) string {
+	return filepath.Join(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+sidecarSuffix)
+}
+
+// isSidecarName reports whether a directory entry is a metadata sidecar
+func isSidecarName(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, sidecarSuffix) && len(name) > len(sidecarSuffix)+1
+}
+
+// readSidecar loads the metadata stored for an object. Missing or unreadable sidecars yield nil.
+func readSidecar(fullPath string) *fileSidecar {
+	data, err := os.ReadFile(sidecarPath(fullPath))
+	if err != nil {
+		return nil
+	}
+
+	var sidecar fileSidecar
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return nil
+	}
+	return &sidecar
+}
+
+// writeSidecar stores the metadata for an object, removing the sidecar when there is nothing to keep
+func writeSidecar(fullPath string, sidecar *fileSidecar) error {
+	if sidecar == nil || sidecar.empty() {
+		return removeSidecar(fullPath)
+	}
+
+	data, err := json.Marshal(sidecar)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(sidecarPath(fullPath), data, 0644)
+}
+
+// removeSidecar deletes the metadata file of an object if present
+func removeSidecar(fullPath string) error {
+	if err := os.Remove(sidecarPath(fullPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// applySidecar copies the stored metadata of an object into its FileInfo, with the
+// retention lock only if it is still active at now
+func applySidecar(info *FileInfo, fullPath string, now time.Time) {
+	if sidecar := readSidecar(fullPath); sidecar != nil {
+		info.ExpiresAt = sidecar.ExpiresAt
+		info.RetainUntil = activeRetention(sidecar.RetainUntil, now)
+		info.Metadata = sidecar.infoMetadata()
+		info.ContentEncoding = sidecar.ContentEncoding
+		if info.LastModified != nil {
+			info.ETag = sidecar.cachedETag(info.Size, *info.LastModified)
+		}
+	}
+}
+
+// checkRetention fails if the object at fullPath is under a retention lock active at now
+func checkRetention(fullPath, path string, now time.Time) error {
+	if sidecar := readSidecar(fullPath); sidecar != nil {
+		if until := activeRetention(sidecar.RetainUntil, now); until != nil {
+			return RetentionLockedError(path, *until)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package vsaasstorage
+
+// volumeCapacity is not available on this platform
+func volumeCapacity(path string) (*CapacityInfo, error) {
+	return nil, NotSupportedError("volume capacity is not available on this platform")
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package vsaasstorage
+
+import "syscall"
+
+// volumeCapacity reads the space of the volume holding path
+func volumeCapacity(path string) (*CapacityInfo, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return nil, err
+	}
+	blockSize := uint64(stat.Bsize)
+	return &CapacityInfo{
+		Total:     int64(uint64(stat.Blocks) * blockSize),
+		Free:      int64(uint64(stat.Bfree) * blockSize),
+		Available: int64(uint64(stat.Bavail) * blockSize),
+	}, nil
+}
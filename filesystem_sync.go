@@ -0,0 +1,69 @@
+package vsaasstorage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// SyncWritesMode controls how the filesystem provider flushes writes to disk
+type SyncWritesMode string
+
+const (
+	SyncWritesNone       SyncWritesMode = "none"     // Leave flushing to the operating system
+	SyncWritesFile       SyncWritesMode = "file"     // Sync the file contents before returning
+	SyncWritesFileAndDir SyncWritesMode = "file+dir" // Also sync the parent directory so the entry survives a crash
+)
+
+// syncWritesMode returns the configured mode, defaulting to SyncWritesNone
+func (p *FileSystemProvider) syncWritesMode() SyncWritesMode {
+	if p.config.FileSystem.SyncWrites == "" {
+		return SyncWritesNone
+	}
+	return p.config.FileSystem.SyncWrites
+}
+
+// syncFile flushes an open file when SyncWrites requires it
+func (p *FileSystemProvider) syncFile(file *os.File) error {
+	if p.syncWritesMode() == SyncWritesNone {
+		return nil
+	}
+	return file.Sync()
+}
+
+// syncPath flushes a file that is not open anymore, and its directory if required
+func (p *FileSystemProvider) syncPath(fullPath string) error {
+	if p.syncWritesMode() == SyncWritesNone {
+		return nil
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return p.syncDir(fullPath)
+}
+
+// syncDir flushes the directory holding fullPath when SyncWrites is "file+dir"
+func (p *FileSystemProvider) syncDir(fullPath string) error {
+	if p.syncWritesMode() != SyncWritesFileAndDir {
+		return nil
+	}
+
+	dir, err := os.Open(filepath.Dir(fullPath))
+	if err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+	return dir.Close()
+}
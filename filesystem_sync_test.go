@@ -0,0 +1,57 @@
+package vsaasstorage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFileSystemSyncWrites(t *testing.T) {
+	ctx := context.Background()
+
+	for _, mode := range []SyncWritesMode{SyncWritesNone, SyncWritesFile, SyncWritesFileAndDir} {
+		for _, deduplicate := range []bool{false, true} {
+			t.Run(string(mode), func(t *testing.T) {
+				storage, err := New(&StorageConfig{
+					Name:     "SyncStorage",
+					Provider: "filesystem",
+					FileSystem: &FileSystemConfig{
+						BasePath:    t.TempDir(),
+						SyncWrites:  mode,
+						Deduplicate: deduplicate,
+					},
+				})
+				if err != nil {
+					t.Fatalf("Failed to create storage: %v", err)
+				}
+
+				if _, err := storage.Upload(ctx, "events/day.log", strings.NewReader("event"), nil); err != nil {
+					t.Fatalf("Upload failed: %v", err)
+				}
+				if err := storage.Copy(ctx, "events/day.log", "backup/day.log"); err != nil {
+					t.Fatalf("Copy failed: %v", err)
+				}
+				if err := storage.Move(ctx, "backup/day.log", "archive/day.log"); err != nil {
+					t.Fatalf("Move failed: %v", err)
+				}
+
+				reader, _, err := storage.Download(ctx, "archive/day.log")
+				if err != nil {
+					t.Fatalf("Download failed: %v", err)
+				}
+				defer reader.Close()
+				if content, _ := io.ReadAll(reader); string(content) != "event" {
+					t.Errorf("Unexpected content '%s'", content)
+				}
+			})
+		}
+	}
+
+	t.Run("Invalid mode", func(t *testing.T) {
+		config := &FileSystemConfig{BasePath: t.TempDir(), SyncWrites: "always"}
+		if err := config.Validate(); err == nil {
+			t.Error("Expected an invalid syncWrites value to be rejected")
+		}
+	})
+}
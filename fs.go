@@ -0,0 +1,298 @@
+package vsaasstorage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"time"
+)
+
+// FS returns a read-only view of the storage as an fs.FS, which also implements
+// fs.ReadDirFS and fs.StatFS. Every operation runs with the given context.
+//
+//	http.Handle("/app/", http.StripPrefix("/app/", http.FileServerFS(storage.FS(ctx))))
+func (s *Storage) FS(ctx context.Context) fs.FS {
+	return &storageFS{ctx: ctx, storage: s}
+}
+
+// HTTPFileSystem returns the storage as an http.FileSystem for http.FileServer, Echo's
+// static middleware or http.ServeContent. Files are seekable and only the ranges that
+// are actually read are fetched, so Range requests on large files stay cheap.
+func (s *Storage) HTTPFileSystem(ctx context.Context) http.FileSystem {
+	return http.FS(s.FS(ctx))
+}
+
+// openFile opens a file for reading without fetching its content yet
+func (s *Storage) openFile(ctx context.Context, path string, info *FileInfo) *fsFile {
+	return &fsFile{fsys: &storageFS{ctx: ctx, storage: s}, name: path, info: info}
+}
+
+// storageFS adapts a Storage to the io/fs interfaces
+type storageFS struct {
+	ctx     context.Context
+	storage *Storage
+}
+
+// Open opens the named file or directory
+func (f *storageFS) Open(name string) (fs.File, error) {
+	info, err := f.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDirectory {
+		return &fsDir{fsys: f, name: name, info: info}, nil
+	}
+
+	return &fsFile{fsys: f, name: name, info: info}, nil
+}
+
+// Stat returns information about the named file or directory
+func (f *storageFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := f.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fsFileInfo{info: info, name: fsBaseName(name)}, nil
+}
+
+// ReadDir reads the named directory, returning its entries sorted by name
+func (f *storageFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	files, err := f.storage.List(f.ctx, fsStoragePath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fsError(err)}
+	}
+
+	sortByName(files)
+	entries := make([]fs.DirEntry, len(files))
+	for i, file := range files {
+		entries[i] = fs.FileInfoToDirEntry(fsFileInfo{info: file, name: file.Name})
+	}
+	return entries, nil
+}
+
+// stat looks up a path, reporting errors as *fs.PathError
+func (f *storageFS) stat(op, name string) (*FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	info, err := f.storage.GetInfo(f.ctx, fsStoragePath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fsError(err)}
+	}
+	return info, nil
+}
+
+// fsReadAhead is the buffer of an open file, which lets small reads, like those of
+// container parsers, and short forward seeks go without another range request
+const fsReadAhead = 32 << 10
+
+// fsFile is an open file. Content is fetched lazily with ReadRange, so opening a file
+// or seeking in it does not download anything until the next Read.
+type fsFile struct {
+	fsys     *storageFS
+	name     string
+	info     *FileInfo
+	reader   io.ReadCloser
+	buffered *bufio.Reader // Read ahead of reader
+	offset   int64         // Position of the next Read
+	at       int64         // Position of buffered
+	closed   bool
+}
+
+// Stat returns information about the file
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return fsFileInfo{info: f.info, name: fsBaseName(f.name)}, nil
+}
+
+// Read reads from the current offset
+func (f *fsFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.offset >= f.info.Size {
+		return 0, io.EOF
+	}
+
+	if f.reader != nil && f.offset > f.at && f.offset-f.at <= int64(f.buffered.Buffered()) {
+		skipped, _ := f.buffered.Discard(int(f.offset - f.at))
+		f.at += int64(skipped)
+	}
+	if f.reader == nil || f.at != f.offset {
+		f.closeReader()
+		reader, _, err := f.fsys.storage.ReadRange(f.fsys.ctx, fsStoragePath(f.name), f.offset, -1)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: fsError(err)}
+		}
+		f.reader = reader
+		f.buffered = bufio.NewReaderSize(reader, fsReadAhead)
+		f.at = f.offset
+	}
+
+	n, err := f.buffered.Read(p)
+	f.offset += int64(n)
+	f.at += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+// Close releases the current reader
+func (f *fsFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return f.closeReader()
+}
+
+// closeReader closes the current reader, if any
+func (f *fsFile) closeReader() error {
+	if f.reader == nil {
+		return nil
+	}
+	err := f.reader.Close()
+	f.reader, f.buffered = nil, nil
+	return err
+}
+
+// fsDir is an open directory
+type fsDir struct {
+	fsys    *storageFS
+	name    string
+	info    *FileInfo
+	entries []fs.DirEntry
+	read    bool
+	closed  bool
+}
+
+// Stat returns information about the directory
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return fsFileInfo{info: d.info, name: fsBaseName(d.name)}, nil
+}
+
+// Read always fails for directories
+func (d *fsDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the next n entries, or all remaining entries if n <= 0
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.read = true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// Close closes the directory
+func (d *fsDir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
+
+// fsFileInfo exposes a FileInfo as an fs.FileInfo
+type fsFileInfo struct {
+	info *FileInfo
+	name string
+}
+
+func (i fsFileInfo) Name() string { return i.name }
+func (i fsFileInfo) Size() int64  { return i.info.Size }
+func (i fsFileInfo) IsDir() bool  { return i.info.IsDirectory }
+func (i fsFileInfo) Sys() any     { return i.info }
+
+func (i fsFileInfo) Mode() fs.FileMode {
+	if i.info.IsDirectory {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i fsFileInfo) ModTime() time.Time {
+	if i.info.LastModified == nil {
+		return time.Time{}
+	}
+	return *i.info.LastModified
+}
+
+// fsStoragePath converts an fs path into a storage path
+func fsStoragePath(name string) string {
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+// fsBaseName returns the name reported for an fs path
+func fsBaseName(name string) string {
+	return path.Base(name)
+}
+
+// fsError maps storage errors to the io/fs sentinel errors
+func fsError(err error) error {
+	switch {
+	case errors.Is(err, ErrFileNotFound), errors.Is(err, ErrDirectoryNotFound):
+		return fs.ErrNotExist
+	case errors.Is(err, ErrPermissionDenied):
+		return fs.ErrPermission
+	case errors.Is(err, ErrInvalidPath):
+		return fs.ErrInvalid
+	default:
+		return err
+	}
+}
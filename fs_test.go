@@ -0,0 +1,59 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStorageFS(t *testing.T) {
+	ctx := context.Background()
+	fsStorage, memStorage := newTransferStorages(t)
+
+	for name, storage := range map[string]*Storage{"filesystem": fsStorage, "memory": memStorage} {
+		t.Run(name, func(t *testing.T) {
+			files := map[string]string{
+				"index.html":         "<html></html>",
+				"assets/app.js":      "console.log('hi')",
+				"assets/css/app.css": "body {}",
+			}
+			for path, content := range files {
+				if _, err := storage.Upload(ctx, path, strings.NewReader(content), nil); err != nil {
+					t.Fatalf("Upload of %s failed: %v", path, err)
+				}
+			}
+
+			fsys := storage.FS(ctx)
+			if err := fstest.TestFS(fsys, "index.html", "assets/app.js", "assets/css/app.css"); err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := fs.ReadFile(fsys, "assets/app.js")
+			if err != nil || string(content) != files["assets/app.js"] {
+				t.Errorf("Unexpected content '%s', %v", content, err)
+			}
+
+			if _, err := fsys.Open("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Expected fs.ErrNotExist, got %v", err)
+			}
+
+			info, err := fs.Stat(fsys, "assets")
+			if err != nil || !info.IsDir() || info.Name() != "assets" {
+				t.Errorf("Unexpected directory info %v, %v", info, err)
+			}
+
+			file, _ := fsys.Open("index.html")
+			defer file.Close()
+			seeker := file.(io.Seeker)
+			seeker.Seek(6, io.SeekStart)
+			rest, _ := io.ReadAll(file)
+			if string(rest) != "</html>" {
+				t.Errorf("Expected seek to skip the opening tag, got '%s'", rest)
+			}
+		})
+	}
+}
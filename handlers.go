@@ -1,54 +1,315 @@
 package vsaasstorage
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/labstack/echo/v4"
 	rest "github.com/xompass/vsaas-rest"
 	"github.com/xompass/vsaas-rest/http_errors"
 )
 
-// UploadHandler creates a handler function for file uploads using vsaas-rest
-func (s *Storage) UploadHandler(destinationDir string) func(c *rest.EndpointContext) error {
-	return func(c *rest.EndpointContext) error {
-		// Use the new UploadFromCtx function
-		results, err := s.UploadFromCtx(c.Context(), c, destinationDir)
-		if err != nil {
-			if storageErr, ok := err.(*StorageError); ok {
-				switch storageErr.Code {
-				case ErrorCodeUploadFailed:
-					return http_errors.BadRequestError(storageErr.Message)
-				default:
-					return http_errors.InternalServerError(storageErr.Message)
-				}
+// requestPath returns the path of a handler request, from the path route parameter or
+// the path query parameter. Echo leaves route parameters escaped when the request path
+// has escapes the default encoding would not produce, such as %2F, so they are unescaped
+// here: authorization, tokens and the provider must all see the same path.
+func requestPath(c *rest.EndpointContext) string {
+	path := c.EchoCtx.Param("path")
+	if path != "" && c.EchoCtx.Request().URL.RawPath != "" {
+		if unescaped, err := url.PathUnescape(path); err == nil {
+			path = unescaped
+		}
+	}
+	if path == "" {
+		path = c.EchoCtx.QueryParam("path")
+	}
+	return path
+}
+
+// httpError converts an error into the HTTP error matching StorageError.HTTPStatus.
+// Server errors are prefixed with the given message for context. The error is kept as
+// the internal error of the response, where traced adds the request ID to it.
+func httpError(err error, message string) error {
+	var multi *MultiError
+	if errors.As(err, &multi) {
+		return batchError(multi, message, 0)
+	}
+
+	var storageErr *StorageError
+	if !errors.As(err, &storageErr) {
+		return withCause(http_errors.InternalServerError(message+": "+err.Error()), err)
+	}
+
+	status := storageErr.HTTPStatus()
+	if status >= http.StatusInternalServerError {
+		return withCause(statusError(status, message+": "+err.Error()), err)
+	}
+	if storageErr.Code == ErrorCodeArchived {
+		// Tell clients they can request a restore rather than retry the download
+		return echo.NewHTTPError(status, map[string]interface{}{
+			"code":             storageErr.Code,
+			"message":          storageErr.Message,
+			"path":             storageErr.Path,
+			"restore_required": true,
+		}).SetInternal(err)
+	}
+	return withCause(statusError(status, storageErr.Message), err)
+}
+
+// mutationError maps the error of a handler that modifies the storage to its status,
+// with the error code and path in the body so clients can tell the failures apart.
+// Retention locks are refusals like read-only storage and answer 403.
+func mutationError(err error, message, path string) error {
+	var storageErr *StorageError
+	var multi *MultiError
+	if errors.As(err, &multi) || !errors.As(err, &storageErr) {
+		return httpError(err, message)
+	}
+
+	status := storageErr.HTTPStatus()
+	if storageErr.Code == ErrorCodeRetentionLocked {
+		status = http.StatusForbidden
+	}
+	if status >= http.StatusInternalServerError {
+		return httpError(err, message)
+	}
+	return echo.NewHTTPError(status, map[string]interface{}{
+		"code":    storageErr.Code,
+		"message": storageErr.Message,
+		"path":    path,
+	}).SetInternal(err)
+}
+
+// batchError renders the failures of a batch operation of total paths with the failure of
+// each path in the body: 207 when some of them succeeded, and 400 when all failed, or 500
+// when they all failed on server errors. A total of 0 means every path failed.
+func batchError(failed *MultiError, message string, total int) error {
+	status := http.StatusMultiStatus
+	if total <= failed.Len() {
+		status = http.StatusInternalServerError
+		for path := range failed.Errors {
+			if failed.Failed(path).HTTPStatus() < http.StatusInternalServerError {
+				status = http.StatusBadRequest
+				break
 			}
-			return http_errors.InternalServerError("Failed to upload files: " + err.Error())
 		}
+	}
+	body := failed.body()
+	body["message"] = message
+	return echo.NewHTTPError(status, body).SetInternal(failed)
+}
 
-		return c.JSON(map[string]interface{}{
-			"message": "Files uploaded successfully",
-			"files":   results,
-		})
+// withCause sets cause as the internal error of an HTTP error
+func withCause(err, cause error) error {
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) && httpErr.Internal == nil {
+		httpErr.Internal = cause
+	}
+	return err
+}
+
+// statusError creates an HTTP error for the given status code
+func statusError(status int, message string) error {
+	switch status {
+	case http.StatusBadRequest:
+		return http_errors.BadRequestError(message)
+	case http.StatusUnauthorized:
+		return http_errors.UnauthorizedError(message)
+	case http.StatusNotFound:
+		return http_errors.NotFoundError(message)
+	case http.StatusInternalServerError:
+		return http_errors.InternalServerError(message)
+	default:
+		return echo.NewHTTPError(status, message)
+	}
+}
+
+// UploadHandler creates a handler function for file uploads using vsaas-rest. Each file
+// is reported with its own status: 200 is returned when at least one was stored and 400
+// when all failed. ?atomic=true makes the request all-or-nothing and ?signed_url=true
+// adds a signed URL to each stored file. Requests accepting text/event-stream receive
+// the progress of each file as server-sent events, followed by the response. layout,
+// when given, places the files under destinationDir instead of StorageConfig.UploadLayout.
+func (s *Storage) UploadHandler(destinationDir string, layout ...PathLayout) func(c *rest.EndpointContext) error {
+	return s.traced(func(c *rest.EndpointContext) error {
+		if err := s.authorize(c, destinationDir, SignedURLOperationPut); err != nil {
+			return err
+		}
+
+		// ?signed_url=true adds a signed URL to each result, valid for ?expires_in seconds
+		opts := UploadOptions{
+			IdempotencyKey:   c.EchoCtx.Request().Header.Get(IdempotencyKeyHeader),
+			Atomic:           c.EchoCtx.QueryParam("atomic") == "true",
+			IncludeSignedURL: c.EchoCtx.QueryParam("signed_url") == "true",
+		}
+		if seconds, err := strconv.Atoi(c.EchoCtx.QueryParam("expires_in")); err == nil && seconds > 0 {
+			opts.SignedURLExpiresIn = time.Duration(seconds) * time.Second
+		}
+		if len(layout) > 0 {
+			opts.PathLayout = layout[0]
+		}
+		// Content-MD5 or x-checksum-sha256 are checked against the content of the file
+		checksum, err := ParseChecksumHeaders(c.EchoCtx.Request().Header)
+		if err != nil {
+			return httpError(err, "Failed to upload files")
+		}
+		opts.ExpectedChecksum = checksum
+
+		if strings.Contains(c.EchoCtx.Request().Header.Get("Accept"), "text/event-stream") {
+			return s.streamUpload(c, destinationDir, opts)
+		}
+
+		results, err := s.UploadFromCtxWithOptions(c.Context(), c, destinationDir, opts)
+		var uploadErr *UploadError
+		if err != nil && !errors.As(err, &uploadErr) {
+			s.setRetryAfter(c, err)
+			return httpError(err, "Failed to upload files")
+		}
+		if failure := checksumFailure(opts, uploadErr); failure != nil {
+			return mutationError(failure, "Failed to upload file", destinationDir)
+		}
+		if len(results) == 1 && uploadErr == nil && results[0].ETag != "" {
+			c.EchoCtx.Response().Header().Set("ETag", quoteETag(results[0].ETag))
+		}
+
+		return c.JSON(uploadResponse(results, uploadErr, RequestIDFrom(c.Context())))
+	})
+}
+
+// streamUpload answers an upload with server-sent events: "progress" while the files are
+// stored, then a "result" event with the body and status of the JSON response, or an
+// "error" event with the status and message of the failure
+func (s *Storage) streamUpload(c *rest.EndpointContext, destinationDir string, opts UploadOptions) error {
+	stream := NewProgressStream(c)
+	opts.Progress = stream.Progress
+
+	results, err := s.UploadFromCtxWithOptions(c.Context(), c, destinationDir, opts)
+	var uploadErr *UploadError
+	if errors.As(err, &uploadErr) {
+		if failure := checksumFailure(opts, uploadErr); failure != nil {
+			err, uploadErr = failure, nil
+		}
+	}
+	if err != nil && uploadErr == nil {
+		status := http.StatusInternalServerError
+		var storageErr *StorageError
+		if errors.As(err, &storageErr) {
+			status = storageErr.HTTPStatus()
+		}
+		event := map[string]interface{}{
+			"status":     status,
+			"message":    err.Error(),
+			"request_id": RequestIDFrom(c.Context()),
+		}
+		// The headers are already sent, so the Retry-After goes in the event
+		if seconds := s.uploadRetryAfter(err); seconds > 0 {
+			event["retry_after"] = seconds
+		}
+		stream.Send("error", event)
+		return nil
 	}
+
+	body, status := uploadResponse(results, uploadErr, RequestIDFrom(c.Context()))
+	body["status"] = status
+	stream.Send("result", body)
+	return nil
+}
+
+// checksumFailure returns the error of the file of an upload sent with a checksum, which
+// answers with the status of its failure, e.g. 422 for a mismatch, rather than as one
+// file of many
+func checksumFailure(opts UploadOptions, uploadErr *UploadError) error {
+	if opts.ExpectedChecksum == nil || uploadErr == nil || len(uploadErr.Failures) != 1 {
+		return nil
+	}
+	return uploadErr.Failures[0].Err
+}
+
+// uploadedFileStatus is a stored file in an upload response
+type uploadedFileStatus struct {
+	Status int `json:"status"`
+	*UploadedFileResult
+}
+
+// failedFileStatus is a file that could not be stored in an upload response
+type failedFileStatus struct {
+	Status       int       `json:"status"`
+	FieldName    string    `json:"field_name"`
+	OriginalName string    `json:"original_name"`
+	Code         ErrorCode `json:"code"`
+	Message      string    `json:"message"`
+}
+
+// uploadResponse builds the body and status of a multi-file upload response. Responses
+// with failed files include the request ID so they can be matched with the logs.
+func uploadResponse(results []*UploadedFileResult, uploadErr *UploadError, requestID string) (map[string]interface{}, int) {
+	files := make([]interface{}, 0, len(results))
+	for _, result := range results {
+		files = append(files, uploadedFileStatus{Status: http.StatusCreated, UploadedFileResult: result})
+	}
+
+	var failures []*UploadFailure
+	if uploadErr != nil {
+		failures = uploadErr.Failures
+	}
+	for _, failure := range failures {
+		status := failedFileStatus{
+			Status:       http.StatusInternalServerError,
+			FieldName:    failure.FieldName,
+			OriginalName: failure.OriginalName,
+			Code:         ErrorCodeInternalError,
+			Message:      failure.Err.Error(),
+		}
+		var storageErr *StorageError
+		if errors.As(failure.Err, &storageErr) {
+			status.Status, status.Code, status.Message = storageErr.HTTPStatus(), storageErr.Code, storageErr.Message
+		}
+		files = append(files, status)
+	}
+
+	message, status := "Files uploaded successfully", http.StatusOK
+	switch {
+	case len(results) == 0:
+		message, status = "No files were uploaded", http.StatusBadRequest
+	case len(failures) > 0:
+		message = "Some files failed to upload"
+	}
+	body := map[string]interface{}{
+		"message":  message,
+		"files":    files,
+		"uploaded": len(results),
+		"failed":   len(failures),
+	}
+	if len(failures) > 0 && requestID != "" {
+		body["request_id"] = requestID
+	}
+	return body, status
 }
 
 // DownloadHandler creates a handler function for file downloads
 func (s *Storage) DownloadHandler() func(c *rest.EndpointContext) error {
-	return func(c *rest.EndpointContext) error {
-		path := c.EchoCtx.Param("path")
-		if path == "" {
-			path = c.EchoCtx.QueryParam("path")
-		}
+	return s.traced(func(c *rest.EndpointContext) error {
+		path := requestPath(c)
 
 		if path == "" {
 			return http_errors.BadRequestError("File path is required")
 		}
 
 		return s.StreamFile(c, path)
-	}
+	})
 }
 
 // handleSignedURLRequest handles the generation of signed URLs
@@ -56,6 +317,9 @@ func (s *Storage) handleSignedURLRequest(c *rest.EndpointContext, path string) e
 	// Get expiration time from query params or use default
 	expiresInStr := c.EchoCtx.QueryParam("expires_in")
 	expiresIn := s.config.GetSignedURLConfig().ExpiresIn
+	if s.config.CDNSigning != nil {
+		expiresIn = 0 // Zero defers to CDNSigningConfig.ExpiresIn
+	}
 
 	if expiresInStr != "" {
 		if seconds, err := strconv.Atoi(expiresInStr); err == nil {
@@ -66,11 +330,11 @@ func (s *Storage) handleSignedURLRequest(c *rest.EndpointContext, path string) e
 	// Generate signed URL
 	signedURL, err := s.GenerateSignedURL(c.Context(), path, SignedURLOperationGet, expiresIn)
 	if err != nil {
-		return http_errors.InternalServerError("Failed to generate signed URL: " + err.Error())
+		return httpError(err, "Failed to generate signed URL")
 	}
 
-	// For filesystem provider, construct the actual URL
-	if s.config.Provider == "filesystem" {
+	// For filesystem provider, construct the actual URL unless the CDN signed it
+	if s.config.Provider == "filesystem" && s.config.CDNSigning == nil {
 		// The signed URL for filesystem is just the token, we need to construct the full URL
 		req := c.EchoCtx.Request()
 		scheme := "http"
@@ -92,145 +356,634 @@ func (s *Storage) handleSignedURLRequest(c *rest.EndpointContext, path string) e
 func (s *Storage) handleTokenDownload(c *rest.EndpointContext, path, token string) error {
 	// Validate token (only for filesystem provider)
 	if s.config.Provider == "filesystem" {
-		if fsProvider, ok := s.provider.(*FileSystemProvider); ok {
+		if fsProvider, ok := providerAs[*FileSystemProvider](s.provider); ok {
 			if err := fsProvider.ValidateSignedToken(token, path, SignedURLOperationGet); err != nil {
-				return http_errors.UnauthorizedError("Invalid or expired token")
+				s.recordAccess(c, path, &AccessEvent{
+					Operation: AccessOperationSignedDownload,
+					Status:    http.StatusUnauthorized,
+				})
+				return s.tokenRejected(err)
 			}
 		}
 	}
 
-	return s.handleDirectDownload(c, path)
+	// Headers signed into the token take precedence over the stored ones; the client
+	// cannot change them, whatever the query parameters of the request
+	return s.serveRecorded(c, path, &AccessEvent{
+		Operation: AccessOperationSignedDownload,
+		Subject:   tokenSubject(token),
+	}, tokenResponseOverrides(token).apply)
 }
 
 // handleDirectDownload handles direct file download
 func (s *Storage) handleDirectDownload(c *rest.EndpointContext, path string) error {
-	// Check if file exists
-	exists, err := s.Exists(c.Context(), path)
-	if err != nil {
-		return http_errors.InternalServerError("Failed to check file existence: " + err.Error())
+	return s.serveRecorded(c, path, &AccessEvent{Operation: AccessOperationDownload}, nil)
+}
+
+// serveContent writes a file to the response. Range, If-Range, If-None-Match and
+// If-Modified-Since requests are answered by http.ServeContent.
+func (s *Storage) serveContent(ctx context.Context, response *echo.Response, request *http.Request, path string) error {
+	err := s.writeContent(ctx, response, request, path, nil)
+	abortTruncated(err)
+	if err != nil && !errors.Is(err, errTransferAborted) {
+		return downloadError(err)
 	}
-	if !exists {
+	return nil
+}
+
+// downloadError converts an error from writeContent into an HTTP error
+func downloadError(err error) error {
+	if errors.Is(err, ErrFileNotFound) {
 		return http_errors.NotFoundError("File not found")
 	}
+	return httpError(err, "Failed to download file")
+}
 
-	// Download file
-	reader, fileInfo, err := s.Download(c.Context(), path)
+// writeContent does the work of serveContent, returning storage errors as they are.
+// headers, when set, can adjust the response headers before the content is sent.
+func (s *Storage) writeContent(ctx context.Context, response *echo.Response, request *http.Request, path string, headers func(header http.Header)) error {
+	fileInfo, err := s.GetInfo(ctx, path)
 	if err != nil {
-		return http_errors.InternalServerError("Failed to download file: " + err.Error())
+		return err
+	}
+	if fileInfo.IsDirectory {
+		return NewStorageErrorWithPath(ErrorCodeIsDirectory, "path is a directory", path)
 	}
-	defer reader.Close()
+	if offloadHeader, target := s.offloadTarget(path, fileInfo); offloadHeader != "" {
+		return writeOffload(response, fileInfo, offloadHeader, target, headers)
+	}
+
+	content, err := s.openContent(ctx, path, fileInfo)
+	if err != nil {
+		return err
+	}
+
+	// Encoded content is sent as stored to clients that accept its encoding, and gzip
+	// is decoded on the fly for the others
+	encoding := fileInfo.ContentEncoding
+	decode := encoding == "gzip" && !acceptsEncoding(request.Header.Get("Accept-Encoding"), encoding)
+
+	etag := fileInfo.ETag
+	if decode && etag != "" {
+		etag += identityETagSuffix
+	}
+	if s.config.FaststartRemux && isMP4(fileInfo) && encoding == "" {
+		var remuxed bool
+		if content, remuxed = s.faststartContent(ctx, content, fileInfo); remuxed && etag != "" {
+			// The bytes sent differ from the stored file, so they need their own validator
+			etag += ".faststart"
+		}
+	}
+	defer content.Close()
 
 	// Set headers
-	c.EchoCtx.Response().Header().Set("Content-Type", fileInfo.ContentType)
-	c.EchoCtx.Response().Header().Set("Content-Length", strconv.FormatInt(fileInfo.Size, 10))
-	c.EchoCtx.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileInfo.Name))
+	header := response.Header()
+	header.Set("Content-Type", fileInfo.ContentType)
+	header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileInfo.Name))
 
-	if fileInfo.ETag != "" {
-		c.EchoCtx.Response().Header().Set("ETag", fileInfo.ETag)
+	if etag != "" {
+		header.Set("ETag", quoteETag(etag))
+	}
+	if encoding != "" {
+		header.Add("Vary", "Accept-Encoding")
+		if !decode {
+			// http.ServeContent leaves out the length of encoded content
+			header.Set("Content-Encoding", encoding)
+			header.Set("Content-Length", strconv.FormatInt(fileInfo.Size, 10))
+		}
+		request = withoutRange(request)
+	}
+	if headers != nil {
+		headers(header)
 	}
 
+	var modTime time.Time
 	if fileInfo.LastModified != nil {
-		c.EchoCtx.Response().Header().Set("Last-Modified", fileInfo.LastModified.Format(http.TimeFormat))
+		modTime = *fileInfo.LastModified
 	}
 
 	// Stream file content
-	_, err = io.Copy(c.EchoCtx.Response().Writer, reader)
-	if err != nil {
-		return http_errors.InternalServerError("Failed to stream file: " + err.Error())
+	writer := &contentWriter{
+		Response:         response,
+		bufferSize:       s.config.GetCopyBufferSize(),
+		path:             fileInfo.Path,
+		progress:         s.config.DownloadProgress,
+		progressInterval: s.config.DownloadProgressInterval,
+		checksum:         s.config.DownloadChecksumTrailer && request.Method != http.MethodHead,
+		length:           -1,
+	}
+	if decode {
+		return writeDecoded(writer, request, content, etag, modTime)
+	}
+	http.ServeContent(writer, quoteConditionalETags(request), fileInfo.Name, modTime, content)
+	return writer.finish()
+}
+
+// openContent returns a seekable reader for a file. Local files are served as *os.File so
+// sendfile stays available, providers with range reads are read lazily, and otherwise a
+// seekable download is used as is.
+func (s *Storage) openContent(ctx context.Context, path string, info *FileInfo) (io.ReadSeekCloser, error) {
+	if provider, ok := providerAs[FileProvider](s.provider); ok {
+		file, fileInfo, err := provider.DownloadFile(ctx, s.extensionPath(path))
+		if s.fallsBack(err) {
+			return s.fallback.openContent(ctx, s, path, info)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fileInfo.isExpired(s.config.now()) {
+			file.Close()
+			return nil, FileNotFoundError(path)
+		}
+		return file, nil
+	}
+
+	if _, ok := providerAs[RangeProvider](s.provider); !ok {
+		reader, _, err := s.Download(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if seeker, ok := reader.(io.ReadSeekCloser); ok {
+			return seeker, nil
+		}
+		reader.Close()
+	}
+	return s.openFile(ctx, path, info), nil
+}
+
+// errTransferAborted is returned by writeContent when the body could not be sent in full,
+// typically because the client disconnected. The response is already committed by then.
+var errTransferAborted = errors.New("transfer aborted")
+
+// errContentTruncated is returned with errTransferAborted when the content could not be
+// read in full after the headers were sent, e.g. on a mid-stream provider error
+var errContentTruncated = errors.New("content truncated")
+
+// ChecksumTrailer is the trailer carrying the MD5 of full responses when
+// StorageConfig.DownloadChecksumTrailer is set, as "md5=<hex>"
+const ChecksumTrailer = "X-Checksum"
+
+// contentWriter streams responses through a pooled buffer, handing *os.File sources to
+// the underlying writer so they can be sent with sendfile. It counts the body bytes sent
+// and keeps the first read and write errors, which http.ServeContent does not return.
+type contentWriter struct {
+	*echo.Response
+	bufferSize int
+
+	path             string
+	progress         ProgressFunc // Optional, see StorageConfig.DownloadProgress
+	progressInterval int64
+	counter          *progressCounter
+
+	checksum bool      // Send full responses chunked with ChecksumTrailer
+	hash     hash.Hash // MD5 of the body when the trailer is sent
+
+	length  int64 // Announced body length, -1 when unknown
+	sent    int64 // Body bytes sent
+	err     error // First write error
+	readErr error // First error reading the content
+}
+
+// WriteHeader sends the headers, replacing the Content-Length of full responses with
+// the checksum trailer when it is enabled
+func (w *contentWriter) WriteHeader(code int) {
+	w.length = -1
+	if length, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil {
+		w.length = length
+	}
+	if w.checksum && code == http.StatusOK && w.length >= 0 {
+		w.Header().Del("Content-Length")
+		w.Header().Set("Trailer", ChecksumTrailer)
+		w.hash = md5.New()
+	}
+	w.Response.WriteHeader(code)
+}
+
+// ReadFrom copies src into the response
+func (w *contentWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !w.Committed {
+		if w.Status == 0 {
+			w.Status = http.StatusOK
+		}
+		w.WriteHeader(w.Status)
+	}
+	if w.progress != nil && w.counter == nil {
+		w.counter = newProgressCounter(w.path, w.progress, w.progressInterval, w.length)
+	}
+
+	var n int64
+	var err error
+	if rf, ok := w.Writer.(io.ReaderFrom); ok && isFileSource(src) && w.hash == nil {
+		n, err = w.sendFile(rf, src)
+		w.Size += n
+	} else {
+		// Hide ReadFrom so copyBuffer uses the pool instead of calling back into this method
+		n, err = copyBuffer(contentBodyWriter{w}, contentSource{src, w}, w.bufferSize)
+	}
+	if err != nil && w.err == nil && w.readErr == nil {
+		w.err = err
 	}
+	return n, err
+}
 
+// sendFile hands a file source to rf, in chunks of the progress interval when progress
+// is reported so sendfile is still used for each of them
+func (w *contentWriter) sendFile(rf io.ReaderFrom, src io.Reader) (int64, error) {
+	if w.counter == nil {
+		n, err := rf.ReadFrom(src)
+		w.sent += n
+		return n, err
+	}
+
+	limited, ok := src.(*io.LimitedReader)
+	if !ok {
+		limited = &io.LimitedReader{R: src, N: math.MaxInt64}
+	}
+	var written int64
+	for limited.N > 0 {
+		n, err := rf.ReadFrom(&io.LimitedReader{R: limited.R, N: min(limited.N, w.counter.interval)})
+		limited.N -= n
+		written += n
+		w.sent += n
+		w.counter.add(n)
+		if err != nil || n == 0 {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// finish reports the final progress, sets the checksum trailer and returns
+// errTransferAborted when the body was cut short: by a write error, or with
+// errContentTruncated by a read error or content shorter than announced
+func (w *contentWriter) finish() error {
+	if w.counter != nil {
+		w.counter.finish()
+	}
+	if w.err != nil {
+		return fmt.Errorf("%w: %w", errTransferAborted, w.err)
+	}
+	if w.readErr != nil {
+		return fmt.Errorf("%w: %w: %w", errTransferAborted, errContentTruncated, w.readErr)
+	}
+	if w.sent > 0 && w.length >= 0 && w.sent < w.length {
+		return fmt.Errorf("%w: %w: sent %d of %d bytes", errTransferAborted, errContentTruncated, w.sent, w.length)
+	}
+	if w.hash != nil {
+		w.Header().Set(ChecksumTrailer, "md5="+hex.EncodeToString(w.hash.Sum(nil)))
+	}
 	return nil
 }
 
-// DeleteHandler creates a handler function for file deletion
+// contentBodyWriter writes the body of a contentWriter, counting and hashing what is sent
+type contentBodyWriter struct {
+	w *contentWriter
+}
+
+// Write writes to the response
+func (b contentBodyWriter) Write(p []byte) (int, error) {
+	n, err := b.w.Response.Write(p)
+	b.w.sent += int64(n)
+	if b.w.hash != nil {
+		b.w.hash.Write(p[:n])
+	}
+	if b.w.counter != nil {
+		b.w.counter.add(int64(n))
+	}
+	return n, err
+}
+
+// contentSource reads the content of a contentWriter, keeping the first read error
+type contentSource struct {
+	r io.Reader
+	w *contentWriter
+}
+
+// Read reads from the content
+func (s contentSource) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF && s.w.readErr == nil {
+		s.w.readErr = err
+	}
+	return n, err
+}
+
+// abortTruncated aborts the connection when the content was cut short after the headers
+// were sent, so clients see an error instead of a complete-looking truncated body
+func abortTruncated(err error) {
+	if errors.Is(err, errContentTruncated) {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// isFileSource reports whether src reads directly from an *os.File
+func isFileSource(src io.Reader) bool {
+	if limited, ok := src.(*io.LimitedReader); ok {
+		src = limited.R
+	}
+	_, ok := src.(*os.File)
+	return ok
+}
+
+// DeleteHandler creates a handler function for file deletion. It answers 204 on success,
+// or 200 with a JSON body when LegacyDeleteResponse is set.
 func (s *Storage) DeleteHandler() func(c *rest.EndpointContext) error {
-	return func(c *rest.EndpointContext) error {
-		path := c.EchoCtx.Param("path")
-		if path == "" {
-			path = c.EchoCtx.QueryParam("path")
-		}
+	return s.traced(func(c *rest.EndpointContext) error {
+		path := requestPath(c)
 
 		if path == "" {
 			return http_errors.BadRequestError("File path is required")
 		}
+		if err := s.authorize(c, path, SignedURLOperationDelete); err != nil {
+			return err
+		}
+
+		// Admins can bypass the trash with ?permanent=true and drop the history with
+		// ?purge_versions=true. Shallow directories also need ?force=true.
+		options := DeleteOptions{
+			Permanent:     c.EchoCtx.QueryParam("permanent") == "true",
+			PurgeVersions: c.EchoCtx.QueryParam("purge_versions") == "true",
+			Force:         c.EchoCtx.QueryParam("force") == "true",
+		}
 
 		// Check if it's a directory deletion request
 		if c.EchoCtx.QueryParam("recursive") == "true" {
-			err := s.DeleteDirectory(c.Context(), path)
+			err := s.DeleteDirectory(c.Context(), path, options)
+			var retentionErr *DirectoryRetentionError
+			if errors.As(err, &retentionErr) {
+				// The rest of the tree was deleted; report what was kept
+				return echo.NewHTTPError(http.StatusLocked, map[string]interface{}{
+					"code":    ErrorCodeRetentionLocked,
+					"message": "Some files are under retention and were not deleted",
+					"path":    path,
+					"skipped": retentionErr.Skipped,
+				})
+			}
 			if err != nil {
-				return http_errors.InternalServerError("Failed to delete directory: " + err.Error())
+				return mutationError(err, "Failed to delete directory", path)
 			}
 
-			return c.JSON(map[string]string{
-				"message": "Directory deleted successfully",
-				"path":    path,
-			})
+			return s.deleted(c, "Directory deleted successfully", path)
 		}
 
 		// Regular file deletion
-		err := s.Delete(c.Context(), path)
-		if err != nil {
-			if storageErr, ok := err.(*StorageError); ok && storageErr.Code == ErrorCodeFileNotFound {
-				return http_errors.NotFoundError("File not found")
+		err := s.Delete(c.Context(), path, options)
+		if errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrInvalidPath) {
+			// Providers report directories as missing or invalid files
+			if isDir, _ := s.DirectoryExists(c.Context(), path); isDir {
+				err = NewStorageErrorWithPath(ErrorCodeIsDirectory, "path is a directory, use recursive=true to delete it", path)
 			}
-			return http_errors.InternalServerError("Failed to delete file: " + err.Error())
 		}
+		if err != nil {
+			return mutationError(err, "Failed to delete file", path)
+		}
+
+		return s.deleted(c, "File deleted successfully", path)
+	})
+}
 
+// deleted answers a successful deletion with 204, or with the legacy JSON body
+func (s *Storage) deleted(c *rest.EndpointContext, message, path string) error {
+	if s.config.LegacyDeleteResponse {
 		return c.JSON(map[string]string{
-			"message": "File deleted successfully",
+			"message": message,
 			"path":    path,
 		})
 	}
+	return c.EchoCtx.NoContent(http.StatusNoContent)
+}
+
+// MkdirHandler creates a handler function that creates an empty directory
+func (s *Storage) MkdirHandler() func(c *rest.EndpointContext) error {
+	return s.traced(func(c *rest.EndpointContext) error {
+		path := requestPath(c)
+
+		if path == "" {
+			return http_errors.BadRequestError("Directory path is required")
+		}
+		if err := s.authorize(c, path, SignedURLOperationPut); err != nil {
+			return err
+		}
+
+		if err := s.CreateDirectory(c.Context(), path); err != nil {
+			return mutationError(err, "Failed to create directory", path)
+		}
+
+		return c.JSON(map[string]string{
+			"message": "Directory created successfully",
+			"path":    path,
+		}, http.StatusCreated)
+	})
 }
 
 // ListHandler creates a handler function for listing files in a directory
 func (s *Storage) ListHandler() func(c *rest.EndpointContext) error {
-	return func(c *rest.EndpointContext) error {
-		path := c.EchoCtx.Param("path")
+	return s.traced(func(c *rest.EndpointContext) error {
+		path := requestPath(c)
+
 		if path == "" {
-			path = c.EchoCtx.QueryParam("path")
+			path = "/" // Default to root
+		}
+		if err := s.authorize(c, path, SignedURLOperationGet); err != nil {
+			return err
 		}
+		return s.writeListing(c, path)
+	})
+}
+
+// writeListing answers a ListHandler request for a directory, also used as its index
+// by the download handler
+func (s *Storage) writeListing(c *rest.EndpointContext, path string) error {
+	// ?allow_missing=true lists a directory that was never created as empty and
+	// ?include_hidden=true adds hidden entries for administration. ?etags=true,
+	// ?metadata=true and ?compute_etags=true add details for sync tooling.
+	// ?fallback=true merges the files of the fallback storage.
+	opts := ListOptions{
+		AllowMissing:    c.EchoCtx.QueryParam("allow_missing") == "true",
+		IncludeHidden:   c.EchoCtx.QueryParam("include_hidden") == "true",
+		IncludeETags:    c.EchoCtx.QueryParam("etags") == "true" || c.EchoCtx.QueryParam("compute_etags") == "true",
+		IncludeMetadata: c.EchoCtx.QueryParam("metadata") == "true",
+		ComputeETags:    c.EchoCtx.QueryParam("compute_etags") == "true",
+		IncludeFallback: c.EchoCtx.QueryParam("fallback") == "true",
+	}
+
+	// ?recursive=true, ?page_size=N or ?page_token= list one page at a time, adding
+	// "nextPageToken" to the response until the last page
+	if c.EchoCtx.QueryParam("recursive") == "true" || c.EchoCtx.QueryParam("page_size") != "" || c.EchoCtx.QueryParam("page_token") != "" {
+		return s.writeListPage(c, path, opts)
+	}
+
+	files, err := s.ListWithOptions(c.Context(), path, opts)
+	if err != nil {
+		return httpError(err, "Failed to list files")
+	}
+	s.withPublicURLs(files...)
+
+	return c.JSON(map[string]interface{}{
+		"path":  path,
+		"files": files,
+		"count": len(files),
+	})
+}
+
+// writeListPage answers a ListHandler request for a page of a listing
+func (s *Storage) writeListPage(c *rest.EndpointContext, path string, opts ListOptions) error {
+	pageSize := 0
+	if value := c.EchoCtx.QueryParam("page_size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return http_errors.BadRequestError("page_size must be a positive integer")
+		}
+		pageSize = size
+	}
+
+	page, err := s.ListPage(c.Context(), path, ListPageOptions{
+		ListOptions: opts,
+		Recursive:   c.EchoCtx.QueryParam("recursive") == "true",
+		PageSize:    pageSize,
+		PageToken:   c.EchoCtx.QueryParam("page_token"),
+	})
+	if err != nil {
+		return httpError(err, "Failed to list files")
+	}
+	s.withPublicURLs(page.Files...)
+
+	response := map[string]interface{}{
+		"path":  path,
+		"files": page.Files,
+		"count": len(page.Files),
+	}
+	if page.NextPageToken != "" {
+		response["nextPageToken"] = page.NextPageToken
+	}
+	return c.JSON(response)
+}
+
+// InfoHandler creates a handler function for getting file information
+func (s *Storage) InfoHandler() func(c *rest.EndpointContext) error {
+	return s.traced(func(c *rest.EndpointContext) error {
+		path := requestPath(c)
 
 		if path == "" {
-			path = "/" // Default to root
+			return http_errors.BadRequestError("File path is required")
+		}
+		if err := s.authorize(c, path, SignedURLOperationGet); err != nil {
+			return err
 		}
 
-		files, err := s.List(c.Context(), path)
+		// ?children=true counts the entries of a directory, one level deep
+		fileInfo, err := s.GetInfoWithOptions(c.Context(), path, GetInfoOptions{
+			IncludeChildrenSummary: c.EchoCtx.QueryParam("children") == "true",
+		})
 		if err != nil {
-			if storageErr, ok := err.(*StorageError); ok && storageErr.Code == ErrorCodeDirectoryNotFound {
-				return http_errors.NotFoundError("Directory not found")
+			return httpError(err, "Failed to get file info")
+		}
+		s.withPublicURLs(fileInfo)
+
+		// Uploaded files carry their original name and uploader in "origin"
+		response := struct {
+			*FileInfo
+			Origin *UploadOrigin `json:"origin,omitempty"`
+			MP4    *MP4Info      `json:"mp4,omitempty"`
+		}{FileInfo: fileInfo, Origin: fileInfo.UploadOrigin()}
+
+		// ?probe=true adds the duration, tracks and layout of MP4 videos
+		if c.EchoCtx.QueryParam("probe") == "true" && !fileInfo.IsDirectory && isMP4(fileInfo) {
+			if response.MP4, err = s.ProbeMP4(c.Context(), path); err != nil {
+				return httpError(err, "Failed to probe file")
 			}
-			return http_errors.InternalServerError("Failed to list files: " + err.Error())
+		}
+
+		return c.JSON(response)
+	})
+}
+
+// ReportHandler creates a handler function returning the largest or oldest files under a
+// path, for the admin console. Query parameters: path, n (default 20, at most 1000) and
+// by ("size" or "age", default "size").
+func (s *Storage) ReportHandler() func(c *rest.EndpointContext) error {
+	return s.traced(func(c *rest.EndpointContext) error {
+		path := requestPath(c)
+
+		n := DefaultReportSize
+		if nStr := c.EchoCtx.QueryParam("n"); nStr != "" {
+			var err error
+			if n, err = strconv.Atoi(nStr); err != nil || n < 1 || n > maxReportSize {
+				return http_errors.BadRequestError(fmt.Sprintf("n must be between 1 and %d", maxReportSize))
+			}
+		}
+
+		by := c.EchoCtx.QueryParam("by")
+		if by == "" {
+			by = TopBySize
+		}
+		if err := s.authorize(c, path, SignedURLOperationGet); err != nil {
+			return err
+		}
+
+		files, err := s.TopN(c.Context(), path, n, by)
+		if err != nil {
+			return httpError(err, "Failed to build report")
 		}
 
 		return c.JSON(map[string]interface{}{
 			"path":  path,
+			"by":    by,
 			"files": files,
 			"count": len(files),
 		})
-	}
+	})
 }
 
-// InfoHandler creates a handler function for getting file information
-func (s *Storage) InfoHandler() func(c *rest.EndpointContext) error {
-	return func(c *rest.EndpointContext) error {
-		path := c.EchoCtx.Param("path")
-		if path == "" {
-			path = c.EchoCtx.QueryParam("path")
+// archiveRequest is the JSON body accepted by ArchiveHandler
+type archiveRequest struct {
+	Paths []string `json:"paths"`
+	ArchiveOptions
+}
+
+// ArchiveHandler creates a handler function that streams the files listed in a JSON body
+// such as {"paths": [...], "names": {...}, "format": "zip", "skip_missing": true} as one
+// archive with a manifest
+func (s *Storage) ArchiveHandler() func(c *rest.EndpointContext) error {
+	return s.traced(func(c *rest.EndpointContext) error {
+		var body archiveRequest
+		if err := json.NewDecoder(c.EchoCtx.Request().Body).Decode(&body); err != nil {
+			return http_errors.BadRequestError("Invalid request body")
+		}
+		if len(body.Paths) == 0 || len(body.Paths) > maxArchiveFiles {
+			return http_errors.BadRequestError(fmt.Sprintf("paths must list between 1 and %d files", maxArchiveFiles))
+		}
+		for _, path := range body.Paths {
+			if err := s.authorize(c, path, SignedURLOperationGet); err != nil {
+				return err
+			}
 		}
 
-		if path == "" {
-			return http_errors.BadRequestError("File path is required")
+		format := body.Format
+		if format == "" {
+			format = ArchiveTar
 		}
+		contentType := "application/x-tar"
+		if format == ArchiveZip {
+			contentType = "application/zip"
+		}
+
+		response := c.EchoCtx.Response()
+		response.Header().Set("Content-Type", contentType)
+		response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"export.%s\"", format))
 
-		fileInfo, err := s.GetInfo(c.Context(), path)
+		_, err := s.ArchiveFiles(c.Context(), body.Paths, response, body.ArchiveOptions)
+		if err != nil && !response.Committed {
+			return httpError(err, "Failed to build archive")
+		}
 		if err != nil {
-			if storageErr, ok := err.(*StorageError); ok && storageErr.Code == ErrorCodeFileNotFound {
-				return http_errors.NotFoundError("File not found")
-			}
-			return http_errors.InternalServerError("Failed to get file info: " + err.Error())
+			// The archive is already being sent; aborting the connection tells the client
+			// it is incomplete instead of ending a valid-looking partial archive
+			s.config.log(c.Context(), LogLevelError, "failed to stream archive", map[string]interface{}{
+				"error": err.Error(),
+			})
+			panic(http.ErrAbortHandler)
 		}
-
-		return c.JSON(fileInfo)
-	}
+		return nil
+	})
 }
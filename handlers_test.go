@@ -0,0 +1,230 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// newContentServer serves storage files the way handleDirectDownload does
+func newContentServer(storage *Storage) *httptest.Server {
+	e := echo.New()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := echo.NewResponse(w, e)
+		if err := storage.serveContent(r.Context(), response, r, strings.TrimPrefix(r.URL.Path, "/")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+}
+
+func TestServeContent(t *testing.T) {
+	ctx := context.Background()
+	fsStorage, memStorage := newTransferStorages(t)
+
+	for name, storage := range map[string]*Storage{"filesystem": fsStorage, "memory": memStorage} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := storage.Upload(ctx, "videos/clip.mp4", strings.NewReader("0123456789"), nil); err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+			info, _ := storage.GetInfo(ctx, "videos/clip.mp4")
+
+			server := newContentServer(storage)
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + "/videos/clip.mp4")
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != "0123456789" {
+				t.Errorf("Expected 200 with the full content, got %d '%s'", resp.StatusCode, body)
+			}
+			if resp.Header.Get("Content-Type") != "video/mp4" || resp.Header.Get("Last-Modified") == "" {
+				t.Errorf("Unexpected headers %v", resp.Header)
+			}
+			if info.ETag != "" && resp.Header.Get("ETag") != `"`+info.ETag+`"` {
+				t.Errorf("Expected quoted ETag, got '%s'", resp.Header.Get("ETag"))
+			}
+			lastModified := resp.Header.Get("Last-Modified")
+
+			req, _ := http.NewRequest(http.MethodGet, server.URL+"/videos/clip.mp4", nil)
+			req.Header.Set("Range", "bytes=2-4")
+			resp, err = http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			body, _ = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusPartialContent || string(body) != "234" {
+				t.Errorf("Expected 206 with '234', got %d '%s'", resp.StatusCode, body)
+			}
+
+			req, _ = http.NewRequest(http.MethodGet, server.URL+"/videos/clip.mp4", nil)
+			req.Header.Set("If-Modified-Since", lastModified)
+			resp, err = http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNotModified {
+				t.Errorf("Expected 304, got %d", resp.StatusCode)
+			}
+
+			if info.ETag != "" {
+				// Clients sending the bare value still get a match
+				req, _ = http.NewRequest(http.MethodGet, server.URL+"/videos/clip.mp4", nil)
+				req.Header.Set("If-None-Match", info.ETag)
+				resp, err = http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("Request failed: %v", err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusNotModified {
+					t.Errorf("Expected 304 for an unquoted If-None-Match, got %d", resp.StatusCode)
+				}
+			}
+		})
+	}
+}
+
+// Compares serving a large local file through serveContent (sendfile) against the
+// previous userspace copy:
+//
+//	go test -run '^$' -bench DirectDownload
+func BenchmarkDirectDownload(b *testing.B) {
+	const size = 64 << 20
+	ctx := context.Background()
+
+	storage, err := New(&StorageConfig{
+		Name:       "BenchmarkStorage",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: b.TempDir()},
+	})
+	if err != nil {
+		b.Fatalf("Failed to create storage: %v", err)
+	}
+	if _, err := storage.Upload(ctx, "video.mp4", bytes.NewReader(make([]byte, size)), nil); err != nil {
+		b.Fatalf("Upload failed: %v", err)
+	}
+
+	streaming := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reader, _, err := storage.Download(r.Context(), "video.mp4")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer reader.Close()
+		io.Copy(w, struct{ io.Reader }{reader})
+	}))
+	defer streaming.Close()
+
+	sendfile := newContentServer(storage)
+	defer sendfile.Close()
+
+	for name, server := range map[string]*httptest.Server{"streaming": streaming, "sendfile": sendfile} {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				resp, err := http.Get(server.URL + "/video.mp4")
+				if err != nil {
+					b.Fatalf("Request failed: %v", err)
+				}
+				n, _ := io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if n != size {
+					b.Fatalf("Expected %d bytes, got %d", size, n)
+				}
+			}
+		})
+	}
+}
+
+// serveDelete runs DeleteHandler and returns the response status and the error code of
+// the body, if any
+func serveDelete(storage *Storage, query string) (int, ErrorCode) {
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodDelete, "/files?"+query, nil)
+	recorder := httptest.NewRecorder()
+	err := storage.DeleteHandler()(&rest.EndpointContext{EchoCtx: e.NewContext(request, recorder)})
+	if err == nil {
+		return recorder.Code, ""
+	}
+
+	var httpErr *echo.HTTPError
+	if !errors.As(err, &httpErr) {
+		return -1, ""
+	}
+	body, _ := httpErr.Message.(map[string]interface{})
+	code, _ := body["code"].(ErrorCode)
+	return httpErr.Code, code
+}
+
+func TestDeleteHandler(t *testing.T) {
+	ctx := context.Background()
+	fsStorage, memStorage := newTransferStorages(t)
+
+	for name, storage := range map[string]*Storage{"filesystem": fsStorage, "memory": memStorage} {
+		t.Run(name, func(t *testing.T) {
+			storage.Upload(ctx, "a.txt", strings.NewReader("a"), nil)
+			storage.Upload(ctx, "cameras/1/clip.mp4", strings.NewReader("clip"), nil)
+			storage.CreateDirectory(ctx, "empty")
+
+			tests := []struct {
+				name   string
+				query  string
+				status int
+				code   ErrorCode
+			}{
+				{"File", "path=a.txt", http.StatusNoContent, ""},
+				{"Missing file", "path=a.txt", http.StatusNotFound, ErrorCodeFileNotFound},
+				{"Directory", "path=cameras", http.StatusConflict, ErrorCodeIsDirectory},
+				{"Empty directory", "path=empty", http.StatusConflict, ErrorCodeIsDirectory},
+				{"Missing directory", "path=missing&recursive=true", http.StatusNotFound, ErrorCodeDirectoryNotFound},
+				{"Recursive", "path=cameras&recursive=true", http.StatusNoContent, ""},
+			}
+			for _, tt := range tests {
+				if status, code := serveDelete(storage, tt.query); status != tt.status || code != tt.code {
+					t.Errorf("%s: expected %d %q, got %d %q", tt.name, tt.status, tt.code, status, code)
+				}
+			}
+			if exists, _ := storage.DirectoryExists(ctx, "empty"); !exists {
+				t.Error("Expected the directory deleted without recursive=true to remain")
+			}
+		})
+	}
+
+	t.Run("Retention", func(t *testing.T) {
+		memStorage.Upload(ctx, "evidence.mp4", strings.NewReader("clip"), nil)
+		if err := memStorage.SetRetention(ctx, "evidence.mp4", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("SetRetention failed: %v", err)
+		}
+		if status, code := serveDelete(memStorage, "path=evidence.mp4&permanent=true"); status != http.StatusForbidden || code != ErrorCodeRetentionLocked {
+			t.Errorf("Expected 403 RETENTION_LOCKED, got %d %q", status, code)
+		}
+	})
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		storage, _ := New(&StorageConfig{Name: "test", Provider: "memory", ReadOnly: true})
+		if status, code := serveDelete(storage, "path=a.txt"); status != http.StatusForbidden || code != ErrorCodeReadOnly {
+			t.Errorf("Expected 403 READ_ONLY, got %d %q", status, code)
+		}
+	})
+
+	t.Run("LegacyResponse", func(t *testing.T) {
+		storage, _ := New(&StorageConfig{Name: "test", Provider: "memory", LegacyDeleteResponse: true})
+		storage.Upload(ctx, "a.txt", strings.NewReader("a"), nil)
+		if status, _ := serveDelete(storage, "path=a.txt"); status != http.StatusOK {
+			t.Errorf("Expected 200, got %d", status)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package vsaasstorage
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// hiddenEntries holds the names and prefixes List and Walk skip unless
+// ListOptions.IncludeHidden is set. Metadata sidecars are not listed by the
+// filesystem provider at all.
+var hiddenEntries = struct {
+	sync.RWMutex
+	names    map[string]bool
+	prefixes []string
+}{
+	names: map[string]bool{
+		// Internal directories of trash, versioning, scanning, deduplication and reservations
+		trashPrefix:      true,
+		versionsPrefix:   true,
+		quarantinePrefix: true,
+		blobsDir:         true,
+		claimsPrefix:     true,
+
+		// Files created by desktop clients
+		".DS_Store":   true,
+		"Thumbs.db":   true,
+		"desktop.ini": true,
+	},
+	prefixes: []string{"._", ".tmp-"},
+}
+
+// RegisterHiddenName hides entries with the given name from List and Walk by default
+func RegisterHiddenName(name string) {
+	hiddenEntries.Lock()
+	defer hiddenEntries.Unlock()
+	hiddenEntries.names[name] = true
+}
+
+// RegisterHiddenPrefix hides entries whose name starts with prefix from List and Walk by default
+func RegisterHiddenPrefix(prefix string) {
+	hiddenEntries.Lock()
+	defer hiddenEntries.Unlock()
+	for _, registered := range hiddenEntries.prefixes {
+		if registered == prefix {
+			return
+		}
+	}
+	hiddenEntries.prefixes = append(hiddenEntries.prefixes, prefix)
+}
+
+// IsHidden reports whether an entry name is hidden from List and Walk by default
+func IsHidden(name string) bool {
+	hiddenEntries.RLock()
+	defer hiddenEntries.RUnlock()
+	if hiddenEntries.names[name] {
+		return true
+	}
+	for _, prefix := range hiddenEntries.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// allEntries lists hidden entries too. Operations that move, delete or account for every
+// file of a directory walk with it, so hidden files are neither left behind nor lost.
+var allEntries = ListOptions{IncludeHidden: true}
+
+// DirectoryUsage summarizes the contents of a directory
+type DirectoryUsage struct {
+	Path        string `json:"path"`
+	Files       int64  `json:"files"`
+	Directories int64  `json:"directories"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// Usage walks root and counts its files, directories and bytes. Hidden entries are
+// counted only with IncludeHidden, so reports can include or exclude internal data deliberately.
+func (s *Storage) Usage(ctx context.Context, root string, opts ListOptions) (*DirectoryUsage, error) {
+	usage := &DirectoryUsage{Path: root}
+	err := s.walk(ctx, root, opts, func(info *FileInfo) error {
+		if info.IsDirectory {
+			usage.Directories++
+		} else {
+			usage.Files++
+			usage.Bytes += info.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
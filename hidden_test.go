@@ -0,0 +1,104 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestHiddenEntries(t *testing.T) {
+	ctx := context.Background()
+	storage, _ := New(&StorageConfig{Name: "test", Provider: "memory"})
+
+	for _, name := range []string{"photos/a.jpg", "photos/.DS_Store", "photos/._a.jpg", "photos/Thumbs.db", "photos/.tmp-123"} {
+		if _, err := storage.Upload(ctx, name, strings.NewReader("data"), nil); err != nil {
+			t.Fatalf("Upload of %s failed: %v", name, err)
+		}
+	}
+
+	t.Run("List", func(t *testing.T) {
+		files, err := storage.List(ctx, "photos")
+		if err != nil || len(files) != 1 || files[0].Name != "a.jpg" {
+			t.Errorf("Expected only a.jpg, got %v, %v", files, err)
+		}
+		files, err = storage.ListWithOptions(ctx, "photos", ListOptions{IncludeHidden: true})
+		if err != nil || len(files) != 5 {
+			t.Errorf("Expected 5 entries with IncludeHidden, got %v, %v", files, err)
+		}
+	})
+
+	t.Run("Walk", func(t *testing.T) {
+		var visited, all int
+		storage.Walk(ctx, "", func(info *FileInfo) error {
+			visited++
+			return nil
+		})
+		storage.WalkWithOptions(ctx, "", ListOptions{IncludeHidden: true}, func(info *FileInfo) error {
+			all++
+			return nil
+		})
+		if visited != 2 || all != 6 {
+			t.Errorf("Expected 2 and 6 visited entries, got %d and %d", visited, all)
+		}
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		usage, err := storage.Usage(ctx, "photos", ListOptions{})
+		if err != nil || usage.Files != 1 || usage.Bytes != 4 {
+			t.Errorf("Unexpected usage %+v, %v", usage, err)
+		}
+		usage, err = storage.Usage(ctx, "photos", ListOptions{IncludeHidden: true})
+		if err != nil || usage.Files != 5 || usage.Bytes != 20 {
+			t.Errorf("Unexpected usage with hidden entries %+v, %v", usage, err)
+		}
+	})
+
+	t.Run("Register", func(t *testing.T) {
+		RegisterHiddenName("@eaDir")
+		t.Cleanup(func() {
+			hiddenEntries.Lock()
+			delete(hiddenEntries.names, "@eaDir")
+			hiddenEntries.Unlock()
+		})
+		if _, err := storage.Upload(ctx, "photos/@eaDir/a.jpg", strings.NewReader("thumb"), nil); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		if files, _ := storage.List(ctx, "photos"); len(files) != 1 {
+			t.Errorf("Expected the registered name to be hidden, got %v", files)
+		}
+	})
+
+	t.Run("MoveKeepsHidden", func(t *testing.T) {
+		if err := storage.Move(ctx, "photos", "archive/photos"); err != nil {
+			t.Fatalf("Move failed: %v", err)
+		}
+		if exists, _ := storage.Exists(ctx, "archive/photos/.DS_Store"); !exists {
+			t.Error("Expected hidden files to be moved with the directory")
+		}
+		if exists, _ := storage.DirectoryExists(ctx, "photos"); exists {
+			t.Error("Expected the source directory to be removed")
+		}
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/files?path=archive/photos&include_hidden=true", nil)
+		recorder := httptest.NewRecorder()
+		if err := storage.ListHandler()(&rest.EndpointContext{EchoCtx: e.NewContext(request, recorder)}); err != nil {
+			t.Fatalf("Handler failed: %v", err)
+		}
+		var body struct {
+			Count int `json:"count"`
+		}
+		json.Unmarshal(recorder.Body.Bytes(), &body)
+		if body.Count != 6 {
+			t.Errorf("Expected 6 entries, got %s", recorder.Body.String())
+		}
+	})
+}
@@ -0,0 +1,227 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	rest "github.com/xompass/vsaas-rest"
+	"github.com/xompass/vsaas-rest/http_errors"
+)
+
+// maxPlaylistSize bounds the playlists read into memory to be rewritten
+const maxPlaylistSize = 4 << 20
+
+// hlsContentTypes maps HLS file extensions to the types players expect
+var hlsContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+	".aac":  "audio/aac",
+	".vtt":  "text/vtt",
+}
+
+// playlistURIAttribute matches the URI attribute of tags such as EXT-X-MEDIA, EXT-X-MAP and EXT-X-KEY
+var playlistURIAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// HLSHandler creates a handler function that serves HLS playlists and segments. Playlists
+// are served inline with no-cache headers and segments with long cache headers. When a
+// request carries a valid signed token, relative URIs in the playlist get a token for the
+// path they reference, so the player can fetch segments and variant playlists.
+func (s *Storage) HLSHandler() func(c *rest.EndpointContext) error {
+	return s.traced(func(c *rest.EndpointContext) error {
+		filePath := requestPath(c)
+
+		if filePath == "" {
+			return http_errors.BadRequestError("File path is required")
+		}
+
+		event := &AccessEvent{Operation: AccessOperationDownload}
+		token := c.EchoCtx.QueryParam("token")
+		fsProvider, signed := providerAs[*FileSystemProvider](s.provider)
+		signed = signed && token != ""
+		if !signed || !s.config.SignedTokensSkipAuthorizer {
+			if err := s.authorize(c, filePath, SignedURLOperationGet); err != nil {
+				return err
+			}
+		}
+		if signed {
+			event.Operation = AccessOperationSignedDownload
+			if err := fsProvider.ValidateSignedToken(token, filePath, SignedURLOperationGet); err != nil {
+				event.Status = http.StatusUnauthorized
+				s.recordAccess(c, filePath, event)
+				return s.tokenRejected(err)
+			}
+			event.Subject = tokenSubject(token)
+		}
+
+		if strings.EqualFold(path.Ext(filePath), ".m3u8") {
+			var sign func(string) (string, error)
+			if signed {
+				sign = playlistSigner(fsProvider, filePath, token, s.config.GetSignedURLConfig().ExpiresIn)
+			}
+			return s.servePlaylist(c, filePath, sign, event)
+		}
+
+		return s.serveRecorded(c, filePath, event, func(header http.Header) {
+			if contentType, ok := hlsContentTypes[strings.ToLower(path.Ext(filePath))]; ok {
+				header.Set("Content-Type", contentType)
+			}
+			header.Set("Content-Disposition", "inline")
+			// Segments never change once written
+			header.Set("Cache-Control", "public, max-age=31536000, immutable")
+		})
+	})
+}
+
+// servePlaylist writes a playlist, adding the tokens of sign to its relative URIs when set
+func (s *Storage) servePlaylist(c *rest.EndpointContext, filePath string, sign func(string) (string, error), event *AccessEvent) error {
+	data, err := s.readPlaylist(c.Context(), filePath)
+	if err != nil {
+		event.Status = responseStatus(c.EchoCtx.Response(), err)
+		s.recordAccess(c, filePath, event)
+		return downloadError(err)
+	}
+
+	if sign != nil {
+		if data, err = rewritePlaylist(data, path.Dir(cleanPath(filePath)), sign); err != nil {
+			return httpError(err, "Failed to sign playlist")
+		}
+	}
+
+	header := c.EchoCtx.Response().Header()
+	header.Set("Content-Disposition", "inline")
+	// Live playlists change with every new segment
+	header.Set("Cache-Control", "no-cache")
+
+	err = c.EchoCtx.Blob(http.StatusOK, hlsContentTypes[".m3u8"], data)
+	event.Bytes = int64(len(data))
+	event.Status = http.StatusOK
+	s.recordAccess(c, filePath, event)
+	return err
+}
+
+// readPlaylist downloads a playlist, refusing files too large to be one
+func (s *Storage) readPlaylist(ctx context.Context, filePath string) ([]byte, error) {
+	reader, _, err := s.Download(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxPlaylistSize+1))
+	if err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to read playlist", err)
+	}
+	if len(data) > maxPlaylistSize {
+		return nil, NewStorageError(ErrorCodeInvalidPath, "playlist too large: "+filePath)
+	}
+	return data, nil
+}
+
+// playlistSigner returns the function that signs the URIs of a playlist. A prefix token
+// that already covers the playlist's directory is reused as is; otherwise each referenced
+// path gets a token of its own, with the same expiry, subject and signing key as the token
+// the playlist was requested with. A playlist token then opens the segments and variant
+// playlists the playlist lists, and no other file next to them.
+func playlistSigner(provider *FileSystemProvider, filePath, token string, defaultExpiry time.Duration) func(string) (string, error) {
+	dir := path.Dir(cleanPath(filePath))
+	if dir == "." {
+		dir = ""
+	}
+
+	claims := tokenClaims(token)
+	if prefix, ok := claims["prefix"].(string); ok && prefix != "" && isWithinPrefix(dir, prefix) {
+		return func(string) (string, error) { return token, nil }
+	}
+
+	expires := provider.config.now().Add(defaultExpiry).Unix()
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		expires = exp.Unix()
+	}
+	subject, _ := claims.GetSubject()
+	keyName := tokenKeyName(token)
+
+	return func(p string) (string, error) {
+		segmentClaims := jwt.MapClaims{
+			"path": provider.config.tokenPath(p),
+			"op":   string(SignedURLOperationGet),
+			"exp":  expires,
+			"iat":  provider.config.now().Unix(),
+		}
+		if subject != "" {
+			segmentClaims["sub"] = subject
+		}
+		return provider.signToken(segmentClaims, keyName)
+	}
+}
+
+// rewritePlaylist adds the token sign returns for the referenced path to every relative
+// URI of a playlist in dir, both on URI lines and in URI attributes. URIs that leave dir
+// are kept unchanged.
+func rewritePlaylist(data []byte, dir string, sign func(string) (string, error)) ([]byte, error) {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	var out bytes.Buffer
+	out.Grow(len(data))
+	var signErr error
+	tokenize := func(uri string) string {
+		tokenized, err := tokenizeURI(uri, dir, sign)
+		if err != nil && signErr == nil {
+			signErr = err
+		}
+		return tokenized
+	}
+
+	for _, line := range lines {
+		content := bytes.TrimRight(line, "\r\n")
+		ending := line[len(content):]
+
+		switch {
+		case len(bytes.TrimSpace(content)) == 0:
+			out.Write(line)
+		case bytes.HasPrefix(content, []byte("#")):
+			out.Write(playlistURIAttribute.ReplaceAllFunc(content, func(match []byte) []byte {
+				uri := string(match[len(`URI="`) : len(match)-1])
+				return []byte(`URI="` + tokenize(uri) + `"`)
+			}))
+			out.Write(ending)
+		default:
+			out.WriteString(tokenize(strings.TrimSpace(string(content))))
+			out.Write(ending)
+		}
+	}
+
+	if signErr != nil {
+		return nil, signErr
+	}
+	return out.Bytes(), nil
+}
+
+// tokenizeURI adds the token of the referenced path to a relative URI inside dir
+func tokenizeURI(uri, dir string, sign func(string) (string, error)) (string, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.IsAbs() || parsed.Host != "" || strings.HasPrefix(parsed.Path, "/") {
+		return uri, nil
+	}
+	referenced := path.Join("/", dir, parsed.Path)
+	if !isWithinPrefix(referenced, dir) {
+		return uri, nil
+	}
+	token, err := sign(cleanPath(referenced))
+	if err != nil {
+		return uri, err
+	}
+
+	query := parsed.Query()
+	query.Set("token", token)
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
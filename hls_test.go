@@ -0,0 +1,178 @@
+package vsaasstorage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestRewritePlaylist(t *testing.T) {
+	playlist := "#EXTM3U\r\n" +
+		"#EXT-X-MAP:URI=\"init.mp4\"\r\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud\",URI=\"audio/index.m3u8\"\r\n" +
+		"#EXTINF:4.0,\r\n" +
+		"seg-1.m4s\r\n" +
+		"\r\n" +
+		"#EXTINF:4.0,\r\n" +
+		"seg-2.m4s?v=1\r\n" +
+		"https://cdn.example.com/seg-3.m4s\r\n" +
+		"/absolute/seg-4.m4s\r\n" +
+		"../other/seg-5.m4s\r\n"
+
+	want := "#EXTM3U\r\n" +
+		"#EXT-X-MAP:URI=\"init.mp4?token=tok\"\r\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud\",URI=\"audio/index.m3u8?token=tok\"\r\n" +
+		"#EXTINF:4.0,\r\n" +
+		"seg-1.m4s?token=tok\r\n" +
+		"\r\n" +
+		"#EXTINF:4.0,\r\n" +
+		"seg-2.m4s?token=tok&v=1\r\n" +
+		"https://cdn.example.com/seg-3.m4s\r\n" +
+		"/absolute/seg-4.m4s\r\n" +
+		"../other/seg-5.m4s\r\n"
+
+	var signed []string
+	got, err := rewritePlaylist([]byte(playlist), "streams/cam1", func(p string) (string, error) {
+		signed = append(signed, p)
+		return "tok", nil
+	})
+	if err != nil || string(got) != want {
+		t.Errorf("unexpected playlist:\n%s", got)
+	}
+	if strings.Join(signed, ",") != "streams/cam1/init.mp4,streams/cam1/audio/index.m3u8,streams/cam1/seg-1.m4s,streams/cam1/seg-2.m4s" {
+		t.Errorf("expected a token per referenced path, got %v", signed)
+	}
+}
+
+func TestHLSHandler(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{
+		Name:       "test",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+		SignedURL:  &SignedURLConfig{Enabled: true, SecretKey: "secret", ExpiresIn: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.Upload(ctx, "streams/cam1/master.m3u8", strings.NewReader("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\n720p/index.m3u8\n"), nil)
+	storage.Upload(ctx, "streams/cam1/720p/index.m3u8", strings.NewReader("#EXTM3U\n#EXTINF:4.0,\nseg-1.ts\n"), nil)
+	storage.Upload(ctx, "streams/cam1/720p/seg-1.ts", strings.NewReader("segment"), nil)
+	storage.Upload(ctx, "streams/cam2/index.m3u8", strings.NewReader("#EXTM3U\n"), nil)
+
+	e := echo.New()
+	get := func(target string) (*httptest.ResponseRecorder, error) {
+		parsed, _ := url.Parse(target)
+		request := httptest.NewRequest(http.MethodGet, "/"+target, nil)
+		recorder := httptest.NewRecorder()
+		c := e.NewContext(request, recorder)
+		c.SetParamNames("path")
+		c.SetParamValues(parsed.Path)
+		return recorder, storage.HLSHandler()(&rest.EndpointContext{EchoCtx: c})
+	}
+
+	// Unsigned requests are served with the HLS headers and no rewriting
+	recorder, err := get("streams/cam1/master.m3u8")
+	if err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+	if recorder.Header().Get("Content-Type") != "application/vnd.apple.mpegurl" ||
+		recorder.Header().Get("Cache-Control") != "no-cache" || strings.Contains(recorder.Body.String(), "token=") {
+		t.Errorf("unexpected playlist response %v %q", recorder.Header(), recorder.Body.String())
+	}
+
+	recorder, err = get("streams/cam1/720p/seg-1.ts")
+	if err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+	if recorder.Header().Get("Content-Type") != "video/mp2t" || !strings.Contains(recorder.Header().Get("Cache-Control"), "max-age=31536000") ||
+		recorder.Body.String() != "segment" {
+		t.Errorf("unexpected segment response %v", recorder.Header())
+	}
+
+	// A signed master playlist propagates a token per referenced path down to the segments
+	token, _ := storage.GenerateSignedURL(ctx, "streams/cam1/master.m3u8", SignedURLOperationGet, time.Minute)
+	recorder, err = get("streams/cam1/master.m3u8?token=" + token)
+	if err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+	variant := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")[2]
+	if !strings.HasPrefix(variant, "720p/index.m3u8?token=") {
+		t.Fatalf("expected a tokenized variant URI, got %q", variant)
+	}
+
+	recorder, err = get("streams/cam1/" + variant)
+	if err != nil {
+		t.Fatalf("variant playlist refused: %v", err)
+	}
+	segment := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")[2]
+	if !strings.HasPrefix(segment, "seg-1.ts?token=") || segment == "seg-1.ts?"+strings.TrimPrefix(variant, "720p/index.m3u8?") {
+		t.Errorf("expected the segment to get a token of its own, got %q", segment)
+	}
+	if _, err := get("streams/cam1/720p/seg-1.ts?" + strings.TrimPrefix(variant, "720p/index.m3u8?")); err == nil {
+		t.Error("expected the token of the variant playlist to be refused for its segment")
+	}
+
+	recorder, err = get("streams/cam1/720p/" + segment)
+	if err != nil || recorder.Body.String() != "segment" {
+		t.Errorf("segment refused: %v", err)
+	}
+
+	// The propagated token does not open other streams
+	if _, err := get("streams/cam2/index.m3u8?" + strings.TrimPrefix(segment, "seg-1.ts?")); err == nil {
+		t.Error("expected the token to be refused outside its directory")
+	}
+}
+
+func TestHLSHandlerRootPlaylist(t *testing.T) {
+	ctx := context.Background()
+	storage, _ := New(&StorageConfig{
+		Name:       "test",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+		SignedURL:  &SignedURLConfig{Enabled: true, SecretKey: "secret", ExpiresIn: time.Hour},
+	})
+	storage.Upload(ctx, "index.m3u8", strings.NewReader("#EXTM3U\n#EXTINF:4.0,\nseg-1.ts\n"), nil)
+	storage.Upload(ctx, "seg-1.ts", strings.NewReader("segment"), nil)
+	storage.Upload(ctx, "secret.txt", strings.NewReader("secret"), nil)
+	provider, _ := providerAs[*FileSystemProvider](storage.provider)
+
+	request := httptest.NewRequest(http.MethodGet, "/index.m3u8", nil)
+	recorder := httptest.NewRecorder()
+	c := echo.New().NewContext(request, recorder)
+	c.SetParamNames("path")
+	c.SetParamValues("index.m3u8")
+	token, _ := storage.GenerateSignedURL(ctx, "index.m3u8", SignedURLOperationGet, time.Minute)
+	c.QueryParams().Set("token", token)
+	if err := storage.HLSHandler()(&rest.EndpointContext{EchoCtx: c}); err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+
+	segment, _ := url.Parse(strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")[2])
+	segmentToken := segment.Query().Get("token")
+	if err := provider.ValidateSignedToken(segmentToken, "seg-1.ts", SignedURLOperationGet); err != nil {
+		t.Errorf("expected the segment token to be valid: %v", err)
+	}
+	for _, token := range []string{token, segmentToken} {
+		if err := provider.ValidateSignedToken(token, "secret.txt", SignedURLOperationGet); err == nil {
+			t.Error("expected the playlist tokens to be refused for a sibling file")
+		}
+	}
+
+	// No token can claim the root as its prefix
+	if _, err := provider.GeneratePrefixToken("", SignedURLOperationGet, time.Minute); err == nil {
+		t.Error("expected a root prefix token to be refused")
+	}
+	forged, _ := provider.signToken(jwt.MapClaims{"prefix": "", "op": "GET", "exp": time.Now().Add(time.Minute).Unix()}, "")
+	if err := provider.ValidateSignedToken(forged, "secret.txt", SignedURLOperationGet); err == nil {
+		t.Error("expected a root prefix token to be rejected")
+	}
+}
@@ -0,0 +1,56 @@
+package vsaasstorage
+
+import "context"
+
+// LogLevel represents the severity of a log entry
+type LogLevel string
+
+const (
+	LogLevelDebug LogLevel = "debug"
+	LogLevelInfo  LogLevel = "info"
+	LogLevelWarn  LogLevel = "warn"
+	LogLevelError LogLevel = "error"
+)
+
+// Logger receives log entries emitted by the storage package
+type Logger interface {
+	Log(ctx context.Context, level LogLevel, message string, fields map[string]interface{})
+}
+
+// Metrics receives counters and gauges emitted by the storage package
+type Metrics interface {
+	IncCounter(name string, value int64, labels map[string]string)
+	SetGauge(name string, value float64, labels map[string]string)
+}
+
+// log sends an entry to the configured logger, if any, with the request ID of ctx
+func (c *StorageConfig) log(ctx context.Context, level LogLevel, message string, fields map[string]interface{}) {
+	if c == nil || c.Logger == nil {
+		return
+	}
+	if requestID := RequestIDFrom(ctx); requestID != "" {
+		withID := make(map[string]interface{}, len(fields)+1)
+		for key, value := range fields {
+			withID[key] = value
+		}
+		withID["request_id"] = requestID
+		fields = withID
+	}
+	c.Logger.Log(ctx, level, message, fields)
+}
+
+// incCounter increments a counter on the configured metrics sink, if any
+func (c *StorageConfig) incCounter(name string, value int64, labels map[string]string) {
+	if c == nil || c.Metrics == nil {
+		return
+	}
+	c.Metrics.IncCounter(name, value, labels)
+}
+
+// setGauge sets a gauge on the configured metrics sink, if any
+func (c *StorageConfig) setGauge(name string, value float64, labels map[string]string) {
+	if c == nil || c.Metrics == nil {
+		return
+	}
+	c.Metrics.SetGauge(name, value, labels)
+}
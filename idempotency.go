@@ -0,0 +1,369 @@
+package vsaasstorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the request header read by UploadHandler
+const IdempotencyKeyHeader = "X-Idempotency-Key"
+
+// Defaults for IdempotencyConfig
+const (
+	DefaultIdempotencyTTL         = 24 * time.Hour
+	DefaultIdempotencyLockTimeout = 5 * time.Minute
+)
+
+// maxIdempotencyWait bounds the delay between two checks of a key held by another upload
+const maxIdempotencyWait = time.Second
+
+// IdempotencyStore records the result of uploads by idempotency key.
+// Implementations must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Reserve claims a key for an upload in progress. It returns reserved true when the
+	// caller now holds the key, or the recorded result when an upload with the key already
+	// completed. A nil result with reserved false means another upload holds the key.
+	// Reservations older than lockTimeout are considered abandoned and can be claimed again.
+	Reserve(key string, lockTimeout time.Duration) (result []byte, reserved bool, err error)
+	// Complete records the result of the upload holding the key, kept for ttl
+	Complete(key string, result []byte, ttl time.Duration) error
+	// Release gives up a reservation after a failed upload so the key can be retried
+	Release(key string) error
+}
+
+// IdempotencyConfig contains configuration for upload idempotency keys
+type IdempotencyConfig struct {
+	TTL         time.Duration `json:"ttl,omitempty"`         // How long results are replayed, defaults to 24 hours
+	LockTimeout time.Duration `json:"lockTimeout,omitempty"` // Time after which an unfinished upload stops holding its key, defaults to 5 minutes
+	StateDir    string        `json:"stateDir,omitempty"`    // Directory for one file per key; in-memory if empty
+
+	// Store replaces the default in-memory or filesystem store
+	Store IdempotencyStore `json:"-"`
+}
+
+// ttl returns the configured TTL or the default
+func (c *IdempotencyConfig) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return DefaultIdempotencyTTL
+}
+
+// lockTimeout returns the configured lock timeout or the default
+func (c *IdempotencyConfig) lockTimeout() time.Duration {
+	if c.LockTimeout > 0 {
+		return c.LockTimeout
+	}
+	return DefaultIdempotencyLockTimeout
+}
+
+// newIdempotencyStore creates the store described by the configuration
+func newIdempotencyStore(config *IdempotencyConfig, clock Clock) (IdempotencyStore, error) {
+	if config.Store != nil {
+		return config.Store, nil
+	}
+	if config.StateDir != "" {
+		store, err := NewFileIdempotencyStore(config.StateDir)
+		if err != nil {
+			return nil, err
+		}
+		store.Clock = clock
+		return store, nil
+	}
+	store := NewMemoryIdempotencyStore()
+	store.Clock = clock
+	return store, nil
+}
+
+// idempotent runs upload once per key. Later calls with the same key, including concurrent
+// ones, get the recorded result instead of running upload again. Without a key or an
+// idempotency configuration, upload simply runs.
+func idempotent[T any](ctx context.Context, s *Storage, key string, upload func() (T, error)) (T, error) {
+	var zero T
+	if key == "" || s.idempotency == nil {
+		return upload()
+	}
+
+	wait := 10 * time.Millisecond
+	for {
+		recorded, reserved, err := s.idempotency.Reserve(key, s.config.Idempotency.lockTimeout())
+		if err != nil {
+			return zero, NewStorageErrorWithCause(ErrorCodeInternalError, "failed to reserve idempotency key", err)
+		}
+
+		if recorded != nil {
+			var result T
+			if err := json.Unmarshal(recorded, &result); err != nil {
+				return zero, NewStorageErrorWithCause(ErrorCodeInternalError, "failed to decode idempotent result", err)
+			}
+			return result, nil
+		}
+
+		if reserved {
+			break
+		}
+
+		// Another upload holds the key; wait for its result, or for the key to be released
+		select {
+		case <-ctx.Done():
+			return zero, CanceledError("", ctx.Err())
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, maxIdempotencyWait)
+	}
+
+	result, err := upload()
+	if err != nil {
+		if releaseErr := s.idempotency.Release(key); releaseErr != nil {
+			s.config.log(ctx, LogLevelWarn, "failed to release idempotency key", map[string]interface{}{
+				"error": releaseErr.Error(),
+			})
+		}
+		return result, err // Partial results of multi-file uploads
+	}
+
+	data, err := json.Marshal(result)
+	if err == nil {
+		err = s.idempotency.Complete(key, data, s.config.Idempotency.ttl())
+	}
+	if err != nil {
+		// The upload succeeded, so report it; a retry will store the content again
+		s.config.log(ctx, LogLevelWarn, "failed to record idempotent result", map[string]interface{}{
+			"error": err.Error(),
+		})
+		s.idempotency.Release(key)
+	}
+
+	return result, nil
+}
+
+// idempotencyScope ties a client key to the destination of the upload, so the same key
+// sent to different endpoints does not replay unrelated results
+func idempotencyScope(kind, destinationDir, key string) string {
+	if key == "" {
+		return ""
+	}
+	return kind + ":" + cleanPath(destinationDir) + ":" + key
+}
+
+// idempotencyRecord is the state of a key
+type idempotencyRecord struct {
+	Result  json.RawMessage `json:"result,omitempty"` // Empty while the upload is in progress
+	Expires time.Time       `json:"expires"`
+}
+
+// MemoryIdempotencyStore keeps idempotency keys in memory, for a single process
+type MemoryIdempotencyStore struct {
+	Clock Clock // Tells when keys expire, the system clock when nil
+
+	mu        sync.Mutex
+	records   map[string]idempotencyRecord
+	lastSweep time.Time
+}
+
+// NewMemoryIdempotencyStore creates an empty in-memory store
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[string]idempotencyRecord)}
+}
+
+// Reserve claims a key or returns its recorded result
+func (m *MemoryIdempotencyStore) Reserve(key string, lockTimeout time.Duration) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := clockOrReal(m.Clock).Now()
+	m.sweepLocked(now)
+
+	if record, ok := m.records[key]; ok && now.Before(record.Expires) {
+		return record.Result, false, nil
+	}
+
+	m.records[key] = idempotencyRecord{Expires: now.Add(lockTimeout)}
+	return nil, true, nil
+}
+
+// Complete records the result of a key
+func (m *MemoryIdempotencyStore) Complete(key string, result []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.records[key] = idempotencyRecord{Result: result, Expires: clockOrReal(m.Clock).Now().Add(ttl)}
+	return nil
+}
+
+// Release forgets a key
+func (m *MemoryIdempotencyStore) Release(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, key)
+	return nil
+}
+
+// sweepLocked drops expired keys at most once a minute. Must be called with the lock held.
+func (m *MemoryIdempotencyStore) sweepLocked(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+
+	for key, record := range m.records {
+		if !now.Before(record.Expires) {
+			delete(m.records, key)
+		}
+	}
+}
+
+// FileIdempotencyStore keeps one JSON file per key in a directory, so keys are shared by
+// every process with access to it. Reservations are made by creating the file exclusively.
+type FileIdempotencyStore struct {
+	Clock Clock // Tells when keys expire, the system clock when nil
+
+	dir string
+}
+
+// NewFileIdempotencyStore creates a store in dir, creating the directory if needed
+func NewFileIdempotencyStore(dir string) (*FileIdempotencyStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeInvalidConfig, "failed to create idempotency directory", err)
+	}
+	return &FileIdempotencyStore{dir: dir}, nil
+}
+
+// path returns the file of a key. Keys are hashed since they come from clients.
+func (f *FileIdempotencyStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Reserve claims a key or returns its recorded result
+func (f *FileIdempotencyStore) Reserve(key string, lockTimeout time.Duration) ([]byte, bool, error) {
+	file := f.path(key)
+	data, err := json.Marshal(idempotencyRecord{Expires: clockOrReal(f.Clock).Now().Add(lockTimeout)})
+	if err != nil {
+		return nil, false, err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		handle, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = handle.Write(data)
+			if closeErr := handle.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(file)
+				return nil, false, err
+			}
+			return nil, true, nil
+		}
+		if !os.IsExist(err) {
+			return nil, false, err
+		}
+
+		record, err := readIdempotencyRecord(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // Released in the meantime
+		}
+		if err != nil {
+			// Created but not written yet by the process holding the key, unless it
+			// stopped before writing it
+			if stat, statErr := os.Stat(file); statErr != nil || clockOrReal(f.Clock).Now().Sub(stat.ModTime()) < lockTimeout {
+				return nil, false, nil
+			}
+		} else if clockOrReal(f.Clock).Now().Before(record.Expires) {
+			return record.Result, false, nil
+		}
+
+		// Expired result or abandoned reservation, claim it again
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return nil, false, err
+		}
+	}
+
+	return nil, false, nil
+}
+
+// Complete records the result of a key
+func (f *FileIdempotencyStore) Complete(key string, result []byte, ttl time.Duration) error {
+	data, err := json.Marshal(idempotencyRecord{Result: result, Expires: clockOrReal(f.Clock).Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a truncated record
+	file := f.path(key)
+	tmp, err := os.CreateTemp(f.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Release forgets a key
+func (f *FileIdempotencyStore) Release(key string) error {
+	if err := os.Remove(f.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// PruneExpired removes the files of expired keys and returns how many were removed.
+// Expired keys are otherwise only replaced when the same key is used again.
+func (f *FileIdempotencyStore) PruneExpired() (int, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	now := clockOrReal(f.Clock).Now()
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		file := filepath.Join(f.dir, entry.Name())
+		record, err := readIdempotencyRecord(file)
+		if err != nil || now.Before(record.Expires) {
+			continue
+		}
+		if err := os.Remove(file); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// readIdempotencyRecord reads and decodes the file of a key
+func readIdempotencyRecord(file string) (*idempotencyRecord, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var record idempotencyRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
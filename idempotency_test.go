@@ -0,0 +1,180 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func testIdempotencyStore(t *testing.T, store IdempotencyStore) {
+	result, reserved, err := store.Reserve("key", time.Minute)
+	if err != nil || !reserved || result != nil {
+		t.Fatalf("first Reserve = %q, %v, %v", result, reserved, err)
+	}
+
+	result, reserved, err = store.Reserve("key", time.Minute)
+	if err != nil || reserved || result != nil {
+		t.Fatalf("Reserve of a held key = %q, %v, %v", result, reserved, err)
+	}
+
+	if err := store.Complete("key", []byte(`{"path":"a"}`), time.Minute); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	result, reserved, err = store.Reserve("key", time.Minute)
+	if err != nil || reserved || string(result) != `{"path":"a"}` {
+		t.Fatalf("Reserve of a completed key = %q, %v, %v", result, reserved, err)
+	}
+
+	// Released keys can be claimed again
+	if _, reserved, _ := store.Reserve("other", time.Minute); !reserved {
+		t.Fatal("expected to reserve another key")
+	}
+	if err := store.Release("other"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, reserved, _ := store.Reserve("other", time.Minute); !reserved {
+		t.Error("expected a released key to be reserved again")
+	}
+
+	// Abandoned reservations and expired results can be claimed again
+	store.Reserve("abandoned", time.Millisecond)
+	store.Reserve("expired", time.Minute)
+	store.Complete("expired", []byte(`{}`), time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	for _, key := range []string{"abandoned", "expired"} {
+		if result, reserved, _ := store.Reserve(key, time.Minute); !reserved || result != nil {
+			t.Errorf("expected %s key to be reserved again, got %q, %v", key, result, reserved)
+		}
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	testIdempotencyStore(t, NewMemoryIdempotencyStore())
+}
+
+func TestFileIdempotencyStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileIdempotencyStore(filepath.Join(dir, "keys"))
+	if err != nil {
+		t.Fatalf("NewFileIdempotencyStore failed: %v", err)
+	}
+	testIdempotencyStore(t, store)
+
+	// A second store on the same directory sees the same keys
+	shared, _ := NewFileIdempotencyStore(filepath.Join(dir, "keys"))
+	if result, _, _ := shared.Reserve("key", time.Minute); string(result) != `{"path":"a"}` {
+		t.Errorf("expected the result recorded by the other store, got %q", result)
+	}
+
+	store.Reserve("stale", time.Minute)
+	store.Complete("stale", []byte(`{}`), time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	removed, err := store.PruneExpired()
+	if err != nil || removed != 1 {
+		t.Errorf("PruneExpired = %d, %v, want 1", removed, err)
+	}
+}
+
+func TestUploadIdempotencyKey(t *testing.T) {
+	storage, err := New(&StorageConfig{
+		Name:        "test",
+		Provider:    "memory",
+		Idempotency: &IdempotencyConfig{},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	ctx := context.Background()
+	source := filepath.Join(t.TempDir(), "clip.mp4")
+	if err := os.WriteFile(source, []byte("clip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := &rest.UploadedFile{Path: source, Filename: "clip.mp4", OriginalName: "clip.mp4", MimeType: "video/mp4"}
+
+	t.Run("Replay", func(t *testing.T) {
+		opts := UploadOptions{IdempotencyKey: "delivery-1"}
+		first, err := storage.UploadFromUploadedFileWithOptions(ctx, file, "file", "clips", opts)
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		second, err := storage.UploadFromUploadedFileWithOptions(ctx, file, "file", "clips", opts)
+		if err != nil {
+			t.Fatalf("Retry failed: %v", err)
+		}
+		if second.Path != first.Path || second.ETag != first.ETag {
+			t.Errorf("expected the first result, got %s after %s", second.Path, first.Path)
+		}
+
+		entries, _ := storage.List(ctx, "clips")
+		if len(entries) != 1 {
+			t.Errorf("expected one stored file, got %d", len(entries))
+		}
+
+		// The same key in another directory is a different upload
+		other, err := storage.UploadFromUploadedFileWithOptions(ctx, file, "file", "other", opts)
+		if err != nil || other.Path == first.Path {
+			t.Errorf("expected a new upload in another directory, got %v, %v", other, err)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var uploads atomic.Int32
+		var wg sync.WaitGroup
+		results := make([]string, 5)
+		for i := range results {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result, err := idempotent(ctx, storage, "concurrent", func() (*UploadedFileResult, error) {
+					uploads.Add(1)
+					time.Sleep(50 * time.Millisecond)
+					return storage.uploadFile(ctx, file, "file", "concurrent", UploadOptions{})
+				})
+				if err != nil {
+					t.Errorf("Upload failed: %v", err)
+					return
+				}
+				results[i] = result.Path
+			}()
+		}
+		wg.Wait()
+
+		if uploads.Load() != 1 {
+			t.Errorf("expected a single upload, got %d", uploads.Load())
+		}
+		for _, path := range results {
+			if path != results[0] {
+				t.Errorf("expected every caller to get %s, got %s", results[0], path)
+			}
+		}
+	})
+
+	t.Run("FailureReleasesKey", func(t *testing.T) {
+		missing := &rest.UploadedFile{Path: filepath.Join(t.TempDir(), "missing"), Filename: "missing.mp4"}
+		opts := UploadOptions{IdempotencyKey: "retry-after-failure"}
+		if _, err := storage.UploadFromUploadedFileWithOptions(ctx, missing, "file", "clips", opts); err == nil {
+			t.Fatal("expected the upload to fail")
+		}
+		if _, err := storage.UploadFromUploadedFileWithOptions(ctx, file, "file", "clips", opts); err != nil {
+			t.Errorf("expected the retry to run, got %v", err)
+		}
+	})
+
+	t.Run("WaitHonorsContext", func(t *testing.T) {
+		storage.idempotency.Reserve(idempotencyScope("file", "clips", "held"), time.Minute)
+		waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err := storage.UploadFromUploadedFileWithOptions(waitCtx, file, "file", "clips", UploadOptions{IdempotencyKey: "held"})
+		if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected a canceled error wrapping DeadlineExceeded, got %v", err)
+		}
+	})
+}
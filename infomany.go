@@ -0,0 +1,173 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+)
+
+// DefaultBatchListThreshold is the number of paths in the same directory from which
+// ExistsMany and GetInfoMany list the directory once instead of checking each path,
+// when StorageConfig.BatchListThreshold is not set
+const DefaultBatchListThreshold = 32
+
+// batchListThreshold returns the configured listing threshold of batched lookups, or 0
+// when listings are disabled
+func (c *StorageConfig) batchListThreshold() int {
+	switch {
+	case c.BatchListThreshold < 0:
+		return 0
+	case c.BatchListThreshold > 0:
+		return c.BatchListThreshold
+	}
+	return DefaultBatchListThreshold
+}
+
+// ExistsMany checks whether each of paths exists, keyed by the paths as given. Paths are
+// checked in parallel, up to StorageConfig.Concurrency at a time, except that
+// BatchListThreshold or more paths in the same directory are resolved with a single
+// listing of it. Paths that could not be checked are left out of the result and
+// reported in a *MultiError keyed by path, returned along with the rest.
+func (s *Storage) ExistsMany(ctx context.Context, paths []string) (map[string]bool, error) {
+	found, err := s.lookupMany(ctx, paths, false)
+	if found == nil {
+		return nil, err
+	}
+
+	var failed map[string]error
+	var multi *MultiError
+	if errors.As(err, &multi) {
+		failed = multi.Errors
+	}
+	exists := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		if _, ok := failed[p]; !ok {
+			exists[p] = found[p] != nil
+		}
+	}
+	return exists, err
+}
+
+// GetInfoMany gets the information of each of paths, keyed by the paths as given, like
+// ExistsMany. Missing and expired files are left out of the result without an error.
+// Files resolved from a listing carry their ETag but only the custom metadata the
+// provider lists.
+func (s *Storage) GetInfoMany(ctx context.Context, paths []string) (map[string]*FileInfo, error) {
+	return s.lookupMany(ctx, paths, true)
+}
+
+// lookupMany finds the paths that exist, with their full information when details is
+// set. It only fails as a whole when ctx is done; other failures are returned in a
+// *MultiError together with the paths found.
+func (s *Storage) lookupMany(ctx context.Context, paths []string, details bool) (map[string]*FileInfo, error) {
+	var (
+		mu    sync.Mutex
+		found = make(map[string]*FileInfo)
+		errs  MultiError
+	)
+	record := func(p string, info *FileInfo, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs.Add(p, err)
+		} else if info != nil {
+			found[p] = info
+		}
+	}
+
+	// Group the paths by directory, keeping paths with ".." for the provider to reject
+	byDir := make(map[string][]string)
+	var single []string
+	seen := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		normalized := s.config.normalizePath(p)
+		clean := cleanPath(normalized)
+		if clean == "" || strings.Contains("/"+normalized+"/", "/../") {
+			single = append(single, p)
+			continue
+		}
+		dir := path.Dir(clean)
+		byDir[dir] = append(byDir[dir], p)
+	}
+
+	threshold := s.config.batchListThreshold()
+	for dir, group := range byDir {
+		if threshold == 0 || len(group) < threshold || !s.lookupListed(ctx, dir, group, details, record) {
+			single = append(single, group...)
+		}
+	}
+
+	err := s.executor().Run(ctx, single, func(ctx context.Context, p string) error {
+		if !details {
+			exists, err := s.Exists(ctx, p)
+			if exists {
+				record(p, &FileInfo{Path: p}, err)
+			} else {
+				record(p, nil, err)
+			}
+			return nil
+		}
+		info, err := s.GetInfo(ctx, p)
+		if errors.Is(err, ErrFileNotFound) {
+			err = nil
+		}
+		record(p, info, err)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, errs.ErrorOrNil()
+}
+
+// lookupListed resolves the paths of group, all in directory dir, from one listing of
+// dir. It reports false when the directory could not be listed, so the paths are
+// checked one by one instead.
+func (s *Storage) lookupListed(ctx context.Context, dir string, group []string, details bool, record func(string, *FileInfo, error)) bool {
+	if dir == "." {
+		dir = ""
+	}
+	entries, err := s.provider.List(ctx, dir)
+	if errors.Is(err, ErrDirectoryNotFound) {
+		return true // None of them exists
+	}
+	if err != nil {
+		return false
+	}
+
+	byPath := make(map[string]*FileInfo, len(entries))
+	for _, entry := range entries {
+		byPath[cleanPath(entry.Path)] = entry
+	}
+
+	now := s.config.now()
+	matched := make(map[string]*FileInfo, len(group))
+	var files []*FileInfo
+	for _, p := range group {
+		info, ok := byPath[cleanPath(s.config.normalizePath(p))]
+		if !ok || (details && info.isExpired(now)) {
+			continue
+		}
+		matched[p] = info
+		if !info.IsDirectory {
+			files = append(files, info)
+		}
+	}
+
+	// Custom metadata is left as listed, since fetching it would take a request per file
+	if details {
+		if err := s.fillDetails(ctx, files, ListOptions{IncludeETags: true}); err != nil {
+			return false
+		}
+	}
+	for p, info := range matched {
+		record(p, info, nil)
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingProvider counts the lookups reaching the provider and fails GetInfo and
+// Exists for one path
+type countingProvider struct {
+	StorageProvider
+	mu      sync.Mutex
+	lookups int
+	lists   int
+	fail    string
+}
+
+func (p *countingProvider) count(path string, lookup bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !lookup {
+		p.lists++
+		return nil
+	}
+	p.lookups++
+	if path == p.fail {
+		return NewStorageErrorWithPath(ErrorCodeProviderError, "connection reset", path)
+	}
+	return nil
+}
+
+func (p *countingProvider) GetInfo(ctx context.Context, path string) (*FileInfo, error) {
+	if err := p.count(path, true); err != nil {
+		return nil, err
+	}
+	return p.StorageProvider.GetInfo(ctx, path)
+}
+
+func (p *countingProvider) Exists(ctx context.Context, path string) (bool, error) {
+	if err := p.count(path, true); err != nil {
+		return false, err
+	}
+	return p.StorageProvider.Exists(ctx, path)
+}
+
+func (p *countingProvider) List(ctx context.Context, path string) ([]*FileInfo, error) {
+	p.count(path, false)
+	return p.StorageProvider.List(ctx, path)
+}
+
+func TestExistsMany(t *testing.T) {
+	ctx := context.Background()
+	provider := &countingProvider{}
+	RegisterProvider("counting", func(config *StorageConfig) (StorageProvider, error) {
+		inner, err := NewMemoryProvider(config)
+		provider.StorageProvider = inner
+		return provider, err
+	})
+	config := &StorageConfig{Name: "test", Provider: "counting", BatchListThreshold: 8}
+	storage, err := New(config)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	// A playlist expects 12 segments, of which the last 2 were not written yet
+	var paths []string
+	for i := range 12 {
+		segment := fmt.Sprintf("streams/1/segment%03d.ts", i)
+		paths = append(paths, segment)
+		if i < 10 {
+			storage.Upload(ctx, segment, strings.NewReader("ts"), nil)
+		}
+	}
+	storage.Upload(ctx, "streams/2/index.m3u8", strings.NewReader("#EXTM3U"), nil)
+	paths = append(paths, "/streams/2/index.m3u8", "streams/3/index.m3u8")
+
+	exists, err := storage.ExistsMany(ctx, paths)
+	if err != nil {
+		t.Fatalf("ExistsMany failed: %v", err)
+	}
+	for i, p := range paths {
+		if expected := i < 10 || i == 12; exists[p] != expected {
+			t.Errorf("Expected %s to exist=%v", p, expected)
+		}
+	}
+	if len(exists) != len(paths) {
+		t.Errorf("Expected a result per path, got %d", len(exists))
+	}
+	// The existing file is looked up again for its expiration
+	if provider.lists != 1 || provider.lookups != 3 {
+		t.Errorf("Expected one listing and 3 lookups, got %d and %d", provider.lists, provider.lookups)
+	}
+
+	t.Run("WithoutListing", func(t *testing.T) {
+		provider.lists, provider.lookups = 0, 0
+		config.BatchListThreshold = -1
+		defer func() { config.BatchListThreshold = 8 }()
+		exists, err := storage.ExistsMany(ctx, paths)
+		if err != nil || !exists[paths[0]] || exists[paths[11]] {
+			t.Errorf("Unexpected result %v, %v", exists, err)
+		}
+		if provider.lists != 0 || provider.lookups != len(paths)+11 {
+			t.Errorf("Expected a lookup per path and per existing file, got %d listings and %d lookups", provider.lists, provider.lookups)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		provider.fail = "streams/2/index.m3u8"
+		defer func() { provider.fail = "" }()
+		exists, err := storage.ExistsMany(ctx, paths)
+		var multi *MultiError
+		if !errors.As(err, &multi) || multi.Len() != 1 || !errors.Is(multi.Errors["/streams/2/index.m3u8"], ErrProviderError) {
+			t.Fatalf("Expected the failed path in a MultiError, got %v", err)
+		}
+		if _, ok := exists["/streams/2/index.m3u8"]; ok || !exists[paths[0]] || len(exists) != len(paths)-1 {
+			t.Errorf("Expected the other paths to be checked, got %v", exists)
+		}
+	})
+}
+
+func TestGetInfoMany(t *testing.T) {
+	ctx := context.Background()
+	for _, threshold := range []int{2, -1} {
+		t.Run(fmt.Sprint(threshold), func(t *testing.T) {
+			storage, _ := New(&StorageConfig{Name: "test", Provider: "memory", BatchListThreshold: threshold})
+			storage.Upload(ctx, "clips/a.mp4", strings.NewReader("aaa"), nil)
+			storage.Upload(ctx, "clips/b.mp4", strings.NewReader("bb"), nil)
+			expired := time.Now().Add(-time.Hour)
+			storage.Upload(ctx, "clips/old.mp4", strings.NewReader("old"), &FileMetadata{ExpiresAt: &expired})
+
+			infos, err := storage.GetInfoMany(ctx, []string{"clips/a.mp4", "clips/b.mp4", "clips/old.mp4", "clips/missing.mp4", "clips/a.mp4"})
+			if err != nil {
+				t.Fatalf("GetInfoMany failed: %v", err)
+			}
+			if len(infos) != 2 || infos["clips/a.mp4"].Size != 3 || infos["clips/b.mp4"].ETag == "" {
+				t.Errorf("Expected the two live files with their details, got %v", infos)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// iterateBatch is the number of entries a FileIterator reads ahead, which bounds the
+// GetInfo calls made together for ListOptions.IncludeETags and IncludeMetadata
+const iterateBatch = 256
+
+// ListIterator reads the entries of a directory one at a time, sorted by name like List.
+// Next returns io.EOF after the last entry.
+type ListIterator interface {
+	Next() (*FileInfo, error)
+	Close() error
+}
+
+// IteratingProvider is implemented by providers that can list a directory without
+// building every FileInfo of it at once. Listings of other providers are read with List.
+type IteratingProvider interface {
+	Iterate(ctx context.Context, path string) (ListIterator, error)
+}
+
+// FileIterator reads a directory listing one entry at a time, holding at most a batch
+// of FileInfo in memory instead of the whole directory. It is not safe for concurrent use.
+type FileIterator struct {
+	ctx     context.Context
+	storage *Storage
+	path    string
+	opts    ListOptions
+
+	source   ListIterator
+	filtered bool // The source entries are already filtered and detailed, as with IncludeFallback
+	batch    []*FileInfo
+	pending  []*FileInfo
+	err      error
+}
+
+// Iterate returns an iterator over the entries of a directory, with the same entries as
+// ListWithOptions with the same options, sorted by name. Errors listing the directory,
+// including ErrDirectoryNotFound, are returned by the first call to Next. The iterator
+// must be closed.
+func (s *Storage) Iterate(ctx context.Context, path string, opts ListOptions) *FileIterator {
+	return &FileIterator{ctx: ctx, storage: s, path: path, opts: opts}
+}
+
+// Next returns the next entry, or io.EOF after the last one. Errors are sticky: once
+// Next fails, every later call returns the same error.
+func (it *FileIterator) Next() (*FileInfo, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	if len(it.pending) == 0 {
+		if err := it.fill(); err != nil {
+			it.err = err
+			return nil, err
+		}
+	}
+	file := it.pending[0]
+	it.pending[0] = nil
+	it.pending = it.pending[1:]
+	return file, nil
+}
+
+// Close releases the listing. Next returns io.EOF once the iterator is closed.
+func (it *FileIterator) Close() error {
+	if it.err == nil {
+		it.err = io.EOF
+	}
+	it.pending = nil
+	if it.source == nil {
+		return nil
+	}
+	return it.source.Close()
+}
+
+// fill reads the next batch of visible entries, with the details requested by the
+// options
+func (it *FileIterator) fill() error {
+	if it.source == nil {
+		if err := it.open(); err != nil {
+			return err
+		}
+	}
+
+	root := isRootPath(it.path)
+	batch := it.batch[:0]
+	for len(batch) < iterateBatch {
+		file, err := it.source.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if it.filtered || it.opts.listed(root, file) {
+			batch = append(batch, file)
+		}
+	}
+	it.batch = batch
+	if len(batch) == 0 {
+		return io.EOF
+	}
+
+	if !it.filtered && (it.opts.IncludeETags || it.opts.IncludeMetadata) {
+		if err := it.storage.fillDetails(it.ctx, batch, it.opts); err != nil {
+			return err
+		}
+	}
+	it.pending = batch
+	return nil
+}
+
+// open starts the listing, past the decorators for providers with IteratingProvider
+func (it *FileIterator) open() error {
+	s := it.storage
+	var err error
+	switch provider, ok := providerAs[IteratingProvider](s.provider); {
+	case it.opts.IncludeFallback && s.fallback != nil:
+		var files []*FileInfo
+		files, err = s.listMerged(it.ctx, it.path, it.opts)
+		it.source, it.filtered = &sliceIterator{files: files}, true
+	case ok:
+		var source ListIterator
+		source, err = provider.Iterate(it.ctx, s.extensionPath(it.path))
+		if err == nil {
+			it.source = &normalizedIterator{ListIterator: source, config: s.config}
+		}
+	default:
+		var files []*FileInfo
+		files, err = s.provider.List(it.ctx, it.path)
+		sortByName(files)
+		it.source = &sliceIterator{files: files}
+	}
+
+	if err != nil {
+		if it.opts.AllowMissing && errors.Is(err, ErrDirectoryNotFound) {
+			it.source = &sliceIterator{}
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// listed reports whether ListWithOptions returns an entry of a directory, root being
+// whether it is the storage root
+func (opts ListOptions) listed(root bool, file *FileInfo) bool {
+	if root && (file.Name == trashPrefix || file.Name == versionsPrefix || file.Name == quarantinePrefix) {
+		// Uploads being scanned are never listed
+		return (file.Name == trashPrefix && opts.IncludeTrash) || (file.Name == versionsPrefix && opts.IncludeVersions)
+	}
+	return opts.IncludeHidden || !IsHidden(file.Name)
+}
+
+// sliceIterator iterates over a listing already read
+type sliceIterator struct {
+	files []*FileInfo
+}
+
+func (it *sliceIterator) Next() (*FileInfo, error) {
+	if len(it.files) == 0 {
+		return nil, io.EOF
+	}
+	file := it.files[0]
+	it.files[0] = nil
+	it.files = it.files[1:]
+	return file, nil
+}
+
+func (it *sliceIterator) Close() error {
+	it.files = nil
+	return nil
+}
+
+// normalizedIterator normalizes the listed names like the decorator List goes through
+type normalizedIterator struct {
+	ListIterator
+	config *StorageConfig
+}
+
+func (it *normalizedIterator) Next() (*FileInfo, error) {
+	file, err := it.ListIterator.Next()
+	if file != nil {
+		file.Path = it.config.normalizePath(file.Path)
+		file.Name = it.config.normalizePath(file.Name)
+	}
+	return file, err
+}
+
+// collectIterator reads every entry of an iterator and closes it
+func collectIterator(it ListIterator) ([]*FileInfo, error) {
+	defer it.Close()
+	var files []*FileInfo
+	for {
+		file, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+}
@@ -0,0 +1,166 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestIterate(t *testing.T) {
+	ctx := context.Background()
+	for _, provider := range []string{"filesystem", "memory"} {
+		t.Run(provider, func(t *testing.T) {
+			config := &StorageConfig{Name: "test", Provider: provider, Trash: &TrashConfig{Enabled: true}}
+			if provider == "filesystem" {
+				config.FileSystem = &FileSystemConfig{BasePath: t.TempDir()}
+			}
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			// More entries than a batch, uploaded out of order, with metadata sidecars
+			for i := iterateBatch + 40; i > 0; i-- {
+				storage.Upload(ctx, fmt.Sprintf("cameras/clip-%04d.mp4", i), strings.NewReader("x"), &FileMetadata{CustomMetadata: map[string]string{"i": fmt.Sprint(i)}})
+			}
+			storage.Upload(ctx, "cameras/.hidden", strings.NewReader("x"), nil)
+			storage.Upload(ctx, "cameras/sub/a.txt", strings.NewReader("x"), nil)
+			storage.Upload(ctx, "deleted.txt", strings.NewReader("x"), nil)
+			storage.Delete(ctx, "deleted.txt")
+
+			for _, c := range []struct {
+				path string
+				opts ListOptions
+			}{
+				{"cameras", ListOptions{}},
+				{"cameras", ListOptions{IncludeHidden: true, IncludeETags: true, IncludeMetadata: true}},
+				{"", ListOptions{}},
+				{"", ListOptions{IncludeTrash: true}},
+			} {
+				listed, err := storage.ListWithOptions(ctx, c.path, c.opts)
+				if err != nil {
+					t.Fatalf("ListWithOptions failed: %v", err)
+				}
+				sortByName(listed)
+
+				entries := storage.Iterate(ctx, c.path, c.opts)
+				var iterated []*FileInfo
+				for {
+					entry, err := entries.Next()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					if err != nil {
+						t.Fatalf("Next failed: %v", err)
+					}
+					iterated = append(iterated, entry)
+				}
+				entries.Close()
+
+				if len(iterated) != len(listed) {
+					t.Fatalf("%q %+v: expected %d entries, got %d", c.path, c.opts, len(listed), len(iterated))
+				}
+				for i := range listed {
+					if iterated[i].Path != listed[i].Path || iterated[i].ETag != listed[i].ETag || len(iterated[i].Metadata) != len(listed[i].Metadata) {
+						t.Errorf("%q %+v: entry %d is %+v, expected %+v", c.path, c.opts, i, iterated[i], listed[i])
+						break
+					}
+				}
+			}
+
+			missing := storage.Iterate(ctx, "missing", ListOptions{})
+			if _, err := missing.Next(); !errors.Is(err, ErrDirectoryNotFound) {
+				t.Errorf("expected ErrDirectoryNotFound, got %v", err)
+			}
+			if _, err := missing.Next(); !errors.Is(err, ErrDirectoryNotFound) {
+				t.Errorf("expected the error to be sticky, got %v", err)
+			}
+			missing.Close()
+			allowed := storage.Iterate(ctx, "missing", ListOptions{AllowMissing: true})
+			if _, err := allowed.Next(); !errors.Is(err, io.EOF) {
+				t.Errorf("expected an empty listing, got %v", err)
+			}
+
+			closed := storage.Iterate(ctx, "cameras", ListOptions{})
+			closed.Next()
+			closed.Close()
+			if _, err := closed.Next(); !errors.Is(err, io.EOF) {
+				t.Errorf("expected a closed iterator to end, got %v", err)
+			}
+		})
+	}
+}
+
+// Compares the heap held while listing a directory of 100k files:
+//
+//	go test -run '^$' -bench Iterate -benchtime 3x
+func BenchmarkIterate(b *testing.B) {
+	const entries = 100_000
+	basePath := b.TempDir()
+	dir := filepath.Join(basePath, "cameras")
+	os.MkdirAll(dir, 0755)
+	for i := 0; i < entries; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("clip-%06d.mp4", i)), nil, 0644); err != nil {
+			b.Fatalf("Failed to create the tree: %v", err)
+		}
+	}
+	storage, err := New(&StorageConfig{Name: "BenchmarkStorage", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: basePath}})
+	if err != nil {
+		b.Fatalf("Failed to create storage: %v", err)
+	}
+	ctx := context.Background()
+
+	// heapInUse samples the heap after a collection, so only what is still referenced counts
+	heapInUse := func() int64 {
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		return int64(stats.HeapInuse)
+	}
+
+	b.Run("List", func(b *testing.B) {
+		var peak int64
+		for i := 0; i < b.N; i++ {
+			base := heapInUse()
+			files, err := storage.List(ctx, "cameras")
+			if err != nil || len(files) != entries {
+				b.Fatalf("List failed: %v", err)
+			}
+			peak = max(peak, heapInUse()-base)
+			runtime.KeepAlive(files)
+		}
+		b.ReportMetric(float64(peak)/(1<<20), "peak-MB")
+	})
+
+	b.Run("Iterate", func(b *testing.B) {
+		var peak int64
+		for i := 0; i < b.N; i++ {
+			base := heapInUse()
+			it := storage.Iterate(ctx, "cameras", ListOptions{})
+			count := 0
+			for {
+				_, err := it.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					b.Fatalf("Next failed: %v", err)
+				}
+				if count++; count%(entries/10) == 0 {
+					peak = max(peak, heapInUse()-base)
+				}
+			}
+			it.Close()
+			if count != entries {
+				b.Fatalf("expected %d entries, got %d", entries, count)
+			}
+		}
+		b.ReportMetric(float64(peak)/(1<<20), "peak-MB")
+	})
+}
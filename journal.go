@@ -0,0 +1,329 @@
+package vsaasstorage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Operations recorded in JournalEntry
+const (
+	JournalOperationUpload   = "upload" // Also restores from the trash or version history
+	JournalOperationAppend   = "append"
+	JournalOperationDelete   = "delete"
+	JournalOperationMove     = "move" // Path is the destination and Source the original path
+	JournalOperationCopy     = "copy"
+	JournalOperationMetadata = "metadata" // Metadata such as retention changed, not the content
+)
+
+// DefaultJournalMaxSize is the size at which FileJournal rotates its file
+const DefaultJournalMaxSize = 64 << 20
+
+// DefaultJournalMaxFiles is the number of rotated files FileJournal keeps
+const DefaultJournalMaxFiles = 4
+
+// JournalEntry records one successful mutation. Paths are canonical and never point into
+// the trash, version history or quarantine.
+type JournalEntry struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"op"`
+	Path      string    `json:"path"`
+	Source    string    `json:"source,omitempty"` // Original path of moves and copies
+	Size      int64     `json:"size,omitempty"`   // Size after the operation, unset for deletes
+	ETag      string    `json:"etag,omitempty"`
+}
+
+// JournalWriter receives an entry after each successful mutation. Errors are logged and
+// counted in storage_journal_errors_total but never fail the operation.
+type JournalWriter interface {
+	WriteEntry(ctx context.Context, entry *JournalEntry) error
+}
+
+// JournalReader is implemented by journals that can be replayed
+type JournalReader interface {
+	ReadJournal(ctx context.Context, since time.Time, fn func(JournalEntry) error) error
+}
+
+// ReplayJournal calls fn for every journal entry recorded at or after since, oldest
+// first, stopping at the first error fn returns. Consumers that resume from the time of
+// the last entry they applied see it again, so applying entries must be idempotent. The
+// configured Journal must implement JournalReader, as FileJournal and MemoryJournal do.
+func (s *Storage) ReplayJournal(ctx context.Context, since time.Time, fn func(JournalEntry) error) error {
+	if s.config.Journal == nil {
+		return NewStorageError(ErrorCodeInvalidConfig, "no journal is configured")
+	}
+	reader, ok := s.config.Journal.(JournalReader)
+	if !ok {
+		return NotSupportedError("journal cannot be replayed")
+	}
+	return reader.ReadJournal(ctx, since, fn)
+}
+
+// journal records a mutation of path with the size and ETag of info, if any. Moves and
+// copies out of internal areas are recorded as uploads of their destination.
+func (s *Storage) journal(ctx context.Context, operation, path, source string, info *FileInfo) {
+	if s.config.Journal == nil {
+		return
+	}
+	path = cleanPath(s.config.normalizePath(path))
+	if isInternalPath(path) {
+		return
+	}
+	if source != "" {
+		if source = cleanPath(s.config.normalizePath(source)); isInternalPath(source) {
+			operation, source = JournalOperationUpload, ""
+		}
+	}
+
+	entry := &JournalEntry{Time: s.config.now().UTC(), Operation: operation, Path: path, Source: source}
+	if info != nil {
+		entry.Size, entry.ETag = info.Size, NormalizeETag(info.ETag)
+	}
+
+	// A mutation that happened must be recorded even if the caller gave up meanwhile
+	ctx = context.WithoutCancel(ctx)
+	if err := s.config.Journal.WriteEntry(ctx, entry); err != nil {
+		s.config.log(ctx, LogLevelWarn, "failed to write journal entry", map[string]interface{}{
+			"path":      path,
+			"operation": operation,
+			"error":     err.Error(),
+		})
+		s.config.incCounter("storage_journal_errors_total", 1, map[string]string{
+			"storage": s.config.Name,
+		})
+	}
+}
+
+// journalCurrent records a mutation with the current size and ETag of path, which costs
+// a GetInfo only when a journal is configured
+func (s *Storage) journalCurrent(ctx context.Context, operation, path, source string) {
+	if s.config.Journal == nil {
+		return
+	}
+	info, _ := s.provider.GetInfo(ctx, path)
+	s.journal(ctx, operation, path, source, info)
+}
+
+// FileJournal appends entries as JSON lines to a file, rotating it to path.1 ... path.N
+// once it reaches MaxSize. The file is reopened when it was moved or truncated by an
+// external tool such as logrotate. Only one process may write a journal file.
+type FileJournal struct {
+	path     string
+	MaxSize  int64 // Size at which the file is rotated, defaults to DefaultJournalMaxSize
+	MaxFiles int   // Rotated files kept, defaults to DefaultJournalMaxFiles
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileJournal opens or creates the journal file at path
+func NewFileJournal(path string) (*FileJournal, error) {
+	j := &FileJournal{path: path, MaxSize: DefaultJournalMaxSize, MaxFiles: DefaultJournalMaxFiles}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeInvalidConfig, "failed to create journal directory", err)
+	}
+	if err := j.open(); err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeInvalidConfig, "failed to open journal", err)
+	}
+	return j, nil
+}
+
+// open opens the journal file for appending. Must be called with the lock held.
+func (j *FileJournal) open() error {
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	j.file, j.size = file, stat.Size()
+	return nil
+}
+
+// WriteEntry appends the entry as one line, rotating the file first if it is full
+func (j *FileJournal) WriteEntry(ctx context.Context, entry *JournalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.reopenIfReplaced(); err != nil {
+		return err
+	}
+	if maxSize := j.maxSize(); j.size > 0 && j.size+int64(len(line)) > maxSize {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	return err
+}
+
+// reopenIfReplaced reopens the journal file if it was moved or removed since it was
+// opened, and picks up its size if it was truncated. Must be called with the lock held.
+func (j *FileJournal) reopenIfReplaced() error {
+	if j.file == nil {
+		return j.open()
+	}
+	current, err := os.Stat(j.path)
+	opened, openedErr := j.file.Stat()
+	if err == nil && openedErr == nil && os.SameFile(current, opened) {
+		j.size = current.Size()
+		return nil
+	}
+	j.file.Close()
+	j.file = nil
+	return j.open()
+}
+
+// rotate shifts the rotated files, dropping the oldest, and starts a new journal file.
+// Must be called with the lock held.
+func (j *FileJournal) rotate() error {
+	j.file.Close()
+	j.file = nil
+
+	maxFiles := j.maxFiles()
+	os.Remove(fmt.Sprintf("%s.%d", j.path, maxFiles))
+	for i := maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", j.path, i), fmt.Sprintf("%s.%d", j.path, i+1))
+	}
+	if err := os.Rename(j.path, j.path+".1"); err != nil {
+		return err
+	}
+	return j.open()
+}
+
+// maxSize returns the configured rotation size
+func (j *FileJournal) maxSize() int64 {
+	if j.MaxSize > 0 {
+		return j.MaxSize
+	}
+	return DefaultJournalMaxSize
+}
+
+// maxFiles returns the configured number of rotated files
+func (j *FileJournal) maxFiles() int {
+	if j.MaxFiles > 0 {
+		return j.MaxFiles
+	}
+	return DefaultJournalMaxFiles
+}
+
+// ReadJournal reads the rotated files, oldest first, and then the current one. Rotated
+// files last written before since are skipped without reading them, and lines that are
+// not complete entries, such as one cut short by a crash, are ignored.
+func (j *FileJournal) ReadJournal(ctx context.Context, since time.Time, fn func(JournalEntry) error) error {
+	// Open every file at once, so a rotation while reading neither skips nor repeats entries
+	j.mu.Lock()
+	var files []*os.File
+	for i := j.maxFiles(); i >= 0; i-- {
+		name := j.path
+		if i > 0 {
+			name = fmt.Sprintf("%s.%d", j.path, i)
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		if stat, err := file.Stat(); err == nil && i > 0 && stat.ModTime().Before(since) {
+			file.Close()
+			continue
+		}
+		files = append(files, file)
+	}
+	j.mu.Unlock()
+
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	for _, file := range files {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64<<10), 1<<20)
+		for scanner.Scan() {
+			if err := checkContext(ctx, ""); err != nil {
+				return err
+			}
+			var entry JournalEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Operation == "" {
+				continue
+			}
+			if entry.Time.Before(since) {
+				continue
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return NewStorageErrorWithCause(ErrorCodeInternalError, "failed to read journal", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the journal file
+func (j *FileJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// MemoryJournal keeps entries in memory, for tests and single-process consumers
+type MemoryJournal struct {
+	mu      sync.Mutex
+	entries []JournalEntry
+}
+
+// NewMemoryJournal creates an empty in-memory journal
+func NewMemoryJournal() *MemoryJournal {
+	return &MemoryJournal{}
+}
+
+// WriteEntry appends a copy of the entry
+func (j *MemoryJournal) WriteEntry(ctx context.Context, entry *JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, *entry)
+	return nil
+}
+
+// ReadJournal calls fn for the entries recorded at or after since
+func (j *MemoryJournal) ReadJournal(ctx context.Context, since time.Time, fn func(JournalEntry) error) error {
+	j.mu.Lock()
+	entries := append([]JournalEntry(nil), j.entries...)
+	j.mu.Unlock()
+
+	for _, entry := range entries {
+		if entry.Time.Before(since) {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
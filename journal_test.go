@@ -0,0 +1,188 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// journalOps returns the entries of a journal as "op path" strings
+func journalOps(t *testing.T, storage *Storage, since time.Time) []string {
+	t.Helper()
+	var ops []string
+	err := storage.ReplayJournal(context.Background(), since, func(entry JournalEntry) error {
+		op := entry.Operation + " " + entry.Path
+		if entry.Source != "" {
+			op += " <- " + entry.Source
+		}
+		ops = append(ops, op)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReplayJournal failed: %v", err)
+	}
+	return ops
+}
+
+func TestJournal(t *testing.T) {
+	ctx := context.Background()
+	journal, err := NewFileJournal(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	defer journal.Close()
+	storage, err := New(&StorageConfig{
+		Name:       "test",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+		Trash:      &TrashConfig{Enabled: true},
+		Journal:    journal,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	storage.Upload(ctx, "/docs/a.txt", strings.NewReader("aaa"), nil)
+	storage.Copy(ctx, "docs/a.txt", "docs/b.txt")
+	storage.Move(ctx, "docs/b.txt", "archive/b.txt")
+	storage.Append(ctx, "logs/app.log", strings.NewReader("line\n"))
+	storage.SetRetention(ctx, "archive/b.txt", time.Now().Add(time.Hour))
+	storage.Delete(ctx, "docs/a.txt")
+	storage.Upload(ctx, "tmp/1.txt", strings.NewReader("1"), nil)
+	storage.Upload(ctx, "tmp/2.txt", strings.NewReader("2"), nil)
+	storage.DeleteDirectory(ctx, "tmp")
+	if err := storage.Delete(ctx, "missing.txt"); err == nil {
+		t.Fatal("Expected the delete to fail")
+	}
+
+	expected := []string{
+		"upload docs/a.txt",
+		"copy docs/b.txt <- docs/a.txt",
+		"move archive/b.txt <- docs/b.txt",
+		"append logs/app.log",
+		"metadata archive/b.txt",
+		"delete docs/a.txt",
+		"upload tmp/1.txt",
+		"upload tmp/2.txt",
+		"delete tmp/1.txt",
+		"delete tmp/2.txt",
+	}
+	if ops := journalOps(t, storage, time.Time{}); strings.Join(ops, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected journal:\n%s", strings.Join(ops, "\n"))
+	}
+
+	var first JournalEntry
+	storage.ReplayJournal(ctx, time.Time{}, func(entry JournalEntry) error {
+		first = entry
+		return errors.New("stop")
+	})
+	if first.Size != 3 || first.ETag == "" || first.Time.IsZero() {
+		t.Errorf("Expected the size and ETag of the upload, got %+v", first)
+	}
+
+	t.Run("Since", func(t *testing.T) {
+		since := time.Now()
+		storage.Upload(ctx, "docs/c.txt", strings.NewReader("c"), nil)
+		if ops := journalOps(t, storage, since); len(ops) != 1 || ops[0] != "upload docs/c.txt" {
+			t.Errorf("Expected only the entries after since, got %v", ops)
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		since := time.Now()
+		batches, _ := storage.ListWithOptions(ctx, trashPrefix, ListOptions{IncludeTrash: true})
+		if err := storage.Restore(ctx, batches[0].Path+"/docs/a.txt"); err != nil {
+			t.Fatalf("Restore failed: %v", err)
+		}
+		if ops := journalOps(t, storage, since); len(ops) != 1 || ops[0] != "upload docs/a.txt" {
+			t.Errorf("Expected the restore as an upload, got %v", ops)
+		}
+	})
+}
+
+// failingJournal refuses every entry
+type failingJournal struct{}
+
+func (failingJournal) WriteEntry(ctx context.Context, entry *JournalEntry) error {
+	return errors.New("disk full")
+}
+
+func TestJournalFailure(t *testing.T) {
+	ctx := context.Background()
+	logger := &recordingLogger{}
+	metrics := &countingMetrics{}
+	storage, _ := New(&StorageConfig{Name: "test", Provider: "memory", Journal: failingJournal{}, Logger: logger, Metrics: metrics})
+
+	if _, err := storage.Upload(ctx, "a.txt", strings.NewReader("a"), nil); err != nil {
+		t.Fatalf("Expected the upload to succeed despite the journal, got %v", err)
+	}
+	if len(logger.messages) != 1 || metrics.counters["storage_journal_errors_total"] != 1 {
+		t.Errorf("Expected the failure to be logged and counted, got %v, %v", logger.messages, metrics.counters)
+	}
+	if err := storage.ReplayJournal(ctx, time.Time{}, func(JournalEntry) error { return nil }); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported replaying a write-only journal, got %v", err)
+	}
+}
+
+func TestFileJournalRotation(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := NewFileJournal(path)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	defer journal.Close()
+	journal.MaxSize = 512
+	journal.MaxFiles = 2
+
+	write := func(from, to int) {
+		for i := from; i < to; i++ {
+			journal.WriteEntry(ctx, &JournalEntry{Time: time.Now(), Operation: JournalOperationUpload, Path: fmt.Sprintf("clips/%03d.mp4", i)})
+		}
+	}
+	paths := func() []string {
+		var paths []string
+		journal.ReadJournal(ctx, time.Time{}, func(entry JournalEntry) error {
+			paths = append(paths, entry.Path)
+			return nil
+		})
+		return paths
+	}
+
+	write(0, 40)
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected at most 2 rotated files, got %v", err)
+	}
+	replayed := paths()
+	if len(replayed) == 0 || len(replayed) >= 40 || replayed[len(replayed)-1] != "clips/039.mp4" {
+		t.Fatalf("Expected the newest entries in order, got %v", replayed)
+	}
+	for i := 1; i < len(replayed); i++ {
+		if replayed[i] <= replayed[i-1] {
+			t.Fatalf("Entries out of order: %v", replayed)
+		}
+	}
+
+	t.Run("TruncatedLine", func(t *testing.T) {
+		file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		file.WriteString(`{"time":"2024-01-01T00:00:00Z","op":"upl`)
+		file.Close()
+		if replayed := paths(); replayed[len(replayed)-1] != "clips/039.mp4" {
+			t.Errorf("Expected the partial line to be skipped, got %v", replayed)
+		}
+	})
+
+	t.Run("ExternalRotation", func(t *testing.T) {
+		os.Rename(path, path+".old")
+		write(40, 41)
+		data, _ := os.ReadFile(path)
+		if !strings.Contains(string(data), "clips/040.mp4") {
+			t.Errorf("Expected the journal to be reopened at its path, got %q", data)
+		}
+	})
+}
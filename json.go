@@ -0,0 +1,180 @@
+package vsaasstorage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// DefaultMaxJSONSize is the size cap of GetJSON when maxSize is not positive
+const DefaultMaxJSONSize = 10 << 20
+
+// JSONContentType is the content type PutJSON stores documents with
+const JSONContentType = "application/json"
+
+// JSONOptions controls how PutJSON encodes a document
+type JSONOptions struct {
+	Indent bool // Pretty-print with two-space indentation
+	Gzip   bool // Store the document gzip-compressed; GetJSON decompresses it transparently
+}
+
+// mergeJSONOptions returns the first options value or the defaults
+func mergeJSONOptions(opts []JSONOptions) JSONOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return JSONOptions{}
+}
+
+// PutJSON stores v encoded as JSON with the application/json content type. Other fields
+// of metadata, such as CustomMetadata or TTL, are kept.
+func (s *Storage) PutJSON(ctx context.Context, path string, v any, metadata *FileMetadata, opts ...JSONOptions) (*FileInfo, error) {
+	options := mergeJSONOptions(opts)
+
+	var data []byte
+	var err error
+	if options.Indent {
+		data, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return nil, &StorageError{Code: ErrorCodeInvalidJSON, Message: "failed to encode JSON: " + err.Error(), Path: path, Cause: err}
+	}
+
+	stored := FileMetadata{}
+	if metadata != nil {
+		stored = *metadata
+	}
+	stored.ContentType = JSONContentType
+	if options.Gzip {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		writer.Write(data)
+		writer.Close()
+		data = buf.Bytes()
+		stored.ContentEncoding = "gzip"
+	}
+
+	return s.Upload(ctx, path, bytes.NewReader(data), &stored)
+}
+
+// GetJSON decodes the JSON document at path into out. Documents larger than maxSize
+// (DefaultMaxJSONSize when not positive) fail with ErrFileTooLarge, even when stored
+// compressed, and files that are not JSON fail with ErrInvalidJSON, with the
+// *json.SyntaxError or *json.UnmarshalTypeError as the cause.
+func (s *Storage) GetJSON(ctx context.Context, path string, out any, maxSize int64) (*FileInfo, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxJSONSize
+	}
+
+	reader, info, err := s.Download(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	if !isJSONContentType(info.ContentType) {
+		return nil, NewStorageErrorWithPath(ErrorCodeInvalidJSON, "unexpected content type "+info.ContentType, info.Path)
+	}
+
+	// gzip streams cannot start a JSON document, so they are recognized by their header
+	buffered := bufio.NewReader(reader)
+	var content io.Reader = buffered
+	if header, err := buffered.Peek(2); err == nil && header[0] == 0x1f && header[1] == 0x8b {
+		gz, err := gzip.NewReader(content)
+		if err != nil {
+			return nil, &StorageError{Code: ErrorCodeInvalidJSON, Message: "invalid gzip stream: " + err.Error(), Path: info.Path, Cause: err}
+		}
+		defer gz.Close()
+		content = gz
+	} else if info.Size > maxSize {
+		return nil, jsonTooLarge(info.Path, maxSize)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(content, maxSize+1))
+	if err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to read JSON document", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, jsonTooLarge(info.Path, maxSize)
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return nil, &StorageError{Code: ErrorCodeInvalidJSON, Message: "malformed JSON: " + err.Error(), Path: info.Path, Cause: err}
+	}
+	return info, nil
+}
+
+// jsonTooLarge returns the error of a document over the size cap of GetJSON
+func jsonTooLarge(path string, maxSize int64) *StorageError {
+	return NewStorageErrorWithPath(ErrorCodeFileTooLarge, fmt.Sprintf("JSON document exceeds %d bytes", maxSize), path)
+}
+
+// isJSONContentType reports whether a stored content type can hold JSON. Files stored
+// without one are reported as application/octet-stream by some providers.
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == JSONContentType || strings.HasSuffix(mediaType, "+json") || mediaType == "application/octet-stream"
+}
+
+// UpdateJSON applies mutate to the JSON document at path with compare-and-swap: the
+// document is read with its ETag and the result is written only if the ETag did not
+// change in the meantime, retrying up to maxRetries times with the new content when it
+// did. mutate is called with nil when the document does not exist, to create it, and
+// may be called several times. Its custom metadata is kept. Documents written without
+// a checksum have no ETag and fail with ErrNotSupported.
+func (s *Storage) UpdateJSON(ctx context.Context, path string, maxRetries int, mutate func(current json.RawMessage) (json.RawMessage, error)) (*FileInfo, error) {
+	var err error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		var info *FileInfo
+		if info, err = s.updateJSON(ctx, path, mutate); !errors.Is(err, ErrPreconditionFailed) {
+			return info, err
+		}
+		if ctxErr := checkContext(ctx, path); ctxErr != nil {
+			return nil, ctxErr
+		}
+	}
+	return nil, err
+}
+
+// updateJSON makes one compare-and-swap attempt of UpdateJSON
+func (s *Storage) updateJSON(ctx context.Context, path string, mutate func(current json.RawMessage) (json.RawMessage, error)) (*FileInfo, error) {
+	var current json.RawMessage
+	etag := ""
+	metadata := &FileMetadata{ContentType: JSONContentType}
+	info, err := s.GetJSON(ctx, path, &current, 0)
+	switch {
+	case errors.Is(err, ErrFileNotFound):
+		current = nil
+	case err != nil:
+		return nil, err
+	case info.ETag == "":
+		return nil, NotSupportedError("document has no ETag to compare; enable ComputeChecksum")
+	default:
+		etag = info.ETag
+		metadata.CustomMetadata = info.Metadata
+	}
+
+	updated, err := mutate(current)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(updated) {
+		return nil, NewStorageErrorWithPath(ErrorCodeInvalidJSON, "update is not valid JSON", path)
+	}
+	return s.UploadIfMatch(ctx, path, bytes.NewReader(updated), metadata, etag)
+}
@@ -0,0 +1,159 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type cameraSettings struct {
+	Name    string `json:"name"`
+	Bitrate int    `json:"bitrate"`
+}
+
+func TestJSONDocuments(t *testing.T) {
+	ctx := context.Background()
+	for _, provider := range []string{"memory", "filesystem"} {
+		t.Run(provider, func(t *testing.T) {
+			storage, err := New(&StorageConfig{Name: "test", Provider: provider, FileSystem: &FileSystemConfig{BasePath: t.TempDir()}})
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			settings := cameraSettings{Name: "lobby", Bitrate: 4000}
+			info, err := storage.PutJSON(ctx, "cameras/1/settings", settings, &FileMetadata{CustomMetadata: map[string]string{"camera": "1"}})
+			if err != nil {
+				t.Fatalf("PutJSON failed: %v", err)
+			}
+			if info.ContentType != JSONContentType {
+				t.Errorf("Expected %s, got %q", JSONContentType, info.ContentType)
+			}
+
+			var got cameraSettings
+			if _, err := storage.GetJSON(ctx, "cameras/1/settings", &got, 0); err != nil || got != settings {
+				t.Errorf("Expected %+v, got %+v, %v", settings, got, err)
+			}
+
+			t.Run("Options", func(t *testing.T) {
+				storage.PutJSON(ctx, "cameras/2/settings.json", settings, nil, JSONOptions{Indent: true})
+				data, _, _ := storage.DownloadBytes(ctx, "cameras/2/settings.json")
+				if !strings.Contains(string(data), "\n  \"name\": \"lobby\"") {
+					t.Errorf("Expected indented JSON, got %q", data)
+				}
+
+				storage.PutJSON(ctx, "cameras/3/settings.json", settings, nil, JSONOptions{Gzip: true})
+				var got cameraSettings
+				if _, err := storage.GetJSON(ctx, "cameras/3/settings.json", &got, 0); err != nil || got != settings {
+					t.Errorf("Expected the gzip document to be decoded, got %+v, %v", got, err)
+				}
+			})
+
+			t.Run("Malformed", func(t *testing.T) {
+				storage.Upload(ctx, "cameras/4/settings.json", strings.NewReader(`{"name": `), nil)
+				_, err := storage.GetJSON(ctx, "cameras/4/settings.json", &got, 0)
+				var syntaxErr *json.SyntaxError
+				if !errors.Is(err, ErrInvalidJSON) || !errors.As(err, &syntaxErr) {
+					t.Errorf("Expected ErrInvalidJSON caused by a *json.SyntaxError, got %v", err)
+				}
+
+				storage.Upload(ctx, "cameras/4/snapshot.jpg", strings.NewReader(`{}`), &FileMetadata{ContentType: "image/jpeg"})
+				if _, err := storage.GetJSON(ctx, "cameras/4/snapshot.jpg", &got, 0); !errors.Is(err, ErrInvalidJSON) {
+					t.Errorf("Expected ErrInvalidJSON for an image, got %v", err)
+				}
+			})
+
+			t.Run("TooLarge", func(t *testing.T) {
+				if _, err := storage.GetJSON(ctx, "cameras/1/settings", &got, 8); !errors.Is(err, ErrFileTooLarge) {
+					t.Errorf("Expected ErrFileTooLarge, got %v", err)
+				}
+				large := map[string]string{"padding": strings.Repeat("a", 1000)}
+				storage.PutJSON(ctx, "cameras/5/large.json", large, nil, JSONOptions{Gzip: true})
+				if _, err := storage.GetJSON(ctx, "cameras/5/large.json", &large, 100); !errors.Is(err, ErrFileTooLarge) {
+					t.Errorf("Expected the cap to apply to the decompressed document, got %v", err)
+				}
+			})
+		})
+	}
+}
+
+func TestUpdateJSON(t *testing.T) {
+	ctx := context.Background()
+	for _, provider := range []string{"memory", "filesystem"} {
+		t.Run(provider, func(t *testing.T) {
+			storage, err := New(&StorageConfig{Name: "test", Provider: provider, FileSystem: &FileSystemConfig{BasePath: t.TempDir()}})
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			increment := func(current json.RawMessage) (json.RawMessage, error) {
+				var state struct{ Count int }
+				if current != nil {
+					if err := json.Unmarshal(current, &state); err != nil {
+						return nil, err
+					}
+				}
+				state.Count++
+				return json.Marshal(state)
+			}
+
+			// Concurrent writers each get their increment in
+			var wg sync.WaitGroup
+			for range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := storage.UpdateJSON(ctx, "cameras/1/state.json", 20, increment); err != nil {
+						t.Errorf("UpdateJSON failed: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			var state struct{ Count int }
+			if _, err := storage.GetJSON(ctx, "cameras/1/state.json", &state, 0); err != nil || state.Count != 8 {
+				t.Errorf("Expected 8 increments, got %d, %v", state.Count, err)
+			}
+
+			t.Run("Precondition", func(t *testing.T) {
+				info, _ := storage.GetInfo(ctx, "cameras/1/state.json")
+				if _, err := storage.UploadIfMatch(ctx, "cameras/1/state.json", strings.NewReader("{}"), nil, "stale"); !errors.Is(err, ErrPreconditionFailed) {
+					t.Errorf("Expected ErrPreconditionFailed for a stale ETag, got %v", err)
+				}
+				if _, err := storage.UploadIfMatch(ctx, "cameras/1/state.json", strings.NewReader("{}"), nil, ""); !errors.Is(err, ErrPreconditionFailed) {
+					t.Errorf("Expected ErrPreconditionFailed when creating an existing file, got %v", err)
+				}
+				if _, err := storage.UploadIfMatch(ctx, "cameras/1/state.json", strings.NewReader("{}"), nil, info.ETag); err != nil {
+					t.Errorf("Expected the current ETag to match, got %v", err)
+				}
+			})
+
+			t.Run("Retries", func(t *testing.T) {
+				calls := 0
+				_, err := storage.UpdateJSON(ctx, "cameras/2/state.json", 2, func(current json.RawMessage) (json.RawMessage, error) {
+					calls++
+					storage.Upload(ctx, "cameras/2/state.json", strings.NewReader(fmt.Sprintf(`{"Count": %d}`, calls)), nil)
+					return json.RawMessage(`{"Count": 0}`), nil
+				})
+				if !errors.Is(err, ErrPreconditionFailed) || calls != 3 {
+					t.Errorf("Expected ErrPreconditionFailed after 3 attempts, got %v after %d", err, calls)
+				}
+			})
+		})
+	}
+
+	t.Run("WithoutChecksum", func(t *testing.T) {
+		disabled := false
+		storage, _ := New(&StorageConfig{Name: "test", Provider: "memory", ComputeChecksum: &disabled})
+		storage.PutJSON(ctx, "state.json", map[string]int{"Count": 1}, nil)
+		_, err := storage.UpdateJSON(ctx, "state.json", 3, func(current json.RawMessage) (json.RawMessage, error) {
+			return current, nil
+		})
+		if !errors.Is(err, ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported without ETags, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package vsaasstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PathLayout places an uploaded file under its destination directory: it returns the
+// path of the file named filename uploaded to dir at now. Without a layout, uploads are
+// stored flat as dir/filename.
+type PathLayout func(dir, filename string, now time.Time) string
+
+// Templates of the built-in layouts, for ParsePathLayout and StorageConfig.UploadLayout
+const (
+	LayoutFlat = "{dir}/{filename}"
+	LayoutDate = "{dir}/{yyyy}/{mm}/{dd}/{filename}" // One directory per day
+	LayoutHash = "{dir}/{hash2}/{filename}"          // 256 directories by the hash of the name
+)
+
+// layoutPlaceholder matches the placeholders of a layout template
+var layoutPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// ParsePathLayout builds a PathLayout from a template. {dir} is the destination directory
+// and {filename} the stored name, which must be the last segment. {yyyy}, {mm}, {dd} and
+// {hh} are the upload time in UTC, and {hash2} and {hash4} the first 2 or 4 hex digits of
+// the SHA-256 of the name, which spread uploads evenly over 256 or 65536 directories.
+func ParsePathLayout(template string) (PathLayout, error) {
+	if !strings.HasSuffix(template, "/{filename}") && template != "{filename}" {
+		return nil, fmt.Errorf("path layout %q must end with the {filename} segment", template)
+	}
+	for _, placeholder := range layoutPlaceholder.FindAllString(template, -1) {
+		switch placeholder {
+		case "{dir}", "{yyyy}", "{mm}", "{dd}", "{hh}", "{hash2}", "{hash4}":
+		case "{filename}":
+			if strings.Count(template, placeholder) > 1 {
+				return nil, fmt.Errorf("path layout %q has more than one {filename}", template)
+			}
+		default:
+			return nil, fmt.Errorf("unknown placeholder %s in path layout %q", placeholder, template)
+		}
+	}
+
+	return func(dir, filename string, now time.Time) string {
+		now = now.UTC()
+		sum := sha256.Sum256([]byte(filename))
+		hash := hex.EncodeToString(sum[:2])
+		return path.Clean(strings.NewReplacer(
+			"{dir}", strings.TrimSuffix(dir, "/"),
+			"{filename}", filename,
+			"{yyyy}", now.Format("2006"),
+			"{mm}", now.Format("01"),
+			"{dd}", now.Format("02"),
+			"{hh}", now.Format("15"),
+			"{hash2}", hash[:2],
+			"{hash4}", hash,
+		).Replace(template))
+	}, nil
+}
+
+// uploadPathLayout returns the layout of UploadLayout, or nil for the flat layout.
+// Validate has already checked the template.
+func (c *StorageConfig) uploadPathLayout() PathLayout {
+	if c.UploadLayout == "" {
+		return nil
+	}
+	layout, _ := ParsePathLayout(c.UploadLayout)
+	return layout
+}
+
+// uploadPath returns where an uploaded file named fileName is stored under dir, through
+// the layout of the options or of the configuration
+func (s *Storage) uploadPath(dir, fileName string, opts UploadOptions, now time.Time) string {
+	layout := opts.PathLayout
+	if layout == nil {
+		layout = s.config.uploadPathLayout()
+	}
+	if layout == nil {
+		return s.config.normalizePath(fmt.Sprintf("%s/%s", strings.TrimSuffix(dir, "/"), fileName))
+	}
+	return s.config.normalizePath(layout(dir, fileName, now))
+}
@@ -0,0 +1,96 @@
+package vsaasstorage
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestParsePathLayout(t *testing.T) {
+	now := time.Date(2024, 3, 7, 23, 30, 0, 0, time.FixedZone("CLT", -3*3600))
+
+	tests := []struct {
+		template, dir, want string
+	}{
+		{LayoutFlat, "uploads", "uploads/photo.jpg"},
+		{LayoutDate, "uploads/", "uploads/2024/03/08/photo.jpg"}, // In UTC
+		{"{dir}/{yyyy}/{mm}/{dd}/{hh}/{filename}", "cams/1", "cams/1/2024/03/08/02/photo.jpg"},
+		{LayoutHash, "uploads", "uploads/af/photo.jpg"},
+		{"{dir}/{hash4}/{filename}", "uploads", "uploads/aff6/photo.jpg"},
+		{"archive/{yyyy}/{filename}", "ignored", "archive/2024/photo.jpg"},
+		{LayoutDate, "", "2024/03/08/photo.jpg"},
+	}
+	for _, tt := range tests {
+		layout, err := ParsePathLayout(tt.template)
+		if err != nil {
+			t.Fatalf("ParsePathLayout(%q) failed: %v", tt.template, err)
+		}
+		if got := strings.TrimPrefix(layout(tt.dir, "photo.jpg", now), "/"); got != tt.want {
+			t.Errorf("%s in %q: expected %s, got %s", tt.template, tt.dir, tt.want, got)
+		}
+	}
+
+	for _, template := range []string{"{dir}/{yyyy}", "{filename}/{dir}", "{dir}/{week}/{filename}", "{dir}/{filename}/{filename}", "{dir}/x{filename}"} {
+		if _, err := ParsePathLayout(template); err == nil {
+			t.Errorf("Expected %q to be refused", template)
+		}
+	}
+}
+
+func TestUploadLayout(t *testing.T) {
+	ctx := context.Background()
+	source := filepath.Join(t.TempDir(), "photo.jpg")
+	os.WriteFile(source, []byte("photo"), 0644)
+	file := &rest.UploadedFile{Path: source, Filename: "photo.jpg", OriginalName: "photo.jpg", MimeType: "image/jpeg"}
+
+	config := &StorageConfig{
+		Name:         "test",
+		Provider:     "filesystem",
+		FileSystem:   &FileSystemConfig{BasePath: t.TempDir()},
+		UploadLayout: LayoutDate,
+	}
+	storage, err := New(config)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	t.Run("Configured", func(t *testing.T) {
+		result, err := storage.UploadFromUploadedFile(ctx, file, "file", "uploads")
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		today := time.Now().UTC().Format("2006/01/02")
+		if path.Dir(result.Path) != "uploads/"+today || path.Base(result.Path) != result.Filename {
+			t.Errorf("Expected %s under uploads/%s, got %s", result.Filename, today, result.Path)
+		}
+		if exists, _ := storage.Exists(ctx, result.Path); !exists {
+			t.Errorf("Expected the file at %s", result.Path)
+		}
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		hash, _ := ParsePathLayout(LayoutHash)
+		result, err := storage.UploadFromUploadedFileWithOptions(ctx, file, "file", "uploads", UploadOptions{Filename: "named", PathLayout: hash})
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		if !regexp.MustCompile(`^uploads/[0-9a-f]{2}/named\.jpg$`).MatchString(result.Path) {
+			t.Errorf("Expected the hash layout to place the explicit name, got %s", result.Path)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		invalid := *config
+		invalid.UploadLayout = "{dir}/{yyyy}"
+		if err := invalid.Validate(); err == nil {
+			t.Error("Expected a layout without {filename} to be refused")
+		}
+	})
+}
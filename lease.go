@@ -0,0 +1,322 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LeaseSuffix is appended to a path to name the lock object of its lease
+const LeaseSuffix = ".lease"
+
+// DefaultLeaseClockSkew is the clock skew tolerated between lease owners when
+// StorageConfig.LeaseClockSkew is not set
+const DefaultLeaseClockSkew = 5 * time.Second
+
+// maxLeaseSize caps the lock objects read by AcquireLease
+const maxLeaseSize = 64 << 10
+
+// leaseClockSkew returns the configured clock skew tolerance of leases
+func (c *StorageConfig) leaseClockSkew() time.Duration {
+	if c.LeaseClockSkew > 0 {
+		return c.LeaseClockSkew
+	}
+	return DefaultLeaseClockSkew
+}
+
+// leaseRecord is the content of a lock object. The expiry is absolute, so owners on
+// other hosts judge it with their own clock plus the configured skew.
+type leaseRecord struct {
+	Owner     string    `json:"owner"`
+	Token     string    `json:"token"` // Tells acquisitions by the same owner apart
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Lease is a cooperative lock on a storage path, held by one owner at a time. It is
+// renewed in the background every third of its TTL until Release; if a renewal fails,
+// Done is closed and Err reports why, and the holder must stop working on the path.
+type Lease struct {
+	Path  string // Path the lease is on; its lock object is Path + LeaseSuffix
+	Owner string
+
+	storage *Storage
+	ttl     time.Duration
+	token   string
+
+	mu        sync.Mutex // Serializes renewals and Release
+	etag      string
+	expiresAt time.Time
+
+	done chan struct{}
+	stop chan struct{}
+	end  sync.Once
+	err  error
+}
+
+// AcquireLease takes the lease on path for ttl on behalf of owner. The lease is stored
+// as a lock object created atomically with UploadIfMatch, so only one of several
+// contenders succeeds; the others fail with ErrLeaseHeld. A lease that was not renewed
+// is taken over once its expiry plus LeaseClockSkew has passed, by replacing its lock
+// object if it still has the ETag read. Every replacement first claims the version it
+// replaces (see claimVersion), so takeovers are exclusive also among processes sharing a
+// directory. Renewal stops with Release, not with ctx.
+func (s *Storage) AcquireLease(ctx context.Context, path string, ttl time.Duration, owner string) (*Lease, error) {
+	if ttl <= 0 {
+		return nil, NewStorageError(ErrorCodeInvalidConfig, "lease ttl must be positive")
+	}
+	path = s.config.normalizePath(path)
+	if path == "" {
+		return nil, InvalidPathError(path)
+	}
+
+	token := make([]byte, 16)
+	rand.Read(token)
+	lease := &Lease{
+		Path:    path,
+		Owner:   owner,
+		storage: s,
+		ttl:     ttl,
+		token:   hex.EncodeToString(token),
+		done:    make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+
+	lockPath := path + LeaseSuffix
+	current, info, err := s.readLease(ctx, lockPath)
+	etag := ""
+	switch {
+	case errors.Is(err, ErrFileNotFound):
+	case errors.Is(err, ErrInvalidJSON):
+		// A lock object being created or left half-written by a crash has no owner; it
+		// is removed once it is older than a lease could be
+		if info.LastModified != nil && s.config.now().Sub(*info.LastModified) <= ttl+s.config.leaseClockSkew() {
+			return nil, LeaseHeldError(path, "", info.LastModified.Add(ttl))
+		}
+		if err := s.removeLock(ctx, lockPath, info.ETag); err != nil && !errors.Is(err, ErrFileNotFound) {
+			if errors.Is(err, ErrPreconditionFailed) {
+				return nil, LeaseHeldError(path, "", time.Time{})
+			}
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case s.config.now().Before(current.ExpiresAt.Add(s.config.leaseClockSkew())):
+		return nil, LeaseHeldError(path, current.Owner, current.ExpiresAt)
+	case info.ETag == "":
+		return nil, NotSupportedError("lease has no ETag to compare; enable ComputeChecksum")
+	default:
+		etag = info.ETag
+	}
+
+	if err := lease.write(ctx, etag); err != nil {
+		if errors.Is(err, ErrPreconditionFailed) {
+			// Another owner acquired or renewed it since it was read
+			if current, _, err := s.readLease(ctx, lockPath); err == nil {
+				return nil, LeaseHeldError(path, current.Owner, current.ExpiresAt)
+			}
+			return nil, LeaseHeldError(path, "", time.Time{})
+		}
+		return nil, err
+	}
+
+	go lease.renewLoop(context.WithoutCancel(ctx))
+	return lease, nil
+}
+
+// readLease reads the lock object at lockPath. Objects that are not a lease fail with
+// ErrInvalidJSON and are returned with their info.
+func (s *Storage) readLease(ctx context.Context, lockPath string) (*leaseRecord, *FileInfo, error) {
+	reader, info, err := s.Download(ctx, lockPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxLeaseSize))
+	if err != nil {
+		return nil, nil, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to read lease", err)
+	}
+	record := &leaseRecord{}
+	if err := json.Unmarshal(data, record); err != nil || record.Token == "" {
+		return nil, info, NewStorageErrorWithPath(ErrorCodeInvalidJSON, "not a lease", lockPath)
+	}
+	return record, info, nil
+}
+
+// claimVersion claims the version of the lock object at lockPath with etag before it is
+// replaced or removed, by creating a claim object exclusively. UploadIfMatch serializes
+// replacements within the process only, while the claim is created with O_EXCL on the
+// filesystem, so of several processes replacing the same version only one goes on and the
+// others fail with ErrPreconditionFailed. The returned release gives the claim up; a
+// process that crashes while holding it blocks that version until CleanupOrphans.
+func (s *Storage) claimVersion(ctx context.Context, lockPath, etag string) (func(), error) {
+	provider, ok := providerAs[ConditionalProvider](s.provider)
+	if !ok {
+		return nil, NotSupportedError("provider does not support conditional uploads")
+	}
+	claim := s.extensionPath(claimPath(lockPath + "@" + etag))
+	if _, err := provider.UploadIfMatch(ctx, claim, strings.NewReader(""), nil, ""); err != nil {
+		return nil, err
+	}
+	return func() {
+		// The claim must go even when the write was canceled
+		s.provider.Delete(context.WithoutCancel(ctx), claim)
+	}, nil
+}
+
+// removeLock removes the lock object at lockPath if it still has etag, failing with
+// ErrPreconditionFailed otherwise. Lock objects are internal, so they skip the trash and
+// the audit of user deletes.
+func (s *Storage) removeLock(ctx context.Context, lockPath, etag string) error {
+	release, err := s.claimVersion(ctx, lockPath, etag)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	info, err := s.provider.GetInfo(ctx, lockPath)
+	if err != nil {
+		return err
+	}
+	if info.ETag != etag {
+		return PreconditionFailedError(lockPath)
+	}
+	return s.provider.Delete(ctx, lockPath)
+}
+
+// write stores the lease with a new expiry if its lock object still has etag. Replacing
+// an existing version claims it first, so a write never races a takeover or a removal in
+// another process.
+func (l *Lease) write(ctx context.Context, etag string) error {
+	if etag != "" {
+		release, err := l.storage.claimVersion(ctx, l.Path+LeaseSuffix, etag)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	record := leaseRecord{Owner: l.Owner, Token: l.token, ExpiresAt: l.storage.config.now().Add(l.ttl).UTC()}
+	data, _ := json.Marshal(record)
+	info, err := l.storage.UploadIfMatch(ctx, l.Path+LeaseSuffix, bytes.NewReader(data), &FileMetadata{ContentType: JSONContentType}, etag)
+	if err != nil {
+		return err
+	}
+	l.etag, l.expiresAt = info.ETag, record.ExpiresAt
+	return nil
+}
+
+// Renew extends the lease by its TTL from now. It fails with ErrLeaseLost if the lease
+// was released or taken over, which also closes Done.
+func (l *Lease) Renew(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.ended() {
+		return l.lostError()
+	}
+	err := l.write(ctx, l.etag)
+	if errors.Is(err, ErrPreconditionFailed) || errors.Is(err, ErrFileNotFound) {
+		err = LeaseLostError(l.Path)
+		l.finish(err)
+	}
+	return err
+}
+
+// Release gives up the lease and removes its lock object, stopping renewals. It fails
+// with ErrLeaseLost if the lease had already been taken over.
+func (l *Lease) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.ended() {
+		return l.lostError()
+	}
+	l.finish(nil)
+
+	err := l.storage.removeLock(ctx, l.Path+LeaseSuffix, l.etag)
+	if errors.Is(err, ErrPreconditionFailed) || errors.Is(err, ErrFileNotFound) {
+		return LeaseLostError(l.Path)
+	}
+	return err
+}
+
+// Done returns a channel closed when the lease ends, either by Release or because it
+// could not be renewed
+func (l *Lease) Done() <-chan struct{} {
+	return l.done
+}
+
+// Err returns why the lease ended: nil while it is held or after Release, or the error
+// of the renewal that failed
+func (l *Lease) Err() error {
+	select {
+	case <-l.done:
+		return l.err
+	default:
+		return nil
+	}
+}
+
+// ExpiresAt returns the expiry of the lease as of its last renewal
+func (l *Lease) ExpiresAt() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.expiresAt
+}
+
+// renewLoop renews the lease every third of its TTL until it ends. A renewal that fails
+// for any reason ends the lease, as the next one may come too late to keep it.
+func (l *Lease) renewLoop(ctx context.Context) {
+	clock := l.storage.config.clock()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-clock.After(max(l.ttl/3, time.Millisecond)):
+			renewCtx, cancel := context.WithTimeout(ctx, l.ttl/3)
+			err := l.Renew(renewCtx)
+			cancel()
+			if err != nil {
+				l.mu.Lock()
+				l.finish(err)
+				l.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// ended reports whether the lease was released or lost. Callers hold mu.
+func (l *Lease) ended() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// lostError returns the error of operations on a lease that already ended
+func (l *Lease) lostError() error {
+	if l.err != nil {
+		return l.err
+	}
+	return LeaseLostError(l.Path)
+}
+
+// finish ends the lease with err. Callers hold mu.
+func (l *Lease) finish(err error) {
+	l.end.Do(func() {
+		l.err = err
+		close(l.stop)
+		close(l.done)
+	})
+}
@@ -0,0 +1,217 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquireLease(t *testing.T) {
+	ctx := context.Background()
+	for _, provider := range []string{"memory", "filesystem"} {
+		t.Run(provider, func(t *testing.T) {
+			storage, err := New(&StorageConfig{Name: "test", Provider: provider, FileSystem: &FileSystemConfig{BasePath: t.TempDir()}})
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			// Two workers contend for the same export
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			leases := make([]*Lease, 2)
+			errs := make([]error, 2)
+			for i, owner := range []string{"worker-a", "worker-b"} {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					leases[i], errs[i] = storage.AcquireLease(ctx, "exports/batch-1", time.Minute, owner)
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			winner, loser := 0, 1
+			if errs[0] != nil {
+				winner, loser = 1, 0
+			}
+			if errs[winner] != nil || !errors.Is(errs[loser], ErrLeaseHeld) {
+				t.Fatalf("Expected exactly one lease, got %v and %v", errs[0], errs[1])
+			}
+			if !strings.Contains(errs[loser].Error(), leases[winner].Owner) {
+				t.Errorf("Expected the holder in the error, got %v", errs[loser])
+			}
+
+			if err := leases[winner].Release(ctx); err != nil {
+				t.Fatalf("Release failed: %v", err)
+			}
+			if exists, _ := storage.Exists(ctx, "exports/batch-1"+LeaseSuffix); exists {
+				t.Error("Expected Release to remove the lock object")
+			}
+			if err := leases[winner].Err(); err != nil {
+				t.Errorf("Expected no error after Release, got %v", err)
+			}
+			if err := leases[winner].Release(ctx); !errors.Is(err, ErrLeaseLost) {
+				t.Errorf("Expected ErrLeaseLost releasing twice, got %v", err)
+			}
+
+			lease, err := storage.AcquireLease(ctx, "exports/batch-1", time.Minute, "worker-b")
+			if err != nil {
+				t.Fatalf("Expected the released lease to be free, got %v", err)
+			}
+			expiresAt := lease.ExpiresAt()
+			time.Sleep(10 * time.Millisecond)
+			if err := lease.Renew(ctx); err != nil || !lease.ExpiresAt().After(expiresAt) {
+				t.Errorf("Expected Renew to extend the lease, got %v", err)
+			}
+			lease.Release(ctx)
+		})
+	}
+}
+
+func TestLeaseTakeover(t *testing.T) {
+	ctx := context.Background()
+	storage, _ := New(&StorageConfig{Name: "test", Provider: "memory", LeaseClockSkew: time.Minute})
+
+	// The holder's clock may be behind, so a lease just past its expiry is still honored
+	stale := leaseRecord{Owner: "worker-a", Token: "t", ExpiresAt: time.Now().Add(-30 * time.Second)}
+	storage.PutJSON(ctx, "exports/batch-1"+LeaseSuffix, stale, nil)
+	if _, err := storage.AcquireLease(ctx, "exports/batch-1", time.Minute, "worker-b"); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("Expected the lease to be held within the clock skew, got %v", err)
+	}
+
+	stale.ExpiresAt = time.Now().Add(-2 * time.Minute)
+	storage.PutJSON(ctx, "exports/batch-1"+LeaseSuffix, stale, nil)
+	lease, err := storage.AcquireLease(ctx, "exports/batch-1", time.Minute, "worker-b")
+	if err != nil {
+		t.Fatalf("Expected an expired lease to be taken over, got %v", err)
+	}
+	defer lease.Release(ctx)
+
+	var record leaseRecord
+	storage.GetJSON(ctx, "exports/batch-1"+LeaseSuffix, &record, 0)
+	if record.Owner != "worker-b" || !record.ExpiresAt.After(time.Now()) {
+		t.Errorf("Expected the lock object of the new owner, got %+v", record)
+	}
+}
+
+func TestLeaseDone(t *testing.T) {
+	ctx := context.Background()
+	storage, _ := New(&StorageConfig{Name: "test", Provider: "memory"})
+
+	lease, err := storage.AcquireLease(ctx, "exports/batch-1", 60*time.Millisecond, "worker-a")
+	if err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+
+	// Background renewals keep the lease alive past its TTL
+	time.Sleep(100 * time.Millisecond)
+	if lease.Err() != nil {
+		t.Fatalf("Expected the lease to be renewed, got %v", lease.Err())
+	}
+
+	// Someone else replaces the lock object, so the next renewal fails
+	for {
+		info, _ := storage.GetInfo(ctx, "exports/batch-1"+LeaseSuffix)
+		_, err := storage.UploadIfMatch(ctx, "exports/batch-1"+LeaseSuffix, strings.NewReader(`{"owner":"intruder","token":"x"}`), nil, info.ETag)
+		if err == nil {
+			break
+		}
+	}
+	select {
+	case <-lease.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected Done to be closed when renewal fails")
+	}
+	if !errors.Is(lease.Err(), ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost, got %v", lease.Err())
+	}
+	if err := lease.Release(ctx); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected Release of a lost lease to fail, got %v", err)
+	}
+}
+
+func TestLeaseSkipsTrash(t *testing.T) {
+	ctx := context.Background()
+	storage := newTrashStorage(t, "filesystem")
+
+	lease, err := storage.AcquireLease(ctx, "exports/batch-1", time.Minute, "worker-a")
+	if err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+	if err := lease.Release(ctx); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	// The lock object is internal, so removing it must not fill the trash
+	batches, err := storage.ListWithOptions(ctx, trashPrefix, ListOptions{IncludeTrash: true, AllowMissing: true})
+	if err != nil {
+		t.Fatalf("Failed to list the trash: %v", err)
+	}
+	if len(batches) != 0 {
+		t.Errorf("Expected an empty trash, got %d batches", len(batches))
+	}
+}
+
+func TestLeaseTakeoverAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	basePath := t.TempDir()
+	storages := make([]*Storage, 2)
+	for i := range storages {
+		storage, err := New(&StorageConfig{Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: basePath}})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		storages[i] = storage
+	}
+	lockPath := "exports/batch-1" + LeaseSuffix
+	stale := leaseRecord{Owner: "worker-a", Token: "t", ExpiresAt: time.Now().Add(-time.Hour)}
+
+	// A process in the middle of taking over holds the claim on the version it replaces,
+	// so the other process backs off
+	info, err := storages[0].PutJSON(ctx, lockPath, stale, nil)
+	if err != nil {
+		t.Fatalf("PutJSON failed: %v", err)
+	}
+	release, err := storages[0].claimVersion(ctx, lockPath, info.ETag)
+	if err != nil {
+		t.Fatalf("claimVersion failed: %v", err)
+	}
+	if _, err := storages[1].AcquireLease(ctx, "exports/batch-1", time.Minute, "worker-b"); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("Expected ErrLeaseHeld while the version is claimed, got %v", err)
+	}
+	release()
+
+	// Contenders on both instances race for the expired lease; exactly one gets it
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var leases []*Lease
+	start := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			lease, err := storages[i%2].AcquireLease(ctx, "exports/batch-1", time.Minute, "worker")
+			if err == nil {
+				mu.Lock()
+				leases = append(leases, lease)
+				mu.Unlock()
+			} else if !errors.Is(err, ErrLeaseHeld) {
+				t.Errorf("Expected ErrLeaseHeld, got %v", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if len(leases) != 1 {
+		t.Fatalf("Expected exactly one takeover, got %d", len(leases))
+	}
+	if err := leases[0].Release(ctx); err != nil {
+		t.Errorf("Release failed: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package vsaasstorage
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// DefaultListDetailsConcurrency is the number of parallel GetInfo calls or checksums used
+// to complete a listing when StorageConfig.ListDetailsConcurrency is not set
+const DefaultListDetailsConcurrency = 16
+
+// ListDetailsProvider is implemented by providers whose listings already carry ETags and
+// custom metadata, or that can add them cheaper than a GetInfo per file
+type ListDetailsProvider interface {
+	FillDetails(ctx context.Context, files []*FileInfo, opts ListOptions) error
+}
+
+// listDetailsConcurrency returns the configured fan-out of listings with details
+func (c *StorageConfig) listDetailsConcurrency() int {
+	if c.ListDetailsConcurrency > 0 {
+		return c.ListDetailsConcurrency
+	}
+	return DefaultListDetailsConcurrency
+}
+
+// fillDetails adds the ETags and metadata requested by opts to listed files. Providers
+// without ListDetailsProvider get a GetInfo per file missing them, which is a HEAD request
+// per object on S3, so the calls are bounded by ListDetailsConcurrency.
+func (s *Storage) fillDetails(ctx context.Context, files []*FileInfo, opts ListOptions) error {
+	if provider, ok := providerAs[ListDetailsProvider](s.provider); ok {
+		return provider.FillDetails(ctx, files, opts)
+	}
+
+	missing := make(map[string]*FileInfo)
+	var paths []string
+	for _, file := range files {
+		if file.IsDirectory {
+			continue
+		}
+		if (opts.IncludeETags && file.ETag == "") || (opts.IncludeMetadata && file.Metadata == nil) {
+			missing[file.Path] = file
+			paths = append(paths, file.Path)
+		}
+	}
+
+	// Each call writes to its own FileInfo
+	return NewParallelExecutor(s.config.listDetailsConcurrency()).Run(ctx, paths, func(ctx context.Context, path string) error {
+		info, err := s.provider.GetInfo(ctx, path)
+		if err != nil {
+			if errors.Is(err, ErrFileNotFound) {
+				return nil // Deleted since it was listed
+			}
+			return err
+		}
+		file := missing[path]
+		if opts.IncludeETags {
+			file.ETag = info.ETag
+		}
+		if opts.IncludeMetadata {
+			file.Metadata = info.Metadata
+		}
+		return nil
+	})
+}
+
+// FillDetails completes a listing. Listed files already carry the checksum and metadata
+// cached in their sidecars; with ComputeETags the files without a valid cached checksum
+// are hashed and the result is cached.
+func (p *FileSystemProvider) FillDetails(ctx context.Context, files []*FileInfo, opts ListOptions) error {
+	if !opts.IncludeETags || !opts.ComputeETags {
+		return nil
+	}
+
+	missing := make(map[string]*FileInfo)
+	var paths []string
+	for _, file := range files {
+		if !file.IsDirectory && file.ETag == "" {
+			missing[file.Path] = file
+			paths = append(paths, file.Path)
+		}
+	}
+
+	return NewParallelExecutor(p.config.listDetailsConcurrency()).Run(ctx, paths, func(ctx context.Context, path string) error {
+		etag, err := p.computeETag(path)
+		if err != nil {
+			return err
+		}
+		missing[path].ETag = etag
+		return nil
+	})
+}
+
+// computeETag hashes a file and caches the checksum in its sidecar, unless the file
+// changed while it was read
+func (p *FileSystemProvider) computeETag(path string) (string, error) {
+	fullPath, err := p.getFullPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return "", fileSystemError(err, path, ErrorCodeInternalError, "failed to open file")
+	}
+	defer file.Close()
+
+	before, err := file.Stat()
+	if err != nil {
+		return "", fileSystemError(err, path, ErrorCodeInternalError, "failed to get file stats")
+	}
+	hash := md5.New()
+	if _, err := copyBuffer(hash, file, p.config.GetCopyBufferSize()); err != nil {
+		return "", fileSystemError(err, path, ErrorCodeInternalError, "failed to read file")
+	}
+	etag := fmt.Sprintf("%x", hash.Sum(nil))
+
+	after, err := os.Stat(fullPath)
+	if err == nil && os.SameFile(before, after) && after.Size() == before.Size() && after.ModTime().Equal(before.ModTime()) {
+		sidecar := readSidecar(fullPath)
+		if sidecar == nil {
+			sidecar = &fileSidecar{}
+		}
+		sidecar.setETag(etag, after)
+		if err := writeSidecar(fullPath, sidecar); err != nil {
+			return "", fileSystemError(err, path, ErrorCodeInternalError, "failed to write metadata")
+		}
+	}
+	return etag, nil
+}
+
+// FillDetails does nothing: listed objects already carry their ETag and metadata
+func (p *MemoryProvider) FillDetails(ctx context.Context, files []*FileInfo, opts ListOptions) error {
+	return nil
+}
@@ -0,0 +1,125 @@
+package vsaasstorage
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// bareListProvider lists entries without ETags or metadata, like an object store whose
+// listing omits them, and tracks the GetInfo calls made to complete it
+type bareListProvider struct {
+	StorageProvider
+
+	calls, inFlight, maxInFlight atomic.Int32
+}
+
+func (p *bareListProvider) List(ctx context.Context, path string) ([]*FileInfo, error) {
+	files, err := p.StorageProvider.List(ctx, path)
+	for _, file := range files {
+		file.ETag = ""
+		file.Metadata = nil
+	}
+	return files, err
+}
+
+func (p *bareListProvider) GetInfo(ctx context.Context, path string) (*FileInfo, error) {
+	p.calls.Add(1)
+	current := p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+	for {
+		peak := p.maxInFlight.Load()
+		if current <= peak || p.maxInFlight.CompareAndSwap(peak, current) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return p.StorageProvider.GetInfo(ctx, path)
+}
+
+func TestListDetails(t *testing.T) {
+	ctx := context.Background()
+	metadata := &FileMetadata{CustomMetadata: map[string]string{"camera": "7"}}
+
+	t.Run("Filesystem", func(t *testing.T) {
+		storage, err := New(&StorageConfig{
+			Name:       "test",
+			Provider:   "filesystem",
+			FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+
+		uploaded, err := storage.Upload(ctx, "events/a.log", strings.NewReader("first\n"), metadata)
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+
+		files, err := storage.ListWithOptions(ctx, "events", ListOptions{IncludeETags: true, IncludeMetadata: true})
+		if err != nil || len(files) != 1 {
+			t.Fatalf("List failed: %v, %v", files, err)
+		}
+		if files[0].ETag != uploaded.ETag || files[0].Metadata["camera"] != "7" {
+			t.Errorf("Expected the cached ETag and metadata, got %q and %v", files[0].ETag, files[0].Metadata)
+		}
+
+		// Appending invalidates the cached checksum until it is computed again
+		if _, err := storage.Append(ctx, "events/a.log", strings.NewReader("second\n")); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		files, _ = storage.ListWithOptions(ctx, "events", ListOptions{IncludeETags: true})
+		if files[0].ETag != "" {
+			t.Errorf("Expected no ETag for a changed file, got %q", files[0].ETag)
+		}
+
+		expected := fmt.Sprintf("%x", md5.Sum([]byte("first\nsecond\n")))
+		files, _ = storage.ListWithOptions(ctx, "events", ListOptions{IncludeETags: true, ComputeETags: true})
+		if files[0].ETag != expected {
+			t.Errorf("Expected the computed ETag %q, got %q", expected, files[0].ETag)
+		}
+		if info, _ := storage.GetInfo(ctx, "events/a.log"); info.ETag != expected {
+			t.Errorf("Expected the computed ETag to be cached, got %q", info.ETag)
+		}
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		provider := &bareListProvider{}
+		RegisterProvider("bare-list", func(config *StorageConfig) (StorageProvider, error) {
+			inner, err := NewMemoryProvider(config)
+			provider.StorageProvider = inner
+			return provider, err
+		})
+		storage, err := New(&StorageConfig{Name: "test", Provider: "bare-list", ListDetailsConcurrency: 2})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		for i := 0; i < 6; i++ {
+			if _, err := storage.Upload(ctx, fmt.Sprintf("clips/%d.mp4", i), strings.NewReader("clip"), metadata); err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+		}
+
+		files, _ := storage.List(ctx, "clips")
+		if provider.calls.Load() != 0 || files[0].ETag != "" {
+			t.Fatal("Expected a plain listing without details")
+		}
+
+		files, err = storage.ListWithOptions(ctx, "clips", ListOptions{IncludeETags: true, IncludeMetadata: true})
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		for _, file := range files {
+			if file.ETag == "" || file.Metadata["camera"] != "7" {
+				t.Errorf("Expected details for %s, got %q and %v", file.Path, file.ETag, file.Metadata)
+			}
+		}
+		if provider.calls.Load() != 6 || provider.maxInFlight.Load() > 2 {
+			t.Errorf("Expected 6 GetInfo calls at most 2 at a time, got %d with %d in flight", provider.calls.Load(), provider.maxInFlight.Load())
+		}
+	})
+}
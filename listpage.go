@@ -0,0 +1,124 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// DefaultListPageSize is the number of entries of a page when ListPageOptions.PageSize is
+// not set
+const DefaultListPageSize = 1000
+
+// ListPageOptions configures ListPage
+type ListPageOptions struct {
+	ListOptions
+	Recursive bool   // Include the entries of subdirectories, each directory followed by its contents
+	PageSize  int    // Entries per page, defaults to DefaultListPageSize
+	PageToken string // NextPageToken of the previous page, empty for the first one
+}
+
+// ListPage is a page of a listing
+type ListPage struct {
+	Files         []*FileInfo `json:"files"`
+	NextPageToken string      `json:"nextPageToken,omitempty"` // Empty on the last page
+}
+
+// ListPage lists a directory one page at a time. Entries are ordered by path, comparing
+// one segment at a time, so the contents of a directory come right after it. A page
+// token is the path of the last entry returned rather than an offset, so it stays valid
+// when entries are added or removed between calls: the next page starts after that path
+// even if it no longer exists, and directories that vanished in between are skipped. No
+// entry is returned twice; entries created after the listing started may or may not
+// appear, depending on where they sort.
+func (s *Storage) ListPage(ctx context.Context, path string, opts ListPageOptions) (*ListPage, error) {
+	root := cleanPath(path)
+	after, err := decodePageToken(opts.PageToken, root)
+	if err != nil {
+		return nil, err
+	}
+	size := opts.PageSize
+	if size <= 0 {
+		size = DefaultListPageSize
+	}
+
+	// One more entry than the page tells whether there is a next page
+	page := &ListPage{Files: []*FileInfo{}}
+	if err := s.listPageFrom(ctx, root, after, opts, size+1, page, true); err != nil {
+		return nil, err
+	}
+	if len(page.Files) > size {
+		page.Files = page.Files[:size]
+		page.NextPageToken = encodePageToken(cleanPath(page.Files[size-1].Path))
+	}
+	return page, nil
+}
+
+// listPageFrom adds to page the entries of dir that sort after the path after, until it
+// holds limit entries. Directories whose contents all sort before after are not listed.
+func (s *Storage) listPageFrom(ctx context.Context, dir, after string, opts ListPageOptions, limit int, page *ListPage, top bool) error {
+	if err := checkContext(ctx, dir); err != nil {
+		return err
+	}
+	entries, err := s.ListWithOptions(ctx, dir, opts.ListOptions)
+	if err != nil {
+		if !top && errors.Is(err, ErrDirectoryNotFound) {
+			return nil // Deleted since its parent was listed
+		}
+		return err
+	}
+	sortByName(entries)
+
+	for _, entry := range entries {
+		if len(page.Files) >= limit {
+			return nil
+		}
+		entryPath := cleanPath(entry.Path)
+		order := comparePaths(entryPath, after)
+		if after == "" || order > 0 {
+			page.Files = append(page.Files, entry)
+		}
+		if !opts.Recursive || !entry.IsDirectory {
+			continue
+		}
+		if after != "" && order < 0 && !isWithinPrefix(after, entryPath) {
+			continue // Listed in a previous page
+		}
+		if err := s.listPageFrom(ctx, entryPath, after, opts, limit, page, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// comparePaths orders two clean paths one segment at a time, so a directory sorts right
+// before its contents and "a/b" before "a-b"
+func comparePaths(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if order := strings.Compare(as[i], bs[i]); order != 0 {
+			return order
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// encodePageToken returns the page token that resumes a listing after path
+func encodePageToken(path string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(path))
+}
+
+// decodePageToken returns the path a page token resumes after, which must be below the
+// listed directory root
+func decodePageToken(token, root string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	after := cleanPath(string(decoded))
+	if err != nil || after == "" || after == root || !isWithinPrefix(after, root) {
+		return "", NewStorageErrorWithCause(ErrorCodeInvalidPath, "invalid page token", err)
+	}
+	return after, nil
+}
@@ -0,0 +1,119 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestListPage(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir()}})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	for _, p := range []string{"cams/a/1.mp4", "cams/a/2.mp4", "cams/a-b/1.mp4", "cams/b/1.mp4", "cams/b/2.mp4", "cams/c.txt"} {
+		storage.Upload(ctx, p, strings.NewReader("x"), nil)
+	}
+
+	listAll := func(opts ListPageOptions, between func(page int)) []string {
+		var paths []string
+		for page := 0; ; page++ {
+			result, err := storage.ListPage(ctx, "cams", opts)
+			if err != nil {
+				t.Fatalf("ListPage failed: %v", err)
+			}
+			for _, file := range result.Files {
+				paths = append(paths, file.Path)
+			}
+			if result.NextPageToken == "" {
+				return paths
+			}
+			opts.PageToken = result.NextPageToken
+			if between != nil {
+				between(page)
+			}
+		}
+	}
+
+	t.Run("Order", func(t *testing.T) {
+		paths := listAll(ListPageOptions{Recursive: true, PageSize: 3}, nil)
+		expected := "cams/a cams/a/1.mp4 cams/a/2.mp4 cams/a-b cams/a-b/1.mp4 cams/b cams/b/1.mp4 cams/b/2.mp4 cams/c.txt"
+		if strings.Join(paths, " ") != expected {
+			t.Errorf("Expected %s, got %v", expected, paths)
+		}
+
+		paths = listAll(ListPageOptions{PageSize: 2}, nil)
+		if strings.Join(paths, " ") != "cams/a cams/a-b cams/b cams/c.txt" {
+			t.Errorf("Expected the direct children, got %v", paths)
+		}
+	})
+
+	t.Run("Mutations", func(t *testing.T) {
+		paths := listAll(ListPageOptions{Recursive: true, PageSize: 2}, func(page int) {
+			switch page {
+			case 0:
+				// The last returned entry and the directory being listed disappear
+				storage.DeleteDirectory(ctx, "cams/a")
+				storage.Upload(ctx, "cams/0.txt", strings.NewReader("x"), nil)
+			case 1:
+				storage.DeleteDirectory(ctx, "cams/b")
+				storage.Upload(ctx, "cams/d.txt", strings.NewReader("x"), nil)
+			}
+		})
+
+		seen := make(map[string]bool)
+		for _, p := range paths {
+			if seen[p] {
+				t.Errorf("Expected no duplicates, got %s twice in %v", p, paths)
+			}
+			seen[p] = true
+		}
+		for _, p := range []string{"cams/a", "cams/a/1.mp4", "cams/a-b/1.mp4", "cams/c.txt", "cams/d.txt"} {
+			if !seen[p] {
+				t.Errorf("Expected %s to be listed, got %v", p, paths)
+			}
+		}
+		if seen["cams/0.txt"] {
+			t.Errorf("Expected an entry sorting before the cursor to be missed, got %v", paths)
+		}
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		for _, token := range []string{"%%%", encodePageToken("other/a.txt"), encodePageToken("cams")} {
+			if _, err := storage.ListPage(ctx, "cams", ListPageOptions{PageToken: token}); !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("Expected token %q to be rejected, got %v", token, err)
+			}
+		}
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		list := func(query string) map[string]interface{} {
+			request := httptest.NewRequest(http.MethodGet, "/files?path=cams&"+query, nil)
+			recorder := httptest.NewRecorder()
+			if err := storage.ListHandler()(&rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder)}); err != nil {
+				t.Fatalf("ListHandler failed: %v", err)
+			}
+			var body map[string]interface{}
+			json.Unmarshal(recorder.Body.Bytes(), &body)
+			return body
+		}
+
+		first := list("recursive=true&page_size=1")
+		token, _ := first["nextPageToken"].(string)
+		if first["count"] != float64(1) || token == "" {
+			t.Fatalf("Expected a page with a token, got %v", first)
+		}
+		second := list("recursive=true&page_size=100&page_token=" + token)
+		if _, ok := second["nextPageToken"]; ok || second["count"] == float64(0) {
+			t.Errorf("Expected the last page, got %v", second)
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package vsaasstorage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UploadDirOptions controls UploadDirectory
+type UploadDirOptions struct {
+	// Include and Exclude are path.Match globs over the slash-separated path relative to
+	// the local directory. Patterns without a slash match the base name at any depth, so
+	// "*.ts" selects every segment. When Include is set a file must match one of its
+	// patterns; Exclude is checked afterwards and also skips whole directories.
+	Include []string
+	Exclude []string
+
+	Concurrency int  // Parallel uploads, defaults to 4
+	Checksum    bool // Compare the MD5 of files with the destination ETag instead of size and modification time
+
+	// DeleteExtraneous deletes destination files under the prefix that have no local
+	// counterpart, making the upload a push-style sync. Files left out by the globs are
+	// never deleted.
+	DeleteExtraneous bool
+}
+
+// UploadDirReport summarizes an UploadDirectory
+type UploadDirReport struct {
+	Uploaded int               `json:"uploaded"`
+	Skipped  int               `json:"skipped"` // Already up to date at the destination
+	Deleted  int               `json:"deleted"`
+	Bytes    int64             `json:"bytes"` // Bytes uploaded
+	Failed   []TransferFailure `json:"failed,omitempty"`
+}
+
+// localFile is a file found under the local directory of UploadDirectory
+type localFile struct {
+	path    string // Local path
+	size    int64
+	modTime time.Time
+}
+
+// UploadDirectory uploads the files of a local directory tree under destinationPrefix,
+// keeping their relative paths, with up to opts.Concurrency uploads at a time. Files
+// whose destination has the same size and is not older (or the same MD5 with
+// opts.Checksum) are skipped, so an interrupted upload can simply be run again. Failed
+// files do not stop the upload; they are listed in the report and summarized in the
+// returned error.
+func (s *Storage) UploadDirectory(ctx context.Context, localDir, destinationPrefix string, opts UploadDirOptions) (*UploadDirReport, error) {
+	if err := s.checkWritable(destinationPrefix); err != nil {
+		return nil, err
+	}
+
+	report := &UploadDirReport{}
+	local := make(map[string]localFile)
+	var relPaths []string
+	err := filepath.WalkDir(localDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if filePath == localDir {
+				return err
+			}
+			report.fail(filePath, err)
+			return nil
+		}
+		rel, err := filepath.Rel(localDir, filePath)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if entry.IsDir() {
+			if matchesAny(opts.Exclude, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || !selectedPath(rel, opts.Include, opts.Exclude) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			report.fail(filePath, err)
+			return nil
+		}
+		local[rel] = localFile{path: filePath, size: info.Size(), modTime: info.ModTime()}
+		relPaths = append(relPaths, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeInvalidPath, "failed to read local directory", err)
+	}
+
+	remote, err := s.remoteFiles(ctx, destinationPrefix, opts.Checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultTransferConcurrency
+	}
+
+	var mu sync.Mutex
+	// Failures are collected in the report, so the executor only reports cancellation
+	err = NewParallelExecutor(concurrency).Run(ctx, relPaths, func(ctx context.Context, rel string) error {
+		file := local[rel]
+		dstPath := s.config.normalizePath(path.Join(destinationPrefix, rel))
+		skipped, err := s.uploadLocalFile(ctx, file, dstPath, remote[rel], opts.Checksum)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case err != nil:
+			report.fail(dstPath, err)
+		case skipped:
+			report.Skipped++
+		default:
+			report.Uploaded++
+			report.Bytes += file.size
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if opts.DeleteExtraneous {
+		for rel, info := range remote {
+			if _, ok := local[rel]; ok || !selectedPath(rel, opts.Include, opts.Exclude) {
+				continue
+			}
+			if err := s.Delete(ctx, info.Path); err != nil {
+				report.fail(info.Path, err)
+				continue
+			}
+			report.Deleted++
+		}
+	}
+
+	if len(report.Failed) > 0 {
+		return report, NewStorageErrorWithCause(ErrorCodeUploadFailed,
+			fmt.Sprintf("%d files failed to upload", len(report.Failed)),
+			report.Failed[0].Err)
+	}
+	return report, nil
+}
+
+// remoteFiles returns the files under prefix by their path relative to it. A missing
+// prefix is empty.
+func (s *Storage) remoteFiles(ctx context.Context, prefix string, withETags bool) (map[string]*FileInfo, error) {
+	files := make(map[string]*FileInfo)
+	opts := ListOptions{IncludeHidden: true, AllowMissing: true, IncludeETags: withETags}
+	err := s.walk(ctx, prefix, opts, func(info *FileInfo) error {
+		if !info.IsDirectory {
+			files[relativePath(prefix, info.Path)] = info
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// uploadLocalFile uploads a local file to dstPath unless remote already has its content
+func (s *Storage) uploadLocalFile(ctx context.Context, file localFile, dstPath string, remote *FileInfo, checksum bool) (bool, error) {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return false, NewStorageErrorWithCause(ErrorCodeUploadFailed, "failed to open local file", err)
+	}
+	defer f.Close()
+
+	if remote != nil && remote.Size == file.size {
+		localInfo := &FileInfo{Size: file.size, LastModified: &file.modTime}
+		if checksum && remote.ETag != "" && !isMultipartETag(remote.ETag) {
+			hash := md5.New()
+			if _, err := io.Copy(hash, f); err != nil {
+				return false, NewStorageErrorWithCause(ErrorCodeUploadFailed, "failed to read local file", err)
+			}
+			localInfo.ETag = hex.EncodeToString(hash.Sum(nil))
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return false, NewStorageErrorWithCause(ErrorCodeUploadFailed, "failed to read local file", err)
+			}
+		}
+		if !fileChanged(localInfo, remote) {
+			return true, nil
+		}
+	}
+
+	metadata := &FileMetadata{ContentType: mime.TypeByExtension(path.Ext(dstPath))}
+	if _, err := s.Upload(ctx, dstPath, &contextReader{ctx: ctx, r: f}, metadata); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// OverwritePolicy decides what DownloadDirectory does with a local file that differs
+// from the storage file it would be replaced with
+type OverwritePolicy string
+
+const (
+	OverwriteFail    OverwritePolicy = "fail"      // Report the file as failed, the default
+	OverwriteReplace OverwritePolicy = "overwrite" // Replace the local file
+	OverwriteSkip    OverwritePolicy = "skip"      // Keep the local file
+)
+
+// DownloadDirOptions controls DownloadDirectory
+type DownloadDirOptions struct {
+	Include     []string // Globs of relative paths to download, as in UploadDirOptions
+	Exclude     []string // Globs of relative paths to leave out, as in UploadDirOptions
+	Concurrency int      // Parallel downloads, defaults to 4
+
+	Overwrite OverwritePolicy // Local files that differ from the storage, OverwriteFail when empty
+}
+
+// DownloadDirReport summarizes a DownloadDirectory
+type DownloadDirReport struct {
+	Downloaded int               `json:"downloaded"`
+	Skipped    int               `json:"skipped"` // Already up to date locally, or kept by OverwriteSkip
+	Bytes      int64             `json:"bytes"`   // Bytes downloaded
+	Failed     []TransferFailure `json:"failed,omitempty"`
+}
+
+// DownloadDirectory mirrors the files under prefix to localDir with up to
+// opts.Concurrency downloads at a time. Each file is written to a temporary file and
+// renamed into place once verified, as in DownloadToFile, and gets the modification time
+// of the storage file. Local files with the same size and modification time, or the
+// same MD5 as the ETag, are skipped, so an interrupted download can simply be run again.
+// Failed files do not stop the download; they are listed in the report and summarized
+// in the returned error.
+func (s *Storage) DownloadDirectory(ctx context.Context, prefix, localDir string, opts DownloadDirOptions) (*DownloadDirReport, error) {
+	// The prefix must exist, unlike the destination of an upload
+	if _, err := s.List(ctx, prefix); err != nil {
+		return nil, err
+	}
+	remote, err := s.remoteFiles(ctx, prefix, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var relPaths []string
+	for rel := range remote {
+		if selectedPath(rel, opts.Include, opts.Exclude) {
+			relPaths = append(relPaths, rel)
+		}
+	}
+	sort.Strings(relPaths)
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultTransferConcurrency
+	}
+
+	report := &DownloadDirReport{}
+	var mu sync.Mutex
+	// Failures are collected in the report, so the executor only reports cancellation
+	err = NewParallelExecutor(concurrency).Run(ctx, relPaths, func(ctx context.Context, rel string) error {
+		info := remote[rel]
+		skipped, err := s.downloadLocalFile(ctx, info, localDir, rel, opts.Overwrite)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case err != nil:
+			report.fail(info.Path, err)
+		case skipped:
+			report.Skipped++
+		default:
+			report.Downloaded++
+			report.Bytes += info.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if len(report.Failed) > 0 {
+		return report, NewStorageErrorWithCause(ErrorCodeDownloadFailed,
+			fmt.Sprintf("%d files failed to download", len(report.Failed)),
+			report.Failed[0].Err)
+	}
+	return report, nil
+}
+
+// downloadLocalFile downloads a storage file to its relative path under localDir,
+// applying the overwrite policy to a different local file
+func (s *Storage) downloadLocalFile(ctx context.Context, info *FileInfo, localDir, rel string, overwrite OverwritePolicy) (bool, error) {
+	localRel := filepath.FromSlash(rel)
+	if !filepath.IsLocal(localRel) {
+		return false, InvalidPathError(info.Path)
+	}
+	localPath := filepath.Join(localDir, localRel)
+
+	if stat, err := os.Stat(localPath); err == nil {
+		if stat.IsDir() {
+			return false, NewStorageErrorWithPath(ErrorCodeIsDirectory, "local path is a directory", localPath)
+		}
+		unchanged, err := localUnchanged(localPath, stat, info)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case unchanged:
+			return true, nil
+		case overwrite == OverwriteSkip:
+			return true, nil
+		case overwrite != OverwriteReplace:
+			return false, NewStorageErrorWithPath(ErrorCodeFileAlreadyExists, "local file differs from the storage file", localPath)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return false, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to create local directory", err)
+	}
+	if _, err := s.DownloadToFile(ctx, info.Path, localPath); err != nil {
+		return false, err
+	}
+	if info.LastModified != nil {
+		os.Chtimes(localPath, *info.LastModified, *info.LastModified) // Lets the next run skip it without hashing
+	}
+	return false, nil
+}
+
+// localUnchanged reports whether a local file has the content of a storage file, by
+// size and modification time, or by MD5 when the ETag is a content hash
+func localUnchanged(localPath string, stat os.FileInfo, info *FileInfo) (bool, error) {
+	if stat.Size() != info.Size {
+		return false, nil
+	}
+	if info.LastModified != nil && stat.ModTime().Equal(*info.LastModified) {
+		return true, nil
+	}
+	etag := NormalizeETag(info.ETag)
+	if etag == "" || isMultipartETag(etag) {
+		return false, nil
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return false, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to read local file", err)
+	}
+	defer f.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return false, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to read local file", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)) == etag, nil
+}
+
+// fail records a failed path in the report
+func (r *DownloadDirReport) fail(path string, err error) {
+	r.Failed = append(r.Failed, TransferFailure{Path: path, Error: err.Error(), Err: err})
+}
+
+// fail records a failed path in the report
+func (r *UploadDirReport) fail(path string, err error) {
+	r.Failed = append(r.Failed, TransferFailure{Path: path, Error: err.Error(), Err: err})
+}
+
+// selectedPath reports whether a relative path passes the include and exclude globs
+func selectedPath(rel string, include, exclude []string) bool {
+	if len(include) > 0 && !matchesAny(include, rel) {
+		return false
+	}
+	return !matchesAny(exclude, rel)
+}
+
+// matchesAny reports whether a relative path matches one of the globs. Patterns
+// without a slash are matched against the base name.
+func matchesAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeLocalTree creates files under dir from relative paths to contents
+func writeLocalTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(filePath), 0755)
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", rel, err)
+		}
+	}
+}
+
+func TestUploadDirectory(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{Name: "test", Provider: "memory"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	localDir := t.TempDir()
+	writeLocalTree(t, localDir, map[string]string{
+		"cam1/seg1.ts":     "one",
+		"cam1/seg2.ts":     "two",
+		"cam1/index.m3u8":  "playlist",
+		"cam2/seg1.ts":     "three",
+		"tmp/partial.ts":   "partial",
+		"cam2/notes/a.txt": "notes",
+	})
+	opts := UploadDirOptions{Include: []string{"*.ts", "*.m3u8"}, Exclude: []string{"tmp"}, Concurrency: 2}
+
+	report, err := storage.UploadDirectory(ctx, localDir, "edge/site1", opts)
+	if err != nil {
+		t.Fatalf("UploadDirectory failed: %v", err)
+	}
+	if report.Uploaded != 4 || report.Skipped != 0 || report.Bytes != 19 {
+		t.Errorf("Expected 4 files of 19 bytes uploaded, got %+v", report)
+	}
+	data, _, err := storage.DownloadBytes(ctx, "edge/site1/cam1/seg2.ts")
+	if err != nil || string(data) != "two" {
+		t.Errorf("Expected the relative path to be kept, got %q, %v", data, err)
+	}
+	for _, excluded := range []string{"edge/site1/tmp/partial.ts", "edge/site1/cam2/notes/a.txt"} {
+		if exists, _ := storage.Exists(ctx, excluded); exists {
+			t.Errorf("Expected %s to be left out by the globs", excluded)
+		}
+	}
+
+	t.Run("Resume", func(t *testing.T) {
+		report, err := storage.UploadDirectory(ctx, localDir, "edge/site1", opts)
+		if err != nil || report.Uploaded != 0 || report.Skipped != 4 {
+			t.Fatalf("Expected every file to be skipped, got %+v, %v", report, err)
+		}
+
+		// A changed file is uploaded again, by modification time or by checksum
+		writeLocalTree(t, localDir, map[string]string{"cam1/seg1.ts": "ONE"})
+		future := time.Now().Add(time.Hour)
+		os.Chtimes(filepath.Join(localDir, "cam1", "seg1.ts"), future, future)
+		report, err = storage.UploadDirectory(ctx, localDir, "edge/site1", opts)
+		if err != nil || report.Uploaded != 1 || report.Skipped != 3 {
+			t.Errorf("Expected the changed file to be uploaded, got %+v, %v", report, err)
+		}
+
+		writeLocalTree(t, localDir, map[string]string{"cam1/seg2.ts": "TWO"})
+		past := time.Now().Add(-time.Hour)
+		os.Chtimes(filepath.Join(localDir, "cam1", "seg2.ts"), past, past)
+		checksumOpts := opts
+		checksumOpts.Checksum = true
+		report, err = storage.UploadDirectory(ctx, localDir, "edge/site1", checksumOpts)
+		if err != nil || report.Uploaded != 1 {
+			t.Errorf("Expected the checksum to catch the change, got %+v, %v", report, err)
+		}
+	})
+
+	t.Run("DeleteExtraneous", func(t *testing.T) {
+		storage.Upload(ctx, "edge/site1/cam3/old.ts", strings.NewReader("old"), nil)
+		storage.Upload(ctx, "edge/site1/readme.txt", strings.NewReader("kept"), nil)
+		syncOpts := opts
+		syncOpts.DeleteExtraneous = true
+		report, err := storage.UploadDirectory(ctx, localDir, "edge/site1", syncOpts)
+		if err != nil || report.Deleted != 1 {
+			t.Fatalf("Expected the extraneous segment to be deleted, got %+v, %v", report, err)
+		}
+		if exists, _ := storage.Exists(ctx, "edge/site1/readme.txt"); !exists {
+			t.Error("Expected files outside the globs to be kept")
+		}
+	})
+
+	t.Run("MissingDirectory", func(t *testing.T) {
+		if _, err := storage.UploadDirectory(ctx, filepath.Join(localDir, "missing"), "edge", UploadDirOptions{}); err == nil {
+			t.Error("Expected an error for a missing local directory")
+		}
+	})
+}
+
+func TestDownloadDirectory(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{Name: "test", Provider: "memory"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	for name, content := range map[string]string{
+		"exports/acme/cam1/a.mp4":    "aaaa",
+		"exports/acme/cam1/b.mp4":    "bb",
+		"exports/acme/cam2/c.mp4":    "c",
+		"exports/acme/cam2/notes.md": "notes",
+	} {
+		storage.Upload(ctx, name, strings.NewReader(content), nil)
+	}
+
+	localDir := t.TempDir()
+	opts := DownloadDirOptions{Include: []string{"*.mp4"}, Concurrency: 2}
+	report, err := storage.DownloadDirectory(ctx, "exports/acme", localDir, opts)
+	if err != nil {
+		t.Fatalf("DownloadDirectory failed: %v", err)
+	}
+	if report.Downloaded != 3 || report.Bytes != 7 {
+		t.Errorf("Expected 3 files of 7 bytes, got %+v", report)
+	}
+	if data, _ := os.ReadFile(filepath.Join(localDir, "cam1", "a.mp4")); string(data) != "aaaa" {
+		t.Errorf("Expected the relative path to be kept, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, "cam2", "notes.md")); !os.IsNotExist(err) {
+		t.Error("Expected notes.md to be left out by the globs")
+	}
+
+	t.Run("Resume", func(t *testing.T) {
+		report, err := storage.DownloadDirectory(ctx, "exports/acme", localDir, opts)
+		if err != nil || report.Downloaded != 0 || report.Skipped != 3 {
+			t.Fatalf("Expected every file to be skipped, got %+v, %v", report, err)
+		}
+
+		// Same content with another modification time is recognized by its MD5
+		now := time.Now()
+		os.Chtimes(filepath.Join(localDir, "cam1", "b.mp4"), now, now)
+		report, err = storage.DownloadDirectory(ctx, "exports/acme", localDir, opts)
+		if err != nil || report.Skipped != 3 {
+			t.Errorf("Expected the checksum to match, got %+v, %v", report, err)
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		localFile := filepath.Join(localDir, "cam2", "c.mp4")
+		os.WriteFile(localFile, []byte("local"), 0644)
+
+		report, err := storage.DownloadDirectory(ctx, "exports/acme", localDir, opts)
+		if err == nil || len(report.Failed) != 1 || report.Failed[0].Path != "exports/acme/cam2/c.mp4" {
+			t.Fatalf("Expected the conflict to fail by default, got %+v, %v", report, err)
+		}
+
+		skipOpts := opts
+		skipOpts.Overwrite = OverwriteSkip
+		if _, err := storage.DownloadDirectory(ctx, "exports/acme", localDir, skipOpts); err != nil {
+			t.Fatalf("Expected no error when skipping, got %v", err)
+		}
+		if data, _ := os.ReadFile(localFile); string(data) != "local" {
+			t.Errorf("Expected the local file to be kept, got %q", data)
+		}
+
+		replaceOpts := opts
+		replaceOpts.Overwrite = OverwriteReplace
+		report, err = storage.DownloadDirectory(ctx, "exports/acme", localDir, replaceOpts)
+		if err != nil || report.Downloaded != 1 {
+			t.Fatalf("Expected the local file to be replaced, got %+v, %v", report, err)
+		}
+		if data, _ := os.ReadFile(localFile); string(data) != "c" {
+			t.Errorf("Expected the storage content, got %q", data)
+		}
+	})
+
+	t.Run("MissingPrefix", func(t *testing.T) {
+		if _, err := storage.DownloadDirectory(ctx, "exports/missing", localDir, opts); !errors.Is(err, ErrDirectoryNotFound) {
+			t.Errorf("Expected ErrDirectoryNotFound, got %v", err)
+		}
+	})
+}
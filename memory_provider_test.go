@@ -0,0 +1,117 @@
+package vsaasstorage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMemoryProvider(t *testing.T) {
+	storage, err := New(&StorageConfig{
+		Name:     "MemoryStorage",
+		Provider: "memory",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	ctx := context.Background()
+
+	for _, path := range []string{"test/hello.txt", "test/nested/deep.txt", "root.txt"} {
+		if _, err := storage.Upload(ctx, path, strings.NewReader("content of "+path), nil); err != nil {
+			t.Fatalf("Upload of %s failed: %v", path, err)
+		}
+	}
+
+	t.Run("Download", func(t *testing.T) {
+		reader, info, err := storage.Download(ctx, "/test/hello.txt")
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		defer reader.Close()
+
+		content, _ := io.ReadAll(reader)
+		if string(content) != "content of test/hello.txt" {
+			t.Errorf("Unexpected content '%s'", content)
+		}
+
+		if info.ETag == "" {
+			t.Error("Expected ETag to be set")
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		files, err := storage.List(ctx, "test")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+
+		if len(files) != 2 {
+			t.Fatalf("Expected 2 entries, got %d", len(files))
+		}
+
+		if files[0].Name != "hello.txt" || files[0].IsDirectory {
+			t.Errorf("Expected file 'hello.txt' first, got %+v", files[0])
+		}
+
+		if files[1].Name != "nested" || !files[1].IsDirectory {
+			t.Errorf("Expected directory 'nested' second, got %+v", files[1])
+		}
+
+		if _, err := storage.List(ctx, "missing"); err == nil {
+			t.Error("Expected error listing a missing directory")
+		}
+	})
+
+	t.Run("Directory semantics", func(t *testing.T) {
+		exists, err := storage.Exists(ctx, "test/nested")
+		if err != nil || exists {
+			t.Errorf("Expected Exists to report false for a directory, got %v, %v", exists, err)
+		}
+
+		exists, err = storage.DirectoryExists(ctx, "test/nested")
+		if err != nil || !exists {
+			t.Errorf("Expected directory to exist, got %v, %v", exists, err)
+		}
+
+		exists, err = storage.DirectoryExists(ctx, "test/nested/deep.txt")
+		if err != nil || exists {
+			t.Errorf("Expected DirectoryExists to report false for a file, got %v, %v", exists, err)
+		}
+
+		info, err := storage.GetInfo(ctx, "test/nested")
+		if err != nil {
+			t.Fatalf("GetInfo failed: %v", err)
+		}
+		if !info.IsDirectory {
+			t.Error("Expected directory info")
+		}
+
+		if _, _, err := storage.Download(ctx, "test/nested"); err == nil {
+			t.Error("Expected error downloading a directory")
+		}
+	})
+
+	t.Run("DeleteDirectory", func(t *testing.T) {
+		if err := storage.DeleteDirectory(ctx, "test"); err != nil {
+			t.Fatalf("DeleteDirectory failed: %v", err)
+		}
+
+		exists, _ := storage.Exists(ctx, "test/hello.txt")
+		if exists {
+			t.Error("Expected nested files to be removed")
+		}
+
+		exists, _ = storage.Exists(ctx, "root.txt")
+		if !exists {
+			t.Error("Expected files outside the directory to remain")
+		}
+	})
+
+	t.Run("Path traversal", func(t *testing.T) {
+		if _, err := storage.Upload(ctx, "../escape.txt", strings.NewReader("x"), nil); err == nil {
+			t.Error("Expected invalid path error")
+		}
+	})
+}
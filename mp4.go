@@ -0,0 +1,563 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strings"
+)
+
+// maxMP4Boxes bounds the top-level boxes scanned in a file
+const maxMP4Boxes = 1024
+
+// maxMoovSize bounds the moov box read into memory
+const maxMoovSize = 64 << 20
+
+// errAlreadyFaststart is returned by planFaststart when there is nothing to relocate
+var errAlreadyFaststart = errors.New("already faststart")
+
+// MP4Info describes an MP4 file
+type MP4Info struct {
+	Duration   float64    `json:"duration"` // Seconds
+	MajorBrand string     `json:"major_brand,omitempty"`
+	Faststart  bool       `json:"faststart"`  // moov comes before mdat, so playback can start before the whole file is fetched
+	Fragmented bool       `json:"fragmented"` // Samples are described in moof boxes rather than in moov
+	Tracks     []MP4Track `json:"tracks"`
+}
+
+// MP4Track describes a track of an MP4 file
+type MP4Track struct {
+	ID       uint32  `json:"id"`
+	Type     string  `json:"type"`            // "video", "audio" or the raw handler type
+	Codec    string  `json:"codec,omitempty"` // Sample entry type, e.g. "avc1" or "mp4a"
+	Duration float64 `json:"duration"`        // Seconds
+	Width    int     `json:"width,omitempty"`
+	Height   int     `json:"height,omitempty"`
+	Samples  uint32  `json:"samples,omitempty"`
+}
+
+// mp4Box is a top-level box of a file
+type mp4Box struct {
+	typ          string
+	offset, size int64
+}
+
+// readAtFunc reads length bytes at offset
+type readAtFunc func(offset, length int64) ([]byte, error)
+
+// ProbeMP4 reports the duration, tracks and layout of an MP4 file. Only the top-level box
+// headers and the moov box are read, with ReadRange, so probing a large recording does not
+// download its media data.
+func (s *Storage) ProbeMP4(ctx context.Context, filePath string) (*MP4Info, error) {
+	info, err := s.GetInfo(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDirectory {
+		return nil, NewStorageErrorWithPath(ErrorCodeInvalidPath, "cannot probe a directory", filePath)
+	}
+
+	readAt := func(offset, length int64) ([]byte, error) {
+		reader, _, err := s.ReadRange(ctx, filePath, offset, length)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+
+	boxes, err := scanMP4Boxes(readAt, info.Size)
+	if err != nil {
+		return nil, mp4Error(err, filePath)
+	}
+
+	probe := &MP4Info{Faststart: true, Tracks: []MP4Track{}}
+	var moov *mp4Box
+	for i := range boxes {
+		box := &boxes[i]
+		switch box.typ {
+		case "ftyp":
+			if data, err := readAt(box.offset+8, min(box.size-8, 4)); err == nil && len(data) == 4 {
+				probe.MajorBrand = string(data)
+			}
+		case "moov":
+			if moov == nil {
+				moov = box
+			}
+		case "mdat":
+			if moov == nil {
+				probe.Faststart = false
+			}
+		case "moof":
+			probe.Fragmented = true
+		}
+	}
+	if moov == nil {
+		return nil, NewStorageErrorWithPath(ErrorCodeInvalidPath, "MP4 file has no moov box", filePath)
+	}
+
+	data, err := readMoov(readAt, moov)
+	if err != nil {
+		return nil, mp4Error(err, filePath)
+	}
+	if err := parseMoov(moovPayload(data), probe); err != nil {
+		return nil, mp4Error(err, filePath)
+	}
+
+	return probe, nil
+}
+
+// mp4Error reports a malformed file as an invalid path and passes storage errors through
+func mp4Error(err error, filePath string) error {
+	var storageErr *StorageError
+	if errors.As(err, &storageErr) {
+		return err
+	}
+	return &StorageError{Code: ErrorCodeInvalidPath, Message: "invalid MP4 file: " + err.Error(), Path: filePath, Cause: err}
+}
+
+// isMP4 reports whether a file holds MP4 or QuickTime video
+func isMP4(info *FileInfo) bool {
+	switch info.ContentType {
+	case "video/mp4", "video/quicktime", "video/x-m4v":
+		return true
+	}
+	switch strings.ToLower(path.Ext(info.Name)) {
+	case ".mp4", ".m4v", ".mov":
+		return true
+	}
+	return false
+}
+
+// scanMP4Boxes lists the top-level boxes of a file by reading each box header
+func scanMP4Boxes(readAt readAtFunc, size int64) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for offset := int64(0); offset < size; {
+		if len(boxes) == maxMP4Boxes {
+			return nil, errors.New("too many top-level boxes")
+		}
+
+		header, err := readAt(offset, min(16, size-offset))
+		if err != nil {
+			return nil, err
+		}
+		if len(header) < 8 {
+			return nil, errors.New("truncated box header")
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(header))
+		typ := string(header[4:8])
+		switch boxSize {
+		case 0: // Extends to the end of the file
+			boxSize = size - offset
+		case 1: // 64-bit size follows the type
+			if len(header) < 16 {
+				return nil, errors.New("truncated box header")
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:]))
+		}
+		if boxSize < 8 || boxSize > size-offset {
+			return nil, fmt.Errorf("invalid size for box %q at %d", typ, offset)
+		}
+		if len(boxes) == 0 && typ != "ftyp" && typ != "free" && typ != "skip" && typ != "wide" {
+			return nil, errors.New("missing ftyp box")
+		}
+
+		boxes = append(boxes, mp4Box{typ: typ, offset: offset, size: boxSize})
+		offset += boxSize
+	}
+	return boxes, nil
+}
+
+// readMoov reads a whole moov box, header included
+func readMoov(readAt readAtFunc, moov *mp4Box) ([]byte, error) {
+	if moov.size > maxMoovSize {
+		return nil, fmt.Errorf("moov box too large (%d bytes)", moov.size)
+	}
+	data, err := readAt(moov.offset, moov.size)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != moov.size {
+		return nil, errors.New("truncated moov box")
+	}
+	return data, nil
+}
+
+// moovPayload returns the children of a moov box read by readMoov
+func moovPayload(moov []byte) []byte {
+	if binary.BigEndian.Uint32(moov) == 1 {
+		return moov[16:]
+	}
+	return moov[8:]
+}
+
+// mp4Children calls fn for each box in data. Payloads share data, so fn may modify them.
+func mp4Children(data []byte, fn func(typ string, payload []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return errors.New("truncated box")
+		}
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return errors.New("truncated box")
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return fmt.Errorf("invalid size for box %q", typ)
+		}
+
+		if err := fn(typ, data[header:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// parseMoov fills the duration and tracks of probe from the payload of a moov box
+func parseMoov(moov []byte, probe *MP4Info) error {
+	return mp4Children(moov, func(typ string, payload []byte) error {
+		switch typ {
+		case "mvhd":
+			timescale, duration, err := mp4Duration(payload)
+			if err != nil {
+				return err
+			}
+			probe.Duration = seconds(duration, timescale)
+		case "mvex":
+			probe.Fragmented = true
+		case "trak":
+			track, err := parseTrak(payload)
+			if err != nil {
+				return err
+			}
+			probe.Tracks = append(probe.Tracks, *track)
+		}
+		return nil
+	})
+}
+
+// parseTrak reads the track header, media header, handler and sample description of a track
+func parseTrak(trak []byte) (*MP4Track, error) {
+	track := &MP4Track{}
+	err := mp4Children(trak, func(typ string, payload []byte) error {
+		switch typ {
+		case "tkhd":
+			idOffset := 12 // Version 0: version/flags, creation and modification times
+			if len(payload) > 0 && payload[0] == 1 {
+				idOffset = 20
+			}
+			if len(payload) < idOffset+4 || len(payload) < 84 {
+				return errors.New("truncated tkhd box")
+			}
+			track.ID = binary.BigEndian.Uint32(payload[idOffset:])
+			// Width and height are 16.16 fixed point values at the end of the box
+			track.Width = int(binary.BigEndian.Uint32(payload[len(payload)-8:]) >> 16)
+			track.Height = int(binary.BigEndian.Uint32(payload[len(payload)-4:]) >> 16)
+		case "mdia":
+			return parseMdia(payload, track)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return track, nil
+}
+
+// parseMdia reads the media header, handler and sample table of a track
+func parseMdia(mdia []byte, track *MP4Track) error {
+	return mp4Children(mdia, func(typ string, payload []byte) error {
+		switch typ {
+		case "mdhd":
+			timescale, duration, err := mp4Duration(payload)
+			if err != nil {
+				return err
+			}
+			track.Duration = seconds(duration, timescale)
+		case "hdlr":
+			if len(payload) < 12 {
+				return errors.New("truncated hdlr box")
+			}
+			switch handler := string(payload[8:12]); handler {
+			case "vide":
+				track.Type = "video"
+			case "soun":
+				track.Type = "audio"
+			default:
+				track.Type = handler
+			}
+		case "minf":
+			return mp4Children(payload, func(typ string, payload []byte) error {
+				if typ != "stbl" {
+					return nil
+				}
+				return mp4Children(payload, func(typ string, payload []byte) error {
+					switch typ {
+					case "stsd":
+						if len(payload) >= 16 {
+							track.Codec = string(payload[12:16])
+						}
+					case "stsz":
+						if len(payload) >= 12 {
+							track.Samples = binary.BigEndian.Uint32(payload[8:])
+						}
+					}
+					return nil
+				})
+			})
+		}
+		return nil
+	})
+}
+
+// mp4Duration reads the timescale and duration of an mvhd or mdhd box
+func mp4Duration(payload []byte) (uint32, uint64, error) {
+	if len(payload) > 0 && payload[0] == 1 {
+		if len(payload) < 32 {
+			return 0, 0, errors.New("truncated header box")
+		}
+		return binary.BigEndian.Uint32(payload[20:]), binary.BigEndian.Uint64(payload[24:]), nil
+	}
+	if len(payload) < 20 {
+		return 0, 0, errors.New("truncated header box")
+	}
+	return binary.BigEndian.Uint32(payload[12:]), uint64(binary.BigEndian.Uint32(payload[16:])), nil
+}
+
+// seconds converts a duration in timescale units to seconds
+func seconds(duration uint64, timescale uint32) float64 {
+	if timescale == 0 || duration == math.MaxUint32 || duration == math.MaxUint64 { // Unknown duration
+		return 0
+	}
+	return float64(duration) / float64(timescale)
+}
+
+// contentSegment is a part of a spliced file: either bytes held in memory or a range of
+// the source file
+type contentSegment struct {
+	data           []byte
+	offset, length int64
+}
+
+// size returns the length of the segment
+func (c contentSegment) size() int64 {
+	if c.data != nil {
+		return int64(len(c.data))
+	}
+	return c.length
+}
+
+// planFaststart returns the segments of a copy of the file with moov moved in front of
+// the first mdat, with chunk offsets adjusted, or errAlreadyFaststart
+func planFaststart(readAt readAtFunc, size int64) ([]contentSegment, error) {
+	boxes, err := scanMP4Boxes(readAt, size)
+	if err != nil {
+		return nil, err
+	}
+
+	moovIndex, mdatIndex := -1, -1
+	for i, box := range boxes {
+		switch box.typ {
+		case "moov":
+			if moovIndex >= 0 {
+				return nil, errors.New("multiple moov boxes")
+			}
+			moovIndex = i
+		case "mdat":
+			if mdatIndex < 0 {
+				mdatIndex = i
+			}
+		case "moof":
+			return nil, errors.New("fragmented files cannot be relocated")
+		}
+	}
+	if moovIndex < 0 || mdatIndex < 0 || moovIndex < mdatIndex {
+		return nil, errAlreadyFaststart
+	}
+
+	moov, mdat := boxes[moovIndex], boxes[mdatIndex]
+	data, err := readMoov(readAt, &moov)
+	if err != nil {
+		return nil, err
+	}
+
+	// Everything from the first mdat up to the old moov moves forward by the size of moov
+	if err := shiftChunkOffsets(moovPayload(data), mdat.offset, moov.offset, moov.size); err != nil {
+		return nil, err
+	}
+
+	return []contentSegment{
+		{offset: 0, length: mdat.offset},
+		{data: data},
+		{offset: mdat.offset, length: moov.offset - mdat.offset},
+		{offset: moov.offset + moov.size, length: size - moov.offset - moov.size},
+	}, nil
+}
+
+// shiftChunkOffsets adds delta to the stco and co64 entries that point into [from, to)
+func shiftChunkOffsets(moov []byte, from, to, delta int64) error {
+	return mp4Children(moov, func(typ string, payload []byte) error {
+		switch typ {
+		case "trak", "mdia", "minf", "stbl":
+			return shiftChunkOffsets(payload, from, to, delta)
+		case "mvex":
+			return errors.New("fragmented files cannot be relocated")
+		case "stco", "co64":
+			if len(payload) < 8 {
+				return fmt.Errorf("truncated %s box", typ)
+			}
+			count := int(binary.BigEndian.Uint32(payload[4:]))
+			entrySize := 4
+			if typ == "co64" {
+				entrySize = 8
+			}
+			entries := payload[8:]
+			if count > len(entries)/entrySize {
+				return fmt.Errorf("truncated %s box", typ)
+			}
+
+			for i := 0; i < count; i++ {
+				entry := entries[i*entrySize:]
+				if entrySize == 8 {
+					if offset := int64(binary.BigEndian.Uint64(entry)); offset >= from && offset < to {
+						binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+					}
+					continue
+				}
+				offset := int64(binary.BigEndian.Uint32(entry))
+				if offset < from || offset >= to {
+					continue
+				}
+				if offset+delta > math.MaxUint32 {
+					return errors.New("chunk offsets overflow stco")
+				}
+				binary.BigEndian.PutUint32(entry, uint32(offset+delta))
+			}
+		}
+		return nil
+	})
+}
+
+// splicedContent reads a sequence of segments as a single seekable file
+type splicedContent struct {
+	src      io.ReadSeekCloser
+	segments []contentSegment
+	size     int64
+	pos      int64
+}
+
+// newSplicedContent joins segments taken from src and from memory
+func newSplicedContent(src io.ReadSeekCloser, segments []contentSegment) *splicedContent {
+	content := &splicedContent{src: src, segments: segments}
+	for _, segment := range segments {
+		content.size += segment.size()
+	}
+	return content
+}
+
+// Read reads from the segment holding the current position
+func (c *splicedContent) Read(p []byte) (int, error) {
+	if c.pos >= c.size {
+		return 0, io.EOF
+	}
+
+	start := int64(0)
+	for _, segment := range c.segments {
+		end := start + segment.size()
+		if c.pos >= end {
+			start = end
+			continue
+		}
+
+		rel := c.pos - start
+		if segment.data != nil {
+			n := copy(p, segment.data[rel:])
+			c.pos += int64(n)
+			return n, nil
+		}
+
+		if _, err := c.src.Seek(segment.offset+rel, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if remaining := segment.length - rel; int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+		n, err := c.src.Read(p)
+		c.pos += int64(n)
+		if err == io.EOF && c.pos < c.size {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
+	return 0, io.EOF
+}
+
+// Seek sets the position of the next Read
+func (c *splicedContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.pos
+	case io.SeekEnd:
+		offset += c.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	c.pos = offset
+	return offset, nil
+}
+
+// Close closes the source file
+func (c *splicedContent) Close() error {
+	return c.src.Close()
+}
+
+// faststartContent wraps content so that the moov box of an MP4 that is not faststart is
+// sent first. It returns content unchanged, with remuxed false, when there is nothing to do
+// or the file cannot be relocated.
+func (s *Storage) faststartContent(ctx context.Context, content io.ReadSeekCloser, info *FileInfo) (io.ReadSeekCloser, bool) {
+	readAt := func(offset, length int64) ([]byte, error) {
+		if _, err := content.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		data := make([]byte, length)
+		n, err := io.ReadFull(content, data)
+		if err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		return data[:n], err
+	}
+
+	segments, err := planFaststart(readAt, info.Size)
+	if _, seekErr := content.Seek(0, io.SeekStart); err == nil {
+		err = seekErr
+	}
+	if err != nil {
+		if !errors.Is(err, errAlreadyFaststart) {
+			s.config.log(ctx, LogLevelWarn, "failed to relocate moov, serving unchanged", map[string]interface{}{
+				"path":  info.Path,
+				"error": err.Error(),
+			})
+		}
+		return content, false
+	}
+
+	return newSplicedContent(content, segments), true
+}
@@ -0,0 +1,153 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// mp4TestBox builds a box from its type and payload parts
+func mp4TestBox(typ string, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(box, typ...), payload...)
+}
+
+// u32 encodes big-endian uint32 values
+func u32(values ...uint32) []byte {
+	var out []byte
+	for _, v := range values {
+		out = binary.BigEndian.AppendUint32(out, v)
+	}
+	return out
+}
+
+// buildTestMP4 returns a minimal MP4 with one 640x360 avc1 track of 10 seconds whose only
+// chunk holds "SAMPLE", laid out with moov after mdat unless faststart is set
+func buildTestMP4(faststart bool) []byte {
+	ftyp := mp4TestBox("ftyp", []byte("isom"), u32(512), []byte("isomavc1"))
+
+	moov := func(chunkOffset uint32) []byte {
+		tkhd := append(u32(0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0), u32(640<<16, 360<<16)...)
+		return mp4TestBox("moov",
+			mp4TestBox("mvhd", u32(0, 0, 0, 1000, 10000), make([]byte, 80)),
+			mp4TestBox("trak",
+				mp4TestBox("tkhd", tkhd),
+				mp4TestBox("mdia",
+					mp4TestBox("mdhd", u32(0, 0, 0, 90000, 900000, 0)),
+					mp4TestBox("hdlr", u32(0, 0), []byte("vide"), u32(0, 0, 0), []byte("video\x00")),
+					mp4TestBox("minf",
+						mp4TestBox("stbl",
+							mp4TestBox("stsd", u32(0, 1), mp4TestBox("avc1", make([]byte, 78))),
+							mp4TestBox("stsz", u32(0, 6, 1)),
+							mp4TestBox("stco", u32(0, 1, chunkOffset)),
+						),
+					),
+				),
+			),
+		)
+	}
+
+	mdat := mp4TestBox("mdat", []byte("SAMPLE"))
+	if faststart {
+		size := len(ftyp) + len(moov(0))
+		return bytes.Join([][]byte{ftyp, moov(uint32(size + 8)), mdat}, nil)
+	}
+	return bytes.Join([][]byte{ftyp, mdat, moov(uint32(len(ftyp) + 8))}, nil)
+}
+
+// firstChunk returns the bytes the stco of an MP4 points to
+func firstChunk(t *testing.T, data []byte) string {
+	t.Helper()
+	index := bytes.Index(data, []byte("stco"))
+	if index < 0 {
+		t.Fatal("no stco box")
+	}
+	offset := binary.BigEndian.Uint32(data[index+12:])
+	return string(data[offset : offset+6])
+}
+
+func TestProbeMP4(t *testing.T) {
+	ctx := context.Background()
+	storage, _ := New(&StorageConfig{Name: "test", Provider: "memory"})
+	storage.Upload(ctx, "clips/slow.mp4", bytes.NewReader(buildTestMP4(false)), nil)
+	storage.Upload(ctx, "clips/fast.mp4", bytes.NewReader(buildTestMP4(true)), nil)
+	storage.Upload(ctx, "clips/notes.txt", strings.NewReader("not a video at all"), nil)
+
+	probe, err := storage.ProbeMP4(ctx, "clips/slow.mp4")
+	if err != nil {
+		t.Fatalf("ProbeMP4 failed: %v", err)
+	}
+	if probe.Faststart || probe.Duration != 10 || probe.MajorBrand != "isom" || len(probe.Tracks) != 1 {
+		t.Fatalf("unexpected probe %+v", probe)
+	}
+	track := probe.Tracks[0]
+	if track.ID != 1 || track.Type != "video" || track.Codec != "avc1" || track.Duration != 10 ||
+		track.Width != 640 || track.Height != 360 || track.Samples != 1 {
+		t.Errorf("unexpected track %+v", track)
+	}
+
+	if probe, err := storage.ProbeMP4(ctx, "clips/fast.mp4"); err != nil || !probe.Faststart {
+		t.Errorf("expected a faststart file, got %+v, %v", probe, err)
+	}
+	if _, err := storage.ProbeMP4(ctx, "clips/notes.txt"); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("expected ErrInvalidPath for a non-MP4 file, got %v", err)
+	}
+}
+
+func TestFaststartRemux(t *testing.T) {
+	ctx := context.Background()
+	original := buildTestMP4(false)
+
+	storage, _ := New(&StorageConfig{Name: "test", Provider: "memory", FaststartRemux: true})
+	storage.Upload(ctx, "clips/slow.mp4", bytes.NewReader(original), &FileMetadata{ContentType: "video/mp4"})
+
+	server := newContentServer(storage)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/clips/slow.mp4")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if len(body) != len(original) {
+		t.Fatalf("expected %d bytes, got %d", len(original), len(body))
+	}
+	if moov, mdat := bytes.Index(body, []byte("moov")), bytes.Index(body, []byte("mdat")); moov < 0 || moov > mdat {
+		t.Errorf("expected moov before mdat, got moov at %d and mdat at %d", moov, mdat)
+	}
+	if chunk := firstChunk(t, body); chunk != "SAMPLE" {
+		t.Errorf("expected the chunk offset to follow the media data, got %q", chunk)
+	}
+	if etag := resp.Header.Get("ETag"); !strings.HasSuffix(etag, `.faststart"`) {
+		t.Errorf("expected a distinct ETag for the remuxed bytes, got %s", etag)
+	}
+
+	// Ranges are answered from the remuxed layout
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/clips/slow.mp4", nil)
+	req.Header.Set("Range", "bytes=20-")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	part, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(part, body[20:]) {
+		t.Errorf("expected the tail of the remuxed file, got %d", resp.StatusCode)
+	}
+
+	// The stored file is not modified
+	reader, _, _ := storage.Download(ctx, "clips/slow.mp4")
+	stored, _ := io.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(stored, original) {
+		t.Error("expected the stored file to be unchanged")
+	}
+}
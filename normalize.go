@@ -0,0 +1,216 @@
+package vsaasstorage
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// PathNormalization selects the Unicode normalization applied to paths
+type PathNormalization string
+
+const (
+	// PathNormalizationNFC composes paths, so "café" from macOS and "café" from
+	// a database refer to the same file. It is the default.
+	PathNormalizationNFC PathNormalization = "nfc"
+	// PathNormalizationNone passes paths to the provider byte for byte
+	PathNormalizationNone PathNormalization = "none"
+)
+
+// PathNormalizationProvider is implemented by providers that can rename stored entries
+// whose names are not in NFC
+type PathNormalizationProvider interface {
+	NormalizePaths(ctx context.Context, root string) (int, error)
+}
+
+// normalizePath applies the configured canonicalization and normalization to a path
+func (c *StorageConfig) normalizePath(p string) string {
+	if c == nil || !c.LegacyPaths {
+		p = canonicalize(p)
+	}
+	if c != nil && c.PathNormalization == PathNormalizationNone {
+		return p
+	}
+	return normalizeNFC(p)
+}
+
+// tokenPath returns the form of a path bound to signed tokens, used both for the claim
+// when a token is generated and for the comparison when it is validated: canonical even
+// with LegacyPaths, and normalized like the paths given to the provider. Tokens minted
+// elsewhere for "/a//b.txt" or "./a/b.txt" are then valid for "a/b.txt" and the reverse.
+func (c *StorageConfig) tokenPath(p string) string {
+	p = canonicalize(p)
+	if c != nil && c.PathNormalization == PathNormalizationNone {
+		return p
+	}
+	return normalizeNFC(p)
+}
+
+// canonicalize returns the external form of a path: forward slashes, no leading or
+// trailing slash and no empty or "." segments, so "/docs//a.txt" and "docs/a.txt" are
+// the same path. ".." segments are kept for the providers to reject.
+func canonicalize(p string) string {
+	p = filepath.ToSlash(p)
+	if !strings.HasPrefix(p, "/") && !strings.HasSuffix(p, "/") && !strings.Contains(p, "//") &&
+		p != "." && !strings.HasPrefix(p, "./") && !strings.Contains(p, "/./") && !strings.HasSuffix(p, "/.") {
+		return p
+	}
+
+	segments := strings.Split(p, "/")
+	kept := segments[:0]
+	for _, segment := range segments {
+		if segment != "" && segment != "." {
+			kept = append(kept, segment)
+		}
+	}
+	return strings.Join(kept, "/")
+}
+
+// checkFilePath fails with InvalidPathError when p cannot name a file: it canonicalizes to
+// the root, or its last segment is empty or ".", as in "docs/" or "docs/."
+func checkFilePath(p string) error {
+	slashed := filepath.ToSlash(p)
+	last := slashed[strings.LastIndex(slashed, "/")+1:]
+	if last == "" || last == "." || canonicalize(slashed) == "" {
+		return InvalidPathError(p)
+	}
+	return nil
+}
+
+// checkFilePaths checks the source and destination of a copy or move
+func checkFilePaths(srcPath, dstPath string) error {
+	if err := checkFilePath(srcPath); err != nil {
+		return err
+	}
+	return checkFilePath(dstPath)
+}
+
+// normalizeNFC returns s in NFC, without allocating when it already is
+func normalizeNFC(s string) string {
+	if norm.NFC.IsNormalString(s) {
+		return s
+	}
+	return norm.NFC.String(s)
+}
+
+// NormalizeExistingPaths renames the files and directories under root whose names are
+// not in NFC, so they can be found through normalized paths. It is a one-off migration for
+// files stored before normalization was enabled and returns how many entries were renamed.
+// Entries whose normalized name is already taken are left in place and reported in a
+// *MultiError keyed by path.
+func (s *Storage) NormalizeExistingPaths(ctx context.Context, root string) (int, error) {
+	if err := s.checkWritable(root); err != nil {
+		return 0, err
+	}
+
+	provider, ok := providerAs[PathNormalizationProvider](s.provider)
+	if !ok {
+		return 0, NotSupportedError("provider cannot normalize stored paths")
+	}
+	return provider.NormalizePaths(ctx, s.extensionPath(root))
+}
+
+// normalizingProvider wraps a StorageProvider normalizing the paths it receives and the
+// paths of the files it returns
+type normalizingProvider struct {
+	inner  StorageProvider
+	config *StorageConfig
+}
+
+// Unwrap returns the wrapped provider
+func (p *normalizingProvider) Unwrap() StorageProvider {
+	return p.inner
+}
+
+// normalize normalizes the path and name of a returned file
+func (p *normalizingProvider) normalize(file *FileInfo) {
+	if file != nil {
+		file.Path = p.config.normalizePath(file.Path)
+		file.Name = p.config.normalizePath(file.Name)
+	}
+}
+
+// Upload uploads a file to the normalized path, rejecting paths that cannot name a file
+func (p *normalizingProvider) Upload(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata) (*FileInfo, error) {
+	if err := checkFilePath(path); err != nil {
+		return nil, err
+	}
+	info, err := p.inner.Upload(ctx, p.config.normalizePath(path), reader, metadata)
+	p.normalize(info)
+	return info, err
+}
+
+// Download downloads the file at the normalized path
+func (p *normalizingProvider) Download(ctx context.Context, path string) (io.ReadCloser, *FileInfo, error) {
+	if err := checkFilePath(path); err != nil {
+		return nil, nil, err
+	}
+	reader, info, err := p.inner.Download(ctx, p.config.normalizePath(path))
+	p.normalize(info)
+	return reader, info, err
+}
+
+// Delete deletes the file at the normalized path
+func (p *normalizingProvider) Delete(ctx context.Context, path string) error {
+	if err := checkFilePath(path); err != nil {
+		return err
+	}
+	return p.inner.Delete(ctx, p.config.normalizePath(path))
+}
+
+// Exists checks the file at the normalized path
+func (p *normalizingProvider) Exists(ctx context.Context, path string) (bool, error) {
+	if err := checkFilePath(path); err != nil {
+		return false, err
+	}
+	return p.inner.Exists(ctx, p.config.normalizePath(path))
+}
+
+// GetInfo returns the info of the normalized path
+func (p *normalizingProvider) GetInfo(ctx context.Context, path string) (*FileInfo, error) {
+	info, err := p.inner.GetInfo(ctx, p.config.normalizePath(path))
+	p.normalize(info)
+	return info, err
+}
+
+// List lists the normalized directory, normalizing the listed names
+func (p *normalizingProvider) List(ctx context.Context, path string) ([]*FileInfo, error) {
+	files, err := p.inner.List(ctx, p.config.normalizePath(path))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		p.normalize(file)
+	}
+	return files, nil
+}
+
+// DeleteDirectory deletes the normalized directory
+func (p *normalizingProvider) DeleteDirectory(ctx context.Context, path string) error {
+	return p.inner.DeleteDirectory(ctx, p.config.normalizePath(path))
+}
+
+// Copy copies between normalized paths
+func (p *normalizingProvider) Copy(ctx context.Context, srcPath, dstPath string) error {
+	if err := checkFilePaths(srcPath, dstPath); err != nil {
+		return err
+	}
+	return p.inner.Copy(ctx, p.config.normalizePath(srcPath), p.config.normalizePath(dstPath))
+}
+
+// Move moves between normalized paths
+func (p *normalizingProvider) Move(ctx context.Context, srcPath, dstPath string) error {
+	if err := checkFilePaths(srcPath, dstPath); err != nil {
+		return err
+	}
+	return p.inner.Move(ctx, p.config.normalizePath(srcPath), p.config.normalizePath(dstPath))
+}
+
+// GenerateSignedURL signs the normalized path
+func (p *normalizingProvider) GenerateSignedURL(ctx context.Context, path string, operation SignedURLOperation, expiresIn time.Duration) (string, error) {
+	return p.inner.GenerateSignedURL(ctx, p.config.normalizePath(path), operation, expiresIn)
+}
@@ -0,0 +1,277 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	decomposedName = "cafe\u0301.txt" // As sent by macOS clients
+	composedName   = "caf\u00e9.txt"
+)
+
+func TestPathNormalization(t *testing.T) {
+	ctx := context.Background()
+
+	for _, provider := range []string{"filesystem", "memory"} {
+		t.Run(provider, func(t *testing.T) {
+			config := &StorageConfig{Name: "test", Provider: provider}
+			if provider == "filesystem" {
+				config.FileSystem = &FileSystemConfig{BasePath: t.TempDir()}
+			}
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			if _, err := storage.Upload(ctx, "menus/"+decomposedName, strings.NewReader("menu"), nil); err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+
+			reader, _, err := storage.Download(ctx, "menus/"+composedName)
+			if err != nil {
+				t.Fatalf("Download with the composed name failed: %v", err)
+			}
+			content, _ := io.ReadAll(reader)
+			reader.Close()
+			if string(content) != "menu" {
+				t.Errorf("Unexpected content %q", content)
+			}
+
+			files, err := storage.List(ctx, "menus")
+			if err != nil || len(files) != 1 {
+				t.Fatalf("List failed: %v, %v", files, err)
+			}
+			if files[0].Name != composedName || files[0].Path != "menus/"+composedName {
+				t.Errorf("Expected composed names, got %q and %q", files[0].Name, files[0].Path)
+			}
+		})
+	}
+
+	t.Run("None", func(t *testing.T) {
+		storage, _ := New(&StorageConfig{Name: "test", Provider: "memory", PathNormalization: PathNormalizationNone})
+		if _, err := storage.Upload(ctx, decomposedName, strings.NewReader("menu"), nil); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		if exists, _ := storage.Exists(ctx, composedName); exists {
+			t.Error("Expected paths to be kept byte for byte")
+		}
+		if exists, _ := storage.Exists(ctx, decomposedName); !exists {
+			t.Error("Expected the decomposed path to exist")
+		}
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		config := &StorageConfig{Name: "test", Provider: "memory", PathNormalization: "nfd"}
+		if err := config.Validate(); err == nil {
+			t.Error("Expected an unknown normalization to be rejected")
+		}
+	})
+}
+
+func TestNormalizeExistingPaths(t *testing.T) {
+	ctx := context.Background()
+	basePath := t.TempDir()
+	storage, err := New(&StorageConfig{
+		Name:       "test",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: basePath},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	// Files written before normalization was enabled
+	decomposedDir := "Jose\u0301"
+	writeRaw := func(name, content string) {
+		t.Helper()
+		fullPath := filepath.Join(basePath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeRaw(decomposedDir+"/"+decomposedName, "menu")
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	if err := writeSidecar(filepath.Join(basePath, decomposedDir, decomposedName), &fileSidecar{ExpiresAt: &expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+	writeRaw("conflict/"+decomposedName, "old")
+	writeRaw("conflict/"+composedName, "new")
+
+	if exists, _ := storage.Exists(ctx, "Jos\u00e9/"+composedName); exists {
+		t.Fatal("Expected decomposed files to be unreachable before the migration")
+	}
+
+	renamed, err := storage.NormalizeExistingPaths(ctx, "")
+	if renamed != 2 {
+		t.Errorf("Expected 2 renamed entries, got %d", renamed)
+	}
+	var multiErr *MultiError
+	if !errors.As(err, &multiErr) || len(multiErr.Errors) != 1 || !errors.Is(multiErr.Errors["conflict/"+decomposedName], ErrFileAlreadyExists) {
+		t.Fatalf("Expected a single conflict, got %v", err)
+	}
+
+	if _, err := storage.GetInfo(ctx, "Jos\u00e9/"+composedName); err != nil {
+		t.Fatalf("Expected the migrated file to be reachable: %v", err)
+	}
+	if sidecar := readSidecar(filepath.Join(basePath, "Jos\u00e9", composedName)); sidecar == nil || !sidecar.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected the sidecar to follow the file, got %+v", sidecar)
+	}
+
+	// Both forms of the conflicting name are kept
+	for _, name := range []string{decomposedName, composedName} {
+		if _, err := os.Stat(filepath.Join(basePath, "conflict", name)); err != nil {
+			t.Errorf("Expected %q to be kept: %v", name, err)
+		}
+	}
+
+	t.Run("NotSupported", func(t *testing.T) {
+		storage, _ := New(&StorageConfig{Name: "test", Provider: "memory"})
+		if _, err := storage.NormalizeExistingPaths(ctx, ""); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported, got %v", err)
+		}
+	})
+}
+
+func TestCanonicalPaths(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		path, expected string
+	}{
+		{"test/hello.txt", "test/hello.txt"},
+		{"/test/hello.txt", "test/hello.txt"},
+		{"//test//hello.txt/", "test/hello.txt"},
+		{"./test/./hello.txt", "test/hello.txt"},
+		{"/", ""},
+		{"../escape.txt", "../escape.txt"},
+	}
+	for _, tt := range tests {
+		if canonical := canonicalize(tt.path); canonical != tt.expected {
+			t.Errorf("canonicalize(%q) = %q, expected %q", tt.path, canonical, tt.expected)
+		}
+	}
+
+	fsStorage, memStorage := newTransferStorages(t)
+	for name, storage := range map[string]*Storage{"filesystem": fsStorage, "memory": memStorage} {
+		t.Run(name, func(t *testing.T) {
+			info, err := storage.Upload(ctx, "/test//hello.txt", strings.NewReader("hello"), nil)
+			if err != nil || info.Path != "test/hello.txt" {
+				t.Fatalf("Expected Upload to return the canonical path, got %v, %v", info, err)
+			}
+			if info, err := storage.GetInfo(ctx, "test/hello.txt"); err != nil || info.Path != "test/hello.txt" {
+				t.Errorf("Expected GetInfo to return the canonical path, got %v, %v", info, err)
+			}
+			if _, info, err := storage.Download(ctx, "/test/hello.txt"); err != nil || info.Path != "test/hello.txt" {
+				t.Errorf("Expected Download to return the canonical path, got %v, %v", info, err)
+			}
+			if info, err := storage.Append(ctx, "/test/log.txt", strings.NewReader("x")); err != nil || info.Path != "test/log.txt" {
+				t.Errorf("Expected Append to return the canonical path, got %v, %v", info, err)
+			}
+			if reader, info, err := storage.ReadRange(ctx, "//test/hello.txt", 1, 3); err != nil || info.Path != "test/hello.txt" {
+				t.Errorf("Expected ReadRange to return the canonical path, got %v, %v", info, err)
+			} else {
+				reader.Close()
+			}
+			if exists, err := storage.DirectoryExists(ctx, "/test/"); err != nil || !exists {
+				t.Errorf("Expected DirectoryExists to find the canonical directory, got %v, %v", exists, err)
+			}
+
+			for _, dir := range []string{"test", "/test/", "//test"} {
+				files, err := storage.List(ctx, dir)
+				if err != nil || len(files) != 2 || (files[0].Path != "test/hello.txt" && files[1].Path != "test/hello.txt") {
+					t.Errorf("Expected List(%q) to return canonical paths, got %v, %v", dir, files, err)
+				}
+			}
+			files, _ := storage.List(ctx, "/")
+			if len(files) != 1 || files[0].Path != "test" {
+				t.Errorf("Expected the root listing to return canonical paths, got %v", files)
+			}
+		})
+	}
+
+	t.Run("Legacy", func(t *testing.T) {
+		storage, err := New(&StorageConfig{Name: "test", Provider: "memory", LegacyPaths: true})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		info, err := storage.Upload(ctx, "/test/hello.txt", strings.NewReader("hello"), nil)
+		if err != nil || info.Path != "/test/hello.txt" {
+			t.Errorf("Expected the path as passed, got %v, %v", info, err)
+		}
+	})
+}
+
+func TestFilePathSegments(t *testing.T) {
+	ctx := context.Background()
+
+	fsStorage, memStorage := newTransferStorages(t)
+	for name, storage := range map[string]*Storage{"filesystem": fsStorage, "memory": memStorage} {
+		t.Run(name, func(t *testing.T) {
+			stored := []struct {
+				path, expected string
+			}{
+				{"folder//file.txt", "folder/file.txt"},
+				{"/folder/./nested/file.txt", "folder/nested/file.txt"},
+				{"./folder//./other.txt", "folder/other.txt"},
+			}
+			for _, tt := range stored {
+				info, err := storage.Upload(ctx, tt.path, strings.NewReader("x"), nil)
+				if err != nil || info.Path != tt.expected {
+					t.Errorf("Upload(%q) = %v, %v, expected %q", tt.path, info, err, tt.expected)
+					continue
+				}
+				if exists, err := storage.Exists(ctx, tt.expected); err != nil || !exists {
+					t.Errorf("Expected %q to be found through its clean path, got %v, %v", tt.expected, exists, err)
+				}
+			}
+
+			// Listed paths lead back to the same entries
+			files, err := storage.List(ctx, "folder//")
+			if err != nil || len(files) != 3 {
+				t.Fatalf("List failed: %v, %v", files, err)
+			}
+			for _, file := range files {
+				info, err := storage.GetInfo(ctx, file.Path)
+				if err != nil || info.Path != file.Path {
+					t.Errorf("Expected listed path %q to round-trip, got %v, %v", file.Path, info, err)
+				}
+			}
+
+			for _, path := range []string{"", "/", ".", "//", "./", "/./", "folder/", "folder//", "folder/."} {
+				if _, err := storage.Upload(ctx, path, strings.NewReader("x"), nil); !errors.Is(err, ErrInvalidPath) {
+					t.Errorf("Expected Upload(%q) to fail with ErrInvalidPath, got %v", path, err)
+				}
+				if _, _, err := storage.Download(ctx, path); !errors.Is(err, ErrInvalidPath) {
+					t.Errorf("Expected Download(%q) to fail with ErrInvalidPath, got %v", path, err)
+				}
+				if err := storage.Delete(ctx, path); !errors.Is(err, ErrInvalidPath) {
+					t.Errorf("Expected Delete(%q) to fail with ErrInvalidPath, got %v", path, err)
+				}
+				if _, err := storage.Exists(ctx, path); !errors.Is(err, ErrInvalidPath) {
+					t.Errorf("Expected Exists(%q) to fail with ErrInvalidPath, got %v", path, err)
+				}
+				if _, err := storage.Append(ctx, path, strings.NewReader("x")); !errors.Is(err, ErrInvalidPath) {
+					t.Errorf("Expected Append(%q) to fail with ErrInvalidPath, got %v", path, err)
+				}
+				if err := storage.Copy(ctx, "folder/file.txt", path); !errors.Is(err, ErrInvalidPath) {
+					t.Errorf("Expected Copy to %q to fail with ErrInvalidPath, got %v", path, err)
+				}
+			}
+
+			// Nothing was written by the rejected calls
+			if files, _ := storage.List(ctx, ""); len(files) != 1 || files[0].Path != "folder" {
+				t.Errorf("Expected only the folder at the root, got %v", files)
+			}
+		})
+	}
+}
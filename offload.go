@@ -0,0 +1,70 @@
+package vsaasstorage
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Headers that hand a download over to the web server, see FileSystemConfig.OffloadHeader
+const (
+	OffloadHeaderAccelRedirect = "X-Accel-Redirect"
+	OffloadHeaderSendfile      = "X-Sendfile"
+)
+
+// offloadTarget returns the offload header and its value for a file of the filesystem
+// provider, or "" when the process must serve it: offloading is off, the bytes sent would
+// differ from the stored ones, or the file is not on disk, e.g. it comes from the fallback
+func (s *Storage) offloadTarget(path string, info *FileInfo) (string, string) {
+	provider, ok := providerAs[*FileSystemProvider](s.provider)
+	if !ok || provider.config.FileSystem == nil || provider.config.FileSystem.OffloadHeader == "" {
+		return "", ""
+	}
+	if info.ContentEncoding != "" || (s.config.FaststartRemux && isMP4(info)) {
+		return "", ""
+	}
+	fullPath, err := provider.getFullPath(s.extensionPath(path))
+	if err != nil {
+		return "", ""
+	}
+	if stat, err := os.Stat(fullPath); err != nil || !stat.Mode().IsRegular() {
+		return "", ""
+	}
+
+	fsConfig := provider.config.FileSystem
+	if fsConfig.OffloadHeader == OffloadHeaderSendfile {
+		absPath, err := filepath.Abs(fullPath)
+		if err != nil {
+			return "", ""
+		}
+		return OffloadHeaderSendfile, absPath
+	}
+	relPath, err := filepath.Rel(fsConfig.BasePath, fullPath)
+	if err != nil {
+		return "", ""
+	}
+	location := strings.TrimSuffix(fsConfig.OffloadPrefix, "/") + "/" + filepath.ToSlash(relPath)
+	return OffloadHeaderAccelRedirect, (&url.URL{Path: location}).EscapedPath()
+}
+
+// writeOffload answers with the headers of the file and the offload header, leaving the
+// body to the web server
+func writeOffload(response *echo.Response, info *FileInfo, offloadHeader, target string, headers func(header http.Header)) error {
+	header := response.Header()
+	header.Set("Content-Type", info.ContentType)
+	header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", info.Name))
+	if info.ETag != "" {
+		header.Set("ETag", quoteETag(info.ETag))
+	}
+	if headers != nil {
+		headers(header)
+	}
+	header.Set(offloadHeader, target)
+	response.WriteHeader(http.StatusOK)
+	return nil
+}
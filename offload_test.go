@@ -0,0 +1,97 @@
+package vsaasstorage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestDownloadOffload(t *testing.T) {
+	ctx := context.Background()
+	basePath := t.TempDir()
+	storage, err := New(&StorageConfig{
+		Name:     "test",
+		Provider: "filesystem",
+		FileSystem: &FileSystemConfig{
+			BasePath:      basePath,
+			CreateDirs:    true,
+			OffloadHeader: OffloadHeaderAccelRedirect,
+			OffloadPrefix: "/protected/",
+		},
+		SignedURL: &SignedURLConfig{Enabled: true, ExpiresIn: time.Minute, SecretKey: "secret"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.Upload(ctx, "cameras/cam 1/clip.mp4", strings.NewReader("0123456789"), &FileMetadata{ContentType: "video/mp4"})
+
+	stream := func(target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		c := &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder)}
+		if err := storage.StreamFile(c, "cameras/cam 1/clip.mp4"); err != nil {
+			if httpErr, ok := err.(*echo.HTTPError); ok {
+				recorder.Code = httpErr.Code
+			}
+		}
+		return recorder
+	}
+
+	recorder := stream("/clip.mp4")
+	if recorder.Code != http.StatusOK || recorder.Body.Len() != 0 {
+		t.Fatalf("expected an empty 200, got %d with %q", recorder.Code, recorder.Body.String())
+	}
+	if location := recorder.Header().Get(OffloadHeaderAccelRedirect); location != "/protected/cameras/cam%201/clip.mp4" {
+		t.Errorf("unexpected X-Accel-Redirect %q", location)
+	}
+	if recorder.Header().Get("Content-Type") != "video/mp4" ||
+		recorder.Header().Get("Content-Disposition") != `attachment; filename="clip.mp4"` {
+		t.Errorf("unexpected headers %v", recorder.Header())
+	}
+
+	// The token is still checked before the download is handed over
+	recorder = stream("/clip.mp4?token=forged")
+	if recorder.Code != http.StatusUnauthorized || recorder.Header().Get(OffloadHeaderAccelRedirect) != "" {
+		t.Errorf("expected a 401 without offload, got %d %v", recorder.Code, recorder.Header())
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"path": "cameras/cam 1/clip.mp4",
+		"op":   string(SignedURLOperationGet),
+		"exp":  time.Now().Add(time.Minute).Unix(),
+	})
+	signed, _ := token.SignedString([]byte("secret"))
+	if recorder = stream("/clip.mp4?token=" + signed); recorder.Header().Get(OffloadHeaderAccelRedirect) == "" {
+		t.Errorf("expected a signed download to be offloaded, got %d %v", recorder.Code, recorder.Header())
+	}
+
+	storage.config.FileSystem.OffloadHeader = OffloadHeaderSendfile
+	recorder = stream("/clip.mp4")
+	if path := recorder.Header().Get(OffloadHeaderSendfile); path != filepath.Join(basePath, "cameras", "cam 1", "clip.mp4") {
+		t.Errorf("unexpected X-Sendfile %q", path)
+	}
+
+	storage.config.FileSystem.OffloadHeader = ""
+	if recorder = stream("/clip.mp4"); recorder.Body.String() != "0123456789" {
+		t.Errorf("expected the content without offload, got %q", recorder.Body.String())
+	}
+}
+
+func TestDownloadOffloadValidation(t *testing.T) {
+	for _, config := range []*FileSystemConfig{
+		{BasePath: "/data", OffloadHeader: "X-Forward"},
+		{BasePath: "/data", OffloadHeader: OffloadHeaderAccelRedirect},
+		{BasePath: "/data", OffloadHeader: OffloadHeaderAccelRedirect, OffloadPrefix: "protected"},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", config)
+		}
+	}
+}
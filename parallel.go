@@ -0,0 +1,88 @@
+package vsaasstorage
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultConcurrency is the number of parallel operations used by directory and batch
+// operations when StorageConfig.Concurrency is not set
+const DefaultConcurrency = 8
+
+// DefaultUploadConcurrency is the number of files of a multi-file upload stored in
+// parallel when StorageConfig.UploadConcurrency is not set
+const DefaultUploadConcurrency = 4
+
+// ParallelExecutor runs an operation on many paths with a bounded number of calls in flight
+type ParallelExecutor struct {
+	concurrency int
+}
+
+// NewParallelExecutor creates an executor running up to concurrency operations at a time.
+// Values below 1 use DefaultConcurrency.
+func NewParallelExecutor(concurrency int) *ParallelExecutor {
+	if concurrency < 1 {
+		concurrency = DefaultConcurrency
+	}
+	return &ParallelExecutor{concurrency: concurrency}
+}
+
+// Run calls fn for every path and waits for the calls to finish. Failures do not stop
+// the others and are returned together as a *MultiError. Once ctx is cancelled no new
+// calls are started and a CanceledError wrapping the context error is returned.
+func (e *ParallelExecutor) Run(ctx context.Context, paths []string, fn func(ctx context.Context, path string) error) error {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs MultiError
+	)
+	sem := make(chan struct{}, e.concurrency)
+
+schedule:
+	for _, path := range paths {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break schedule
+		}
+
+		// A slot may have been free even though the context was already cancelled
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(path string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := fn(ctx, path); err != nil {
+				mu.Lock()
+				errs.Add(path, err)
+				mu.Unlock()
+			}
+		}(path)
+	}
+	wg.Wait()
+
+	if err := checkContext(ctx, ""); err != nil {
+		return err
+	}
+	return errs.ErrorOrNil()
+}
+
+// executor returns a parallel executor using the configured concurrency
+func (s *Storage) executor() *ParallelExecutor {
+	return NewParallelExecutor(s.config.Concurrency)
+}
+
+// uploadConcurrency returns the configured fan-out of multi-file uploads
+func (c *StorageConfig) uploadConcurrency() int {
+	if c.UploadConcurrency > 0 {
+		return c.UploadConcurrency
+	}
+	return DefaultUploadConcurrency
+}
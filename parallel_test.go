@@ -0,0 +1,79 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallelExecutor(t *testing.T) {
+	ctx := context.Background()
+
+	paths := make([]string, 50)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("thumbs/%02d.jpg", i)
+	}
+
+	t.Run("Bounded concurrency", func(t *testing.T) {
+		var running, peak, calls int32
+		err := NewParallelExecutor(4).Run(ctx, paths, func(ctx context.Context, path string) error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&calls, 1)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if calls != 50 || peak > 4 {
+			t.Errorf("Expected 50 calls with at most 4 in flight, got %d calls and peak %d", calls, peak)
+		}
+	})
+
+	t.Run("Failures are aggregated", func(t *testing.T) {
+		err := NewParallelExecutor(0).Run(ctx, paths, func(ctx context.Context, path string) error {
+			if path == "thumbs/07.jpg" || path == "thumbs/03.jpg" {
+				return FileNotFoundError(path)
+			}
+			return nil
+		})
+
+		var multiErr *MultiError
+		if !errors.As(err, &multiErr) {
+			t.Fatalf("Expected a MultiError, got %v", err)
+		}
+		if multiErr.Len() != 2 || multiErr.Paths()[0] != "thumbs/03.jpg" {
+			t.Errorf("Unexpected failures %v", multiErr.Paths())
+		}
+		if !errors.Is(err, ErrFileNotFound) {
+			t.Error("Expected the MultiError to unwrap to ErrFileNotFound")
+		}
+	})
+
+	t.Run("Cancellation stops scheduling", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		var calls int32
+		err := NewParallelExecutor(1).Run(ctx, paths, func(ctx context.Context, path string) error {
+			if atomic.AddInt32(&calls, 1) == 3 {
+				cancel()
+			}
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		if calls > 4 {
+			t.Errorf("Expected scheduling to stop after cancellation, got %d calls", calls)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package vsaasstorage
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultFileSystemMaxNameLength is the 255-byte name limit of ext4, XFS and most local
+	// filesystems, minus the room taken by the name of the metadata sidecar
+	DefaultFileSystemMaxNameLength = 255 - len("."+sidecarSuffix)
+	// DefaultFileSystemMaxPathLength is PATH_MAX on Linux
+	DefaultFileSystemMaxPathLength = 4096
+	// DefaultS3MaxKeyLength is the key limit of S3
+	DefaultS3MaxKeyLength = 1024
+)
+
+// pathLimits returns the byte limits of a path component and of a whole path, 0 meaning
+// unlimited. The configured values override the provider defaults; negative values disable them.
+func (c *StorageConfig) pathLimits() (maxName, maxPath int) {
+	switch c.Provider {
+	case "filesystem":
+		maxName, maxPath = DefaultFileSystemMaxNameLength, DefaultFileSystemMaxPathLength
+	case "s3":
+		maxPath = DefaultS3MaxKeyLength
+	}
+	if c.MaxNameLength != 0 {
+		maxName = max(c.MaxNameLength, 0)
+	}
+	if c.MaxPathLength != 0 {
+		maxPath = max(c.MaxPathLength, 0)
+	}
+	return maxName, maxPath
+}
+
+// checkPathLength fails with ErrorCodeInvalidPath when the stored form of a path, or one
+// of its components, exceeds the limits of the provider
+func (c *StorageConfig) checkPathLength(p string) error {
+	maxName, maxPath := c.pathLimits()
+	clean := c.normalizePath(cleanPath(p))
+
+	if maxPath > 0 && len(clean) > maxPath {
+		return NewStorageErrorWithPath(ErrorCodeInvalidPath,
+			fmt.Sprintf("path is %d bytes, the limit is %d", len(clean), maxPath), p)
+	}
+	if maxName > 0 {
+		for _, name := range strings.Split(clean, "/") {
+			if len(name) > maxName {
+				return NewStorageErrorWithPath(ErrorCodeInvalidPath,
+					fmt.Sprintf("name %q is %d bytes, the limit is %d", name, len(name), maxName), p)
+			}
+		}
+	}
+	return nil
+}
+
+// nameLimit returns the longest name that fits in dir, or 0 when unlimited
+func (c *StorageConfig) nameLimit(dir string) int {
+	maxName, maxPath := c.pathLimits()
+	if maxPath > 0 {
+		room := maxPath
+		if dir = c.normalizePath(cleanPath(dir)); dir != "" {
+			room -= len(dir) + 1
+		}
+		room = max(room, 1)
+		if maxName == 0 || room < maxName {
+			maxName = room
+		}
+	}
+	return maxName
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
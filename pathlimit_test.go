@@ -0,0 +1,123 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestPathLimits(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Defaults", func(t *testing.T) {
+		tests := []struct {
+			config           StorageConfig
+			maxName, maxPath int
+		}{
+			{StorageConfig{Provider: "filesystem"}, 249, 4096},
+			{StorageConfig{Provider: "s3"}, 0, 1024},
+			{StorageConfig{Provider: "memory"}, 0, 0},
+			{StorageConfig{Provider: "filesystem", MaxNameLength: 143}, 143, 4096},
+			{StorageConfig{Provider: "s3", MaxPathLength: -1}, 0, 0},
+		}
+		for _, tt := range tests {
+			if maxName, maxPath := tt.config.pathLimits(); maxName != tt.maxName || maxPath != tt.maxPath {
+				t.Errorf("%s: expected %d/%d, got %d/%d", tt.config.Provider, tt.maxName, tt.maxPath, maxName, maxPath)
+			}
+		}
+	})
+
+	t.Run("Filesystem", func(t *testing.T) {
+		storage, err := New(&StorageConfig{
+			Name:       "test",
+			Provider:   "filesystem",
+			FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+
+		name := strings.Repeat("a", DefaultFileSystemMaxNameLength+1)
+		_, err = storage.Upload(ctx, "tenants/"+name+"/file.txt", strings.NewReader("data"), nil)
+		if !errors.Is(err, ErrInvalidPath) {
+			t.Fatalf("Expected ErrInvalidPath, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "is 250 bytes, the limit is 249") {
+			t.Errorf("Expected the component and the limit in the message, got %q", err.Error())
+		}
+
+		if _, err := storage.Upload(ctx, "tenants/"+name[:DefaultFileSystemMaxNameLength], strings.NewReader("data"), nil); err != nil {
+			t.Errorf("Upload at the limit failed: %v", err)
+		}
+		if err := storage.Copy(ctx, "tenants/"+name[:DefaultFileSystemMaxNameLength], "copies/"+name); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("Expected ErrInvalidPath from Copy, got %v", err)
+		}
+	})
+
+	t.Run("Configured", func(t *testing.T) {
+		storage, _ := New(&StorageConfig{Name: "test", Provider: "memory", MaxPathLength: 20})
+		_, err := storage.Upload(ctx, "cameras/12/recording.mp4", strings.NewReader("data"), nil)
+		if !errors.Is(err, ErrInvalidPath) || !strings.Contains(err.Error(), "path is 24 bytes, the limit is 20") {
+			t.Errorf("Expected the path limit to apply, got %v", err)
+		}
+		if err := storage.CreateDirectory(ctx, "cameras/12/recordings"); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("Expected ErrInvalidPath from CreateDirectory, got %v", err)
+		}
+	})
+}
+
+func TestGenerateUniqueFilenameLimit(t *testing.T) {
+	name := generateUniqueFilename(strings.Repeat("a", 300)+".jpg", 255)
+	if len(name) != 255 || !strings.HasSuffix(name, ".jpg") || name[242] != '_' {
+		t.Errorf("Expected the base name to be truncated, got %d bytes: %q", len(name), name)
+	}
+
+	// Truncation never splits a character
+	name = generateUniqueFilename(strings.Repeat("é", 20)+".png", 30)
+	if len(name) > 30 || !utf8.ValidString(name) || !strings.HasSuffix(name, ".png") {
+		t.Errorf("Unexpected truncated name %q", name)
+	}
+
+	if name := generateUniqueFilename("photo.jpg", 255); !strings.HasPrefix(name, "photo_") {
+		t.Errorf("Expected short names to be kept, got %q", name)
+	}
+}
+
+func TestUploadPathLimits(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{
+		Name:       "test",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	original := strings.Repeat("informe-mensual-", 20) + ".pdf"
+	source := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(source, []byte("%PDF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := &rest.UploadedFile{Path: source, Filename: original, OriginalName: original, MimeType: "application/pdf"}
+
+	result, err := storage.UploadFromUploadedFile(ctx, file, "file", "tenants/acme/reports")
+	if err != nil {
+		t.Fatalf("Upload with a long original name failed: %v", err)
+	}
+	if len(result.Filename) != DefaultFileSystemMaxNameLength || !strings.HasSuffix(result.Filename, ".pdf") {
+		t.Errorf("Expected a name truncated to the limit, got %d bytes: %q", len(result.Filename), result.Filename)
+	}
+
+	// Explicit names are not shortened
+	_, err = storage.UploadFromUploadedFile(ctx, file, "file", "tenants/acme/reports", strings.Repeat("b", 260))
+	if !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Expected ErrInvalidPath for a long explicit name, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	rest "github.com/xompass/vsaas-rest"
+	"github.com/xompass/vsaas-rest/http_errors"
+)
+
+const (
+	// PosterSuffix is appended to a video path to name its poster
+	PosterSuffix = ".poster.jpg"
+	// PosterDerivation is the derivation that generates posters when Poster is configured
+	PosterDerivation = "poster"
+	// DefaultPosterAt is the position of the frame used as poster
+	DefaultPosterAt = time.Second
+	// DefaultPosterQuality is the JPEG quality of posters
+	DefaultPosterQuality = 80
+	// DefaultFFmpegTimeout bounds a single frame extraction
+	DefaultFFmpegTimeout = 30 * time.Second
+)
+
+// FrameExtractor extracts a single frame of a video
+type FrameExtractor interface {
+	ExtractFrame(ctx context.Context, src io.Reader, at time.Duration) (image.Image, error)
+}
+
+// PosterConfig enables poster generation for videos uploaded through the handlers
+type PosterConfig struct {
+	At         time.Duration  `json:"at,omitempty"`         // Position of the frame, defaults to 1 second
+	Prefix     string         `json:"prefix,omitempty"`     // Store posters under this prefix instead of next to the video
+	Quality    int            `json:"quality,omitempty"`    // JPEG quality, defaults to 80
+	FFmpegPath string         `json:"ffmpegPath,omitempty"` // ffmpeg binary, defaults to "ffmpeg" in PATH
+	Extractor  FrameExtractor `json:"-"`                    // Replaces the ffmpeg extractor
+}
+
+// extractor returns the configured extractor, or ffmpeg
+func (c *PosterConfig) extractor() FrameExtractor {
+	if c.Extractor != nil {
+		return c.Extractor
+	}
+	return &FFmpegFrameExtractor{Path: c.FFmpegPath}
+}
+
+// pathTemplate returns the derived path template of posters
+func (c *PosterConfig) pathTemplate() string {
+	if c.Prefix != "" {
+		return cleanPath(c.Prefix) + "/{path}" + PosterSuffix
+	}
+	return "{path}" + PosterSuffix
+}
+
+// FFmpegFrameExtractor extracts frames by running ffmpeg
+type FFmpegFrameExtractor struct {
+	Path    string        // ffmpeg binary, defaults to "ffmpeg" in PATH
+	Timeout time.Duration // Defaults to 30 seconds
+}
+
+// ExtractFrame runs ffmpeg to decode the frame at the given position. Files are passed
+// by name, as MP4s with the moov box at the end cannot be read from a pipe.
+func (f *FFmpegFrameExtractor) ExtractFrame(ctx context.Context, src io.Reader, at time.Duration) (image.Image, error) {
+	binary := f.Path
+	if binary == "" {
+		binary = "ffmpeg"
+	}
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = DefaultFFmpegTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	input := "pipe:0"
+	if file, ok := src.(*os.File); ok {
+		input = file.Name()
+	}
+
+	cmd := exec.CommandContext(ctx, binary,
+		"-hide_banner", "-loglevel", "error",
+		"-ss", fmt.Sprintf("%.3f", at.Seconds()),
+		"-i", input,
+		"-frames:v", "1",
+		"-f", "image2pipe", "-vcodec", "mjpeg",
+		"pipe:1",
+	)
+	if input == "pipe:0" {
+		cmd.Stdin = src
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("ffmpeg: %w: %s", err, message)
+		}
+		return nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+	if stdout.Len() == 0 {
+		return nil, errors.New("ffmpeg: no frame at the requested position")
+	}
+
+	return jpeg.Decode(&stdout)
+}
+
+// videoExtensions lists the extensions treated as video when the stored type is generic
+var videoExtensions = map[string]bool{
+	".mp4": true, ".m4v": true, ".mov": true, ".mkv": true, ".webm": true, ".avi": true, ".ts": true,
+}
+
+// isVideo reports whether a content type is a video
+func isVideo(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(contentType), "video/")
+}
+
+// isVideoFile reports whether a stored file is a video, by content type or extension
+func isVideoFile(info *FileInfo) bool {
+	return !info.IsDirectory && (isVideo(info.ContentType) || videoExtensions[strings.ToLower(path.Ext(info.Path))])
+}
+
+// createPoster stores the poster of a video just uploaded from file and returns its path.
+// Failures are logged and counted but never returned, as the video itself is already stored.
+func (s *Storage) createPoster(ctx context.Context, video *FileInfo, file *os.File) string {
+	d, ok := s.derived.lookup(PosterDerivation)
+	if !ok {
+		return ""
+	}
+	poster, err := s.generateDerived(ctx, d, video.Path, video, file)
+	if err != nil {
+		s.config.log(ctx, LogLevelWarn, "failed to create video poster", map[string]interface{}{
+			"path":  video.Path,
+			"error": err.Error(),
+		})
+		s.config.incCounter("storage_poster_failures_total", 1, map[string]string{"storage": s.config.Name})
+		return ""
+	}
+	s.config.incCounter("storage_posters_created_total", 1, map[string]string{"storage": s.config.Name})
+	return poster.Path
+}
+
+// derive is the DeriveFunc of the poster derivation. It extracts the configured frame,
+// falling back to the first one for videos shorter than the configured position.
+func (c *PosterConfig) derive(ctx context.Context, src *FileInfo, r io.Reader, w io.Writer) error {
+	// Frames are extracted from files, which ffmpeg reads by name
+	file, ok := r.(*os.File)
+	if !ok {
+		spilled, err := os.CreateTemp("", "vsaas-poster-*"+path.Ext(src.Path))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spilled.Name())
+		defer spilled.Close()
+		if _, err := io.Copy(spilled, r); err != nil {
+			return err
+		}
+		file = spilled
+	}
+
+	extractor := c.extractor()
+	at := c.At
+	if at <= 0 {
+		at = DefaultPosterAt
+	}
+	frame, err := extractFrameAt(ctx, extractor, file, at)
+	if err != nil {
+		if frame, err = extractFrameAt(ctx, extractor, file, 0); err != nil {
+			return err
+		}
+	}
+
+	quality := c.Quality
+	if quality <= 0 || quality > 100 {
+		quality = DefaultPosterQuality
+	}
+	return jpeg.Encode(w, frame, &jpeg.Options{Quality: quality})
+}
+
+// extractFrameAt rewinds file and extracts the frame at the given position
+func extractFrameAt(ctx context.Context, extractor FrameExtractor, file *os.File, at time.Duration) (image.Image, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	frame, err := extractor.ExtractFrame(ctx, file, at)
+	if err == nil && frame == nil {
+		err = errors.New("no frame extracted")
+	}
+	return frame, err
+}
+
+// PosterHandler creates a handler function that serves the poster of a video path, or the
+// file itself for images. Posters are created on upload when Poster is configured, and
+// generated on demand with GetDerived for videos stored by other means or changed since.
+func (s *Storage) PosterHandler() func(c *rest.EndpointContext) error {
+	return s.traced(func(c *rest.EndpointContext) error {
+		filePath := requestPath(c)
+
+		if filePath == "" {
+			return http_errors.BadRequestError("File path is required")
+		}
+		if err := s.authorize(c, filePath, SignedURLOperationGet); err != nil {
+			return err
+		}
+
+		if info, err := s.GetInfo(c.Context(), filePath); err == nil && isVideoFile(info) {
+			if _, ok := s.derived.lookup(PosterDerivation); !ok {
+				filePath = cleanPath(filePath) + PosterSuffix
+			} else if poster, err := s.GetDerived(c.Context(), filePath, PosterDerivation); err == nil {
+				filePath = poster.Path
+			} else {
+				return httpError(err, "Failed to create poster")
+			}
+		}
+
+		return s.serveRecorded(c, filePath, &AccessEvent{Operation: AccessOperationDownload}, func(header http.Header) {
+			header.Set("Content-Disposition", "inline")
+		})
+	})
+}
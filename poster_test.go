@@ -0,0 +1,183 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// fakeExtractor returns a solid frame, or fails for positions listed in failAt
+type fakeExtractor struct {
+	failAt map[time.Duration]bool
+	calls  []time.Duration
+}
+
+func (f *fakeExtractor) ExtractFrame(ctx context.Context, src io.Reader, at time.Duration) (image.Image, error) {
+	f.calls = append(f.calls, at)
+	if f.failAt[at] {
+		return nil, errors.New("no frame")
+	}
+	if _, err := io.ReadAll(src); err != nil {
+		return nil, err
+	}
+	frame := image.NewRGBA(image.Rect(0, 0, 16, 9))
+	for i := range frame.Pix {
+		frame.Pix[i] = 0x80
+	}
+	return frame, nil
+}
+
+// countingMetrics records counter totals by name
+type countingMetrics struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	gauges   map[string]float64
+}
+
+func (m *countingMetrics) IncCounter(name string, value int64, labels map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counters == nil {
+		m.counters = make(map[string]int64)
+	}
+	m.counters[name] += value
+}
+
+func (m *countingMetrics) SetGauge(name string, value float64, labels map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.gauges == nil {
+		m.gauges = make(map[string]float64)
+	}
+	m.gauges[name] = value
+}
+
+// writeTestVideo writes a file to upload as a video
+func writeTestVideo(t *testing.T) *rest.UploadedFile {
+	t.Helper()
+	source := filepath.Join(t.TempDir(), "clip.mp4")
+	if err := os.WriteFile(source, buildTestMP4(true), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &rest.UploadedFile{Path: source, Filename: "clip.mp4", OriginalName: "clip.mp4", MimeType: "video/mp4"}
+}
+
+func TestVideoPoster(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Created", func(t *testing.T) {
+		extractor := &fakeExtractor{failAt: map[time.Duration]bool{2 * time.Second: true}}
+		metrics := &countingMetrics{}
+		storage, _ := New(&StorageConfig{
+			Name:     "test",
+			Provider: "memory",
+			Poster:   &PosterConfig{At: 2 * time.Second, Prefix: "posters", Extractor: extractor},
+			Metrics:  metrics,
+		})
+
+		result, err := storage.UploadFromUploadedFile(ctx, writeTestVideo(t), "file", "cameras/1", "clip")
+		if err != nil {
+			t.Fatalf("upload failed: %v", err)
+		}
+		if result.Poster != "posters/cameras/1/clip.mp4.poster.jpg" {
+			t.Fatalf("unexpected poster path %q", result.Poster)
+		}
+		if len(extractor.calls) != 2 || extractor.calls[1] != 0 {
+			t.Errorf("expected a retry on the first frame, got %v", extractor.calls)
+		}
+
+		reader, info, err := storage.Download(ctx, result.Poster)
+		if err != nil {
+			t.Fatalf("poster not stored: %v", err)
+		}
+		frame, err := jpeg.Decode(reader)
+		reader.Close()
+		if err != nil || frame.Bounds().Dx() != 16 || info.ContentType != "image/jpeg" {
+			t.Errorf("unexpected poster %v, %v", info, err)
+		}
+		if metrics.counters["storage_posters_created_total"] != 1 {
+			t.Errorf("unexpected counters %v", metrics.counters)
+		}
+
+		// The poster handler resolves video paths to their poster
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/poster", nil)
+		recorder := httptest.NewRecorder()
+		c := e.NewContext(request, recorder)
+		c.SetParamNames("path")
+		c.SetParamValues("cameras/1/clip.mp4")
+		if err := storage.PosterHandler()(&rest.EndpointContext{EchoCtx: c}); err != nil {
+			t.Fatalf("handler failed: %v", err)
+		}
+		if recorder.Header().Get("Content-Type") != "image/jpeg" || recorder.Header().Get("Content-Disposition") != "inline" {
+			t.Errorf("unexpected poster response %v", recorder.Header())
+		}
+	})
+
+	t.Run("FailureKeepsUpload", func(t *testing.T) {
+		extractor := &fakeExtractor{failAt: map[time.Duration]bool{DefaultPosterAt: true, 0: true}}
+		metrics := &countingMetrics{}
+		storage, _ := New(&StorageConfig{
+			Name:     "test",
+			Provider: "memory",
+			Poster:   &PosterConfig{Extractor: extractor},
+			Metrics:  metrics,
+		})
+
+		result, err := storage.UploadFromUploadedFile(ctx, writeTestVideo(t), "file", "cameras/1", "clip")
+		if err != nil {
+			t.Fatalf("expected the upload to succeed, got %v", err)
+		}
+		if result.Poster != "" || metrics.counters["storage_poster_failures_total"] != 1 {
+			t.Errorf("expected a counted failure, got %q and %v", result.Poster, metrics.counters)
+		}
+		if exists, _ := storage.Exists(ctx, "cameras/1/clip.mp4.poster.jpg"); exists {
+			t.Error("expected no poster")
+		}
+	})
+}
+
+func TestFFmpegFrameExtractor(t *testing.T) {
+	binary, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+
+	source := filepath.Join(t.TempDir(), "clip.mp4")
+	generate := exec.Command(binary, "-hide_banner", "-loglevel", "error", "-f", "lavfi", "-i", "color=c=red:s=64x36:d=2",
+		"-pix_fmt", "yuv420p", source)
+	if err := generate.Run(); err != nil {
+		t.Skipf("ffmpeg cannot encode test video: %v", err)
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	frame, err := (&FFmpegFrameExtractor{Path: binary}).ExtractFrame(context.Background(), file, time.Second)
+	if err != nil {
+		t.Fatalf("ExtractFrame failed: %v", err)
+	}
+	if frame.Bounds().Dx() != 64 {
+		t.Errorf("unexpected frame size %v", frame.Bounds())
+	}
+	if r, _, _, _ := frame.At(32, 18).RGBA(); r < 0xc000 {
+		t.Errorf("expected a red frame, got %v", color.RGBAModel.Convert(frame.At(32, 18)))
+	}
+}
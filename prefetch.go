@@ -0,0 +1,131 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	rest "github.com/xompass/vsaas-rest"
+	"github.com/xompass/vsaas-rest/http_errors"
+)
+
+// maxPrefetchFiles bounds the number of paths accepted by PrefetchHandler
+const maxPrefetchFiles = 1000
+
+// PrefetchProvider is implemented by caching provider wrappers that can load a file into
+// their local cache ahead of its download. Implementations must stay within their cache
+// size, evicting the least recently used entries, and skip a file that would take more
+// than their limit rather than evict the whole cache for it.
+type PrefetchProvider interface {
+	// Prefetch loads a file into the cache, failing with ErrFileNotFound if it does not exist
+	Prefetch(ctx context.Context, path string) error
+}
+
+// Prefetch warms the storage for the downloads of paths that are expected soon. With a
+// caching provider wrapper the files are loaded into its cache in parallel, up to
+// StorageConfig.Concurrency at a time; otherwise only their existence is checked, so the
+// caller still learns which are available. Files that are missing, invalid or failed to
+// load are reported in a *MultiError keyed by path.
+func (s *Storage) Prefetch(ctx context.Context, paths []string) error {
+	var errs MultiError
+	valid := make([]string, 0, len(paths))
+	seen := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		if err := checkFilePath(p); err != nil {
+			errs.Add(p, err)
+			continue
+		}
+		if !seen[p] {
+			seen[p] = true
+			valid = append(valid, p)
+		}
+	}
+
+	provider, ok := providerAs[PrefetchProvider](s.provider)
+	if !ok {
+		exists, err := s.ExistsMany(ctx, valid)
+		if exists == nil {
+			return err
+		}
+		var failed *MultiError
+		if errors.As(err, &failed) {
+			for p, err := range failed.Errors {
+				errs.Add(p, err)
+			}
+		}
+		for p, found := range exists {
+			if !found {
+				errs.Add(p, FileNotFoundError(p))
+			}
+		}
+		return errs.ErrorOrNil()
+	}
+
+	err := s.executor().Run(ctx, valid, func(ctx context.Context, p string) error {
+		return provider.Prefetch(ctx, s.extensionPath(p))
+	})
+	var failed *MultiError
+	switch {
+	case errors.As(err, &failed):
+		for p, err := range failed.Errors {
+			errs.Add(p, err)
+		}
+	case err != nil:
+		return err
+	}
+	return errs.ErrorOrNil()
+}
+
+// prefetchStatus is the outcome of one path in a PrefetchHandler response
+type prefetchStatus struct {
+	Path    string    `json:"path"`
+	Status  int       `json:"status"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// PrefetchHandler creates a handler that prefetches the files listed in a JSON array of
+// paths, answering with the status of each: 200 when it was loaded into the cache or,
+// without one, when it exists, and the status of its error otherwise. "cached" tells the
+// two cases apart.
+func (s *Storage) PrefetchHandler() func(c *rest.EndpointContext) error {
+	return s.traced(func(c *rest.EndpointContext) error {
+		var paths []string
+		if err := json.NewDecoder(c.EchoCtx.Request().Body).Decode(&paths); err != nil {
+			return http_errors.BadRequestError("Invalid request body")
+		}
+		if len(paths) == 0 || len(paths) > maxPrefetchFiles {
+			return http_errors.BadRequestError(fmt.Sprintf("paths must list between 1 and %d files", maxPrefetchFiles))
+		}
+		for _, path := range paths {
+			if err := s.authorize(c, path, SignedURLOperationGet); err != nil {
+				return err
+			}
+		}
+
+		err := s.Prefetch(c.Context(), paths)
+		var failed *MultiError
+		if err != nil && !errors.As(err, &failed) {
+			return httpError(err, "Failed to prefetch files")
+		}
+
+		files := make([]prefetchStatus, len(paths))
+		for i, path := range paths {
+			files[i] = prefetchStatus{Path: path, Status: http.StatusOK}
+			if failed == nil {
+				continue
+			}
+			if storageErr := failed.Failed(path); storageErr != nil {
+				files[i].Status, files[i].Code, files[i].Message = storageErr.HTTPStatus(), storageErr.Code, storageErr.Message
+			}
+		}
+
+		_, cached := providerAs[PrefetchProvider](s.provider)
+		return c.JSON(map[string]interface{}{
+			"cached": cached,
+			"files":  files,
+		})
+	})
+}
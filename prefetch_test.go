@@ -0,0 +1,120 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// cachingProvider records the files prefetched into its cache and how many loads ran at once
+type cachingProvider struct {
+	StorageProvider
+	mu       sync.Mutex
+	cached   map[string]bool
+	inFlight int
+	peak     int
+}
+
+func (p *cachingProvider) Prefetch(ctx context.Context, path string) error {
+	p.mu.Lock()
+	p.inFlight++
+	p.peak = max(p.peak, p.inFlight)
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.inFlight--
+		p.mu.Unlock()
+	}()
+
+	time.Sleep(time.Millisecond)
+	if exists, err := p.Exists(ctx, path); err != nil || !exists {
+		return FileNotFoundError(path)
+	}
+	p.mu.Lock()
+	p.cached[path] = true
+	p.mu.Unlock()
+	return nil
+}
+
+func TestPrefetch(t *testing.T) {
+	ctx := context.Background()
+	provider := &cachingProvider{cached: make(map[string]bool)}
+	RegisterProvider("caching", func(config *StorageConfig) (StorageProvider, error) {
+		inner, err := NewMemoryProvider(config)
+		provider.StorageProvider = inner
+		return provider, err
+	})
+	storage, err := New(&StorageConfig{Name: "test", Provider: "caching", Concurrency: 4})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	var paths []string
+	for i := 0; i < 20; i++ {
+		path := "clips/" + string(rune('a'+i)) + ".mp4"
+		storage.Upload(ctx, path, strings.NewReader("clip"), nil)
+		paths = append(paths, path)
+	}
+
+	err = storage.Prefetch(ctx, append(paths, "clips/missing.mp4", "clips/"))
+	var multi *MultiError
+	if !errors.As(err, &multi) || multi.Len() != 2 ||
+		!errors.Is(multi.Errors["clips/missing.mp4"], ErrFileNotFound) || !errors.Is(multi.Errors["clips/"], ErrInvalidPath) {
+		t.Fatalf("Expected the missing and invalid paths to fail, got %v", err)
+	}
+	if len(provider.cached) != len(paths) {
+		t.Errorf("Expected %d cached files, got %d", len(paths), len(provider.cached))
+	}
+	if provider.peak > 4 {
+		t.Errorf("Expected at most 4 loads at once, got %d", provider.peak)
+	}
+	if !storage.Capabilities().Prefetch {
+		t.Error("Expected the prefetch capability")
+	}
+}
+
+func TestPrefetchHandler(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{Name: "test", Provider: "memory"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.Upload(ctx, "clips/a.mp4", strings.NewReader("clip"), nil)
+
+	request := httptest.NewRequest(http.MethodPost, "/prefetch", strings.NewReader(`["clips/a.mp4", "clips/missing.mp4"]`))
+	recorder := httptest.NewRecorder()
+	c := &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder)}
+	if err := storage.PrefetchHandler()(c); err != nil {
+		t.Fatalf("PrefetchHandler failed: %v", err)
+	}
+
+	var body struct {
+		Cached bool             `json:"cached"`
+		Files  []prefetchStatus `json:"files"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if body.Cached || len(body.Files) != 2 {
+		t.Fatalf("Expected an uncached status per path, got %+v", body)
+	}
+	if body.Files[0].Status != http.StatusOK || body.Files[1].Status != http.StatusNotFound || body.Files[1].Code != ErrorCodeFileNotFound {
+		t.Errorf("Expected the existing file available and the other missing, got %+v", body.Files)
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/prefetch", strings.NewReader(`[]`))
+	c = &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, httptest.NewRecorder())}
+	var httpErr *echo.HTTPError
+	if err := storage.PrefetchHandler()(c); !errors.As(err, &httpErr) || httpErr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an empty list to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package vsaasstorage
+
+import (
+	"errors"
+
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// PreUploadInterceptor is called for each file of an upload received with UploadHandler
+// or UploadFromCtx before it is stored, with where it is about to go. It may change the
+// proposed directory, name and metadata, or return an error to refuse the file, which
+// fails alone like any other file of a multi-file upload. A *StorageError is reported
+// with its own code; other errors are reported as ErrContentRejected (HTTP 422).
+type PreUploadInterceptor func(c *rest.EndpointContext, file *rest.UploadedFile, proposed *ProposedUpload) error
+
+// ProposedUpload is where an uploaded file is about to be stored
+type ProposedUpload struct {
+	FieldName   string            // Form field the file was sent in, for reference
+	Directory   string            // Destination directory, before the path layout is applied
+	Filename    string            // As UploadOptions.Filename: empty generates a unique name
+	ContentType string            // Content type stored with the file
+	Metadata    map[string]string // Custom metadata stored with the file; the origin keys are always set by the storage
+}
+
+// interceptUpload runs the interceptor of an upload on a file, returning the proposed
+// upload it approved
+func interceptUpload(uploadedFile *rest.UploadedFile, fieldName, destinationDir string, opts UploadOptions) (*ProposedUpload, error) {
+	proposed := &ProposedUpload{
+		FieldName:   fieldName,
+		Directory:   destinationDir,
+		Filename:    opts.Filename,
+		ContentType: uploadedFile.MimeType,
+		Metadata:    map[string]string{},
+	}
+	if opts.preUpload == nil {
+		return proposed, nil
+	}
+
+	if err := opts.preUpload(uploadedFile, proposed); err != nil {
+		var storageErr *StorageError
+		if errors.As(err, &storageErr) {
+			return nil, err
+		}
+		return nil, NewStorageErrorWithCause(ErrorCodeContentRejected, "upload rejected: "+err.Error(), err)
+	}
+	if proposed.Metadata == nil {
+		proposed.Metadata = map[string]string{}
+	}
+	return proposed, nil
+}
@@ -0,0 +1,94 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestPreUploadInterceptor(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	newFile := func(name string) *rest.UploadedFile {
+		path := filepath.Join(tmpDir, name)
+		os.WriteFile(path, []byte(name), 0644)
+		return &rest.UploadedFile{Path: path, Filename: name, OriginalName: name, MimeType: "text/plain"}
+	}
+	newContext := func(files map[string][]*rest.UploadedFile) (*rest.EndpointContext, *httptest.ResponseRecorder) {
+		request := httptest.NewRequest(http.MethodPost, "/upload", nil)
+		request.Header.Set("X-Tenant", "acme")
+		recorder := httptest.NewRecorder()
+		return &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder), UploadedFiles: files}, recorder
+	}
+
+	storage, err := New(&StorageConfig{
+		Name:     "test",
+		Provider: "memory",
+		PreUpload: func(c *rest.EndpointContext, file *rest.UploadedFile, proposed *ProposedUpload) error {
+			switch {
+			case strings.HasSuffix(file.OriginalName, ".exe"):
+				return errors.New("executables are not allowed")
+			case file.OriginalName == "dup.txt":
+				return FileAlreadyExistsError("cases/dup.txt")
+			}
+			tenant := c.EchoCtx.Request().Header.Get("X-Tenant")
+			proposed.Directory = tenant + "/" + proposed.Directory
+			proposed.Filename = "case-" + strings.TrimSuffix(file.OriginalName, ".txt")
+			proposed.ContentType = "text/markdown"
+			proposed.Metadata["tenant"] = tenant
+			proposed.Metadata[OriginalNameMetadataKey] = "spoofed"
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	c, _ := newContext(map[string][]*rest.UploadedFile{"documents": {newFile("a.txt"), newFile("b.exe"), newFile("dup.txt")}})
+	results, err := storage.UploadFromCtx(ctx, c, "cases")
+	var uploadErr *UploadError
+	if !errors.As(err, &uploadErr) || len(uploadErr.Failures) != 2 {
+		t.Fatalf("expected 2 refused files, got %v", err)
+	}
+	if !errors.Is(uploadErr.Failures[0].Err, ErrContentRejected) || !errors.Is(uploadErr.Failures[1].Err, ErrFileAlreadyExists) {
+		t.Errorf("expected the refusals with their codes, got %v and %v", uploadErr.Failures[0].Err, uploadErr.Failures[1].Err)
+	}
+	if len(results) != 1 || results[0].Path != "acme/cases/case-a.txt" {
+		t.Fatalf("expected the approved file to be renamed and moved, got %+v", results)
+	}
+	info, _ := storage.GetInfo(ctx, results[0].Path)
+	if info.ContentType != "text/markdown" || info.Metadata["tenant"] != "acme" || info.Metadata[OriginalNameMetadataKey] != "a.txt" {
+		t.Errorf("unexpected stored file %+v", info)
+	}
+
+	// The handler reports each refused file with its own status
+	c, recorder := newContext(map[string][]*rest.UploadedFile{"documents": {newFile("c.exe")}})
+	if err := storage.UploadHandler("cases")(c); err != nil {
+		t.Fatalf("UploadHandler failed: %v", err)
+	}
+	var body struct {
+		Files []failedFileStatus `json:"files"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	if len(body.Files) != 1 || body.Files[0].Status != http.StatusUnprocessableEntity || body.Files[0].Code != ErrorCodeContentRejected {
+		t.Errorf("expected a 422 for the refused file, got %s", recorder.Body.String())
+	}
+
+	// Options override the configured interceptor
+	c, _ = newContext(map[string][]*rest.UploadedFile{"documents": {newFile("d.exe")}})
+	results, err = storage.UploadFromCtxWithOptions(ctx, c, "cases", UploadOptions{
+		PreUpload: func(c *rest.EndpointContext, file *rest.UploadedFile, proposed *ProposedUpload) error { return nil },
+	})
+	if err != nil || len(results) != 1 || !strings.HasPrefix(results[0].Path, "cases/") {
+		t.Errorf("expected the file to be stored unchanged, got %v %v", results, err)
+	}
+}
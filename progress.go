@@ -0,0 +1,158 @@
+package vsaasstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// DefaultProgressInterval is the number of bytes between progress reports when
+// UploadOptions.ProgressInterval is not set
+const DefaultProgressInterval = 1 << 20
+
+// ProgressFunc receives the progress of an upload. bytesTotal is -1 when the size of the
+// content is not known in advance.
+type ProgressFunc func(path string, bytesDone, bytesTotal int64)
+
+// progressCounter calls a ProgressFunc as bytes are transferred
+type progressCounter struct {
+	path     string
+	progress ProgressFunc
+	interval int64
+	done     int64
+	total    int64
+	next     int64 // Bytes transferred at which the next report is due
+	reported int64 // Bytes transferred at the last report, -1 before the first
+}
+
+// newProgressCounter creates a counter reporting every interval bytes, or
+// DefaultProgressInterval when interval is not positive
+func newProgressCounter(path string, progress ProgressFunc, interval, total int64) *progressCounter {
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	return &progressCounter{
+		path:     path,
+		progress: progress,
+		interval: interval,
+		total:    total,
+		next:     interval,
+		reported: -1,
+	}
+}
+
+// add counts n bytes, reporting when an interval is crossed
+func (c *progressCounter) add(n int64) {
+	c.done += n
+	if c.done >= c.next {
+		c.report()
+	}
+}
+
+// finish reports the final count unless it was just reported
+func (c *progressCounter) finish() {
+	if c.reported != c.done {
+		c.report()
+	}
+}
+
+// report calls the ProgressFunc with the current count
+func (c *progressCounter) report() {
+	c.reported = c.done
+	c.next = c.done + c.interval
+	c.progress(c.path, c.done, c.total)
+}
+
+// progressReader reports the bytes read from an upload to a ProgressFunc
+type progressReader struct {
+	r       io.Reader
+	counter *progressCounter
+}
+
+// newProgressReader wraps r to report its progress as configured in opts
+func newProgressReader(r io.Reader, path string, total int64, opts UploadOptions) *progressReader {
+	return &progressReader{r: r, counter: newProgressCounter(path, opts.Progress, opts.ProgressInterval, total)}
+}
+
+// Read reads from the underlying reader, reporting each interval crossed and the end of
+// the content
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.counter.add(int64(n))
+	if err == io.EOF {
+		r.counter.finish()
+	}
+	return n, err
+}
+
+// Unwrap returns the reader being reported, so its size can still be known
+func (r *progressReader) Unwrap() io.Reader {
+	return r.r
+}
+
+// ProgressStream sends upload progress to the browser as server-sent events. Its
+// Progress method is a ProgressFunc:
+//
+//	stream := vsaasstorage.NewProgressStream(c)
+//	results, err := storage.UploadFromCtxWithOptions(ctx, c, "uploads", vsaasstorage.UploadOptions{Progress: stream.Progress})
+//	stream.Send("result", results)
+//
+// Each report is a "progress" event with path, bytes_done and bytes_total in its data.
+// The response is committed with status 200 on the first event, so failures must be
+// sent as events too. It is safe for concurrent use.
+type ProgressStream struct {
+	mu      sync.Mutex
+	c       *rest.EndpointContext
+	started bool
+	err     error // First write error; the client is gone and later events are dropped
+}
+
+// NewProgressStream creates a ProgressStream writing to the response of c
+func NewProgressStream(c *rest.EndpointContext) *ProgressStream {
+	return &ProgressStream{c: c}
+}
+
+// progressEvent is the data of a "progress" event
+type progressEvent struct {
+	Path       string `json:"path"`
+	BytesDone  int64  `json:"bytes_done"`
+	BytesTotal int64  `json:"bytes_total"`
+}
+
+// Progress sends a "progress" event
+func (p *ProgressStream) Progress(path string, bytesDone, bytesTotal int64) {
+	p.Send("progress", progressEvent{Path: path, BytesDone: bytesDone, BytesTotal: bytesTotal})
+}
+
+// Send sends an event with data encoded as JSON and flushes it to the client
+func (p *ProgressStream) Send(event string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+
+	response := p.c.EchoCtx.Response()
+	if !p.started {
+		p.started = true
+		response.Header().Set("Content-Type", "text/event-stream")
+		response.Header().Set("Cache-Control", "no-cache")
+		response.Header().Set("X-Accel-Buffering", "no") // Keep proxies such as nginx from buffering the events
+		response.WriteHeader(http.StatusOK)
+	}
+	if _, err := fmt.Fprintf(response, "event: %s\ndata: %s\n\n", event, body); err != nil {
+		p.err = err
+		return err
+	}
+	response.Flush()
+	return nil
+}
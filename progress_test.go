@@ -0,0 +1,105 @@
+package vsaasstorage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// progressRecorder keeps the reports of an upload
+type progressRecorder struct {
+	done  []int64
+	total int64
+	path  string
+}
+
+func (r *progressRecorder) report(path string, bytesDone, bytesTotal int64) {
+	r.path, r.total = path, bytesTotal
+	r.done = append(r.done, bytesDone)
+}
+
+func TestUploadProgress(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{Name: "test", Provider: "memory"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	t.Run("KnownSize", func(t *testing.T) {
+		recorder := &progressRecorder{}
+		_, err := storage.UploadWithOptions(ctx, "/docs//a.txt", strings.NewReader("0123456789"), nil, UploadOptions{Progress: recorder.report})
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		if recorder.path != "docs/a.txt" || recorder.total != 10 || len(recorder.done) != 1 || recorder.done[0] != 10 {
+			t.Errorf("Expected a final report of docs/a.txt at 10 of 10 bytes, got %q at %v of %d", recorder.path, recorder.done, recorder.total)
+		}
+	})
+
+	t.Run("Interval", func(t *testing.T) {
+		recorder := &progressRecorder{}
+		reader := iotest.OneByteReader(strings.NewReader("0123456789"))
+		_, err := storage.UploadWithOptions(ctx, "docs/b.txt", reader, nil, UploadOptions{Progress: recorder.report, ProgressInterval: 4})
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		if recorder.total != -1 || fmt.Sprint(recorder.done) != "[4 8 10]" {
+			t.Errorf("Expected reports at 4, 8 and 10 of an unknown size, got %v of %d", recorder.done, recorder.total)
+		}
+	})
+
+	t.Run("UploadedFile", func(t *testing.T) {
+		recorder := &progressRecorder{}
+		result, err := storage.UploadFromUploadedFileWithOptions(ctx, writeTestVideo(t), "file", "clips", UploadOptions{Progress: recorder.report})
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		if recorder.path != result.Path || recorder.total != result.Size || recorder.done[len(recorder.done)-1] != result.Size {
+			t.Errorf("Expected the progress of %s to reach %d, got %v of %d", result.Path, result.Size, recorder.done, recorder.total)
+		}
+	})
+
+	t.Run("ReaderSize", func(t *testing.T) {
+		reader := &contextReader{ctx: ctx, r: strings.NewReader("abc")}
+		if size, ok := readerSize(newProgressReader(reader, "a", 3, UploadOptions{Progress: func(string, int64, int64) {}})); !ok || size != 3 {
+			t.Errorf("Expected wrapped readers to keep their size for quotas, got %d", size)
+		}
+	})
+}
+
+func TestProgressStream(t *testing.T) {
+	storage, err := New(&StorageConfig{Name: "test", Provider: "memory"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	request.Header.Set("Accept", "text/event-stream")
+	recorder := httptest.NewRecorder()
+	c := &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder)}
+
+	stream := NewProgressStream(c)
+	stream.Progress("uploads/a.mp4", 512, 1024)
+	if err := storage.UploadHandler("uploads")(c); err != nil {
+		t.Fatalf("Upload handler failed: %v", err)
+	}
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %q", contentType)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, "event: progress\ndata: {\"path\":\"uploads/a.mp4\",\"bytes_done\":512,\"bytes_total\":1024}\n\n") {
+		t.Errorf("Expected a progress event, got %q", body)
+	}
+	// The request has no files, which is reported as an event once the stream started
+	if !strings.Contains(body, "event: error\ndata: {\"message\":") || !strings.Contains(body, "\"status\":400") {
+		t.Errorf("Expected an error event with status 400, got %q", body)
+	}
+}
@@ -0,0 +1,62 @@
+package vsaasstorage
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// validatePublicBaseURL checks that a public base URL is absolute
+func validatePublicBaseURL(baseURL string) error {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("publicBaseURL must be an absolute http or https URL")
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return errors.New("publicBaseURL cannot have a query or fragment")
+	}
+	return nil
+}
+
+// PublicURL returns the stable public URL of a file, such as its CDN URL, by joining
+// PublicBaseURL and the path. Each segment is escaped, so spaces and non-ASCII names are
+// valid in the URL while the slashes between segments are kept. It fails with
+// ErrNotSupported when PublicBaseURL is not configured. Signed URLs are generated
+// separately by GenerateSignedURL.
+func (s *Storage) PublicURL(filePath string) (string, error) {
+	if s.config.PublicBaseURL == "" {
+		return "", NotSupportedError("public base URL is not configured")
+	}
+
+	return joinURL(s.config.PublicBaseURL, s.config.normalizePath(filePath)), nil
+}
+
+// joinURL appends a storage path to a base URL, escaping each segment
+func joinURL(baseURL, filePath string) string {
+	segments := strings.Split(cleanPath(filePath), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + strings.Join(segments, "/")
+}
+
+// publicURL returns the public URL of a file, or "" when none is configured
+func (s *Storage) publicURL(filePath string) string {
+	if s.config.PublicBaseURL == "" {
+		return ""
+	}
+	publicURL, _ := s.PublicURL(filePath)
+	return publicURL
+}
+
+// withPublicURLs sets the public URL of listed or returned files
+func (s *Storage) withPublicURLs(files ...*FileInfo) {
+	if s.config.PublicBaseURL == "" {
+		return
+	}
+	for _, file := range files {
+		if !file.IsDirectory {
+			file.PublicURL = s.publicURL(file.Path)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestPublicURL(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{Name: "test", Provider: "memory", PublicBaseURL: "https://cdn.example.com/media/"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	tests := []struct {
+		path, expected string
+	}{
+		{"cameras/1/clip.mp4", "https://cdn.example.com/media/cameras/1/clip.mp4"},
+		{"/reports/monthly report.pdf", "https://cdn.example.com/media/reports/monthly%20report.pdf"},
+		{"menus/café.txt", "https://cdn.example.com/media/menus/caf%C3%A9.txt"},
+		{"a/../b/#1?.txt", "https://cdn.example.com/media/b/%231%3F.txt"},
+	}
+	for _, tt := range tests {
+		if publicURL, err := storage.PublicURL(tt.path); err != nil || publicURL != tt.expected {
+			t.Errorf("PublicURL(%q) = %q, %v; expected %q", tt.path, publicURL, err, tt.expected)
+		}
+	}
+
+	t.Run("NotConfigured", func(t *testing.T) {
+		storage, _ := New(&StorageConfig{Name: "test", Provider: "memory"})
+		if _, err := storage.PublicURL("a.txt"); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported, got %v", err)
+		}
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		for _, baseURL := range []string{"cdn.example.com", "ftp://cdn.example.com", "https://cdn.example.com/?v=1"} {
+			config := &StorageConfig{Name: "test", Provider: "memory", PublicBaseURL: baseURL}
+			if err := config.Validate(); err == nil {
+				t.Errorf("Expected %q to be rejected", baseURL)
+			}
+		}
+	})
+
+	t.Run("Handlers", func(t *testing.T) {
+		if _, err := storage.Upload(ctx, "docs/a b.txt", strings.NewReader("data"), nil); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/files?path=docs", nil)
+		recorder := httptest.NewRecorder()
+		if err := storage.ListHandler()(&rest.EndpointContext{EchoCtx: e.NewContext(request, recorder)}); err != nil {
+			t.Fatalf("List handler failed: %v", err)
+		}
+		var list struct {
+			Files []*FileInfo `json:"files"`
+		}
+		json.Unmarshal(recorder.Body.Bytes(), &list)
+		if len(list.Files) != 1 || list.Files[0].PublicURL != "https://cdn.example.com/media/docs/a%20b.txt" {
+			t.Errorf("Unexpected listing %s", recorder.Body.String())
+		}
+
+		request = httptest.NewRequest(http.MethodGet, "/info?path=docs/a%20b.txt", nil)
+		recorder = httptest.NewRecorder()
+		if err := storage.InfoHandler()(&rest.EndpointContext{EchoCtx: e.NewContext(request, recorder)}); err != nil {
+			t.Fatalf("Info handler failed: %v", err)
+		}
+		var info FileInfo
+		json.Unmarshal(recorder.Body.Bytes(), &info)
+		if info.PublicURL != "https://cdn.example.com/media/docs/a%20b.txt" {
+			t.Errorf("Unexpected info %s", recorder.Body.String())
+		}
+	})
+
+	t.Run("Upload", func(t *testing.T) {
+		result, err := storage.UploadFromUploadedFile(ctx, writeTestVideo(t), "file", "cameras/1", "clip")
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		if result.PublicURL != "https://cdn.example.com/media/cameras/1/clip.mp4" {
+			t.Errorf("Unexpected public URL %q", result.PublicURL)
+		}
+	})
+}
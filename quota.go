@@ -0,0 +1,424 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// QuotaManager tracks storage usage per prefix (typically a tenant) and enforces limits.
+// Implementations must be safe for concurrent use.
+type QuotaManager interface {
+	// Reserve holds bytes for an upload in progress, failing with ErrorCodeQuotaExceeded
+	// if usage plus outstanding reservations would exceed the limit
+	Reserve(prefix string, bytes int64) error
+	// Commit turns a reservation into usage. actual is the number of bytes finally
+	// stored; committing 0 cancels the reservation.
+	Commit(prefix string, reserved, actual int64) error
+	// Release returns bytes to the quota after files are deleted
+	Release(prefix string, bytes int64) error
+	// Usage reports the current usage of a prefix
+	Usage(prefix string) (*QuotaUsage, error)
+}
+
+// QuotaUsage describes the usage of a prefix
+type QuotaUsage struct {
+	Prefix   string `json:"prefix"`
+	Used     int64  `json:"used"`
+	Reserved int64  `json:"reserved"`
+	Limit    int64  `json:"limit"` // 0 means unlimited
+}
+
+// QuotaConfig contains configuration for per-prefix quotas
+type QuotaConfig struct {
+	DefaultLimit int64            `json:"defaultLimit,omitempty"` // Bytes allowed per prefix, 0 for unlimited
+	Limits       map[string]int64 `json:"limits,omitempty"`       // Per-prefix overrides of DefaultLimit
+	StateFile    string           `json:"stateFile,omitempty"`    // JSON file persisting usage; in-memory if empty
+
+	// PrefixFunc extracts the quota prefix from a path. Defaults to the first path segment.
+	PrefixFunc func(path string) string `json:"-"`
+	// Manager replaces the default file-backed manager
+	Manager QuotaManager `json:"-"`
+}
+
+// limit returns the limit that applies to a prefix
+func (c *QuotaConfig) limit(prefix string) int64 {
+	if limit, ok := c.Limits[prefix]; ok {
+		return limit
+	}
+	return c.DefaultLimit
+}
+
+// prefix returns the quota prefix of a path
+func (c *QuotaConfig) prefix(filePath string) string {
+	if c.PrefixFunc != nil {
+		return c.PrefixFunc(filePath)
+	}
+	return FirstSegmentPrefix(filePath)
+}
+
+// FirstSegmentPrefix returns the first segment of a path, e.g. "tenant-a" for "tenant-a/videos/1.mp4"
+func FirstSegmentPrefix(filePath string) string {
+	first, _, _ := strings.Cut(cleanPath(filePath), "/")
+	return first
+}
+
+// DefaultQuotaManager keeps usage in memory and optionally persists it to a JSON file
+type DefaultQuotaManager struct {
+	config *QuotaConfig
+
+	mu       sync.Mutex
+	used     map[string]int64
+	reserved map[string]int64
+}
+
+// NewQuotaManager creates the default quota manager, loading persisted usage if StateFile is set
+func NewQuotaManager(config *QuotaConfig) (*DefaultQuotaManager, error) {
+	m := &DefaultQuotaManager{
+		config:   config,
+		used:     make(map[string]int64),
+		reserved: make(map[string]int64),
+	}
+
+	if config.StateFile == "" {
+		return m, nil
+	}
+
+	data, err := os.ReadFile(config.StateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, NewStorageErrorWithCause(ErrorCodeInvalidConfig, "failed to read quota state", err)
+	}
+	if err := json.Unmarshal(data, &m.used); err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeInvalidConfig, "failed to parse quota state", err)
+	}
+
+	return m, nil
+}
+
+// Reserve holds bytes for an upload in progress
+func (m *DefaultQuotaManager) Reserve(prefix string, bytes int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	limit := m.config.limit(prefix)
+	if limit > 0 && m.used[prefix]+m.reserved[prefix]+bytes > limit {
+		return QuotaExceededError(prefix, limit)
+	}
+
+	m.reserved[prefix] += bytes
+	return nil
+}
+
+// Commit turns a reservation into usage
+func (m *DefaultQuotaManager) Commit(prefix string, reserved, actual int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reserved[prefix] = max(m.reserved[prefix]-reserved, 0)
+	if actual == 0 {
+		return nil
+	}
+
+	m.used[prefix] += actual
+	return m.saveLocked()
+}
+
+// Release returns bytes to the quota
+func (m *DefaultQuotaManager) Release(prefix string, bytes int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.used[prefix] = max(m.used[prefix]-bytes, 0)
+	return m.saveLocked()
+}
+
+// Usage reports the current usage of a prefix
+func (m *DefaultQuotaManager) Usage(prefix string) (*QuotaUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return &QuotaUsage{
+		Prefix:   prefix,
+		Used:     m.used[prefix],
+		Reserved: m.reserved[prefix],
+		Limit:    m.config.limit(prefix),
+	}, nil
+}
+
+// SetUsage overwrites the recorded usage of a prefix, e.g. after recomputing it from a listing
+func (m *DefaultQuotaManager) SetUsage(prefix string, bytes int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.used[prefix] = bytes
+	return m.saveLocked()
+}
+
+// saveLocked persists usage to the state file. Must be called with the lock held.
+func (m *DefaultQuotaManager) saveLocked() error {
+	if m.config.StateFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(m.used)
+	if err != nil {
+		return NewStorageErrorWithCause(ErrorCodeInternalError, "failed to encode quota state", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated state
+	tmp := m.config.StateFile + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(tmp), 0755); err != nil {
+		return NewStorageErrorWithCause(ErrorCodeInternalError, "failed to create quota state directory", err)
+	}
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return NewStorageErrorWithCause(ErrorCodeInternalError, "failed to write quota state", err)
+	}
+	if err := os.Rename(tmp, m.config.StateFile); err != nil {
+		return NewStorageErrorWithCause(ErrorCodeInternalError, "failed to write quota state", err)
+	}
+
+	return nil
+}
+
+// QuotaUsage reports the usage of the prefix that the given path belongs to
+func (s *Storage) QuotaUsage(path string) (*QuotaUsage, error) {
+	if s.quota == nil {
+		return nil, NotSupportedError("quotas are not configured")
+	}
+	return s.quota.Usage(s.config.Quota.prefix(path))
+}
+
+// RefreshQuotaUsage recomputes usage by walking the storage and stores it in the
+// quota manager. The manager must support SetUsage, as DefaultQuotaManager does.
+func (s *Storage) RefreshQuotaUsage(ctx context.Context) error {
+	if s.quota == nil {
+		return NotSupportedError("quotas are not configured")
+	}
+
+	setter, ok := s.quota.(interface {
+		SetUsage(prefix string, bytes int64) error
+	})
+	if !ok {
+		return NotSupportedError("quota manager does not support SetUsage")
+	}
+
+	usage := make(map[string]int64)
+	err := s.walk(ctx, "", allEntries, func(info *FileInfo) error {
+		if !info.IsDirectory {
+			usage[s.config.Quota.prefix(info.Path)] += info.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for prefix, bytes := range usage {
+		if err := setter.SetUsage(prefix, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quotaUpload runs an upload against the quota of the path's prefix. When the reader
+// size is known it is reserved up front; otherwise bytes are reserved as they are read
+// so concurrent uploads cannot exceed the limit together.
+func (s *Storage) quotaUpload(ctx context.Context, filePath string, reader io.Reader, upload func(io.Reader) (*FileInfo, error)) (*FileInfo, error) {
+	prefix := s.config.Quota.prefix(filePath)
+
+	// An overwrite frees the bytes of the previous version
+	var previousSize int64
+	if info, err := s.provider.GetInfo(ctx, filePath); err == nil && !info.IsDirectory {
+		previousSize = info.Size
+	}
+
+	var reserved int64
+	var counting *quotaReader
+	if size, ok := readerSize(reader); ok {
+		if err := s.quota.Reserve(prefix, size); err != nil {
+			return nil, err
+		}
+		reserved = size
+	} else {
+		counting = &quotaReader{reader: reader, quota: s.quota, prefix: prefix}
+		reader = counting
+	}
+
+	info, err := upload(reader)
+	if counting != nil {
+		reserved = counting.reserved
+		if counting.err != nil {
+			err = counting.err // Report the quota error rather than the provider's wrapping
+		}
+	}
+	if err != nil {
+		s.quota.Commit(prefix, reserved, 0)
+		return nil, err
+	}
+
+	if err := s.quota.Commit(prefix, reserved, info.Size); err != nil {
+		return nil, err
+	}
+	if previousSize > 0 {
+		if err := s.quota.Release(prefix, previousSize); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// quotaAppend runs an append, charging the prefix the bytes read from reader. Unlike
+// quotaUpload it never looks at the previous size, which concurrent appends would change.
+func (s *Storage) quotaAppend(filePath string, reader io.Reader, appendFile func(io.Reader) (*FileInfo, error)) (*FileInfo, error) {
+	prefix := s.config.Quota.prefix(filePath)
+	counting := &quotaReader{reader: reader, quota: s.quota, prefix: prefix}
+
+	info, err := appendFile(counting)
+	if counting.err != nil {
+		err = counting.err // Report the quota error rather than the provider's wrapping
+	}
+	if err != nil {
+		s.quota.Commit(prefix, counting.reserved, 0)
+		return nil, err
+	}
+
+	if err := s.quota.Commit(prefix, counting.reserved, counting.reserved); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// quotaTransfer runs a copy or move, charging the destination prefix and, for moves,
+// returning the bytes to the source prefix
+func (s *Storage) quotaTransfer(ctx context.Context, srcPath, dstPath string, move bool, transfer func() error) error {
+	src, err := s.provider.GetInfo(ctx, srcPath)
+	if err != nil || src.IsDirectory {
+		return transfer() // Let the provider report the error
+	}
+
+	srcPrefix := s.config.Quota.prefix(srcPath)
+	dstPrefix := s.config.Quota.prefix(dstPath)
+
+	// An overwrite frees the bytes of the file being replaced
+	var replaced []*FileInfo
+	if dst, err := s.provider.GetInfo(ctx, dstPath); err == nil && !dst.IsDirectory {
+		replaced = append(replaced, dst)
+	}
+
+	// Moves within a prefix do not change its usage
+	if move && srcPrefix == dstPrefix {
+		if err := transfer(); err != nil {
+			return err
+		}
+		return s.releaseQuota(replaced)
+	}
+
+	if err := s.quota.Reserve(dstPrefix, src.Size); err != nil {
+		return err
+	}
+	if err := transfer(); err != nil {
+		s.quota.Commit(dstPrefix, src.Size, 0)
+		return err
+	}
+	if err := s.quota.Commit(dstPrefix, src.Size, src.Size); err != nil {
+		return err
+	}
+
+	if move {
+		replaced = append(replaced, src)
+	}
+	return s.releaseQuota(replaced)
+}
+
+// releaseQuota returns the bytes of deleted files to their prefixes
+func (s *Storage) releaseQuota(files []*FileInfo) error {
+	var errs []error
+	for _, file := range files {
+		if file.IsDirectory || file.Size == 0 {
+			continue
+		}
+		if err := s.quota.Release(s.config.Quota.prefix(file.Path), file.Size); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// quotaReader reserves quota for the bytes it reads
+type quotaReader struct {
+	reader   io.Reader
+	quota    QuotaManager
+	prefix   string
+	reserved int64
+	err      error
+}
+
+// Read implements io.Reader
+func (r *quotaReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if reserveErr := r.quota.Reserve(r.prefix, int64(n)); reserveErr != nil {
+			r.err = reserveErr
+			return 0, reserveErr
+		}
+		r.reserved += int64(n)
+	}
+	return n, err
+}
+
+// readerSize returns the number of bytes left in the reader when it can be known without reading
+func readerSize(reader io.Reader) (int64, bool) {
+	// Wrappers that pass reads through, such as progress reporting, have the size of their reader
+	if wrapper, ok := reader.(interface{ Unwrap() io.Reader }); ok {
+		return readerSize(wrapper.Unwrap())
+	}
+
+	if lener, ok := reader.(interface{ Len() int }); ok {
+		return int64(lener.Len()), true
+	}
+
+	seeker, ok := reader.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	if _, err := seeker.Seek(current, io.SeekStart); err != nil {
+		return 0, false
+	}
+
+	return end - current, true
+}
+
+// withoutPaths returns the files whose path is not in the given list
+func withoutPaths(files []*FileInfo, paths []string) []*FileInfo {
+	excluded := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		excluded[cleanPath(p)] = true
+	}
+
+	var kept []*FileInfo
+	for _, file := range files {
+		if !excluded[cleanPath(file.Path)] {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
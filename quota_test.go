@@ -0,0 +1,197 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newQuotaStorage(t *testing.T, quota *QuotaConfig) *Storage {
+	t.Helper()
+
+	storage, err := New(&StorageConfig{
+		Name:     "QuotaStorage",
+		Provider: "memory",
+		Quota:    quota,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	return storage
+}
+
+func TestQuota(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Limit per prefix", func(t *testing.T) {
+		storage := newQuotaStorage(t, &QuotaConfig{
+			DefaultLimit: 10,
+			Limits:       map[string]int64{"big": 100},
+		})
+
+		if _, err := storage.Upload(ctx, "tenant/a.bin", strings.NewReader("12345678"), nil); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+
+		_, err := storage.Upload(ctx, "tenant/b.bin", strings.NewReader("12345"), nil)
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("Expected ErrQuotaExceeded, got %v", err)
+		}
+
+		if _, err := storage.Upload(ctx, "big/b.bin", strings.NewReader(strings.Repeat("x", 50)), nil); err != nil {
+			t.Errorf("Upload under an overridden limit failed: %v", err)
+		}
+
+		usage, _ := storage.QuotaUsage("tenant/any")
+		if usage.Used != 8 || usage.Reserved != 0 || usage.Limit != 10 {
+			t.Errorf("Unexpected usage %+v", usage)
+		}
+	})
+
+	t.Run("Unknown size reader", func(t *testing.T) {
+		storage := newQuotaStorage(t, &QuotaConfig{DefaultLimit: 10})
+
+		reader := io.MultiReader(strings.NewReader("123456"), strings.NewReader("789012"))
+		_, err := storage.Upload(ctx, "tenant/a.bin", reader, nil)
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("Expected ErrQuotaExceeded, got %v", err)
+		}
+
+		usage, _ := storage.QuotaUsage("tenant")
+		if usage.Used != 0 || usage.Reserved != 0 {
+			t.Errorf("Expected failed upload to release its reservation, got %+v", usage)
+		}
+	})
+
+	t.Run("Deletes return bytes", func(t *testing.T) {
+		storage := newQuotaStorage(t, &QuotaConfig{DefaultLimit: 10})
+
+		storage.Upload(ctx, "tenant/a.bin", strings.NewReader("12345"), nil)
+		storage.Upload(ctx, "tenant/dir/b.bin", strings.NewReader("123"), nil)
+
+		if err := storage.Delete(ctx, "tenant/a.bin"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if usage, _ := storage.QuotaUsage("tenant"); usage.Used != 3 {
+			t.Errorf("Expected 3 bytes used, got %d", usage.Used)
+		}
+
+		if err := storage.DeleteDirectory(ctx, "tenant/dir"); err != nil {
+			t.Fatalf("DeleteDirectory failed: %v", err)
+		}
+		if usage, _ := storage.QuotaUsage("tenant"); usage.Used != 0 {
+			t.Errorf("Expected 0 bytes used, got %d", usage.Used)
+		}
+	})
+
+	t.Run("Expired files return bytes", func(t *testing.T) {
+		storage := newQuotaStorage(t, &QuotaConfig{DefaultLimit: 10})
+
+		past := time.Now().Add(-time.Minute)
+		storage.Upload(ctx, "tenant/old.bin", strings.NewReader("1234567890"), &FileMetadata{ExpiresAt: &past})
+		if deleted, err := storage.CleanupExpired(ctx); err != nil || deleted != 1 {
+			t.Fatalf("Expected the expired file to be swept, got %d, %v", deleted, err)
+		}
+		if usage, _ := storage.QuotaUsage("tenant"); usage.Used != 0 {
+			t.Errorf("Expected 0 bytes used after the sweep, got %d", usage.Used)
+		}
+		if _, err := storage.Upload(ctx, "tenant/new.bin", strings.NewReader("1234567890"), nil); err != nil {
+			t.Errorf("Expected the freed bytes to be available, got %v", err)
+		}
+	})
+
+	t.Run("Restores are charged", func(t *testing.T) {
+		storage, err := New(&StorageConfig{
+			Name:     "QuotaStorage",
+			Provider: "memory",
+			Quota:    &QuotaConfig{DefaultLimit: 10},
+			Trash:    &TrashConfig{Enabled: true},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+
+		storage.Upload(ctx, "tenant/a.bin", strings.NewReader("1234567890"), nil)
+		storage.Delete(ctx, "tenant/a.bin")
+		batches, _ := storage.ListWithOptions(ctx, trashPrefix, ListOptions{IncludeTrash: true})
+		if len(batches) != 1 {
+			t.Fatalf("Expected one trash batch, got %d", len(batches))
+		}
+		if err := storage.Restore(ctx, trashPrefix+"/"+batches[0].Name+"/tenant/a.bin"); err != nil {
+			t.Fatalf("Restore failed: %v", err)
+		}
+
+		if usage, _ := storage.QuotaUsage("tenant"); usage.Used != 10 || usage.Reserved != 0 {
+			t.Errorf("Expected the restored file to be charged, got %+v", usage)
+		}
+		if _, err := storage.Upload(ctx, "tenant/b.bin", strings.NewReader("1234567890"), nil); !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("Expected ErrQuotaExceeded after the restore, got %v", err)
+		}
+	})
+
+	t.Run("Overwrite and move", func(t *testing.T) {
+		storage := newQuotaStorage(t, &QuotaConfig{DefaultLimit: 10})
+
+		storage.Upload(ctx, "a/file.bin", strings.NewReader("12345"), nil)
+		storage.Upload(ctx, "a/file.bin", strings.NewReader("123"), nil)
+		if usage, _ := storage.QuotaUsage("a"); usage.Used != 3 {
+			t.Errorf("Expected overwrite to replace usage, got %d", usage.Used)
+		}
+
+		if err := storage.Move(ctx, "a/file.bin", "b/file.bin"); err != nil {
+			t.Fatalf("Move failed: %v", err)
+		}
+		usageA, _ := storage.QuotaUsage("a")
+		usageB, _ := storage.QuotaUsage("b")
+		if usageA.Used != 0 || usageB.Used != 3 {
+			t.Errorf("Expected usage to follow the move, got a=%d b=%d", usageA.Used, usageB.Used)
+		}
+	})
+
+	t.Run("Concurrent uploads", func(t *testing.T) {
+		storage := newQuotaStorage(t, &QuotaConfig{DefaultLimit: 100})
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		succeeded := 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				name := "tenant/" + strings.Repeat("f", i+1)
+				if _, err := storage.Upload(ctx, name, strings.NewReader(strings.Repeat("x", 30)), nil); err == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if succeeded != 3 {
+			t.Errorf("Expected exactly 3 uploads to fit, got %d", succeeded)
+		}
+	})
+
+	t.Run("State file", func(t *testing.T) {
+		config := &QuotaConfig{
+			DefaultLimit: 10,
+			StateFile:    filepath.Join(t.TempDir(), "quota.json"),
+		}
+		storage := newQuotaStorage(t, config)
+		storage.Upload(ctx, "tenant/a.bin", strings.NewReader("1234"), nil)
+
+		manager, err := NewQuotaManager(config)
+		if err != nil {
+			t.Fatalf("NewQuotaManager failed: %v", err)
+		}
+		if usage, _ := manager.Usage("tenant"); usage.Used != 4 {
+			t.Errorf("Expected persisted usage of 4, got %d", usage.Used)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package vsaasstorage
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// RangeProvider is implemented by providers that can read part of a file without
+// fetching it from the start
+type RangeProvider interface {
+	// ReadRange returns length bytes starting at offset, or the rest of the file if length is negative
+	ReadRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, *FileInfo, error)
+}
+
+// FileProvider is implemented by providers backed by local files. Serving the *os.File
+// directly lets the kernel copy it to the socket (sendfile).
+type FileProvider interface {
+	// DownloadFile opens a file for reading
+	DownloadFile(ctx context.Context, path string) (*os.File, *FileInfo, error)
+}
+
+// ReadRange returns length bytes of a file starting at offset, or everything after offset
+// if length is negative. Providers without native range reads fall back to Download,
+// seeking when the reader allows it and discarding the skipped bytes otherwise.
+func (s *Storage) ReadRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, *FileInfo, error) {
+	if offset < 0 {
+		return nil, nil, NewStorageErrorWithPath(ErrorCodeInvalidPath, "negative range offset", path)
+	}
+
+	var (
+		reader io.ReadCloser
+		info   *FileInfo
+		err    error
+	)
+	if provider, ok := providerAs[RangeProvider](s.provider); ok {
+		reader, info, err = provider.ReadRange(ctx, s.extensionPath(path), offset, length)
+	} else {
+		reader, info, err = downloadRange(ctx, s.provider, path, offset, length)
+	}
+	if s.fallsBack(err) {
+		return s.fallback.readRange(ctx, s, path, offset, length)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if info.isExpired(s.config.now()) {
+		reader.Close()
+		return nil, nil, FileNotFoundError(path)
+	}
+
+	return reader, info, nil
+}
+
+// downloadRange emulates a range read on top of Download
+func downloadRange(ctx context.Context, provider StorageProvider, path string, offset, length int64) (io.ReadCloser, *FileInfo, error) {
+	reader, info, err := provider.Download(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if offset > 0 {
+		if seeker, ok := reader.(io.Seeker); ok {
+			_, err = seeker.Seek(offset, io.SeekStart)
+		} else if _, err = io.CopyN(io.Discard, reader, offset); err == io.EOF {
+			err = nil
+		}
+		if err != nil {
+			reader.Close()
+			return nil, nil, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to seek file", err)
+		}
+	}
+
+	return limitReadCloser(reader, length), info, nil
+}
+
+// limitReadCloser limits reader to length bytes, or returns it unchanged if length is negative
+func limitReadCloser(reader io.ReadCloser, length int64) io.ReadCloser {
+	if length < 0 {
+		return reader
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(reader, length), reader}
+}
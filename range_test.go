@@ -0,0 +1,80 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadRange(t *testing.T) {
+	ctx := context.Background()
+	fsStorage, memStorage := newTransferStorages(t)
+
+	for name, storage := range map[string]*Storage{"filesystem": fsStorage, "memory": memStorage} {
+		t.Run(name, func(t *testing.T) {
+			storage.Upload(ctx, "clip.bin", strings.NewReader("0123456789"), nil)
+
+			cases := []struct {
+				offset, length int64
+				expected       string
+			}{
+				{0, -1, "0123456789"},
+				{3, 4, "3456"},
+				{7, -1, "789"},
+				{8, 10, "89"},
+				{20, -1, ""},
+			}
+			for _, tc := range cases {
+				reader, info, err := storage.ReadRange(ctx, "clip.bin", tc.offset, tc.length)
+				if err != nil {
+					t.Fatalf("ReadRange(%d, %d) failed: %v", tc.offset, tc.length, err)
+				}
+				content, _ := io.ReadAll(reader)
+				reader.Close()
+
+				if string(content) != tc.expected || info.Size != 10 {
+					t.Errorf("ReadRange(%d, %d) = '%s' (size %d), expected '%s'", tc.offset, tc.length, content, info.Size, tc.expected)
+				}
+			}
+
+			if _, _, err := storage.ReadRange(ctx, "missing.bin", 0, -1); !errors.Is(err, ErrFileNotFound) {
+				t.Errorf("Expected ErrFileNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestHTTPFileSystem(t *testing.T) {
+	ctx := context.Background()
+	_, storage := newTransferStorages(t)
+	storage.Upload(ctx, "videos/clip.mp4", strings.NewReader("0123456789"), &FileMetadata{ContentType: "video/mp4"})
+
+	server := httptest.NewServer(http.FileServer(storage.HTTPFileSystem(ctx)))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/videos/clip.mp4", nil)
+	req.Header.Set("Range", "bytes=4-6")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "456" {
+		t.Errorf("Expected 206 with '456', got %d '%s'", resp.StatusCode, body)
+	}
+
+	resp, err = http.Get(server.URL + "/videos/missing.mp4")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,25 @@
+package vsaasstorage
+
+// ReadOnly returns a view of the storage that shares its provider but refuses every
+// operation that modifies it, for replicas and viewers that must not write
+func (s *Storage) ReadOnly() *Storage {
+	config := s.config.Clone()
+	config.ReadOnly = true
+	return &Storage{
+		provider:     s.provider,
+		config:       config,
+		quota:        s.quota,
+		idempotency:  s.idempotency,
+		uploadMemory: s.uploadMemory,
+		fallback:     s.fallback,
+		derived:      s.derived,
+	}
+}
+
+// checkWritable fails with ErrReadOnly when the storage is read-only
+func (s *Storage) checkWritable(path string) error {
+	if s.config.ReadOnly {
+		return ReadOnlyError(path)
+	}
+	return nil
+}
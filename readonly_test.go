@@ -0,0 +1,114 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{
+		Name:       "ReplicaStorage",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+		SignedURL: &SignedURLConfig{
+			Enabled:   true,
+			ExpiresIn: 5 * time.Minute,
+			SecretKey: "test-secret-key",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.Upload(ctx, "cameras/1/clip.mp4", strings.NewReader("video"), nil)
+
+	readOnly := storage.ReadOnly()
+
+	t.Run("Mutations are refused", func(t *testing.T) {
+		_, uploadErr := readOnly.Upload(ctx, "cameras/1/new.mp4", strings.NewReader("x"), nil)
+		_, signErr := readOnly.GenerateSignedURL(ctx, "cameras/1/new.mp4", SignedURLOperationPut, time.Minute)
+		_, deleteSignErr := readOnly.GenerateSignedURL(ctx, "cameras/1/clip.mp4", SignedURLOperationDelete, time.Minute)
+
+		errs := map[string]error{
+			"Upload":           uploadErr,
+			"Delete":           readOnly.Delete(ctx, "cameras/1/clip.mp4"),
+			"DeleteDirectory":  readOnly.DeleteDirectory(ctx, "cameras"),
+			"Copy":             readOnly.Copy(ctx, "cameras/1/clip.mp4", "copy.mp4"),
+			"Move":             readOnly.Move(ctx, "cameras/1/clip.mp4", "moved.mp4"),
+			"DeleteMany":       readOnly.DeleteMany(ctx, []string{"cameras/1/clip.mp4"}),
+			"SignedURL PUT":    signErr,
+			"SignedURL DELETE": deleteSignErr,
+		}
+		for operation, err := range errs {
+			if !errors.Is(err, ErrReadOnly) {
+				t.Errorf("Expected %s to fail with ErrReadOnly, got %v", operation, err)
+			}
+		}
+
+		if exists, _ := storage.Exists(ctx, "cameras/1/clip.mp4"); !exists {
+			t.Error("Expected the file to be left untouched")
+		}
+	})
+
+	t.Run("Reads still work", func(t *testing.T) {
+		reader, _, err := readOnly.Download(ctx, "cameras/1/clip.mp4")
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(content) != "video" {
+			t.Errorf("Unexpected content '%s'", content)
+		}
+
+		if _, err := readOnly.List(ctx, "cameras/1"); err != nil {
+			t.Errorf("List failed: %v", err)
+		}
+		if _, err := readOnly.GetInfo(ctx, "cameras/1/clip.mp4"); err != nil {
+			t.Errorf("GetInfo failed: %v", err)
+		}
+		if exists, err := readOnly.Exists(ctx, "cameras/1/clip.mp4"); err != nil || !exists {
+			t.Errorf("Exists failed: %v, %v", exists, err)
+		}
+		if _, err := readOnly.GenerateSignedURL(ctx, "cameras/1/clip.mp4", SignedURLOperationGet, time.Minute); err != nil {
+			t.Errorf("Expected GET signed URLs to be allowed, got %v", err)
+		}
+	})
+
+	t.Run("Derived view leaves the original writable", func(t *testing.T) {
+		if storage.GetConfig().ReadOnly || !readOnly.GetConfig().ReadOnly {
+			t.Error("Expected only the derived view to be read-only")
+		}
+		if _, err := storage.Upload(ctx, "cameras/1/new.mp4", strings.NewReader("x"), nil); err != nil {
+			t.Errorf("Expected the original storage to accept uploads, got %v", err)
+		}
+	})
+
+	t.Run("Configured read-only", func(t *testing.T) {
+		replica, err := New(&StorageConfig{Name: "Replica", Provider: "memory", ReadOnly: true})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		if _, err := replica.Upload(ctx, "a.txt", strings.NewReader("a"), nil); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("Expected ErrReadOnly, got %v", err)
+		}
+		if _, _, err := storage.TransferTo(ctx, replica, "cameras/1/clip.mp4", "clip.mp4", TransferOptions{}); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("Expected transfers into a read-only storage to fail, got %v", err)
+		}
+	})
+
+	t.Run("Handlers respond 403", func(t *testing.T) {
+		var httpErr *echo.HTTPError
+		err := httpError(ReadOnlyError("cameras/1/clip.mp4"), "Failed to delete file")
+		if !errors.As(err, &httpErr) || httpErr.Code != http.StatusForbidden {
+			t.Errorf("Expected a 403 HTTP error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package vsaasstorage
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"time"
+)
+
+// Orders accepted by TopN
+const (
+	TopBySize = "size" // Largest files first
+	TopByAge  = "age"  // Oldest files first, by LastModified
+)
+
+// DefaultReportSize is the number of files ReportHandler returns when n is not given
+const DefaultReportSize = 20
+
+// maxReportSize bounds the n accepted by ReportHandler
+const maxReportSize = 1000
+
+// TopN returns the n largest or oldest files under root, depending on by. Files are
+// collected during a single walk into a heap of at most n entries, so memory does not
+// grow with the size of the tree.
+func (s *Storage) TopN(ctx context.Context, root string, n int, by string) ([]*FileInfo, error) {
+	var ranks func(a, b *FileInfo) bool
+	switch by {
+	case TopBySize:
+		ranks = rankBySize
+	case TopByAge:
+		ranks = rankByAge
+	default:
+		return nil, NewStorageError(ErrorCodeInvalidPath, "unknown report order: "+by)
+	}
+
+	if n <= 0 {
+		return []*FileInfo{}, nil
+	}
+
+	top := &fileHeap{ranks: ranks}
+	err := s.Walk(ctx, root, func(info *FileInfo) error {
+		if info.IsDirectory {
+			return nil
+		}
+		if top.Len() < n {
+			heap.Push(top, info)
+		} else if ranks(info, top.files[0]) {
+			top.files[0] = info
+			heap.Fix(top, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(top.files, func(i, j int) bool {
+		return ranks(top.files[i], top.files[j])
+	})
+	return top.files, nil
+}
+
+// FilesOlderThan calls fn for every file under root last modified before cutoff, as the
+// walk finds them. Returning an error from fn stops the walk.
+func (s *Storage) FilesOlderThan(ctx context.Context, root string, cutoff time.Time, fn func(info *FileInfo) error) error {
+	return s.Walk(ctx, root, func(info *FileInfo) error {
+		if info.IsDirectory || info.LastModified == nil || !info.LastModified.Before(cutoff) {
+			return nil
+		}
+		return fn(info)
+	})
+}
+
+// rankBySize reports whether a is larger than b, breaking ties by path
+func rankBySize(a, b *FileInfo) bool {
+	if a.Size != b.Size {
+		return a.Size > b.Size
+	}
+	return a.Path < b.Path
+}
+
+// rankByAge reports whether a is older than b, breaking ties by path. Files without a
+// modification time rank last.
+func rankByAge(a, b *FileInfo) bool {
+	switch {
+	case a.LastModified == nil || b.LastModified == nil:
+		if (a.LastModified == nil) != (b.LastModified == nil) {
+			return b.LastModified == nil
+		}
+	case !a.LastModified.Equal(*b.LastModified):
+		return a.LastModified.Before(*b.LastModified)
+	}
+	return a.Path < b.Path
+}
+
+// fileHeap keeps the lowest ranked file at the root so it can be replaced by a better one
+type fileHeap struct {
+	files []*FileInfo
+	ranks func(a, b *FileInfo) bool
+}
+
+func (h *fileHeap) Len() int           { return len(h.files) }
+func (h *fileHeap) Less(i, j int) bool { return h.ranks(h.files[j], h.files[i]) }
+func (h *fileHeap) Swap(i, j int)      { h.files[i], h.files[j] = h.files[j], h.files[i] }
+func (h *fileHeap) Push(x any)         { h.files = append(h.files, x.(*FileInfo)) }
+
+func (h *fileHeap) Pop() any {
+	last := h.files[len(h.files)-1]
+	h.files = h.files[:len(h.files)-1]
+	return last
+}
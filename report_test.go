@@ -0,0 +1,92 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopN(t *testing.T) {
+	ctx := context.Background()
+	storage, _ := newTransferStorages(t)
+	base := storage.config.FileSystem.BasePath
+
+	now := time.Now()
+	files := map[string]struct {
+		size int
+		age  time.Duration
+	}{
+		"cameras/1/a.mp4":      {50, 1 * time.Hour},
+		"cameras/1/b.mp4":      {10, 5 * time.Hour},
+		"cameras/2/c.mp4":      {30, 3 * time.Hour},
+		"cameras/2/2024/d.mp4": {40, 2 * time.Hour},
+		"cameras/3/e.mp4":      {20, 4 * time.Hour},
+		"other/f.mp4":          {100, 10 * time.Hour},
+	}
+	for path, file := range files {
+		storage.Upload(ctx, path, strings.NewReader(strings.Repeat("x", file.size)), nil)
+		modTime := now.Add(-file.age)
+		os.Chtimes(filepath.Join(base, path), modTime, modTime)
+	}
+
+	paths := func(infos []*FileInfo) string {
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Path)
+		}
+		return strings.Join(names, ",")
+	}
+
+	largest, err := storage.TopN(ctx, "cameras", 3, TopBySize)
+	if err != nil {
+		t.Fatalf("TopN failed: %v", err)
+	}
+	if got := paths(largest); got != "cameras/1/a.mp4,cameras/2/2024/d.mp4,cameras/2/c.mp4" {
+		t.Errorf("Unexpected largest files %s", got)
+	}
+
+	oldest, err := storage.TopN(ctx, "cameras", 2, TopByAge)
+	if err != nil {
+		t.Fatalf("TopN failed: %v", err)
+	}
+	if got := paths(oldest); got != "cameras/1/b.mp4,cameras/3/e.mp4" {
+		t.Errorf("Unexpected oldest files %s", got)
+	}
+
+	all, _ := storage.TopN(ctx, "", 100, TopBySize)
+	if len(all) != len(files) || all[0].Path != "other/f.mp4" {
+		t.Errorf("Expected every file with the largest first, got %s", paths(all))
+	}
+
+	if _, err := storage.TopN(ctx, "cameras", 3, "name"); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Expected an error for an unknown order, got %v", err)
+	}
+
+	t.Run("FilesOlderThan", func(t *testing.T) {
+		var stale []string
+		err := storage.FilesOlderThan(ctx, "cameras", now.Add(-150*time.Minute), func(info *FileInfo) error {
+			stale = append(stale, info.Path)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("FilesOlderThan failed: %v", err)
+		}
+		if got := strings.Join(stale, ","); got != "cameras/1/b.mp4,cameras/2/c.mp4,cameras/3/e.mp4" {
+			t.Errorf("Unexpected stale files %s", got)
+		}
+
+		stop := errors.New("stop")
+		calls := 0
+		err = storage.FilesOlderThan(ctx, "", now, func(info *FileInfo) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) || calls != 1 {
+			t.Errorf("Expected the callback error to stop the walk, got %v after %d calls", err, calls)
+		}
+	})
+}
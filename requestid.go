@@ -0,0 +1,123 @@
+package vsaasstorage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// RequestIDHeader is the header handlers read the request ID from, as set by the client
+// or by echo's RequestID middleware, and echo back in the response
+const RequestIDHeader = echo.HeaderXRequestID
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying a request ID. Log entries of operations run
+// with the context include it as the "request_id" field.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFrom returns the request ID carried by ctx, or "" if there is none
+func RequestIDFrom(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// requestContext gives a handler request its ID: the one already in its context, the one
+// set by echo's RequestID middleware or sent by the client, or a new one. The ID is set
+// on the response header and stored in the request context, so c.Context() carries it.
+func requestContext(c *rest.EndpointContext) (context.Context, string) {
+	ctx := c.Context()
+	requestID := RequestIDFrom(ctx)
+	if requestID == "" {
+		requestID = c.EchoCtx.Response().Header().Get(RequestIDHeader)
+	}
+	if requestID == "" {
+		requestID = c.EchoCtx.Request().Header.Get(RequestIDHeader)
+	}
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+
+	c.EchoCtx.Response().Header().Set(RequestIDHeader, requestID)
+	if RequestIDFrom(ctx) != requestID {
+		ctx = WithRequestID(ctx, requestID)
+		c.EchoCtx.SetRequest(c.EchoCtx.Request().WithContext(ctx))
+	}
+	return ctx, requestID
+}
+
+// traced runs a handler with a request ID in its context. A failure is logged, with the
+// ID, and returned with "request_id" in its JSON body and the StorageError behind it, if
+// any, carrying the ID as the cause of the HTTP error.
+func (s *Storage) traced(handler func(c *rest.EndpointContext) error) func(c *rest.EndpointContext) error {
+	return func(c *rest.EndpointContext) error {
+		ctx, requestID := requestContext(c)
+		err := handler(c)
+		if err == nil {
+			return nil
+		}
+
+		var httpErr *echo.HTTPError
+		if !errors.As(err, &httpErr) {
+			// Like echo, answer unexpected errors without exposing their text
+			httpErr = echo.NewHTTPError(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			httpErr.Internal = err
+		}
+
+		body := map[string]interface{}{}
+		switch message := httpErr.Message.(type) {
+		case map[string]interface{}:
+			for key, value := range message {
+				body[key] = value
+			}
+		default:
+			body["message"] = message
+		}
+		body["request_id"] = requestID
+		traced := echo.NewHTTPError(httpErr.Code, body)
+
+		cause := httpErr.Internal
+		var storageErr *StorageError
+		var multi *MultiError
+		if !errors.As(err, &multi) && errors.As(err, &storageErr) {
+			annotated := *storageErr
+			annotated.RequestID = requestID
+			cause = &annotated
+		}
+		traced.Internal = cause
+
+		level := LogLevelWarn
+		if httpErr.Code >= http.StatusInternalServerError {
+			level = LogLevelError
+		}
+		fields := map[string]interface{}{
+			"storage": s.config.Name,
+			"method":  c.EchoCtx.Request().Method,
+			"path":    c.EchoCtx.Request().URL.Path,
+			"status":  httpErr.Code,
+		}
+		if cause != nil {
+			fields["error"] = cause.Error()
+		} else {
+			fields["error"] = fmt.Sprint(httpErr.Message)
+		}
+		s.config.log(ctx, level, "request failed", fields)
+
+		return traced
+	}
+}
@@ -0,0 +1,120 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// fieldsLogger keeps the fields of every entry logged by the storage
+type fieldsLogger struct {
+	mu      sync.Mutex
+	entries []map[string]interface{}
+}
+
+func (l *fieldsLogger) Log(ctx context.Context, level LogLevel, message string, fields map[string]interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, fields)
+}
+
+// brokenInfoProvider fails every GetInfo as an unreachable backend would
+type brokenInfoProvider struct {
+	StorageProvider
+}
+
+func (p *brokenInfoProvider) GetInfo(ctx context.Context, path string) (*FileInfo, error) {
+	return nil, NewProviderError("broken", ErrorCodeProviderError, "backend unreachable", nil)
+}
+
+func TestRequestID(t *testing.T) {
+	logger := &fieldsLogger{}
+	RegisterProvider("broken-info", func(config *StorageConfig) (StorageProvider, error) {
+		inner, err := NewMemoryProvider(config)
+		return &brokenInfoProvider{StorageProvider: inner}, err
+	})
+	storage, err := New(&StorageConfig{Name: "test", Provider: "broken-info", Logger: logger})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	request := func(requestID string) (*echo.HTTPError, *httptest.ResponseRecorder) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/files/info?path=cameras/1.mp4", nil)
+		if requestID != "" {
+			req.Header.Set(RequestIDHeader, requestID)
+		}
+		recorder := httptest.NewRecorder()
+		c := &rest.EndpointContext{EchoCtx: echo.New().NewContext(req, recorder)}
+
+		var httpErr *echo.HTTPError
+		if err := storage.InfoHandler()(c); !errors.As(err, &httpErr) || httpErr.Code != http.StatusBadGateway {
+			t.Fatalf("Expected a 502, got %v", err)
+		}
+		return httpErr, recorder
+	}
+
+	t.Run("FromHeader", func(t *testing.T) {
+		httpErr, recorder := request("req-123")
+		body, _ := httpErr.Message.(map[string]interface{})
+		if body["request_id"] != "req-123" || !strings.Contains(body["message"].(string), "backend unreachable") {
+			t.Errorf("Expected the request ID in the body, got %v", httpErr.Message)
+		}
+		if got := recorder.Header().Get(RequestIDHeader); got != "req-123" {
+			t.Errorf("Expected the request ID in the response header, got %q", got)
+		}
+
+		var storageErr *StorageError
+		if !errors.As(httpErr, &storageErr) || storageErr.RequestID != "req-123" || storageErr.Code != ErrorCodeProviderError {
+			t.Errorf("Expected the StorageError behind the response to carry the ID, got %+v", storageErr)
+		}
+
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		if len(logger.entries) == 0 {
+			t.Fatal("Expected the failure to be logged")
+		}
+		for _, fields := range logger.entries {
+			if fields["request_id"] != "req-123" {
+				t.Errorf("Expected every log entry to carry the request ID, got %v", fields)
+			}
+		}
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		httpErr, recorder := request("")
+		body, _ := httpErr.Message.(map[string]interface{})
+		requestID, _ := body["request_id"].(string)
+		if requestID == "" || recorder.Header().Get(RequestIDHeader) != requestID {
+			t.Errorf("Expected a generated ID in the body and header, got %q and %q", requestID, recorder.Header().Get(RequestIDHeader))
+		}
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		ctx := WithRequestID(context.Background(), "job-7")
+		if got := RequestIDFrom(ctx); got != "job-7" {
+			t.Errorf("Expected job-7, got %q", got)
+		}
+		if got := RequestIDFrom(context.Background()); got != "" {
+			t.Errorf("Expected no ID in a plain context, got %q", got)
+		}
+
+		fields := map[string]interface{}{"storage": "test"}
+		storage.config.log(ctx, LogLevelInfo, "message", fields)
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		if last := logger.entries[len(logger.entries)-1]; last["request_id"] != "job-7" {
+			t.Errorf("Expected the ID of the context in the entry, got %v", last)
+		}
+		if _, ok := fields["request_id"]; ok {
+			t.Error("Expected the caller's fields to be left unchanged")
+		}
+	})
+}
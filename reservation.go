@@ -0,0 +1,120 @@
+package vsaasstorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path"
+	"strings"
+	"time"
+)
+
+// claimsPrefix holds the claim objects that reserve generated upload names
+const claimsPrefix = ".claims"
+
+// maxReservationAttempts bounds the names reserveFilename tries before giving up
+const maxReservationAttempts = 10
+
+// isClaimPath reports whether a path is inside the claims prefix
+func isClaimPath(p string) bool {
+	clean := cleanPath(p)
+	return clean == claimsPrefix || strings.HasPrefix(clean, claimsPrefix+"/")
+}
+
+// claimPath returns the claim object of a path. Claims are named by the hash of the path,
+// so they live in a single flat directory whatever the length or depth of the path.
+func claimPath(filePath string) string {
+	sum := sha256.Sum256([]byte(cleanPath(filePath)))
+	return path.Join(claimsPrefix, hex.EncodeToString(sum[:]))
+}
+
+// reserveFilename generates a unique name for an upload and reserves the path place puts
+// it at, so that concurrent uploads that generate the same name never write to the same
+// file. The returned release gives the reservation up and must be called once the upload
+// finished or failed.
+func (s *Storage) reserveFilename(ctx context.Context, originalFilename string, place func(fileName string) string) (string, string, func(), error) {
+	for attempt := 1; ; attempt++ {
+		fileName, filePath, err := s.uniqueFilename(ctx, originalFilename, place)
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		release, err := s.reserve(ctx, filePath)
+		if err == nil {
+			return fileName, filePath, release, nil
+		}
+		if !errors.Is(err, ErrFileAlreadyExists) || attempt == maxReservationAttempts {
+			return "", "", nil, err
+		}
+	}
+}
+
+// reserve claims filePath by creating its claim object exclusively and then checking that
+// the file does not exist, failing with ErrFileAlreadyExists otherwise. Every writer of a
+// generated name claims it first, so two can never hold the same one. Providers without
+// ConditionalProvider cannot create the claim atomically and rely on the random suffix of
+// the name alone.
+func (s *Storage) reserve(ctx context.Context, filePath string) (func(), error) {
+	if err := s.checkWritable(filePath); err != nil {
+		return nil, err
+	}
+	provider, ok := providerAs[ConditionalProvider](s.provider)
+	if !ok {
+		return func() {}, nil
+	}
+
+	claim := s.extensionPath(claimPath(filePath))
+	if _, err := provider.UploadIfMatch(ctx, claim, strings.NewReader(""), nil, ""); err != nil {
+		if errors.Is(err, ErrPreconditionFailed) {
+			return nil, FileAlreadyExistsError(filePath)
+		}
+		return nil, err
+	}
+	release := func() {
+		// The claim must go even when the upload was canceled
+		s.provider.Delete(context.WithoutCancel(ctx), claim)
+	}
+
+	exists, err := s.Exists(ctx, filePath)
+	if err != nil || exists {
+		release()
+		if err == nil {
+			err = FileAlreadyExistsError(filePath)
+		}
+		return nil, err
+	}
+	return release, nil
+}
+
+// cleanupClaims removes the claims older than olderThan, left behind by processes that
+// crashed during an upload, and returns how many were removed
+func (s *Storage) cleanupClaims(ctx context.Context, olderThan time.Duration) (int, error) {
+	cutoff := s.config.now().Add(-olderThan)
+
+	var stale []string
+	err := s.walk(ctx, claimsPrefix, ListOptions{IncludeHidden: true, AllowMissing: true}, func(info *FileInfo) error {
+		if !info.IsDirectory && info.LastModified != nil && info.LastModified.Before(cutoff) {
+			stale = append(stale, info.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, claim := range stale {
+		if err := checkContext(ctx, claim); err != nil {
+			return removed, err
+		}
+		if err := s.provider.Delete(ctx, claim); err != nil {
+			if errors.Is(err, ErrFileNotFound) {
+				continue // Released concurrently
+			}
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
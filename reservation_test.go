@@ -0,0 +1,131 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestConcurrentUniqueFilenames(t *testing.T) {
+	// Every suffix is handed out twice, so uploads keep generating names already in use
+	var generated int64
+	restore := uniqueSuffix
+	uniqueSuffix = func() string {
+		return fmt.Sprintf("%08d", atomic.AddInt64(&generated, 1)/2)
+	}
+	defer func() { uniqueSuffix = restore }()
+
+	const uploads = 100
+	sources := t.TempDir()
+	contents := make([][]byte, uploads)
+	files := make([]*rest.UploadedFile, uploads)
+	for i := range files {
+		contents[i] = bytes.Repeat([]byte(fmt.Sprintf("upload %03d\n", i)), 4096)
+		source := filepath.Join(sources, fmt.Sprintf("photo-%d.jpg", i))
+		os.WriteFile(source, contents[i], 0644)
+		files[i] = &rest.UploadedFile{Path: source, Filename: "photo.jpg", OriginalName: "photo.jpg", MimeType: "image/jpeg"}
+	}
+
+	configs := map[string]*StorageConfig{
+		"filesystem": {Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir()}},
+		"memory":     {Name: "test", Provider: "memory"},
+	}
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			results := make([]*UploadedFileResult, uploads)
+			var wg sync.WaitGroup
+			for i, file := range files {
+				wg.Add(1)
+				go func(i int, file *rest.UploadedFile) {
+					defer wg.Done()
+					result, err := storage.UploadFromUploadedFile(ctx, file, "file", "uploads")
+					if err != nil {
+						t.Errorf("Upload %d failed: %v", i, err)
+						return
+					}
+					results[i] = result
+				}(i, file)
+			}
+			wg.Wait()
+			if t.Failed() {
+				return
+			}
+
+			paths := make(map[string]bool, uploads)
+			for i, result := range results {
+				if paths[result.Path] {
+					t.Errorf("Upload %d reused %s", i, result.Path)
+				}
+				paths[result.Path] = true
+
+				reader, _, err := storage.Download(ctx, result.Path)
+				if err != nil {
+					t.Fatalf("Download of %s failed: %v", result.Path, err)
+				}
+				data, _ := io.ReadAll(reader)
+				reader.Close()
+				if !bytes.Equal(data, contents[i]) {
+					t.Errorf("Expected %s to hold upload %d whole, got %d bytes", result.Path, i, len(data))
+				}
+			}
+
+			entries, err := storage.List(ctx, "uploads")
+			if err != nil || len(entries) != uploads {
+				t.Errorf("Expected %d distinct files, got %d (%v)", uploads, len(entries), err)
+			}
+			claims, _ := storage.ListWithOptions(ctx, claimsPrefix, ListOptions{IncludeHidden: true, AllowMissing: true})
+			if len(claims) != 0 {
+				t.Errorf("Expected every reservation to be released, got %d claims", len(claims))
+			}
+			root, _ := storage.List(ctx, "")
+			for _, entry := range root {
+				if entry.Name == claimsPrefix {
+					t.Error("Expected the reservations to be hidden from the root listing")
+				}
+			}
+		})
+	}
+}
+
+func TestReservationCleanup(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{Name: "test", Provider: "memory"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	// A process that crashed mid-upload never releases its reservation
+	if _, err := storage.reserve(ctx, "uploads/photo_1.jpg"); err != nil {
+		t.Fatalf("reserve failed: %v", err)
+	}
+	if _, err := storage.reserve(ctx, "uploads/photo_1.jpg"); err == nil {
+		t.Fatal("Expected a reserved name to be refused")
+	}
+	if root, _ := storage.List(ctx, ""); len(root) != 0 {
+		t.Errorf("Expected the reservation to be hidden, got %+v", root[0])
+	}
+
+	report, err := storage.CleanupOrphans(ctx, 0)
+	if err != nil || report.Claims != 1 {
+		t.Fatalf("Expected the stale claim to be removed, got %+v, %v", report, err)
+	}
+	release, err := storage.reserve(ctx, "uploads/photo_1.jpg")
+	if err != nil {
+		t.Fatalf("Expected the name to be free again, got %v", err)
+	}
+	release()
+}
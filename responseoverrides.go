@@ -0,0 +1,85 @@
+package vsaasstorage
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Claims of filesystem GET tokens carrying ResponseOverrides, named after the S3 query parameters
+const (
+	responseContentDispositionClaim = "response-content-disposition"
+	responseContentTypeClaim        = "response-content-type"
+)
+
+// ResponseOverrides replace the headers a GET signed URL is served with, e.g. to download
+// a file under another name: ContentDisposition `attachment; filename="evidence-case-4411.mp4"`.
+// They are part of the signature, so the holder of the URL cannot change them.
+type ResponseOverrides struct {
+	ContentDisposition string
+	ContentType        string
+}
+
+// ResponseOverrideProvider is implemented by providers whose GET signed URLs can override
+// the Content-Disposition and Content-Type of the response
+type ResponseOverrideProvider interface {
+	// GenerateSignedURLWithOverrides signs a GET URL served with the given headers
+	GenerateSignedURLWithOverrides(ctx context.Context, path string, expiresIn time.Duration, overrides ResponseOverrides) (string, error)
+}
+
+// empty reports whether no header is overridden
+func (o ResponseOverrides) empty() bool {
+	return o.ContentDisposition == "" && o.ContentType == ""
+}
+
+// validate refuses values that could inject headers or that are not a valid header value:
+// both must parse as a media type with parameters, like "inline" or "video/mp4"
+func (o ResponseOverrides) validate() error {
+	for name, value := range map[string]string{"Content-Disposition": o.ContentDisposition, "Content-Type": o.ContentType} {
+		if value == "" {
+			continue
+		}
+		if strings.ContainsFunc(value, func(r rune) bool { return r < ' ' && r != '\t' || r == 0x7f }) {
+			return NewStorageError(ErrorCodeSignedURLFailed, name+" override contains control characters")
+		}
+		if _, _, err := mime.ParseMediaType(value); err != nil {
+			return NewStorageErrorWithCause(ErrorCodeSignedURLFailed, "invalid "+name+" override", err)
+		}
+	}
+	return nil
+}
+
+// claims adds the overrides to the claims of a filesystem token
+func (o ResponseOverrides) claims(claims map[string]interface{}) {
+	if o.ContentDisposition != "" {
+		claims[responseContentDispositionClaim] = o.ContentDisposition
+	}
+	if o.ContentType != "" {
+		claims[responseContentTypeClaim] = o.ContentType
+	}
+}
+
+// tokenResponseOverrides returns the overrides signed into a token that was already
+// validated. Values that do not validate are dropped.
+func tokenResponseOverrides(token string) ResponseOverrides {
+	claims := tokenClaims(token)
+	var overrides ResponseOverrides
+	overrides.ContentDisposition, _ = claims[responseContentDispositionClaim].(string)
+	overrides.ContentType, _ = claims[responseContentTypeClaim].(string)
+	if overrides.validate() != nil {
+		return ResponseOverrides{}
+	}
+	return overrides
+}
+
+// apply sets the overridden headers of a response
+func (o ResponseOverrides) apply(header http.Header) {
+	if o.ContentDisposition != "" {
+		header.Set("Content-Disposition", o.ContentDisposition)
+	}
+	if o.ContentType != "" {
+		header.Set("Content-Type", o.ContentType)
+	}
+}
@@ -0,0 +1,123 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestSignedURLResponseOverrides(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{
+		Name:       "test",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+		SignedURL:  &SignedURLConfig{Enabled: true, SecretKey: "secret"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.Upload(ctx, "cases/4411/clip.mp4", strings.NewReader("0123456789"), &FileMetadata{ContentType: "video/mp4"})
+
+	download := func(target string) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		recorder := httptest.NewRecorder()
+		c := &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder)}
+		if err := storage.StreamFile(c, "cases/4411/clip.mp4"); err != nil {
+			t.Fatalf("StreamFile failed: %v", err)
+		}
+		return recorder
+	}
+
+	overrides := ResponseOverrides{
+		ContentDisposition: `attachment; filename="evidence-case-4411.mp4"`,
+		ContentType:        "application/octet-stream",
+	}
+	token, err := storage.GenerateSignedURLWithOptions(ctx, "cases/4411/clip.mp4", SignedURLOperationGet, SignedURLOptions{ExpiresIn: time.Minute, Response: overrides})
+	if err != nil {
+		t.Fatalf("Failed to sign URL: %v", err)
+	}
+
+	t.Run("Applied", func(t *testing.T) {
+		recorder := download("/download?token=" + token)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != "0123456789" {
+			t.Fatalf("Expected the file, got %d %q", recorder.Code, recorder.Body.String())
+		}
+		if got := recorder.Header().Get("Content-Disposition"); got != overrides.ContentDisposition {
+			t.Errorf("Expected the signed Content-Disposition, got %q", got)
+		}
+		if got := recorder.Header().Get("Content-Type"); got != overrides.ContentType {
+			t.Errorf("Expected the signed Content-Type, got %q", got)
+		}
+	})
+
+	t.Run("QueryIgnored", func(t *testing.T) {
+		query := url.Values{
+			"token":                        {token},
+			"response-content-disposition": {`attachment; filename="other.exe"`},
+			"response-content-type":        {"text/html"},
+		}
+		recorder := download("/download?" + query.Encode())
+		if recorder.Header().Get("Content-Disposition") != overrides.ContentDisposition || recorder.Header().Get("Content-Type") != overrides.ContentType {
+			t.Errorf("Expected the query parameters of the client to be ignored, got %v", recorder.Header())
+		}
+	})
+
+	t.Run("Plain", func(t *testing.T) {
+		plain, _ := storage.GenerateSignedURL(ctx, "cases/4411/clip.mp4", SignedURLOperationGet, time.Minute)
+		recorder := download("/download?token=" + plain)
+		if got := recorder.Header().Get("Content-Disposition"); got != `attachment; filename="clip.mp4"` {
+			t.Errorf("Expected the stored name without overrides, got %q", got)
+		}
+		if got := recorder.Header().Get("Content-Type"); got != "video/mp4" {
+			t.Errorf("Expected the stored content type without overrides, got %q", got)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		invalid := []ResponseOverrides{
+			{ContentDisposition: "attachment; filename=\"a.mp4\"\r\nSet-Cookie: session=1"},
+			{ContentType: "video/mp4\nX-Injected: 1"},
+			{ContentType: "not a type"},
+			{ContentDisposition: `attachment; filename="unterminated`},
+		}
+		for _, response := range invalid {
+			_, err := storage.GenerateSignedURLWithOptions(ctx, "cases/4411/clip.mp4", SignedURLOperationGet, SignedURLOptions{ExpiresIn: time.Minute, Response: response})
+			if !errors.Is(err, &StorageError{Code: ErrorCodeSignedURLFailed}) {
+				t.Errorf("Expected %+v to be refused, got %v", response, err)
+			}
+		}
+
+		_, err := storage.GenerateSignedURLWithOptions(ctx, "cases/4411/clip.mp4", SignedURLOperationPut, SignedURLOptions{ExpiresIn: time.Minute, Response: overrides})
+		if err == nil {
+			t.Error("Expected overrides on a PUT URL to be refused")
+		}
+	})
+
+	if !storage.Capabilities().ResponseOverrides {
+		t.Error("Expected the response overrides capability")
+	}
+	memory, _ := New(&StorageConfig{Name: "test", Provider: "memory"})
+	if _, err := memory.GenerateSignedURLWithOptions(ctx, "a.mp4", SignedURLOperationGet, SignedURLOptions{Response: overrides}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected providers without overrides to refuse them, got %v", err)
+	}
+}
+
+func TestS3ResponseQuery(t *testing.T) {
+	query := s3ResponseQuery(ResponseOverrides{ContentDisposition: `attachment; filename="evidence.mp4"`, ContentType: "video/mp4"})
+	if query.Get("response-content-disposition") != `attachment; filename="evidence.mp4"` || query.Get("response-content-type") != "video/mp4" {
+		t.Errorf("Unexpected query %v", query)
+	}
+	if len(s3ResponseQuery(ResponseOverrides{})) != 0 {
+		t.Error("Expected no parameters without overrides")
+	}
+}
@@ -0,0 +1,58 @@
+package vsaasstorage
+
+import (
+	"context"
+	"time"
+)
+
+// RetentionProvider is implemented by providers that can lock files against
+// deletion and overwrite until a given time
+type RetentionProvider interface {
+	// SetRetention locks a file until the given time. Existing locks can only be extended.
+	SetRetention(ctx context.Context, path string, until time.Time) error
+	// GetRetention returns the time a file is locked until, or nil if it is not locked
+	GetRetention(ctx context.Context, path string) (*time.Time, error)
+}
+
+// SetRetention prevents a file from being deleted, moved or overwritten until the given time.
+// A lock can be extended but never shortened.
+func (s *Storage) SetRetention(ctx context.Context, path string, until time.Time) error {
+	if err := s.checkWritable(path); err != nil {
+		return err
+	}
+
+	provider, ok := providerAs[RetentionProvider](s.provider)
+	if !ok {
+		return NotSupportedError("retention is not supported by the provider")
+	}
+	if err := provider.SetRetention(ctx, s.extensionPath(path), until); err != nil {
+		return err
+	}
+	s.journalCurrent(ctx, JournalOperationMetadata, path, "")
+	return nil
+}
+
+// GetRetention returns the time a file is locked until, or nil if it is not locked
+func (s *Storage) GetRetention(ctx context.Context, path string) (*time.Time, error) {
+	provider, ok := providerAs[RetentionProvider](s.provider)
+	if !ok {
+		return nil, NotSupportedError("retention is not supported by the provider")
+	}
+	return provider.GetRetention(ctx, s.extensionPath(path))
+}
+
+// activeRetention returns until if it is still in the future, or nil
+func activeRetention(until *time.Time, now time.Time) *time.Time {
+	if until == nil || !until.After(now) {
+		return nil
+	}
+	return until
+}
+
+// checkRetentionExtension rejects attempts to shorten a lock still active at now
+func checkRetentionExtension(path string, current *time.Time, until, now time.Time) error {
+	if current = activeRetention(current, now); current != nil && until.Before(*current) {
+		return RetentionLockedError(path, *current)
+	}
+	return nil
+}
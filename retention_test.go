@@ -0,0 +1,129 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+
+	for _, provider := range []string{"filesystem", "memory"} {
+		t.Run(provider, func(t *testing.T) {
+			config := &StorageConfig{
+				Name:     "EvidenceStorage",
+				Provider: provider,
+			}
+			if provider == "filesystem" {
+				config.FileSystem = &FileSystemConfig{BasePath: t.TempDir()}
+			}
+
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			for _, path := range []string{"case/evidence.mp4", "case/notes.txt", "case/sub/clip.mp4"} {
+				if _, err := storage.Upload(ctx, path, strings.NewReader(path), nil); err != nil {
+					t.Fatalf("Upload of %s failed: %v", path, err)
+				}
+			}
+
+			until := time.Now().Add(time.Hour)
+			if err := storage.SetRetention(ctx, "case/evidence.mp4", until); err != nil {
+				t.Fatalf("SetRetention failed: %v", err)
+			}
+
+			retainUntil, err := storage.GetRetention(ctx, "case/evidence.mp4")
+			if err != nil || retainUntil == nil || !retainUntil.Equal(until.UTC()) {
+				t.Fatalf("Expected retention until %v, got %v, %v", until, retainUntil, err)
+			}
+
+			if err := storage.SetRetention(ctx, "case/evidence.mp4", until.Add(-time.Minute)); !errors.Is(err, ErrRetentionLocked) {
+				t.Errorf("Expected shortening the lock to fail, got %v", err)
+			}
+
+			if err := storage.Delete(ctx, "case/evidence.mp4"); !errors.Is(err, ErrRetentionLocked) {
+				t.Errorf("Expected Delete to fail, got %v", err)
+			}
+
+			if err := storage.Move(ctx, "case/evidence.mp4", "other.mp4"); !errors.Is(err, ErrRetentionLocked) {
+				t.Errorf("Expected Move to fail, got %v", err)
+			}
+
+			if _, err := storage.Upload(ctx, "case/evidence.mp4", strings.NewReader("x"), nil); !errors.Is(err, ErrRetentionLocked) {
+				t.Errorf("Expected overwriting Upload to fail, got %v", err)
+			}
+
+			if err := storage.Copy(ctx, "case/evidence.mp4", "copy.mp4"); err != nil {
+				t.Fatalf("Copy failed: %v", err)
+			}
+			if retainUntil, _ := storage.GetRetention(ctx, "copy.mp4"); retainUntil != nil {
+				t.Errorf("Expected copy without retention, got %v", retainUntil)
+			}
+
+			err = storage.DeleteDirectory(ctx, "case")
+			var retentionErr *DirectoryRetentionError
+			if !errors.As(err, &retentionErr) {
+				t.Fatalf("Expected DirectoryRetentionError, got %v", err)
+			}
+			if len(retentionErr.Skipped) != 1 || retentionErr.Skipped[0] != "case/evidence.mp4" {
+				t.Errorf("Unexpected skipped files %v", retentionErr.Skipped)
+			}
+			if !errors.Is(err, ErrRetentionLocked) {
+				t.Error("Expected DirectoryRetentionError to match ErrRetentionLocked")
+			}
+
+			if exists, _ := storage.Exists(ctx, "case/notes.txt"); exists {
+				t.Error("Expected unlocked files to be deleted")
+			}
+			if exists, _ := storage.Exists(ctx, "case/sub"); exists {
+				t.Error("Expected emptied directories to be deleted")
+			}
+			if exists, _ := storage.Exists(ctx, "case/evidence.mp4"); !exists {
+				t.Error("Expected locked file to survive")
+			}
+		})
+	}
+}
+
+func TestRetentionClock(t *testing.T) {
+	ctx := context.Background()
+
+	for _, provider := range []string{"filesystem", "memory"} {
+		t.Run(provider, func(t *testing.T) {
+			clock := newManualClock()
+			config := &StorageConfig{Name: "EvidenceStorage", Provider: provider, Clock: clock}
+			if provider == "filesystem" {
+				config.FileSystem = &FileSystemConfig{BasePath: t.TempDir()}
+			}
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			storage.Upload(ctx, "case/evidence.mp4", strings.NewReader("x"), nil)
+			if err := storage.SetRetention(ctx, "case/evidence.mp4", clock.Now().Add(time.Hour)); err != nil {
+				t.Fatalf("SetRetention failed: %v", err)
+			}
+			if err := storage.Delete(ctx, "case/evidence.mp4"); !errors.Is(err, ErrRetentionLocked) {
+				t.Fatalf("Expected Delete to fail while locked, got %v", err)
+			}
+
+			// The lock expires by the storage clock, not the system one
+			clock.Advance(2 * time.Hour)
+			if retainUntil, err := storage.GetRetention(ctx, "case/evidence.mp4"); err != nil || retainUntil != nil {
+				t.Errorf("Expected the lock to have expired, got %v, %v", retainUntil, err)
+			}
+			if info, err := storage.GetInfo(ctx, "case/evidence.mp4"); err != nil || info.RetainUntil != nil {
+				t.Errorf("Expected no lock in the info, got %v, %v", info, err)
+			}
+			if err := storage.Delete(ctx, "case/evidence.mp4"); err != nil {
+				t.Errorf("Expected Delete to succeed after the lock expired, got %v", err)
+			}
+		})
+	}
+}
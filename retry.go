@@ -0,0 +1,277 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// RetryPolicy configures how transient provider errors are retried
+type RetryPolicy struct {
+	MaxAttempts    int           `json:"maxAttempts"`              // Total attempts including the first one
+	InitialDelay   time.Duration `json:"initialDelay"`             // Delay before the first retry
+	MaxDelay       time.Duration `json:"maxDelay"`                 // Upper bound for a single delay
+	Multiplier     float64       `json:"multiplier"`               // Backoff growth factor
+	Jitter         float64       `json:"jitter"`                   // Random fraction (0-1) applied to each delay
+	RetryableCodes []ErrorCode   `json:"retryableCodes,omitempty"` // Error codes considered transient
+
+	// IsRetryable overrides the default classification when set
+	IsRetryable func(err error) bool `json:"-"`
+	// OnRetry is called before each retry attempt
+	OnRetry func(ctx context.Context, operation, path string, attempt int, err error) `json:"-"`
+}
+
+// DefaultRetryableCodes are the error codes retried when a policy does not specify any
+var DefaultRetryableCodes = []ErrorCode{
+	ErrorCodeProviderError,
+	ErrorCodeInternalError,
+	ErrorCodeThrottled,
+	ErrorCodeTimeout,
+}
+
+// withDefaults returns a copy of the policy with zero values replaced by defaults
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = 100 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 5 * time.Second
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		p.Jitter = 0
+	}
+	if len(p.RetryableCodes) == 0 {
+		p.RetryableCodes = DefaultRetryableCodes
+	}
+	return p
+}
+
+// retryable reports whether the error should be retried under this policy
+func (p RetryPolicy) retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if p.IsRetryable != nil {
+		return p.IsRetryable(err)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var storageErr *StorageError
+	if errors.As(err, &storageErr) {
+		for _, code := range p.RetryableCodes {
+			if storageErr.Code == code {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// delay returns the backoff duration before the given retry attempt (1-based)
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(attempt-1))
+	if delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (rand.Float64()*2 - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// RetryingProvider wraps a StorageProvider retrying idempotent operations on transient errors
+type RetryingProvider struct {
+	inner  StorageProvider
+	policy RetryPolicy
+}
+
+// NewRetryingProvider creates a new provider that retries transient errors of the inner provider
+func NewRetryingProvider(inner StorageProvider, policy RetryPolicy) *RetryingProvider {
+	return &RetryingProvider{
+		inner:  inner,
+		policy: policy.withDefaults(),
+	}
+}
+
+// Unwrap returns the wrapped provider
+func (p *RetryingProvider) Unwrap() StorageProvider {
+	return p.inner
+}
+
+// do runs fn until it succeeds, fails with a non-retryable error or runs out of attempts
+func (p *RetryingProvider) do(ctx context.Context, operation, path string, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= p.policy.MaxAttempts || !p.policy.retryable(err) {
+			return err
+		}
+
+		if p.policy.OnRetry != nil {
+			p.policy.OnRetry(ctx, operation, path, attempt, err)
+		}
+
+		timer := time.NewTimer(p.policy.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// Upload uploads a file, retrying only when the reader can be rewound
+func (p *RetryingProvider) Upload(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata) (*FileInfo, error) {
+	seeker, ok := reader.(io.Seeker)
+	if !ok {
+		return p.inner.Upload(ctx, path, reader, metadata)
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return p.inner.Upload(ctx, path, reader, metadata)
+	}
+
+	var info *FileInfo
+	first := true
+	err = p.do(ctx, "upload", path, func() error {
+		if !first {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return NewStorageErrorWithCause(ErrorCodeUploadFailed, "failed to rewind reader for retry", err)
+			}
+		}
+		first = false
+
+		var err error
+		info, err = p.inner.Upload(ctx, path, reader, metadata)
+		return err
+	})
+	return info, err
+}
+
+// Download downloads a file, retrying transient errors when opening it
+func (p *RetryingProvider) Download(ctx context.Context, path string) (io.ReadCloser, *FileInfo, error) {
+	var reader io.ReadCloser
+	var info *FileInfo
+	err := p.do(ctx, "download", path, func() error {
+		var err error
+		reader, info, err = p.inner.Download(ctx, path)
+		return err
+	})
+	return reader, info, err
+}
+
+// Delete deletes a file, treating a missing file on a retry as success
+func (p *RetryingProvider) Delete(ctx context.Context, path string) error {
+	attempts := 0
+	return p.do(ctx, "delete", path, func() error {
+		attempts++
+		err := p.inner.Delete(ctx, path)
+		if attempts > 1 && errors.Is(err, FileNotFoundError(path)) {
+			// A previous attempt may have succeeded before failing
+			return nil
+		}
+		return err
+	})
+}
+
+// Exists checks if a file exists, retrying transient errors
+func (p *RetryingProvider) Exists(ctx context.Context, path string) (bool, error) {
+	var exists bool
+	err := p.do(ctx, "exists", path, func() error {
+		var err error
+		exists, err = p.inner.Exists(ctx, path)
+		return err
+	})
+	return exists, err
+}
+
+// GetInfo gets information about a file, retrying transient errors
+func (p *RetryingProvider) GetInfo(ctx context.Context, path string) (*FileInfo, error) {
+	var info *FileInfo
+	err := p.do(ctx, "get_info", path, func() error {
+		var err error
+		info, err = p.inner.GetInfo(ctx, path)
+		return err
+	})
+	return info, err
+}
+
+// List lists files in a directory, retrying transient errors
+func (p *RetryingProvider) List(ctx context.Context, path string) ([]*FileInfo, error) {
+	var files []*FileInfo
+	err := p.do(ctx, "list", path, func() error {
+		var err error
+		files, err = p.inner.List(ctx, path)
+		return err
+	})
+	return files, err
+}
+
+// DeleteDirectory deletes a directory without retrying
+func (p *RetryingProvider) DeleteDirectory(ctx context.Context, path string) error {
+	return p.inner.DeleteDirectory(ctx, path)
+}
+
+// Copy copies a file, retrying transient errors
+func (p *RetryingProvider) Copy(ctx context.Context, srcPath, dstPath string) error {
+	return p.do(ctx, "copy", srcPath, func() error {
+		return p.inner.Copy(ctx, srcPath, dstPath)
+	})
+}
+
+// Move moves a file without retrying, since a partial move is not idempotent
+func (p *RetryingProvider) Move(ctx context.Context, srcPath, dstPath string) error {
+	return p.inner.Move(ctx, srcPath, dstPath)
+}
+
+// GenerateSignedURL generates a signed URL using the inner provider
+func (p *RetryingProvider) GenerateSignedURL(ctx context.Context, path string, operation SignedURLOperation, expiresIn time.Duration) (string, error) {
+	return p.inner.GenerateSignedURL(ctx, path, operation, expiresIn)
+}
+
+// retryPolicy returns the configured retry policy reporting retries to the config hooks
+func (c *StorageConfig) retryPolicy() RetryPolicy {
+	policy := *c.Retry
+	onRetry := policy.OnRetry
+	policy.OnRetry = func(ctx context.Context, operation, path string, attempt int, err error) {
+		c.incCounter("storage_retries_total", 1, map[string]string{
+			"storage":   c.Name,
+			"operation": operation,
+		})
+		c.log(ctx, LogLevelWarn, "retrying storage operation", map[string]interface{}{
+			"storage":   c.Name,
+			"operation": operation,
+			"path":      path,
+			"attempt":   attempt,
+			"error":     err.Error(),
+		})
+		if onRetry != nil {
+			onRetry(ctx, operation, path, attempt, err)
+		}
+	}
+	return policy
+}
@@ -0,0 +1,271 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProvider is a StorageProvider whose operations fail with scripted errors
+type fakeProvider struct {
+	StorageProvider
+
+	mu    sync.Mutex
+	calls map[string]int
+	errs  map[string][]error
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{
+		calls: make(map[string]int),
+		errs:  make(map[string][]error),
+	}
+}
+
+// failWith queues errors returned by the next calls of the operation
+func (p *fakeProvider) failWith(operation string, errs ...error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errs[operation] = append(p.errs[operation], errs...)
+}
+
+func (p *fakeProvider) next(operation string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls[operation]++
+	if len(p.errs[operation]) == 0 {
+		return nil
+	}
+	err := p.errs[operation][0]
+	p.errs[operation] = p.errs[operation][1:]
+	return err
+}
+
+func (p *fakeProvider) callCount(operation string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls[operation]
+}
+
+func (p *fakeProvider) Upload(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata) (*FileInfo, error) {
+	data, _ := io.ReadAll(reader)
+	if err := p.next("upload"); err != nil {
+		return nil, err
+	}
+	return &FileInfo{Path: path, Size: int64(len(data))}, nil
+}
+
+func (p *fakeProvider) Download(ctx context.Context, path string) (io.ReadCloser, *FileInfo, error) {
+	if err := p.next("download"); err != nil {
+		return nil, nil, err
+	}
+	return io.NopCloser(strings.NewReader("data")), &FileInfo{Path: path, Size: 4}, nil
+}
+
+func (p *fakeProvider) Delete(ctx context.Context, path string) error {
+	return p.next("delete")
+}
+
+func (p *fakeProvider) Exists(ctx context.Context, path string) (bool, error) {
+	if err := p.next("exists"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (p *fakeProvider) GetInfo(ctx context.Context, path string) (*FileInfo, error) {
+	if err := p.next("get_info"); err != nil {
+		return nil, err
+	}
+	return &FileInfo{Path: path}, nil
+}
+
+func (p *fakeProvider) Move(ctx context.Context, srcPath, dstPath string) error {
+	return p.next("move")
+}
+
+var errTransient = NewProviderError("fake", ErrorCodeProviderError, "temporary failure", nil)
+
+func TestRetryingProvider(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:  3,
+		InitialDelay: time.Millisecond,
+		MaxDelay:     2 * time.Millisecond,
+	}
+	ctx := context.Background()
+
+	t.Run("Retries transient errors", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("get_info", errTransient, errTransient)
+
+		retries := 0
+		p := policy
+		p.OnRetry = func(ctx context.Context, operation, path string, attempt int, err error) {
+			retries++
+		}
+
+		provider := NewRetryingProvider(fake, p)
+		if _, err := provider.GetInfo(ctx, "a.txt"); err != nil {
+			t.Fatalf("GetInfo failed: %v", err)
+		}
+
+		if fake.callCount("get_info") != 3 {
+			t.Errorf("Expected 3 calls, got %d", fake.callCount("get_info"))
+		}
+
+		if retries != 2 {
+			t.Errorf("Expected 2 retries reported, got %d", retries)
+		}
+	})
+
+	t.Run("Gives up after max attempts", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("exists", errTransient, errTransient, errTransient, errTransient)
+
+		provider := NewRetryingProvider(fake, policy)
+		if _, err := provider.Exists(ctx, "a.txt"); err == nil {
+			t.Fatal("Expected error after exhausting attempts")
+		}
+
+		if fake.callCount("exists") != 3 {
+			t.Errorf("Expected 3 calls, got %d", fake.callCount("exists"))
+		}
+	})
+
+	t.Run("Does not retry permanent errors", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("download", FileNotFoundError("a.txt"))
+
+		provider := NewRetryingProvider(fake, policy)
+		if _, _, err := provider.Download(ctx, "a.txt"); err == nil {
+			t.Fatal("Expected not found error")
+		}
+
+		if fake.callCount("download") != 1 {
+			t.Errorf("Expected 1 call, got %d", fake.callCount("download"))
+		}
+	})
+
+	t.Run("Custom classification", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("get_info", FileNotFoundError("a.txt"))
+
+		p := policy
+		p.RetryableCodes = []ErrorCode{ErrorCodeFileNotFound}
+
+		provider := NewRetryingProvider(fake, p)
+		if _, err := provider.GetInfo(ctx, "a.txt"); err != nil {
+			t.Fatalf("GetInfo failed: %v", err)
+		}
+
+		if fake.callCount("get_info") != 2 {
+			t.Errorf("Expected 2 calls, got %d", fake.callCount("get_info"))
+		}
+	})
+
+	t.Run("Delete not found after retry is success", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("delete", errTransient, FileNotFoundError("a.txt"))
+
+		provider := NewRetryingProvider(fake, policy)
+		if err := provider.Delete(ctx, "a.txt"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	})
+
+	t.Run("Upload retries seekable readers", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("upload", errTransient)
+
+		provider := NewRetryingProvider(fake, policy)
+		info, err := provider.Upload(ctx, "a.txt", strings.NewReader("hello"), nil)
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+
+		if info.Size != 5 {
+			t.Errorf("Expected the retried upload to read 5 bytes, got %d", info.Size)
+		}
+
+		if fake.callCount("upload") != 2 {
+			t.Errorf("Expected 2 calls, got %d", fake.callCount("upload"))
+		}
+	})
+
+	t.Run("Upload does not retry non-seekable readers", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("upload", errTransient)
+
+		provider := NewRetryingProvider(fake, policy)
+		reader := io.MultiReader(strings.NewReader("hello"))
+		if _, err := provider.Upload(ctx, "a.txt", reader, nil); err == nil {
+			t.Fatal("Expected upload error")
+		}
+
+		if fake.callCount("upload") != 1 {
+			t.Errorf("Expected 1 call, got %d", fake.callCount("upload"))
+		}
+	})
+
+	t.Run("Move is never retried", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("move", errTransient)
+
+		provider := NewRetryingProvider(fake, policy)
+		if err := provider.Move(ctx, "a.txt", "b.txt"); err == nil {
+			t.Fatal("Expected move error")
+		}
+
+		if fake.callCount("move") != 1 {
+			t.Errorf("Expected 1 call, got %d", fake.callCount("move"))
+		}
+	})
+
+	t.Run("Stops on context cancellation", func(t *testing.T) {
+		fake := newFakeProvider()
+		fake.failWith("get_info", errTransient, errTransient)
+
+		p := policy
+		p.InitialDelay = time.Hour
+		p.MaxDelay = time.Hour
+
+		cancelCtx, cancel := context.WithCancel(ctx)
+		p.OnRetry = func(ctx context.Context, operation, path string, attempt int, err error) {
+			cancel()
+		}
+
+		provider := NewRetryingProvider(fake, p)
+		_, err := provider.GetInfo(cancelCtx, "a.txt")
+		if !errors.Is(err, errTransient) {
+			t.Errorf("Expected last provider error, got %v", err)
+		}
+	})
+}
+
+func TestRetryConfigWiring(t *testing.T) {
+	config := &StorageConfig{
+		Name:     "TestStorage",
+		Provider: "filesystem",
+		FileSystem: &FileSystemConfig{
+			BasePath: t.TempDir(),
+		},
+		Retry: &RetryPolicy{MaxAttempts: 2},
+	}
+
+	storage, err := New(config)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	if _, ok := storage.provider.(*RetryingProvider); !ok {
+		t.Fatalf("Expected retrying provider, got %T", storage.provider)
+	}
+
+	if _, ok := providerAs[*FileSystemProvider](storage.provider); !ok {
+		t.Error("Expected filesystem provider to be reachable through the decorator chain")
+	}
+}
@@ -2,11 +2,23 @@ package vsaasstorage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
-// S3Provider implements the StorageProvider interface for AWS S3
+// S3Provider implements the StorageProvider interface for AWS S3. It deliberately does not
+// implement AppendProvider: objects cannot grow in place, and emulating appends with a
+// guarded read-modify-write would rewrite the whole object on every record. Storage.Append
+// returns ErrNotSupported instead, so callers can batch records and Upload them.
 type S3Provider struct {
 	config *StorageConfig
 }
@@ -17,7 +29,9 @@ func NewS3Provider(config *StorageConfig) (*S3Provider, error) {
 		return nil, NewStorageError(ErrorCodeInvalidConfig, "s3 configuration is required")
 	}
 
-	// TODO: Initialize AWS S3 client here
+	// TODO: Initialize AWS S3 client here. Every method must pass ctx to the SDK calls and
+	// convert their errors with mapS3Error, which maps context errors to CanceledError as
+	// checked by storagetest.RunProviderTests.
 	return &S3Provider{
 		config: config,
 	}, nil
@@ -25,16 +39,211 @@ func NewS3Provider(config *StorageConfig) (*S3Provider, error) {
 
 // Upload uploads a file to S3 (placeholder implementation)
 func (p *S3Provider) Upload(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata) (*FileInfo, error) {
-	// TODO: Implement S3 upload
+	// TODO: Implement S3 upload, storing metadata.expiration() as the "expires-at" object metadata
+	// and the custom metadata converted by s3UserMetadata. metadata.contentEncoding() goes in
+	// the ContentEncoding of PutObject.
+	// TODO: Forward metadata.expectedChecksum() as the ContentMD5 and ChecksumSHA256 of
+	// PutObject (or of CompleteMultipartUpload), so S3 verifies the content on its own.
+	// S3 refuses a mismatch with BadDigest, mapped by mapS3Error.
+	// TODO: When uploading in parts, report progress as each UploadPart completes rather than as
+	// the part buffers are filled, e.g. by reading through an unwrapped *progressReader
 	return nil, NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
 }
 
 // Download downloads a file from S3 (placeholder implementation)
 func (p *S3Provider) Download(ctx context.Context, path string) (io.ReadCloser, *FileInfo, error) {
-	// TODO: Implement S3 download
+	// TODO: Implement S3 download, converting error codes with s3GetObjectError
 	return nil, nil, NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
 }
 
+// ReadRange reads part of a file from S3 (placeholder implementation)
+func (p *S3Provider) ReadRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, *FileInfo, error) {
+	// TODO: Implement with GetObject and a "bytes=offset-(offset+length-1)" Range header so seeks do not fetch the whole object
+	return nil, nil, NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
+// s3GetObjectError converts the error code of a failed GetObject. Objects in GLACIER or
+// DEEP_ARCHIVE that were not restored fail with InvalidObjectState, which is reported as
+// ErrArchived so clients know to call RestoreFromArchive instead of retrying.
+func s3GetObjectError(code, path string, cause error) *StorageError {
+	switch code {
+	case "NoSuchKey":
+		return FileNotFoundError(path)
+	case "InvalidObjectState":
+		return ArchivedError(path, cause)
+	default:
+		if mapped := mapS3Error(cause, path); mapped != nil && mapped.Code != ErrorCodeProviderError {
+			return mapped
+		}
+		return NewProviderError("s3", ErrorCodeDownloadFailed, "failed to get object", cause)
+	}
+}
+
+// s3APIError is implemented by the errors of the AWS SDK that carry an S3 error code,
+// like smithy.APIError
+type s3APIError interface {
+	ErrorCode() string
+}
+
+// mapS3Error converts an error of an S3 call into a StorageError with the code that
+// retries, alerting and handlers expect, keeping the SDK error as its Cause. Errors
+// without a known code are reported as ErrorCodeProviderError.
+func mapS3Error(err error, path string) *StorageError {
+	if err == nil {
+		return nil
+	}
+	if isContextError(err) {
+		return CanceledError(path, err)
+	}
+
+	code := ErrorCodeProviderError
+	var apiErr s3APIError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &apiErr):
+		switch apiErr.ErrorCode() {
+		case "AccessDenied", "Forbidden", "AllAccessDisabled":
+			code = ErrorCodePermissionDenied
+		case "NoSuchKey", "NotFound":
+			code = ErrorCodeFileNotFound
+		case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException":
+			code = ErrorCodeThrottled
+		case "RequestTimeout", "RequestTimeoutException":
+			code = ErrorCodeTimeout
+		case "BadDigest", "XAmzContentSHA256Mismatch":
+			// The content of an upload does not match the checksum forwarded with it
+			return UploadChecksumMismatchError(path, "rejected by S3", err)
+		case "NoSuchBucket", "InvalidBucketName", "InvalidAccessKeyId", "SignatureDoesNotMatch", "PermanentRedirect":
+			code = ErrorCodeInvalidConfig // The bucket, region or credentials are wrong
+		}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		code = ErrorCodeTimeout
+	}
+	return &StorageError{Code: code, Message: err.Error(), Provider: "s3", Path: path, Cause: err}
+}
+
+// SetStorageClass changes the storage class of an object by copying it onto itself (placeholder implementation)
+func (p *S3Provider) SetStorageClass(ctx context.Context, path, class string) error {
+	// TODO: CopyObject with the object as its own source, StorageClass: class and
+	// MetadataDirective COPY so the custom metadata survives. Objects over 5GB need
+	// UploadPartCopy instead. Archived objects fail with InvalidObjectState and must be
+	// restored first, report them with s3GetObjectError.
+	return NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
+// RestoreFromArchive restores an archived object with RestoreObject (placeholder implementation)
+func (p *S3Provider) RestoreFromArchive(ctx context.Context, path string, days int, tier string) error {
+	// TODO: RestoreObject with RestoreRequest{Days: days, GlacierJobParameters: {Tier: tier}}.
+	// A 409 RestoreAlreadyInProgress is not an error; objects that are not archived fail
+	// with InvalidObjectState and should be reported as already readable (nil).
+	return NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
+// GetRestoreStatus reads the restore state of an object from HeadObject (placeholder implementation)
+func (p *S3Provider) GetRestoreStatus(ctx context.Context, path string) (*RestoreStatus, error) {
+	// TODO: HeadObject, then s3RestoreStatus with its StorageClass, ArchiveStatus and Restore fields
+	return nil, NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
+// s3RestoreStatus builds the RestoreStatus of an object from the StorageClass,
+// x-amz-archive-status and x-amz-restore headers of HeadObject. Objects in GLACIER_IR or
+// other instant classes are readable and never archived.
+func s3RestoreStatus(storageClass, archiveStatus, restore string) (*RestoreStatus, error) {
+	switch {
+	case storageClass == "GLACIER" || storageClass == "DEEP_ARCHIVE":
+	case storageClass == "INTELLIGENT_TIERING" && archiveStatus != "":
+	default:
+		return &RestoreStatus{}, nil
+	}
+	return parseRestoreHeader(restore)
+}
+
+// TODO: Support deduplication by uploading to .blobs/<sha256> once and CopyObject-ing to each path
+
+// NativeVersioning reports whether the bucket keeps the versions of objects itself
+func (p *S3Provider) NativeVersioning() bool {
+	return p.config.S3.Versioning
+}
+
+// ListVersions lists the previous versions of an object (placeholder implementation)
+func (p *S3Provider) ListVersions(ctx context.Context, path string) ([]*FileInfo, error) {
+	// TODO: ListObjectVersions with Prefix set to the key, paging with KeyMarker and
+	// VersionIdMarker, then s3VersionInfos over its Versions and DeleteMarkers
+	return nil, NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
+// DownloadVersion downloads a previous version of an object (placeholder implementation)
+func (p *S3Provider) DownloadVersion(ctx context.Context, path, versionID string) (io.ReadCloser, *FileInfo, error) {
+	// TODO: GetObject with VersionId, converting error codes with s3GetObjectError. Delete
+	// markers answer 405 MethodNotAllowed, which should be reported as FileNotFoundError.
+	return nil, nil, NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
+// RestoreVersion copies a previous version over the current object (placeholder implementation)
+func (p *S3Provider) RestoreVersion(ctx context.Context, path, versionID string) error {
+	// TODO: CopyObject with CopySource "bucket/escapeS3Key(key)?versionId=versionID" onto the
+	// same key, which makes the copy the latest version and keeps the replaced one
+	return NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
+// DeleteVersion permanently deletes a version or delete marker (placeholder implementation)
+func (p *S3Provider) DeleteVersion(ctx context.Context, path, versionID string) error {
+	// TODO: DeleteObject with VersionId; NoSuchVersion is FileNotFoundError
+	return NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
+// s3ObjectVersion is an entry of ListObjectVersions: a version or a delete marker
+type s3ObjectVersion struct {
+	Key          string
+	VersionID    string
+	ETag         string
+	Size         int64
+	LastModified time.Time
+	IsLatest     bool
+	DeleteMarker bool
+}
+
+// s3VersionInfos converts the entries of ListObjectVersions into the previous versions
+// of path, newest first. The listing is by prefix, so entries of other keys are dropped,
+// and so is the latest version, which is the current object. Delete markers are kept,
+// flagged with DeleteMarkerMetadataKey, so callers can tell when the file was deleted.
+func (p *S3Provider) s3VersionInfos(path string, entries []s3ObjectVersion) []*FileInfo {
+	key := p.s3ObjectKey(path)
+	var versions []*FileInfo
+	for _, entry := range entries {
+		if entry.Key != key || (entry.IsLatest && !entry.DeleteMarker) {
+			continue
+		}
+
+		metadata := map[string]string{VersionIDMetadataKey: entry.VersionID}
+		etag := NormalizeETag(entry.ETag)
+		if entry.DeleteMarker {
+			metadata[DeleteMarkerMetadataKey] = "true"
+			etag = ""
+		}
+		modTime := entry.LastModified
+		versions = append(versions, &FileInfo{
+			Path:         path,
+			Name:         entry.VersionID,
+			Size:         entry.Size,
+			ETag:         etag,
+			LastModified: &modTime,
+			Metadata:     metadata,
+		})
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LastModified.After(*versions[j].LastModified)
+	})
+	return versions
+}
+
+// TODO: Implement RetentionProvider using S3 Object Lock (PutObjectRetention) when the bucket supports it
+
+// TODO: Implement ConditionalProvider with PutObject If-Match on the ETag, or If-None-Match "*"
+// when etag is empty, mapping 412 responses to PreconditionFailedError. Leases and the
+// reservation of generated upload names need it.
+
 // Delete deletes a file from S3 (placeholder implementation)
 func (p *S3Provider) Delete(ctx context.Context, path string) error {
 	// TODO: Implement S3 delete
@@ -47,21 +256,68 @@ func (p *S3Provider) Exists(ctx context.Context, path string) (bool, error) {
 	return false, NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
 }
 
+// DirectoryExists checks if at least one key has path as prefix (placeholder implementation)
+func (p *S3Provider) DirectoryExists(ctx context.Context, path string) (bool, error) {
+	// TODO: ListObjectsV2 with Prefix set to path + "/" and MaxKeys set to 1
+	return false, NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
+// CreateDirectory creates a zero-byte "<path>/" marker object (placeholder implementation)
+func (p *S3Provider) CreateDirectory(ctx context.Context, path string) error {
+	// TODO: HeadObject on path to reject files, then PutObject with key path + "/" and an empty body
+	return NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
+// CleanupOrphans aborts multipart uploads started before olderThan (placeholder implementation)
+func (p *S3Provider) CleanupOrphans(ctx context.Context, olderThan time.Duration) (*CleanupReport, error) {
+	// TODO: ListMultipartUploads and AbortMultipartUpload for every upload initiated before the cutoff
+	return nil, NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
 // GetInfo gets information about a file in S3 (placeholder implementation)
 func (p *S3Provider) GetInfo(ctx context.Context, path string) (*FileInfo, error) {
-	// TODO: Implement S3 get info
+	// TODO: Implement S3 get info. S3 returns quoted ETags, store them with NormalizeETag.
+	// The StorageClass header goes under StorageClassMetadataKey (STANDARD when absent).
+	// The x-amz-meta-* user metadata is decoded into Metadata with s3CustomMetadata.
+	// The Content-Encoding header goes in ContentEncoding.
+	return nil, NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
+// DirectorySummary counts the direct children of a directory in S3 (placeholder implementation)
+func (p *S3Provider) DirectorySummary(ctx context.Context, path string) (*DirectorySummary, error) {
+	// TODO: Implement with a single ListObjectsV2 page with Delimiter "/": Contents are the
+	// files and CommonPrefixes the directories, skipping names hidden by IsHidden, and
+	// Truncated is set from IsTruncated
 	return nil, NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
 }
 
 // List lists files in a directory in S3 (placeholder implementation)
 func (p *S3Provider) List(ctx context.Context, path string) ([]*FileInfo, error) {
-	// TODO: Implement S3 list
+	// TODO: Implement S3 list with EncodingType=url, decoding the returned keys with
+	// decodeS3ListKey. ListObjectsV2 returns the ETag and StorageClass of each object,
+	// which should be set on the entries, the class under StorageClassMetadataKey so that
+	// TransitionStorageClass skips objects already moved; custom metadata needs a
+	// HeadObject per object and is left to Storage.fillDetails, bounded by
+	// ListDetailsConcurrency.
+	return nil, NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
+// Iterate lists a directory in S3 one entry at a time (placeholder implementation)
+func (p *S3Provider) Iterate(ctx context.Context, path string) (ListIterator, error) {
+	// TODO: Implement like List, fetching the next ListObjectsV2 page with its
+	// ContinuationToken only when Next has returned every entry of the current one. Entries
+	// must come sorted by name like List: a CommonPrefix "a/" sorts after keys such as
+	// "a-b" in the listing but before them by name, so prefixes are held back until the
+	// keys that sort before their name have been returned.
 	return nil, NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
 }
 
 // DeleteDirectory deletes a directory and all its contents recursively in S3 (placeholder implementation)
 func (p *S3Provider) DeleteDirectory(ctx context.Context, path string) error {
-	// TODO: Implement S3 delete directory
+	// TODO: Implement S3 delete directory with DeleteObjects batches of up to 1000 keys,
+	// each filled from one ListObjectsV2 page (without Delimiter) as it is fetched rather
+	// than after listing the whole prefix, reporting the keys listed in the Errors of each
+	// response in a *MultiError keyed by path
 	return NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
 }
 
@@ -79,6 +335,127 @@ func (p *S3Provider) Move(ctx context.Context, srcPath, dstPath string) error {
 
 // GenerateSignedURL generates a signed URL for S3 operations (placeholder implementation)
 func (p *S3Provider) GenerateSignedURL(ctx context.Context, path string, operation SignedURLOperation, expiresIn time.Duration) (string, error) {
-	// TODO: Implement S3 signed URL generation
+	// TODO: Implement S3 signed URL generation, with the key of s3ObjectKey escaped by escapeS3Key
+	return "", NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
+}
+
+// GenerateSignedURLWithOverrides presigns a GetObject URL with response header overrides (placeholder implementation)
+func (p *S3Provider) GenerateSignedURLWithOverrides(ctx context.Context, path string, expiresIn time.Duration, overrides ResponseOverrides) (string, error) {
+	// TODO: PresignGetObject with ResponseContentDisposition and ResponseContentType set,
+	// which signs the query parameters of s3ResponseQuery into the URL
 	return "", NewStorageError(ErrorCodeProviderError, "S3 provider not yet implemented")
 }
+
+// s3ResponseQuery returns the response-* query parameters of a presigned GetObject URL
+// that override the headers S3 serves the object with
+func s3ResponseQuery(overrides ResponseOverrides) url.Values {
+	query := url.Values{}
+	if overrides.ContentDisposition != "" {
+		query.Set(responseContentDispositionClaim, overrides.ContentDisposition)
+	}
+	if overrides.ContentType != "" {
+		query.Set(responseContentTypeClaim, overrides.ContentType)
+	}
+	return query
+}
+
+// s3ObjectKey returns the object key of a storage path. Keys are the canonical path as is,
+// never percent-encoded, so "a//b.txt" and "/a/b.txt" are the object "a/b.txt" and a file
+// named "100%+done #1.mp4" keeps that exact name in the bucket. Every request addresses
+// objects through it.
+func (p *S3Provider) s3ObjectKey(path string) string {
+	key := canonicalize(path)
+	if p.config.PathNormalization != PathNormalizationNone {
+		key = normalizeNFC(key)
+	}
+	return key
+}
+
+// decodeS3ListKey decodes a key returned by ListObjectsV2. Listings request
+// EncodingType=url so keys with characters invalid in XML survive the response; S3 then
+// form-encodes every key, with "+" standing for a space.
+func decodeS3ListKey(key, encodingType string) (string, error) {
+	if encodingType != "url" {
+		return key, nil
+	}
+	decoded, err := url.QueryUnescape(key)
+	if err != nil {
+		return "", NewStorageErrorWithCause(ErrorCodeListFailed, "invalid key in listing: "+key, err)
+	}
+	return decoded, nil
+}
+
+// escapeS3Key escapes an object key for the path of a request or presigned URL the way
+// SigV4 canonical URIs do: every byte but unreserved characters and "/" is
+// percent-encoded, so "+" is not read as a space and "#" does not start a fragment
+func escapeS3Key(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3MetadataLimit is the size of the user metadata S3 accepts for an object, counting the
+// bytes of every key and value
+const s3MetadataLimit = 2048
+
+// s3UserMetadata converts custom metadata into the user metadata of PutObject. Values that
+// are not plain ASCII are RFC 2047 encoded, the way S3 returns them. Over the 2KB limit the
+// original name recorded by uploads, whose length the client chooses, is shortened before
+// its extension; metadata that still does not fit fails with ErrorCodeUploadFailed.
+func s3UserMetadata(filePath string, custom map[string]string) (map[string]string, error) {
+	metadata := make(map[string]string, len(custom))
+	size := 0
+	for key, value := range custom {
+		metadata[key] = encodeS3MetadataValue(value)
+		size += len(key) + len(metadata[key])
+	}
+
+	if name, ok := custom[OriginalNameMetadataKey]; ok && size > s3MetadataLimit {
+		size -= len(metadata[OriginalNameMetadataKey])
+		ext := path.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		for base != "" && size+len(encodeS3MetadataValue(base+ext)) > s3MetadataLimit {
+			_, n := utf8.DecodeLastRuneInString(base)
+			base = base[:len(base)-n]
+		}
+		metadata[OriginalNameMetadataKey] = encodeS3MetadataValue(base + ext)
+		size += len(metadata[OriginalNameMetadataKey])
+	}
+	if size > s3MetadataLimit {
+		return nil, NewStorageErrorWithPath(ErrorCodeUploadFailed, fmt.Sprintf("metadata takes %d bytes, S3 accepts up to %d", size, s3MetadataLimit), filePath)
+	}
+	return metadata, nil
+}
+
+// encodeS3MetadataValue RFC 2047 encodes a metadata value that cannot be sent as is in an
+// HTTP header, or that would be mistaken for an encoded one
+func encodeS3MetadataValue(value string) string {
+	unsafe := strings.IndexFunc(value, func(r rune) bool { return r < ' ' || r > '~' }) >= 0
+	if unsafe || strings.HasPrefix(value, "=?") {
+		return mime.QEncoding.Encode("utf-8", value)
+	}
+	return value
+}
+
+// s3CustomMetadata decodes the user metadata of an object as stored by s3UserMetadata
+func s3CustomMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	var decoder mime.WordDecoder
+	custom := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		if decoded, err := decoder.DecodeHeader(value); err == nil {
+			value = decoded
+		}
+		custom[strings.ToLower(key)] = value
+	}
+	return custom
+}
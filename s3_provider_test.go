@@ -0,0 +1,206 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestS3Keys(t *testing.T) {
+	provider, err := NewS3Provider(&StorageConfig{Name: "test", Provider: "s3", S3: &S3Config{Bucket: "media"}})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	tests := []struct {
+		path, key, escaped string
+	}{
+		{"100%+done #1.mp4", "100%+done #1.mp4", "100%25%2Bdone%20%231.mp4"},
+		{"/clips//a b.mp4", "clips/a b.mp4", "clips/a%20b.mp4"},
+		{"café/~x.mp4", "café/~x.mp4", "caf%C3%A9/~x.mp4"},
+		{"a&b=c?.txt", "a&b=c?.txt", "a%26b%3Dc%3F.txt"},
+	}
+	for _, tt := range tests {
+		key := provider.s3ObjectKey(tt.path)
+		if key != tt.key {
+			t.Errorf("Path %q: expected key %q, got %q", tt.path, tt.key, key)
+		}
+		if escaped := escapeS3Key(key); escaped != tt.escaped {
+			t.Errorf("Key %q: expected %q, got %q", key, tt.escaped, escaped)
+		}
+	}
+
+	listed := []struct {
+		raw, encoding, key string
+	}{
+		{"100%25%2Bdone+%231.mp4", "url", "100%+done #1.mp4"},
+		{"caf%C3%A9%2Fa.mp4", "url", "café/a.mp4"},
+		{"100%+done #1.mp4", "", "100%+done #1.mp4"},
+	}
+	for _, tt := range listed {
+		if key, err := decodeS3ListKey(tt.raw, tt.encoding); err != nil || key != tt.key {
+			t.Errorf("Listed %q: expected %q, got %q, %v", tt.raw, tt.key, key, err)
+		}
+	}
+	if _, err := decodeS3ListKey("bad%zz", "url"); err == nil {
+		t.Error("Expected an invalid escape to fail")
+	}
+}
+
+func TestS3RestoreStatus(t *testing.T) {
+	tests := []struct {
+		class, archiveStatus, restore string
+		archived, readable            bool
+	}{
+		{"STANDARD", "", "", false, true},
+		{"GLACIER_IR", "", "", false, true},
+		{"GLACIER", "", "", true, false},
+		{"DEEP_ARCHIVE", "", `ongoing-request="true"`, true, false},
+		{"GLACIER", "", `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`, true, true},
+		{"INTELLIGENT_TIERING", "", "", false, true},
+		{"INTELLIGENT_TIERING", "ARCHIVE_ACCESS", "", true, false},
+	}
+	for _, tt := range tests {
+		status, err := s3RestoreStatus(tt.class, tt.archiveStatus, tt.restore)
+		if err != nil || status.Archived != tt.archived || status.Readable() != tt.readable {
+			t.Errorf("Class %s %s %q: unexpected status %+v, %v", tt.class, tt.archiveStatus, tt.restore, status, err)
+		}
+	}
+
+	if err := s3GetObjectError("InvalidObjectState", "clips/old.mp4", nil); !errors.Is(err, ErrArchived) || err.HTTPStatus() != http.StatusConflict {
+		t.Errorf("Expected InvalidObjectState to be ErrArchived, got %v", err)
+	}
+	if err := s3GetObjectError("NoSuchKey", "clips/old.mp4", nil); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected NoSuchKey to be ErrFileNotFound, got %v", err)
+	}
+}
+
+// s3CodeError is an SDK error carrying an S3 error code, like smithy.GenericAPIError
+type s3CodeError string
+
+func (e s3CodeError) Error() string     { return "api error " + string(e) }
+func (e s3CodeError) ErrorCode() string { return string(e) }
+
+// timeoutError is a network error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestMapS3Error(t *testing.T) {
+	tests := []struct {
+		err      error
+		sentinel error
+		status   int
+	}{
+		{s3CodeError("AccessDenied"), ErrPermissionDenied, http.StatusForbidden},
+		{s3CodeError("NoSuchKey"), ErrFileNotFound, http.StatusNotFound},
+		{s3CodeError("SlowDown"), ErrThrottled, http.StatusServiceUnavailable},
+		{s3CodeError("ThrottlingException"), ErrThrottled, http.StatusServiceUnavailable},
+		{s3CodeError("RequestTimeout"), ErrTimeout, http.StatusGatewayTimeout},
+		{s3CodeError("BadDigest"), ErrChecksumMismatch, http.StatusUnprocessableEntity},
+		{&url.Error{Op: "Put", URL: "https://media.s3.amazonaws.com/a.mp4", Err: timeoutError{}}, ErrTimeout, http.StatusGatewayTimeout},
+		{s3CodeError("NoSuchBucket"), &StorageError{Code: ErrorCodeInvalidConfig}, http.StatusInternalServerError},
+		{s3CodeError("InternalError"), ErrProviderError, http.StatusBadGateway},
+		{fmt.Errorf("operation error S3: PutObject: %w", context.DeadlineExceeded), ErrCanceled, http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		wrapped := fmt.Errorf("operation error S3: GetObject: %w", tt.err)
+		mapped := mapS3Error(wrapped, "clips/a.mp4")
+		if !errors.Is(mapped, tt.sentinel) || mapped.HTTPStatus() != tt.status {
+			t.Errorf("%v: expected %v with status %d, got %v", tt.err, tt.sentinel, tt.status, mapped)
+		}
+		if !errors.Is(mapped, tt.err) || mapped.Path != "clips/a.mp4" {
+			t.Errorf("%v: expected the SDK error kept as cause, got %v", tt.err, mapped.Cause)
+		}
+	}
+
+	if mapped := mapS3Error(nil, "clips/a.mp4"); mapped != nil {
+		t.Errorf("Expected no error, got %v", mapped)
+	}
+	if err := s3GetObjectError("SlowDown", "clips/a.mp4", s3CodeError("SlowDown")); !errors.Is(err, ErrThrottled) {
+		t.Errorf("Expected a throttled GetObject to be ErrThrottled, got %v", err)
+	}
+	if !(RetryPolicy{}).withDefaults().retryable(mapS3Error(s3CodeError("SlowDown"), "clips/a.mp4")) {
+		t.Error("Expected throttling to be retried")
+	}
+}
+
+func TestS3UserMetadata(t *testing.T) {
+	custom := map[string]string{
+		OriginalNameMetadataKey: "Cámara 1 — entrada.mp4",
+		FieldNameMetadataKey:    "video",
+		"camera":                "=?not-encoded?=",
+	}
+	metadata, err := s3UserMetadata("clips/a.mp4", custom)
+	if err != nil {
+		t.Fatalf("s3UserMetadata failed: %v", err)
+	}
+	if metadata[FieldNameMetadataKey] != "video" || !strings.HasPrefix(metadata[OriginalNameMetadataKey], "=?utf-8?q?") {
+		t.Errorf("Expected only the values that are not ASCII encoded, got %v", metadata)
+	}
+	if decoded := s3CustomMetadata(metadata); !reflect.DeepEqual(decoded, custom) {
+		t.Errorf("Expected the metadata to round-trip, got %v", decoded)
+	}
+
+	long := map[string]string{OriginalNameMetadataKey: strings.Repeat("é", 2000) + ".mp4", UploadedByMetadataKey: "user-42"}
+	metadata, err = s3UserMetadata("clips/a.mp4", long)
+	if err != nil {
+		t.Fatalf("Expected a long original name to be shortened, got %v", err)
+	}
+	size := 0
+	for key, value := range metadata {
+		size += len(key) + len(value)
+	}
+	name := s3CustomMetadata(metadata)[OriginalNameMetadataKey]
+	if size > s3MetadataLimit || !strings.HasSuffix(name, "é.mp4") || metadata[UploadedByMetadataKey] != "user-42" {
+		t.Errorf("Expected the name shortened to %d bytes of metadata, got %d bytes and %q", s3MetadataLimit, size, name)
+	}
+
+	if _, err := s3UserMetadata("clips/a.mp4", map[string]string{"notes": strings.Repeat("x", s3MetadataLimit)}); !errors.Is(err, &StorageError{Code: ErrorCodeUploadFailed}) {
+		t.Errorf("Expected metadata over the limit to be refused, got %v", err)
+	}
+}
+
+func TestS3VersionInfos(t *testing.T) {
+	provider, err := NewS3Provider(&StorageConfig{Name: "test", Provider: "s3", S3: &S3Config{Bucket: "media", Versioning: true}})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if !provider.NativeVersioning() {
+		t.Error("Expected native versioning on a versioned bucket")
+	}
+
+	at := func(minute int) time.Time { return time.Date(2024, 6, 12, 10, minute, 0, 0, time.UTC) }
+	entries := []s3ObjectVersion{
+		{Key: "plans/a.json", VersionID: "v1", ETag: `"e1"`, Size: 2, LastModified: at(1)},
+		{Key: "plans/a.json", VersionID: "v3", ETag: `"e3"`, Size: 2, LastModified: at(3), IsLatest: true},
+		{Key: "plans/a.json", VersionID: "v2", ETag: `"e2"`, Size: 2, LastModified: at(2)},
+		{Key: "plans/a.json.bak", VersionID: "b1", ETag: `"b1"`, Size: 2, LastModified: at(4), IsLatest: true},
+	}
+	versions := provider.s3VersionInfos("/plans//a.json", entries)
+	if len(versions) != 2 || versions[0].Name != "v2" || versions[1].Name != "v1" {
+		t.Fatalf("Expected the previous versions v2 and v1, got %+v", versions)
+	}
+	if versions[0].Metadata[VersionIDMetadataKey] != "v2" || versions[0].ETag != "e2" {
+		t.Errorf("Unexpected version %+v", versions[0])
+	}
+
+	// Once deleted, the delete marker is the newest entry and every version is previous
+	entries[1].IsLatest = false
+	entries = append(entries, s3ObjectVersion{Key: "plans/a.json", VersionID: "d1", LastModified: at(5), IsLatest: true, DeleteMarker: true})
+	versions = provider.s3VersionInfos("plans/a.json", entries)
+	if len(versions) != 4 || versions[0].Name != "d1" || !isDeleteMarker(versions[0]) || versions[0].ETag != "" {
+		t.Fatalf("Expected the delete marker first, got %+v", versions)
+	}
+	if isDeleteMarker(versions[1]) || versions[1].Name != "v3" {
+		t.Errorf("Expected v3 after the delete marker, got %+v", versions[1])
+	}
+}
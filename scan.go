@@ -0,0 +1,180 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+// quarantinePrefix is the directory that holds uploads while they are being scanned
+const quarantinePrefix = ".quarantine"
+
+// Scanner inspects uploaded content before it is stored under its final path
+type Scanner interface {
+	// Scan reads the content and returns nil if it is clean. Content that must be refused
+	// is reported with ContentRejectedError; any other error means the scan could not be
+	// completed, and the upload is refused as well.
+	Scan(ctx context.Context, reader io.Reader, info *ScanInfo) error
+}
+
+// ScanInfo describes the upload being scanned
+type ScanInfo struct {
+	Path         string // Final path of the upload
+	OriginalName string // Name sent by the client
+	ContentType  string
+	Size         int64
+}
+
+// isQuarantinePath reports whether the path is inside the quarantine area
+func isQuarantinePath(p string) bool {
+	clean := cleanPath(p)
+	return clean == quarantinePrefix || strings.HasPrefix(clean, quarantinePrefix+"/")
+}
+
+// uploadScanned uploads content into the quarantine while the scanner reads it, and moves
+// it to filePath only if the scan passes. Rejected uploads are deleted.
+func (s *Storage) uploadScanned(ctx context.Context, filePath string, reader io.Reader, metadata *FileMetadata, info *ScanInfo) (*FileInfo, error) {
+	ctx, release, err := s.reserveReaderMemory(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	reader, verifier := newChecksumReader(reader, filePath, metadata)
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	quarantinePath := path.Join(quarantinePrefix, fmt.Sprintf("%x", id), path.Base(filePath))
+
+	pipeReader, pipeWriter := io.Pipe()
+	scanned := make(chan error, 1)
+	go func() {
+		err := s.config.Scanner.Scan(ctx, pipeReader, info)
+		// Keep the upload flowing if the scanner stopped reading early
+		io.Copy(io.Discard, pipeReader)
+		scanned <- err
+	}()
+
+	_, uploadErr := s.provider.Upload(ctx, quarantinePath, io.TeeReader(reader, pipeWriter), metadata)
+	pipeWriter.CloseWithError(uploadErr)
+	scanErr := <-scanned
+
+	if uploadErr != nil || scanErr != nil {
+		s.provider.DeleteDirectory(ctx, path.Dir(quarantinePath))
+	}
+	if uploadErr != nil {
+		return nil, verifier.failure(uploadErr)
+	}
+	if scanErr != nil {
+		if errors.Is(scanErr, ErrContentRejected) {
+			return nil, scanErr
+		}
+		return nil, NewStorageErrorWithCause(ErrorCodeInternalError, "content scan failed", scanErr)
+	}
+
+	// The scanned content replaces filePath like a regular upload would
+	if s.versioningEnabled() && !isInternalPath(filePath) {
+		if _, err := s.saveVersion(ctx, filePath); err != nil {
+			s.provider.DeleteDirectory(ctx, path.Dir(quarantinePath))
+			return nil, err
+		}
+	}
+
+	err = s.Move(ctx, quarantinePath, filePath)
+	s.provider.DeleteDirectory(ctx, path.Dir(quarantinePath))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.provider.GetInfo(ctx, filePath)
+}
+
+// ClamdScanner scans content with a clamd daemon over TCP using the INSTREAM command
+type ClamdScanner struct {
+	Address   string        // host:port of clamd, usually port 3310
+	Timeout   time.Duration // Limit for the whole scan, defaults to one minute
+	ChunkSize int           // Bytes per INSTREAM chunk, defaults to 64KB
+}
+
+// NewClamdScanner creates a scanner for the clamd daemon listening at address
+func NewClamdScanner(address string) *ClamdScanner {
+	return &ClamdScanner{Address: address}
+}
+
+// Scan streams the content to clamd and parses its verdict. clamd refuses streams larger
+// than its StreamMaxLength setting, which is reported as a scan failure.
+func (c *ClamdScanner) Scan(ctx context.Context, reader io.Reader, info *ScanInfo) error {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	chunkSize := c.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 64 << 10
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, readErr := io.ReadFull(reader, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	// A zero-length chunk ends the stream
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return err
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")), info)
+}
+
+// parseClamdReply turns a clamd verdict such as "stream: Eicar-Signature FOUND" into an error
+func parseClamdReply(reply string, info *ScanInfo) error {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return ContentRejectedError(info.Path, strings.TrimSuffix(result, " FOUND"))
+	default:
+		return fmt.Errorf("clamd: %s", reply)
+	}
+}
@@ -0,0 +1,195 @@
+package vsaasstorage
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// keywordScanner rejects content containing a keyword. With early set it rejects
+// without reading, like a scanner that refuses a file by its name.
+type keywordScanner struct {
+	keyword string
+	early   bool
+	err     error
+}
+
+func (s keywordScanner) Scan(ctx context.Context, reader io.Reader, info *ScanInfo) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.early {
+		return ContentRejectedError(info.Path, "blocked name")
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(string(content), s.keyword) {
+		return ContentRejectedError(info.Path, "found "+s.keyword)
+	}
+	return nil
+}
+
+func TestUploadScanning(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeUpload := func(content string) *rest.UploadedFile {
+		file := filepath.Join(dir, "upload.tmp")
+		os.WriteFile(file, []byte(content), 0644)
+		return &rest.UploadedFile{Path: file, Filename: "clip.mp4", OriginalName: "clip.mp4", MimeType: "video/mp4"}
+	}
+
+	for _, provider := range []string{"filesystem", "memory"} {
+		t.Run(provider, func(t *testing.T) {
+			config := &StorageConfig{Name: "ScannedStorage", Provider: provider, Scanner: keywordScanner{keyword: "virus"}}
+			if provider == "filesystem" {
+				config.FileSystem = &FileSystemConfig{BasePath: t.TempDir()}
+			}
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			result, err := storage.UploadFromUploadedFile(ctx, writeUpload("clean video"), "file", "uploads", "clean")
+			if err != nil {
+				t.Fatalf("Expected a clean upload to pass, got %v", err)
+			}
+			reader, _, err := storage.Download(ctx, result.Path)
+			if err != nil {
+				t.Fatalf("Download failed: %v", err)
+			}
+			content, _ := io.ReadAll(reader)
+			reader.Close()
+			if string(content) != "clean video" || result.Size != int64(len("clean video")) {
+				t.Errorf("Unexpected stored content '%s' (%d bytes)", content, result.Size)
+			}
+
+			_, err = storage.UploadFromUploadedFile(ctx, writeUpload("a virus inside"), "file", "uploads", "infected")
+			if !errors.Is(err, ErrContentRejected) || !strings.Contains(err.Error(), "found virus") {
+				t.Fatalf("Expected ErrContentRejected with the scanner reason, got %v", err)
+			}
+			if exists, _ := storage.Exists(ctx, "uploads/infected.tmp"); exists {
+				t.Error("Expected the rejected upload not to be stored")
+			}
+
+			var httpErr *echo.HTTPError
+			if !errors.As(httpError(err, "Failed to upload files"), &httpErr) || httpErr.Code != http.StatusUnprocessableEntity {
+				t.Errorf("Expected a 422 HTTP error, got %v", httpErr)
+			}
+
+			if exists, _ := storage.DirectoryExists(ctx, quarantinePrefix); exists {
+				files, _ := storage.provider.List(ctx, quarantinePrefix)
+				if len(files) > 0 {
+					t.Errorf("Expected the quarantine to be empty, got %d entries", len(files))
+				}
+			}
+			root, _ := storage.List(ctx, "")
+			for _, file := range root {
+				if file.Name == quarantinePrefix {
+					t.Error("Expected the quarantine to be hidden from listings")
+				}
+			}
+		})
+	}
+
+	t.Run("Scanner stopping early", func(t *testing.T) {
+		storage, _ := New(&StorageConfig{Name: "ScannedStorage", Provider: "memory", Scanner: keywordScanner{early: true}})
+		large := strings.Repeat("x", 4<<20)
+		if _, err := storage.UploadFromUploadedFile(ctx, writeUpload(large), "file", "uploads"); !errors.Is(err, ErrContentRejected) {
+			t.Errorf("Expected ErrContentRejected, got %v", err)
+		}
+	})
+
+	t.Run("Scanner failure", func(t *testing.T) {
+		storage, _ := New(&StorageConfig{Name: "ScannedStorage", Provider: "memory", Scanner: keywordScanner{err: errors.New("clamd unavailable")}})
+		_, err := storage.UploadFromUploadedFile(ctx, writeUpload("clean"), "file", "uploads")
+		if err == nil || errors.Is(err, ErrContentRejected) {
+			t.Errorf("Expected the upload to fail closed, got %v", err)
+		}
+	})
+}
+
+// fakeClamd accepts one INSTREAM session and replies FOUND if the stream contains "virus"
+func fakeClamd(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				if command, _ := reader.ReadString(0); command != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+
+				var content []byte
+				for {
+					var size uint32
+					if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					chunk := make([]byte, size)
+					io.ReadFull(reader, chunk)
+					content = append(content, chunk...)
+				}
+
+				if strings.Contains(string(content), "virus") {
+					conn.Write([]byte("stream: Test-Virus FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestClamdScanner(t *testing.T) {
+	ctx := context.Background()
+	scanner := NewClamdScanner(fakeClamd(t))
+	scanner.ChunkSize = 4 // Split the content across several chunks
+
+	if err := scanner.Scan(ctx, strings.NewReader("clean content"), &ScanInfo{Path: "a.txt"}); err != nil {
+		t.Errorf("Expected clean content to pass, got %v", err)
+	}
+
+	err := scanner.Scan(ctx, strings.NewReader("some virus here"), &ScanInfo{Path: "b.txt"})
+	if !errors.Is(err, ErrContentRejected) || !strings.Contains(err.Error(), "Test-Virus") {
+		t.Errorf("Expected the clamd signature in a rejection, got %v", err)
+	}
+
+	if err := parseClamdReply("INSTREAM size limit exceeded. ERROR", &ScanInfo{}); err == nil || errors.Is(err, ErrContentRejected) {
+		t.Errorf("Expected a clamd error to be a scan failure, got %v", err)
+	}
+
+	unreachable := NewClamdScanner("127.0.0.1:1")
+	if err := unreachable.Scan(ctx, strings.NewReader("x"), &ScanInfo{}); err == nil {
+		t.Error("Expected an error when clamd is unreachable")
+	}
+}
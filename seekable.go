@@ -0,0 +1,104 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+)
+
+// DefaultSeekableMemoryThreshold is the size up to which OpenSeekable keeps a download
+// in memory when StorageConfig.SeekableMemoryThreshold is not set
+const DefaultSeekableMemoryThreshold = 8 << 20
+
+// OpenSeekable opens a file for readers that need to seek, like MP4 parsers
+// or http.ServeContent. Local files are returned as *os.File and providers with range
+// reads are read lazily, fetching from the offset of each Read after a Seek (see
+// Capabilities.NativeSeeking). Otherwise the file is downloaded whole, into memory up to
+// SeekableMemoryThreshold and into a temporary file removed on Close beyond it.
+func (s *Storage) OpenSeekable(ctx context.Context, path string) (io.ReadSeekCloser, *FileInfo, error) {
+	info, err := s.GetInfo(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDirectory {
+		return nil, nil, NewStorageErrorWithPath(ErrorCodeIsDirectory, "path is a directory", path)
+	}
+
+	if s.Capabilities().NativeSeeking {
+		content, err := s.openContent(ctx, path, info)
+		if err != nil {
+			return nil, nil, err
+		}
+		return content, info, nil
+	}
+
+	reader, info, err := s.Download(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if seeker, ok := reader.(io.ReadSeekCloser); ok {
+		return seeker, info, nil
+	}
+	defer reader.Close()
+
+	content, err := s.spillContent(ctx, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, info, nil
+}
+
+// spillContent reads a download whole into a seekable reader, in memory when it fits
+// within SeekableMemoryThreshold and in a temporary file otherwise
+func (s *Storage) spillContent(ctx context.Context, reader io.Reader) (io.ReadSeekCloser, error) {
+	threshold := s.config.GetSeekableMemoryThreshold()
+	var head bytes.Buffer
+	if _, err := copyContext(ctx, &head, io.LimitReader(reader, threshold+1), s.config.GetCopyBufferSize()); err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to read file", err)
+	}
+	if int64(head.Len()) <= threshold {
+		return bytesReadSeekCloser{bytes.NewReader(head.Bytes())}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "vsaas-seekable-*")
+	if err != nil {
+		return nil, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to create temporary file", err)
+	}
+	spilled := &tempFile{File: tmp}
+	if _, err = tmp.Write(head.Bytes()); err == nil {
+		_, err = copyContext(ctx, tmp, reader, s.config.GetCopyBufferSize())
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spilled.Close()
+		return nil, NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to spill file", err)
+	}
+	return spilled, nil
+}
+
+// bytesReadSeekCloser is a file read into memory
+type bytesReadSeekCloser struct {
+	*bytes.Reader
+}
+
+// Close does nothing
+func (bytesReadSeekCloser) Close() error {
+	return nil
+}
+
+// tempFile is a temporary file removed when closed
+type tempFile struct {
+	*os.File
+}
+
+// Close closes and removes the file
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	if removeErr := os.Remove(f.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
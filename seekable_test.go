@@ -0,0 +1,99 @@
+package vsaasstorage
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// plainProvider hides the range reads and local files of the provider it wraps
+type plainProvider struct {
+	StorageProvider
+}
+
+func TestOpenSeekable(t *testing.T) {
+	ctx := context.Background()
+	RegisterProvider("plain", func(config *StorageConfig) (StorageProvider, error) {
+		inner, err := NewMemoryProvider(config)
+		return &plainProvider{inner}, err
+	})
+
+	content := strings.Repeat("0123456789", 10)
+	for _, config := range []*StorageConfig{
+		{Name: "filesystem", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir(), CreateDirs: true}},
+		{Name: "memory", Provider: "memory"},
+		{Name: "in-memory", Provider: "plain"},
+		{Name: "spilled", Provider: "plain", SeekableMemoryThreshold: 16},
+	} {
+		t.Run(config.Name, func(t *testing.T) {
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			storage.Upload(ctx, "clips/a.mp4", strings.NewReader(content), nil)
+			if native := storage.Capabilities().NativeSeeking; native != (config.Provider != "plain") {
+				t.Errorf("expected NativeSeeking %v", !native)
+			}
+
+			file, info, err := storage.OpenSeekable(ctx, "clips/a.mp4")
+			if err != nil {
+				t.Fatalf("OpenSeekable failed: %v", err)
+			}
+			if info.Size != int64(len(content)) {
+				t.Errorf("expected size %d, got %d", len(content), info.Size)
+			}
+
+			read := func(whence int, offset int64, n int) string {
+				t.Helper()
+				if _, err := file.Seek(offset, whence); err != nil {
+					t.Fatalf("Seek failed: %v", err)
+				}
+				data := make([]byte, n)
+				n, err := io.ReadFull(file, data)
+				if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+					t.Fatalf("Read failed: %v", err)
+				}
+				return string(data[:n])
+			}
+			if got := read(io.SeekStart, 50, 5); got != "01234" {
+				t.Errorf("expected 01234 at 50, got %q", got)
+			}
+			if got := read(io.SeekCurrent, -53, 4); got != "2345" {
+				t.Errorf("expected 2345 after seeking backwards, got %q", got)
+			}
+			if got := read(io.SeekCurrent, 10, 3); got != "678" {
+				t.Errorf("expected 678 after a short forward seek, got %q", got)
+			}
+			if got := read(io.SeekEnd, -2, 10); got != "89" {
+				t.Errorf("expected the last 2 bytes, got %q", got)
+			}
+			if got := read(io.SeekEnd, 0, 1); got != "" {
+				t.Errorf("expected nothing at EOF, got %q", got)
+			}
+
+			spilled, _ := file.(*tempFile)
+			if err := file.Close(); err != nil {
+				t.Errorf("Close failed: %v", err)
+			}
+			if (spilled != nil) != (config.Name == "spilled") {
+				t.Errorf("unexpected reader %T", file)
+			}
+			if spilled != nil {
+				if _, err := os.Stat(spilled.Name()); !os.IsNotExist(err) {
+					t.Errorf("expected the temporary file to be removed, got %v", err)
+				}
+			}
+		})
+	}
+
+	storage, _ := New(&StorageConfig{Name: "test", Provider: "memory"})
+	storage.CreateDirectory(ctx, "clips")
+	if _, _, err := storage.OpenSeekable(ctx, "clips"); err == nil {
+		t.Error("expected a directory to be refused")
+	}
+	if _, _, err := storage.OpenSeekable(ctx, "missing.mp4"); err == nil {
+		t.Error("expected a missing file to fail")
+	}
+}
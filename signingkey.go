@@ -0,0 +1,56 @@
+package vsaasstorage
+
+import (
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey returns the secret of the named key of Keys with the key itself, or
+// SecretKey and a nil key, which is not restricted, when name is empty
+func (c *SignedURLConfig) signingKey(name string) (string, *SigningKey, error) {
+	if name == "" {
+		if c.SecretKey == "" {
+			return "", nil, NewStorageError(ErrorCodeSignedURLFailed, "secret key is required for signed URLs")
+		}
+		return c.SecretKey, nil, nil
+	}
+	for i := range c.Keys {
+		if c.Keys[i].Name == name {
+			return c.Keys[i].Secret, &c.Keys[i], nil
+		}
+	}
+	return "", nil, NewStorageError(ErrorCodeSignedURLFailed, "unknown signing key: "+name)
+}
+
+// tokenInScope reports whether the operation and the path or prefix of a token are
+// within those of the key that signs it. Tokens of SecretKey have no restrictions.
+func (p *FileSystemProvider) tokenInScope(key *SigningKey, claims jwt.MapClaims) bool {
+	if key == nil {
+		return true
+	}
+	operation, _ := claims["op"].(string)
+	if !slices.Contains(key.Operations, SignedURLOperation(operation)) {
+		return false
+	}
+	if key.PathPrefix == "" {
+		return true
+	}
+	path, ok := claims["prefix"].(string)
+	if !ok {
+		if path, ok = claims["path"].(string); !ok {
+			return false
+		}
+	}
+	return isWithinPrefix(p.config.tokenPath(path), p.config.tokenPath(key.PathPrefix))
+}
+
+// tokenKeyName returns the kid header of a token, the name of the key that signed it
+func tokenKeyName(token string) string {
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return ""
+	}
+	name, _ := parsed.Header["kid"].(string)
+	return name
+}
@@ -0,0 +1,133 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSigningKeys(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{
+		Name:       "test",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+		SignedURL: &SignedURLConfig{Enabled: true, SecretKey: "secret", Keys: []SigningKey{
+			{Name: "viewer", Secret: "viewer-secret", Operations: []SignedURLOperation{SignedURLOperationGet}},
+			{Name: "ingest", Secret: "ingest-secret", Operations: []SignedURLOperation{SignedURLOperationPut}, PathPrefix: "incoming"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	provider, _ := providerAs[*FileSystemProvider](storage.provider)
+	sign := func(path string, operation SignedURLOperation, key string) (string, error) {
+		return storage.GenerateSignedURLWithOptions(ctx, path, operation, SignedURLOptions{ExpiresIn: time.Minute, KeyName: key})
+	}
+
+	viewer, err := sign("cameras/1/clip.mp4", SignedURLOperationGet, "viewer")
+	if err != nil {
+		t.Fatalf("Failed to sign with the viewer key: %v", err)
+	}
+	if err := provider.ValidateSignedToken(viewer, "cameras/1/clip.mp4", SignedURLOperationGet); err != nil {
+		t.Errorf("expected the viewer token to be valid: %v", err)
+	}
+	if info, _ := storage.InspectToken(viewer); info.KeyName != "viewer" {
+		t.Errorf("expected the kid of the viewer key, got %q", info.KeyName)
+	}
+	ingest, err := sign("/incoming/cam1/clip.mp4", SignedURLOperationPut, "ingest")
+	if err != nil {
+		t.Fatalf("Failed to sign with the ingest key: %v", err)
+	}
+	if err := provider.ValidateSignedToken(ingest, "incoming/cam1/clip.mp4", SignedURLOperationPut); err != nil {
+		t.Errorf("expected the ingest token to be valid: %v", err)
+	}
+
+	// Keys refuse to sign outside their scope
+	for _, c := range []struct {
+		path      string
+		operation SignedURLOperation
+		key       string
+	}{
+		{"cameras/1/clip.mp4", SignedURLOperationPut, "viewer"},
+		{"incoming/cam1/clip.mp4", SignedURLOperationGet, "ingest"},
+		{"cameras/1/clip.mp4", SignedURLOperationPut, "ingest"},
+		{"incoming-old/clip.mp4", SignedURLOperationPut, "ingest"},
+	} {
+		if _, err := sign(c.path, c.operation, c.key); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%s %s with %s: expected ErrPermissionDenied, got %v", c.operation, c.path, c.key, err)
+		}
+	}
+	if _, err := sign("cameras/1/clip.mp4", SignedURLOperationGet, "missing"); err == nil {
+		t.Error("expected an unknown key to fail")
+	}
+
+	// A leaked key cannot mint tokens beyond its scope, even by writing the claims itself
+	forge := func(claims jwt.MapClaims, kid, secret string) string {
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, _ := token.SignedString([]byte(secret))
+		return signed
+	}
+	forged := forge(jwt.MapClaims{"path": "cameras/1/clip.mp4", "op": "DELETE"}, "viewer", "viewer-secret")
+	var failure TokenFailure
+	if err := provider.ValidateSignedToken(forged, "cameras/1/clip.mp4", SignedURLOperationDelete); !errors.As(err, &failure) || failure != TokenFailureKeyScope {
+		t.Errorf("expected a key scope failure, got %v", err)
+	}
+	forged = forge(jwt.MapClaims{"prefix": "", "op": "PUT"}, "ingest", "ingest-secret")
+	if err := provider.ValidateSignedToken(forged, "cameras/1/clip.mp4", SignedURLOperationPut); !errors.As(err, &failure) || failure != TokenFailureKeyScope {
+		t.Errorf("expected a key scope failure for a root prefix token, got %v", err)
+	}
+
+	// The kid selects the secret: another key's secret or an unknown kid is a bad signature
+	for _, forged := range []string{
+		forge(jwt.MapClaims{"path": "cameras/1/clip.mp4", "op": "GET"}, "viewer", "ingest-secret"),
+		forge(jwt.MapClaims{"path": "cameras/1/clip.mp4", "op": "GET"}, "admin", "secret"),
+		forge(jwt.MapClaims{"path": "cameras/1/clip.mp4", "op": "GET"}, "", "viewer-secret"),
+	} {
+		if err := provider.ValidateSignedToken(forged, "cameras/1/clip.mp4", SignedURLOperationGet); !errors.As(err, &failure) || failure != TokenFailureSignature {
+			t.Errorf("expected a signature failure, got %v", err)
+		}
+	}
+
+	// SecretKey keeps signing every operation
+	if _, err := sign("cameras/1/clip.mp4", SignedURLOperationDelete, ""); err != nil {
+		t.Errorf("expected SecretKey to sign any operation: %v", err)
+	}
+}
+
+func TestSigningKeysConfig(t *testing.T) {
+	get := []SignedURLOperation{SignedURLOperationGet}
+	for _, keys := range [][]SigningKey{
+		{{Secret: "s", Operations: get}},
+		{{Name: "a", Operations: get}},
+		{{Name: "a", Secret: "s"}},
+		{{Name: "a", Secret: "s", Operations: get}, {Name: "a", Secret: "t", Operations: get}},
+	} {
+		config := &SignedURLConfig{Enabled: true, Keys: keys}
+		if err := config.Validate(); err == nil {
+			t.Errorf("expected %v to be rejected", keys)
+		}
+	}
+
+	config := &StorageConfig{
+		Name:      "test",
+		Provider:  "memory",
+		SignedURL: &SignedURLConfig{Keys: []SigningKey{{Name: "viewer", Secret: "viewer-secret", Operations: get}}},
+	}
+	if printed := config.Redacted().SignedURL.String(); strings.Contains(printed, "viewer-secret") {
+		t.Errorf("expected the key secret to be redacted, got %s", printed)
+	}
+	clone := config.Clone()
+	clone.SignedURL.Keys[0].Operations[0] = SignedURLOperationDelete
+	if config.SignedURL.Keys[0].Operations[0] != SignedURLOperationGet {
+		t.Error("expected Clone to copy the operations of the keys")
+	}
+}
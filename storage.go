@@ -3,11 +3,15 @@ package vsaasstorage
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	rest "github.com/xompass/vsaas-rest"
@@ -19,7 +23,7 @@ type StorageProvider interface {
 	Upload(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata) (*FileInfo, error)
 	Download(ctx context.Context, path string) (io.ReadCloser, *FileInfo, error)
 	Delete(ctx context.Context, path string) error
-	Exists(ctx context.Context, path string) (bool, error)
+	Exists(ctx context.Context, path string) (bool, error) // Files only, directories report false
 	GetInfo(ctx context.Context, path string) (*FileInfo, error)
 
 	// Directory operations
@@ -34,10 +38,41 @@ type StorageProvider interface {
 	GenerateSignedURL(ctx context.Context, path string, operation SignedURLOperation, expiresIn time.Duration) (string, error)
 }
 
+// DirectoryExistsProvider is implemented by providers that can check for a directory
+// without fetching its info, such as object stores where a directory is a key prefix
+type DirectoryExistsProvider interface {
+	DirectoryExists(ctx context.Context, path string) (bool, error)
+}
+
+// DirectoryProvider is implemented by providers that can create empty directories
+type DirectoryProvider interface {
+	CreateDirectory(ctx context.Context, path string) error
+}
+
+// AppendProvider is implemented by providers that can add data to the end of a file
+type AppendProvider interface {
+	Append(ctx context.Context, path string, reader io.Reader) (*FileInfo, error)
+}
+
+// ConditionalProvider is implemented by providers that can replace a file only if it
+// still has the given ETag, or create it only if it does not exist when etag is empty.
+// Conditional uploads of the same file are serialized so exactly one of several
+// concurrent writers with the same ETag succeeds; the others fail with
+// ErrPreconditionFailed.
+type ConditionalProvider interface {
+	UploadIfMatch(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata, etag string) (*FileInfo, error)
+}
+
 // Storage is the main storage instance that wraps a provider
 type Storage struct {
 	provider StorageProvider
 	config   *StorageConfig
+	quota    QuotaManager
+
+	idempotency  IdempotencyStore
+	uploadMemory *uploadBudget   // Set with StorageConfig.UploadMemory
+	fallback     *fallbackSource // Set on the views returned by WithFallback
+	derived      *DerivedFileManager
 }
 
 // FileInfo contains information about a file
@@ -50,6 +85,21 @@ type FileInfo struct {
 	LastModified *time.Time        `json:"last_modified,omitempty"`
 	IsDirectory  bool              `json:"is_directory"`
 	Metadata     map[string]string `json:"metadata,omitempty"`
+	ExpiresAt    *time.Time        `json:"expires_at,omitempty"`
+	RetainUntil  *time.Time        `json:"retain_until,omitempty"`
+	PublicURL    string            `json:"public_url,omitempty"` // Set by InfoHandler and ListHandler when PublicBaseURL is configured
+
+	// ContentEncoding is the encoding the content was stored with, e.g. "gzip"; Size is the
+	// size of the encoded content
+	ContentEncoding string `json:"content_encoding,omitempty"`
+
+	// Children summarizes the entries of a directory, filled by GetInfoWithOptions
+	Children *DirectorySummary `json:"children,omitempty"`
+}
+
+// isExpired reports whether the file has an expiration time that has passed
+func (f *FileInfo) isExpired(now time.Time) bool {
+	return f != nil && f.ExpiresAt != nil && !f.ExpiresAt.After(now)
 }
 
 // UploadedFileResult represents the result of uploading a file
@@ -62,14 +112,75 @@ type UploadedFileResult struct {
 	ContentType  string     `json:"content_type"`
 	ETag         string     `json:"etag,omitempty"`
 	LastModified *time.Time `json:"last_modified,omitempty"`
+
+	EXIF   *ImageMetadata `json:"exif,omitempty"`   // Fields removed by StripEXIF, when ExtractEXIF is set
+	Poster string         `json:"poster,omitempty"` // Path of the video poster, when Poster is configured and it was created
+
+	PublicURL string `json:"public_url,omitempty"` // Public URL of the file, when PublicBaseURL is configured
+
+	// SignedURL is a GET URL for the file, requested with UploadOptions.IncludeSignedURL.
+	// On the filesystem provider it is the token for the download endpoint's ?token=.
+	SignedURL          string     `json:"signed_url,omitempty"`
+	SignedURLExpiresAt *time.Time `json:"signed_url_expires_at,omitempty"`
+
+	// ContentMD5 and ChecksumSHA256 are the base64 digests of the content, verified
+	// against the ones sent in UploadOptions.ExpectedChecksum
+	ContentMD5     string `json:"content_md5,omitempty"`
+	ChecksumSHA256 string `json:"checksum_sha256,omitempty"`
 }
 
 // FileMetadata contains metadata for file uploads
 type FileMetadata struct {
-	ContentType     string            `json:"content_type,omitempty"`
-	CacheControl    string            `json:"cache_control,omitempty"`
-	ContentEncoding string            `json:"content_encoding,omitempty"`
-	CustomMetadata  map[string]string `json:"custom_metadata,omitempty"`
+	ContentType      string            `json:"content_type,omitempty"`
+	CacheControl     string            `json:"cache_control,omitempty"`
+	ContentEncoding  string            `json:"content_encoding,omitempty"`
+	CustomMetadata   map[string]string `json:"custom_metadata,omitempty"`
+	ExpiresAt        *time.Time        `json:"expires_at,omitempty"`       // Absolute expiration time, takes precedence over TTL
+	TTL              time.Duration     `json:"ttl,omitempty"`              // Expiration relative to the upload time
+	ComputeChecksum  *bool             `json:"compute_checksum,omitempty"` // Overrides StorageConfig.ComputeChecksum for this upload
+	ExpectedChecksum *ExpectedChecksum `json:"-"`                          // Checksum sent by the client, the upload fails if the content differs
+}
+
+// expiration returns the absolute expiration time for an upload made at now, or nil
+func (m *FileMetadata) expiration(now time.Time) *time.Time {
+	if m == nil {
+		return nil
+	}
+	if m.ExpiresAt != nil {
+		expiresAt := m.ExpiresAt.UTC()
+		return &expiresAt
+	}
+	if m.TTL > 0 {
+		expiresAt := now.Add(m.TTL).UTC()
+		return &expiresAt
+	}
+	return nil
+}
+
+// contentEncoding returns the encoding of an upload, empty for identity
+func (m *FileMetadata) contentEncoding() string {
+	if m == nil || strings.EqualFold(m.ContentEncoding, "identity") {
+		return ""
+	}
+	return strings.ToLower(m.ContentEncoding)
+}
+
+// customMetadata returns a copy of the custom metadata without the keys reported by the
+// providers themselves, or nil when there is none
+func (m *FileMetadata) customMetadata() map[string]string {
+	if m == nil || len(m.CustomMetadata) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(m.CustomMetadata))
+	for k, v := range m.CustomMetadata {
+		if k != DedupBlobMetadataKey {
+			copied[k] = v
+		}
+	}
+	if len(copied) == 0 {
+		return nil
+	}
+	return copied
 }
 
 // SignedURLOperation defines the type of operation for signed URLs
@@ -81,6 +192,30 @@ const (
 	SignedURLOperationDelete SignedURLOperation = "DELETE"
 )
 
+// ProviderFactory creates a provider for the given configuration
+type ProviderFactory func(config *StorageConfig) (StorageProvider, error)
+
+var (
+	providerFactoriesMu sync.RWMutex
+	providerFactories   = make(map[string]ProviderFactory)
+)
+
+// RegisterProvider registers a provider factory under the given name so that
+// New can create it from StorageConfig.Provider. Built-in providers cannot be replaced.
+func RegisterProvider(name string, factory ProviderFactory) {
+	providerFactoriesMu.Lock()
+	defer providerFactoriesMu.Unlock()
+	providerFactories[name] = factory
+}
+
+// lookupProvider returns the factory registered under the given name
+func lookupProvider(name string) (ProviderFactory, bool) {
+	providerFactoriesMu.RLock()
+	defer providerFactoriesMu.RUnlock()
+	factory, ok := providerFactories[name]
+	return factory, ok
+}
+
 // New creates a new Storage instance with the given configuration
 func New(config *StorageConfig) (*Storage, error) {
 	if err := config.Validate(); err != nil {
@@ -95,88 +230,640 @@ func New(config *StorageConfig) (*Storage, error) {
 		provider, err = NewFileSystemProvider(config)
 	case "s3":
 		provider, err = NewS3Provider(config)
+	case "memory":
+		provider, err = NewMemoryProvider(config)
 	default:
-		return nil, &StorageError{
-			Code:    ErrorCodeInvalidProvider,
-			Message: "unsupported provider: " + config.Provider,
+		factory, ok := lookupProvider(config.Provider)
+		if !ok {
+			return nil, &StorageError{
+				Code:    ErrorCodeInvalidProvider,
+				Message: "unsupported provider: " + config.Provider,
+			}
 		}
+		provider, err = factory(config)
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
+	if config.PathNormalization != PathNormalizationNone || !config.LegacyPaths {
+		provider = &normalizingProvider{inner: provider, config: config}
+	}
+
+	if config.Retry != nil {
+		provider = NewRetryingProvider(provider, config.retryPolicy())
+	}
+
+	// The breaker wraps the retries so an open circuit skips them entirely
+	if config.CircuitBreaker != nil {
+		provider = NewCircuitBreakerProvider(provider, config.circuitBreakerConfig())
+	}
+
+	var quota QuotaManager
+	if config.Quota != nil {
+		quota = config.Quota.Manager
+		if quota == nil {
+			if quota, err = NewQuotaManager(config.Quota); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var idempotency IdempotencyStore
+	if config.Idempotency != nil {
+		if idempotency, err = newIdempotencyStore(config.Idempotency, config.Clock); err != nil {
+			return nil, err
+		}
+	}
+
+	var uploadMemory *uploadBudget
+	if config.UploadMemory != nil {
+		uploadMemory = &uploadBudget{}
+	}
+
+	derived := newDerivedFileManager()
+	if config.Poster != nil {
+		if err := derived.RegisterDerivation(PosterDerivation, config.Poster.derive, config.Poster.pathTemplate(), DerivationOptions{ContentType: "image/jpeg"}); err != nil {
+			return nil, NewStorageErrorWithCause(ErrorCodeInvalidConfig, "invalid poster configuration", err)
+		}
+	}
+
 	return &Storage{
-		provider: provider,
-		config:   config,
+		provider:     provider,
+		config:       config,
+		quota:        quota,
+		idempotency:  idempotency,
+		uploadMemory: uploadMemory,
+		derived:      derived,
 	}, nil
 }
 
+// providerAs finds the first provider in a decorator chain that implements T
+func providerAs[T any](provider StorageProvider) (T, bool) {
+	for provider != nil {
+		if p, ok := provider.(T); ok {
+			return p, true
+		}
+
+		wrapper, ok := provider.(interface{ Unwrap() StorageProvider })
+		if !ok {
+			break
+		}
+		provider = wrapper.Unwrap()
+	}
+
+	var zero T
+	return zero, false
+}
+
+// extensionPath normalizes a path for a provider extension found with providerAs. The
+// extension is called on the provider past the decorators, including the one normalizing
+// the paths of StorageProvider calls, so its paths are normalized here instead.
+func (s *Storage) extensionPath(p string) string {
+	return s.config.normalizePath(p)
+}
+
 // Upload uploads a file to the storage
 func (s *Storage) Upload(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata) (*FileInfo, error) {
-	return s.provider.Upload(ctx, path, reader, metadata)
+	if err := s.checkWritable(path); err != nil {
+		return nil, err
+	}
+	if err := s.config.checkPathLength(path); err != nil {
+		return nil, err
+	}
+	ctx, release, err := s.reserveReaderMemory(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	reader, verifier := newChecksumReader(reader, path, metadata)
+
+	// Keep the current content in the history before it is replaced
+	versionPath := ""
+	if s.versioningEnabled() && !isInternalPath(path) {
+		if versionPath, err = s.saveVersion(ctx, path); err != nil {
+			return nil, err
+		}
+	}
+
+	var info *FileInfo
+	if s.quota != nil {
+		info, err = s.quotaUpload(ctx, path, reader, func(reader io.Reader) (*FileInfo, error) {
+			return s.provider.Upload(ctx, path, reader, metadata)
+		})
+	} else {
+		info, err = s.provider.Upload(ctx, path, reader, metadata)
+	}
+
+	if err != nil {
+		if versionPath != "" {
+			s.provider.Move(ctx, versionPath, path) // Put the previous content back
+		}
+		return nil, verifier.failure(err)
+	}
+
+	if versionPath != "" {
+		if err := s.pruneVersions(ctx, path); err != nil {
+			s.config.log(ctx, LogLevelWarn, "failed to prune versions", map[string]interface{}{
+				"path":  path,
+				"error": err.Error(),
+			})
+		}
+	}
+
+	s.journal(ctx, JournalOperationUpload, path, "", info)
+	s.invalidateDerived(ctx, path, false)
+	return info, nil
+}
+
+// UploadWithOptions is Upload reporting progress through UploadOptions.Progress. The
+// total size is known for readers with a Len method or that can seek, such as *os.File
+// and *bytes.Reader, and is -1 otherwise. The other options only apply to uploaded files.
+func (s *Storage) UploadWithOptions(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata, opts UploadOptions) (*FileInfo, error) {
+	if opts.Progress != nil {
+		total := int64(-1)
+		if size, ok := readerSize(reader); ok {
+			total = size
+		}
+		reader = newProgressReader(reader, s.config.normalizePath(path), total, opts)
+	}
+	return s.Upload(ctx, path, reader, metadata)
+}
+
+// Append adds the content of reader to the end of a file, creating it if needed. Callers
+// writing whole records per call get them without interleaving on the filesystem and
+// memory providers. Appends are not kept in the version history. Providers that cannot
+// append, such as S3, fail with ErrNotSupported so callers can fall back to Upload.
+func (s *Storage) Append(ctx context.Context, path string, reader io.Reader) (*FileInfo, error) {
+	if err := checkFilePath(path); err != nil {
+		return nil, err
+	}
+	path = s.extensionPath(path)
+	if err := s.checkWritable(path); err != nil {
+		return nil, err
+	}
+	if err := s.config.checkPathLength(path); err != nil {
+		return nil, err
+	}
+
+	provider, ok := providerAs[AppendProvider](s.provider)
+	if !ok {
+		return nil, NotSupportedError("provider cannot append to files")
+	}
+
+	var info *FileInfo
+	var err error
+	if s.quota != nil {
+		info, err = s.quotaAppend(path, reader, func(reader io.Reader) (*FileInfo, error) {
+			return provider.Append(ctx, path, reader)
+		})
+	} else {
+		info, err = provider.Append(ctx, path, reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.journal(ctx, JournalOperationAppend, path, "", info)
+	s.invalidateDerived(ctx, path, false)
+	return info, nil
 }
 
-// Download downloads a file from the storage
+// UploadIfMatch uploads a file only if the stored file still has etag, or only if there
+// is no file when etag is empty, failing with ErrPreconditionFailed otherwise. Like
+// Append, it is not kept in the version history. Providers without ConditionalProvider,
+// such as S3 for now, fail with ErrNotSupported.
+func (s *Storage) UploadIfMatch(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata, etag string) (*FileInfo, error) {
+	if err := checkFilePath(path); err != nil {
+		return nil, err
+	}
+	path = s.extensionPath(path)
+	if err := s.checkWritable(path); err != nil {
+		return nil, err
+	}
+	if err := s.config.checkPathLength(path); err != nil {
+		return nil, err
+	}
+
+	provider, ok := providerAs[ConditionalProvider](s.provider)
+	if !ok {
+		return nil, NotSupportedError("provider cannot upload conditionally")
+	}
+	ctx, release, err := s.reserveReaderMemory(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	reader, verifier := newChecksumReader(reader, path, metadata)
+
+	etag = NormalizeETag(etag)
+	var info *FileInfo
+	if s.quota != nil {
+		info, err = s.quotaUpload(ctx, path, reader, func(reader io.Reader) (*FileInfo, error) {
+			return provider.UploadIfMatch(ctx, path, reader, metadata, etag)
+		})
+	} else {
+		info, err = provider.UploadIfMatch(ctx, path, reader, metadata, etag)
+	}
+	if err != nil {
+		return nil, verifier.failure(err)
+	}
+	s.journal(ctx, JournalOperationUpload, path, "", info)
+	s.invalidateDerived(ctx, path, false)
+	return info, nil
+}
+
+// Download downloads a file from the storage. Expired files are reported as not found
+// even if they have not been removed by CleanupExpired yet.
 func (s *Storage) Download(ctx context.Context, path string) (io.ReadCloser, *FileInfo, error) {
-	return s.provider.Download(ctx, path)
+	reader, info, err := s.provider.Download(ctx, path)
+	if s.fallsBack(err) {
+		return s.fallback.download(ctx, s, path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if info.isExpired(s.config.now()) {
+		reader.Close()
+		return nil, nil, FileNotFoundError(path)
+	}
+
+	return reader, info, nil
+}
+
+// DeleteOptions controls the behavior of Delete and DeleteDirectory
+type DeleteOptions struct {
+	Permanent     bool // Bypass the trash when it is enabled
+	PurgeVersions bool // Also delete the version history when versioning is enabled
+	Force         bool // Let DeleteDirectory delete the root or a path shallower than StorageConfig.MinDeleteDepth
+}
+
+// shallowDelete reports whether deleting the directory at path needs DeleteOptions.Force:
+// it is the root or has fewer segments than StorageConfig.MinDeleteDepth
+func (s *Storage) shallowDelete(path string) bool {
+	clean := cleanPath(path)
+	if clean == "" {
+		return true
+	}
+	return strings.Count(clean, "/")+1 < s.config.MinDeleteDepth
+}
+
+// mergeDeleteOptions returns the first options value or the defaults
+func mergeDeleteOptions(opts []DeleteOptions) DeleteOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return DeleteOptions{}
 }
 
-// Delete deletes a file from the storage
-func (s *Storage) Delete(ctx context.Context, path string) error {
-	return s.provider.Delete(ctx, path)
+// Delete deletes a file from the storage. When the trash is enabled the file
+// is moved into it unless DeleteOptions.Permanent is set.
+func (s *Storage) Delete(ctx context.Context, path string, opts ...DeleteOptions) error {
+	return s.audited(ctx, AuditEntry{Operation: AuditOperationDelete, Path: path}, func(*AuditEntry) error {
+		return s.deleteFile(ctx, path, opts...)
+	})
 }
 
-// Exists checks if a file exists in the storage
+// deleteFile implements Delete
+func (s *Storage) deleteFile(ctx context.Context, path string, opts ...DeleteOptions) error {
+	if err := s.checkWritable(path); err != nil {
+		return err
+	}
+
+	// Remember the size so it can be returned to the quota
+	var deleted *FileInfo
+	if s.quota != nil {
+		deleted, _ = s.provider.GetInfo(ctx, path)
+	}
+
+	var err error
+	options := mergeDeleteOptions(opts)
+	if s.trashEnabled() && !options.Permanent && !isTrashPath(path) {
+		_, err = s.trashFile(ctx, path)
+	} else {
+		err = s.provider.Delete(ctx, path)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.journal(ctx, JournalOperationDelete, path, "", nil)
+	s.invalidateDerived(ctx, path, true)
+
+	if options.PurgeVersions {
+		if err := s.purgeVersions(ctx, path, nil); err != nil {
+			return err
+		}
+	}
+
+	if deleted != nil {
+		return s.releaseQuota([]*FileInfo{deleted})
+	}
+	return nil
+}
+
+// Exists checks if a file exists in the storage. Directories report false, so a true
+// result means the path can be downloaded; use DirectoryExists for directories. Expired
+// files report false like in GetInfo, whether or not they were swept yet.
 func (s *Storage) Exists(ctx context.Context, path string) (bool, error) {
-	return s.provider.Exists(ctx, path)
+	exists, err := s.provider.Exists(ctx, path)
+	if err == nil && exists {
+		exists, err = s.unexpired(ctx, path)
+	}
+	if err == nil && !exists && s.fallback != nil {
+		return s.fallback.storage.Exists(ctx, path)
+	}
+	return exists, err
+}
+
+// unexpired reports whether an existing file has not expired
+func (s *Storage) unexpired(ctx context.Context, path string) (bool, error) {
+	info, err := s.provider.GetInfo(ctx, path)
+	if errors.Is(err, ErrFileNotFound) {
+		return false, nil // Deleted in between
+	}
+	if err != nil {
+		return false, err
+	}
+	return !info.isExpired(s.config.now()), nil
+}
+
+// DirectoryExists checks if a directory exists in the storage. On object stores a
+// directory exists when at least one key has its path as prefix.
+func (s *Storage) DirectoryExists(ctx context.Context, path string) (bool, error) {
+	if provider, ok := providerAs[DirectoryExistsProvider](s.provider); ok {
+		return provider.DirectoryExists(ctx, s.extensionPath(path))
+	}
+
+	info, err := s.provider.GetInfo(ctx, path)
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrDirectoryNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return info.IsDirectory, nil
 }
 
-// GetInfo gets information about a file
+// GetInfo gets information about a file. Expired files are reported as not found.
 func (s *Storage) GetInfo(ctx context.Context, path string) (*FileInfo, error) {
-	return s.provider.GetInfo(ctx, path)
+	info, err := s.provider.GetInfo(ctx, path)
+	if s.fallsBack(err) {
+		return s.fallback.storage.GetInfo(ctx, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if info.isExpired(s.config.now()) {
+		return nil, FileNotFoundError(path)
+	}
+
+	return info, nil
+}
+
+// ListOptions controls the behavior of ListWithOptions
+type ListOptions struct {
+	IncludeTrash    bool // Include the trash directory when listing the root
+	IncludeVersions bool // Include the version history directory when listing the root
+	IncludeHidden   bool // Include entries hidden by IsHidden, except the internal directories at the root
+	AllowMissing    bool // Return an empty listing instead of ErrDirectoryNotFound
+
+	// IncludeETags and IncludeMetadata guarantee listed files carry their ETag and custom
+	// metadata, fetching them per file on providers whose listings lack them. Providers
+	// may include them even when not requested.
+	IncludeETags    bool
+	IncludeMetadata bool
+	ComputeETags    bool // With IncludeETags, hash files without a stored checksum on the filesystem provider
+
+	IncludeFallback bool // Merge the entries of the secondary of a storage created with WithFallback
 }
 
 // List lists files in a directory
 func (s *Storage) List(ctx context.Context, path string) ([]*FileInfo, error) {
-	return s.provider.List(ctx, path)
+	return s.ListWithOptions(ctx, path, ListOptions{})
 }
 
-// DeleteDirectory deletes a directory and all its contents recursively
-func (s *Storage) DeleteDirectory(ctx context.Context, path string) error {
-	return s.provider.DeleteDirectory(ctx, path)
+// ListWithOptions lists files in a directory using the given options
+func (s *Storage) ListWithOptions(ctx context.Context, path string, opts ListOptions) ([]*FileInfo, error) {
+	if opts.IncludeFallback && s.fallback != nil {
+		return s.listMerged(ctx, path, opts)
+	}
+
+	files, err := s.provider.List(ctx, path)
+	if err != nil {
+		if opts.AllowMissing && errors.Is(err, ErrDirectoryNotFound) {
+			return []*FileInfo{}, nil
+		}
+		return nil, err
+	}
+
+	root := isRootPath(path)
+	visible := files[:0]
+	for _, file := range files {
+		if opts.listed(root, file) {
+			visible = append(visible, file)
+		}
+	}
+
+	if opts.IncludeETags || opts.IncludeMetadata {
+		if err := s.fillDetails(ctx, visible, opts); err != nil {
+			return nil, err
+		}
+	}
+	return visible, nil
 }
 
-// Copy copies a file from source to destination
+// CreateDirectory creates an empty directory and any missing parents. Creating an existing
+// directory succeeds; a path where a file exists fails with ErrorCodeInvalidPath.
+func (s *Storage) CreateDirectory(ctx context.Context, path string) error {
+	if err := s.checkWritable(path); err != nil {
+		return err
+	}
+	if err := s.config.checkPathLength(path); err != nil {
+		return err
+	}
+	if isRootPath(path) {
+		return nil
+	}
+
+	provider, ok := providerAs[DirectoryProvider](s.provider)
+	if !ok {
+		return NotSupportedError("provider cannot create directories")
+	}
+	return provider.CreateDirectory(ctx, s.extensionPath(path))
+}
+
+// DeleteDirectory deletes a directory and all its contents recursively. When the trash
+// is enabled the contents are moved into it unless DeleteOptions.Permanent is set. The
+// root and paths shallower than StorageConfig.MinDeleteDepth fail with ErrForceRequired
+// unless DeleteOptions.Force is set.
+func (s *Storage) DeleteDirectory(ctx context.Context, path string, opts ...DeleteOptions) error {
+	return s.audited(ctx, AuditEntry{Operation: AuditOperationDeleteDirectory, Path: path}, func(*AuditEntry) error {
+		return s.deleteDirectory(ctx, path, opts...)
+	})
+}
+
+// deleteDirectory implements DeleteDirectory
+func (s *Storage) deleteDirectory(ctx context.Context, path string, opts ...DeleteOptions) error {
+	if err := s.checkWritable(path); err != nil {
+		return err
+	}
+	options := mergeDeleteOptions(opts)
+	if !options.Force && s.shallowDelete(path) {
+		return ForceRequiredError(path)
+	}
+
+	// Remember the files so their sizes can be returned to the quota, their deletion
+	// journaled and their native versions purged
+	_, native := s.nativeVersioning()
+	var files []*FileInfo
+	if s.quota != nil || s.config.Journal != nil || (options.PurgeVersions && native) {
+		s.walk(ctx, path, allEntries, func(info *FileInfo) error {
+			files = append(files, info)
+			return nil
+		})
+	}
+
+	var err error
+	if s.trashEnabled() && !options.Permanent && !isTrashPath(path) {
+		err = s.trashDirectory(ctx, path)
+	} else {
+		err = s.provider.DeleteDirectory(ctx, path)
+	}
+
+	// Files kept under retention still count against the quota
+	var retentionErr *DirectoryRetentionError
+	if err != nil && !errors.As(err, &retentionErr) {
+		return err
+	}
+	if retentionErr != nil {
+		files = withoutPaths(files, retentionErr.Skipped)
+	}
+	for _, file := range files {
+		if !file.IsDirectory {
+			s.journal(ctx, JournalOperationDelete, file.Path, "", nil)
+			s.invalidateDerived(ctx, file.Path, true)
+		}
+	}
+	if options.PurgeVersions {
+		if purgeErr := s.purgeVersions(ctx, path, files); purgeErr != nil {
+			return purgeErr
+		}
+	}
+
+	if s.quota != nil && len(files) > 0 {
+		if quotaErr := s.releaseQuota(files); quotaErr != nil {
+			return quotaErr
+		}
+	}
+	return err
+}
+
+// Copy copies a file from source to destination. A directory is copied recursively
+// like CopyDirectory.
 func (s *Storage) Copy(ctx context.Context, srcPath, dstPath string) error {
-	return s.provider.Copy(ctx, srcPath, dstPath)
+	if err := s.checkWritable(dstPath); err != nil {
+		return err
+	}
+	if err := s.config.checkPathLength(dstPath); err != nil {
+		return err
+	}
+
+	var err error
+	if s.quota != nil {
+		err = s.quotaTransfer(ctx, srcPath, dstPath, false, func() error {
+			return s.provider.Copy(ctx, srcPath, dstPath)
+		})
+	} else {
+		err = s.provider.Copy(ctx, srcPath, dstPath)
+	}
+
+	if s.rejectedDirectory(ctx, srcPath, err) {
+		return s.CopyDirectory(ctx, srcPath, dstPath)
+	}
+	if err != nil {
+		return err
+	}
+	s.journalCurrent(ctx, JournalOperationCopy, dstPath, srcPath)
+	s.invalidateDerived(ctx, dstPath, false)
+	return nil
 }
 
-// Move moves a file from source to destination
+// Move moves a file from source to destination. A directory is moved file by file,
+// so retention locks and quotas apply to each of them, and removed once it is empty.
 func (s *Storage) Move(ctx context.Context, srcPath, dstPath string) error {
-	return s.provider.Move(ctx, srcPath, dstPath)
+	return s.audited(ctx, AuditEntry{Operation: AuditOperationMove, Path: srcPath, Destination: dstPath}, func(*AuditEntry) error {
+		return s.moveFile(ctx, srcPath, dstPath)
+	})
 }
 
-// GenerateSignedURL generates a signed URL for the given operation
+// moveFile implements Move
+func (s *Storage) moveFile(ctx context.Context, srcPath, dstPath string) error {
+	if err := s.checkWritable(dstPath); err != nil {
+		return err
+	}
+	if err := s.config.checkPathLength(dstPath); err != nil {
+		return err
+	}
+
+	var err error
+	if s.quota != nil {
+		err = s.quotaTransfer(ctx, srcPath, dstPath, true, func() error {
+			return s.provider.Move(ctx, srcPath, dstPath)
+		})
+	} else {
+		err = s.provider.Move(ctx, srcPath, dstPath)
+	}
+
+	if s.rejectedDirectory(ctx, srcPath, err) {
+		return s.moveDirectory(ctx, srcPath, dstPath)
+	}
+	if err != nil {
+		return err
+	}
+	s.journalCurrent(ctx, JournalOperationMove, dstPath, srcPath)
+	s.invalidateDerived(ctx, srcPath, true)
+	s.invalidateDerived(ctx, dstPath, false)
+	return nil
+}
+
+// GenerateSignedURL generates a signed URL for the given operation. A read-only storage
+// only signs GET URLs. With CDNSigning GET URLs are signed for CloudFront.
 func (s *Storage) GenerateSignedURL(ctx context.Context, path string, operation SignedURLOperation, expiresIn time.Duration) (string, error) {
-	return s.provider.GenerateSignedURL(ctx, path, operation, expiresIn)
+	return s.GenerateSignedURLWithOptions(ctx, path, operation, SignedURLOptions{ExpiresIn: expiresIn})
 }
 
-// GetConfig returns the storage configuration
+// Provider returns the provider backing the storage, including any decorators
+func (s *Storage) Provider() StorageProvider {
+	return s.provider
+}
+
+// GetConfig returns a deep copy of the storage configuration.
+// Modifying the returned value does not affect the running storage.
 func (s *Storage) GetConfig() *StorageConfig {
-	return s.config
+	return s.config.Clone()
+}
+
+// uniqueSuffix returns a short unique identifier (8 characters), replaceable in tests
+var uniqueSuffix = func() string {
+	uniqueID := make([]byte, 4)
+	rand.Read(uniqueID)
+	return fmt.Sprintf("%x", uniqueID)
 }
 
-// generateUniqueFilename generates a unique filename to avoid conflicts
-func generateUniqueFilename(originalFilename string) string {
+// generateUniqueFilename generates a unique filename to avoid conflicts. Names longer than
+// maxLength bytes lose the end of their base name, never the extension or the unique suffix.
+func generateUniqueFilename(originalFilename string, maxLength int) string {
 	// Get file extension
 	ext := filepath.Ext(originalFilename)
 	nameWithoutExt := strings.TrimSuffix(originalFilename, ext)
 
-	// Generate a short unique identifier (8 characters)
-	uniqueID := make([]byte, 4)
-	rand.Read(uniqueID)
-	uniqueStr := fmt.Sprintf("%x", uniqueID)
+	uniqueStr := uniqueSuffix()
+	if room := maxLength - len(ext) - len(uniqueStr) - 1; maxLength > 0 && room >= 0 {
+		nameWithoutExt = truncateUTF8(nameWithoutExt, room)
+	}
 
 	// Combine: originalname_uniqueid.ext
 	if ext != "" {
@@ -185,44 +872,334 @@ func generateUniqueFilename(originalFilename string) string {
 	return fmt.Sprintf("%s_%s", nameWithoutExt, uniqueStr)
 }
 
+// UploadOptions controls the behavior of UploadFromCtxWithOptions and UploadFromUploadedFileWithOptions
+type UploadOptions struct {
+	Filename string // Name without extension for the stored file; a unique name is generated when empty
+
+	// ExactPath stores the file at the destination directory joined with Filename exactly
+	// as given, e.g. "cam42/latest.json": no extension is appended and the path layout is
+	// not applied. Filename is required.
+	ExactPath bool
+
+	// NoOverwrite fails with ErrFileAlreadyExists instead of replacing an existing file
+	// at an explicit Filename. The check is atomic on providers with ConditionalProvider
+	// and done before the upload otherwise.
+	NoOverwrite bool
+
+	// IdempotencyKey makes retries of the same upload return the first result instead of
+	// storing the content again. Requires StorageConfig.Idempotency.
+	IdempotencyKey string
+
+	// IncludeSignedURL adds a signed GET URL to each result when signed URLs or CDN signing
+	// are enabled, valid for SignedURLExpiresIn or the configured default. A URL that
+	// cannot be signed is left out without failing the upload.
+	IncludeSignedURL   bool
+	SignedURLExpiresIn time.Duration
+
+	// Progress is called every ProgressInterval bytes (DefaultProgressInterval when zero)
+	// and once more when the content has been read. The files of a multi-file upload are
+	// reported by path, possibly from several goroutines at once.
+	Progress         ProgressFunc
+	ProgressInterval int64
+
+	// Atomic makes multi-file uploads all-or-nothing: the first failure deletes the files
+	// of the request already stored. Otherwise every file is attempted and the failures
+	// are reported in an *UploadError next to the results of the stored files.
+	Atomic bool
+
+	// PathLayout places the files under the destination directory, overriding
+	// StorageConfig.UploadLayout, e.g. in date or hash partitions. Flat when neither is set.
+	PathLayout PathLayout
+
+	// Uploader is recorded as the subject that uploaded the files under
+	// UploadedByMetadataKey, taking precedence over the one set with WithUploader
+	Uploader string
+
+	// ExpectedChecksum is the checksum the client computed for the content of a single
+	// uploaded file, e.g. from ParseChecksumHeaders. A file that does not match it fails
+	// with ErrChecksumMismatch and is not stored.
+	ExpectedChecksum *ExpectedChecksum
+
+	// PreUpload approves, moves or renames each file before it is stored, overriding
+	// StorageConfig.PreUpload. Only UploadFromCtx and UploadHandler call it.
+	PreUpload PreUploadInterceptor
+
+	preUpload func(file *rest.UploadedFile, proposed *ProposedUpload) error // PreUpload bound to the request
+}
+
 // UploadFromCtx processes file uploads from a vsaas-rest context and uploads them to the specified destination directory
 func (s *Storage) UploadFromCtx(ctx context.Context, c *rest.EndpointContext, destinationDir string, destinationFilename ...string) ([]*UploadedFileResult, error) {
+	var opts UploadOptions
+	if len(destinationFilename) > 0 {
+		opts.Filename = destinationFilename[0]
+	}
+	return s.UploadFromCtxWithOptions(ctx, c, destinationDir, opts)
+}
+
+// UploadFromCtxWithOptions is UploadFromCtx with options. When some files fail and
+// others are stored, the results of the stored files are returned with an *UploadError
+// listing the failures, unless UploadOptions.Atomic is set. With an idempotency key the
+// results of every file in the request are recorded and replayed together, only when all
+// of them were stored.
+func (s *Storage) UploadFromCtxWithOptions(ctx context.Context, c *rest.EndpointContext, destinationDir string, opts UploadOptions) ([]*UploadedFileResult, error) {
+	if err := s.checkWritable(destinationDir); err != nil {
+		return nil, err
+	}
+
 	// Check if there are uploaded files
 	allFiles := c.GetAllUploadedFiles()
 	if len(allFiles) == 0 {
 		return nil, NewStorageError(ErrorCodeUploadFailed, "No files uploaded")
 	}
 
-	var results []*UploadedFileResult
+	if opts.PreUpload == nil {
+		opts.PreUpload = s.config.PreUpload
+	}
+	if intercept := opts.PreUpload; intercept != nil {
+		opts.preUpload = func(file *rest.UploadedFile, proposed *ProposedUpload) error {
+			return intercept(c, file, proposed)
+		}
+	}
+	return s.uploadFiles(ctx, allFiles, destinationDir, opts)
+}
+
+// uploadJob is a file of a multi-file upload
+type uploadJob struct {
+	fieldName string
+	file      *rest.UploadedFile
+}
+
+// uploadJobs returns the files of a request ordered by field name and position
+func uploadJobs(allFiles map[string][]*rest.UploadedFile) []uploadJob {
+	fieldNames := make([]string, 0, len(allFiles))
+	for fieldName := range allFiles {
+		fieldNames = append(fieldNames, fieldName)
+	}
+	sort.Strings(fieldNames)
+
+	var jobs []uploadJob
+	for _, fieldName := range fieldNames {
+		for _, file := range allFiles[fieldName] {
+			jobs = append(jobs, uploadJob{fieldName: fieldName, file: file})
+		}
+	}
+	return jobs
+}
 
-	// Process each uploaded file
-	for fieldName, files := range allFiles {
-		for _, uploadedFile := range files {
-			result, err := s.UploadFromUploadedFile(ctx, uploadedFile, fieldName, destinationDir, destinationFilename...)
-			if err != nil {
+// uploadFiles stores the files of a request under destinationDir, UploadConcurrency at
+// a time. Results and failures keep the order of the files by field name and position.
+func (s *Storage) uploadFiles(ctx context.Context, allFiles map[string][]*rest.UploadedFile, destinationDir string, opts UploadOptions) ([]*UploadedFileResult, error) {
+	jobs := uploadJobs(allFiles)
+	if opts.ExactPath && len(jobs) > 1 {
+		return nil, NewStorageError(ErrorCodeInvalidPath, "ExactPath stores a single file")
+	}
+	if opts.ExpectedChecksum != nil && len(jobs) > 1 {
+		return nil, NewStorageError(ErrorCodeUploadFailed, "a checksum applies to a single file")
+	}
+	if opts.Atomic {
+		// Reject names that cannot be stored before any file of the request is uploaded
+		for _, job := range jobs {
+			if err := s.checkUploadPath(destinationDir, job.file, opts); err != nil {
 				return nil, err
 			}
-			results = append(results, result)
 		}
 	}
 
-	return results, nil
+	// The whole request is admitted or refused at once
+	var size int64
+	for _, job := range jobs {
+		size += max(job.file.Size, 0)
+	}
+	ctx, release, err := s.reserveUploadMemory(ctx, size)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return idempotent(ctx, s, idempotencyScope("request", destinationDir, opts.IdempotencyKey), func() ([]*UploadedFileResult, error) {
+		// An atomic upload stops the other files at the first failure
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		results := make([]*UploadedFileResult, len(jobs))
+		errs := make([]error, len(jobs))
+		keys := make([]string, len(jobs))
+		for i := range jobs {
+			keys[i] = strconv.Itoa(i)
+		}
+		// Each call writes to its own index
+		NewParallelExecutor(s.config.uploadConcurrency()).Run(runCtx, keys, func(ctx context.Context, key string) error {
+			i, _ := strconv.Atoi(key)
+			results[i], errs[i] = s.uploadFile(ctx, jobs[i].file, jobs[i].fieldName, destinationDir, opts)
+			if errs[i] != nil && opts.Atomic {
+				cancel()
+			}
+			return nil
+		})
+
+		var stored []*UploadedFileResult
+		uploadErr := &UploadError{}
+		var firstErr error
+		for i, job := range jobs {
+			err := errs[i]
+			if results[i] != nil {
+				stored = append(stored, results[i])
+				continue
+			}
+			if err == nil {
+				err = runCtx.Err() // Never started
+			}
+			// Files stopped by the failure of another are not the cause of an atomic upload failing
+			if firstErr == nil && (!opts.Atomic || ctx.Err() != nil || !errors.Is(err, context.Canceled)) {
+				firstErr = err
+			}
+			uploadErr.Failures = append(uploadErr.Failures, &UploadFailure{
+				FieldName:    job.fieldName,
+				OriginalName: job.file.OriginalName,
+				Err:          err,
+			})
+		}
+
+		if len(uploadErr.Failures) == 0 {
+			return stored, nil
+		}
+		if opts.Atomic {
+			s.removeUploaded(context.WithoutCancel(ctx), stored)
+			return nil, firstErr
+		}
+		return stored, uploadErr
+	})
+}
+
+// removeUploaded deletes the files stored by an atomic upload that failed
+func (s *Storage) removeUploaded(ctx context.Context, results []*UploadedFileResult) {
+	for _, result := range results {
+		for _, path := range []string{result.Path, result.Poster} {
+			if path == "" {
+				continue
+			}
+			if err := s.Delete(ctx, path, DeleteOptions{Permanent: true}); err != nil {
+				s.config.log(ctx, LogLevelWarn, "failed to remove file of a failed atomic upload", map[string]interface{}{
+					"path":  path,
+					"error": err.Error(),
+				})
+			}
+		}
+	}
 }
 
 // UploadFromUploadedFile processes a single uploaded file and uploads it to the specified destination directory
 func (s *Storage) UploadFromUploadedFile(ctx context.Context, uploadedFile *rest.UploadedFile, fieldName, destinationDir string, destinationFileName ...string) (*UploadedFileResult, error) {
-	// Generate unique filename to avoid conflicts
+	var opts UploadOptions
+	if len(destinationFileName) > 0 {
+		opts.Filename = destinationFileName[0]
+	}
+	return s.UploadFromUploadedFileWithOptions(ctx, uploadedFile, fieldName, destinationDir, opts)
+}
 
-	fileName := ""
-	if len(destinationFileName) > 0 && destinationFileName[0] != "" {
-		ext := filepath.Ext(uploadedFile.Filename)
-		fileName = destinationFileName[0] + ext
-	} else {
-		fileName = generateUniqueFilename(uploadedFile.Filename)
+// UploadFromUploadedFileToPath stores a single uploaded file at exactly filePath, without
+// generating a name or appending the extension of the uploaded file. See UploadOptions.ExactPath.
+func (s *Storage) UploadFromUploadedFileToPath(ctx context.Context, uploadedFile *rest.UploadedFile, fieldName, filePath string, opts ...UploadOptions) (*UploadedFileResult, error) {
+	var options UploadOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	dir, name := "", filepath.ToSlash(filePath)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		dir, name = name[:i], name[i+1:]
+	}
+	options.ExactPath, options.Filename = true, name
+	return s.UploadFromUploadedFileWithOptions(ctx, uploadedFile, fieldName, dir, options)
+}
+
+// UploadFromUploadedFileWithOptions is UploadFromUploadedFile with options
+func (s *Storage) UploadFromUploadedFileWithOptions(ctx context.Context, uploadedFile *rest.UploadedFile, fieldName, destinationDir string, opts UploadOptions) (*UploadedFileResult, error) {
+	if err := s.checkWritable(destinationDir); err != nil {
+		return nil, err
+	}
+
+	return idempotent(ctx, s, idempotencyScope("file", destinationDir, opts.IdempotencyKey), func() (*UploadedFileResult, error) {
+		return s.uploadFile(ctx, uploadedFile, fieldName, destinationDir, opts)
+	})
+}
+
+// checkUploadPath checks the path limits for an uploaded file. Generated names are
+// shortened to fit, so only the directory and explicit names can exceed them.
+func (s *Storage) checkUploadPath(destinationDir string, uploadedFile *rest.UploadedFile, opts UploadOptions) error {
+	if opts.ExactPath {
+		return s.checkExactPath(destinationDir, opts.Filename)
+	}
+	if opts.Filename != "" {
+		return s.config.checkPathLength(s.uploadPath(destinationDir, explicitFilename(opts.Filename, uploadedFile.Filename), opts, s.config.now()))
+	}
+	return s.config.checkPathLength(destinationDir)
+}
+
+// checkExactPath refuses an ExactPath upload without a name, that cannot name a file or
+// that steps out of its directory with ".."
+func (s *Storage) checkExactPath(destinationDir, fileName string) error {
+	if fileName == "" {
+		return NewStorageError(ErrorCodeInvalidPath, "ExactPath requires a Filename")
+	}
+	filePath := destinationDir + "/" + fileName
+	for _, segment := range strings.Split(filepath.ToSlash(filePath), "/") {
+		if segment == ".." {
+			return InvalidPathError(filePath)
+		}
+	}
+	if err := checkFilePath(fileName); err != nil {
+		return err
+	}
+	return s.config.checkPathLength(filePath)
+}
+
+// explicitFilename appends the extension of the uploaded file to a name given by the
+// caller, unless the name already ends with it: "latest" and "latest.json" both store a
+// "latest.json" upload as "latest.json"
+func explicitFilename(name, uploadedFilename string) string {
+	ext := filepath.Ext(uploadedFilename)
+	if strings.EqualFold(filepath.Ext(name), ext) {
+		return name
+	}
+	return name + ext
+}
+
+// uploadFile stores a single uploaded file under destinationDir
+func (s *Storage) uploadFile(ctx context.Context, uploadedFile *rest.UploadedFile, fieldName, destinationDir string, opts UploadOptions) (*UploadedFileResult, error) {
+	proposed, err := interceptUpload(uploadedFile, fieldName, destinationDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	destinationDir, opts.Filename = proposed.Directory, proposed.Filename
+
+	destinationFileName := opts.Filename
+	if err := s.checkUploadPath(destinationDir, uploadedFile, opts); err != nil {
+		return nil, err
+	}
+
+	// The path layout places the file under destinationDir, flat by default
+	now := s.config.now()
+	place := func(fileName string) string {
+		return s.uploadPath(destinationDir, fileName, opts, now)
 	}
 
-	// Construct the full file path with unique filename
-	filePath := fmt.Sprintf("%s/%s", strings.TrimSuffix(destinationDir, "/"), fileName)
+	// Generate unique filename to avoid conflicts, reserved until the upload is done
+	var fileName, filePath string
+	switch {
+	case opts.ExactPath:
+		filePath = s.config.normalizePath(cleanPath(destinationDir + "/" + destinationFileName))
+		fileName = filePath[strings.LastIndex(filePath, "/")+1:]
+	case destinationFileName != "":
+		fileName = explicitFilename(destinationFileName, uploadedFile.Filename)
+		filePath = place(fileName)
+	default:
+		var release func()
+		var err error
+		if fileName, filePath, release, err = s.reserveFilename(ctx, uploadedFile.Filename, place); err != nil {
+			return nil, err
+		}
+		defer release()
+	}
 
 	// Open the uploaded file
 	fileReader, err := os.Open(uploadedFile.Path)
@@ -231,17 +1208,87 @@ func (s *Storage) UploadFromUploadedFile(ctx context.Context, uploadedFile *rest
 	}
 	defer fileReader.Close()
 
-	// Prepare metadata
+	// Remove EXIF and XMP from images before anything is stored
+	var imageMetadata *ImageMetadata
+	expected := opts.ExpectedChecksum
+	if s.config.StripEXIF {
+		// Stripping changes the content, so the checksum is checked on the file as received
+		if expected != nil {
+			if err := verifyContent(fileReader, filePath, expected); err != nil {
+				return nil, err
+			}
+			if _, err := fileReader.Seek(0, io.SeekStart); err != nil {
+				return nil, NewStorageErrorWithCause(ErrorCodeUploadFailed, "Failed to read uploaded file", err)
+			}
+			expected = nil
+		}
+		stripped, exif := s.stripUploadedImage(ctx, fileReader, filePath)
+		if stripped != nil {
+			defer os.Remove(stripped.Name())
+			defer stripped.Close()
+			fileReader = stripped
+		}
+		if exif != nil && s.config.ExtractEXIF {
+			if imageMetadata, err = parseEXIF(exif); err != nil {
+				s.config.log(ctx, LogLevelWarn, "failed to parse EXIF", map[string]interface{}{
+					"path":  filePath,
+					"error": err.Error(),
+				})
+			}
+		}
+	}
+
+	// Prepare metadata, recording where the file came from
 	metadata := &FileMetadata{
-		ContentType: uploadedFile.MimeType,
+		ContentType:      proposed.ContentType,
+		CustomMetadata:   proposed.Metadata,
+		ExpectedChecksum: expected,
+	}
+	for key, value := range uploadOriginMetadata(ctx, uploadedFile.OriginalName, fieldName, opts, now) {
+		metadata.CustomMetadata[key] = value
+	}
+
+	size := int64(-1)
+	if stat, err := fileReader.Stat(); err == nil {
+		size = stat.Size()
+	}
+	var reader io.Reader = &contextReader{ctx: ctx, r: fileReader}
+	if opts.Progress != nil {
+		reader = newProgressReader(reader, filePath, size, opts)
 	}
 
-	// Upload to storage
-	fileInfo, err := s.Upload(ctx, filePath, fileReader, metadata)
+	// Upload to storage, through the scanner when one is configured
+	var fileInfo *FileInfo
+	if opts.NoOverwrite && s.config.Scanner != nil {
+		// The scanned content is moved into place, so the check can only come first
+		if exists, err := s.Exists(ctx, filePath); err != nil {
+			return nil, err
+		} else if exists {
+			return nil, FileAlreadyExistsError(filePath)
+		}
+	}
+	switch {
+	case s.config.Scanner != nil:
+		fileInfo, err = s.uploadScanned(ctx, filePath, reader, metadata, &ScanInfo{
+			Path:         filePath,
+			OriginalName: uploadedFile.OriginalName,
+			ContentType:  proposed.ContentType,
+			Size:         max(size, 0),
+		})
+	case opts.NoOverwrite:
+		fileInfo, err = s.uploadNew(ctx, filePath, reader, metadata)
+	default:
+		fileInfo, err = s.Upload(ctx, filePath, reader, metadata)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	var posterPath string
+	if s.config.Poster != nil && (isVideo(proposed.ContentType) || isVideoFile(fileInfo)) {
+		posterPath = s.createPoster(ctx, fileInfo, fileReader)
+	}
+
 	// Create result structure
 	result := &UploadedFileResult{
 		FieldName:    fieldName,
@@ -252,15 +1299,80 @@ func (s *Storage) UploadFromUploadedFile(ctx context.Context, uploadedFile *rest
 		ContentType:  fileInfo.ContentType,
 		ETag:         fileInfo.ETag,
 		LastModified: fileInfo.LastModified,
+		EXIF:         imageMetadata,
+		Poster:       posterPath,
+		PublicURL:    s.publicURL(fileInfo.Path),
+
+		ContentMD5:     opts.ExpectedChecksum.contentMD5(),
+		ChecksumSHA256: opts.ExpectedChecksum.checksumSHA256(),
+	}
+	if opts.IncludeSignedURL {
+		s.signUploadResult(ctx, result, opts.SignedURLExpiresIn)
 	}
 
 	return result, nil
 }
 
+// uploadNew stores a file only if nothing exists at its path yet, failing with
+// ErrFileAlreadyExists otherwise
+func (s *Storage) uploadNew(ctx context.Context, filePath string, reader io.Reader, metadata *FileMetadata) (*FileInfo, error) {
+	if _, ok := providerAs[ConditionalProvider](s.provider); ok {
+		info, err := s.UploadIfMatch(ctx, filePath, reader, metadata, "")
+		if errors.Is(err, ErrPreconditionFailed) {
+			return nil, FileAlreadyExistsError(filePath)
+		}
+		return info, err
+	}
+
+	exists, err := s.Exists(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, FileAlreadyExistsError(filePath)
+	}
+	return s.Upload(ctx, filePath, reader, metadata)
+}
+
+// signUploadResult adds a signed GET URL to an upload result, logging instead of failing
+// when it cannot be signed
+func (s *Storage) signUploadResult(ctx context.Context, result *UploadedFileResult, expiresIn time.Duration) {
+	if !s.config.GetSignedURLConfig().Enabled && s.config.CDNSigning == nil {
+		return
+	}
+
+	if expiresIn <= 0 {
+		expiresIn = s.config.GetSignedURLConfig().ExpiresIn
+		if s.config.CDNSigning != nil {
+			expiresIn = s.config.CDNSigning.ExpiresIn
+			if expiresIn <= 0 {
+				expiresIn = DefaultCDNSignedURLExpiry
+			}
+		}
+	}
+
+	expiresAt := s.config.now().Add(expiresIn)
+	signedURL, err := s.GenerateSignedURL(ctx, result.Path, SignedURLOperationGet, expiresIn)
+	if err != nil {
+		s.config.log(ctx, LogLevelWarn, "failed to sign URL of uploaded file", map[string]interface{}{
+			"path":  result.Path,
+			"error": err.Error(),
+		})
+		return
+	}
+	result.SignedURL, result.SignedURLExpiresAt = signedURL, &expiresAt
+}
+
 // StreamFile streams a file directly to the HTTP response, handling signed URLs, tokens, and direct downloads
 func (s *Storage) StreamFile(c *rest.EndpointContext, path string) error {
 	// Check for token validation (signed URL access)
-	if token := c.EchoCtx.QueryParam("token"); token != "" {
+	token := c.EchoCtx.QueryParam("token")
+	if !s.tokenAuthorizes(token) {
+		if err := s.authorize(c, path, SignedURLOperationGet); err != nil {
+			return err
+		}
+	}
+	if token != "" {
 		return s.handleTokenDownload(c, path, token)
 	}
 
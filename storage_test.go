@@ -2,6 +2,8 @@ package vsaasstorage
 
 import (
 	"context"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,6 +16,7 @@ func TestFileSystemProvider(t *testing.T) {
 	testDir := filepath.Join(os.TempDir(), "vsaas-storage-test")
 	defer os.RemoveAll(testDir)
 
+	clock := newManualClock()
 	config := &StorageConfig{
 		Name:     "TestStorage",
 		Provider: "filesystem",
@@ -26,6 +29,7 @@ func TestFileSystemProvider(t *testing.T) {
 			ExpiresIn: 5 * time.Minute,
 			SecretKey: "test-secret-key",
 		},
+		Clock: clock,
 	}
 
 	storage, err := New(config)
@@ -98,6 +102,26 @@ func TestFileSystemProvider(t *testing.T) {
 		if exists {
 			t.Error("File should not exist")
 		}
+
+		exists, err = storage.Exists(ctx, "test")
+		if err != nil || exists {
+			t.Errorf("Expected Exists to report false for a directory, got %v, %v", exists, err)
+		}
+
+		exists, err = storage.DirectoryExists(ctx, "test")
+		if err != nil || !exists {
+			t.Errorf("Expected directory to exist, got %v, %v", exists, err)
+		}
+
+		exists, err = storage.DirectoryExists(ctx, "test/hello.txt")
+		if err != nil || exists {
+			t.Errorf("Expected DirectoryExists to report false for a file, got %v, %v", exists, err)
+		}
+
+		exists, err = storage.DirectoryExists(ctx, "missing")
+		if err != nil || exists {
+			t.Errorf("Expected missing directory to not exist, got %v, %v", exists, err)
+		}
 	})
 
 	t.Run("GetInfo", func(t *testing.T) {
@@ -263,10 +287,123 @@ func TestFileSystemProvider(t *testing.T) {
 			if err == nil {
 				t.Error("Token validation should fail for wrong operation")
 			}
+
+			// Test expiry
+			clock.Advance(5 * time.Minute)
+			if err := fsProvider.ValidateSignedToken(signedURL, "signed/test.txt", SignedURLOperationGet); err != nil {
+				t.Errorf("Token should still be valid when it expires, got %v", err)
+			}
+			clock.Advance(time.Second)
+			err = fsProvider.ValidateSignedToken(signedURL, "signed/test.txt", SignedURLOperationGet)
+			if !errors.Is(err, ErrTokenExpired) {
+				t.Errorf("Token validation should fail with an expired token, got %v", err)
+			}
+		}
+	})
+
+	t.Run("Skip checksum", func(t *testing.T) {
+		fileInfo, err := storage.Upload(ctx, "checksum/hashed.bin", strings.NewReader("content"), nil)
+		if err != nil || fileInfo.ETag == "" {
+			t.Errorf("Expected an ETag by default, got '%s', %v", fileInfo.ETag, err)
+		}
+
+		skip := false
+		fileInfo, err = storage.Upload(ctx, "checksum/raw.bin", strings.NewReader("content"), &FileMetadata{ComputeChecksum: &skip})
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		if fileInfo.ETag != "" || fileInfo.Size != 7 {
+			t.Errorf("Expected no ETag and size 7, got '%s' and %d", fileInfo.ETag, fileInfo.Size)
+		}
+	})
+
+	t.Run("Failed upload keeps the previous file", func(t *testing.T) {
+		storage.Upload(ctx, "test/kept.txt", strings.NewReader("previous"), nil)
+		if _, err := storage.Upload(ctx, "test/kept.txt", &failingReader{data: []byte("partial")}, nil); err == nil {
+			t.Fatal("Expected the failing upload to fail")
+		}
+
+		reader, info, err := storage.Download(ctx, "test/kept.txt")
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(content) != "previous" || info.ETag == "" {
+			t.Errorf("Expected the previous content and its ETag, got '%s' and '%s'", content, info.ETag)
+		}
+	})
+
+	t.Run("Uploads in progress are not listed", func(t *testing.T) {
+		reader, writer := io.Pipe()
+		done := make(chan error)
+		go func() {
+			_, err := storage.Upload(ctx, "progress/clip.mp4", reader, &FileMetadata{TTL: time.Hour})
+			done <- err
+		}()
+		writer.Write([]byte("partial"))
+
+		files, err := storage.ListWithOptions(ctx, "progress", ListOptions{IncludeHidden: true})
+		if err != nil || len(files) != 0 {
+			t.Errorf("Expected no entries while uploading, got %d, %v", len(files), err)
+		}
+		writer.Close()
+		if err := <-done; err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+
+		// The replacing upload drops the expiration along with the previous content
+		storage.Upload(ctx, "progress/clip.mp4", strings.NewReader("video"), nil)
+		if info, err := storage.GetInfo(ctx, "progress/clip.mp4"); err != nil || info.ExpiresAt != nil {
+			t.Errorf("Expected the replaced file without expiration, got %+v, %v", info, err)
 		}
 	})
 }
 
+// benchmarkUploadSize is the size of the synthetic upload in BenchmarkFileSystemUpload
+const benchmarkUploadSize = 1 << 30
+
+// zeroReader produces an endless stream of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// Measures the cost of hashing a 1GB upload:
+//
+//	go test -run '^$' -bench FileSystemUpload -benchtime 3x
+func BenchmarkFileSystemUpload(b *testing.B) {
+	for _, checksum := range []bool{true, false} {
+		name := "checksum"
+		if !checksum {
+			name = "no-checksum"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			storage, err := New(&StorageConfig{
+				Name:            "BenchmarkStorage",
+				Provider:        "filesystem",
+				FileSystem:      &FileSystemConfig{BasePath: b.TempDir()},
+				ComputeChecksum: &checksum,
+			})
+			if err != nil {
+				b.Fatalf("Failed to create storage: %v", err)
+			}
+
+			ctx := context.Background()
+			b.SetBytes(benchmarkUploadSize)
+			for i := 0; i < b.N; i++ {
+				reader := io.LimitReader(zeroReader{}, benchmarkUploadSize)
+				if _, err := storage.Upload(ctx, "bench/video.mp4", reader, nil); err != nil {
+					b.Fatalf("Upload failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
 func TestConfigValidation(t *testing.T) {
 	t.Run("Valid filesystem config", func(t *testing.T) {
 		config := &StorageConfig{
@@ -367,3 +504,67 @@ func TestStorageErrors(t *testing.T) {
 		t.Errorf("Expected error message '%s', got '%s'", expectedMsg, err.Error())
 	}
 }
+
+func TestCreateDirectory(t *testing.T) {
+	ctx := context.Background()
+	configs := map[string]*StorageConfig{
+		"filesystem": {Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir(), Permissions: "0640"}},
+		"memory":     {Name: "test", Provider: "memory"},
+	}
+
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			if _, err := storage.List(ctx, "tenants/acme"); !errors.Is(err, ErrDirectoryNotFound) {
+				t.Errorf("Expected ErrDirectoryNotFound, got %v", err)
+			}
+			files, err := storage.ListWithOptions(ctx, "tenants/acme", ListOptions{AllowMissing: true})
+			if err != nil || files == nil || len(files) != 0 {
+				t.Errorf("Expected an empty listing, got %v, %v", files, err)
+			}
+
+			if err := storage.CreateDirectory(ctx, "tenants/acme/cameras"); err != nil {
+				t.Fatalf("CreateDirectory failed: %v", err)
+			}
+			if err := storage.CreateDirectory(ctx, "tenants/acme/cameras"); err != nil {
+				t.Errorf("Expected creating an existing directory to succeed, got %v", err)
+			}
+
+			files, err = storage.List(ctx, "tenants/acme")
+			if err != nil || len(files) != 1 || files[0].Name != "cameras" || !files[0].IsDirectory {
+				t.Errorf("Expected the new directory in the listing, got %v, %v", files, err)
+			}
+			if files, err := storage.List(ctx, "tenants/acme/cameras"); err != nil || len(files) != 0 {
+				t.Errorf("Expected an empty directory, got %v, %v", files, err)
+			}
+			if info, err := storage.GetInfo(ctx, "tenants/acme/cameras"); err != nil || !info.IsDirectory {
+				t.Errorf("Expected directory info, got %v, %v", info, err)
+			}
+
+			storage.Upload(ctx, "tenants/acme/notes.txt", strings.NewReader("notes"), nil)
+			for _, path := range []string{"tenants/acme/notes.txt", "tenants/acme/notes.txt/sub"} {
+				if err := storage.CreateDirectory(ctx, path); !errors.Is(err, ErrInvalidPath) {
+					t.Errorf("Expected ErrInvalidPath for %s, got %v", path, err)
+				}
+			}
+
+			if err := storage.DeleteDirectory(ctx, "tenants/acme/cameras"); err != nil {
+				t.Fatalf("DeleteDirectory failed: %v", err)
+			}
+			if _, err := storage.List(ctx, "tenants/acme/cameras"); !errors.Is(err, ErrDirectoryNotFound) {
+				t.Errorf("Expected the directory to be deleted, got %v", err)
+			}
+		})
+	}
+
+	t.Run("Permissions", func(t *testing.T) {
+		provider := &FileSystemProvider{config: configs["filesystem"]}
+		if mode := provider.dirMode(); mode != 0750 {
+			t.Errorf("Expected 0750, got %o", mode)
+		}
+	})
+}
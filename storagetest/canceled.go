@@ -0,0 +1,37 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	vsaasstorage "github.com/xompass/vsaas-storage"
+)
+
+// CanceledTimeout is how long AssertCanceled lets an operation take to give up
+const CanceledTimeout = time.Second
+
+// AssertCanceled calls fn with an already canceled context and fails the test unless it
+// returns within CanceledTimeout a *vsaasstorage.StorageError wrapping context.Canceled.
+// name identifies the operation in failure messages.
+func AssertCanceled(t testing.TB, name string, fn func(ctx context.Context) error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		var storageErr *vsaasstorage.StorageError
+		if !errors.As(err, &storageErr) || !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected %s to fail with a StorageError wrapping context.Canceled, got %v", name, err)
+		}
+	case <-time.After(CanceledTimeout):
+		t.Errorf("Expected %s to return within %v of the cancellation", name, CanceledTimeout)
+	}
+}
@@ -0,0 +1,139 @@
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	vsaasstorage "github.com/xompass/vsaas-storage"
+)
+
+func TestStorageCanceledContext(t *testing.T) {
+	for name, config := range testConfigs(t) {
+		t.Run(name, func(t *testing.T) {
+			storage, err := vsaasstorage.New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			ctx := context.Background()
+			if _, err := storage.Upload(ctx, "dir/a.json", strings.NewReader(`{"n":1}`), nil); err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+
+			operations := map[string]func(ctx context.Context) error{
+				"Upload": func(ctx context.Context) error {
+					_, err := storage.Upload(ctx, "dir/b.txt", strings.NewReader("x"), nil)
+					return err
+				},
+				"Append": func(ctx context.Context) error {
+					_, err := storage.Append(ctx, "dir/log.txt", strings.NewReader("x"))
+					return err
+				},
+				"Download": func(ctx context.Context) error {
+					_, _, err := storage.Download(ctx, "dir/a.json")
+					return err
+				},
+				"DownloadBytes": func(ctx context.Context) error {
+					_, _, err := storage.DownloadBytes(ctx, "dir/a.json")
+					return err
+				},
+				"ReadRange": func(ctx context.Context) error {
+					_, _, err := storage.ReadRange(ctx, "dir/a.json", 0, 1)
+					return err
+				},
+				"GetInfo": func(ctx context.Context) error {
+					_, err := storage.GetInfo(ctx, "dir/a.json")
+					return err
+				},
+				"Exists": func(ctx context.Context) error {
+					_, err := storage.Exists(ctx, "dir/a.json")
+					return err
+				},
+				"DirectoryExists": func(ctx context.Context) error {
+					_, err := storage.DirectoryExists(ctx, "dir")
+					return err
+				},
+				"List": func(ctx context.Context) error {
+					_, err := storage.List(ctx, "dir")
+					return err
+				},
+				"Walk": func(ctx context.Context) error {
+					return storage.Walk(ctx, "", func(*vsaasstorage.FileInfo) error { return nil })
+				},
+				"ExistsMany": func(ctx context.Context) error {
+					_, err := storage.ExistsMany(ctx, []string{"dir/a.json"})
+					return err
+				},
+				"GetInfoMany": func(ctx context.Context) error {
+					_, err := storage.GetInfoMany(ctx, []string{"dir/a.json"})
+					return err
+				},
+				"Delete": func(ctx context.Context) error {
+					return storage.Delete(ctx, "dir/a.json")
+				},
+				"DeleteMany": func(ctx context.Context) error {
+					return storage.DeleteMany(ctx, []string{"dir/a.json"})
+				},
+				"DeleteDirectory": func(ctx context.Context) error {
+					return storage.DeleteDirectory(ctx, "dir")
+				},
+				"EmptyDirectory": func(ctx context.Context) error {
+					_, err := storage.EmptyDirectory(ctx, "dir")
+					return err
+				},
+				"CreateDirectory": func(ctx context.Context) error {
+					return storage.CreateDirectory(ctx, "empty")
+				},
+				"Copy": func(ctx context.Context) error {
+					return storage.Copy(ctx, "dir/a.json", "dir/copy.json")
+				},
+				"Move": func(ctx context.Context) error {
+					return storage.Move(ctx, "dir/a.json", "dir/moved.json")
+				},
+				"CopyDirectory": func(ctx context.Context) error {
+					return storage.CopyDirectory(ctx, "dir", "copied")
+				},
+				"GenerateSignedURL": func(ctx context.Context) error {
+					_, err := storage.GenerateSignedURL(ctx, "dir/a.json", vsaasstorage.SignedURLOperationGet, time.Minute)
+					return err
+				},
+				"PutJSON": func(ctx context.Context) error {
+					_, err := storage.PutJSON(ctx, "dir/b.json", map[string]int{"n": 2}, nil)
+					return err
+				},
+				"GetJSON": func(ctx context.Context) error {
+					var out map[string]int
+					_, err := storage.GetJSON(ctx, "dir/a.json", &out, 0)
+					return err
+				},
+				"ArchiveFiles": func(ctx context.Context) error {
+					_, err := storage.ArchiveFiles(ctx, []string{"dir/a.json"}, io.Discard, vsaasstorage.ArchiveOptions{})
+					return err
+				},
+				"TopN": func(ctx context.Context) error {
+					_, err := storage.TopN(ctx, "", 1, "size")
+					return err
+				},
+				"Usage": func(ctx context.Context) error {
+					_, err := storage.Usage(ctx, "", vsaasstorage.ListOptions{})
+					return err
+				},
+			}
+			for name, fn := range operations {
+				AssertCanceled(t, name, fn)
+			}
+
+			// Nothing was changed by the canceled calls
+			files, err := storage.List(ctx, "")
+			if err != nil || len(files) != 1 || files[0].Path != "dir" {
+				t.Errorf("Expected only the original directory, got %v, %v", files, err)
+			}
+			if data, _, err := storage.DownloadBytes(ctx, "dir/a.json"); err != nil || !bytes.Equal(data, []byte(`{"n":1}`)) {
+				t.Errorf("Expected the original file to be kept, got %q, %v", data, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	vsaasstorage "github.com/xompass/vsaas-storage"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	later, sooner := clock.After(time.Minute), clock.After(time.Second)
+	if clock.Waiters() != 2 {
+		t.Fatalf("Expected 2 waiters, got %d", clock.Waiters())
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case fired := <-sooner:
+		if !fired.Equal(start.Add(time.Second)) {
+			t.Errorf("Expected the time of the clock, got %v", fired)
+		}
+	default:
+		t.Fatal("Expected the due channel to fire")
+	}
+	select {
+	case <-later:
+		t.Fatal("Expected the later channel not to fire yet")
+	default:
+	}
+
+	clock.Advance(time.Hour)
+	if !clock.Now().Equal(start.Add(time.Hour+time.Second)) || clock.Waiters() != 0 {
+		t.Errorf("Expected the clock advanced with no waiters, got %v and %d", clock.Now(), clock.Waiters())
+	}
+	<-later
+}
+
+func TestFakeClockExpirations(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	config := testConfigs(t)["filesystem"]
+	config.Clock = clock
+	storage, err := vsaasstorage.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	t.Run("SignedURL", func(t *testing.T) {
+		token, err := storage.GenerateSignedURL(ctx, "clips/a.mp4", vsaasstorage.SignedURLOperationGet, 5*time.Minute)
+		if err != nil {
+			t.Fatalf("GenerateSignedURL failed: %v", err)
+		}
+		if info, _ := storage.InspectToken(token); !info.Valid || !info.ExpiresAt.Equal(clock.Now().Add(5*time.Minute)) {
+			t.Fatalf("Expected a token valid for 5 minutes of the clock, got %+v", info)
+		}
+
+		clock.Advance(5*time.Minute + time.Second)
+		info, _ := storage.InspectToken(token)
+		if info.Valid || info.Failure != vsaasstorage.TokenFailureExpired {
+			t.Errorf("Expected the token to expire, got %+v", info)
+		}
+	})
+
+	t.Run("Worker", func(t *testing.T) {
+		storage.Upload(ctx, "tmp/a.txt", strings.NewReader("a"), &vsaasstorage.FileMetadata{TTL: time.Hour})
+		workerCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		storage.StartExpirationWorker(workerCtx, 10*time.Minute)
+
+		for clock.Waiters() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(2 * time.Hour)
+
+		// Expired files are hidden at once, so look for the file on disk
+		file := filepath.Join(config.FileSystem.BasePath, "tmp", "a.txt")
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Error("Expected the worker to delete the expired file")
+	})
+}
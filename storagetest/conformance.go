@@ -0,0 +1,443 @@
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	vsaasstorage "github.com/xompass/vsaas-storage"
+)
+
+// LargeStreamSize is the size of the file RunProviderTests streams through Upload and
+// Download. It is above the usual 5 MiB multipart threshold and not a multiple of it.
+const LargeStreamSize = 12<<20 + 123
+
+// RunProviderTests checks that a provider behaves like the built-in ones: round trips,
+// Unicode paths, large streams, directory semantics, copy and move, the error codes of
+// missing files, signed tokens when the provider can sign, and that every operation gives
+// up on a canceled context. newProvider is called once per subtest and must return an
+// empty provider. Paths are passed canonical and in NFC, as Storage passes them, so the
+// suite checks the provider rather than the path handling in front of it.
+func RunProviderTests(t *testing.T, newProvider func(t *testing.T) vsaasstorage.StorageProvider) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, provider vsaasstorage.StorageProvider)
+	}{
+		{"RoundTrip", testRoundTrip},
+		{"Overwrite", testOverwrite},
+		{"UnicodePaths", testUnicodePaths},
+		{"LargeStream", testLargeStream},
+		{"Directories", testDirectories},
+		{"DeleteDirectory", testDeleteDirectory},
+		{"Copy", testCopy},
+		{"Move", testMove},
+		{"NotFound", testNotFound},
+		{"SignedURL", testSignedURL},
+		{"CanceledContext", testCanceledContext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newProvider(t))
+		})
+	}
+}
+
+// upload stores content at filePath, failing the test on error
+func upload(t *testing.T, provider vsaasstorage.StorageProvider, filePath, content string) *vsaasstorage.FileInfo {
+	t.Helper()
+	info, err := provider.Upload(context.Background(), filePath, strings.NewReader(content), nil)
+	if err != nil {
+		t.Fatalf("Upload of %q failed: %v", filePath, err)
+	}
+	return info
+}
+
+// download returns the content at filePath, failing the test on error
+func download(t *testing.T, provider vsaasstorage.StorageProvider, filePath string) string {
+	t.Helper()
+	reader, _, err := provider.Download(context.Background(), filePath)
+	if err != nil {
+		t.Fatalf("Download of %q failed: %v", filePath, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Reading %q failed: %v", filePath, err)
+	}
+	return string(data)
+}
+
+// listPaths returns the sorted paths listed in dirPath, directories with a trailing slash
+func listPaths(t *testing.T, provider vsaasstorage.StorageProvider, dirPath string) []string {
+	t.Helper()
+	files, err := provider.List(context.Background(), dirPath)
+	if err != nil {
+		t.Fatalf("List of %q failed: %v", dirPath, err)
+	}
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDirectory {
+			paths = append(paths, file.Path+"/")
+		} else {
+			paths = append(paths, file.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func testRoundTrip(t *testing.T, provider vsaasstorage.StorageProvider) {
+	ctx := context.Background()
+	metadata := &vsaasstorage.FileMetadata{
+		ContentType:    "video/mp4",
+		CustomMetadata: map[string]string{"camera": "1"},
+	}
+	info, err := provider.Upload(ctx, "cameras/1/clip.mp4", strings.NewReader("clip"), metadata)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if info.Path != "cameras/1/clip.mp4" || info.Name != "clip.mp4" || info.Size != 4 || info.IsDirectory {
+		t.Errorf("Unexpected upload info %+v", info)
+	}
+
+	reader, downloaded, err := provider.Download(ctx, "cameras/1/clip.mp4")
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "clip" {
+		t.Errorf("Expected 'clip', got %q", data)
+	}
+	if downloaded.Size != 4 || downloaded.ContentType != "video/mp4" {
+		t.Errorf("Unexpected download info %+v", downloaded)
+	}
+
+	got, err := provider.GetInfo(ctx, "cameras/1/clip.mp4")
+	if err != nil {
+		t.Fatalf("GetInfo failed: %v", err)
+	}
+	if got.Path != info.Path || got.Size != 4 || got.ContentType != "video/mp4" || got.IsDirectory {
+		t.Errorf("Unexpected info %+v", got)
+	}
+	if got.Metadata["camera"] != "1" {
+		t.Errorf("Expected the custom metadata to be kept, got %v", got.Metadata)
+	}
+	if got.LastModified == nil || time.Since(*got.LastModified) > time.Hour {
+		t.Errorf("Expected a recent modification time, got %v", got.LastModified)
+	}
+	if info.ETag != "" && got.ETag != info.ETag {
+		t.Errorf("Expected GetInfo to return the upload ETag %q, got %q", info.ETag, got.ETag)
+	}
+
+	if exists, err := provider.Exists(ctx, "cameras/1/clip.mp4"); err != nil || !exists {
+		t.Errorf("Expected the file to exist, got %v, %v", exists, err)
+	}
+
+	if err := provider.Delete(ctx, "cameras/1/clip.mp4"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if exists, err := provider.Exists(ctx, "cameras/1/clip.mp4"); err != nil || exists {
+		t.Errorf("Expected the file to be deleted, got %v, %v", exists, err)
+	}
+}
+
+func testOverwrite(t *testing.T, provider vsaasstorage.StorageProvider) {
+	first := upload(t, provider, "doc.txt", "first")
+	second := upload(t, provider, "doc.txt", "second version")
+
+	if content := download(t, provider, "doc.txt"); content != "second version" {
+		t.Errorf("Expected the new content, got %q", content)
+	}
+	if second.Size != int64(len("second version")) {
+		t.Errorf("Expected the new size, got %d", second.Size)
+	}
+	if first.ETag != "" && second.ETag == first.ETag {
+		t.Errorf("Expected a new ETag for new content, got %q twice", first.ETag)
+	}
+}
+
+func testUnicodePaths(t *testing.T, provider vsaasstorage.StorageProvider) {
+	paths := []string{"menús/café.txt", "日本語/ファイル.mp4", "emoji 🎥/clip.mp4", "spaces and+plus/100%.txt"}
+	for _, filePath := range paths {
+		if info := upload(t, provider, filePath, filePath); info.Path != filePath {
+			t.Errorf("Expected upload at %q, got %q", filePath, info.Path)
+		}
+		if content := download(t, provider, filePath); content != filePath {
+			t.Errorf("Expected %q back, got %q", filePath, content)
+		}
+	}
+
+	listed := listPaths(t, provider, "menús")
+	if len(listed) != 1 || listed[0] != "menús/café.txt" {
+		t.Errorf("Expected the Unicode name in the listing, got %v", listed)
+	}
+}
+
+func testLargeStream(t *testing.T, provider vsaasstorage.StorageProvider) {
+	ctx := context.Background()
+	hash := sha256.New()
+	source := io.TeeReader(io.LimitReader(rand.New(rand.NewSource(1)), LargeStreamSize), hash)
+
+	info, err := provider.Upload(ctx, "large.bin", source, nil)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if info.Size != LargeStreamSize {
+		t.Errorf("Expected %d bytes, got %d", LargeStreamSize, info.Size)
+	}
+	expected := hash.Sum(nil)
+
+	reader, _, err := provider.Download(ctx, "large.bin")
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	defer reader.Close()
+	hash.Reset()
+	n, err := io.Copy(hash, reader)
+	if err != nil || n != LargeStreamSize {
+		t.Fatalf("Expected to read %d bytes, got %d, %v", LargeStreamSize, n, err)
+	}
+	if !bytes.Equal(hash.Sum(nil), expected) {
+		t.Error("Downloaded content differs from the uploaded content")
+	}
+}
+
+func testDirectories(t *testing.T, provider vsaasstorage.StorageProvider) {
+	ctx := context.Background()
+	upload(t, provider, "a.txt", "a")
+	upload(t, provider, "dir/b.txt", "b")
+	upload(t, provider, "dir/sub/c.txt", "c")
+	upload(t, provider, "dir/sub/deeper/d.txt", "d")
+
+	// Listings hold the immediate children only, with directories flagged
+	tests := []struct {
+		dir      string
+		expected []string
+	}{
+		{"", []string{"a.txt", "dir/"}},
+		{"dir", []string{"dir/b.txt", "dir/sub/"}},
+		{"dir/sub", []string{"dir/sub/c.txt", "dir/sub/deeper/"}},
+	}
+	for _, tt := range tests {
+		if listed := listPaths(t, provider, tt.dir); strings.Join(listed, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("List(%q) = %v, expected %v", tt.dir, listed, tt.expected)
+		}
+	}
+
+	// Directories are not files
+	if exists, err := provider.Exists(ctx, "dir"); err != nil || exists {
+		t.Errorf("Expected Exists to report false for a directory, got %v, %v", exists, err)
+	}
+	if info, err := provider.GetInfo(ctx, "dir/sub"); err != nil || !info.IsDirectory {
+		t.Errorf("Expected GetInfo to report a directory, got %+v, %v", info, err)
+	}
+
+	if _, err := provider.List(ctx, "missing"); !errors.Is(err, vsaasstorage.ErrDirectoryNotFound) {
+		t.Errorf("Expected ErrDirectoryNotFound listing a missing directory, got %v", err)
+	}
+}
+
+func testDeleteDirectory(t *testing.T, provider vsaasstorage.StorageProvider) {
+	ctx := context.Background()
+	upload(t, provider, "keep.txt", "keep")
+	upload(t, provider, "dir/a.txt", "a")
+	upload(t, provider, "dir/sub/b.txt", "b")
+	upload(t, provider, "dirty.txt", "not below dir")
+
+	if err := provider.DeleteDirectory(ctx, "dir"); err != nil {
+		t.Fatalf("DeleteDirectory failed: %v", err)
+	}
+	for _, filePath := range []string{"dir/a.txt", "dir/sub/b.txt"} {
+		if exists, _ := provider.Exists(ctx, filePath); exists {
+			t.Errorf("Expected %q to be deleted", filePath)
+		}
+	}
+	if listed := listPaths(t, provider, ""); strings.Join(listed, ",") != "dirty.txt,keep.txt" {
+		t.Errorf("Expected only the files outside the directory, got %v", listed)
+	}
+
+	if err := provider.DeleteDirectory(ctx, "dir"); !errors.Is(err, vsaasstorage.ErrDirectoryNotFound) {
+		t.Errorf("Expected ErrDirectoryNotFound deleting a missing directory, got %v", err)
+	}
+}
+
+func testCopy(t *testing.T, provider vsaasstorage.StorageProvider) {
+	ctx := context.Background()
+	upload(t, provider, "src/a.txt", "content")
+
+	if err := provider.Copy(ctx, "src/a.txt", "dst/nested/a.txt"); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if content := download(t, provider, "dst/nested/a.txt"); content != "content" {
+		t.Errorf("Expected the copied content, got %q", content)
+	}
+	if content := download(t, provider, "src/a.txt"); content != "content" {
+		t.Errorf("Expected the source to be kept, got %q", content)
+	}
+
+	// Copies are independent of their source
+	upload(t, provider, "src/a.txt", "changed")
+	if content := download(t, provider, "dst/nested/a.txt"); content != "content" {
+		t.Errorf("Expected the copy to keep its content, got %q", content)
+	}
+
+	// Existing destinations are replaced
+	upload(t, provider, "other.txt", "other")
+	if err := provider.Copy(ctx, "other.txt", "dst/nested/a.txt"); err != nil {
+		t.Fatalf("Copy over an existing file failed: %v", err)
+	}
+	if content := download(t, provider, "dst/nested/a.txt"); content != "other" {
+		t.Errorf("Expected the destination to be replaced, got %q", content)
+	}
+}
+
+func testMove(t *testing.T, provider vsaasstorage.StorageProvider) {
+	ctx := context.Background()
+	upload(t, provider, "src/a.txt", "content")
+
+	if err := provider.Move(ctx, "src/a.txt", "dst/a.txt"); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	if content := download(t, provider, "dst/a.txt"); content != "content" {
+		t.Errorf("Expected the moved content, got %q", content)
+	}
+	if exists, _ := provider.Exists(ctx, "src/a.txt"); exists {
+		t.Error("Expected the source to be removed")
+	}
+}
+
+func testNotFound(t *testing.T, provider vsaasstorage.StorageProvider) {
+	ctx := context.Background()
+	upload(t, provider, "present.txt", "x")
+
+	if _, _, err := provider.Download(ctx, "missing.txt"); !errors.Is(err, vsaasstorage.ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound from Download, got %v", err)
+	}
+	if _, err := provider.GetInfo(ctx, "missing.txt"); !errors.Is(err, vsaasstorage.ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound from GetInfo, got %v", err)
+	}
+	if err := provider.Delete(ctx, "missing.txt"); !errors.Is(err, vsaasstorage.ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound from Delete, got %v", err)
+	}
+	if exists, err := provider.Exists(ctx, "missing.txt"); err != nil || exists {
+		t.Errorf("Expected Exists to report false without error, got %v, %v", exists, err)
+	}
+	if err := provider.Copy(ctx, "missing.txt", "copy.txt"); !errors.Is(err, vsaasstorage.ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound from Copy, got %v", err)
+	}
+	if err := provider.Move(ctx, "missing.txt", "moved.txt"); !errors.Is(err, vsaasstorage.ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound from Move, got %v", err)
+	}
+
+	// Failed calls leave nothing behind
+	if listed := listPaths(t, provider, ""); strings.Join(listed, ",") != "present.txt" {
+		t.Errorf("Expected only the present file, got %v", listed)
+	}
+}
+
+// tokenValidator is implemented by providers that sign tokens instead of URLs
+type tokenValidator interface {
+	ValidateSignedToken(tokenString, path string, operation vsaasstorage.SignedURLOperation) error
+}
+
+func testSignedURL(t *testing.T, provider vsaasstorage.StorageProvider) {
+	upload(t, provider, "signed/a.txt", "signed content")
+
+	signed, err := provider.GenerateSignedURL(context.Background(), "signed/a.txt", vsaasstorage.SignedURLOperationGet, time.Minute)
+	if err != nil {
+		t.Skipf("Provider cannot sign: %v", err)
+	}
+
+	if parsed, err := url.Parse(signed); err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") {
+		response, err := http.Get(signed)
+		if err != nil {
+			t.Fatalf("Fetching the signed URL failed: %v", err)
+		}
+		defer response.Body.Close()
+		data, _ := io.ReadAll(response.Body)
+		if response.StatusCode != http.StatusOK || string(data) != "signed content" {
+			t.Errorf("Expected the content from the signed URL, got %d %q", response.StatusCode, data)
+		}
+		return
+	}
+
+	validator, ok := provider.(tokenValidator)
+	if !ok {
+		t.Skip("Provider returns tokens it cannot validate")
+	}
+	if err := validator.ValidateSignedToken(signed, "signed/a.txt", vsaasstorage.SignedURLOperationGet); err != nil {
+		t.Errorf("Expected the token to be valid for its path, got %v", err)
+	}
+	if err := validator.ValidateSignedToken(signed, "signed/b.txt", vsaasstorage.SignedURLOperationGet); err == nil {
+		t.Error("Expected the token to be rejected for another path")
+	}
+	if err := validator.ValidateSignedToken(signed, "signed/a.txt", vsaasstorage.SignedURLOperationDelete); err == nil {
+		t.Error("Expected the token to be rejected for another operation")
+	}
+}
+
+func testCanceledContext(t *testing.T, provider vsaasstorage.StorageProvider) {
+	upload(t, provider, "dir/a.txt", "content")
+
+	operations := map[string]func(ctx context.Context) error{
+		"Upload": func(ctx context.Context) error {
+			_, err := provider.Upload(ctx, "dir/b.txt", strings.NewReader("content"), nil)
+			return err
+		},
+		"Download": func(ctx context.Context) error {
+			reader, _, err := provider.Download(ctx, "dir/a.txt")
+			if reader != nil {
+				reader.Close()
+			}
+			return err
+		},
+		"Delete": func(ctx context.Context) error {
+			return provider.Delete(ctx, "dir/a.txt")
+		},
+		"Exists": func(ctx context.Context) error {
+			_, err := provider.Exists(ctx, "dir/a.txt")
+			return err
+		},
+		"GetInfo": func(ctx context.Context) error {
+			_, err := provider.GetInfo(ctx, "dir/a.txt")
+			return err
+		},
+		"List": func(ctx context.Context) error {
+			_, err := provider.List(ctx, "dir")
+			return err
+		},
+		"DeleteDirectory": func(ctx context.Context) error {
+			return provider.DeleteDirectory(ctx, "dir")
+		},
+		"Copy": func(ctx context.Context) error {
+			return provider.Copy(ctx, "dir/a.txt", "dir/copy.txt")
+		},
+		"Move": func(ctx context.Context) error {
+			return provider.Move(ctx, "dir/a.txt", "dir/moved.txt")
+		},
+		"GenerateSignedURL": func(ctx context.Context) error {
+			_, err := provider.GenerateSignedURL(ctx, "dir/a.txt", vsaasstorage.SignedURLOperationGet, time.Minute)
+			return err
+		},
+	}
+	for name, fn := range operations {
+		AssertCanceled(t, name, fn)
+	}
+
+	// Nothing was changed by the canceled calls
+	if listed := listPaths(t, provider, "dir"); strings.Join(listed, ",") != "dir/a.txt" {
+		t.Errorf("Expected the canceled calls to leave the directory untouched, got %v", listed)
+	}
+}
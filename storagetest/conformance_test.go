@@ -0,0 +1,93 @@
+package storagetest
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	vsaasstorage "github.com/xompass/vsaas-storage"
+)
+
+// testConfigs returns a configuration per provider the package tests run against, each
+// with its own empty filesystem directory
+func testConfigs(t *testing.T) map[string]*vsaasstorage.StorageConfig {
+	configs := map[string]*vsaasstorage.StorageConfig{
+		"filesystem": {
+			Name:       "filesystem",
+			Provider:   "filesystem",
+			FileSystem: &vsaasstorage.FileSystemConfig{BasePath: t.TempDir()},
+			SignedURL:  &vsaasstorage.SignedURLConfig{Enabled: true, SecretKey: "secret"},
+		},
+		"memory": {Name: "memory", Provider: "memory"},
+	}
+
+	// Runs against MinIO or S3 when an endpoint is given, e.g. a local
+	// "minio server" with VSAAS_STORAGE_TEST_S3_ENDPOINT=http://localhost:9000
+	if endpoint := os.Getenv("VSAAS_STORAGE_TEST_S3_ENDPOINT"); endpoint != "" {
+		configs["s3"] = &vsaasstorage.StorageConfig{
+			Name:     "s3",
+			Provider: "s3",
+			S3: &vsaasstorage.S3Config{
+				Endpoint:        endpoint,
+				Region:          "us-east-1",
+				Bucket:          os.Getenv("VSAAS_STORAGE_TEST_S3_BUCKET"),
+				AccessKeyID:     os.Getenv("VSAAS_STORAGE_TEST_S3_ACCESS_KEY"),
+				SecretAccessKey: os.Getenv("VSAAS_STORAGE_TEST_S3_SECRET_KEY"),
+				ForcePathStyle:  true,
+			},
+		}
+	}
+	return configs
+}
+
+// newTestProvider creates the named provider from a fresh test configuration
+func newTestProvider(t *testing.T, name string) vsaasstorage.StorageProvider {
+	t.Helper()
+	config := testConfigs(t)[name]
+
+	var provider vsaasstorage.StorageProvider
+	var err error
+	switch name {
+	case "filesystem":
+		provider, err = vsaasstorage.NewFileSystemProvider(config)
+	case "memory":
+		provider, err = vsaasstorage.NewMemoryProvider(config)
+	case "s3":
+		provider, err = vsaasstorage.NewS3Provider(config)
+	}
+	if err != nil {
+		t.Fatalf("Failed to create %s provider: %v", name, err)
+	}
+
+	// The bucket is shared between subtests, so each one leaves it empty
+	if name == "s3" {
+		t.Cleanup(func() {
+			ctx := context.Background()
+			files, _ := provider.List(ctx, "")
+			for _, file := range files {
+				if file.IsDirectory {
+					provider.DeleteDirectory(ctx, file.Path)
+				} else {
+					provider.Delete(ctx, file.Path)
+				}
+			}
+		})
+	}
+	return provider
+}
+
+func TestProviderConformance(t *testing.T) {
+	for name := range testConfigs(t) {
+		t.Run(name, func(t *testing.T) {
+			RunProviderTests(t, func(t *testing.T) vsaasstorage.StorageProvider {
+				return newTestProvider(t, name)
+			})
+		})
+	}
+}
+
+func TestMockProviderConformance(t *testing.T) {
+	RunProviderTests(t, func(t *testing.T) vsaasstorage.StorageProvider {
+		return NewMockProvider()
+	})
+}
@@ -0,0 +1,154 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	vsaasstorage "github.com/xompass/vsaas-storage"
+)
+
+// SpecialKey is a path whose characters are easily mangled by URL encoding, and the
+// canonical path every provider must store it as
+type SpecialKey struct {
+	Path      string
+	Canonical string
+}
+
+// SpecialKeys covers "+" read as a space, "%" sequences decoded twice, "#" cut off as a
+// fragment, reserved query characters, non-ASCII names and duplicate slashes. Characters
+// that Windows forbids in file names are left out so the filesystem provider can run it
+// everywhere.
+var SpecialKeys = []SpecialKey{
+	{"100%+done #1.mp4", "100%+done #1.mp4"},
+	{"a+b.txt", "a+b.txt"},
+	{"percent%20literal.txt", "percent%20literal.txt"},
+	{"hash#fragment.txt", "hash#fragment.txt"},
+	{"space name.txt", "space name.txt"},
+	{"amp&eq=semi;.txt", "amp&eq=semi;.txt"},
+	{"tilde~quote'.txt", "tilde~quote'.txt"},
+	{"café.txt", "café.txt"},
+	{"日本語/ファイル.mp4", "日本語/ファイル.mp4"},
+	{"emoji 🎥.mp4", "emoji 🎥.mp4"},
+	{"double//slash.txt", "double/slash.txt"},
+	{"/leading.txt", "leading.txt"},
+}
+
+// keyEncodingDir is the directory RunKeyEncoding works in
+const keyEncodingDir = "keyencoding"
+
+// RunKeyEncoding uploads, lists, downloads, signs and deletes every path of SpecialKeys
+// under a "keyencoding" directory, failing if any of them comes back altered. Running it
+// against several providers checks that they agree on what a path means. Signed URLs are
+// fetched over HTTP when the provider returns one, checked with InspectToken when it
+// returns a token, and skipped when it cannot sign.
+func RunKeyEncoding(t *testing.T, storage *vsaasstorage.Storage) {
+	t.Helper()
+	ctx := context.Background()
+	t.Cleanup(func() {
+		storage.DeleteDirectory(ctx, keyEncodingDir, vsaasstorage.DeleteOptions{Permanent: true})
+	})
+
+	for _, key := range SpecialKeys {
+		filePath := keyEncodingDir + "/" + key.Path
+		canonical := keyEncodingDir + "/" + key.Canonical
+		content := "content of " + key.Canonical
+
+		t.Run(key.Path, func(t *testing.T) {
+			info, err := storage.Upload(ctx, filePath, strings.NewReader(content), nil)
+			if err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+			if info.Path != canonical {
+				t.Errorf("Expected upload at %q, got %q", canonical, info.Path)
+			}
+
+			if info, err := storage.GetInfo(ctx, canonical); err != nil || info.Path != canonical || info.Name != path.Base(canonical) {
+				t.Errorf("Expected info of %q, got %+v, %v", canonical, info, err)
+			}
+
+			reader, _, err := storage.Download(ctx, canonical)
+			if err != nil {
+				t.Fatalf("Download failed: %v", err)
+			}
+			data, _ := io.ReadAll(reader)
+			reader.Close()
+			if string(data) != content {
+				t.Errorf("Expected %q, got %q", content, data)
+			}
+
+			checkSignedURL(t, storage, canonical, content)
+		})
+	}
+
+	t.Run("List", func(t *testing.T) {
+		listed := make(map[string]bool)
+		err := storage.Walk(ctx, keyEncodingDir, func(info *vsaasstorage.FileInfo) error {
+			if !info.IsDirectory {
+				listed[info.Path] = true
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Walk failed: %v", err)
+		}
+		for _, key := range SpecialKeys {
+			if canonical := keyEncodingDir + "/" + key.Canonical; !listed[canonical] {
+				t.Errorf("Expected %q in the listing, got %v", canonical, listed)
+			}
+		}
+		if len(listed) != len(SpecialKeys) {
+			t.Errorf("Expected %d listed files, got %d", len(SpecialKeys), len(listed))
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		for _, key := range SpecialKeys {
+			canonical := keyEncodingDir + "/" + key.Canonical
+			if err := storage.Delete(ctx, keyEncodingDir+"/"+key.Path, vsaasstorage.DeleteOptions{Permanent: true}); err != nil {
+				t.Errorf("Delete of %q failed: %v", key.Path, err)
+			}
+			if exists, _ := storage.Exists(ctx, canonical); exists {
+				t.Errorf("Expected %q to be deleted", canonical)
+			}
+		}
+	})
+}
+
+// checkSignedURL checks that a signed URL of canonical serves content or, for providers
+// issuing tokens, that the token names the same path
+func checkSignedURL(t *testing.T, storage *vsaasstorage.Storage, canonical, content string) {
+	t.Helper()
+	signed, err := storage.GenerateSignedURL(context.Background(), canonical, vsaasstorage.SignedURLOperationGet, time.Minute)
+	if err != nil {
+		t.Logf("Skipping signed URL: %v", err)
+		return
+	}
+
+	if parsed, err := url.Parse(signed); err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") {
+		response, err := http.Get(signed)
+		if err != nil {
+			t.Fatalf("Fetching the signed URL failed: %v", err)
+		}
+		defer response.Body.Close()
+		data, _ := io.ReadAll(response.Body)
+		if response.StatusCode != http.StatusOK || string(data) != content {
+			t.Errorf("Expected %q from the signed URL, got %d %q", content, response.StatusCode, data)
+		}
+		return
+	}
+
+	info, err := storage.InspectToken(signed)
+	if errors.Is(err, vsaasstorage.ErrNotSupported) {
+		return
+	}
+	if err != nil || !info.Valid || info.Path != canonical {
+		t.Errorf("Expected a valid token for %q, got %+v, %v", canonical, info, err)
+	}
+}
@@ -0,0 +1,19 @@
+package storagetest
+
+import (
+	"testing"
+
+	vsaasstorage "github.com/xompass/vsaas-storage"
+)
+
+func TestKeyEncoding(t *testing.T) {
+	for name, config := range testConfigs(t) {
+		t.Run(name, func(t *testing.T) {
+			storage, err := vsaasstorage.New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			RunKeyEncoding(t, storage)
+		})
+	}
+}
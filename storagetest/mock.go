@@ -0,0 +1,381 @@
+// Package storagetest provides helpers for testing code that depends on vsaas-storage.
+package storagetest
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	vsaasstorage "github.com/xompass/vsaas-storage"
+)
+
+// Operation identifies a StorageProvider method
+type Operation string
+
+const (
+	OpUpload            Operation = "upload"
+	OpDownload          Operation = "download"
+	OpDelete            Operation = "delete"
+	OpExists            Operation = "exists"
+	OpGetInfo           Operation = "get_info"
+	OpList              Operation = "list"
+	OpDeleteDirectory   Operation = "delete_directory"
+	OpCopy              Operation = "copy"
+	OpMove              Operation = "move"
+	OpGenerateSignedURL Operation = "generate_signed_url"
+)
+
+// Call records a single provider call made through a MockProvider
+type Call struct {
+	Operation Operation
+	Path      string
+	DstPath   string // Destination for copy and move
+	Err       error
+	Time      time.Time
+}
+
+// pathFailure is a scripted failure for paths matching a glob
+type pathFailure struct {
+	pattern string
+	err     error
+}
+
+// MockProvider implements StorageProvider over an in-memory map and lets tests
+// script failures, add latency and assert on the calls it received.
+type MockProvider struct {
+	store *vsaasstorage.MemoryProvider
+
+	mu           sync.Mutex
+	failNext     map[Operation][]error
+	pathFailures []pathFailure
+	latency      map[Operation]time.Duration
+	calls        []Call
+}
+
+func init() {
+	vsaasstorage.RegisterProvider("mock", func(config *vsaasstorage.StorageConfig) (vsaasstorage.StorageProvider, error) {
+		return NewMockProvider(), nil
+	})
+}
+
+// NewMockProvider creates a new empty mock provider
+func NewMockProvider() *MockProvider {
+	store, _ := vsaasstorage.NewMemoryProvider(nil)
+	return &MockProvider{
+		store:    store,
+		failNext: make(map[Operation][]error),
+		latency:  make(map[Operation]time.Duration),
+	}
+}
+
+// NewMockStorage creates a Storage backed by a new mock provider
+func NewMockStorage(t testing.TB) (*vsaasstorage.Storage, *MockProvider) {
+	t.Helper()
+
+	storage, err := vsaasstorage.New(&vsaasstorage.StorageConfig{
+		Name:     "MockStorage",
+		Provider: "mock",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create mock storage: %v", err)
+	}
+
+	return storage, MockFrom(storage)
+}
+
+// MockFrom returns the mock provider backing a storage created with Provider "mock",
+// or nil when the storage is not backed by one
+func MockFrom(storage *vsaasstorage.Storage) *MockProvider {
+	provider := storage.Provider()
+	for provider != nil {
+		if mock, ok := provider.(*MockProvider); ok {
+			return mock
+		}
+
+		wrapper, ok := provider.(interface {
+			Unwrap() vsaasstorage.StorageProvider
+		})
+		if !ok {
+			return nil
+		}
+		provider = wrapper.Unwrap()
+	}
+	return nil
+}
+
+// FailNext makes the next call of the operation fail with err.
+// Multiple calls queue failures in order.
+func (m *MockProvider) FailNext(op Operation, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failNext[op] = append(m.failNext[op], err)
+}
+
+// FailPathMatching makes every call on a path matching the glob fail with err
+// until Reset is called. Patterns use path.Match syntax and are matched
+// against the path without a leading slash.
+func (m *MockProvider) FailPathMatching(glob string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pathFailures = append(m.pathFailures, pathFailure{pattern: glob, err: err})
+}
+
+// SetLatency adds an artificial delay before every call of the operation
+func (m *MockProvider) SetLatency(op Operation, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latency[op] = latency
+}
+
+// Reset clears scripted failures, latencies and recorded calls. Stored files are kept.
+func (m *MockProvider) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failNext = make(map[Operation][]error)
+	m.pathFailures = nil
+	m.latency = make(map[Operation]time.Duration)
+	m.calls = nil
+}
+
+// Calls returns a copy of the recorded calls
+func (m *MockProvider) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
+
+// CallCount returns how many times the operation was called
+func (m *MockProvider) CallCount(op Operation) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, call := range m.calls {
+		if call.Operation == op {
+			count++
+		}
+	}
+	return count
+}
+
+// AssertCalled fails the test if the operation was never called successfully on the path
+func (m *MockProvider) AssertCalled(t testing.TB, op Operation, filePath string) {
+	t.Helper()
+	if !m.called(op, filePath) {
+		t.Errorf("Expected successful %s call for '%s', got calls: %v", op, filePath, m.Calls())
+	}
+}
+
+// AssertNotCalled fails the test if the operation was called successfully on the path
+func (m *MockProvider) AssertNotCalled(t testing.TB, op Operation, filePath string) {
+	t.Helper()
+	if m.called(op, filePath) {
+		t.Errorf("Expected no successful %s call for '%s'", op, filePath)
+	}
+}
+
+// AssertUploaded fails the test if the path was never uploaded successfully
+func (m *MockProvider) AssertUploaded(t testing.TB, filePath string) {
+	t.Helper()
+	m.AssertCalled(t, OpUpload, filePath)
+}
+
+// AssertNotUploaded fails the test if the path was uploaded successfully
+func (m *MockProvider) AssertNotUploaded(t testing.TB, filePath string) {
+	t.Helper()
+	m.AssertNotCalled(t, OpUpload, filePath)
+}
+
+// AssertDeleted fails the test if the path was never deleted successfully
+func (m *MockProvider) AssertDeleted(t testing.TB, filePath string) {
+	t.Helper()
+	m.AssertCalled(t, OpDelete, filePath)
+}
+
+// called reports whether a successful call of the operation was recorded for the path
+func (m *MockProvider) called(op Operation, filePath string) bool {
+	target := normalize(filePath)
+	for _, call := range m.Calls() {
+		if call.Operation == op && call.Err == nil && normalize(call.Path) == target {
+			return true
+		}
+	}
+	return false
+}
+
+// before applies latency and scripted failures for a call, then fails if ctx is done
+func (m *MockProvider) before(ctx context.Context, op Operation, paths ...string) error {
+	m.mu.Lock()
+	latency := m.latency[op]
+	var err error
+	if queued := m.failNext[op]; len(queued) > 0 {
+		err = queued[0]
+		m.failNext[op] = queued[1:]
+	}
+	if err == nil {
+		err = m.matchPathFailureLocked(paths...)
+	}
+	m.mu.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return vsaasstorage.CanceledError(paths[0], ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	// Like the real providers, the mock does not start work for a canceled request
+	if err == nil && ctx.Err() != nil {
+		err = vsaasstorage.CanceledError(paths[0], ctx.Err())
+	}
+	return err
+}
+
+// matchPathFailureLocked returns the scripted error for the first matching path. Must be called with the lock held.
+func (m *MockProvider) matchPathFailureLocked(paths ...string) error {
+	for _, failure := range m.pathFailures {
+		for _, p := range paths {
+			if matched, _ := path.Match(failure.pattern, normalize(p)); matched {
+				return failure.err
+			}
+		}
+	}
+	return nil
+}
+
+// record stores a call
+func (m *MockProvider) record(op Operation, filePath, dstPath string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, Call{
+		Operation: op,
+		Path:      filePath,
+		DstPath:   dstPath,
+		Err:       err,
+		Time:      time.Now(),
+	})
+}
+
+// Upload stores a file unless a failure is scripted
+func (m *MockProvider) Upload(ctx context.Context, filePath string, reader io.Reader, metadata *vsaasstorage.FileMetadata) (*vsaasstorage.FileInfo, error) {
+	if err := m.before(ctx, OpUpload, filePath); err != nil {
+		m.record(OpUpload, filePath, "", err)
+		return nil, err
+	}
+
+	info, err := m.store.Upload(ctx, filePath, reader, metadata)
+	m.record(OpUpload, filePath, "", err)
+	return info, err
+}
+
+// Download returns a stored file unless a failure is scripted
+func (m *MockProvider) Download(ctx context.Context, filePath string) (io.ReadCloser, *vsaasstorage.FileInfo, error) {
+	if err := m.before(ctx, OpDownload, filePath); err != nil {
+		m.record(OpDownload, filePath, "", err)
+		return nil, nil, err
+	}
+
+	reader, info, err := m.store.Download(ctx, filePath)
+	m.record(OpDownload, filePath, "", err)
+	return reader, info, err
+}
+
+// Delete removes a stored file unless a failure is scripted
+func (m *MockProvider) Delete(ctx context.Context, filePath string) error {
+	err := m.before(ctx, OpDelete, filePath)
+	if err == nil {
+		err = m.store.Delete(ctx, filePath)
+	}
+	m.record(OpDelete, filePath, "", err)
+	return err
+}
+
+// Exists checks a stored path unless a failure is scripted
+func (m *MockProvider) Exists(ctx context.Context, filePath string) (bool, error) {
+	if err := m.before(ctx, OpExists, filePath); err != nil {
+		m.record(OpExists, filePath, "", err)
+		return false, err
+	}
+
+	exists, err := m.store.Exists(ctx, filePath)
+	m.record(OpExists, filePath, "", err)
+	return exists, err
+}
+
+// GetInfo returns information about a stored path unless a failure is scripted
+func (m *MockProvider) GetInfo(ctx context.Context, filePath string) (*vsaasstorage.FileInfo, error) {
+	if err := m.before(ctx, OpGetInfo, filePath); err != nil {
+		m.record(OpGetInfo, filePath, "", err)
+		return nil, err
+	}
+
+	info, err := m.store.GetInfo(ctx, filePath)
+	m.record(OpGetInfo, filePath, "", err)
+	return info, err
+}
+
+// List lists a stored directory unless a failure is scripted
+func (m *MockProvider) List(ctx context.Context, dirPath string) ([]*vsaasstorage.FileInfo, error) {
+	if err := m.before(ctx, OpList, dirPath); err != nil {
+		m.record(OpList, dirPath, "", err)
+		return nil, err
+	}
+
+	files, err := m.store.List(ctx, dirPath)
+	m.record(OpList, dirPath, "", err)
+	return files, err
+}
+
+// DeleteDirectory removes a stored directory unless a failure is scripted
+func (m *MockProvider) DeleteDirectory(ctx context.Context, dirPath string) error {
+	err := m.before(ctx, OpDeleteDirectory, dirPath)
+	if err == nil {
+		err = m.store.DeleteDirectory(ctx, dirPath)
+	}
+	m.record(OpDeleteDirectory, dirPath, "", err)
+	return err
+}
+
+// Copy copies a stored file unless a failure is scripted
+func (m *MockProvider) Copy(ctx context.Context, srcPath, dstPath string) error {
+	err := m.before(ctx, OpCopy, srcPath, dstPath)
+	if err == nil {
+		err = m.store.Copy(ctx, srcPath, dstPath)
+	}
+	m.record(OpCopy, srcPath, dstPath, err)
+	return err
+}
+
+// Move moves a stored file unless a failure is scripted
+func (m *MockProvider) Move(ctx context.Context, srcPath, dstPath string) error {
+	err := m.before(ctx, OpMove, srcPath, dstPath)
+	if err == nil {
+		err = m.store.Move(ctx, srcPath, dstPath)
+	}
+	m.record(OpMove, srcPath, dstPath, err)
+	return err
+}
+
+// GenerateSignedURL returns a fake signed URL unless a failure is scripted
+func (m *MockProvider) GenerateSignedURL(ctx context.Context, filePath string, operation vsaasstorage.SignedURLOperation, expiresIn time.Duration) (string, error) {
+	if err := m.before(ctx, OpGenerateSignedURL, filePath); err != nil {
+		m.record(OpGenerateSignedURL, filePath, "", err)
+		return "", err
+	}
+
+	url := "mock://" + normalize(filePath) + "?op=" + string(operation) + "&expires_in=" + expiresIn.String()
+	m.record(OpGenerateSignedURL, filePath, "", nil)
+	return url, nil
+}
+
+// normalize converts a path into the form used for matching
+func normalize(filePath string) string {
+	return strings.TrimPrefix(path.Clean("/"+filePath), "/")
+}
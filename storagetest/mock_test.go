@@ -0,0 +1,130 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	vsaasstorage "github.com/xompass/vsaas-storage"
+)
+
+func TestMockProvider(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Round trip through New", func(t *testing.T) {
+		storage, mock := NewMockStorage(t)
+		if mock == nil {
+			t.Fatal("Expected mock provider behind storage")
+		}
+
+		if _, err := storage.Upload(ctx, "docs/a.txt", strings.NewReader("hello"), nil); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+
+		reader, info, err := storage.Download(ctx, "docs/a.txt")
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		defer reader.Close()
+
+		content, _ := io.ReadAll(reader)
+		if string(content) != "hello" {
+			t.Errorf("Expected content 'hello', got '%s'", content)
+		}
+
+		if info.ContentType != "text/plain; charset=utf-8" {
+			t.Errorf("Expected text content type, got '%s'", info.ContentType)
+		}
+
+		mock.AssertUploaded(t, "/docs/a.txt")
+		mock.AssertNotUploaded(t, "docs/b.txt")
+	})
+
+	t.Run("FailNext", func(t *testing.T) {
+		storage, mock := NewMockStorage(t)
+		mock.FailNext(OpUpload, vsaasstorage.NewStorageError(vsaasstorage.ErrorCodeUploadFailed, "scripted"))
+
+		_, err := storage.Upload(ctx, "a.txt", strings.NewReader("x"), nil)
+		var storageErr *vsaasstorage.StorageError
+		if !errors.As(err, &storageErr) || storageErr.Code != vsaasstorage.ErrorCodeUploadFailed {
+			t.Fatalf("Expected scripted upload failure, got %v", err)
+		}
+		mock.AssertNotUploaded(t, "a.txt")
+
+		// Only the next call fails
+		if _, err := storage.Upload(ctx, "a.txt", strings.NewReader("x"), nil); err != nil {
+			t.Fatalf("Second upload failed: %v", err)
+		}
+		mock.AssertUploaded(t, "a.txt")
+
+		if mock.CallCount(OpUpload) != 2 {
+			t.Errorf("Expected 2 upload calls, got %d", mock.CallCount(OpUpload))
+		}
+	})
+
+	t.Run("FailPathMatching", func(t *testing.T) {
+		storage, mock := NewMockStorage(t)
+		failure := vsaasstorage.PermissionDeniedError("secret")
+		mock.FailPathMatching("secret/*", failure)
+
+		if _, err := storage.Upload(ctx, "/secret/a.txt", strings.NewReader("x"), nil); !errors.Is(err, failure) {
+			t.Errorf("Expected permission denied, got %v", err)
+		}
+
+		if _, err := storage.Upload(ctx, "public/a.txt", strings.NewReader("x"), nil); err != nil {
+			t.Errorf("Upload outside the failing prefix failed: %v", err)
+		}
+
+		if err := storage.Copy(ctx, "public/a.txt", "secret/b.txt"); !errors.Is(err, failure) {
+			t.Errorf("Expected copy into failing prefix to fail, got %v", err)
+		}
+
+		mock.Reset()
+		if _, err := storage.Upload(ctx, "secret/a.txt", strings.NewReader("x"), nil); err != nil {
+			t.Errorf("Upload after reset failed: %v", err)
+		}
+	})
+
+	t.Run("Latency honors context", func(t *testing.T) {
+		storage, mock := NewMockStorage(t)
+		mock.SetLatency(OpGetInfo, time.Hour)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		if _, err := storage.GetInfo(timeoutCtx, "a.txt"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected deadline exceeded, got %v", err)
+		}
+
+		if time.Since(start) > time.Second {
+			t.Error("Latency did not stop on context cancellation")
+		}
+	})
+
+	t.Run("Call recording", func(t *testing.T) {
+		storage, mock := NewMockStorage(t)
+		storage.Upload(ctx, "a.txt", strings.NewReader("x"), nil)
+		storage.Move(ctx, "a.txt", "b.txt")
+		storage.Delete(ctx, "b.txt")
+		storage.Delete(ctx, "missing.txt")
+
+		calls := mock.Calls()
+		if len(calls) != 4 {
+			t.Fatalf("Expected 4 calls, got %d", len(calls))
+		}
+
+		if calls[1].Operation != OpMove || calls[1].DstPath != "b.txt" {
+			t.Errorf("Unexpected move call: %+v", calls[1])
+		}
+
+		if calls[3].Err == nil {
+			t.Error("Expected failed delete to be recorded with its error")
+		}
+
+		mock.AssertDeleted(t, "b.txt")
+	})
+}
@@ -0,0 +1,41 @@
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	vsaasstorage "github.com/xompass/vsaas-storage"
+)
+
+// EICAR is the standard antivirus test string. Real scanners detect it as a virus, so
+// it exercises the rejection path without handling actual malware.
+const EICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// EICARScanner is a vsaasstorage.Scanner that rejects content containing the EICAR
+// test string and accepts everything else
+type EICARScanner struct{}
+
+// Scan looks for the EICAR string, including across read boundaries
+func (EICARScanner) Scan(ctx context.Context, reader io.Reader, info *vsaasstorage.ScanInfo) error {
+	signature := []byte(EICAR)
+	buf := make([]byte, 32<<10)
+	carry := 0 // Bytes kept from the previous read in case the string spans two reads
+
+	for {
+		n, err := reader.Read(buf[carry:])
+		window := buf[:carry+n]
+		if bytes.Contains(window, signature) {
+			return vsaasstorage.ContentRejectedError(info.Path, "Eicar-Test-Signature")
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		carry = min(len(window), len(signature)-1)
+		copy(buf, window[len(window)-carry:])
+	}
+}
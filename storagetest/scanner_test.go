@@ -0,0 +1,32 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	vsaasstorage "github.com/xompass/vsaas-storage"
+)
+
+func TestEICARScanner(t *testing.T) {
+	ctx := context.Background()
+	info := &vsaasstorage.ScanInfo{Path: "uploads/file.bin"}
+
+	if err := (EICARScanner{}).Scan(ctx, strings.NewReader("harmless content"), info); err != nil {
+		t.Errorf("Expected clean content to pass, got %v", err)
+	}
+
+	infected := strings.Repeat("a", 40000) + EICAR + "trailer"
+	err := (EICARScanner{}).Scan(ctx, strings.NewReader(infected), info)
+	if !errors.Is(err, vsaasstorage.ErrContentRejected) {
+		t.Errorf("Expected ErrContentRejected, got %v", err)
+	}
+
+	// One byte per read splits the signature across every boundary
+	err = (EICARScanner{}).Scan(ctx, iotest.OneByteReader(strings.NewReader(infected)), info)
+	if !errors.Is(err, vsaasstorage.ErrContentRejected) {
+		t.Errorf("Expected ErrContentRejected across reads, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	vsaasstorage "github.com/xompass/vsaas-storage"
+)
+
+// versioningPath is the file RunVersioningTests works on
+const versioningPath = "versioning/plan.json"
+
+// RunVersioningTests checks the versioning API of a storage the same way whether the
+// .versions prefix or the provider's native versioning backs it: the history of
+// overwrites, downloading, restoring and deleting versions, deletes that keep the history
+// and purges that drop it. The storage must have versioning enabled, either through
+// StorageConfig.Versioning or natively, such as an S3 bucket with S3Config.Versioning.
+func RunVersioningTests(t *testing.T, storage *vsaasstorage.Storage) {
+	t.Helper()
+	ctx := context.Background()
+	t.Cleanup(func() {
+		storage.Delete(ctx, versioningPath, vsaasstorage.DeleteOptions{Permanent: true, PurgeVersions: true})
+	})
+
+	for _, content := range []string{"v1", "v2", "v3"} {
+		if _, err := storage.Upload(ctx, versioningPath, strings.NewReader(content), nil); err != nil {
+			t.Fatalf("Upload of %s failed: %v", content, err)
+		}
+	}
+
+	t.Run("History", func(t *testing.T) {
+		versions := listVersions(t, storage)
+		if len(versions) != 2 {
+			t.Fatalf("Expected 2 previous versions, got %d", len(versions))
+		}
+		for i, content := range []string{"v2", "v1"} {
+			if got := downloadVersion(t, storage, versions[i]); got != content {
+				t.Errorf("Expected version %d to hold %q, got %q", i, content, got)
+			}
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		versions := listVersions(t, storage)
+		if err := storage.RestoreVersion(ctx, versioningPath, versionID(versions[len(versions)-1])); err != nil {
+			t.Fatalf("RestoreVersion failed: %v", err)
+		}
+		if got := downloadCurrent(t, storage); got != "v1" {
+			t.Errorf("Expected the restored content, got %q", got)
+		}
+		if restored := listVersions(t, storage); len(restored) != len(versions)+1 {
+			t.Errorf("Expected the replaced content to be kept, got %d versions", len(restored))
+		}
+	})
+
+	t.Run("DeleteVersion", func(t *testing.T) {
+		versions := listVersions(t, storage)
+		deleted := versionID(versions[0])
+		if err := storage.DeleteVersion(ctx, versioningPath, deleted); err != nil {
+			t.Fatalf("DeleteVersion failed: %v", err)
+		}
+		if remaining := listVersions(t, storage); len(remaining) != len(versions)-1 {
+			t.Errorf("Expected %d versions left, got %d", len(versions)-1, len(remaining))
+		}
+		if _, _, err := storage.DownloadVersion(ctx, versioningPath, deleted); !errors.Is(err, vsaasstorage.ErrFileNotFound) {
+			t.Errorf("Expected ErrFileNotFound for the deleted version, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := storage.Delete(ctx, versioningPath, vsaasstorage.DeleteOptions{Permanent: true}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		// Native histories start with the delete marker, which has no content
+		var restorable *vsaasstorage.FileInfo
+		for i, version := range listVersions(t, storage) {
+			if version.Metadata[vsaasstorage.DeleteMarkerMetadataKey] == "true" {
+				if i != 0 {
+					t.Errorf("Expected the delete marker to be the newest entry, got it at %d", i)
+				}
+				if _, _, err := storage.DownloadVersion(ctx, versioningPath, versionID(version)); !errors.Is(err, vsaasstorage.ErrFileNotFound) {
+					t.Errorf("Expected ErrFileNotFound for the delete marker, got %v", err)
+				}
+			} else if restorable == nil {
+				restorable = version
+			}
+		}
+		if restorable == nil {
+			t.Fatal("Expected the history to survive the delete")
+		}
+
+		content := downloadVersion(t, storage, restorable)
+		if err := storage.RestoreVersion(ctx, versioningPath, versionID(restorable)); err != nil {
+			t.Fatalf("RestoreVersion of a deleted file failed: %v", err)
+		}
+		if got := downloadCurrent(t, storage); got != content {
+			t.Errorf("Expected the deleted file back with %q, got %q", content, got)
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		if err := storage.Delete(ctx, versioningPath, vsaasstorage.DeleteOptions{Permanent: true, PurgeVersions: true}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if versions := listVersions(t, storage); len(versions) != 0 {
+			t.Errorf("Expected the history to be purged, got %d versions", len(versions))
+		}
+	})
+}
+
+// listVersions returns the versions of versioningPath, failing the test on error or on
+// entries without a version ID
+func listVersions(t *testing.T, storage *vsaasstorage.Storage) []*vsaasstorage.FileInfo {
+	t.Helper()
+	versions, err := storage.ListVersions(context.Background(), versioningPath)
+	if err != nil {
+		t.Fatalf("ListVersions failed: %v", err)
+	}
+	for _, version := range versions {
+		if versionID(version) == "" {
+			t.Fatalf("Expected a version ID in the metadata of %+v", version)
+		}
+	}
+	return versions
+}
+
+// versionID returns the ID of an entry of ListVersions
+func versionID(version *vsaasstorage.FileInfo) string {
+	return version.Metadata[vsaasstorage.VersionIDMetadataKey]
+}
+
+// downloadVersion returns the content of a version, failing the test on error
+func downloadVersion(t *testing.T, storage *vsaasstorage.Storage, version *vsaasstorage.FileInfo) string {
+	t.Helper()
+	reader, _, err := storage.DownloadVersion(context.Background(), versioningPath, versionID(version))
+	if err != nil {
+		t.Fatalf("DownloadVersion failed: %v", err)
+	}
+	defer reader.Close()
+	data, _ := io.ReadAll(reader)
+	return string(data)
+}
+
+// downloadCurrent returns the current content of versioningPath, failing the test on error
+func downloadCurrent(t *testing.T, storage *vsaasstorage.Storage) string {
+	t.Helper()
+	reader, _, err := storage.Download(context.Background(), versioningPath)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	defer reader.Close()
+	data, _ := io.ReadAll(reader)
+	return string(data)
+}
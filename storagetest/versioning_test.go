@@ -0,0 +1,181 @@
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	vsaasstorage "github.com/xompass/vsaas-storage"
+)
+
+// versionedProvider keeps versions the way a versioned S3 bucket does: every upload is a
+// version, the latest is the current content and deletes add a delete marker
+type versionedProvider struct {
+	vsaasstorage.StorageProvider
+	mu       sync.Mutex
+	next     int
+	versions map[string][]*storedVersion // Oldest first
+}
+
+// storedVersion is a version or delete marker of versionedProvider
+type storedVersion struct {
+	id           string
+	content      []byte
+	deleteMarker bool
+	modTime      time.Time
+}
+
+func newVersionedProvider(config *vsaasstorage.StorageConfig) (vsaasstorage.StorageProvider, error) {
+	store, err := vsaasstorage.NewMemoryProvider(config)
+	return &versionedProvider{StorageProvider: store, versions: make(map[string][]*storedVersion)}, err
+}
+
+// add records a new latest version of a path
+func (p *versionedProvider) add(path string, version *storedVersion) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.next++
+	version.id, version.modTime = strconv.Itoa(p.next), time.Now()
+	p.versions[path] = append(p.versions[path], version)
+}
+
+// find returns a version of a path that holds content
+func (p *versionedProvider) find(path, versionID string) *storedVersion {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, version := range p.versions[path] {
+		if version.id == versionID && !version.deleteMarker {
+			return version
+		}
+	}
+	return nil
+}
+
+func (p *versionedProvider) Upload(ctx context.Context, path string, reader io.Reader, metadata *vsaasstorage.FileMetadata) (*vsaasstorage.FileInfo, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	info, err := p.StorageProvider.Upload(ctx, path, bytes.NewReader(content), metadata)
+	if err == nil {
+		p.add(path, &storedVersion{content: content})
+	}
+	return info, err
+}
+
+func (p *versionedProvider) Delete(ctx context.Context, path string) error {
+	err := p.StorageProvider.Delete(ctx, path)
+	if err == nil {
+		p.add(path, &storedVersion{deleteMarker: true})
+	}
+	return err
+}
+
+func (p *versionedProvider) NativeVersioning() bool {
+	return true
+}
+
+func (p *versionedProvider) ListVersions(ctx context.Context, path string) ([]*vsaasstorage.FileInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	versions := p.versions[path]
+	var infos []*vsaasstorage.FileInfo
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+		if i == len(versions)-1 && !version.deleteMarker {
+			continue // The current content
+		}
+		metadata := map[string]string{vsaasstorage.VersionIDMetadataKey: version.id}
+		if version.deleteMarker {
+			metadata[vsaasstorage.DeleteMarkerMetadataKey] = "true"
+		}
+		infos = append(infos, &vsaasstorage.FileInfo{Path: path, Name: version.id, Size: int64(len(version.content)), LastModified: &version.modTime, Metadata: metadata})
+	}
+	return infos, nil
+}
+
+func (p *versionedProvider) DownloadVersion(ctx context.Context, path, versionID string) (io.ReadCloser, *vsaasstorage.FileInfo, error) {
+	version := p.find(path, versionID)
+	if version == nil {
+		return nil, nil, vsaasstorage.FileNotFoundError(path)
+	}
+	return io.NopCloser(bytes.NewReader(version.content)), &vsaasstorage.FileInfo{Path: path, Name: version.id, Size: int64(len(version.content))}, nil
+}
+
+func (p *versionedProvider) RestoreVersion(ctx context.Context, path, versionID string) error {
+	version := p.find(path, versionID)
+	if version == nil {
+		return vsaasstorage.FileNotFoundError(path)
+	}
+	_, err := p.Upload(ctx, path, bytes.NewReader(version.content), nil)
+	return err
+}
+
+func (p *versionedProvider) DeleteVersion(ctx context.Context, path, versionID string) error {
+	p.mu.Lock()
+	versions := p.versions[path]
+	i := slices.IndexFunc(versions, func(version *storedVersion) bool { return version.id == versionID })
+	if i < 0 {
+		p.mu.Unlock()
+		return vsaasstorage.FileNotFoundError(path)
+	}
+	versions = slices.Delete(versions, i, i+1)
+	p.versions[path] = versions
+	p.mu.Unlock()
+
+	// Deleting the latest entry makes the one before it current
+	if i < len(versions) {
+		return nil
+	}
+	if len(versions) == 0 || versions[len(versions)-1].deleteMarker {
+		return p.StorageProvider.Delete(ctx, path)
+	}
+	_, err := p.StorageProvider.Upload(ctx, path, bytes.NewReader(versions[len(versions)-1].content), nil)
+	return err
+}
+
+func TestVersioning(t *testing.T) {
+	configs := testConfigs(t)
+	delete(configs, "s3") // The .versions prefix is checked on the local providers
+
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			config.Versioning = &vsaasstorage.VersioningConfig{Enabled: true}
+			storage, err := vsaasstorage.New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			RunVersioningTests(t, storage)
+		})
+	}
+
+	t.Run("native", func(t *testing.T) {
+		vsaasstorage.RegisterProvider("versioned", newVersionedProvider)
+		storage, err := vsaasstorage.New(&vsaasstorage.StorageConfig{Name: "versioned", Provider: "versioned"})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		RunVersioningTests(t, storage)
+	})
+
+	// Native versioning runs against a MinIO or S3 bucket with versioning enabled, e.g.
+	// "mc version enable local/versioned" with VSAAS_STORAGE_TEST_S3_VERSIONED_BUCKET=versioned
+	bucket := os.Getenv("VSAAS_STORAGE_TEST_S3_VERSIONED_BUCKET")
+	if config := testConfigs(t)["s3"]; config != nil && bucket != "" {
+		t.Run("s3", func(t *testing.T) {
+			config.S3.Bucket = bucket
+			config.S3.Versioning = true
+			storage, err := vsaasstorage.New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			RunVersioningTests(t, storage)
+		})
+	}
+}
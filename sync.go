@@ -0,0 +1,178 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+)
+
+// SyncOptions controls SyncTo
+type SyncOptions struct {
+	DeleteExtraneous bool // Delete destination files that do not exist in the source
+	DryRun           bool // Report the plan without modifying the destination
+}
+
+// SyncReport summarizes a sync. In dry-run mode it lists what would be done.
+type SyncReport struct {
+	Copied  []string          `json:"copied"`
+	Deleted []string          `json:"deleted"`
+	Skipped int               `json:"skipped"`
+	Bytes   int64             `json:"bytes"`
+	Failed  []TransferFailure `json:"failed,omitempty"`
+}
+
+// SyncTo makes the files under prefix in dst match this storage, copying new and changed
+// files and optionally deleting extraneous ones. Files are compared by size and ETag, or by
+// modification time when either side does not report a comparable ETag (a multipart
+// ETag is not a content hash). Directories are processed one
+// at a time in lexical order, merging the listings of both sides with Iterate, so memory
+// use is bounded by a batch of entries per directory level rather than the whole tree
+// and repeated runs visit files in the same order.
+func (s *Storage) SyncTo(ctx context.Context, dst *Storage, prefix string, opts SyncOptions) (*SyncReport, error) {
+	if err := dst.checkWritable(prefix); err != nil && !opts.DryRun {
+		return nil, err
+	}
+
+	report := &SyncReport{Copied: []string{}, Deleted: []string{}}
+
+	// The source prefix must exist; a missing destination is simply empty
+	entries := s.Iterate(ctx, prefix, ListOptions{})
+	_, err := entries.Next()
+	entries.Close()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if err := s.syncDirectory(ctx, dst, prefix, opts, report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// syncDirectory syncs one directory level and recurses into subdirectories
+func (s *Storage) syncDirectory(ctx context.Context, dst *Storage, dir string, opts SyncOptions, report *SyncReport) error {
+	if err := checkContext(ctx, dir); err != nil {
+		return err
+	}
+
+	srcEntries := s.Iterate(ctx, dir, ListOptions{})
+	defer srcEntries.Close()
+	dstEntries := dst.Iterate(ctx, dir, ListOptions{AllowMissing: true})
+	defer dstEntries.Close()
+
+	// Merge both listings, sorted by name
+	srcEntry, err := nextEntry(srcEntries)
+	if err != nil {
+		report.fail(dir, err)
+		return nil
+	}
+	dstEntry, err := nextEntry(dstEntries)
+	for err == nil && (srcEntry != nil || dstEntry != nil) {
+		switch {
+		case dstEntry == nil || (srcEntry != nil && srcEntry.Name < dstEntry.Name):
+			if err := s.syncEntry(ctx, dst, srcEntry, nil, opts, report); err != nil {
+				return err
+			}
+			srcEntry, err = nextEntry(srcEntries)
+		case srcEntry == nil || dstEntry.Name < srcEntry.Name:
+			if opts.DeleteExtraneous {
+				dst.syncDelete(ctx, dstEntry, opts, report)
+			}
+			dstEntry, err = nextEntry(dstEntries)
+		default:
+			if err := s.syncEntry(ctx, dst, srcEntry, dstEntry, opts, report); err != nil {
+				return err
+			}
+			if srcEntry, err = nextEntry(srcEntries); err == nil {
+				dstEntry, err = nextEntry(dstEntries)
+			}
+		}
+	}
+	if err != nil {
+		report.fail(dir, err)
+	}
+	return nil
+}
+
+// nextEntry returns the next entry of a listing, or nil after the last one
+func nextEntry(entries *FileIterator) (*FileInfo, error) {
+	entry, err := entries.Next()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	return entry, err
+}
+
+// syncEntry brings a destination entry in line with a source entry. dstEntry is nil when
+// the destination does not have it.
+func (s *Storage) syncEntry(ctx context.Context, dst *Storage, srcEntry, dstEntry *FileInfo, opts SyncOptions, report *SyncReport) error {
+	if dstEntry != nil && srcEntry.IsDirectory != dstEntry.IsDirectory {
+		report.fail(srcEntry.Path, NewStorageErrorWithPath(ErrorCodeInvalidPath, "file and directory conflict", srcEntry.Path))
+		return nil
+	}
+
+	if srcEntry.IsDirectory {
+		return s.syncDirectory(ctx, dst, srcEntry.Path, opts, report)
+	}
+
+	if dstEntry != nil && !fileChanged(srcEntry, dstEntry) {
+		report.Skipped++
+		return nil
+	}
+
+	if !opts.DryRun {
+		if _, _, err := s.TransferTo(ctx, dst, srcEntry.Path, srcEntry.Path, TransferOptions{}); err != nil {
+			report.fail(srcEntry.Path, err)
+			return nil
+		}
+	}
+
+	report.Copied = append(report.Copied, srcEntry.Path)
+	report.Bytes += srcEntry.Size
+	return nil
+}
+
+// syncDelete removes an extraneous destination entry
+func (s *Storage) syncDelete(ctx context.Context, entry *FileInfo, opts SyncOptions, report *SyncReport) {
+	if !opts.DryRun {
+		var err error
+		if entry.IsDirectory {
+			err = s.DeleteDirectory(ctx, entry.Path)
+		} else {
+			err = s.Delete(ctx, entry.Path)
+		}
+		if err != nil {
+			report.fail(entry.Path, err)
+			return
+		}
+	}
+
+	report.Deleted = append(report.Deleted, entry.Path)
+}
+
+// fail records a failed path in the report
+func (r *SyncReport) fail(path string, err error) {
+	r.Failed = append(r.Failed, TransferFailure{Path: path, Error: err.Error(), Err: err})
+}
+
+// fileChanged reports whether the source file differs from the destination file
+func fileChanged(src, dst *FileInfo) bool {
+	if src.Size != dst.Size {
+		return true
+	}
+	if comparableETags(src.ETag, dst.ETag) {
+		return NormalizeETag(src.ETag) != NormalizeETag(dst.ETag)
+	}
+	if src.LastModified != nil && dst.LastModified != nil {
+		return src.LastModified.After(*dst.LastModified)
+	}
+	return true
+}
+
+// sortByName sorts entries in lexical order of their names
+func sortByName(entries []*FileInfo) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+}
@@ -0,0 +1,75 @@
+package vsaasstorage
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSyncTo(t *testing.T) {
+	ctx := context.Background()
+	fs, mem := newTransferStorages(t)
+
+	for path, content := range map[string]string{
+		"nas/a.mp4":     "a",
+		"nas/b.mp4":     "b-changed",
+		"nas/sub/c.mp4": "c",
+		"nas/same.mp4":  "same",
+	} {
+		mem.Upload(ctx, path, strings.NewReader(content), nil)
+	}
+	for path, content := range map[string]string{
+		"nas/b.mp4":         "b",
+		"nas/same.mp4":      "same",
+		"nas/extra.mp4":     "x",
+		"nas/old/stale.mp4": "x",
+	} {
+		fs.Upload(ctx, path, strings.NewReader(content), nil)
+	}
+
+	opts := SyncOptions{DeleteExtraneous: true, DryRun: true}
+	plan, err := mem.SyncTo(ctx, fs, "nas", opts)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+
+	expectedCopied := []string{"nas/a.mp4", "nas/b.mp4", "nas/sub/c.mp4"}
+	expectedDeleted := []string{"nas/extra.mp4", "nas/old"}
+	if !reflect.DeepEqual(plan.Copied, expectedCopied) {
+		t.Errorf("Expected copies %v, got %v", expectedCopied, plan.Copied)
+	}
+	if !reflect.DeepEqual(plan.Deleted, expectedDeleted) {
+		t.Errorf("Expected deletions %v, got %v", expectedDeleted, plan.Deleted)
+	}
+	if plan.Skipped != 1 {
+		t.Errorf("Expected 1 skipped file, got %d", plan.Skipped)
+	}
+	if exists, _ := fs.Exists(ctx, "nas/a.mp4"); exists {
+		t.Fatal("Dry run must not modify the destination")
+	}
+
+	opts.DryRun = false
+	report, err := mem.SyncTo(ctx, fs, "nas", opts)
+	if err != nil {
+		t.Fatalf("SyncTo failed: %v", err)
+	}
+	if !reflect.DeepEqual(report.Copied, plan.Copied) || !reflect.DeepEqual(report.Deleted, plan.Deleted) {
+		t.Errorf("Expected the run to match the plan, got %+v", report)
+	}
+
+	for _, path := range []string{"nas/extra.mp4", "nas/old/stale.mp4"} {
+		if exists, _ := fs.Exists(ctx, path); exists {
+			t.Errorf("Expected %s to be deleted", path)
+		}
+	}
+
+	// A second run has nothing left to do
+	report, err = mem.SyncTo(ctx, fs, "nas", opts)
+	if err != nil {
+		t.Fatalf("SyncTo failed: %v", err)
+	}
+	if len(report.Copied) != 0 || len(report.Deleted) != 0 || report.Skipped != 4 {
+		t.Errorf("Expected an idempotent second run, got %+v", report)
+	}
+}
@@ -0,0 +1,201 @@
+package vsaasstorage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	rest "github.com/xompass/vsaas-rest"
+	"github.com/xompass/vsaas-rest/http_errors"
+)
+
+// TokenFailure names the check a signed token failed. It is the cause of the errors
+// returned by ValidateSignedToken:
+//
+//	var failure vsaasstorage.TokenFailure
+//	if errors.As(err, &failure) && failure == vsaasstorage.TokenFailureExpired { ... }
+type TokenFailure string
+
+const (
+	TokenFailureMalformed TokenFailure = "malformed" // Not a JWT or missing claims
+	TokenFailureSignature TokenFailure = "signature" // Signed with another key or algorithm
+	TokenFailureExpired   TokenFailure = "expired"
+	TokenFailurePath      TokenFailure = "path_mismatch"
+	TokenFailureOperation TokenFailure = "operation_mismatch"
+	TokenFailureKeyScope  TokenFailure = "key_scope" // Outside the operations or paths of its signing key
+)
+
+// Error implements the error interface
+func (f TokenFailure) Error() string {
+	return "token check failed: " + string(f)
+}
+
+// TokenInfo describes a signed token for debugging. Claims are decoded even when the
+// signature is invalid, so they must not be trusted unless Valid is true.
+type TokenInfo struct {
+	Valid     bool                   `json:"valid"`
+	Failure   TokenFailure           `json:"failure,omitempty"` // First check that failed
+	Reason    string                 `json:"reason,omitempty"`  // Human-readable detail of the failure
+	Claims    map[string]interface{} `json:"claims,omitempty"`
+	Path      string                 `json:"path,omitempty"`
+	Prefix    string                 `json:"prefix,omitempty"` // Set for tokens valid for a whole directory, such as HLS streams
+	Operation string                 `json:"operation,omitempty"`
+	Subject   string                 `json:"subject,omitempty"`
+	KeyName   string                 `json:"key,omitempty"` // Signing key named in the kid header, empty for SecretKey
+	IssuedAt  *time.Time             `json:"issued_at,omitempty"`
+	ExpiresAt *time.Time             `json:"expires_at,omitempty"`
+}
+
+// fail records the first failed check
+func (i *TokenInfo) fail(failure TokenFailure, reason string) {
+	if i.Valid {
+		i.Valid, i.Failure, i.Reason = false, failure, reason
+	}
+}
+
+// err returns the error ValidateSignedToken reports for the token, or nil if it is valid
+func (i *TokenInfo) err() error {
+	if i.Valid {
+		return nil
+	}
+	if i.Failure == TokenFailureExpired {
+		return NewStorageErrorWithCause(ErrorCodeTokenExpired, "token has expired", i.Failure)
+	}
+	return NewStorageErrorWithCause(ErrorCodeInvalidToken, i.Reason, i.Failure)
+}
+
+// InspectToken decodes a signed token and reports whether its signature and expiry are
+// valid, without granting access to anything. The path and operation are not checked;
+// use TokenInfoHandler with path and operation for that. Only the filesystem provider
+// issues tokens; other providers fail with ErrNotSupported.
+func (s *Storage) InspectToken(tokenString string) (*TokenInfo, error) {
+	provider, ok := providerAs[*FileSystemProvider](s.provider)
+	if !ok {
+		return nil, NotSupportedError("provider does not issue signed tokens")
+	}
+	return provider.inspectToken(tokenString, nil)
+}
+
+// TokenInfoHandler creates a handler function returning the decoded claims of a token
+// and the check it fails, for support engineers. It must be mounted behind admin auth.
+// Query parameters: token, and optionally path and operation to check a request.
+func (s *Storage) TokenInfoHandler() func(c *rest.EndpointContext) error {
+	return s.traced(func(c *rest.EndpointContext) error {
+		token := c.EchoCtx.QueryParam("token")
+		if token == "" {
+			return http_errors.BadRequestError("Token is required")
+		}
+
+		provider, ok := providerAs[*FileSystemProvider](s.provider)
+		if !ok {
+			return httpError(NotSupportedError("provider does not issue signed tokens"), "Failed to inspect token")
+		}
+
+		var target *tokenTarget
+		if path := c.EchoCtx.QueryParam("path"); path != "" {
+			target = &tokenTarget{path: path, operation: SignedURLOperation(c.EchoCtx.QueryParam("operation"))}
+		}
+		info, err := provider.inspectToken(token, target)
+		if err != nil {
+			return httpError(err, "Failed to inspect token")
+		}
+		return c.JSON(info)
+	})
+}
+
+// tokenRejected returns the 401 for a token that failed validation, with the failed
+// check in the message when VerboseTokenErrors is set
+func (s *Storage) tokenRejected(err error) error {
+	var failure TokenFailure
+	if s.config.VerboseTokenErrors && errors.As(err, &failure) {
+		return http_errors.UnauthorizedError(fmt.Sprintf("Invalid or expired token (%s)", string(failure)))
+	}
+	return http_errors.UnauthorizedError("Invalid or expired token")
+}
+
+// tokenTarget is the request a token is checked against
+type tokenTarget struct {
+	path      string
+	operation SignedURLOperation // Not checked when empty
+}
+
+// inspectToken runs every check of ValidateSignedToken, recording the first that fails.
+// The path and operation are only checked against a target. It fails only when tokens
+// cannot be validated at all.
+func (p *FileSystemProvider) inspectToken(tokenString string, target *tokenTarget) (*TokenInfo, error) {
+	signedConfig := p.config.GetSignedURLConfig()
+	if !signedConfig.Enabled {
+		return nil, NewStorageError(ErrorCodeSignedURLFailed, "signed URLs are not enabled")
+	}
+
+	if signedConfig.SecretKey == "" && len(signedConfig.Keys) == 0 {
+		return nil, NewStorageError(ErrorCodeSignedURLFailed, "secret key is required for signed URLs")
+	}
+
+	info := &TokenInfo{Valid: true}
+	claims := jwt.MapClaims{}
+	parsed, _, err := jwt.NewParser().ParseUnverified(tokenString, claims)
+	if err != nil {
+		info.fail(TokenFailureMalformed, "invalid token: "+err.Error())
+		return info, nil
+	}
+	info.Claims = claims
+	info.KeyName, _ = parsed.Header["kid"].(string)
+	info.Path, _ = claims["path"].(string)
+	info.Prefix, _ = claims["prefix"].(string)
+	info.Operation, _ = claims["op"].(string)
+	info.Subject, _ = claims.GetSubject()
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		info.IssuedAt = &iat.Time
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		info.ExpiresAt = &exp.Time
+	}
+
+	// Expiry is checked separately so it is not reported as a bad signature. The key is
+	// the one named in the kid header, so a token is held to the scope of its own key.
+	secret, key, keyErr := signedConfig.signingKey(info.KeyName)
+	_, err = jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		return []byte(secret), nil
+	}, jwt.WithoutClaimsValidation())
+	if err != nil {
+		info.fail(TokenFailureSignature, "invalid token: "+err.Error())
+	}
+
+	if info.ExpiresAt != nil && p.config.now().After(*info.ExpiresAt) {
+		info.fail(TokenFailureExpired, "token has expired")
+	}
+
+	if !p.tokenInScope(key, claims) {
+		info.fail(TokenFailureKeyScope, "token is outside the scope of its signing key")
+	}
+
+	if target == nil {
+		return info, nil
+	}
+
+	// Validate path, or the prefix of a prefix token. Both sides are compared in the same
+	// form, whatever service minted the token and however the request spelled the path.
+	path := p.config.tokenPath(target.path)
+	if _, ok := claims["prefix"].(string); ok {
+		// The root prefix would open the whole storage, so no token may claim it
+		if prefix := p.config.tokenPath(info.Prefix); prefix == "" || !isWithinPrefix(path, prefix) {
+			info.fail(TokenFailurePath, "token prefix does not match requested path")
+		}
+	} else if _, ok := claims["path"].(string); !ok || p.config.tokenPath(info.Path) != path {
+		info.fail(TokenFailurePath, "token path does not match requested path")
+	}
+
+	if target.operation != "" && info.Operation != string(target.operation) {
+		info.fail(TokenFailureOperation, "token operation does not match requested operation")
+	}
+
+	return info, nil
+}
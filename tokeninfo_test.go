@@ -0,0 +1,167 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestInspectToken(t *testing.T) {
+	ctx := context.Background()
+	clock := newManualClock()
+	storage, err := New(&StorageConfig{
+		Name:       "test",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+		SignedURL:  &SignedURLConfig{Enabled: true, SecretKey: "secret"},
+		Clock:      clock,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	provider, _ := providerAs[*FileSystemProvider](storage.provider)
+
+	expired, _ := storage.GenerateSignedURL(ctx, "cameras/1/clip.mp4", SignedURLOperationGet, time.Minute)
+	clock.Advance(2 * time.Minute)
+	token, err := storage.GenerateSignedURL(ctx, "cameras/1/clip.mp4", SignedURLOperationGet, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateSignedURL failed: %v", err)
+	}
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"path": "cameras/1/clip.mp4", "op": "GET"}).SignedString([]byte("other"))
+
+	info, err := storage.InspectToken(token)
+	if err != nil || !info.Valid || info.Path != "cameras/1/clip.mp4" || info.Operation != "GET" || info.ExpiresAt == nil {
+		t.Fatalf("Expected a valid token with its claims, got %+v, %v", info, err)
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		path      string
+		operation SignedURLOperation
+		failure   TokenFailure
+		code      ErrorCode
+	}{
+		{"Malformed", "not-a-token", "cameras/1/clip.mp4", SignedURLOperationGet, TokenFailureMalformed, ErrorCodeInvalidToken},
+		{"Signature", forged, "cameras/1/clip.mp4", SignedURLOperationGet, TokenFailureSignature, ErrorCodeInvalidToken},
+		{"Expired", expired, "cameras/1/clip.mp4", SignedURLOperationGet, TokenFailureExpired, ErrorCodeTokenExpired},
+		{"Path", token, "cameras/2/clip.mp4", SignedURLOperationGet, TokenFailurePath, ErrorCodeInvalidToken},
+		{"Operation", token, "cameras/1/clip.mp4", SignedURLOperationDelete, TokenFailureOperation, ErrorCodeInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := provider.ValidateSignedToken(tt.token, tt.path, tt.operation)
+			var failure TokenFailure
+			var storageErr *StorageError
+			if !errors.As(err, &failure) || failure != tt.failure || !errors.As(err, &storageErr) || storageErr.Code != tt.code {
+				t.Errorf("Expected %s failure with %s, got %v", tt.failure, tt.code, err)
+			}
+		})
+	}
+
+	t.Run("Handler", func(t *testing.T) {
+		e := echo.New()
+		query := url.Values{"token": {token}, "path": {"cameras/2/clip.mp4"}, "operation": {"GET"}}
+		request := httptest.NewRequest(http.MethodGet, "/tokens?"+query.Encode(), nil)
+		recorder := httptest.NewRecorder()
+		if err := storage.TokenInfoHandler()(&rest.EndpointContext{EchoCtx: e.NewContext(request, recorder)}); err != nil {
+			t.Fatalf("Token info handler failed: %v", err)
+		}
+		var info TokenInfo
+		json.Unmarshal(recorder.Body.Bytes(), &info)
+		if info.Valid || info.Failure != TokenFailurePath || info.Path != "cameras/1/clip.mp4" {
+			t.Errorf("Unexpected token info %s", recorder.Body.String())
+		}
+	})
+
+	t.Run("VerboseErrors", func(t *testing.T) {
+		err := provider.ValidateSignedToken(expired, "cameras/1/clip.mp4", SignedURLOperationGet)
+		if message := storage.tokenRejected(err).Error(); strings.Contains(message, "expired)") {
+			t.Errorf("Expected no detail by default, got %q", message)
+		}
+		storage.config.VerboseTokenErrors = true
+		if message := storage.tokenRejected(err).Error(); !strings.Contains(message, "(expired)") {
+			t.Errorf("Expected the failed check in the message, got %q", message)
+		}
+	})
+}
+
+func TestSignedTokenPathForms(t *testing.T) {
+	ctx := context.Background()
+	for _, legacy := range []bool{false, true} {
+		storage, err := New(&StorageConfig{
+			Name:        "test",
+			Provider:    "filesystem",
+			FileSystem:  &FileSystemConfig{BasePath: t.TempDir()},
+			SignedURL:   &SignedURLConfig{Enabled: true, SecretKey: "secret"},
+			LegacyPaths: legacy,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		provider, _ := providerAs[*FileSystemProvider](storage.provider)
+
+		forms := []string{"signed/test.txt", "/signed/test.txt", "signed//test.txt", "./signed/test.txt", "signed/./test.txt", "signed/test.txt/"}
+		for _, minted := range forms {
+			// Tokens minted by another service keep the path as it was given
+			token, _ := provider.signToken(jwt.MapClaims{"path": minted, "op": "GET", "exp": time.Now().Add(time.Hour).Unix()}, "")
+			for _, requested := range forms {
+				if err := provider.ValidateSignedToken(token, requested, SignedURLOperationGet); err != nil {
+					t.Errorf("legacy=%v: token for %q refused for %q: %v", legacy, minted, requested, err)
+				}
+			}
+		}
+
+		token, _ := storage.GenerateSignedURL(ctx, "/signed//test.txt", SignedURLOperationGet, time.Hour)
+		if info, _ := storage.InspectToken(token); info.Path != "signed/test.txt" {
+			t.Errorf("legacy=%v: expected the canonical path in the claim, got %q", legacy, info.Path)
+		}
+		for _, other := range []string{"signed/test.txt.bak", "signed/other/../test.txt", "other/signed/test.txt", "signed%2Ftest.txt"} {
+			if err := provider.ValidateSignedToken(token, other, SignedURLOperationGet); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("legacy=%v: expected the token to be refused for %q, got %v", legacy, other, err)
+			}
+		}
+	}
+}
+
+func TestRequestPathUnescaped(t *testing.T) {
+	storage, err := New(&StorageConfig{
+		Name:       "test",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+		SignedURL:  &SignedURLConfig{Enabled: true, SecretKey: "secret"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	ctx := context.Background()
+	storage.Upload(ctx, "signed/test file.txt", strings.NewReader("content"), nil)
+	token, _ := storage.GenerateSignedURL(ctx, "signed/test file.txt", SignedURLOperationGet, time.Hour)
+
+	// A router matching /files/*path with an escaped slash passes the parameter escaped
+	e := echo.New()
+	e.GET("/files/*", func(c echo.Context) error {
+		path := c.Param("*")
+		c.SetParamNames("path")
+		c.SetParamValues(path)
+		return storage.DownloadHandler()(&rest.EndpointContext{EchoCtx: c})
+	})
+	for _, target := range []string{"/files/signed/test%20file.txt", "/files/signed%2Ftest%20file.txt", "/files//signed//test%20file.txt"} {
+		request := httptest.NewRequest(http.MethodGet, target+"?token="+url.QueryEscape(token), nil)
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != "content" {
+			t.Errorf("%s: expected the file, got %d %q", target, recorder.Code, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// defaultTransferConcurrency is the number of parallel transfers used by TransferDirectoryTo
+const defaultTransferConcurrency = 4
+
+// TransferOptions controls cross-storage transfers
+type TransferOptions struct {
+	SkipIfSameETag bool                   // Skip files that already exist at the destination with the same ETag
+	Concurrency    int                    // Parallel transfers in TransferDirectoryTo, defaults to 4
+	Progress       func(TransferProgress) // Called after each file of TransferDirectoryTo, never concurrently
+}
+
+// TransferProgress reports the state of a directory transfer
+type TransferProgress struct {
+	FilesTotal int   `json:"files_total"`
+	FilesDone  int   `json:"files_done"`
+	BytesDone  int64 `json:"bytes_done"`
+}
+
+// TransferFailure describes a file that could not be transferred
+type TransferFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+	Err   error  `json:"-"`
+}
+
+// TransferReport summarizes a directory transfer
+type TransferReport struct {
+	Transferred int               `json:"transferred"`
+	Skipped     int               `json:"skipped"`
+	Bytes       int64             `json:"bytes"`
+	Failed      []TransferFailure `json:"failed,omitempty"`
+}
+
+// TransferTo streams a single file to another storage, preserving its content type and metadata.
+// It reports whether the file was skipped because the destination already had the same content.
+func (s *Storage) TransferTo(ctx context.Context, dst *Storage, srcPath, dstPath string, opts TransferOptions) (*FileInfo, bool, error) {
+	if err := dst.checkWritable(dstPath); err != nil {
+		return nil, false, err
+	}
+
+	if opts.SkipIfSameETag {
+		same, err := s.sameContent(ctx, dst, srcPath, dstPath)
+		if err != nil {
+			return nil, false, err
+		}
+		if same {
+			info, err := dst.GetInfo(ctx, dstPath)
+			return info, true, err
+		}
+	}
+
+	reader, info, err := s.Download(ctx, srcPath)
+	if err != nil {
+		return nil, false, err
+	}
+	defer reader.Close()
+
+	metadata := &FileMetadata{
+		ContentType:    info.ContentType,
+		CustomMetadata: info.Metadata,
+		ExpiresAt:      info.ExpiresAt,
+	}
+
+	uploaded, err := dst.Upload(ctx, dstPath, reader, metadata)
+	if err != nil {
+		return nil, false, err
+	}
+	return uploaded, false, nil
+}
+
+// TransferDirectoryTo copies every file under srcDir to dstDir in another storage,
+// running up to opts.Concurrency transfers at a time. Failed files do not stop the
+// transfer; they are listed in the report and summarized in the returned error.
+func (s *Storage) TransferDirectoryTo(ctx context.Context, dst *Storage, srcDir, dstDir string, opts TransferOptions) (*TransferReport, error) {
+	if err := dst.checkWritable(dstDir); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	err := s.walk(ctx, srcDir, allEntries, func(info *FileInfo) error {
+		if !info.IsDirectory {
+			paths = append(paths, info.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultTransferConcurrency
+	}
+
+	report := &TransferReport{}
+	progress := TransferProgress{FilesTotal: len(paths)}
+	var mu sync.Mutex
+
+	// Failures are collected in the report, so the executor only reports cancellation
+	err = NewParallelExecutor(concurrency).Run(ctx, paths, func(ctx context.Context, filePath string) error {
+		dstPath := path.Join(dstDir, relativePath(srcDir, filePath))
+		info, skipped, err := s.TransferTo(ctx, dst, filePath, dstPath, opts)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case err != nil:
+			report.Failed = append(report.Failed, TransferFailure{Path: filePath, Error: err.Error(), Err: err})
+		case skipped:
+			report.Skipped++
+		default:
+			report.Transferred++
+			report.Bytes += info.Size
+			progress.BytesDone += info.Size
+		}
+
+		progress.FilesDone++
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	if len(report.Failed) > 0 {
+		return report, NewStorageErrorWithCause(ErrorCodeProviderError,
+			fmt.Sprintf("%d of %d files failed to transfer", len(report.Failed), len(paths)),
+			report.Failed[0].Err)
+	}
+	return report, nil
+}
+
+// sameContent reports whether the destination already holds the same content as the source
+func (s *Storage) sameContent(ctx context.Context, dst *Storage, srcPath, dstPath string) (bool, error) {
+	dstInfo, err := dst.GetInfo(ctx, dstPath)
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	srcInfo, err := s.GetInfo(ctx, srcPath)
+	if err != nil {
+		return false, err
+	}
+	if srcInfo.Size != dstInfo.Size {
+		return false, nil
+	}
+
+	srcETag, err := s.etag(ctx, srcInfo)
+	if err != nil {
+		return false, err
+	}
+	dstETag, err := dst.etag(ctx, dstInfo)
+	if err != nil {
+		return false, err
+	}
+	return srcETag == dstETag, nil
+}
+
+// etag returns the normalized ETag of a file, hashing its content when the provider
+// does not report one or reports a multipart ETag
+func (s *Storage) etag(ctx context.Context, info *FileInfo) (string, error) {
+	if info.ETag != "" && !isMultipartETag(info.ETag) {
+		return NormalizeETag(info.ETag), nil
+	}
+	return s.computeETag(ctx, info.Path)
+}
+
+// relativePath returns filePath relative to dir
+func relativePath(dir, filePath string) string {
+	clean := cleanPath(filePath)
+	if isRootPath(dir) {
+		return clean
+	}
+	return strings.TrimPrefix(clean, cleanPath(dir)+"/")
+}
@@ -0,0 +1,144 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTransferStorages(t *testing.T) (*Storage, *Storage) {
+	t.Helper()
+
+	fs, err := New(&StorageConfig{
+		Name:       "FileSystemStorage",
+		Provider:   "filesystem",
+		FileSystem: &FileSystemConfig{BasePath: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem storage: %v", err)
+	}
+
+	mem, err := New(&StorageConfig{Name: "MemoryStorage", Provider: "memory"})
+	if err != nil {
+		t.Fatalf("Failed to create memory storage: %v", err)
+	}
+	return fs, mem
+}
+
+func TestTransferTo(t *testing.T) {
+	ctx := context.Background()
+	fs, mem := newTransferStorages(t)
+
+	mem.Upload(ctx, "a.bin", strings.NewReader("payload"), &FileMetadata{
+		ContentType:    "video/mp4",
+		CustomMetadata: map[string]string{"camera": "1"},
+	})
+
+	t.Run("Memory to filesystem", func(t *testing.T) {
+		info, skipped, err := mem.TransferTo(ctx, fs, "a.bin", "clips/a.bin", TransferOptions{})
+		if err != nil || skipped {
+			t.Fatalf("TransferTo failed: %v (skipped %v)", err, skipped)
+		}
+		if info.ContentType != "video/mp4" || info.Size != 7 {
+			t.Errorf("Unexpected transferred info %+v", info)
+		}
+
+		reader, _, err := fs.Download(ctx, "clips/a.bin")
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		defer reader.Close()
+		content, _ := io.ReadAll(reader)
+		if string(content) != "payload" {
+			t.Errorf("Unexpected content '%s'", content)
+		}
+	})
+
+	t.Run("Filesystem to memory", func(t *testing.T) {
+		_, skipped, err := fs.TransferTo(ctx, mem, "clips/a.bin", "back.bin", TransferOptions{})
+		if err != nil || skipped {
+			t.Fatalf("TransferTo failed: %v (skipped %v)", err, skipped)
+		}
+		if exists, _ := mem.Exists(ctx, "back.bin"); !exists {
+			t.Error("Expected file at the destination")
+		}
+	})
+
+	t.Run("Skip same content", func(t *testing.T) {
+		_, skipped, err := mem.TransferTo(ctx, fs, "a.bin", "clips/a.bin", TransferOptions{SkipIfSameETag: true})
+		if err != nil || !skipped {
+			t.Errorf("Expected identical file to be skipped, got skipped=%v, %v", skipped, err)
+		}
+	})
+
+	t.Run("Missing source", func(t *testing.T) {
+		if _, _, err := mem.TransferTo(ctx, fs, "missing.bin", "x.bin", TransferOptions{}); !errors.Is(err, ErrFileNotFound) {
+			t.Errorf("Expected ErrFileNotFound, got %v", err)
+		}
+	})
+}
+
+func TestTransferDirectoryTo(t *testing.T) {
+	ctx := context.Background()
+	fs, mem := newTransferStorages(t)
+
+	paths := []string{"tenant/a.mp4", "tenant/b.mp4", "tenant/sub/c.mp4", "other/d.mp4"}
+	for _, path := range paths {
+		fs.Upload(ctx, path, strings.NewReader("content of "+path), nil)
+	}
+
+	var progress []TransferProgress
+	report, err := fs.TransferDirectoryTo(ctx, mem, "tenant", "migrated", TransferOptions{
+		Concurrency: 2,
+		Progress: func(p TransferProgress) {
+			progress = append(progress, p)
+		},
+	})
+	if err != nil {
+		t.Fatalf("TransferDirectoryTo failed: %v", err)
+	}
+
+	if report.Transferred != 3 || report.Skipped != 0 || len(report.Failed) != 0 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if len(progress) != 3 || progress[2].FilesDone != 3 || progress[2].FilesTotal != 3 || progress[2].BytesDone != report.Bytes {
+		t.Errorf("Unexpected progress %+v", progress)
+	}
+
+	for _, path := range []string{"migrated/a.mp4", "migrated/b.mp4", "migrated/sub/c.mp4"} {
+		if exists, _ := mem.Exists(ctx, path); !exists {
+			t.Errorf("Expected %s at the destination", path)
+		}
+	}
+
+	t.Run("Resume skips transferred files", func(t *testing.T) {
+		fs.Upload(ctx, "tenant/new.mp4", strings.NewReader("new"), nil)
+
+		report, err := fs.TransferDirectoryTo(ctx, mem, "tenant", "migrated", TransferOptions{SkipIfSameETag: true})
+		if err != nil {
+			t.Fatalf("TransferDirectoryTo failed: %v", err)
+		}
+		if report.Transferred != 1 || report.Skipped != 3 {
+			t.Errorf("Expected 1 transferred and 3 skipped, got %+v", report)
+		}
+	})
+
+	t.Run("Failures are aggregated", func(t *testing.T) {
+		mem.SetRetention(ctx, "migrated/a.mp4", time.Now().Add(time.Hour))
+		fs.Upload(ctx, "tenant/a.mp4", strings.NewReader("changed"), nil)
+
+		report, err := fs.TransferDirectoryTo(ctx, mem, "tenant", "migrated", TransferOptions{SkipIfSameETag: true})
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		if len(report.Failed) != 1 || report.Failed[0].Path != "tenant/a.mp4" || !errors.Is(report.Failed[0].Err, ErrRetentionLocked) {
+			t.Errorf("Unexpected failures %+v", report.Failed)
+		}
+		if report.Skipped != 3 {
+			t.Errorf("Expected the other files to be skipped, got %+v", report)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// StorageClassMetadataKey is the metadata key under which providers with storage classes
+// report the class of a file in FileInfo.Metadata
+const StorageClassMetadataKey = "vsaas-storage-class"
+
+// S3 storage classes accepted by TransitionStorageClass. Other S3-compatible services may
+// define their own names, which are passed through unchanged.
+const (
+	StorageClassStandard           = "STANDARD"
+	StorageClassStandardIA         = "STANDARD_IA"
+	StorageClassOneZoneIA          = "ONEZONE_IA"
+	StorageClassIntelligentTiering = "INTELLIGENT_TIERING"
+	StorageClassGlacierIR          = "GLACIER_IR"
+	StorageClassGlacier            = "GLACIER"
+	StorageClassDeepArchive        = "DEEP_ARCHIVE"
+)
+
+// StorageClassProvider is implemented by providers whose objects have a storage class
+// that can be changed after upload, such as S3
+type StorageClassProvider interface {
+	// SetStorageClass moves a file to another storage class, keeping its content and metadata
+	SetStorageClass(ctx context.Context, path, class string) error
+}
+
+// TransitionOptions tunes TransitionStorageClass
+type TransitionOptions struct {
+	DryRun      bool // Report the matching files without changing them
+	Concurrency int  // Transitions in flight, StorageConfig.Concurrency if not set
+}
+
+// TransitionReport counts what TransitionStorageClass found and changed
+type TransitionReport struct {
+	Supported    bool  `json:"supported"`     // False when the provider has no storage classes and nothing was done
+	DryRun       bool  `json:"dry_run"`       // Nothing was changed, Transitioned is always 0
+	Scanned      int   `json:"scanned"`       // Files under the prefix
+	Matched      int   `json:"matched"`       // Files old enough and not in the target class yet
+	MatchedBytes int64 `json:"matched_bytes"` // Total size of the matched files
+	Transitioned int   `json:"transitioned"`  // Files moved to the target class
+	Failed       int   `json:"failed"`        // Files whose transition failed, detailed in the returned *MultiError
+}
+
+// TransitionStorageClass moves every file under prefix last modified more than olderThan
+// ago to targetClass, such as StorageClassStandardIA for footage that is rarely watched
+// after a month. Files already in targetClass are skipped. Transitions run in parallel
+// and a failed file does not stop the others: the report is returned together with a
+// *MultiError listing the failures. Providers without storage classes return a report
+// with Supported set to false and change nothing.
+//
+// On S3 the transition is a copy of the object onto itself, which resets its last
+// modification time, so the same files do not match again on the next run.
+func (s *Storage) TransitionStorageClass(ctx context.Context, prefix string, olderThan time.Duration, targetClass string, opts ...TransitionOptions) (*TransitionReport, error) {
+	if targetClass == "" {
+		return nil, NewStorageError(ErrorCodeInvalidConfig, "target storage class is required")
+	}
+	if err := s.checkWritable(prefix); err != nil {
+		return nil, err
+	}
+
+	var options TransitionOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	report := &TransitionReport{DryRun: options.DryRun}
+
+	provider, ok := providerAs[StorageClassProvider](s.provider)
+	if !ok {
+		return report, nil
+	}
+	report.Supported = true
+
+	cutoff := s.config.now().Add(-olderThan)
+
+	var matched []string
+	err := s.walk(ctx, prefix, allEntries, func(info *FileInfo) error {
+		if info.IsDirectory {
+			return nil
+		}
+		report.Scanned++
+		if info.LastModified == nil || !info.LastModified.Before(cutoff) {
+			return nil
+		}
+		if info.Metadata[StorageClassMetadataKey] == targetClass {
+			return nil
+		}
+		report.Matched++
+		report.MatchedBytes += info.Size
+		matched = append(matched, info.Path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if options.DryRun || len(matched) == 0 {
+		return report, nil
+	}
+
+	concurrency := options.Concurrency
+	if concurrency < 1 {
+		concurrency = s.config.Concurrency
+	}
+
+	var transitioned int64
+	err = NewParallelExecutor(concurrency).Run(ctx, matched, func(ctx context.Context, filePath string) error {
+		if err := provider.SetStorageClass(ctx, filePath, targetClass); err != nil {
+			return err
+		}
+		atomic.AddInt64(&transitioned, 1)
+		s.journalCurrent(ctx, JournalOperationMetadata, filePath, "")
+		return nil
+	})
+	report.Transitioned = int(transitioned)
+	var multi *MultiError
+	if errors.As(err, &multi) {
+		report.Failed = multi.Len()
+	}
+
+	s.config.incCounter("storage_transitioned_total", transitioned, map[string]string{
+		"storage": s.config.Name,
+		"class":   targetClass,
+	})
+
+	return report, err
+}
+
+// StartTransitionWorker runs TransitionStorageClass in the background every interval,
+// with the same jitter as StartExpirationWorker, moving the files under prefix older than
+// olderThan to targetClass. It stops when ctx is cancelled.
+func (s *Storage) StartTransitionWorker(ctx context.Context, interval time.Duration, prefix string, olderThan time.Duration, targetClass string, opts ...TransitionOptions) {
+	go runEvery(ctx, s.config.clock(), interval, func() {
+		report, err := s.TransitionStorageClass(ctx, prefix, olderThan, targetClass, opts...)
+		if err != nil && ctx.Err() == nil {
+			fields := map[string]interface{}{
+				"storage": s.config.Name,
+				"prefix":  prefix,
+				"class":   targetClass,
+				"error":   err.Error(),
+			}
+			if report != nil {
+				fields["transitioned"] = report.Transitioned
+				fields["failed"] = report.Failed
+			}
+			s.config.log(ctx, LogLevelError, "storage class transition failed", fields)
+			return
+		}
+
+		if report != nil && report.Transitioned > 0 {
+			s.config.log(ctx, LogLevelInfo, "storage class transitioned", map[string]interface{}{
+				"storage":      s.config.Name,
+				"prefix":       prefix,
+				"class":        targetClass,
+				"transitioned": report.Transitioned,
+			})
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tieringProvider keeps a storage class per file and reports old modification times for
+// the files in modified
+type tieringProvider struct {
+	StorageProvider
+	mu       sync.Mutex
+	classes  map[string]string
+	modified map[string]time.Time
+	failing  map[string]bool
+}
+
+func (p *tieringProvider) describe(info *FileInfo) *FileInfo {
+	if info == nil || info.IsDirectory {
+		return info
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if modified, ok := p.modified[info.Path]; ok {
+		info.LastModified = &modified
+	}
+	class := p.classes[info.Path]
+	if class == "" {
+		class = StorageClassStandard
+	}
+	info.Metadata = withMetadata(info.Metadata, StorageClassMetadataKey, class)
+	return info
+}
+
+func (p *tieringProvider) GetInfo(ctx context.Context, path string) (*FileInfo, error) {
+	info, err := p.StorageProvider.GetInfo(ctx, path)
+	return p.describe(info), err
+}
+
+func (p *tieringProvider) List(ctx context.Context, path string) ([]*FileInfo, error) {
+	entries, err := p.StorageProvider.List(ctx, path)
+	for _, entry := range entries {
+		p.describe(entry)
+	}
+	return entries, err
+}
+
+func (p *tieringProvider) SetStorageClass(ctx context.Context, path, class string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failing[path] {
+		return ArchivedError(path, nil)
+	}
+	p.classes[path] = class
+	return nil
+}
+
+func TestTransitionStorageClass(t *testing.T) {
+	ctx := context.Background()
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	provider := &tieringProvider{
+		classes: map[string]string{"clips/moved.mp4": StorageClassStandardIA},
+		modified: map[string]time.Time{
+			"clips/a.mp4":      old,
+			"clips/b.mp4":      old,
+			"clips/moved.mp4":  old,
+			"clips/frozen.mp4": old,
+			"other/c.mp4":      old,
+		},
+		failing: map[string]bool{"clips/frozen.mp4": true},
+	}
+	RegisterProvider("tiering", func(config *StorageConfig) (StorageProvider, error) {
+		inner, err := NewMemoryProvider(config)
+		provider.StorageProvider = inner
+		return provider, err
+	})
+	storage, err := New(&StorageConfig{Name: "test", Provider: "tiering"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	for _, path := range []string{"clips/a.mp4", "clips/b.mp4", "clips/moved.mp4", "clips/frozen.mp4", "clips/new.mp4", "other/c.mp4"} {
+		storage.Upload(ctx, path, strings.NewReader("footage"), nil)
+	}
+	if !storage.Capabilities().StorageClasses {
+		t.Error("Expected the storage classes capability")
+	}
+
+	t.Run("DryRun", func(t *testing.T) {
+		report, err := storage.TransitionStorageClass(ctx, "clips", 30*24*time.Hour, StorageClassStandardIA, TransitionOptions{DryRun: true})
+		if err != nil {
+			t.Fatalf("TransitionStorageClass failed: %v", err)
+		}
+		if !report.Supported || report.Scanned != 5 || report.Matched != 3 || report.MatchedBytes != 21 || report.Transitioned != 0 {
+			t.Errorf("Unexpected dry run report: %+v", report)
+		}
+		if class := provider.classes["clips/a.mp4"]; class != "" {
+			t.Errorf("Expected the dry run to change nothing, got %q", class)
+		}
+	})
+
+	t.Run("Transition", func(t *testing.T) {
+		report, err := storage.TransitionStorageClass(ctx, "clips", 30*24*time.Hour, StorageClassStandardIA, TransitionOptions{Concurrency: 2})
+		var multi *MultiError
+		if !errors.As(err, &multi) || multi.Len() != 1 || !errors.Is(multi.Errors["clips/frozen.mp4"], ErrArchived) {
+			t.Fatalf("Expected the archived file to fail alone, got %v", err)
+		}
+		if report.Matched != 3 || report.Transitioned != 2 || report.Failed != 1 {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+		for path, want := range map[string]string{
+			"clips/a.mp4":   StorageClassStandardIA,
+			"clips/b.mp4":   StorageClassStandardIA,
+			"clips/new.mp4": "",
+			"other/c.mp4":   "",
+		} {
+			if got := provider.classes[path]; got != want {
+				t.Errorf("Expected %s in class %q, got %q", path, want, got)
+			}
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		if _, err := storage.TransitionStorageClass(ctx, "clips", time.Hour, ""); err == nil {
+			t.Error("Expected a transition without a target class to be refused")
+		}
+	})
+}
+
+func TestTransitionStorageClassUnsupported(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir()}})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.Upload(ctx, "clips/a.mp4", strings.NewReader("footage"), nil)
+
+	report, err := storage.TransitionStorageClass(ctx, "clips", 0, StorageClassGlacier)
+	if err != nil {
+		t.Fatalf("Expected no error without storage classes, got %v", err)
+	}
+	if report.Supported || report.Matched != 0 {
+		t.Errorf("Expected an unsupported no-op report, got %+v", report)
+	}
+	if storage.Capabilities().StorageClasses {
+		t.Error("Expected no storage classes capability on the filesystem")
+	}
+}
@@ -0,0 +1,221 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"time"
+)
+
+// trashPrefix is the directory that holds trashed files
+const trashPrefix = ".trash"
+
+// pathTimestampFormat names trash batches and versions so they sort chronologically
+const pathTimestampFormat = "20060102T150405.000000000Z"
+
+// TrashConfig contains configuration for soft deletes
+type TrashConfig struct {
+	Enabled bool `json:"enabled"` // Move deleted files into the trash instead of removing them
+}
+
+// trashEnabled reports whether deletes go to the trash
+func (s *Storage) trashEnabled() bool {
+	return s.config.Trash != nil && s.config.Trash.Enabled
+}
+
+// isTrashPath reports whether the path is inside the trash area
+func isTrashPath(p string) bool {
+	clean := cleanPath(p)
+	return clean == trashPrefix || strings.HasPrefix(clean, trashPrefix+"/")
+}
+
+// trashFile moves a file into a new trash batch and returns its trashed path
+func (s *Storage) trashFile(ctx context.Context, filePath string) (string, error) {
+	trashedPath := path.Join(trashPrefix, s.config.now().UTC().Format(pathTimestampFormat), cleanPath(filePath))
+	if err := s.provider.Move(ctx, filePath, trashedPath); err != nil {
+		return "", err
+	}
+	return trashedPath, nil
+}
+
+// trashDirectory moves every file of a directory into a single trash batch and removes the directory
+func (s *Storage) trashDirectory(ctx context.Context, dirPath string) error {
+	batch := path.Join(trashPrefix, s.config.now().UTC().Format(pathTimestampFormat))
+
+	var files []string
+	err := s.walk(ctx, dirPath, allEntries, func(info *FileInfo) error {
+		if !info.IsDirectory {
+			files = append(files, info.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	moved := 0
+	for _, file := range files {
+		err := s.provider.Move(ctx, file, path.Join(batch, cleanPath(file)))
+		if err != nil && !errors.Is(err, ErrRetentionLocked) {
+			return err
+		}
+		if err == nil {
+			moved++
+		}
+	}
+
+	// Only empty directories and retained files remain at this point; the provider
+	// reports the latter through DirectoryRetentionError. Providers with implicit
+	// directories, like memory, have none left once every file was moved.
+	err = s.provider.DeleteDirectory(ctx, dirPath)
+	if moved > 0 && errors.Is(err, ErrDirectoryNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Restore moves a trashed file or directory back to its original location.
+// trashedPath is a path inside the trash, e.g. ".trash/<timestamp>/docs/report.pdf".
+func (s *Storage) Restore(ctx context.Context, trashedPath string) error {
+	if err := s.checkWritable(trashedPath); err != nil {
+		return err
+	}
+
+	clean := cleanPath(trashedPath)
+	parts := strings.SplitN(clean, "/", 3)
+	if len(parts) < 3 || parts[0] != trashPrefix {
+		return NewStorageErrorWithPath(ErrorCodeInvalidPath, "path is not inside the trash", trashedPath)
+	}
+	if _, err := time.Parse(pathTimestampFormat, parts[1]); err != nil {
+		return NewStorageErrorWithPath(ErrorCodeInvalidPath, "path is not inside the trash", trashedPath)
+	}
+
+	info, err := s.provider.GetInfo(ctx, clean)
+	if err != nil {
+		return err
+	}
+
+	batch := path.Join(parts[0], parts[1])
+	if !info.IsDirectory {
+		return s.restoreFile(ctx, clean, parts[2])
+	}
+
+	var files []string
+	err = s.walk(ctx, clean, allEntries, func(info *FileInfo) error {
+		if !info.IsDirectory {
+			files = append(files, cleanPath(info.Path))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := s.restoreFile(ctx, file, strings.TrimPrefix(file, batch+"/")); err != nil {
+			return err
+		}
+	}
+
+	if err := s.provider.DeleteDirectory(ctx, clean); err != nil && !errors.Is(err, ErrDirectoryNotFound) {
+		return err
+	}
+	return nil
+}
+
+// restoreFile moves a single trashed file back unless its original path is taken
+func (s *Storage) restoreFile(ctx context.Context, trashedPath, originalPath string) error {
+	exists, err := s.provider.Exists(ctx, originalPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return FileAlreadyExistsError(originalPath)
+	}
+
+	// Trashed files were given back to the quota, so they are charged again
+	var size int64
+	prefix := ""
+	if s.quota != nil {
+		info, err := s.provider.GetInfo(ctx, trashedPath)
+		if err != nil {
+			return err
+		}
+		size, prefix = info.Size, s.config.Quota.prefix(originalPath)
+		if err := s.quota.Reserve(prefix, size); err != nil {
+			return err
+		}
+	}
+
+	if err := s.provider.Move(ctx, trashedPath, originalPath); err != nil {
+		if s.quota != nil {
+			s.quota.Commit(prefix, size, 0)
+		}
+		return err
+	}
+	if s.quota != nil {
+		if err := s.quota.Commit(prefix, size, size); err != nil {
+			return err
+		}
+	}
+	s.journalCurrent(ctx, JournalOperationUpload, originalPath, "")
+	s.invalidateDerived(ctx, originalPath, false)
+	return nil
+}
+
+// PurgeTrash permanently deletes trash batches older than the given age and returns how many were removed.
+// It is safe to run concurrently: batches removed by another purge are skipped.
+func (s *Storage) PurgeTrash(ctx context.Context, olderThan time.Duration) (int, error) {
+	var purged int
+	err := s.audited(ctx, AuditEntry{Operation: AuditOperationPurgeTrash, Path: trashPrefix}, func(entry *AuditEntry) error {
+		var err error
+		purged, err = s.purgeTrash(ctx, olderThan)
+		entry.Count = purged
+		return err
+	})
+	return purged, err
+}
+
+// purgeTrash implements PurgeTrash
+func (s *Storage) purgeTrash(ctx context.Context, olderThan time.Duration) (int, error) {
+	if err := s.checkWritable(trashPrefix); err != nil {
+		return 0, err
+	}
+
+	batches, err := s.provider.List(ctx, trashPrefix)
+	if err != nil {
+		if errors.Is(err, ErrDirectoryNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	cutoff := s.config.now().UTC().Add(-olderThan)
+	purged := 0
+	for _, batch := range batches {
+		if err := checkContext(ctx, ""); err != nil {
+			return purged, err
+		}
+
+		if !batch.IsDirectory {
+			continue
+		}
+
+		deletedAt, err := time.Parse(pathTimestampFormat, batch.Name)
+		if err != nil || deletedAt.After(cutoff) {
+			continue
+		}
+
+		err = s.provider.DeleteDirectory(ctx, path.Join(trashPrefix, batch.Name))
+		if err != nil {
+			if errors.Is(err, ErrDirectoryNotFound) {
+				continue
+			}
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,153 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTrashStorage(t *testing.T, provider string) *Storage {
+	t.Helper()
+
+	config := &StorageConfig{
+		Name:     "TrashStorage",
+		Provider: provider,
+		Trash:    &TrashConfig{Enabled: true},
+	}
+	if provider == "filesystem" {
+		config.FileSystem = &FileSystemConfig{BasePath: t.TempDir()}
+	}
+	storage, err := New(config)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	return storage
+}
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Delete and restore file", func(t *testing.T) {
+		storage := newTrashStorage(t, "filesystem")
+		if _, err := storage.Upload(ctx, "docs/a.txt", strings.NewReader("hello"), nil); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+
+		if err := storage.Delete(ctx, "docs/a.txt"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		if exists, _ := storage.Exists(ctx, "docs/a.txt"); exists {
+			t.Fatal("Expected file to be gone from its original location")
+		}
+
+		root, err := storage.List(ctx, "")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		for _, entry := range root {
+			if entry.Name == trashPrefix {
+				t.Error("Expected trash to be hidden from the root listing")
+			}
+		}
+
+		batches, err := storage.ListWithOptions(ctx, trashPrefix, ListOptions{IncludeTrash: true})
+		if err != nil || len(batches) != 1 {
+			t.Fatalf("Expected one trash batch, got %v, %v", batches, err)
+		}
+
+		trashed := trashPrefix + "/" + batches[0].Name + "/docs/a.txt"
+		if err := storage.Restore(ctx, trashed); err != nil {
+			t.Fatalf("Restore failed: %v", err)
+		}
+
+		if exists, _ := storage.Exists(ctx, "docs/a.txt"); !exists {
+			t.Error("Expected file to be restored")
+		}
+	})
+
+	t.Run("Restore conflict", func(t *testing.T) {
+		storage := newTrashStorage(t, "filesystem")
+		storage.Upload(ctx, "a.txt", strings.NewReader("old"), nil)
+		storage.Delete(ctx, "a.txt")
+		storage.Upload(ctx, "a.txt", strings.NewReader("new"), nil)
+
+		batches, _ := storage.ListWithOptions(ctx, trashPrefix, ListOptions{IncludeTrash: true})
+		if len(batches) != 1 {
+			t.Fatalf("Expected one trash batch, got %d", len(batches))
+		}
+
+		err := storage.Restore(ctx, trashPrefix+"/"+batches[0].Name+"/a.txt")
+		if !errors.Is(err, ErrFileAlreadyExists) {
+			t.Errorf("Expected ErrFileAlreadyExists, got %v", err)
+		}
+	})
+
+	for _, provider := range []string{"filesystem", "memory"} {
+		t.Run("Directory round trip/"+provider, func(t *testing.T) {
+			storage := newTrashStorage(t, provider)
+			for _, path := range []string{"dir/a.txt", "dir/sub/b.txt"} {
+				storage.Upload(ctx, path, strings.NewReader(path), nil)
+			}
+
+			if err := storage.DeleteDirectory(ctx, "dir"); err != nil {
+				t.Fatalf("DeleteDirectory failed: %v", err)
+			}
+			if exists, _ := storage.Exists(ctx, "dir"); exists {
+				t.Fatal("Expected directory to be removed")
+			}
+
+			batches, _ := storage.ListWithOptions(ctx, trashPrefix, ListOptions{IncludeTrash: true})
+			if len(batches) != 1 {
+				t.Fatalf("Expected one trash batch, got %d", len(batches))
+			}
+
+			if err := storage.Restore(ctx, trashPrefix+"/"+batches[0].Name+"/dir"); err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+
+			for _, path := range []string{"dir/a.txt", "dir/sub/b.txt"} {
+				if exists, _ := storage.Exists(ctx, path); !exists {
+					t.Errorf("Expected %s to be restored", path)
+				}
+			}
+		})
+	}
+
+	t.Run("Permanent delete", func(t *testing.T) {
+		storage := newTrashStorage(t, "filesystem")
+		storage.Upload(ctx, "a.txt", strings.NewReader("x"), nil)
+
+		if err := storage.Delete(ctx, "a.txt", DeleteOptions{Permanent: true}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		if exists, _ := storage.Exists(ctx, trashPrefix); exists {
+			t.Error("Expected permanent delete to bypass the trash")
+		}
+	})
+
+	t.Run("PurgeTrash", func(t *testing.T) {
+		storage := newTrashStorage(t, "filesystem")
+		storage.Upload(ctx, "a.txt", strings.NewReader("x"), nil)
+		storage.Delete(ctx, "a.txt")
+
+		purged, err := storage.PurgeTrash(ctx, time.Hour)
+		if err != nil || purged != 0 {
+			t.Fatalf("Expected recent batches to be kept, got %d, %v", purged, err)
+		}
+
+		purged, err = storage.PurgeTrash(ctx, 0)
+		if err != nil || purged != 1 {
+			t.Fatalf("Expected one batch purged, got %d, %v", purged, err)
+		}
+
+		// A second run finds nothing left to purge
+		purged, err = storage.PurgeTrash(ctx, 0)
+		if err != nil || purged != 0 {
+			t.Errorf("Expected nothing to purge, got %d, %v", purged, err)
+		}
+	})
+}
@@ -2,11 +2,16 @@ package vsaasstorage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	rest "github.com/xompass/vsaas-rest"
 )
@@ -84,10 +89,10 @@ func TestUploadFromUploadedFile(t *testing.T) {
 		}
 
 		// Verify file was uploaded correctly (use the actual generated path)
-		expectedPathPrefix := "/documents/uploaded-file_"
+		expectedPathPrefix := "documents/uploaded-file_"
 		expectedPathSuffix := ".txt"
 		if !strings.HasPrefix(result.Path, expectedPathPrefix) || !strings.HasSuffix(result.Path, expectedPathSuffix) {
-			t.Errorf("Expected path to follow pattern '/documents/uploaded-file_*.txt', got '%s'", result.Path)
+			t.Errorf("Expected path to follow pattern 'documents/uploaded-file_*.txt', got '%s'", result.Path)
 		}
 
 		// Verify file exists and content is correct (use the actual path from result)
@@ -137,10 +142,11 @@ func TestUploadFromUploadedFile(t *testing.T) {
 			expectedPrefix string
 			expectedSuffix string
 		}{
-			{"Without trailing slash", "/docs", "/docs/test_", ".txt"},
-			{"With trailing slash", "/docs/", "/docs/test_", ".txt"},
-			{"Root directory", "/", "/test_", ".txt"},
-			{"Multiple levels", "/users/123/documents", "/users/123/documents/test_", ".txt"},
+			{"Without trailing slash", "/docs", "docs/test_", ".txt"},
+			{"With trailing slash", "/docs/", "docs/test_", ".txt"},
+			{"Root directory", "/", "test_", ".txt"},
+			{"Multiple levels", "/users/123/documents", "users/123/documents/test_", ".txt"},
+			{"Relative", "docs", "docs/test_", ".txt"},
 		}
 
 		for _, tc := range testCases {
@@ -477,3 +483,300 @@ func TestDuplicateFilenameHandling(t *testing.T) {
 		}
 	})
 }
+
+func TestUploadPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{Name: "test", Provider: "memory"})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	newFile := func(name string) *rest.UploadedFile {
+		path := filepath.Join(tmpDir, name)
+		os.WriteFile(path, []byte(name), 0644)
+		return &rest.UploadedFile{Path: path, Filename: name, OriginalName: name, MimeType: "text/plain"}
+	}
+	missing := &rest.UploadedFile{Path: filepath.Join(tmpDir, "missing.txt"), Filename: "missing.txt", OriginalName: "missing.txt"}
+	request := map[string][]*rest.UploadedFile{
+		"documents": {newFile("a.txt"), missing},
+		"photo":     {newFile("b.txt")},
+	}
+
+	t.Run("Partial", func(t *testing.T) {
+		results, err := storage.uploadFiles(ctx, request, "partial", UploadOptions{})
+		var uploadErr *UploadError
+		if !errors.As(err, &uploadErr) || len(uploadErr.Failures) != 1 || uploadErr.Failures[0].OriginalName != "missing.txt" {
+			t.Fatalf("Expected an UploadError for the missing file, got %v", err)
+		}
+		var storageErr *StorageError
+		if !errors.As(err, &storageErr) || storageErr.Code != ErrorCodeUploadFailed {
+			t.Errorf("Expected the cause of the failure to be reachable, got %v", err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("Expected the other 2 files to be stored, got %d", len(results))
+		}
+
+		body, status := uploadResponse(results, uploadErr, "")
+		files := body["files"].([]interface{})
+		if status != http.StatusOK || len(files) != 3 || body["failed"] != 1 {
+			t.Errorf("Expected 200 with 3 entries, got %d %v", status, body)
+		}
+		failure := files[2].(failedFileStatus)
+		if failure.Status != http.StatusBadRequest || failure.Code != ErrorCodeUploadFailed || failure.FieldName != "documents" {
+			t.Errorf("Unexpected failure entry %+v", failure)
+		}
+	})
+
+	t.Run("AllFailed", func(t *testing.T) {
+		results, err := storage.uploadFiles(ctx, map[string][]*rest.UploadedFile{"documents": {missing}}, "failed", UploadOptions{})
+		var uploadErr *UploadError
+		if !errors.As(err, &uploadErr) || len(results) != 0 {
+			t.Fatalf("Expected every file to fail, got %v, %v", results, err)
+		}
+		if _, status := uploadResponse(results, uploadErr, ""); status != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", status)
+		}
+	})
+
+	t.Run("Atomic", func(t *testing.T) {
+		results, err := storage.uploadFiles(ctx, request, "atomic", UploadOptions{Atomic: true})
+		var uploadErr *UploadError
+		if err == nil || errors.As(err, &uploadErr) || results != nil {
+			t.Fatalf("Expected a single error and no results, got %v, %v", results, err)
+		}
+		if files, _ := storage.ListWithOptions(ctx, "atomic", ListOptions{AllowMissing: true}); len(files) != 0 {
+			t.Errorf("Expected the stored files to be removed, got %d", len(files))
+		}
+	})
+}
+
+// recordingLogger keeps the messages logged by the storage
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Log(ctx context.Context, level LogLevel, message string, fields map[string]interface{}) {
+	l.messages = append(l.messages, message)
+}
+
+func TestUploadSignedURL(t *testing.T) {
+	ctx := context.Background()
+	storage, err := New(&StorageConfig{
+		Name:          "test",
+		Provider:      "filesystem",
+		FileSystem:    &FileSystemConfig{BasePath: t.TempDir()},
+		SignedURL:     &SignedURLConfig{Enabled: true, SecretKey: "secret", ExpiresIn: time.Hour},
+		PublicBaseURL: "https://cdn.example.com",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	result, err := storage.UploadFromUploadedFileWithOptions(ctx, writeTestVideo(t), "file", "clips", UploadOptions{
+		IncludeSignedURL:   true,
+		SignedURLExpiresIn: 10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	provider, _ := providerAs[*FileSystemProvider](storage.provider)
+	if err := provider.ValidateSignedToken(result.SignedURL, result.Path, SignedURLOperationGet); err != nil {
+		t.Errorf("Expected a valid token for the uploaded file, got %v", err)
+	}
+	if result.SignedURLExpiresAt == nil || time.Until(*result.SignedURLExpiresAt) > 10*time.Minute || time.Until(*result.SignedURLExpiresAt) < 9*time.Minute {
+		t.Errorf("Expected the URL to expire in 10 minutes, got %v", result.SignedURLExpiresAt)
+	}
+	if result.PublicURL != "https://cdn.example.com/"+result.Path {
+		t.Errorf("Expected the public URL too, got %q", result.PublicURL)
+	}
+
+	result, _ = storage.UploadFromUploadedFileWithOptions(ctx, writeTestVideo(t), "file", "clips", UploadOptions{})
+	if result.SignedURL != "" || result.SignedURLExpiresAt != nil {
+		t.Errorf("Expected no signed URL unless requested, got %q", result.SignedURL)
+	}
+
+	t.Run("Failure", func(t *testing.T) {
+		logger := &recordingLogger{}
+		storage, _ := New(&StorageConfig{
+			Name:      "test",
+			Provider:  "memory",
+			SignedURL: &SignedURLConfig{Enabled: true, SecretKey: "secret"},
+			Logger:    logger,
+		})
+		result, err := storage.UploadFromUploadedFileWithOptions(ctx, writeTestVideo(t), "file", "clips", UploadOptions{IncludeSignedURL: true})
+		if err != nil || result.SignedURL != "" || result.SignedURLExpiresAt != nil {
+			t.Fatalf("Expected the upload to succeed without a signed URL, got %v, %v", result, err)
+		}
+		if len(logger.messages) != 1 || logger.messages[0] != "failed to sign URL of uploaded file" {
+			t.Errorf("Expected a logged warning, got %v", logger.messages)
+		}
+	})
+}
+
+// slowUploadProvider holds each upload until its context is done or it is released, and
+// tracks how many run at once
+type slowUploadProvider struct {
+	StorageProvider
+
+	release               chan struct{}
+	inFlight, maxInFlight atomic.Int32
+}
+
+func (p *slowUploadProvider) Upload(ctx context.Context, path string, reader io.Reader, metadata *FileMetadata) (*FileInfo, error) {
+	current := p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+	for {
+		peak := p.maxInFlight.Load()
+		if current <= peak || p.maxInFlight.CompareAndSwap(peak, current) {
+			break
+		}
+	}
+	select {
+	case <-p.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return p.StorageProvider.Upload(ctx, path, reader, metadata)
+}
+
+func TestUploadConcurrency(t *testing.T) {
+	provider := &slowUploadProvider{release: make(chan struct{})}
+	RegisterProvider("slow-upload", func(config *StorageConfig) (StorageProvider, error) {
+		inner, err := NewMemoryProvider(config)
+		provider.StorageProvider = inner
+		return provider, err
+	})
+	storage, err := New(&StorageConfig{Name: "test", Provider: "slow-upload", UploadConcurrency: 2})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	newFile := func(name string) *rest.UploadedFile {
+		path := filepath.Join(tmpDir, name)
+		os.WriteFile(path, []byte(name), 0644)
+		return &rest.UploadedFile{Path: path, Filename: name, OriginalName: name, MimeType: "text/plain"}
+	}
+	request := map[string][]*rest.UploadedFile{
+		"b": {newFile("3.txt"), newFile("4.txt")},
+		"a": {newFile("1.txt"), newFile("2.txt")},
+		"c": {newFile("5.txt")},
+	}
+
+	t.Run("Ordered", func(t *testing.T) {
+		go func() {
+			for provider.inFlight.Load() < 2 {
+				time.Sleep(time.Millisecond)
+			}
+			for range 5 {
+				provider.release <- struct{}{}
+			}
+		}()
+		results, err := storage.uploadFiles(context.Background(), request, "ordered", UploadOptions{})
+		if err != nil || len(results) != 5 {
+			t.Fatalf("Expected 5 results, got %d, %v", len(results), err)
+		}
+		for i, result := range results {
+			if want := fmt.Sprintf("%d_", i+1); !strings.HasPrefix(result.Filename, want) {
+				t.Errorf("Result %d: expected %s*, got %s", i, want, result.Filename)
+			}
+		}
+		if peak := provider.maxInFlight.Load(); peak != 2 {
+			t.Errorf("Expected 2 uploads at once, got %d", peak)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			for provider.inFlight.Load() < 2 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+		}()
+		done := make(chan struct{})
+		var results []*UploadedFileResult
+		go func() {
+			defer close(done)
+			results, err = storage.uploadFiles(ctx, request, "cancelled", UploadOptions{})
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the upload to stop when the request is cancelled")
+		}
+		var uploadErr *UploadError
+		if !errors.As(err, &uploadErr) || len(uploadErr.Failures) != 5 || len(results) != 0 {
+			t.Fatalf("Expected every file to fail, got %v, %v", results, err)
+		}
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the cancellation as cause, got %v", err)
+		}
+	})
+}
+
+func TestUploadExactPath(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	newFile := func(name, content string) *rest.UploadedFile {
+		path := filepath.Join(tmpDir, name)
+		os.WriteFile(path, []byte(content), 0644)
+		return &rest.UploadedFile{Path: path, Filename: name, OriginalName: name, MimeType: "application/json"}
+	}
+
+	configs := map[string]*StorageConfig{
+		"filesystem": {Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir()}},
+		"memory":     {Name: "test", Provider: "memory", UploadLayout: LayoutDate},
+	}
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			// A name that already has the extension of the upload is not given it twice
+			result, err := storage.UploadFromUploadedFileWithOptions(ctx, newFile("latest.json", "1"), "file", "manifests/cam41", UploadOptions{Filename: "latest.json"})
+			if err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+			if result.Filename != "latest.json" || !strings.HasSuffix(result.Path, "/latest.json") {
+				t.Errorf("Expected no double extension, got %s", result.Path)
+			}
+
+			// The exact path skips the layout as well
+			result, err = storage.UploadFromUploadedFileToPath(ctx, newFile("upload.tmp", "2"), "file", "manifests/cam42/latest.json")
+			if err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+			if result.Path != "manifests/cam42/latest.json" || result.Filename != "latest.json" {
+				t.Errorf("Expected the exact path, got %s (%s)", result.Path, result.Filename)
+			}
+
+			// Replaced by default, refused with NoOverwrite
+			if _, err := storage.UploadFromUploadedFileToPath(ctx, newFile("latest.json", "3"), "file", "manifests/cam42/latest.json"); err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+			_, err = storage.UploadFromUploadedFileToPath(ctx, newFile("latest.json", "4"), "file", "manifests/cam42/latest.json", UploadOptions{NoOverwrite: true})
+			if !errors.Is(err, ErrFileAlreadyExists) {
+				t.Errorf("Expected ErrFileAlreadyExists, got %v", err)
+			}
+			reader, _, err := storage.Download(ctx, "manifests/cam42/latest.json")
+			if err != nil {
+				t.Fatalf("Download failed: %v", err)
+			}
+			content, _ := io.ReadAll(reader)
+			reader.Close()
+			if string(content) != "3" {
+				t.Errorf("Expected the existing file to be kept, got %q", content)
+			}
+
+			for _, invalid := range []string{"manifests/../../etc/latest.json", "manifests/cam42/", ""} {
+				if _, err := storage.UploadFromUploadedFileToPath(ctx, newFile("latest.json", "5"), "file", invalid); !errors.Is(err, ErrInvalidPath) {
+					t.Errorf("Expected %q to be refused, got %v", invalid, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+// Defaults of UploadMemoryConfig
+const (
+	DefaultUploadReservation   = 8 << 20 // 8 MiB
+	DefaultUploadRetryAfter    = 5 * time.Second
+	DefaultUploadRequestMemory = 1 << 20 // 1 MiB
+)
+
+// UploadMemoryConfig bounds the bytes of the uploads a storage handles at the same time,
+// so a burst of uploads is refused with a 503 instead of exhausting the memory of the
+// process. Each upload reserves its declared size, at most MaxBytes so a file larger than
+// the budget can still be stored alone, until it is done. Requests to UploadHandler
+// reserve the size of all their files at once, or their whole body before it is read
+// when UploadMemoryMiddleware comes first.
+type UploadMemoryConfig struct {
+	MaxBytes           int64         `json:"maxBytes"`                     // Bytes all uploads in progress may reserve together
+	DefaultReservation int64         `json:"defaultReservation,omitempty"` // Reserved for uploads of unknown size, defaults to 8 MiB
+	RetryAfter         time.Duration `json:"retryAfter,omitempty"`         // Sent in Retry-After when an upload is refused, defaults to 5s
+	RequestMemory      int64         `json:"requestMemory,omitempty"`      // Bytes of a multipart request kept in memory before spilling to disk, defaults to 1 MiB
+}
+
+// Validate validates the upload memory configuration
+func (c *UploadMemoryConfig) Validate() error {
+	if c.MaxBytes <= 0 {
+		return errors.New("upload memory budget must be positive")
+	}
+	if c.DefaultReservation < 0 || c.RetryAfter < 0 || c.RequestMemory < 0 {
+		return errors.New("upload memory reservation, retry after and request memory cannot be negative")
+	}
+	return nil
+}
+
+// reservation returns the bytes an upload of size reserves; negative sizes are unknown
+func (c *UploadMemoryConfig) reservation(size int64) int64 {
+	if size < 0 {
+		size = DefaultUploadReservation
+		if c.DefaultReservation > 0 {
+			size = c.DefaultReservation
+		}
+	}
+	return min(size, c.MaxBytes)
+}
+
+// retryAfter returns the seconds of the Retry-After of refused uploads
+func (c *UploadMemoryConfig) retryAfter() int {
+	retryAfter := DefaultUploadRetryAfter
+	if c.RetryAfter > 0 {
+		retryAfter = c.RetryAfter
+	}
+	return int((retryAfter + time.Second - 1) / time.Second)
+}
+
+// requestMemory returns the bytes of a multipart request kept in memory
+func (c *UploadMemoryConfig) requestMemory() int64 {
+	if c.RequestMemory > 0 {
+		return c.RequestMemory
+	}
+	return DefaultUploadRequestMemory
+}
+
+// uploadBudget tracks the bytes reserved by the uploads in progress. It is shared by the
+// views of a storage.
+type uploadBudget struct {
+	mu   sync.Mutex
+	used int64
+}
+
+// uploadReservedKey marks a context whose upload already holds a reservation, so the
+// files of a handler request are not counted twice
+type uploadReservedKey struct{}
+
+// reserveUploadMemory reserves the bytes of an upload of size, negative when unknown,
+// failing with ErrThrottled when the budget is exhausted. Uploads made with the returned
+// context reserve nothing more. release returns the bytes.
+func (s *Storage) reserveUploadMemory(ctx context.Context, size int64) (context.Context, func(), error) {
+	if s.uploadMemory == nil || ctx.Value(uploadReservedKey{}) != nil {
+		return ctx, func() {}, nil
+	}
+
+	config := s.config.UploadMemory
+	bytes := config.reservation(size)
+	s.uploadMemory.mu.Lock()
+	if s.uploadMemory.used+bytes > config.MaxBytes {
+		s.uploadMemory.mu.Unlock()
+		s.config.incCounter("storage_upload_memory_rejected_total", 1, map[string]string{"storage": s.config.Name})
+		return ctx, nil, NewStorageError(ErrorCodeThrottled, "too many uploads in progress, retry later")
+	}
+	s.uploadMemory.used += bytes
+	s.setUploadMemoryGauge(s.uploadMemory.used)
+	s.uploadMemory.mu.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			s.uploadMemory.mu.Lock()
+			defer s.uploadMemory.mu.Unlock()
+			s.uploadMemory.used -= bytes
+			s.setUploadMemoryGauge(s.uploadMemory.used)
+		})
+	}
+	return context.WithValue(ctx, uploadReservedKey{}, true), release, nil
+}
+
+// reserveReaderMemory reserves the bytes of an upload read from reader
+func (s *Storage) reserveReaderMemory(ctx context.Context, reader io.Reader) (context.Context, func(), error) {
+	size, ok := readerSize(reader)
+	if !ok {
+		size = -1
+	}
+	return s.reserveUploadMemory(ctx, size)
+}
+
+// UploadMemoryMiddleware admits multipart requests before their body is read, which is
+// where the memory of an upload goes. It reserves the Content-Length of the request, or
+// the default reservation when unknown, answering 503 with Retry-After when the budget is
+// exhausted, and parses the form keeping at most RequestMemory bytes in memory and the
+// rest in temporary files. Register it before the middleware of vsaas-rest that collects
+// the uploaded files, which reuses the parsed form; UploadHandler then reserves nothing
+// more. Without UploadMemoryConfig it passes every request through.
+func (s *Storage) UploadMemoryMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			request := c.Request()
+			if s.uploadMemory == nil || !strings.HasPrefix(request.Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+				return next(c)
+			}
+
+			ctx, release, err := s.reserveUploadMemory(request.Context(), request.ContentLength)
+			if err != nil {
+				if seconds := s.uploadRetryAfter(err); seconds > 0 {
+					c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+				}
+				return httpError(err, "Failed to upload files")
+			}
+			defer release()
+
+			request = request.WithContext(ctx)
+			c.SetRequest(request)
+			if err := request.ParseMultipartForm(s.config.UploadMemory.requestMemory()); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid multipart form")
+			}
+			// The server only removes the files of the request it passed to the handler
+			defer request.MultipartForm.RemoveAll()
+			return next(c)
+		}
+	}
+}
+
+// setUploadMemoryGauge reports the bytes reserved by the uploads in progress
+func (s *Storage) setUploadMemoryGauge(used int64) {
+	s.config.setGauge("storage_upload_memory_bytes", float64(used), map[string]string{"storage": s.config.Name})
+}
+
+// UploadMemoryUsage returns the bytes reserved by the uploads in progress, 0 without an
+// UploadMemoryConfig
+func (s *Storage) UploadMemoryUsage() int64 {
+	if s.uploadMemory == nil {
+		return 0
+	}
+	s.uploadMemory.mu.Lock()
+	defer s.uploadMemory.mu.Unlock()
+	return s.uploadMemory.used
+}
+
+// uploadRetryAfter returns the seconds after which an upload that failed with err may be
+// retried, or 0 when it was not refused because the budget was exhausted
+func (s *Storage) uploadRetryAfter(err error) int {
+	if s.config.UploadMemory == nil || !errors.Is(err, ErrThrottled) {
+		return 0
+	}
+	return s.config.UploadMemory.retryAfter()
+}
+
+// setRetryAfter tells the client of a handler when to retry an upload refused because
+// the budget was exhausted
+func (s *Storage) setRetryAfter(c *rest.EndpointContext, err error) {
+	if seconds := s.uploadRetryAfter(err); seconds > 0 {
+		c.EchoCtx.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+}
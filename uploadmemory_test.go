@@ -0,0 +1,165 @@
+package vsaasstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestUploadMemoryBudget(t *testing.T) {
+	ctx := context.Background()
+	metrics := &countingMetrics{}
+	storage, err := New(&StorageConfig{
+		Name:         "test",
+		Provider:     "memory",
+		Metrics:      metrics,
+		UploadMemory: &UploadMemoryConfig{MaxBytes: 1000, DefaultReservation: 800, RetryAfter: 1500 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	// An upload of unknown size holds the default reservation until it is done
+	pipeReader, pipeWriter := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := storage.Upload(ctx, "clips/stream.mp4", pipeReader, nil)
+		done <- err
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for storage.UploadMemoryUsage() != 800 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if usage := storage.UploadMemoryUsage(); usage != 800 {
+		t.Fatalf("Expected 800 bytes reserved, got %d", usage)
+	}
+
+	if _, err := storage.Upload(ctx, "clips/big.mp4", strings.NewReader(strings.Repeat("x", 300)), nil); !errors.Is(err, ErrThrottled) {
+		t.Errorf("Expected an upload over the budget to be refused, got %v", err)
+	}
+	if _, err := storage.Upload(ctx, "clips/small.mp4", strings.NewReader(strings.Repeat("x", 200)), nil); err != nil {
+		t.Errorf("Expected an upload within the budget to be stored, got %v", err)
+	}
+
+	t.Run("Handler", func(t *testing.T) {
+		source := filepath.Join(t.TempDir(), "upload.tmp")
+		os.WriteFile(source, []byte(strings.Repeat("x", 300)), 0644)
+		request := httptest.NewRequest(http.MethodPost, "/upload", nil)
+		recorder := httptest.NewRecorder()
+		c := &rest.EndpointContext{
+			EchoCtx:       echo.New().NewContext(request, recorder),
+			UploadedFiles: map[string][]*rest.UploadedFile{"file": {{Path: source, Filename: "a.mp4", OriginalName: "a.mp4", Size: 300}}},
+		}
+
+		err := storage.UploadHandler("uploads")(c)
+		var httpErr *echo.HTTPError
+		if !errors.As(err, &httpErr) || httpErr.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Expected a 503, got %v", err)
+		}
+		if got := recorder.Header().Get("Retry-After"); got != "2" {
+			t.Errorf("Expected Retry-After rounded up to 2 seconds, got %q", got)
+		}
+	})
+
+	pipeWriter.Write([]byte("clip"))
+	pipeWriter.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if usage := storage.UploadMemoryUsage(); usage != 0 {
+		t.Errorf("Expected the reservations to be released, got %d", usage)
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.counters["storage_upload_memory_rejected_total"] != 2 || metrics.gauges["storage_upload_memory_bytes"] != 0 {
+		t.Errorf("Expected the rejections and usage in the metrics, got %v and %v", metrics.counters, metrics.gauges)
+	}
+}
+
+func TestUploadMemoryConfig(t *testing.T) {
+	config := &UploadMemoryConfig{MaxBytes: 100}
+	if config.reservation(-1) != 100 || config.reservation(30) != 30 || config.reservation(500) != 100 {
+		t.Error("Expected reservations capped at the budget")
+	}
+	if config.retryAfter() != 5 {
+		t.Errorf("Expected the default Retry-After, got %d", config.retryAfter())
+	}
+	if (&UploadMemoryConfig{}).Validate() == nil {
+		t.Error("Expected a budget of 0 to be refused")
+	}
+}
+
+func TestUploadMemoryMiddleware(t *testing.T) {
+	storage, err := New(&StorageConfig{
+		Name:         "test",
+		Provider:     "memory",
+		UploadMemory: &UploadMemoryConfig{MaxBytes: 5000, RequestMemory: 100},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	newRequest := func() (echo.Context, *httptest.ResponseRecorder) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("file", "clip.mp4")
+		part.Write([]byte(strings.Repeat("x", 3000)))
+		form.Close()
+		request := httptest.NewRequest(http.MethodPost, "/upload", &body)
+		request.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+		recorder := httptest.NewRecorder()
+		return echo.New().NewContext(request, recorder), recorder
+	}
+
+	middleware := storage.UploadMemoryMiddleware()
+	first, _ := newRequest()
+	handled := false
+	err = middleware(func(c echo.Context) error {
+		handled = true
+		if usage := storage.UploadMemoryUsage(); usage != c.Request().ContentLength {
+			t.Errorf("Expected the body to be reserved before it is read, got %d of %d", usage, c.Request().ContentLength)
+		}
+		file, err := c.Request().MultipartForm.File["file"][0].Open()
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer file.Close()
+		if _, spilled := file.(*os.File); !spilled {
+			t.Error("Expected the file past RequestMemory to be spilled to disk")
+		}
+
+		// The request already holds its reservation
+		if _, release, err := storage.reserveUploadMemory(c.Request().Context(), 4000); err != nil {
+			t.Errorf("Expected the handler not to reserve again, got %v", err)
+		} else {
+			release()
+		}
+
+		second, recorder := newRequest()
+		err = middleware(func(echo.Context) error { return nil })(second)
+		var httpErr *echo.HTTPError
+		if !errors.As(err, &httpErr) || httpErr.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") != "5" {
+			t.Errorf("Expected a 503 with Retry-After over the budget, got %v", err)
+		}
+		return nil
+	})(first)
+	if err != nil || !handled {
+		t.Fatalf("Expected the request to be handled, got %v", err)
+	}
+	if usage := storage.UploadMemoryUsage(); usage != 0 {
+		t.Errorf("Expected the reservation to be released, got %d", usage)
+	}
+}
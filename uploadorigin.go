@@ -0,0 +1,75 @@
+package vsaasstorage
+
+import (
+	"context"
+	"time"
+)
+
+// Metadata keys under which UploadFromUploadedFile records where a stored file came from.
+// The names are stable, so external tooling can read them from the object metadata
+// (a sidecar on the filesystem, x-amz-meta-* user metadata on S3).
+const (
+	OriginalNameMetadataKey = "vsaas-original-name" // Name of the file as sent by the client
+	FieldNameMetadataKey    = "vsaas-field-name"    // Form field the file was sent in
+	UploadedAtMetadataKey   = "vsaas-uploaded-at"   // Upload time, RFC 3339 in UTC
+	UploadedByMetadataKey   = "vsaas-uploaded-by"   // Subject that uploaded the file, when known
+)
+
+type uploaderKey struct{}
+
+// WithUploader returns a context carrying the subject that uploads files, e.g. the user
+// authenticated by an Authorizer, recorded under UploadedByMetadataKey unless
+// UploadOptions.Uploader is set
+func WithUploader(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, uploaderKey{}, subject)
+}
+
+// UploaderFrom returns the uploader carried by ctx, or "" if there is none
+func UploaderFrom(ctx context.Context) string {
+	subject, _ := ctx.Value(uploaderKey{}).(string)
+	return subject
+}
+
+// UploadOrigin describes where a file stored by UploadFromUploadedFile came from
+type UploadOrigin struct {
+	OriginalName string     `json:"original_name"`
+	FieldName    string     `json:"field_name,omitempty"`
+	UploadedAt   *time.Time `json:"uploaded_at,omitempty"`
+	UploadedBy   string     `json:"uploaded_by,omitempty"`
+}
+
+// UploadOrigin returns the origin recorded in the metadata of the file, or nil for files
+// that were not stored from an upload
+func (f *FileInfo) UploadOrigin() *UploadOrigin {
+	if f == nil || f.Metadata[OriginalNameMetadataKey] == "" {
+		return nil
+	}
+	origin := &UploadOrigin{
+		OriginalName: f.Metadata[OriginalNameMetadataKey],
+		FieldName:    f.Metadata[FieldNameMetadataKey],
+		UploadedBy:   f.Metadata[UploadedByMetadataKey],
+	}
+	if uploadedAt, err := time.Parse(time.RFC3339, f.Metadata[UploadedAtMetadataKey]); err == nil {
+		origin.UploadedAt = &uploadedAt
+	}
+	return origin
+}
+
+// uploadOriginMetadata returns the metadata recording the origin of an uploaded file
+func uploadOriginMetadata(ctx context.Context, originalName, fieldName string, opts UploadOptions, now time.Time) map[string]string {
+	metadata := map[string]string{
+		OriginalNameMetadataKey: originalName,
+		UploadedAtMetadataKey:   now.UTC().Format(time.RFC3339),
+	}
+	if fieldName != "" {
+		metadata[FieldNameMetadataKey] = fieldName
+	}
+	uploader := opts.Uploader
+	if uploader == "" {
+		uploader = UploaderFrom(ctx)
+	}
+	if uploader != "" {
+		metadata[UploadedByMetadataKey] = uploader
+	}
+	return metadata
+}
@@ -0,0 +1,91 @@
+package vsaasstorage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	rest "github.com/xompass/vsaas-rest"
+)
+
+func TestUploadOrigin(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "upload.tmp")
+	os.WriteFile(source, []byte("clip"), 0644)
+	file := &rest.UploadedFile{Path: source, Filename: "upload.tmp.mp4", OriginalName: "Cámara 1 — entrada.mp4", MimeType: "video/mp4"}
+
+	configs := map[string]*StorageConfig{
+		"filesystem": {Name: "test", Provider: "filesystem", FileSystem: &FileSystemConfig{BasePath: t.TempDir()}},
+		"memory":     {Name: "test", Provider: "memory"},
+	}
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			storage, err := New(config)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			ctx := WithUploader(context.Background(), "user-42")
+
+			before := time.Now().Add(-time.Second)
+			result, err := storage.UploadFromUploadedFile(ctx, file, "video", "clips")
+			if err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+
+			info, err := storage.GetInfo(context.Background(), result.Path)
+			if err != nil {
+				t.Fatalf("GetInfo failed: %v", err)
+			}
+			origin := info.UploadOrigin()
+			if origin == nil || origin.OriginalName != file.OriginalName || origin.FieldName != "video" || origin.UploadedBy != "user-42" {
+				t.Fatalf("Expected the origin of the upload, got %+v", origin)
+			}
+			if origin.UploadedAt == nil || origin.UploadedAt.Before(before) || origin.UploadedAt.After(time.Now()) {
+				t.Errorf("Expected the upload time, got %v", origin.UploadedAt)
+			}
+
+			result, err = storage.UploadFromUploadedFileWithOptions(ctx, file, "video", "clips", UploadOptions{Uploader: "admin"})
+			if err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+			if info, _ := storage.GetInfo(context.Background(), result.Path); info.Metadata[UploadedByMetadataKey] != "admin" {
+				t.Errorf("Expected UploadOptions.Uploader to take precedence, got %v", info.Metadata)
+			}
+		})
+	}
+
+	t.Run("InfoHandler", func(t *testing.T) {
+		storage, _ := New(&StorageConfig{Name: "test", Provider: "memory"})
+		result, err := storage.UploadFromUploadedFile(context.Background(), file, "video", "clips")
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		storage.Upload(context.Background(), "clips/plain.mp4", strings.NewReader("clip"), nil)
+
+		info := func(path string) map[string]interface{} {
+			request := httptest.NewRequest(http.MethodGet, "/info?path="+path, nil)
+			recorder := httptest.NewRecorder()
+			c := &rest.EndpointContext{EchoCtx: echo.New().NewContext(request, recorder)}
+			if err := storage.InfoHandler()(c); err != nil {
+				t.Fatalf("InfoHandler failed: %v", err)
+			}
+			var body map[string]interface{}
+			json.Unmarshal(recorder.Body.Bytes(), &body)
+			return body
+		}
+
+		origin, ok := info(result.Path)["origin"].(map[string]interface{})
+		if !ok || origin["original_name"] != file.OriginalName || origin["field_name"] != "video" {
+			t.Errorf("Expected the origin in the response, got %v", origin)
+		}
+		if _, ok := info("clips/plain.mp4")["origin"]; ok {
+			t.Error("Expected no origin for a file that was not uploaded")
+		}
+	})
+}
@@ -0,0 +1,344 @@
+package vsaasstorage
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// versionsPrefix is the directory that holds previous versions of files
+const versionsPrefix = ".versions"
+
+// VersioningConfig contains configuration for object versioning
+type VersioningConfig struct {
+	Enabled     bool          `json:"enabled"`               // Keep the previous content when a file is overwritten
+	MaxVersions int           `json:"maxVersions,omitempty"` // Versions kept per file, 0 for unlimited
+	MaxAge      time.Duration `json:"maxAge,omitempty"`      // Age after which versions are pruned, 0 for unlimited
+}
+
+// VersioningProvider is implemented by providers that can keep the versions of files
+// themselves, such as S3 buckets with versioning enabled. While NativeVersioning reports
+// true, the versioning methods of Storage are served by the provider instead of the
+// .versions prefix. Version IDs are opaque and the entries of ListVersions carry theirs
+// in Metadata[VersionIDMetadataKey].
+type VersioningProvider interface {
+	// NativeVersioning reports whether the provider keeps previous versions on overwrite and delete
+	NativeVersioning() bool
+	// ListVersions returns the previous versions of a file and its delete markers, newest first
+	ListVersions(ctx context.Context, path string) ([]*FileInfo, error)
+	// DownloadVersion reads a previous version of a file
+	DownloadVersion(ctx context.Context, path, versionID string) (io.ReadCloser, *FileInfo, error)
+	// RestoreVersion copies a previous version over the current content
+	RestoreVersion(ctx context.Context, path, versionID string) error
+	// DeleteVersion permanently deletes a version or delete marker
+	DeleteVersion(ctx context.Context, path, versionID string) error
+}
+
+// Metadata keys of the entries returned by ListVersions
+const (
+	VersionIDMetadataKey    = "vsaas-version-id"    // ID to pass to DownloadVersion, RestoreVersion and DeleteVersion
+	DeleteMarkerMetadataKey = "vsaas-delete-marker" // "true" when the entry records a deletion rather than content
+)
+
+// versioningEnabled reports whether overwrites keep the previous version in the .versions
+// prefix. Providers with native versioning keep it themselves.
+func (s *Storage) versioningEnabled() bool {
+	if _, native := s.nativeVersioning(); native {
+		return false
+	}
+	return s.config.Versioning != nil && s.config.Versioning.Enabled
+}
+
+// nativeVersioning returns the provider when it keeps the versions of files itself
+func (s *Storage) nativeVersioning() (VersioningProvider, bool) {
+	provider, ok := providerAs[VersioningProvider](s.provider)
+	return provider, ok && provider.NativeVersioning()
+}
+
+// isDeleteMarker reports whether an entry of ListVersions records a deletion
+func isDeleteMarker(version *FileInfo) bool {
+	return version.Metadata[DeleteMarkerMetadataKey] == "true"
+}
+
+// isInternalPath reports whether the path belongs to the trash, the version history or
+// the scan quarantine
+func isInternalPath(p string) bool {
+	clean := cleanPath(p)
+	return isTrashPath(clean) || isQuarantinePath(clean) || isClaimPath(clean) || clean == versionsPrefix || strings.HasPrefix(clean, versionsPrefix+"/")
+}
+
+// versionsDir returns the directory holding the versions of a path
+func versionsDir(filePath string) string {
+	return path.Join(versionsPrefix, cleanPath(filePath))
+}
+
+// saveVersion moves the current content of a path into its history and returns the
+// version path, or "" if there was nothing to keep
+func (s *Storage) saveVersion(ctx context.Context, filePath string) (string, error) {
+	info, err := s.provider.GetInfo(ctx, filePath)
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	if info.IsDirectory {
+		return "", nil
+	}
+
+	etag := NormalizeETag(info.ETag)
+	if etag == "" {
+		if etag, err = s.computeETag(ctx, filePath); err != nil {
+			return "", err
+		}
+	}
+
+	versionID := s.config.now().UTC().Format(pathTimestampFormat) + "-" + etag
+	versionPath := path.Join(versionsDir(filePath), versionID)
+	if err := s.provider.Move(ctx, filePath, versionPath); err != nil {
+		return "", err
+	}
+
+	// Versions do not count against the quota, like the trash
+	if s.quota != nil {
+		if err := s.releaseQuota([]*FileInfo{info}); err != nil {
+			return versionPath, err
+		}
+	}
+
+	return versionPath, nil
+}
+
+// computeETag hashes the content of a file for providers that do not report an ETag
+func (s *Storage) computeETag(ctx context.Context, filePath string) (string, error) {
+	reader, _, err := s.provider.Download(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", NewStorageErrorWithCause(ErrorCodeDownloadFailed, "failed to hash file", err)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// ListVersions returns the previous versions of a file, newest first. The version ID
+// accepted by DownloadVersion, RestoreVersion and DeleteVersion is in
+// Metadata[VersionIDMetadataKey]. With native versioning the list also holds the delete
+// markers left by deletions, flagged with Metadata[DeleteMarkerMetadataKey].
+func (s *Storage) ListVersions(ctx context.Context, filePath string) ([]*FileInfo, error) {
+	if provider, native := s.nativeVersioning(); native {
+		return provider.ListVersions(ctx, s.extensionPath(filePath))
+	}
+
+	versions, err := s.provider.List(ctx, versionsDir(filePath))
+	if err != nil {
+		if errors.Is(err, ErrDirectoryNotFound) {
+			return []*FileInfo{}, nil
+		}
+		return nil, err
+	}
+
+	files := versions[:0]
+	for _, version := range versions {
+		if !version.IsDirectory {
+			version.Metadata = withMetadata(version.Metadata, VersionIDMetadataKey, version.Name)
+			files = append(files, version)
+		}
+	}
+
+	// Version IDs start with a sortable timestamp
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name > files[j].Name
+	})
+
+	return files, nil
+}
+
+// withMetadata returns a copy of metadata with key set to value
+func withMetadata(metadata map[string]string, key, value string) map[string]string {
+	copied := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		copied[k] = v
+	}
+	copied[key] = value
+	return copied
+}
+
+// versionPath returns the path in the .versions prefix of a version of a file
+func versionPath(filePath, versionID string) (string, error) {
+	if versionID == "" || strings.Contains(versionID, "/") {
+		return "", NewStorageErrorWithPath(ErrorCodeInvalidPath, "invalid version ID", versionID)
+	}
+	return path.Join(versionsDir(filePath), versionID), nil
+}
+
+// DownloadVersion reads a previous version of a file
+func (s *Storage) DownloadVersion(ctx context.Context, filePath, versionID string) (io.ReadCloser, *FileInfo, error) {
+	if provider, native := s.nativeVersioning(); native {
+		if versionID == "" {
+			return nil, nil, NewStorageErrorWithPath(ErrorCodeInvalidPath, "invalid version ID", versionID)
+		}
+		return provider.DownloadVersion(ctx, s.extensionPath(filePath), versionID)
+	}
+
+	versionPath, err := versionPath(filePath, versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.provider.Download(ctx, versionPath)
+}
+
+// DeleteVersion permanently deletes a previous version of a file. With native versioning,
+// deleting the newest delete marker brings the file back.
+func (s *Storage) DeleteVersion(ctx context.Context, filePath, versionID string) error {
+	if err := s.checkWritable(filePath); err != nil {
+		return err
+	}
+
+	if provider, native := s.nativeVersioning(); native {
+		if versionID == "" {
+			return NewStorageErrorWithPath(ErrorCodeInvalidPath, "invalid version ID", versionID)
+		}
+		return provider.DeleteVersion(ctx, s.extensionPath(filePath), versionID)
+	}
+
+	versionPath, err := versionPath(filePath, versionID)
+	if err != nil {
+		return err
+	}
+	return s.provider.Delete(ctx, versionPath)
+}
+
+// RestoreVersion makes a previous version the current content of a file. The content
+// being replaced is kept as a new version, so a restore can itself be undone.
+func (s *Storage) RestoreVersion(ctx context.Context, filePath, versionID string) error {
+	return s.audited(ctx, AuditEntry{Operation: AuditOperationRestoreVersion, Path: filePath, VersionID: versionID}, func(*AuditEntry) error {
+		return s.restoreVersion(ctx, filePath, versionID)
+	})
+}
+
+// restoreVersion implements RestoreVersion
+func (s *Storage) restoreVersion(ctx context.Context, filePath, versionID string) error {
+	if err := s.checkWritable(filePath); err != nil {
+		return err
+	}
+
+	if provider, native := s.nativeVersioning(); native {
+		if versionID == "" {
+			return NewStorageErrorWithPath(ErrorCodeInvalidPath, "invalid version ID", versionID)
+		}
+		filePath = s.extensionPath(filePath)
+		if err := provider.RestoreVersion(ctx, filePath, versionID); err != nil {
+			return err
+		}
+		s.journalCurrent(ctx, JournalOperationUpload, filePath, "")
+		s.invalidateDerived(ctx, filePath, false)
+		return nil
+	}
+
+	versionPath, err := versionPath(filePath, versionID)
+	if err != nil {
+		return err
+	}
+	exists, err := s.provider.Exists(ctx, versionPath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return FileNotFoundError(versionPath)
+	}
+
+	if _, err := s.saveVersion(ctx, filePath); err != nil {
+		return err
+	}
+
+	return s.Copy(ctx, versionPath, filePath)
+}
+
+// pruneVersions deletes the versions of a file that exceed MaxVersions or MaxAge
+func (s *Storage) pruneVersions(ctx context.Context, filePath string) error {
+	policy := s.config.Versioning
+	if policy.MaxVersions <= 0 && policy.MaxAge <= 0 {
+		return nil
+	}
+
+	versions, err := s.ListVersions(ctx, filePath)
+	if err != nil {
+		return err
+	}
+
+	cutoff := s.config.now().UTC().Add(-policy.MaxAge)
+	for i, version := range versions {
+		expired := false
+		if policy.MaxVersions > 0 && i >= policy.MaxVersions {
+			expired = true
+		}
+		if policy.MaxAge > 0 {
+			timestamp, _, _ := strings.Cut(version.Name, "-")
+			if createdAt, err := time.Parse(pathTimestampFormat, timestamp); err == nil && createdAt.Before(cutoff) {
+				expired = true
+			}
+		}
+
+		if !expired {
+			continue
+		}
+		if err := s.provider.Delete(ctx, version.Path); err != nil && !errors.Is(err, ErrFileNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// purgeVersions deletes the whole version history under a path. Native histories are
+// deleted version by version, for filePath and each of files.
+func (s *Storage) purgeVersions(ctx context.Context, filePath string, files []*FileInfo) error {
+	if provider, native := s.nativeVersioning(); native {
+		paths := []string{filePath}
+		for _, file := range files {
+			if !file.IsDirectory {
+				paths = append(paths, file.Path)
+			}
+		}
+		for _, p := range paths {
+			if err := purgeNativeVersions(ctx, provider, s.extensionPath(p)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !s.versioningEnabled() {
+		return nil
+	}
+
+	err := s.provider.DeleteDirectory(ctx, versionsDir(filePath))
+	if err != nil && !errors.Is(err, ErrDirectoryNotFound) {
+		return err
+	}
+	return nil
+}
+
+// purgeNativeVersions deletes every previous version and delete marker of a file
+func purgeNativeVersions(ctx context.Context, provider VersioningProvider, filePath string) error {
+	versions, err := provider.ListVersions(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		err := provider.DeleteVersion(ctx, filePath, version.Metadata[VersionIDMetadataKey])
+		if err != nil && !errors.Is(err, ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
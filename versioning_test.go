@@ -0,0 +1,106 @@
+package vsaasstorage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestVersioning(t *testing.T) {
+	ctx := context.Background()
+
+	newStorage := func(t *testing.T, versioning *VersioningConfig) *Storage {
+		storage, err := New(&StorageConfig{
+			Name:     "VersionedStorage",
+			Provider: "filesystem",
+			FileSystem: &FileSystemConfig{
+				BasePath: t.TempDir(),
+			},
+			Versioning: versioning,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		return storage
+	}
+
+	read := func(t *testing.T, storage *Storage, path string) string {
+		reader, _, err := storage.Download(ctx, path)
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		defer reader.Close()
+		content, _ := io.ReadAll(reader)
+		return string(content)
+	}
+
+	t.Run("History and restore", func(t *testing.T) {
+		storage := newStorage(t, &VersioningConfig{Enabled: true})
+		for _, content := range []string{"v1", "v2", "v3"} {
+			if _, err := storage.Upload(ctx, "site/floorplan.png", strings.NewReader(content), nil); err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+		}
+
+		versions, err := storage.ListVersions(ctx, "site/floorplan.png")
+		if err != nil {
+			t.Fatalf("ListVersions failed: %v", err)
+		}
+		if len(versions) != 2 {
+			t.Fatalf("Expected 2 versions, got %d", len(versions))
+		}
+
+		// Newest first: versions[1] holds v1
+		if err := storage.RestoreVersion(ctx, "site/floorplan.png", versions[1].Name); err != nil {
+			t.Fatalf("RestoreVersion failed: %v", err)
+		}
+		if content := read(t, storage, "site/floorplan.png"); content != "v1" {
+			t.Errorf("Expected restored content 'v1', got '%s'", content)
+		}
+
+		versions, _ = storage.ListVersions(ctx, "site/floorplan.png")
+		if len(versions) != 3 {
+			t.Errorf("Expected the replaced content to be kept, got %d versions", len(versions))
+		}
+
+		root, _ := storage.List(ctx, "")
+		for _, entry := range root {
+			if entry.Name == versionsPrefix {
+				t.Error("Expected version history to be hidden from the root listing")
+			}
+		}
+	})
+
+	t.Run("MaxVersions", func(t *testing.T) {
+		storage := newStorage(t, &VersioningConfig{Enabled: true, MaxVersions: 2})
+		for _, content := range []string{"v1", "v2", "v3", "v4"} {
+			storage.Upload(ctx, "config.json", strings.NewReader(content), nil)
+		}
+
+		versions, _ := storage.ListVersions(ctx, "config.json")
+		if len(versions) != 2 {
+			t.Fatalf("Expected 2 versions kept, got %d", len(versions))
+		}
+	})
+
+	t.Run("Delete keeps or purges versions", func(t *testing.T) {
+		storage := newStorage(t, &VersioningConfig{Enabled: true})
+		storage.Upload(ctx, "a.txt", strings.NewReader("v1"), nil)
+		storage.Upload(ctx, "a.txt", strings.NewReader("v2"), nil)
+
+		storage.Delete(ctx, "a.txt")
+		if versions, _ := storage.ListVersions(ctx, "a.txt"); len(versions) != 1 {
+			t.Errorf("Expected versions to survive a soft delete, got %d", len(versions))
+		}
+
+		storage.Upload(ctx, "b.txt", strings.NewReader("v1"), nil)
+		storage.Upload(ctx, "b.txt", strings.NewReader("v2"), nil)
+		if err := storage.Delete(ctx, "b.txt", DeleteOptions{PurgeVersions: true}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if versions, _ := storage.ListVersions(ctx, "b.txt"); len(versions) != 0 {
+			t.Errorf("Expected versions to be purged, got %d", len(versions))
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package vsaasstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+// SkipDir can be returned by a WalkFunc to skip the contents of a directory
+var SkipDir = errors.New("skip this directory")
+
+// WalkFunc is called for every file and directory visited by Walk
+type WalkFunc func(info *FileInfo) error
+
+// Walk visits every file and directory under root in lexical order, calling fn for each.
+// Directories are visited before their contents. Returning SkipDir from fn for a directory
+// skips its contents; any other error stops the walk and is returned. Hidden entries are
+// skipped like in List. Directories are read with Iterate, so a directory of any size
+// is walked with a bounded number of entries in memory on providers with
+// IteratingProvider.
+func (s *Storage) Walk(ctx context.Context, root string, fn WalkFunc) error {
+	return s.walk(ctx, root, ListOptions{}, fn)
+}
+
+// WalkWithOptions is Walk listing each directory with the given options, e.g. to visit
+// hidden entries
+func (s *Storage) WalkWithOptions(ctx context.Context, root string, opts ListOptions, fn WalkFunc) error {
+	return s.walk(ctx, root, opts, fn)
+}
+
+// walk implements Walk using the given listing options
+func (s *Storage) walk(ctx context.Context, root string, opts ListOptions, fn WalkFunc) error {
+	if err := checkContext(ctx, root); err != nil {
+		return err
+	}
+
+	// Parents stay open while their subdirectories are walked, so a deep tree holds a
+	// batch of entries per level rather than every listing
+	entries := s.Iterate(ctx, root, opts)
+	defer entries.Close()
+
+	for {
+		entry, err := entries.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = fn(entry)
+		if entry.IsDirectory {
+			if errors.Is(err, SkipDir) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := s.walk(ctx, entry.Path, opts, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err != nil && !errors.Is(err, SkipDir) {
+			return err
+		}
+	}
+}
+
+// cleanPath returns the path in its relative form, without leading slash
+func cleanPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// isWithinPrefix reports whether p is prefix itself or below it. The root contains every path.
+func isWithinPrefix(p, prefix string) bool {
+	clean, prefix := cleanPath(p), cleanPath(prefix)
+	return prefix == "" || clean == prefix || strings.HasPrefix(clean, prefix+"/")
+}
+
+// isRootPath reports whether the path refers to the storage root
+func isRootPath(p string) bool {
+	return cleanPath(p) == ""
+}